@@ -0,0 +1,136 @@
+// Package main describes FlashPaper's commands in one table, so the usage
+// text, the shell completion scripts, and the man page can't drift apart
+// from what the binary accepts. Each command's own flags are defined by a
+// function both the command and the generators call.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// command describes a subcommand of flashpaper.
+type command struct {
+	Name    string
+	Args    string // Synopsis after the name, for usage and the man page
+	Summary string
+
+	// Subcommands are the words accepted after the name
+	Subcommands []string
+
+	// Flags defines the command's own flags on a flag set
+	Flags func(fs *flag.FlagSet)
+}
+
+// commands lists flashpaper's commands in the order usage shows them.
+var commands = []command{
+	{Name: "serve", Summary: "Run the server (the default)"},
+	{Name: "purge", Summary: "Delete all expired pastes, then exit"},
+	{
+		Name: "migrate", Args: "-from X -to Y",
+		Summary: "Copy all data between storage backends",
+		Flags:   func(fs *flag.FlagSet) { migrateFlags(fs) },
+	},
+	{
+		Name: "export", Args: "[-o file.tar[.gz]]",
+		Summary: "Write all stored data to a portable archive",
+		Flags:   func(fs *flag.FlagSet) { exportFlags(fs) },
+	},
+	{
+		Name: "import", Args: "archive|privatebin-dir",
+		Summary: "Load an archive, or a PrivateBin data directory",
+	},
+	{
+		Name: "import-privatebin", Args: "-dsn X [-driver mysql] [-prefix Y]",
+		Summary: "Copy pastes and comments from a PrivateBin database",
+		Flags:   func(fs *flag.FlagSet) { importPrivateBinFlags(fs) },
+	},
+	{
+		Name: "schema", Args: "status|upgrade",
+		Summary:     "Show or upgrade the database schema version",
+		Subcommands: []string{"status", "upgrade"},
+	},
+	{
+		Name: "config", Args: "check",
+		Summary:     "Validate the configuration file, then exit",
+		Subcommands: []string{"check"},
+	},
+	{
+		Name: "admin", Args: "token [-ttl 15m]",
+		Summary:     "Print a signed admin token",
+		Subcommands: []string{"token"},
+		Flags:       func(fs *flag.FlagSet) { adminTokenFlags(fs) },
+	},
+	{
+		Name: "completion", Args: "bash|zsh|fish",
+		Summary:     "Print a shell completion script",
+		Subcommands: []string{"bash", "zsh", "fish"},
+	},
+	{
+		Name: "docs", Args: "man",
+		Summary:     "Print the man page (roff)",
+		Subcommands: []string{"man"},
+	},
+}
+
+// migrateFlags defines the flags of "migrate" on fs.
+func migrateFlags(fs *flag.FlagSet) (from, to *string) {
+	from = fs.String("from", "", "Source backend: filesystem[:dir], sqlite3|postgres|mysql[:dsn], s3[:bucket], or blob:<url>")
+	to = fs.String("to", "", "Destination backend, in the same form")
+	return from, to
+}
+
+// exportFlags defines the flags of "export" on fs.
+func exportFlags(fs *flag.FlagSet) (output *string) {
+	return fs.String("o", "-", "Archive to write (- = standard output); gzipped if named .gz or .tgz")
+}
+
+// importPrivateBinFlags defines the flags of "import-privatebin" on fs.
+func importPrivateBinFlags(fs *flag.FlagSet) (driver, dsn, prefix *string) {
+	driver = fs.String("driver", "mysql", "PrivateBin's database driver: mysql, postgres, or sqlite3")
+	dsn = fs.String("dsn", "", "PrivateBin's database, in the driver's DSN form")
+	prefix = fs.String("prefix", "", "PrivateBin's table prefix ([model_options] tbl), e.g. privatebin_")
+	return driver, dsn, prefix
+}
+
+// adminTokenFlags defines the flags of "admin token" on fs.
+func adminTokenFlags(fs *flag.FlagSet) (ttl *time.Duration) {
+	return fs.Duration("ttl", 15*time.Minute, "How long the token is valid (at most 24h)")
+}
+
+// usage returns the text printed by -h and for unknown commands, before
+// the global flags.
+func usage() string {
+	var b strings.Builder
+	b.WriteString("Usage: flashpaper [flags] [command]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "  %-28s%s\n", strings.TrimSpace(c.Name+" "+c.Args), c.Summary)
+	}
+	b.WriteString("\nFlags:\n")
+	return b.String()
+}
+
+// flagsOf returns the flags fs defines, in lexical order. A nil define
+// has none.
+func flagsOf(define func(fs *flag.FlagSet)) []*flag.Flag {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	if define != nil {
+		define(fs)
+	}
+	return visitFlags(fs)
+}
+
+// visitFlags returns the flags defined on fs, in lexical order.
+func visitFlags(fs *flag.FlagSet) []*flag.Flag {
+	var flags []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) { flags = append(flags, f) })
+	return flags
+}
+
+// isBoolFlag reports whether f takes no value on the command line.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
@@ -0,0 +1,274 @@
+// Package main provides "flashpaper completion bash|zsh|fish" and
+// "flashpaper docs man", which print shell completion scripts and a man
+// page generated from the command table and the flags the binary defines.
+// Packagers install them at build time; operators can also load completion
+// straight from the binary, e.g. source <(flashpaper completion bash).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/liskl/flashpaper/internal/version"
+)
+
+// runCompletion handles "flashpaper completion bash|zsh|fish".
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		fatal("Usage: flashpaper completion bash|zsh|fish")
+	}
+	globals := visitFlags(flag.CommandLine)
+	switch args[0] {
+	case "bash":
+		writeBashCompletion(os.Stdout, globals)
+	case "zsh":
+		writeZshCompletion(os.Stdout, globals)
+	case "fish":
+		writeFishCompletion(os.Stdout, globals)
+	default:
+		fatal("Unsupported shell", "shell", args[0])
+	}
+}
+
+// runDocs handles "flashpaper docs man".
+func runDocs(args []string) {
+	if len(args) != 1 || args[0] != "man" {
+		fatal("Usage: flashpaper docs man")
+	}
+	writeManPage(os.Stdout, visitFlags(flag.CommandLine))
+}
+
+// flagWords returns the flags as they are typed, e.g. "-config".
+func flagWords(flags []*flag.Flag) []string {
+	words := make([]string, 0, len(flags))
+	for _, f := range flags {
+		words = append(words, "-"+f.Name)
+	}
+	return words
+}
+
+// writeBashCompletion writes a bash completion script. The first word
+// that isn't a flag or a flag's value is the command.
+func writeBashCompletion(w io.Writer, globals []*flag.Flag) {
+	// Words after these are values: files for -config, nothing to offer
+	// for the others
+	var valued, skipped []string
+	for _, f := range globals {
+		if !isBoolFlag(f) {
+			skipped = append(skipped, "-"+f.Name)
+		}
+	}
+	valued = append(valued, skipped...)
+	for _, c := range commands {
+		for _, f := range flagsOf(c.Flags) {
+			if !isBoolFlag(f) {
+				valued = append(valued, "-"+f.Name)
+			}
+		}
+	}
+	words := flagWords(globals)
+	for _, c := range commands {
+		words = append(words, c.Name)
+	}
+
+	fmt.Fprintf(w, `# bash completion for flashpaper
+_flashpaper() {
+	local cur prev cmd="" i
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]}"
+	case "$prev" in
+	-config)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	%s)
+		return
+		;;
+	esac
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case "${COMP_WORDS[i]}" in
+		%s) ((i++)) ;;
+		-*) ;;
+		*) cmd="${COMP_WORDS[i]}"; break ;;
+		esac
+	done
+	case "$cmd" in
+	"")
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		;;
+`, strings.Join(valued, "|"), strings.Join(skipped, "|"), strings.Join(words, " "))
+	for _, c := range commands {
+		words := append(append([]string{}, c.Subcommands...), flagWords(flagsOf(c.Flags))...)
+		if len(words) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t%s)\n\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\t;;\n", c.Name, strings.Join(words, " "))
+	}
+	fmt.Fprint(w, `	esac
+}
+complete -F _flashpaper flashpaper
+`)
+}
+
+// writeZshCompletion writes a zsh completion script.
+func writeZshCompletion(w io.Writer, globals []*flag.Flag) {
+	fmt.Fprint(w, "#compdef flashpaper\n\n_flashpaper() {\n\tlocal -a commands\n\tcommands=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "\t\t%s\n", zshQuote(c.Name+":"+c.Summary))
+	}
+	fmt.Fprint(w, "\t)\n\n\tlocal curcontext=\"$curcontext\" state line\n\t_arguments -C \\\n")
+	for _, f := range globals {
+		fmt.Fprintf(w, "\t\t%s \\\n", zshFlagSpec(f))
+	}
+	fmt.Fprint(w, "\t\t'1: :->command' \\\n\t\t'*:: :->args'\n\n\tcase $state in\n")
+	fmt.Fprint(w, "\tcommand)\n\t\t_describe 'command' commands\n\t\t;;\n\targs)\n\t\tcase $words[1] in\n")
+	for _, c := range commands {
+		flags := flagsOf(c.Flags)
+		if len(c.Subcommands) == 0 && len(flags) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\t\t%s)\n\t\t\t_arguments", c.Name)
+		if len(c.Subcommands) > 0 {
+			fmt.Fprintf(w, " '1:subcommand:(%s)'", strings.Join(c.Subcommands, " "))
+		}
+		for _, f := range flags {
+			fmt.Fprintf(w, " %s", zshFlagSpec(f))
+		}
+		fmt.Fprint(w, "\n\t\t\t;;\n")
+	}
+	fmt.Fprint(w, "\t\tesac\n\t\t;;\n\tesac\n}\n\n_flashpaper \"$@\"\n")
+}
+
+// zshFlagSpec returns the _arguments spec of f.
+func zshFlagSpec(f *flag.Flag) string {
+	spec := "-" + f.Name + "[" + strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`).Replace(f.Usage) + "]"
+	if !isBoolFlag(f) {
+		action := ""
+		if f.Name == "config" {
+			action = "_files"
+		}
+		spec += ":" + f.Name + ":" + action
+	}
+	return zshQuote(spec)
+}
+
+// zshQuote single-quotes s for zsh.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// writeFishCompletion writes a fish completion script. Go flags are
+// single-dash long options, fish's "old-style" options (-o).
+func writeFishCompletion(w io.Writer, globals []*flag.Flag) {
+	fmt.Fprint(w, "# fish completion for flashpaper\ncomplete -c flashpaper -f\n")
+	for _, f := range globals {
+		fmt.Fprintf(w, "complete -c flashpaper -n __fish_use_subcommand%s\n", fishFlag(f))
+	}
+	for _, c := range commands {
+		fmt.Fprintf(w, "complete -c flashpaper -n __fish_use_subcommand -a %s -d %s\n", c.Name, fishQuote(c.Summary))
+	}
+	for _, c := range commands {
+		seen := " -n " + fishQuote("__fish_seen_subcommand_from "+c.Name)
+		if len(c.Subcommands) > 0 {
+			fmt.Fprintf(w, "complete -c flashpaper%s -a %s\n", seen, fishQuote(strings.Join(c.Subcommands, " ")))
+		}
+		for _, f := range flagsOf(c.Flags) {
+			fmt.Fprintf(w, "complete -c flashpaper%s%s\n", seen, fishFlag(f))
+		}
+	}
+}
+
+// fishFlag returns the complete options describing f.
+func fishFlag(f *flag.Flag) string {
+	opts := " -o " + f.Name
+	if !isBoolFlag(f) {
+		opts += " -r"
+		if f.Name == "config" {
+			opts += " -F"
+		}
+	}
+	return opts + " -d " + fishQuote(f.Usage)
+}
+
+// fishQuote single-quotes s for fish.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// writeManPage writes the flashpaper(1) man page in roff.
+func writeManPage(w io.Writer, globals []*flag.Flag) {
+	fmt.Fprintf(w, ".TH FLASHPAPER 1 \"\" %q \"FlashPaper Manual\"\n", "FlashPaper "+version.Version)
+	fmt.Fprint(w, `.SH NAME
+flashpaper \- zero-knowledge encrypted pastebin server
+.SH SYNOPSIS
+.B flashpaper
+[\fIflags\fR] [\fIcommand\fR]
+.SH DESCRIPTION
+FlashPaper is a PrivateBin-compatible pastebin. Pastes are encrypted and
+decrypted in the browser; the server only ever stores ciphertext.
+Without a command, it runs the server.
+.SH COMMANDS
+`)
+	for _, c := range commands {
+		fmt.Fprintf(w, ".TP\n.B %s\n%s\n", roff(strings.TrimSpace(c.Name+" "+c.Args)), roff(c.Summary))
+		if flags := flagsOf(c.Flags); len(flags) > 0 {
+			fmt.Fprint(w, ".RS\n")
+			for _, f := range flags {
+				fmt.Fprintf(w, ".TP\n%s\n", roffFlag(f))
+			}
+			fmt.Fprint(w, ".RE\n")
+		}
+	}
+	fmt.Fprint(w, ".SH OPTIONS\n")
+	for _, f := range globals {
+		fmt.Fprintf(w, ".TP\n%s\n", roffFlag(f))
+	}
+	fmt.Fprint(w, `.SH ENVIRONMENT
+Every setting of the configuration file can be overridden with an
+environment variable named after its section and key, e.g.
+.B FLASHPAPER_MAIN_SIZELIMIT
+for
+.B sizelimit
+in
+.BR [main] .
+.SH SIGNALS
+.TP
+.B SIGHUP
+Reload the configuration; settings that need a restart are logged.
+.TP
+.BR SIGINT ", " SIGTERM
+Finish in-flight requests and shut down.
+.SH FILES
+.TP
+.I config.ini
+Default configuration file; see \fBconfig.sample.ini\fR for every setting.
+`)
+}
+
+// roffFlag returns the tag line and description of f for a .TP entry.
+func roffFlag(f *flag.Flag) string {
+	tag := `\fB\-` + roff(f.Name) + `\fR`
+	if !isBoolFlag(f) {
+		name, _ := flag.UnquoteUsage(f)
+		tag += ` \fI` + roff(name) + `\fR`
+	}
+	desc := roff(f.Usage)
+	if f.DefValue != "" && f.DefValue != "0" && f.DefValue != "false" {
+		desc += " (default " + roff(f.DefValue) + ")"
+	}
+	return tag + "\n" + desc
+}
+
+// roff escapes s for roff text: backslashes, hyphens (which would
+// otherwise be typeset as hyphens, not minus signs), and control
+// characters at the start of a line.
+func roff(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
@@ -8,67 +8,169 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/logging"
 	"github.com/liskl/flashpaper/internal/server"
 	"github.com/liskl/flashpaper/internal/storage"
-)
-
-// Version information set at build time via ldflags:
-// go build -ldflags "-X main.version=1.0.0 -X main.commit=abc123"
-var (
-	version = "dev"
-	commit  = "none"
+	"github.com/liskl/flashpaper/internal/util"
+	"github.com/liskl/flashpaper/internal/version"
 )
 
 func main() {
 	// Parse command-line flags
 	configPath := flag.String("config", "config.ini", "Path to configuration file")
 	showVersion := flag.Bool("version", false, "Show version information")
+	compact := flag.Bool("compact", false, "Repack small Filesystem pastes into per-shard containers, then exit")
+	compactMaxSize := flag.Int64("compact-max-size", 64*1024, "Largest paste file in bytes that -compact packs (0 = any size)")
+	pin := flag.String("pin", "", "Pin the paste with this ID so it never expires or is purged, then exit")
+	unpin := flag.String("unpin", "", "Unpin the paste with this ID, restoring its expiration, then exit")
+	noMigrate := flag.Bool("no-migrate", false, "Don't apply database schema migrations at startup; fail if the schema is behind")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage())
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	// Handle version flag
 	if *showVersion {
-		fmt.Printf("FlashPaper %s (commit: %s)\n", version, commit)
+		fmt.Printf("FlashPaper %s (commit: %s)\n", version.Version, version.Commit)
 		os.Exit(0)
 	}
 
+	command, args := flag.Arg(0), flag.Args()
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	switch command {
+	case "", "serve", "purge", "migrate", "export", "import", "import-privatebin", "admin", "schema":
+	case "config":
+		runConfigCheck(*configPath, args)
+		return
+	case "completion":
+		runCompletion(args)
+		return
+	case "docs":
+		runDocs(args)
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	// Load configuration from INI file and environment variables
 	// Environment variables override file settings (12-factor app pattern)
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Failed to load configuration", "error", err)
+	}
+
+	// Structured logs from here on, in the configured level and format.
+	// The log package writes through the same handler.
+	logger, err := logging.New(os.Stderr, cfg.Main.LogLevel, cfg.Main.LogFormat)
+	if err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	for _, warning := range cfg.Warnings {
+		slog.Warn("Config warning", "warning", warning)
+	}
+
+	// Migration opens its own backends
+	if command == "migrate" {
+		runMigrate(cfg, args)
+		return
+	}
+
+	// The schema command migrates, or doesn't, itself
+	if *noMigrate || command == "schema" {
+		cfg.Model.NoMigrate = true
 	}
 
 	// Initialize the storage backend based on configuration
 	// Supports: sqlite, postgres, mysql, filesystem
 	store, err := storage.New(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+		fatal("Failed to initialize storage", "error", err)
+	}
+	if command == "schema" {
+		runSchema(store, args)
+		return
+	}
+	if cfg.Model.NoMigrate {
+		if err := storage.CheckSchema(context.Background(), store); err != nil {
+			fatal("Database schema is out of date", "error", err)
+		}
+	}
+	// Mirror everything to a backend under evaluation
+	if cfg.Model.Shadow != "" {
+		store = openShadow(cfg, store)
 	}
 	defer store.Close()
 
+	// Maintenance mode: compact and exit without serving
+	if *compact {
+		runCompact(store, *compactMaxSize)
+		return
+	}
+	if *pin != "" || *unpin != "" {
+		runPin(store, *pin, *unpin)
+		return
+	}
+
+	switch command {
+	case "purge":
+		runPurge(cfg, store)
+	case "export":
+		runExport(store, args)
+	case "import":
+		runImport(store, args)
+	case "import-privatebin":
+		runImportPrivateBin(store, args)
+	case "admin":
+		runAdmin(cfg, store, args)
+	default:
+		runServe(cfg, store, *configPath)
+	}
+}
+
+// runServe starts the server and blocks until SIGINT or SIGTERM.
+// SIGHUP reloads the configuration from configPath (see reload).
+func runServe(cfg *config.Config, store storage.Storage, configPath string) {
+	// Let the backend prepare statements and caches before taking traffic
+	warmupCtx, cancelWarmup := context.WithTimeout(context.Background(), 30*time.Second)
+	err := storage.Warmup(warmupCtx, store)
+	cancelWarmup()
+	if err != nil {
+		fatal("Failed to warm up storage", "error", err)
+	}
+
 	// Create and configure the HTTP server
 	// The server handles all PrivateBin-compatible API endpoints
 	srv, err := server.New(cfg, store)
 	if err != nil {
-		log.Fatalf("Failed to create server: %v", err)
+		fatal("Failed to create server", "error", err)
 	}
 
 	// Start the server in a goroutine so we can handle shutdown gracefully
 	go func() {
 		addr := fmt.Sprintf("%s:%d", cfg.Main.Host, cfg.Main.Port)
-		log.Printf("FlashPaper %s starting on %s", version, addr)
+		slog.Info("FlashPaper starting", "version", version.Version, "addr", addr)
 		if err := srv.ListenAndServe(); err != nil {
-			log.Printf("Server error: %v", err)
+			slog.Error("Server error", "error", err)
 		}
 	}()
 
@@ -76,17 +178,413 @@ func main() {
 	// This ensures in-flight requests complete and resources are cleaned up
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for waiting := true; waiting; {
+		select {
+		case <-hup:
+			reload(srv, configPath)
+		case <-quit:
+			waiting = false
+		}
+	}
 
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
 
 	// Give outstanding requests up to 30 seconds to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatalf("Server forced to shutdown: %v", err)
+		fatal("Server forced to shutdown", "error", err)
 	}
 
-	log.Println("Server stopped gracefully")
+	slog.Info("Server stopped gracefully")
+}
+
+// reload re-reads the configuration and applies what can change without a
+// restart. An invalid file is logged and the running configuration kept.
+func reload(srv *server.Server, configPath string) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		slog.Error("Configuration not reloaded", "error", err)
+		return
+	}
+	for _, warning := range cfg.Warnings {
+		slog.Warn("Config warning", "warning", warning)
+	}
+	restart := srv.Reload(cfg)
+	if len(restart) > 0 {
+		slog.Warn("Changed settings need a restart", "settings", strings.Join(restart, ", "))
+	}
+	slog.Info("Configuration reloaded", "path", configPath)
+}
+
+// runPurge deletes expired pastes in batches of [purge] batchsize until
+// none are left, for running from cron when automatic purging is off.
+func runPurge(cfg *config.Config, store storage.Storage) {
+	batchSize := cfg.Purge.BatchSize
+	if batchSize <= 0 {
+		batchSize = 10
+	}
+
+	start := time.Now()
+	total := 0
+	for {
+		purged, err := store.Purge(context.Background(), batchSize)
+		total += purged
+		if err != nil {
+			fatal("Purge failed", "purged", total, "error", err)
+		}
+		if purged == 0 {
+			break
+		}
+	}
+	slog.Info("Purge finished", "purged", total, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// runExport handles "flashpaper export [-o file]": it writes everything in
+// the backend to an archive. See storage.ExportArchive.
+func runExport(store storage.Storage, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	output := exportFlags(fs)
+	fs.Parse(args)
+
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if *output != "-" {
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fatal("Failed to create archive", "error", err)
+		}
+		file, w = f, f
+	}
+	var zw *gzip.Writer
+	if strings.HasSuffix(*output, ".gz") || strings.HasSuffix(*output, ".tgz") {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+
+	start := time.Now()
+	stats, err := storage.ExportArchive(context.Background(), w, store)
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil && file != nil {
+		err = file.Close()
+	}
+	if err != nil {
+		fatal("Export failed", "pastes", stats.Pastes, "error", err)
+	}
+	slog.Info("Export finished", "pastes", stats.Pastes, "skipped", stats.Skipped,
+		"comments", stats.Comments, "values", stats.Values,
+		"duration", time.Since(start).Round(time.Millisecond))
+}
+
+// runImport handles "flashpaper import path": it loads an archive written
+// by export (gzipped or not, - for standard input), or a PrivateBin data
+// directory. See storage.ImportArchive and storage.ImportPrivateBinDir.
+func runImport(store storage.Storage, args []string) {
+	if len(args) != 1 {
+		fatal("Usage: flashpaper [-config file] import archive|privatebin-dir")
+	}
+	start := time.Now()
+
+	if info, err := os.Stat(args[0]); err == nil && info.IsDir() {
+		stats, err := storage.ImportPrivateBinDir(context.Background(), store, args[0])
+		if err != nil {
+			fatal("Import failed", "pastes", stats.Pastes, "comments", stats.Comments, "error", err)
+		}
+		slog.Info("Import finished", "pastes", stats.Pastes, "existing", stats.Existing,
+			"expired", stats.Expired, "legacy", stats.Legacy, "comments", stats.Comments,
+			"duration", time.Since(start).Round(time.Millisecond))
+		return
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fatal("Failed to open archive", "error", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	br := bufio.NewReader(r)
+	r = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			fatal("Failed to read archive", "error", err)
+		}
+		r = zr
+	}
+
+	stats, err := storage.ImportArchive(context.Background(), store, r)
+	if err != nil {
+		fatal("Import failed", "pastes", stats.Pastes, "comments", stats.Comments, "error", err)
+	}
+	slog.Info("Import finished", "pastes", stats.Pastes, "existing", stats.Existing,
+		"skipped", stats.Skipped, "comments", stats.Comments, "values", stats.Values,
+		"duration", time.Since(start).Round(time.Millisecond))
+}
+
+// runImportPrivateBin handles "flashpaper import-privatebin -dsn X": it
+// copies a PrivateBin database into the configured backend. See
+// storage.ImportPrivateBin.
+func runImportPrivateBin(store storage.Storage, args []string) {
+	fs := flag.NewFlagSet("import-privatebin", flag.ExitOnError)
+	driver, dsn, prefix := importPrivateBinFlags(fs)
+	fs.Parse(args)
+	if *dsn == "" {
+		fatal("Usage: flashpaper [-config file] import-privatebin -dsn X [-driver mysql] [-prefix Y]")
+	}
+
+	start := time.Now()
+	stats, err := storage.ImportPrivateBin(context.Background(), store, *driver, *dsn, *prefix)
+	if err != nil {
+		fatal("Import failed", "pastes", stats.Pastes, "comments", stats.Comments, "error", err)
+	}
+	slog.Info("Import finished", "pastes", stats.Pastes, "existing", stats.Existing,
+		"expired", stats.Expired, "legacy", stats.Legacy, "comments", stats.Comments,
+		"duration", time.Since(start).Round(time.Millisecond))
+}
+
+// runSchema handles "flashpaper schema status|upgrade": it reports the
+// database schema version, or applies pending migrations. Status exits
+// non-zero while migrations are pending.
+func runSchema(store storage.Storage, args []string) {
+	if len(args) != 1 || (args[0] != "status" && args[0] != "upgrade") {
+		fatal("Usage: flashpaper [-config file] schema status|upgrade")
+	}
+	m, ok := storage.Schema(store)
+	if !ok {
+		fatal("The storage backend has no schema to migrate")
+	}
+	ctx := context.Background()
+
+	if args[0] == "upgrade" {
+		applied, err := m.MigrateSchema(ctx)
+		if err != nil {
+			fatal("Schema migration failed", "applied", applied, "error", err)
+		}
+		slog.Info("Schema migration finished", "applied", applied)
+	}
+	current, latest, err := m.SchemaVersion(ctx)
+	if err != nil {
+		fatal("Failed to read schema version", "error", err)
+	}
+	slog.Info("Database schema", "version", current, "latest", latest)
+	if current < latest {
+		os.Exit(1)
+	}
+}
+
+// runMigrate handles "flashpaper migrate -from X -to Y". See storage.Migrate.
+func runMigrate(cfg *config.Config, args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from, to := migrateFlags(fs)
+	fs.Parse(args)
+	if *from == "" || *to == "" {
+		fatal("Usage: flashpaper [-config file] migrate -from backend -to backend")
+	}
+
+	srcCfg, err := backendConfig(cfg, *from)
+	if err != nil {
+		fatal("Invalid source", "error", err)
+	}
+	dstCfg, err := backendConfig(cfg, *to)
+	if err != nil {
+		fatal("Invalid destination", "error", err)
+	}
+	if srcCfg.Model == dstCfg.Model {
+		fatal("Source and destination are the same storage")
+	}
+
+	src, err := storage.New(srcCfg)
+	if err != nil {
+		fatal("Failed to open source storage", "error", err)
+	}
+	defer src.Close()
+	dst, err := storage.New(dstCfg)
+	if err != nil {
+		src.Close()
+		fatal("Failed to open destination storage", "error", err)
+	}
+	defer dst.Close()
+
+	start := time.Now()
+	stats, err := storage.Migrate(context.Background(), dst, src)
+	if err != nil {
+		src.Close()
+		dst.Close()
+		fatal("Migration failed", "pastes", stats.Pastes, "error", err)
+	}
+	slog.Info("Migration finished", "pastes", stats.Pastes, "existing", stats.Existing,
+		"skipped", stats.Skipped, "comments", stats.Comments, "values", stats.Values,
+		"duration", time.Since(start).Round(time.Millisecond))
+}
+
+// openShadow opens the [model] shadow backend and returns store mirrored
+// to it. See storage.WithShadow.
+func openShadow(cfg *config.Config, store storage.Storage) storage.Storage {
+	shadowCfg, err := backendConfig(cfg, cfg.Model.Shadow)
+	if err != nil {
+		store.Close()
+		fatal("Invalid shadow storage", "error", err)
+	}
+	if shadowCfg.Model == cfg.Model {
+		store.Close()
+		fatal("Shadow storage is the primary storage")
+	}
+	shadow, err := storage.New(shadowCfg)
+	if err != nil {
+		store.Close()
+		fatal("Failed to open shadow storage", "error", err)
+	}
+	slog.Info("Mirroring storage to shadow", "class", shadowCfg.Model.Class)
+	return storage.WithShadow(store, shadow)
+}
+
+// backendConfig returns cfg with its [model] section replaced by a backend
+// spec of the form name[:location]. Without a location, the directory, DSN,
+// or bucket comes from cfg. Comment limits are lifted so that every comment
+// of a paste can be copied.
+func backendConfig(cfg *config.Config, spec string) (*config.Config, error) {
+	c := *cfg
+	c.Main.CommentLimit = 0
+
+	name, location, _ := strings.Cut(spec, ":")
+	switch name {
+	case "filesystem":
+		c.Model.Class = "Filesystem"
+		if location != "" {
+			c.Model.Dir = location
+		}
+	case "sqlite3", "postgres", "mysql":
+		if location == "" && (cfg.Model.Class != "Database" || cfg.Model.Driver != name) {
+			return nil, fmt.Errorf("%s needs a DSN, e.g. %s:<dsn>", name, name)
+		}
+		c.Model.Class = "Database"
+		c.Model.Driver = name
+		if location != "" {
+			c.Model.DSN = location
+			c.Model.SRV = ""
+		}
+	case "s3":
+		c.Model.Class = "S3"
+		if location != "" {
+			c.Model.Bucket = location
+		}
+	case "blob":
+		if location == "" && cfg.Model.Class != "Blob" {
+			return nil, fmt.Errorf("blob needs a bucket URL, e.g. blob:gs://<bucket>")
+		}
+		c.Model.Class = "Blob"
+		if location != "" {
+			c.Model.Bucket = location
+		}
+	default:
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+	return &c, nil
+}
+
+// runConfigCheck handles "flashpaper config check": it loads and validates
+// the configuration file, prints any warnings, and exits non-zero if the
+// configuration is invalid.
+func runConfigCheck(path string, args []string) {
+	if len(args) != 1 || args[0] != "check" {
+		fatal("Usage: flashpaper [-config file] config check")
+	}
+	if _, err := os.Stat(path); err != nil {
+		fatal("Cannot read configuration file", "error", err)
+	}
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		os.Exit(1)
+	}
+	for _, warning := range cfg.Warnings {
+		fmt.Printf("%s: warning: %s\n", path, warning)
+	}
+	fmt.Printf("%s: OK\n", path)
+}
+
+// runCompact repacks the Filesystem backend's small paste files.
+// See storage.Filesystem.Compact; other backends have nothing to compact.
+func runCompact(store storage.Storage, maxSize int64) {
+	fs, ok := storage.Unwrap(store).(*storage.Filesystem)
+	if !ok {
+		fatal("Compaction only applies to the Filesystem storage class")
+	}
+
+	start := time.Now()
+	stats, err := fs.Compact(context.Background(), maxSize)
+	if err != nil {
+		fatal("Compaction failed", "error", err)
+	}
+	slog.Info("Compaction finished", "shards", stats.Shards, "packed", stats.Packed,
+		"expired", stats.Expired, "duration", time.Since(start).Round(time.Millisecond))
+}
+
+// runPin pins or unpins a paste. See storage.Pin.
+func runPin(store storage.Storage, pin, unpin string) {
+	id, pinned := pin, true
+	if unpin != "" {
+		id, pinned = unpin, false
+	}
+	if pin != "" && unpin != "" {
+		fatal("Use either -pin or -unpin, not both")
+	}
+	if err := util.ValidateIDOrError(id); err != nil {
+		fatal("Invalid paste ID", "error", err)
+	}
+
+	if err := storage.Pin(context.Background(), store, id, pinned); err != nil {
+		fatal("Failed to update paste", "error", err)
+	}
+	if pinned {
+		fmt.Printf("Pinned paste %s\n", id)
+	} else {
+		fmt.Printf("Unpinned paste %s\n", id)
+	}
+}
+
+// runAdmin handles "flashpaper admin token [-ttl 15m]", which prints a
+// signed admin token for [admin] signed_tokens. The token is signed with the
+// server salt from storage, so it works on every instance sharing it.
+func runAdmin(cfg *config.Config, store storage.Storage, args []string) {
+	if len(args) == 0 || args[0] != "token" {
+		fatal("Usage: flashpaper [-config file] admin token [-ttl duration]")
+	}
+	fs := flag.NewFlagSet("admin token", flag.ExitOnError)
+	ttl := adminTokenFlags(fs)
+	fs.Parse(args[1:])
+
+	if *ttl <= 0 || *ttl > util.MaxAdminTokenTTL {
+		fatal("Token lifetime must be positive and within the maximum", "ttl", *ttl, "max", util.MaxAdminTokenTTL)
+	}
+	if !cfg.Admin.SignedTokens {
+		slog.Warn("[admin] signed_tokens is off; the server won't accept this token")
+	}
+
+	salt, err := storage.ServerSalt(context.Background(), store)
+	if err != nil {
+		fatal("Failed to read server salt", "error", err)
+	}
+	expires := time.Now().Add(*ttl)
+	token, err := util.GenerateAdminToken(salt, expires)
+	if err != nil {
+		fatal("Failed to sign token", "error", err)
+	}
+	slog.Info("Admin token issued", "expires", expires.UTC().Format(time.RFC3339))
+	fmt.Println(token)
+}
+
+// fatal logs msg and its attributes at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -20,6 +20,12 @@ var staticFiles embed.FS
 //go:embed web/templates/*
 var templateFiles embed.FS
 
+// i18nFiles embeds the UI translation catalogs, one <lang>.json per language
+// in PrivateBin's format.
+//
+//go:embed web/i18n/*
+var i18nFiles embed.FS
+
 // StaticFS returns a filesystem containing static assets.
 // The returned FS has the "web/static" prefix stripped for cleaner URLs.
 // Example: web/static/js/flashpaper.js -> js/flashpaper.js
@@ -34,6 +40,13 @@ func TemplateFS() (fs.FS, error) {
 	return fs.Sub(templateFiles, "web/templates")
 }
 
+// I18nFS returns a filesystem containing translation catalogs.
+// The returned FS has the "web/i18n" prefix stripped.
+// Example: web/i18n/de.json -> de.json
+func I18nFS() (fs.FS, error) {
+	return fs.Sub(i18nFiles, "web/i18n")
+}
+
 // RawStaticFS returns the embedded static filesystem without path stripping.
 // Useful when you need the full path context.
 func RawStaticFS() embed.FS {
@@ -0,0 +1,175 @@
+// Package assets serves FlashPaper's embedded static files under
+// content-hashed ("fingerprinted") paths.
+//
+// At startup every file in the static filesystem is hashed and given a second
+// name with the hash inserted before the extension:
+//
+//	js/flashpaper.js -> js/flashpaper.3f9c1a2b.js
+//
+// Templates reference the hashed name (see Pipeline.URL), which is served
+// with a year-long immutable cache lifetime. Because the name changes
+// whenever the content does, browsers never run stale JavaScript or CSS
+// after an upgrade. The original names keep working for external links
+// but must be revalidated on every use.
+//
+// Compressible assets are additionally pre-encoded with brotli and gzip at
+// startup (see compress.go) and served according to Accept-Encoding.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// HashLength is the number of hex characters of the SHA-256 digest used in
+// fingerprinted file names. 8 characters (32 bits) is plenty to distinguish
+// successive versions of a handful of files.
+const HashLength = 8
+
+// Cache-Control values for fingerprinted and plain asset paths.
+const (
+	CacheImmutable  = "public, max-age=31536000, immutable"
+	CacheRevalidate = "no-cache"
+)
+
+// Asset is a single static file with its fingerprint.
+type Asset struct {
+	// Path is the logical path relative to the static root (e.g. "js/flashpaper.js")
+	Path string
+
+	// HashedPath is the fingerprinted path (e.g. "js/flashpaper.3f9c1a2b.js")
+	HashedPath string
+
+	// Hash is the truncated hex SHA-256 of Content
+	Hash string
+
+	// ContentType is derived from the file extension
+	ContentType string
+
+	// Content is the raw file content
+	Content []byte
+
+	// Encoded holds pre-compressed variants keyed by content coding
+	// ("br", "gzip"). Nil for assets that don't benefit from compression.
+	Encoded map[string][]byte
+}
+
+// Pipeline indexes static files by logical and fingerprinted path.
+// It is immutable after construction and safe for concurrent use.
+type Pipeline struct {
+	assets   map[string]*Asset // logical path -> asset
+	byHashed map[string]*Asset // fingerprinted path -> asset
+}
+
+// New hashes every regular file in fsys and returns a Pipeline serving them.
+func New(fsys fs.FS) (*Pipeline, error) {
+	p := &Pipeline{
+		assets:   make(map[string]*Asset),
+		byHashed: make(map[string]*Asset),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+
+		sum := sha256.Sum256(content)
+		hash := hex.EncodeToString(sum[:])[:HashLength]
+
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if contentType == "" {
+			contentType = http.DetectContentType(content)
+		}
+
+		asset := &Asset{
+			Path:        name,
+			HashedPath:  fingerprint(name, hash),
+			Hash:        hash,
+			ContentType: contentType,
+			Content:     content,
+		}
+		if err := asset.precompress(); err != nil {
+			return fmt.Errorf("compressing %s: %w", name, err)
+		}
+		p.assets[asset.Path] = asset
+		p.byHashed[asset.HashedPath] = asset
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("indexing static assets: %w", err)
+	}
+
+	return p, nil
+}
+
+// fingerprint inserts hash before the file extension.
+// Files without an extension get the hash appended.
+func fingerprint(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// Lookup returns the asset for a logical path.
+func (p *Pipeline) Lookup(name string) (*Asset, bool) {
+	a, ok := p.assets[strings.TrimPrefix(name, "/")]
+	return a, ok
+}
+
+// URL returns the root-relative fingerprinted URL for a logical asset path.
+// Unknown paths are returned unchanged (with a leading slash) so a typo in a
+// template degrades to a 404 rather than a template execution error.
+func (p *Pipeline) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if p != nil {
+		if a, ok := p.assets[name]; ok {
+			return "/" + a.HashedPath
+		}
+	}
+	return "/" + name
+}
+
+// ServeHTTP serves an asset by fingerprinted or logical path.
+// Fingerprinted paths are cached immutably; logical paths must revalidate.
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+
+	cacheControl := CacheImmutable
+	asset, ok := p.byHashed[name]
+	if !ok {
+		cacheControl = CacheRevalidate
+		asset, ok = p.assets[name]
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	encoding := asset.negotiate(r.Header.Get("Accept-Encoding"))
+	body, etag := asset.variant(encoding)
+
+	// Override the no-store defaults set by the security middleware
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Del("Pragma")
+	w.Header().Set("Content-Type", asset.ContentType)
+	w.Header().Set("ETag", etag)
+	asset.setEncodingHeaders(w.Header(), encoding)
+
+	// ServeContent handles Range, HEAD, and If-None-Match against the ETag
+	http.ServeContent(w, r, asset.Path, time.Time{}, bytes.NewReader(body))
+}
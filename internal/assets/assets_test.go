@@ -0,0 +1,118 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"js/app.js":     {Data: []byte("console.log('v1');")},
+		"css/style.css": {Data: []byte("body{color:red}")},
+		"LICENSE":       {Data: []byte("MIT")},
+	}
+}
+
+func TestNew_FingerprintsAllFiles(t *testing.T) {
+	p, err := New(testFS())
+	require.NoError(t, err)
+
+	a, ok := p.Lookup("js/app.js")
+	require.True(t, ok)
+	assert.Len(t, a.Hash, HashLength)
+	assert.Equal(t, "js/app."+a.Hash+".js", a.HashedPath)
+	assert.True(t, strings.HasPrefix(a.ContentType, "text/javascript") ||
+		strings.HasPrefix(a.ContentType, "application/javascript"), a.ContentType)
+
+	// Files without an extension get the hash appended
+	lic, ok := p.Lookup("/LICENSE")
+	require.True(t, ok)
+	assert.Equal(t, "LICENSE."+lic.Hash, lic.HashedPath)
+}
+
+func TestNew_HashChangesWithContent(t *testing.T) {
+	fs1 := testFS()
+	fs2 := testFS()
+	fs2["js/app.js"] = &fstest.MapFile{Data: []byte("console.log('v2');")}
+
+	p1, err := New(fs1)
+	require.NoError(t, err)
+	p2, err := New(fs2)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, p1.URL("js/app.js"), p2.URL("js/app.js"))
+	assert.Equal(t, p1.URL("css/style.css"), p2.URL("css/style.css"))
+}
+
+func TestPipeline_URL(t *testing.T) {
+	p, err := New(testFS())
+	require.NoError(t, err)
+
+	a, _ := p.Lookup("css/style.css")
+	assert.Equal(t, "/"+a.HashedPath, p.URL("css/style.css"))
+	assert.Equal(t, "/"+a.HashedPath, p.URL("/css/style.css"))
+
+	// Unknown assets and nil pipelines fall back to the plain path
+	assert.Equal(t, "/js/missing.js", p.URL("js/missing.js"))
+
+	var nilPipeline *Pipeline
+	assert.Equal(t, "/js/app.js", nilPipeline.URL("js/app.js"))
+}
+
+func TestPipeline_ServeHTTP(t *testing.T) {
+	p, err := New(testFS())
+	require.NoError(t, err)
+	a, _ := p.Lookup("js/app.js")
+
+	tests := []struct {
+		name         string
+		path         string
+		status       int
+		cacheControl string
+	}{
+		{"fingerprinted path", p.URL("js/app.js"), http.StatusOK, CacheImmutable},
+		{"logical path", "/js/app.js", http.StatusOK, CacheRevalidate},
+		{"unknown hash", "/js/app.deadbeef.js", http.StatusNotFound, ""},
+		{"missing file", "/js/missing.js", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			rr := httptest.NewRecorder()
+			rr.Header().Set("Pragma", "no-cache") // as set by the security middleware
+
+			p.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.status, rr.Code)
+			if tt.status != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.cacheControl, rr.Header().Get("Cache-Control"))
+			assert.Empty(t, rr.Header().Get("Pragma"))
+			assert.Equal(t, `"`+a.Hash+`"`, rr.Header().Get("ETag"))
+			assert.Equal(t, string(a.Content), rr.Body.String())
+		})
+	}
+}
+
+func TestPipeline_ServeHTTP_NotModified(t *testing.T) {
+	p, err := New(testFS())
+	require.NoError(t, err)
+	a, _ := p.Lookup("js/app.js")
+
+	req := httptest.NewRequest(http.MethodGet, "/js/app.js", nil)
+	req.Header.Set("If-None-Match", `"`+a.Hash+`"`)
+	rr := httptest.NewRecorder()
+
+	p.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Zero(t, rr.Body.Len())
+}
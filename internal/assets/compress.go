@@ -0,0 +1,164 @@
+// Package assets provides pre-compression of embedded static files.
+// Assets never change at runtime, so each compressible file is encoded once
+// at startup with brotli and gzip at maximum compression. Requests then pick
+// the best variant the client accepts without any per-request CPU cost.
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Supported content encodings, in order of server preference.
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// encodingPreference lists encodings from most to least preferred.
+var encodingPreference = []string{EncodingBrotli, EncodingGzip}
+
+// compressibleTypes are content type prefixes worth compressing.
+// Images and fonts are already compressed and would only grow.
+var compressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+}
+
+// isCompressible reports whether a content type benefits from compression.
+func isCompressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// precompress encodes the asset with every supported encoding.
+// Variants that are not smaller than the original are discarded.
+func (a *Asset) precompress() error {
+	if !isCompressible(a.ContentType) {
+		return nil
+	}
+
+	a.Encoded = make(map[string][]byte)
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	if _, err := bw.Write(a.Content); err != nil {
+		return err
+	}
+	if err := bw.Close(); err != nil {
+		return err
+	}
+	if br.Len() < len(a.Content) {
+		a.Encoded[EncodingBrotli] = br.Bytes()
+	}
+
+	var gz bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := gw.Write(a.Content); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	if gz.Len() < len(a.Content) {
+		a.Encoded[EncodingGzip] = gz.Bytes()
+	}
+
+	return nil
+}
+
+// negotiate picks the best available encoding for an Accept-Encoding header.
+// Returns "" (identity) when the client accepts none of the variants.
+func (a *Asset) negotiate(acceptEncoding string) string {
+	if len(a.Encoded) == 0 {
+		return ""
+	}
+	return Negotiate(acceptEncoding, func(enc string) bool {
+		_, ok := a.Encoded[enc]
+		return ok
+	})
+}
+
+// Negotiate picks the most preferred supported encoding that available
+// allows and an Accept-Encoding header accepts, or "" (identity) if none.
+// Response compression shares it, so both prefer the same encodings.
+func Negotiate(acceptEncoding string, available func(encoding string) bool) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := parseAcceptEncoding(acceptEncoding)
+	for _, enc := range encodingPreference {
+		if !available(enc) {
+			continue
+		}
+		q, listed := accepted[enc]
+		if !listed {
+			q, listed = accepted["*"]
+		}
+		if listed && q > 0 {
+			return enc
+		}
+	}
+	return ""
+}
+
+// parseAcceptEncoding parses an Accept-Encoding header into coding -> q-value.
+// Codings without an explicit q-value default to 1.
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		accepted[coding] = q
+	}
+	return accepted
+}
+
+// variant returns the body and ETag for the chosen encoding.
+// Each encoding gets a distinct ETag so caches never mix representations.
+func (a *Asset) variant(encoding string) ([]byte, string) {
+	if encoding == "" {
+		return a.Content, `"` + a.Hash + `"`
+	}
+	return a.Encoded[encoding], `"` + a.Hash + "-" + encoding + `"`
+}
+
+// setEncodingHeaders sets Vary and Content-Encoding for a response.
+// Vary is set whenever variants exist, including for identity responses,
+// so shared caches key on Accept-Encoding.
+func (a *Asset) setEncodingHeaders(h http.Header, encoding string) {
+	if len(a.Encoded) > 0 {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+}
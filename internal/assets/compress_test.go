@@ -0,0 +1,128 @@
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compressibleFS() fstest.MapFS {
+	return fstest.MapFS{
+		"js/app.js":   {Data: []byte(strings.Repeat("console.log('hello');\n", 200))},
+		"img/dot.png": {Data: []byte("\x89PNG\r\n\x1a\n not really compressible")},
+	}
+}
+
+func TestPrecompress_CreatesVariants(t *testing.T) {
+	p, err := New(compressibleFS())
+	require.NoError(t, err)
+
+	js, _ := p.Lookup("js/app.js")
+	require.Contains(t, js.Encoded, EncodingBrotli)
+	require.Contains(t, js.Encoded, EncodingGzip)
+	assert.Less(t, len(js.Encoded[EncodingBrotli]), len(js.Content))
+
+	// Round-trip both variants
+	br, err := io.ReadAll(brotli.NewReader(bytes.NewReader(js.Encoded[EncodingBrotli])))
+	require.NoError(t, err)
+	assert.Equal(t, js.Content, br)
+
+	gr, err := gzip.NewReader(bytes.NewReader(js.Encoded[EncodingGzip]))
+	require.NoError(t, err)
+	gz, err := io.ReadAll(gr)
+	require.NoError(t, err)
+	assert.Equal(t, js.Content, gz)
+
+	// Images are never compressed
+	png, _ := p.Lookup("img/dot.png")
+	assert.Empty(t, png.Encoded)
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+	got := parseAcceptEncoding("gzip, deflate;q=0.5, BR;q=0, *;q=0.1")
+	assert.Equal(t, 1.0, got["gzip"])
+	assert.Equal(t, 0.5, got["deflate"])
+	assert.Equal(t, 0.0, got["br"])
+	assert.Equal(t, 0.1, got["*"])
+}
+
+func TestAsset_Negotiate(t *testing.T) {
+	p, err := New(compressibleFS())
+	require.NoError(t, err)
+	js, _ := p.Lookup("js/app.js")
+
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", EncodingGzip},
+		{"gzip, br", EncodingBrotli},
+		{"br;q=0, gzip", EncodingGzip},
+		{"*", EncodingBrotli},
+		{"*;q=0", ""},
+		{"deflate", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			assert.Equal(t, tt.expected, js.negotiate(tt.accept))
+		})
+	}
+}
+
+func TestPipeline_ServeHTTP_ContentEncoding(t *testing.T) {
+	p, err := New(compressibleFS())
+	require.NoError(t, err)
+	js, _ := p.Lookup("js/app.js")
+
+	tests := []struct {
+		name     string
+		accept   string
+		encoding string
+		body     []byte
+	}{
+		{"brotli", "gzip, deflate, br", EncodingBrotli, js.Encoded[EncodingBrotli]},
+		{"gzip", "gzip", EncodingGzip, js.Encoded[EncodingGzip]},
+		{"identity", "", "", js.Content},
+	}
+
+	etags := make(map[string]bool)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, p.URL("js/app.js"), nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			rr := httptest.NewRecorder()
+
+			p.ServeHTTP(rr, req)
+
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.encoding, rr.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+			assert.Equal(t, tt.body, rr.Body.Bytes())
+
+			etag := rr.Header().Get("ETag")
+			assert.False(t, etags[etag], "ETag %s reused across encodings", etag)
+			etags[etag] = true
+		})
+	}
+
+	// Incompressible assets don't vary
+	req := httptest.NewRequest(http.MethodGet, "/img/dot.png", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	rr := httptest.NewRecorder()
+	p.ServeHTTP(rr, req)
+
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Empty(t, rr.Header().Get("Vary"))
+}
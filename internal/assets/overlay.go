@@ -0,0 +1,60 @@
+// Package assets provides layered static filesystems. Operators customize
+// the UI by pointing [main] staticdir at a directory whose files replace
+// embedded ones of the same path, or add to them, without rebuilding.
+package assets
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// Overlay returns a filesystem holding the files of both upper and lower,
+// with upper's taking the place of lower's at the same path.
+func Overlay(upper, lower fs.FS) fs.FS {
+	return overlay{upper: upper, lower: lower}
+}
+
+type overlay struct {
+	upper, lower fs.FS
+}
+
+// Open opens upper's file at name, or lower's if upper has none or has a
+// directory there; directories are listed merged by ReadDir.
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if err != nil {
+		return o.lower.Open(name)
+	}
+	if info, err := f.Stat(); err == nil && !info.IsDir() {
+		return f, nil
+	}
+	if lf, err := o.lower.Open(name); err == nil {
+		f.Close()
+		return lf, nil
+	}
+	return f, nil
+}
+
+// ReadDir lists the entries of both layers, upper's winning on a clash.
+func (o overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, upperErr := fs.ReadDir(o.upper, name)
+	lower, lowerErr := fs.ReadDir(o.lower, name)
+	if upperErr != nil && lowerErr != nil {
+		return nil, errors.Join(upperErr, lowerErr)
+	}
+
+	entries := make(map[string]fs.DirEntry, len(upper)+len(lower))
+	for _, e := range lower {
+		entries[e.Name()] = e
+	}
+	for _, e := range upper {
+		entries[e.Name()] = e
+	}
+	merged := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+	return merged, nil
+}
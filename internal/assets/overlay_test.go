@@ -0,0 +1,43 @@
+package assets
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverlay(t *testing.T) {
+	upper := fstest.MapFS{
+		"css/style.css": {Data: []byte("body{color:blue}")},
+		"css/theme.css": {Data: []byte("h1{}")},
+		"img/logo.svg":  {Data: []byte("<svg/>")},
+	}
+	fsys := Overlay(upper, testFS())
+
+	data, err := fs.ReadFile(fsys, "css/style.css")
+	require.NoError(t, err)
+	assert.Equal(t, "body{color:blue}", string(data))
+	data, err = fs.ReadFile(fsys, "js/app.js")
+	require.NoError(t, err)
+	assert.Equal(t, "console.log('v1');", string(data))
+
+	entries, err := fs.ReadDir(fsys, "css")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "style.css", entries[0].Name())
+	assert.Equal(t, "theme.css", entries[1].Name())
+
+	// The pipeline sees every file of both layers
+	p, err := New(fsys)
+	require.NoError(t, err)
+	for _, name := range []string{"css/style.css", "css/theme.css", "img/logo.svg", "js/app.js", "LICENSE"} {
+		_, ok := p.Lookup(name)
+		assert.True(t, ok, name)
+	}
+
+	_, err = fs.ReadDir(fsys, "missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+// Package callback delivers creator notifications for paste lifecycle events.
+// A paste creator may attach a callback URL when creating a paste; FlashPaper
+// then POSTs a small JSON document to it when the paste is first read, or
+// when it is deleted or found expired. This answers "has the recipient opened
+// my secret yet?" without any user accounts.
+//
+// Callbacks never carry paste content or keys - only the paste ID, the event
+// name, and a timestamp. Because the server makes outbound requests on behalf
+// of anonymous clients, target URLs must match an operator-configured
+// allowlist, and redirects are never followed.
+package callback
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// MaxURLLength bounds the length of a client-supplied callback URL.
+const MaxURLLength = 2048
+
+// Event identifies the paste lifecycle event being reported.
+type Event string
+
+// Lifecycle events reported to callbacks.
+const (
+	// EventRead is sent the first time a paste is successfully retrieved
+	EventRead Event = "read"
+
+	// EventDeleted is sent when a paste is deleted with its delete token
+	EventDeleted Event = "deleted"
+
+	// EventExpired is sent when a paste is found to have expired
+	EventExpired Event = "expired"
+)
+
+// Errors returned by Check.
+var (
+	// ErrDisabled indicates callbacks are not enabled on this server
+	ErrDisabled = errors.New("callbacks are not enabled on this server")
+
+	// ErrNotAllowed indicates the URL is not covered by the allowlist
+	ErrNotAllowed = errors.New("callback URL is not allowed")
+)
+
+// Payload is the JSON document POSTed to a callback URL.
+type Payload struct {
+	PasteID string `json:"pasteid"`
+	Event   Event  `json:"event"`
+	Time    int64  `json:"time"`
+}
+
+// Notifier validates callback URLs and delivers notifications.
+// A nil Notifier rejects every URL and delivers nothing.
+type Notifier struct {
+	allowlist []*url.URL
+	client    *http.Client
+	wg        sync.WaitGroup
+}
+
+// New creates a Notifier from configuration.
+// Returns nil when the allowlist is empty, which disables callbacks.
+func New(cfg config.CallbackConfig) *Notifier {
+	var allowlist []*url.URL
+	for _, prefix := range cfg.Allowlist {
+		// Entries are validated by config.Validate
+		if u, err := url.Parse(prefix); err == nil {
+			allowlist = append(allowlist, u)
+		}
+	}
+	if len(allowlist) == 0 {
+		return nil
+	}
+
+	return &Notifier{
+		allowlist: allowlist,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+			// Following redirects would let an allowlisted host bounce
+			// requests to arbitrary internal addresses
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Check validates a client-supplied callback URL against the allowlist.
+func (n *Notifier) Check(raw string) error {
+	if n == nil {
+		return ErrDisabled
+	}
+	if len(raw) > MaxURLLength {
+		return ErrNotAllowed
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || u.User != nil || u.Host == "" {
+		return ErrNotAllowed
+	}
+
+	for _, prefix := range n.allowlist {
+		if u.Scheme == prefix.Scheme &&
+			strings.EqualFold(u.Host, prefix.Host) &&
+			strings.HasPrefix(u.Path, prefix.Path) {
+			return nil
+		}
+	}
+	return ErrNotAllowed
+}
+
+// Notify delivers an event to target in the background.
+// Delivery is best-effort: failures are not retried or reported, since
+// the paste operation that triggered the event has already completed.
+func (n *Notifier) Notify(target, pasteID string, event Event) {
+	if n == nil || target == "" {
+		return
+	}
+
+	body, err := json.Marshal(Payload{
+		PasteID: pasteID,
+		Event:   event,
+		Time:    time.Now().Unix(),
+	})
+	if err != nil {
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "FlashPaper-Callback")
+
+		resp, err := n.client.Do(req)
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+// Wait blocks until all in-flight notifications have finished.
+func (n *Notifier) Wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
@@ -0,0 +1,187 @@
+// Package captcha verifies captcha responses with Cloudflare Turnstile,
+// hCaptcha, or Google reCAPTCHA. The three services work alike: a widget
+// on the page has the visitor solve a challenge and puts a response token
+// in a form field, the client sends the token with its request, and the
+// server checks it against the service's siteverify endpoint with the
+// site's secret. Each token verifies once.
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// requestTimeout bounds a verification request. Paste creation waits for
+// it, so it is kept short.
+const requestTimeout = 5 * time.Second
+
+// maxResponseSize bounds a verification response read; real ones are a
+// few hundred bytes.
+const maxResponseSize = 64 << 10
+
+// ErrRejected is returned for responses the service doesn't accept:
+// missing, wrong, expired, or already used.
+var ErrRejected = errors.New("captcha response rejected")
+
+// Verifier checks captcha responses.
+type Verifier interface {
+	// Verify returns nil if response is a valid, unused token for a
+	// challenge solved from remoteIP, an error wrapping ErrRejected if it
+	// isn't, and any other error if the service couldn't tell.
+	Verify(ctx context.Context, response, remoteIP string) error
+}
+
+// Widget describes how a provider's widget is put on a page.
+type Widget struct {
+	Script string // Script rendering elements of Class
+	Class  string // Class of the element the widget renders into
+	Field  string // Name of the form field holding the response
+
+	// CSP maps Content-Security-Policy directives to the sources the
+	// widget loads from
+	CSP map[string][]string
+}
+
+// provider is a captcha service.
+type provider struct {
+	verifyURL string
+	widget    Widget
+}
+
+// providers are the supported services, keyed by config.CaptchaConfig.Provider.
+var providers = map[string]provider{
+	config.CaptchaTurnstile: {
+		verifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		widget: Widget{
+			Script: "https://challenges.cloudflare.com/turnstile/v0/api.js",
+			Class:  "cf-turnstile",
+			Field:  "cf-turnstile-response",
+			CSP: map[string][]string{
+				"script-src": {"https://challenges.cloudflare.com"},
+				"frame-src":  {"https://challenges.cloudflare.com"},
+			},
+		},
+	},
+	config.CaptchaHCaptcha: {
+		verifyURL: "https://api.hcaptcha.com/siteverify",
+		widget: Widget{
+			Script: "https://js.hcaptcha.com/1/api.js",
+			Class:  "h-captcha",
+			Field:  "h-captcha-response",
+			CSP: map[string][]string{
+				"script-src":  {"https://hcaptcha.com", "https://*.hcaptcha.com"},
+				"frame-src":   {"https://hcaptcha.com", "https://*.hcaptcha.com"},
+				"style-src":   {"https://hcaptcha.com", "https://*.hcaptcha.com"},
+				"connect-src": {"https://hcaptcha.com", "https://*.hcaptcha.com"},
+			},
+		},
+	},
+	config.CaptchaReCAPTCHA: {
+		verifyURL: "https://www.google.com/recaptcha/api/siteverify",
+		widget: Widget{
+			Script: "https://www.google.com/recaptcha/api.js",
+			Class:  "g-recaptcha",
+			Field:  "g-recaptcha-response",
+			CSP: map[string][]string{
+				"script-src": {"https://www.google.com/recaptcha/", "https://www.gstatic.com/recaptcha/"},
+				"frame-src":  {"https://www.google.com/recaptcha/", "https://recaptcha.google.com/recaptcha/"},
+			},
+		},
+	},
+}
+
+// New returns a verifier for the provider configured in [captcha], or nil
+// if captchas are disabled. The configuration must have been validated.
+func New(cfg config.CaptchaConfig) Verifier {
+	p, ok := providers[cfg.Provider]
+	if !ok {
+		return nil
+	}
+	return &SiteVerify{
+		URL:    p.verifyURL,
+		Secret: cfg.Secret,
+		Client: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// WidgetFor returns the widget of the named provider; ok is false for
+// unknown providers.
+func WidgetFor(name string) (widget Widget, ok bool) {
+	p, ok := providers[name]
+	return p.widget, ok
+}
+
+// SiteVerify verifies responses with a siteverify endpoint, which all
+// supported services provide.
+type SiteVerify struct {
+	URL    string // siteverify endpoint
+	Secret string // Site secret
+	Client *http.Client
+}
+
+// siteVerifyResponse is the part of a siteverify response checked.
+type siteVerifyResponse struct {
+	Success    bool     `json:"success"`
+	ErrorCodes []string `json:"error-codes"`
+}
+
+// configErrors are siteverify error codes that blame the server's request
+// rather than the visitor's response.
+var configErrors = map[string]bool{
+	"missing-input-secret":    true,
+	"invalid-input-secret":    true,
+	"sitekey-secret-mismatch": true,
+	"bad-request":             true,
+	"internal-error":          true,
+}
+
+// Verify implements Verifier.
+func (s *SiteVerify) Verify(ctx context.Context, response, remoteIP string) error {
+	if response == "" {
+		return fmt.Errorf("%w: missing-input-response", ErrRejected)
+	}
+	form := url.Values{
+		"secret":   {s.Secret},
+		"response": {response},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("siteverify: unexpected status %s", resp.Status)
+	}
+
+	var result siteVerifyResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&result); err != nil {
+		return fmt.Errorf("siteverify: invalid response: %w", err)
+	}
+	if result.Success {
+		return nil
+	}
+	for _, code := range result.ErrorCodes {
+		if configErrors[code] {
+			return fmt.Errorf("siteverify: %s", strings.Join(result.ErrorCodes, ", "))
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrRejected, strings.Join(result.ErrorCodes, ", "))
+}
@@ -0,0 +1,79 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	assert.Nil(t, New(config.CaptchaConfig{}))
+	for _, name := range []string{config.CaptchaTurnstile, config.CaptchaHCaptcha, config.CaptchaReCAPTCHA} {
+		v := New(config.CaptchaConfig{Provider: name, SiteKey: "key", Secret: "secret"})
+		require.IsType(t, &SiteVerify{}, v, name)
+		assert.Equal(t, "secret", v.(*SiteVerify).Secret)
+
+		widget, ok := WidgetFor(name)
+		assert.True(t, ok)
+		assert.NotEmpty(t, widget.Script)
+		assert.NotEmpty(t, widget.CSP["frame-src"], name)
+	}
+	_, ok := WidgetFor("")
+	assert.False(t, ok)
+}
+
+func TestSiteVerify(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		r.ParseForm()
+		result := map[string]interface{}{"success": false}
+		switch {
+		case r.PostForm.Get("secret") != "secret":
+			result["error-codes"] = []string{"invalid-input-secret"}
+		case r.PostForm.Get("response") == "good-token" && r.PostForm.Get("remoteip") == "192.0.2.1":
+			result["success"] = true
+		default:
+			result["error-codes"] = []string{"invalid-input-response"}
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	s := &SiteVerify{URL: srv.URL, Secret: "secret", Client: srv.Client()}
+	assert.NoError(t, s.Verify(ctx, "good-token", "192.0.2.1"))
+
+	err := s.Verify(ctx, "bad-token", "192.0.2.1")
+	assert.True(t, errors.Is(err, ErrRejected))
+	assert.ErrorContains(t, err, "invalid-input-response")
+
+	// Empty responses aren't sent
+	assert.True(t, errors.Is(s.Verify(ctx, "", "192.0.2.1"), ErrRejected))
+
+	// A wrong secret is the operator's problem, not the visitor's
+	s.Secret = "wrong"
+	err = s.Verify(ctx, "good-token", "192.0.2.1")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrRejected))
+	assert.NotContains(t, err.Error(), "wrong")
+}
+
+func TestSiteVerify_Unavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	s := &SiteVerify{URL: srv.URL, Secret: "secret", Client: srv.Client()}
+	err := s.Verify(context.Background(), "good-token", "")
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrRejected))
+}
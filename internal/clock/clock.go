@@ -0,0 +1,57 @@
+// Package clock abstracts the current time.
+// Expiration, purging, and rate limiting read the time through a Clock
+// rather than calling time.Now, so tests can move time forward with a Fake
+// instead of sleeping until a paste expires or a bucket refills. Durations
+// of real work (request latencies, bandwidth throttling, I/O deadlines)
+// keep using time.Now: a fake clock would only distort them.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the real clock.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Fake is a Clock that only moves when told to. It is safe for concurrent
+// use.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a fake clock stopped at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake current time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock d forward.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// Set moves the clock to t, which may be in its past.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = t
+}
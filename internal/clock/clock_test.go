@@ -0,0 +1,27 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystem(t *testing.T) {
+	before := time.Now()
+	now := System.Now()
+	assert.False(t, now.Before(before))
+	assert.False(t, now.After(time.Now()))
+}
+
+func TestFake(t *testing.T) {
+	start := time.Unix(1_700_000_000, 0)
+	c := NewFake(start)
+	assert.Equal(t, start, c.Now())
+
+	c.Advance(time.Hour)
+	assert.Equal(t, start.Add(time.Hour), c.Now())
+
+	c.Set(start)
+	assert.Equal(t, start, c.Now())
+}
@@ -0,0 +1,161 @@
+// Package cloudflare tracks Cloudflare's edge networks, so a server behind
+// Cloudflare can tell proxied requests from ones that reached it directly.
+// The lists Cloudflare publishes at https://www.cloudflare.com/ips are
+// embedded at build time and can be refreshed from there while running,
+// since they change every few years and binaries live longer than that.
+package cloudflare
+
+import (
+	"bufio"
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Header carries the visitor's address on requests Cloudflare proxies.
+const Header = "CF-Connecting-IP"
+
+// listURLs are the published IPv4 and IPv6 lists.
+var listURLs = []string{
+	"https://www.cloudflare.com/ips-v4",
+	"https://www.cloudflare.com/ips-v6",
+}
+
+// maxListSize bounds a downloaded list; the real ones are a few hundred bytes.
+const maxListSize = 64 << 10
+
+//go:embed ips-v4.txt ips-v6.txt
+var embedded embed.FS
+
+// Ranges is the current set of Cloudflare networks. It is safe for
+// concurrent use; Refresh replaces the set atomically.
+type Ranges struct {
+	networks atomic.Pointer[[]netip.Prefix]
+	urls     []string
+	client   *http.Client
+}
+
+// New returns the networks embedded at build time.
+func New() *Ranges {
+	var networks []netip.Prefix
+	for _, name := range []string{"ips-v4.txt", "ips-v6.txt"} {
+		data, err := embedded.ReadFile(name)
+		if err != nil {
+			panic(err)
+		}
+		list, err := parseList(strings.NewReader(string(data)))
+		if err != nil {
+			panic(fmt.Sprintf("embedded %s: %v", name, err))
+		}
+		networks = append(networks, list...)
+	}
+
+	r := &Ranges{
+		urls:   listURLs,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	r.networks.Store(&networks)
+	return r
+}
+
+// Contains reports whether addr belongs to Cloudflare.
+func (r *Ranges) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, network := range *r.networks.Load() {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of networks.
+func (r *Ranges) Len() int {
+	return len(*r.networks.Load())
+}
+
+// Refresh downloads the published lists and replaces the current set.
+// If any list can't be fetched or parsed, or is empty, the current set
+// is kept.
+func (r *Ranges) Refresh(ctx context.Context) error {
+	var networks []netip.Prefix
+	for _, url := range r.urls {
+		list, err := r.fetch(ctx, url)
+		if err != nil {
+			return fmt.Errorf("%s: %w", url, err)
+		}
+		networks = append(networks, list...)
+	}
+	r.networks.Store(&networks)
+	return nil
+}
+
+// fetch downloads and parses one list.
+func (r *Ranges) fetch(ctx context.Context, url string) ([]netip.Prefix, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	list, err := parseList(io.LimitReader(resp.Body, maxListSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return list, nil
+}
+
+// Run refreshes the set every interval until ctx is done. The first
+// refresh happens right away, since the embedded lists may be stale.
+// Failures are logged and the previous set stays in use.
+func (r *Ranges) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Cloudflare network refresh failed", "error", err)
+		} else if err == nil {
+			slog.Debug("Cloudflare networks refreshed", "networks", r.Len())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseList parses one CIDR range per line. Blank lines and lines
+// starting with # are skipped.
+func parseList(rd io.Reader) ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	scanner := bufio.NewScanner(rd)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", line)
+		}
+		networks = append(networks, prefix.Masked())
+	}
+	return networks, scanner.Err()
+}
@@ -0,0 +1,70 @@
+package cloudflare
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_Embedded(t *testing.T) {
+	r := New()
+	assert.Greater(t, r.Len(), 10)
+
+	assert.True(t, r.Contains(netip.MustParseAddr("104.16.1.1")))
+	assert.True(t, r.Contains(netip.MustParseAddr("::ffff:104.16.1.1")))
+	assert.True(t, r.Contains(netip.MustParseAddr("2606:4700::1")))
+	assert.False(t, r.Contains(netip.MustParseAddr("192.0.2.1")))
+	assert.False(t, r.Contains(netip.MustParseAddr("2001:db8::1")))
+}
+
+// testRanges returns ranges refreshing from a server answering with lists.
+func testRanges(t *testing.T, lists map[string]string) *Ranges {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, ok := lists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(list))
+	}))
+	t.Cleanup(srv.Close)
+
+	r := New()
+	r.urls = []string{srv.URL + "/ips-v4", srv.URL + "/ips-v6"}
+	return r
+}
+
+func TestRefresh(t *testing.T) {
+	r := testRanges(t, map[string]string{
+		"/ips-v4": "192.0.2.0/24\n\n198.51.100.0/24\n",
+		"/ips-v6": "2001:db8::/32",
+	})
+
+	require.NoError(t, r.Refresh(context.Background()))
+	assert.Equal(t, 3, r.Len())
+	assert.True(t, r.Contains(netip.MustParseAddr("192.0.2.1")))
+	assert.True(t, r.Contains(netip.MustParseAddr("2001:db8::1")))
+	assert.False(t, r.Contains(netip.MustParseAddr("104.16.1.1")))
+}
+
+func TestRefresh_KeepsCurrentOnFailure(t *testing.T) {
+	for name, lists := range map[string]map[string]string{
+		"missing": {"/ips-v4": "192.0.2.0/24"},
+		"empty":   {"/ips-v4": "192.0.2.0/24", "/ips-v6": "\n"},
+		"invalid": {"/ips-v4": "192.0.2.0/24", "/ips-v6": "<html>"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := testRanges(t, lists)
+			before := r.Len()
+
+			assert.Error(t, r.Refresh(context.Background()))
+			assert.Equal(t, before, r.Len())
+			assert.True(t, r.Contains(netip.MustParseAddr("104.16.1.1")))
+		})
+	}
+}
@@ -5,30 +5,69 @@
 //
 // The configuration is organized into sections matching PrivateBin:
 //   - [main]: Core application settings (name, template, size limits)
-//   - [expire]: Paste expiration options and defaults
+//   - [expire]: Paste expiration default
+//   - [expire_options], [expire_labels]: Expiration choices and their labels
 //   - [traffic]: Rate limiting configuration
 //   - [purge]: Expired paste cleanup settings
 //   - [model]: Storage backend configuration
+//   - [security]: HTTP security header policy
+//   - [callback]: Creator notification callbacks (read/delete/expire)
+//   - [admin]: Operator API authentication
+//   - [tos]: Terms-of-service document and acceptance requirement
+//   - [server]: HTTP request timeouts
+//   - [pow]: Proof-of-work gate on paste creation
+//   - [captcha]: Captcha verification on paste creation
+//   - [maintenance]: Refusing new content during storage work
 package config
 
 import (
 	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"gopkg.in/ini.v1"
+
+	"github.com/liskl/flashpaper/internal/cloudflare"
 )
 
 // Config holds all application configuration organized by section.
 // This structure mirrors PrivateBin's conf.php for API compatibility.
 type Config struct {
-	Main    MainConfig
-	Expire  ExpireConfig
-	Traffic TrafficConfig
-	Purge   PurgeConfig
-	Model   ModelConfig
+	Main        MainConfig
+	Expire      ExpireConfig
+	Traffic     TrafficConfig
+	Purge       PurgeConfig
+	Model       ModelConfig
+	Security    SecurityConfig
+	Callback    CallbackConfig
+	Webhook     WebhookConfig
+	Admin       AdminConfig
+	TOS         TOSConfig
+	Server      ServerConfig
+	SoftLimit   SoftLimitConfig
+	Tokens      TokensConfig
+	Auth        AuthConfig
+	Metrics     MetricsConfig
+	Telemetry   TelemetryConfig
+	Instance    InstanceConfig
+	API         APIConfig
+	Events      EventsConfig
+	Stats       StatsConfig
+	Maintenance MaintenanceConfig
+	PoW         PoWConfig
+	Captcha     CaptchaConfig
+
+	// Warnings lists problems found in the config file that didn't prevent
+	// loading, such as unknown or deprecated keys. See Schema.
+	Warnings []string
 }
 
 // MainConfig contains core application settings.
@@ -51,31 +90,92 @@ type MainConfig struct {
 	// OpenDiscussion allows discussions without requiring a paste password
 	OpenDiscussion bool
 
+	// CommentLimit is the maximum number of comments per paste (0 = unlimited)
+	CommentLimit int
+
+	// Moderation holds new comments for approval through the admin API:
+	// off, flagged (only comments a spam hook flags), or all
+	Moderation string
+
 	// Password enables password protection option for pastes
 	Password bool
 
+	// AccessProof lets creators require a proof of the paste password on
+	// every read, checked by the server. Gated pastes stay gated if it is
+	// turned off later.
+	AccessProof bool
+
+	// Editable lets creators replace a paste's content without changing
+	// its URL, with an edit token returned at creation. PrivateBin has no
+	// such thing, so its clients never offer it.
+	Editable bool
+
 	// FileUpload enables file attachment support
 	FileUpload bool
 
 	// BurnAfterReadingSelected sets burn-after-reading as the default option
 	BurnAfterReadingSelected bool
 
+	// ForceBurnAfterReading makes every new paste burn-after-reading,
+	// whatever the client asked for (one-time secret instances)
+	ForceBurnAfterReading bool
+
+	// ForceOpenDiscussion opens a discussion on every new paste
+	ForceOpenDiscussion bool
+
 	// SizeLimit is the maximum paste size in bytes (default: 10MB)
 	SizeLimit int64
 
-	// Template is the UI template to use (bootstrap5, bootstrap-dark, etc.)
+	// AttachmentLimit is the maximum total size of a paste's attachments
+	// in bytes, counted separately from SizeLimit (default: 10MB)
+	AttachmentLimit int64
+
+	// Template is the UI theme: bootstrap5, bootstrap-dark, page, or one
+	// whose manifest or <name>.html page is in TemplateDir
 	Template string
 
-	// LanguageSelection enables the language picker in the UI
+	// TemplateDir holds *.html files that replace the embedded templates of
+	// the same name (empty = embedded templates only)
+	TemplateDir string
+
+	// StaticDir holds files that replace the embedded static files of the
+	// same path, or add to them (empty = embedded files only)
+	StaticDir string
+
+	// TemplateReload re-reads TemplateDir, StaticDir, and the template's
+	// manifest whenever their files change, for working on a theme
+	TemplateReload bool
+
+	// LanguageSelection enables the language picker in the UI; the picked
+	// language takes precedence over the browser's Accept-Language
 	LanguageSelection bool
 
-	// LanguageDefault is the default language code (e.g., "en")
+	// LanguageDefault is the UI language for browsers asking for none that
+	// has a translation (e.g., "en")
 	LanguageDefault string
 
 	// QRCode enables QR code generation for paste URLs
 	QRCode bool
 
-	// Icon sets the icon style for comments (identicon, jdenticon, vizhash, none)
+	// PublicURL is the absolute URL users reach the instance at, BasePath
+	// included (e.g. https://example.com/paste). External URL shorteners
+	// need it, as they are sent absolute paste URLs.
+	PublicURL string
+
+	// URLShortener shortens the paste URL in creation responses: local
+	// (short links served under /s/), yourls, get (a GET request to the
+	// URLShortenerURL template), or empty for none
+	URLShortener string
+
+	// URLShortenerURL is the YOURLS API URL, or for get the request URL with
+	// {url} where the escaped paste URL goes
+	URLShortenerURL string
+
+	// URLShortenerSignature is the YOURLS signature token
+	URLShortenerSignature string
+
+	// Icon sets the icon style for comments (identicon, jdenticon, vizhash,
+	// none), rendered from each comment's vizhash into meta.icon
 	Icon string
 
 	// HTTPWarning shows a warning when not using HTTPS
@@ -83,6 +183,97 @@ type MainConfig struct {
 
 	// Compression specifies the compression algorithm (zlib or none)
 	Compression string
+
+	// TLSCert and TLSKey are PEM files for serving HTTPS directly, without
+	// a TLS-terminating proxy in front
+	TLSCert string
+	TLSKey  string
+
+	// ACME obtains and renews certificates for ACMEDomains automatically
+	// (from Let's Encrypt unless ACMEDirectory is set) instead of TLSCert
+	ACME          bool
+	ACMEDomains   []string
+	ACMEEmail     string // Contact for expiry and problem notices
+	ACMECache     string // Directory where certificates and the account key are kept
+	ACMEDirectory string // ACME directory URL, e.g. a staging endpoint
+
+	// RedirectPort is the plain HTTP port that redirects to HTTPS and
+	// answers ACME HTTP-01 challenges when TLS is on (0 disables it)
+	RedirectPort int
+
+	// HSTSMaxAge is the Strict-Transport-Security max-age in seconds sent
+	// over HTTPS when TLS is on (0 omits the header)
+	HSTSMaxAge int
+
+	// LogLevel is the minimum level logged: debug, info, warn, or error.
+	// Paste IDs only appear in debug logs.
+	LogLevel string
+
+	// LogFormat is text (logfmt-style key=value pairs) or json
+	LogFormat string
+
+	// LogPrivacy is how access logs show request URIs, which carry paste
+	// IDs and delete tokens: one of the LogPrivacy constants
+	LogPrivacy string
+}
+
+// Access log privacy modes for MainConfig.LogPrivacy.
+const (
+	// LogPrivacyDebug logs request URIs as they are, at debug level only
+	LogPrivacyDebug = "debug"
+
+	// LogPrivacyRedact logs request URIs at every level, with paste IDs
+	// and tokens masked
+	LogPrivacyRedact = "redact"
+
+	// LogPrivacyHash logs request URIs at every level, with paste IDs and
+	// tokens replaced by keyed hashes, so requests for the same paste can
+	// be correlated without revealing it
+	LogPrivacyHash = "hash"
+
+	// LogPrivacyOff logs request URIs as they are at every level
+	LogPrivacyOff = "off"
+)
+
+// validateURLShortener checks the public URL and the URL shortener
+// settings, which only work together.
+func (m MainConfig) validateURLShortener() error {
+	if m.PublicURL != "" && !isHTTPURL(m.PublicURL) {
+		return fmt.Errorf("publicurl must be an absolute http(s) URL, got %q", m.PublicURL)
+	}
+	switch m.URLShortener {
+	case "", URLShortenerLocal:
+		return nil
+	case URLShortenerYOURLS, URLShortenerGet:
+		// Checked below
+	default:
+		return fmt.Errorf("urlshortener must be 'local', 'yourls', 'get', or empty, got %q", m.URLShortener)
+	}
+
+	if m.PublicURL == "" {
+		return fmt.Errorf("urlshortener %s requires publicurl", m.URLShortener)
+	}
+	if !isHTTPURL(m.URLShortenerURL) {
+		return fmt.Errorf("urlshortener_url must be an absolute http(s) URL, got %q", m.URLShortenerURL)
+	}
+	if m.URLShortener == URLShortenerYOURLS && m.URLShortenerSignature == "" {
+		return fmt.Errorf("urlshortener yourls requires urlshortener_signature")
+	}
+	if m.URLShortener == URLShortenerGet && !strings.Contains(m.URLShortenerURL, "{url}") {
+		return fmt.Errorf("urlshortener_url must contain {url} for urlshortener get")
+	}
+	return nil
+}
+
+// isHTTPURL reports whether s is an absolute http(s) URL.
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// TLSEnabled reports whether the server terminates TLS itself.
+func (m MainConfig) TLSEnabled() bool {
+	return m.ACME || m.TLSCert != ""
 }
 
 // ExpireConfig controls paste expiration behavior.
@@ -90,29 +281,112 @@ type ExpireConfig struct {
 	// Default is the default expiration option (e.g., "1week")
 	Default string
 
-	// Options maps expiration labels to durations
+	// Options maps expiration option keys to durations
 	// Standard options: 5min, 10min, 1hour, 1day, 1week, 1month, 1year, never
 	Options map[string]time.Duration
+
+	// Order lists option keys in the order they are offered to users.
+	// Options missing from Order are listed after it, by duration.
+	Order []string
+
+	// Labels maps option keys to display labels (e.g. "2weeks" -> "2 weeks").
+	// Standard options have built-in labels; others are displayed by key.
+	Labels map[string]string
+}
+
+// standardExpireLabels are the display labels for the standard expiration
+// options, used when Labels doesn't override them.
+var standardExpireLabels = map[string]string{
+	"5min":   "5 min",
+	"10min":  "10 min",
+	"1hour":  "1 hour",
+	"1day":   "1 day",
+	"1week":  "1 week",
+	"1month": "1 month",
+	"1year":  "1 year",
+	"never":  "Never",
 }
 
 // TrafficConfig controls rate limiting to prevent abuse.
 type TrafficConfig struct {
 	// Limit is the minimum seconds between paste creations from same IP
-	// Set to 0 to disable rate limiting
+	// once Burst is used up. Set to 0 to disable rate limiting
 	Limit int
 
-	// Exempted is a list of IP addresses/subnets exempt from rate limiting
+	// Burst is how many pastes a client may create at once (at least 1)
+	Burst int
+
+	// CommentLimit and CommentBurst limit comment creation the same way
+	CommentLimit int
+	CommentBurst int
+
+	// ReadLimit and ReadBurst limit paste reads (including raw downloads)
+	ReadLimit int
+	ReadBurst int
+
+	// Store is where rate limit state is kept: TrafficStoreStorage shares
+	// it between instances on the same backend, TrafficStoreMemory keeps
+	// it per process
+	Store string
+
+	// Exempted is a list of IP addresses and CIDR ranges exempt from
+	// rate limiting
 	Exempted []string
 
-	// Creators is a list of IPs that are allowed to create pastes
-	// If empty, all IPs can create pastes
+	// Creators is a list of IP addresses and CIDR ranges allowed to create
+	// pastes. If empty, all clients can create pastes
 	Creators []string
 
 	// Header is the HTTP header to use for client IP (for reverse proxies)
 	// Common values: X-Forwarded-For, X-Real-IP, CF-Connecting-IP
 	Header string
+
+	// TrustedProxies is a list of IP addresses and CIDR ranges of reverse
+	// proxies whose forwarding headers are believed. Requests from other
+	// peers are judged by their connection address. If empty, forwarding
+	// headers are believed from every peer
+	TrustedProxies []string
+
+	// Preset configures client address handling for a known proxy in
+	// front of the server: TrafficPresetCloudflare, or "" for none
+	Preset string
+
+	// PresetRefresh is how often, in seconds, the preset's proxy networks
+	// are refreshed from the provider; 0 keeps the list built into the binary
+	PresetRefresh int
+
+	// DownloadRate caps each paste download in bytes per second
+	// Applies to /raw and to reads of pastes with attachments; 0 disables
+	DownloadRate int64
+
+	// DownloadRateGlobal caps all downloads combined in bytes per second
+	// 0 disables
+	DownloadRateGlobal int64
+
+	// IdempotencyTTL is how long, in seconds, a paste creation can be
+	// retried with the same Idempotency-Key header; 0 ignores the header
+	IdempotencyTTL int
+
+	// SaltRotation is how often, in seconds, the salt hashing client
+	// addresses for rate limiting changes, so a client's hashes can't be
+	// linked across periods; 0 keeps one salt forever
+	SaltRotation int
 }
 
+// Rate limit stores for TrafficConfig.Store.
+const (
+	// TrafficStoreStorage keeps rate limit state in the storage backend
+	TrafficStoreStorage = "storage"
+
+	// TrafficStoreMemory keeps rate limit state in memory
+	TrafficStoreMemory = "memory"
+)
+
+// TrafficPresetCloudflare is the TrafficConfig.Preset for servers behind
+// Cloudflare: client addresses come from CF-Connecting-IP, trusted only on
+// requests from Cloudflare's networks.
+const TrafficPresetCloudflare = "cloudflare"
+
 // PurgeConfig controls automatic cleanup of expired pastes.
 type PurgeConfig struct {
 	// Limit is the minimum seconds between purge operations
@@ -125,17 +399,602 @@ type PurgeConfig struct {
 
 // ModelConfig defines the storage backend settings.
 type ModelConfig struct {
-	// Class is the storage backend type: Database or Filesystem
+	// Class is the storage backend type: Database, Filesystem, S3, or Blob
 	Class string
 
 	// Database-specific settings (when Class = "Database")
 	DSN    string // Data Source Name for database connection
 	Driver string // Database driver: sqlite3, postgres, mysql
 
+	// Failover for PostgreSQL and MySQL: the DSN may list several hosts,
+	// or SRV names a DNS SRV record to look them up from
+	SRV           string
+	ProbeInterval int // Seconds between primary health probes (0 = no probing)
+
+	// NoMigrate skips applying pending schema migrations at startup, for
+	// running "flashpaper schema upgrade" as a separate step; startup then
+	// fails while the schema is behind
+	NoMigrate bool
+
 	// Filesystem-specific settings (when Class = "Filesystem")
 	Dir string // Directory path for paste storage
+
+	// Object storage settings (when Class = "S3" or "Blob"). For Blob,
+	// Bucket is a URL whose scheme picks the service: s3://bucket,
+	// gs://bucket (Google Cloud Storage), or azblob://container (Azure)
+	Bucket    string // Bucket name, or URL for Blob
+	Region    string // Region used for request signing (S3)
+	Endpoint  string // Endpoint URL for compatible services and emulators
+	Prefix    string // Key prefix, to share a bucket between instances
+	PathStyle bool   // Address the bucket in the URL path (S3; MinIO)
+	AccessKey string // Access key ID (S3) or storage account name (Azure)
+	SecretKey string // Secret access key (S3) or account key (Azure)
+
+	// Encryption at rest: keys sealing stored records, as comma-separated
+	// id:base64 entries, the first sealing new records, or the path of a
+	// file holding the entries (e.g. written by a KMS agent). Empty stores
+	// records as they are.
+	EncryptionKeys     string
+	EncryptionKeysFile string
+
+	// Shadow is a second backend, in the form of "flashpaper migrate"
+	// (e.g. postgres:<dsn>), that mirrors every change and read for
+	// comparison before a cutover; empty for none. See storage.WithShadow
+	Shadow string
+
+	// QueryTimeout is the maximum seconds a single storage operation may
+	// take, within the request's own deadline (0 = only the request's)
+	QueryTimeout int
+
+	// Connection pool of the Database class; 0 picks the driver's default
+	MaxOpenConns    int // Open connections at most
+	MaxIdleConns    int // Idle connections kept for reuse
+	ConnMaxLifetime int // Seconds before a connection is replaced
+
+	// SQLite pragmas, applied to every connection unless the DSN sets them
+	SQLiteJournalMode string // journal_mode: wal, delete, truncate, ...
+	SQLiteBusyTimeout int    // busy_timeout: milliseconds to wait for a lock
+	SQLiteSynchronous string // synchronous: off, normal, full, or extra
+}
+
+// URL shorteners for MainConfig.URLShortener.
+const (
+	// URLShortenerLocal keeps short codes in storage and redirects them
+	URLShortenerLocal = "local"
+
+	// URLShortenerYOURLS uses a YOURLS instance's API
+	URLShortenerYOURLS = "yourls"
+
+	// URLShortenerGet requests a URL template and reads the short URL from
+	// the plain text response
+	URLShortenerGet = "get"
+)
+
+// Comment moderation modes for MainConfig.Moderation.
+const (
+	// ModerationOff publishes comments as soon as they are stored
+	ModerationOff = "off"
+
+	// ModerationFlagged holds comments a spam hook flags
+	ModerationFlagged = "flagged"
+
+	// ModerationAll holds every new comment
+	ModerationAll = "all"
+)
+
+// Server header disclosure policies for SecurityConfig.ServerHeader.
+const (
+	// ServerHeaderNone omits the Server header entirely
+	ServerHeaderNone = "none"
+
+	// ServerHeaderProduct sends only the product name ("FlashPaper")
+	ServerHeaderProduct = "product"
+
+	// ServerHeaderFull sends product and version ("FlashPaper/1.2.3")
+	ServerHeaderFull = "full"
+)
+
+// SecurityConfig controls security-related HTTP response behavior.
+type SecurityConfig struct {
+	// ServerHeader controls version disclosure in the Server response header:
+	// none, product, or full. Some operators must hide versions from scanners,
+	// others want them visible for fleet auditing.
+	ServerHeader string
+
+	// Denylist holds IP addresses and CIDR ranges that may not create
+	// pastes or comments. Operators can add more at runtime through
+	// /admin/denylist; those are kept in storage.
+	Denylist []string
+
+	// DenylistReads also refuses denylisted clients reading pastes
+	DenylistReads bool
+
+	// Tarpit answers denylisted clients with a slow, fake success instead
+	// of 403 Forbidden
+	Tarpit bool
+
+	// CSP overrides Content-Security-Policy directives, written as in the
+	// header ("img-src 'self' https://img.example.com; report-uri /csp").
+	// A directive given here replaces the built-in sources; directives
+	// without built-in sources are added.
+	CSP string
+
+	// CSPNonce replaces 'unsafe-inline' in script-src and style-src with a
+	// nonce generated per response, which templates put on their inline
+	// scripts and styles as {{.CSPNonce}}
+	CSPNonce bool
+
+	// FrameOptions is the X-Frame-Options value: deny, sameorigin, or none
+	// to allow framing. CSP frame-ancestors follows unless set in CSP.
+	FrameOptions string
+
+	// HSTSIncludeSubdomains and HSTSPreload add the includeSubDomains and
+	// preload directives to Strict-Transport-Security
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// COOP, COEP, and CORP are the Cross-Origin-Opener-Policy,
+	// Cross-Origin-Embedder-Policy, and Cross-Origin-Resource-Policy
+	// values; each header is omitted when empty
+	COOP string
+	COEP string
+	CORP string
+
+	// CORSOrigins are the origins, such as "https://app.example.com" or
+	// "*" for any, whose pages may call the JSON API. Empty turns CORS off.
+	CORSOrigins []string
+
+	// CORSMethods and CORSHeaders are the methods and request headers
+	// allowed in cross-origin API requests
+	CORSMethods []string
+	CORSHeaders []string
+}
+
+// X-Frame-Options policies for SecurityConfig.FrameOptions.
+const (
+	// FrameOptionsDeny forbids framing entirely
+	FrameOptionsDeny = "deny"
+
+	// FrameOptionsSameOrigin allows framing by pages of the same origin
+	FrameOptionsSameOrigin = "sameorigin"
+
+	// FrameOptionsNone omits the header, allowing any site to frame pages
+	FrameOptionsNone = "none"
+)
+
+// CSPDirective is one directive of a Content-Security-Policy.
+type CSPDirective struct {
+	Name    string
+	Sources []string
+}
+
+// cspDirectiveName matches a directive name such as "script-src".
+var cspDirectiveName = regexp.MustCompile(`^[a-z][a-z-]*$`)
+
+// ParseCSP parses a policy in header syntax into its directives, in
+// order. Empty directives are skipped; a name repeated is an error, since
+// browsers ignore all but the first.
+func ParseCSP(policy string) ([]CSPDirective, error) {
+	var directives []CSPDirective
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(policy, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if !cspDirectiveName.MatchString(name) {
+			return nil, fmt.Errorf("invalid CSP directive %q", fields[0])
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("CSP directive %q given twice", name)
+		}
+		seen[name] = true
+		for _, source := range fields[1:] {
+			if strings.ContainsAny(source, ",;") {
+				return nil, fmt.Errorf("invalid source %q in CSP directive %q", source, name)
+			}
+		}
+		directives = append(directives, CSPDirective{Name: name, Sources: fields[1:]})
+	}
+	return directives, nil
+}
+
+// CallbackConfig controls creator notification callbacks.
+// A paste creator may supply a callback URL that is pinged when the paste
+// is first read, deleted, or found expired. To keep the server from being
+// used to reach arbitrary hosts, only URLs under an allowlisted prefix are
+// accepted.
+type CallbackConfig struct {
+	// Allowlist is a list of URL prefixes callbacks must start with
+	// (e.g. "https://hooks.example.com/flashpaper/").
+	// Empty disables callbacks entirely.
+	Allowlist []string
+
+	// Timeout is the maximum seconds to wait for a callback to complete
+	Timeout int
+}
+
+// WebhookEvents are the events webhooks can report.
+var WebhookEvents = []string{"paste.created", "paste.deleted", "paste.expired", "comment.created"}
+
+// WebhookConfig controls webhooks: POSTs to the operator's endpoints when
+// pastes are created, deleted, or found expired, and when comments are
+// posted. Unlike creator callbacks they cover every paste, and carry only
+// IDs, the event, and a timestamp.
+type WebhookConfig struct {
+	// URLs are the endpoints every event is sent to; empty disables webhooks
+	URLs []string
+
+	// Secret is the key payloads are signed with (HMAC-SHA256); empty
+	// sends them unsigned
+	Secret string
+
+	// Events limits delivery to some of WebhookEvents; empty sends all
+	Events []string
+
+	// Timeout is the maximum seconds to wait for an endpoint to respond
+	Timeout int
+
+	// Retries is how often a failed delivery is tried again, waiting
+	// twice as long before each attempt
+	Retries int
+
+	// Queue is how many deliveries may wait for a worker; events beyond
+	// it are dropped rather than slowing down requests
+	Queue int
+
+	// Workers is how many deliveries are in progress at once
+	Workers int
+}
+
+// AdminConfig controls the operator API under /admin.
+type AdminConfig struct {
+	// Token is the bearer token required by admin endpoints.
+	// Empty disables the admin API unless SignedTokens is set.
+	Token string
+
+	// SignedTokens accepts short-lived tokens minted with
+	// "flashpaper admin token", signed with the server salt
+	SignedTokens bool
+}
+
+// Enabled reports whether the admin API is mounted.
+func (a AdminConfig) Enabled() bool {
+	return a.Token != "" || a.SignedTokens
+}
+
+// TOSConfig controls the terms-of-service gate.
+// Some jurisdictions expect public instances to have users acknowledge
+// terms before publishing content.
+type TOSConfig struct {
+	// File is the path to the terms document served at /tos.
+	// Files ending in .html or .htm are served as HTML, anything else as
+	// Markdown. Empty disables /tos.
+	File string
+
+	// Required rejects paste creation unless the client sends
+	// "tos_accepted": true
+	Required bool
+}
+
+// InstanceConfig describes the instance to directory sites and clients in
+// /.well-known/flashpaper.json. Everything here is public.
+type InstanceConfig struct {
+	// Description is a short summary of the instance and who runs it
+	Description string
+
+	// Contact is how to reach the operator (e-mail address or URL)
+	Contact string
+
+	// PublicKey is the operator's public key (e.g. OpenPGP, minisign, or
+	// age), published for verifying statements from the operator
+	PublicKey string
+}
+
+// APIConfig controls the secret API at /api/v1/secret, a JSON API for
+// scripts that is separate from the PrivateBin-compatible endpoints.
+type APIConfig struct {
+	// Secrets enables the secret API
+	Secrets bool
+
+	// ServerEncryption lets creators send plaintext for the server to
+	// encrypt. The server then sees the secret, if only while storing and
+	// serving it; instances promising to never see content turn this off.
+	ServerEncryption bool
+}
+
+// EventsConfig controls the /events stream, which pushes comment
+// notifications to readers of a discussion as Server-Sent Events.
+type EventsConfig struct {
+	// Enabled mounts /events
+	Enabled bool
+
+	// MaxClients caps the streams open at once; 0 = unlimited
+	MaxClients int
+
+	// Heartbeat is the seconds between keep-alive comments on idle
+	// streams, which stop proxies from closing them
+	Heartbeat int
+
+	// Redis is the host:port of a Redis server relaying notifications
+	// between replicas; empty keeps them within the process
+	Redis string
+
+	// RedisPassword authenticates to Redis, if it requires it
+	RedisPassword string
+
+	// RedisChannel is the pub/sub channel the replicas share
+	RedisChannel string
+}
+
+// StatsConfig controls the /stats endpoint, which publishes aggregate
+// figures about the instance: how many pastes it holds, how many were
+// created in the last day, and their typical size.
+type StatsConfig struct {
+	// Enabled mounts /stats
+	Enabled bool
+
+	// Cache is the seconds figures are reused for before the backend is
+	// asked again; counting can mean reading every paste
+	Cache int
+}
+
+// MaintenanceConfig controls maintenance mode, for storage migrations and
+// other work during which the instance mustn't take new content. The admin
+// API can switch it without a reload (see PUT /admin/maintenance).
+type MaintenanceConfig struct {
+	// Enabled refuses new pastes, comments, edits, and secrets with 503,
+	// while existing pastes can still be read and deleted
+	Enabled bool
+
+	// ReadOnly also refuses everything else that writes to storage:
+	// deletes, extending and disabling links, and reads that burn or count
+	// a paste. Implies Enabled.
+	ReadOnly bool
+
+	// Message is shown to users instead of the default notice
+	Message string
+}
+
+// Active reports whether maintenance mode is on.
+func (m MaintenanceConfig) Active() bool {
+	return m.Enabled || m.ReadOnly
+}
+
+// PoWConfig controls the proof-of-work gate on paste creation, a spam
+// deterrent for public instances: clients fetch a challenge from /pow and
+// must send a solution with each new paste or secret, which costs a
+// browser a few seconds and a bot that much per paste. Creators with an
+// API token are exempt.
+type PoWConfig struct {
+	// Enabled requires a solution for creating pastes and secrets
+	Enabled bool
+
+	// Difficulty is the number of leading zero bits required of the
+	// solution's hash; each one doubles the work (1-32)
+	Difficulty int
+
+	// TTL is the seconds a challenge can be answered in
+	TTL int
+}
+
+// Captcha providers for CaptchaConfig.Provider.
+const (
+	// CaptchaTurnstile verifies Cloudflare Turnstile responses
+	CaptchaTurnstile = "turnstile"
+
+	// CaptchaHCaptcha verifies hCaptcha responses
+	CaptchaHCaptcha = "hcaptcha"
+
+	// CaptchaReCAPTCHA verifies Google reCAPTCHA (v2) responses
+	CaptchaReCAPTCHA = "recaptcha"
+)
+
+// CaptchaConfig controls captcha verification on paste creation, for
+// operators who prefer a captcha service to proof of work. The web UI
+// renders the provider's widget, and the server checks its response with
+// the provider before storing the paste or secret. Creators with an API
+// token are exempt.
+type CaptchaConfig struct {
+	// Provider is the captcha service: "turnstile", "hcaptcha",
+	// "recaptcha", or empty to disable captchas
+	Provider string
+
+	// SiteKey is the public key the widget is rendered with
+	SiteKey string
+
+	// Secret is the private key responses are verified with
+	Secret string
+}
+
+// Enabled reports whether creation requires a captcha.
+func (c CaptchaConfig) Enabled() bool {
+	return c.Provider != ""
+}
+
+// ServerConfig controls HTTP request handling.
+// A single timeout suits no route well: health checks should fail fast,
+// while creating a paste with a large attachment can take minutes on a
+// slow uplink. All values are in seconds.
+type ServerConfig struct {
+	// Timeout applies to routes without a more specific timeout
+	Timeout int
+
+	// HealthTimeout applies to /health and /readyz
+	HealthTimeout int
+
+	// CreateTimeout applies to paste and comment creation
+	CreateTimeout int
+
+	// ReadTimeout applies to paste reads, raw downloads, and receipts
+	ReadTimeout int
+
+	// MaxReads and MaxWrites cap the requests served at once: GET and HEAD
+	// requests count as reads, everything else as writes. Requests beyond
+	// a cap get 503 with Retry-After right away. 0 = unlimited
+	MaxReads  int
+	MaxWrites int
+
+	// Connection deadlines of the HTTP server. Reads and writes default
+	// (0) to the longest route timeout plus a margin, and may not be set
+	// shorter than it, or slow uploads would be cut off mid-request
+	ConnReadTimeout   int // Reading a whole request, body included
+	ConnHeaderTimeout int // Reading request headers (0 = ConnReadTimeout)
+	ConnWriteTimeout  int // Writing the response
+	ConnIdleTimeout   int // Keep-alive connections between requests
+
+	// H2C serves HTTP/2 over plain TCP, for proxies such as Envoy that
+	// speak HTTP/2 to their upstreams. HTTPS negotiates HTTP/2 anyway
+	H2C bool
+
+	// Compress compresses responses of CompressTypes with brotli or gzip
+	// at CompressLevel (1, fastest, to 9, smallest). Static assets are
+	// precompressed either way
+	Compress      bool
+	CompressLevel int
+	CompressTypes []string
+}
+
+// DefaultCompressTypes are the content types ServerConfig.CompressTypes
+// lists unless configured otherwise.
+var DefaultCompressTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"image/svg+xml",
+}
+
+// Longest returns the longest configured route timeout.
+// The HTTP server's own read and write deadlines must allow for it.
+func (s ServerConfig) Longest() time.Duration {
+	longest := max(s.Timeout, s.HealthTimeout, s.CreateTimeout, s.ReadTimeout)
+	return time.Duration(longest) * time.Second
+}
+
+// SoftLimitConfig sets warning thresholds below the hard limits, as
+// percentages of them. A request past a threshold still succeeds, but its
+// response carries a warning and the warning is counted in the metrics.
+// 0 disables a threshold.
+type SoftLimitConfig struct {
+	// Size is the percentage of [main] sizelimit a paste may reach unwarned
+	Size int
+
+	// Comments is the percentage of [main] commentlimit a discussion may
+	// reach unwarned
+	Comments int
+}
+
+// TokensConfig defines API tokens for paste creation.
+// A token identifies a team sharing the instance: pastes created with it
+// are accounted to it and count against its quotas. Clients sending no
+// token stay anonymous. Each map is keyed by token name.
+type TokensConfig struct {
+	// Secrets maps names to the bearer secrets clients send ([tokens])
+	Secrets map[string]string
+
+	// QuotaPastes caps pastes created per calendar month (UTC)
+	// ([token_quota_pastes]); absent or 0 = unlimited
+	QuotaPastes map[string]int64
+
+	// QuotaBytes caps bytes of pastes and attachments created per calendar
+	// month (UTC) ([token_quota_bytes]); absent or 0 = unlimited
+	QuotaBytes map[string]int64
+
+	// RateLimit and RateBurst replace [traffic] limit and burst for paste
+	// creations with a token ([token_rate_limit], [token_rate_burst]), in
+	// a bucket shared by all of the token's clients; a RateLimit of 0 =
+	// unlimited. Tokens in neither map get the anonymous limits.
+	RateLimit map[string]int64
+	RateBurst map[string]int64
+}
+
+// Enabled reports whether any API token is configured.
+func (t TokensConfig) Enabled() bool {
+	return len(t.Secrets) > 0
+}
+
+// AuthConfig restricts paste creation to authenticated clients, for internal
+// instances where anyone may read pastes but not everyone may post them.
+type AuthConfig struct {
+	// Create is how paste creators authenticate: "" (they don't),
+	// AuthBasic, or AuthOIDC. API tokens ([tokens]) are accepted either way
+	Create string
+
+	// Users maps AuthBasic user names to passwords, or bcrypt hashes of
+	// them ([auth_users])
+	Users map[string]string
+
+	// OIDCIssuer is the issuer URL of AuthOIDC bearer tokens. The keys they
+	// are signed with come from its discovery document
+	OIDCIssuer string
+
+	// OIDCAudience is the audience (client ID) tokens must be issued for
+	OIDCAudience string
+}
+
+// Authentication methods for AuthConfig.Create.
+const (
+	// AuthBasic takes HTTP Basic credentials of [auth_users]
+	AuthBasic = "basic"
+
+	// AuthOIDC takes bearer tokens signed by an OpenID Connect provider
+	AuthOIDC = "oidc"
+)
+
+// MetricsConfig controls the Prometheus /metrics endpoint and the
+// management listener. The same metrics are always available to admins at
+// /admin/metrics.
+type MetricsConfig struct {
+	// Enabled serves /metrics, without authentication unless Token is set
+	Enabled bool
+
+	// Address is a separate host:port to serve /metrics on, keeping it
+	// off the public listener. Empty serves it next to the application.
+	Address string
+
+	// Token, if set, must be presented as a bearer token for /metrics and
+	// /debug (for Prometheus, bearer_token in the scrape config)
+	Token string
+
+	// Debug serves the Go profiler at /debug/pprof/ on Address
+	Debug bool
 }
 
+// TelemetryConfig controls request tracing: spans for requests and the
+// storage operations they make, exported to an OpenTelemetry collector,
+// and Server-Timing response headers breaking down where time went.
+type TelemetryConfig struct {
+	// Enabled exports traces to Endpoint
+	Enabled bool
+
+	// Endpoint is the base URL of an OTLP/HTTP collector; spans are
+	// POSTed to its /v1/traces
+	Endpoint string
+
+	// Headers are "name=value" pairs sent with every export, such as a
+	// collector's API key
+	Headers []string
+
+	// ServiceName identifies this instance's spans (service.name)
+	ServiceName string
+
+	// Sample is the percentage of requests traced; requests whose caller
+	// already decided, in a traceparent header, follow that decision
+	Sample int
+
+	// ServerTiming adds a Server-Timing header to responses with the time
+	// spent in storage, whether or not traces are exported
+	ServerTiming bool
+}
+
+// MinAdminTokenLength is the shortest admin token accepted.
+const MinAdminTokenLength = 16
+
 // DefaultConfig returns a Config with sensible defaults matching PrivateBin.
 // These defaults provide a secure, functional starting point.
 func DefaultConfig() *Config {
@@ -151,6 +1010,7 @@ func DefaultConfig() *Config {
 			FileUpload:               false,
 			BurnAfterReadingSelected: false,
 			SizeLimit:                10 * 1024 * 1024, // 10 MiB
+			AttachmentLimit:          10 * 1024 * 1024, // 10 MiB
 			Template:                 "bootstrap5",
 			LanguageSelection:        false,
 			LanguageDefault:          "en",
@@ -158,6 +1018,13 @@ func DefaultConfig() *Config {
 			Icon:                     "identicon",
 			HTTPWarning:              true,
 			Compression:              "zlib",
+			Moderation:               ModerationOff,
+			ACMECache:                "acme",
+			RedirectPort:             80,
+			HSTSMaxAge:               31536000, // 1 year
+			LogLevel:                 "info",
+			LogFormat:                "text",
+			LogPrivacy:               LogPrivacyDebug,
 		},
 		Expire: ExpireConfig{
 			Default: "1week",
@@ -171,12 +1038,26 @@ func DefaultConfig() *Config {
 				"1year":  365 * 24 * time.Hour,
 				"never":  0, // 0 means no expiration
 			},
+			Order:  []string{"5min", "10min", "1hour", "1day", "1week", "1month", "1year", "never"},
+			Labels: map[string]string{},
 		},
 		Traffic: TrafficConfig{
-			Limit:     10, // 10 seconds between pastes
-			Exempted:  []string{},
-			Creators:  []string{},
-			Header:    "",
+			Limit:    10, // 10 seconds between pastes
+			Exempted: []string{},
+			Creators: []string{},
+			Header:   "",
+
+			Burst:        1,
+			CommentLimit: 10,
+			CommentBurst: 1,
+			ReadLimit:    0, // Reads are unlimited
+			ReadBurst:    10,
+			Store:        TrafficStoreStorage,
+
+			PresetRefresh: 86400, // Daily
+
+			IdempotencyTTL: 86400, // Retries within a day return the original paste
+			SaltRotation:   86400, // A new salt every day (UTC)
 		},
 		Purge: PurgeConfig{
 			Limit:     300, // 5 minutes between purge runs
@@ -187,13 +1068,88 @@ func DefaultConfig() *Config {
 			Driver: "sqlite3",
 			DSN:    "flashpaper.db",
 			Dir:    "data",
+			Region: "us-east-1",
+
+			ProbeInterval: 5,
+			QueryTimeout:  30,
+
+			SQLiteJournalMode: "wal",
+			SQLiteBusyTimeout: 5000,
+			SQLiteSynchronous: "normal",
+		},
+		Security: SecurityConfig{
+			ServerHeader: ServerHeaderNone,
+			FrameOptions: FrameOptionsDeny,
+			CORSMethods:  []string{"GET", "POST", "PUT", "DELETE"},
+			CORSHeaders:  []string{"Content-Type", "X-Requested-With", "Authorization", "X-Access-Proof", "X-Secret-Key", "X-Delete-Token"},
+		},
+		Callback: CallbackConfig{
+			Allowlist: []string{},
+			Timeout:   5,
+		},
+		Server: ServerConfig{
+			Timeout:       60,
+			HealthTimeout: 2,
+			CreateTimeout: 120,
+			ReadTimeout:   30,
+
+			ConnIdleTimeout: 120,
+
+			Compress:      true,
+			CompressLevel: 5,
+			CompressTypes: DefaultCompressTypes,
+		},
+		SoftLimit: SoftLimitConfig{
+			Size:     80,
+			Comments: 80,
+		},
+		Tokens: TokensConfig{
+			Secrets:     map[string]string{},
+			QuotaPastes: map[string]int64{},
+			QuotaBytes:  map[string]int64{},
+			RateLimit:   map[string]int64{},
+			RateBurst:   map[string]int64{},
+		},
+		Auth: AuthConfig{
+			Users: map[string]string{},
+		},
+		API: APIConfig{
+			Secrets:          true,
+			ServerEncryption: true,
+		},
+		Webhook: WebhookConfig{
+			URLs:    []string{},
+			Events:  []string{},
+			Timeout: 5,
+			Retries: 5,
+			Queue:   1000,
+			Workers: 2,
+		},
+		Events: EventsConfig{
+			MaxClients:   1000,
+			Heartbeat:    25,
+			RedisChannel: "flashpaper:events",
+		},
+		Stats: StatsConfig{
+			Cache: 300,
+		},
+		PoW: PoWConfig{
+			Difficulty: 16,
+			TTL:        300,
+		},
+		Telemetry: TelemetryConfig{
+			Endpoint:    "http://localhost:4318",
+			Headers:     []string{},
+			ServiceName: "flashpaper",
+			Sample:      100,
 		},
 	}
 }
 
 // Load reads configuration from an INI file and environment variables.
 // Environment variables override file settings. If the config file doesn't
-// exist, default values are used.
+// exist, default values are used. Unknown, deprecated, and mistyped keys in
+// the file don't fail loading; they are reported in Config.Warnings.
 //
 // Environment variable format: FLASHPAPER_SECTION_KEY
 // Example: FLASHPAPER_MAIN_PORT=9090
@@ -209,6 +1165,7 @@ func Load(path string) (*Config, error) {
 
 	// Override with environment variables
 	cfg.loadFromEnv()
+	cfg.applyTrafficPreset()
 
 	// Validate the final configuration
 	if err := cfg.Validate(); err != nil {
@@ -225,6 +1182,9 @@ func (c *Config) loadFromFile(path string) error {
 		return err
 	}
 
+	// Must run before any sec.Key() lookup, which creates missing keys
+	c.Warnings = checkSchema(iniFile, Schema)
+
 	// [main] section
 	if sec, err := iniFile.GetSection("main"); err == nil {
 		c.Main.Name = sec.Key("name").MustString(c.Main.Name)
@@ -233,17 +1193,45 @@ func (c *Config) loadFromFile(path string) error {
 		c.Main.BasePath = sec.Key("basepath").MustString(c.Main.BasePath)
 		c.Main.Discussion = sec.Key("discussion").MustBool(c.Main.Discussion)
 		c.Main.OpenDiscussion = sec.Key("opendiscussion").MustBool(c.Main.OpenDiscussion)
+		c.Main.CommentLimit = sec.Key("commentlimit").MustInt(c.Main.CommentLimit)
+		c.Main.Moderation = sec.Key("moderation").MustString(c.Main.Moderation)
 		c.Main.Password = sec.Key("password").MustBool(c.Main.Password)
+		c.Main.AccessProof = sec.Key("access_proof").MustBool(c.Main.AccessProof)
+		c.Main.Editable = sec.Key("editable").MustBool(c.Main.Editable)
 		c.Main.FileUpload = sec.Key("fileupload").MustBool(c.Main.FileUpload)
 		c.Main.BurnAfterReadingSelected = sec.Key("burnafterreadingselected").MustBool(c.Main.BurnAfterReadingSelected)
+		c.Main.ForceBurnAfterReading = sec.Key("force_burnafterreading").MustBool(c.Main.ForceBurnAfterReading)
+		c.Main.ForceOpenDiscussion = sec.Key("force_opendiscussion").MustBool(c.Main.ForceOpenDiscussion)
 		c.Main.SizeLimit = sec.Key("sizelimit").MustInt64(c.Main.SizeLimit)
+		c.Main.AttachmentLimit = sec.Key("attachmentlimit").MustInt64(c.Main.AttachmentLimit)
 		c.Main.Template = sec.Key("template").MustString(c.Main.Template)
+		c.Main.TemplateDir = sec.Key("templatedir").MustString(c.Main.TemplateDir)
+		c.Main.StaticDir = sec.Key("staticdir").MustString(c.Main.StaticDir)
+		c.Main.TemplateReload = sec.Key("template_reload").MustBool(c.Main.TemplateReload)
 		c.Main.LanguageSelection = sec.Key("languageselection").MustBool(c.Main.LanguageSelection)
 		c.Main.LanguageDefault = sec.Key("languagedefault").MustString(c.Main.LanguageDefault)
 		c.Main.QRCode = sec.Key("qrcode").MustBool(c.Main.QRCode)
+		c.Main.PublicURL = sec.Key("publicurl").MustString(c.Main.PublicURL)
+		c.Main.URLShortener = sec.Key("urlshortener").MustString(c.Main.URLShortener)
+		c.Main.URLShortenerURL = sec.Key("urlshortener_url").MustString(c.Main.URLShortenerURL)
+		c.Main.URLShortenerSignature = sec.Key("urlshortener_signature").MustString(c.Main.URLShortenerSignature)
 		c.Main.Icon = sec.Key("icon").MustString(c.Main.Icon)
 		c.Main.HTTPWarning = sec.Key("httpwarning").MustBool(c.Main.HTTPWarning)
 		c.Main.Compression = sec.Key("compression").MustString(c.Main.Compression)
+		c.Main.TLSCert = sec.Key("tls_cert").MustString(c.Main.TLSCert)
+		c.Main.TLSKey = sec.Key("tls_key").MustString(c.Main.TLSKey)
+		c.Main.ACME = sec.Key("acme").MustBool(c.Main.ACME)
+		if domains := sec.Key("acme_domains").MustString(""); domains != "" {
+			c.Main.ACMEDomains = splitList(domains)
+		}
+		c.Main.ACMEEmail = sec.Key("acme_email").MustString(c.Main.ACMEEmail)
+		c.Main.ACMECache = sec.Key("acme_cache").MustString(c.Main.ACMECache)
+		c.Main.ACMEDirectory = sec.Key("acme_directory").MustString(c.Main.ACMEDirectory)
+		c.Main.RedirectPort = sec.Key("redirect_port").MustInt(c.Main.RedirectPort)
+		c.Main.HSTSMaxAge = sec.Key("hsts_max_age").MustInt(c.Main.HSTSMaxAge)
+		c.Main.LogLevel = sec.Key("loglevel").MustString(c.Main.LogLevel)
+		c.Main.LogFormat = sec.Key("logformat").MustString(c.Main.LogFormat)
+		c.Main.LogPrivacy = sec.Key("logprivacy").MustString(c.Main.LogPrivacy)
 	}
 
 	// [expire] section
@@ -252,7 +1240,11 @@ func (c *Config) loadFromFile(path string) error {
 	}
 
 	// [expire_options] section - custom expiration times
+	// Options listed here are offered in the order written, ahead of any
+	// built-in options the file doesn't mention.
 	if sec, err := iniFile.GetSection("expire_options"); err == nil {
+		var order []string
+		listed := make(map[string]bool)
 		for _, key := range sec.Keys() {
 			seconds := key.MustInt64(0)
 			if seconds == 0 {
@@ -260,26 +1252,49 @@ func (c *Config) loadFromFile(path string) error {
 			} else {
 				c.Expire.Options[key.Name()] = time.Duration(seconds) * time.Second
 			}
+			order = append(order, key.Name())
+			listed[key.Name()] = true
+		}
+		for _, name := range c.Expire.Order {
+			if !listed[name] {
+				order = append(order, name)
+			}
+		}
+		c.Expire.Order = order
+	}
+
+	// [expire_labels] section - display labels for expiration options
+	if sec, err := iniFile.GetSection("expire_labels"); err == nil {
+		for _, key := range sec.Keys() {
+			c.Expire.Labels[key.Name()] = key.String()
 		}
 	}
 
 	// [traffic] section
 	if sec, err := iniFile.GetSection("traffic"); err == nil {
 		c.Traffic.Limit = sec.Key("limit").MustInt(c.Traffic.Limit)
+		c.Traffic.Burst = sec.Key("burst").MustInt(c.Traffic.Burst)
+		c.Traffic.CommentLimit = sec.Key("comment_limit").MustInt(c.Traffic.CommentLimit)
+		c.Traffic.CommentBurst = sec.Key("comment_burst").MustInt(c.Traffic.CommentBurst)
+		c.Traffic.ReadLimit = sec.Key("read_limit").MustInt(c.Traffic.ReadLimit)
+		c.Traffic.ReadBurst = sec.Key("read_burst").MustInt(c.Traffic.ReadBurst)
+		c.Traffic.Store = sec.Key("store").MustString(c.Traffic.Store)
 		c.Traffic.Header = sec.Key("header").MustString(c.Traffic.Header)
+		c.Traffic.Preset = sec.Key("preset").MustString(c.Traffic.Preset)
+		c.Traffic.PresetRefresh = sec.Key("preset_refresh").MustInt(c.Traffic.PresetRefresh)
+		c.Traffic.DownloadRate = sec.Key("download_rate").MustInt64(c.Traffic.DownloadRate)
+		c.Traffic.DownloadRateGlobal = sec.Key("download_rate_global").MustInt64(c.Traffic.DownloadRateGlobal)
+		c.Traffic.IdempotencyTTL = sec.Key("idempotency_ttl").MustInt(c.Traffic.IdempotencyTTL)
+		c.Traffic.SaltRotation = sec.Key("salt_rotation").MustInt(c.Traffic.SaltRotation)
 
 		if exempted := sec.Key("exempted").MustString(""); exempted != "" {
-			c.Traffic.Exempted = strings.Split(exempted, ",")
-			for i := range c.Traffic.Exempted {
-				c.Traffic.Exempted[i] = strings.TrimSpace(c.Traffic.Exempted[i])
-			}
+			c.Traffic.Exempted = splitList(exempted)
 		}
-
 		if creators := sec.Key("creators").MustString(""); creators != "" {
-			c.Traffic.Creators = strings.Split(creators, ",")
-			for i := range c.Traffic.Creators {
-				c.Traffic.Creators[i] = strings.TrimSpace(c.Traffic.Creators[i])
-			}
+			c.Traffic.Creators = splitList(creators)
+		}
+		if proxies := sec.Key("trusted_proxies").MustString(""); proxies != "" {
+			c.Traffic.TrustedProxies = splitList(proxies)
 		}
 	}
 
@@ -295,73 +1310,894 @@ func (c *Config) loadFromFile(path string) error {
 		c.Model.Driver = sec.Key("driver").MustString(c.Model.Driver)
 		c.Model.DSN = sec.Key("dsn").MustString(c.Model.DSN)
 		c.Model.Dir = sec.Key("dir").MustString(c.Model.Dir)
+		c.Model.SRV = sec.Key("srv").MustString(c.Model.SRV)
+		c.Model.ProbeInterval = sec.Key("probe_interval").MustInt(c.Model.ProbeInterval)
+		c.Model.NoMigrate = sec.Key("no_migrate").MustBool(c.Model.NoMigrate)
+		c.Model.Bucket = sec.Key("bucket").MustString(c.Model.Bucket)
+		c.Model.Region = sec.Key("region").MustString(c.Model.Region)
+		c.Model.Endpoint = sec.Key("endpoint").MustString(c.Model.Endpoint)
+		c.Model.Prefix = sec.Key("prefix").MustString(c.Model.Prefix)
+		c.Model.PathStyle = sec.Key("path_style").MustBool(c.Model.PathStyle)
+		c.Model.AccessKey = sec.Key("access_key").MustString(c.Model.AccessKey)
+		c.Model.SecretKey = sec.Key("secret_key").MustString(c.Model.SecretKey)
+		c.Model.EncryptionKeys = sec.Key("encryption_keys").MustString(c.Model.EncryptionKeys)
+		c.Model.EncryptionKeysFile = sec.Key("encryption_keys_file").MustString(c.Model.EncryptionKeysFile)
+		c.Model.Shadow = sec.Key("shadow").MustString(c.Model.Shadow)
+		c.Model.QueryTimeout = sec.Key("query_timeout").MustInt(c.Model.QueryTimeout)
+		c.Model.MaxOpenConns = sec.Key("max_open_conns").MustInt(c.Model.MaxOpenConns)
+		c.Model.MaxIdleConns = sec.Key("max_idle_conns").MustInt(c.Model.MaxIdleConns)
+		c.Model.ConnMaxLifetime = sec.Key("conn_max_lifetime").MustInt(c.Model.ConnMaxLifetime)
+		c.Model.SQLiteJournalMode = sec.Key("sqlite_journal_mode").MustString(c.Model.SQLiteJournalMode)
+		c.Model.SQLiteBusyTimeout = sec.Key("sqlite_busy_timeout").MustInt(c.Model.SQLiteBusyTimeout)
+		c.Model.SQLiteSynchronous = sec.Key("sqlite_synchronous").MustString(c.Model.SQLiteSynchronous)
 	}
 
-	return nil
-}
-
-// loadFromEnv overrides configuration with environment variables.
-// Format: FLASHPAPER_SECTION_KEY (e.g., FLASHPAPER_MAIN_PORT)
-func (c *Config) loadFromEnv() {
-	// Main section
-	if v := os.Getenv("FLASHPAPER_MAIN_NAME"); v != "" {
-		c.Main.Name = v
-	}
-	if v := os.Getenv("FLASHPAPER_MAIN_HOST"); v != "" {
-		c.Main.Host = v
-	}
-	if v := os.Getenv("FLASHPAPER_MAIN_PORT"); v != "" {
-		if port, err := strconv.Atoi(v); err == nil {
-			c.Main.Port = port
+	// [security] section
+	if sec, err := iniFile.GetSection("security"); err == nil {
+		c.Security.ServerHeader = sec.Key("server_header").MustString(c.Security.ServerHeader)
+		if denylist := sec.Key("denylist").MustString(""); denylist != "" {
+			c.Security.Denylist = splitList(denylist)
 		}
-	}
-	if v := os.Getenv("FLASHPAPER_MAIN_BASEPATH"); v != "" {
-		c.Main.BasePath = v
-	}
-	if v := os.Getenv("FLASHPAPER_MAIN_SIZELIMIT"); v != "" {
-		if size, err := strconv.ParseInt(v, 10, 64); err == nil {
-			c.Main.SizeLimit = size
+		c.Security.DenylistReads = sec.Key("denylist_reads").MustBool(c.Security.DenylistReads)
+		c.Security.Tarpit = sec.Key("tarpit").MustBool(c.Security.Tarpit)
+		c.Security.CSP = sec.Key("csp").MustString(c.Security.CSP)
+		c.Security.CSPNonce = sec.Key("csp_nonce").MustBool(c.Security.CSPNonce)
+		c.Security.FrameOptions = sec.Key("frame_options").MustString(c.Security.FrameOptions)
+		c.Security.HSTSIncludeSubdomains = sec.Key("hsts_include_subdomains").MustBool(c.Security.HSTSIncludeSubdomains)
+		c.Security.HSTSPreload = sec.Key("hsts_preload").MustBool(c.Security.HSTSPreload)
+		c.Security.COOP = sec.Key("coop").MustString(c.Security.COOP)
+		c.Security.COEP = sec.Key("coep").MustString(c.Security.COEP)
+		c.Security.CORP = sec.Key("corp").MustString(c.Security.CORP)
+		if origins := sec.Key("cors_origins").MustString(""); origins != "" {
+			c.Security.CORSOrigins = splitList(origins)
+		}
+		if methods := sec.Key("cors_methods").MustString(""); methods != "" {
+			c.Security.CORSMethods = splitList(methods)
+		}
+		if headers := sec.Key("cors_headers").MustString(""); headers != "" {
+			c.Security.CORSHeaders = splitList(headers)
 		}
 	}
 
-	// Model section (storage backend)
-	if v := os.Getenv("FLASHPAPER_MODEL_CLASS"); v != "" {
-		c.Model.Class = v
-	}
-	if v := os.Getenv("FLASHPAPER_MODEL_DRIVER"); v != "" {
-		c.Model.Driver = v
-	}
-	if v := os.Getenv("FLASHPAPER_MODEL_DSN"); v != "" {
-		c.Model.DSN = v
+	// [callback] section
+	if sec, err := iniFile.GetSection("callback"); err == nil {
+		c.Callback.Timeout = sec.Key("timeout").MustInt(c.Callback.Timeout)
+
+		if allowlist := sec.Key("allowlist").MustString(""); allowlist != "" {
+			c.Callback.Allowlist = splitList(allowlist)
+		}
 	}
-	if v := os.Getenv("FLASHPAPER_MODEL_DIR"); v != "" {
-		c.Model.Dir = v
+
+	// [webhook] section
+	if sec, err := iniFile.GetSection("webhook"); err == nil {
+		c.Webhook.Secret = sec.Key("secret").MustString(c.Webhook.Secret)
+		c.Webhook.Timeout = sec.Key("timeout").MustInt(c.Webhook.Timeout)
+		c.Webhook.Retries = sec.Key("retries").MustInt(c.Webhook.Retries)
+		c.Webhook.Queue = sec.Key("queue").MustInt(c.Webhook.Queue)
+		c.Webhook.Workers = sec.Key("workers").MustInt(c.Webhook.Workers)
+
+		if urls := sec.Key("urls").MustString(""); urls != "" {
+			c.Webhook.URLs = splitList(urls)
+		}
+		if events := sec.Key("events").MustString(""); events != "" {
+			c.Webhook.Events = splitList(events)
+		}
 	}
 
-	// Shorthand environment variables for Docker compatibility
-	if v := os.Getenv("FLASHPAPER_DB_TYPE"); v != "" {
-		c.Model.Class = "Database"
-		c.Model.Driver = v
+	// [admin] section
+	if sec, err := iniFile.GetSection("admin"); err == nil {
+		c.Admin.Token = sec.Key("token").MustString(c.Admin.Token)
+		c.Admin.SignedTokens = sec.Key("signed_tokens").MustBool(c.Admin.SignedTokens)
 	}
-	if v := os.Getenv("FLASHPAPER_DB_HOST"); v != "" {
-		// Construct DSN based on driver type
-		c.updateDSNFromEnv()
+
+	// [tos] section
+	if sec, err := iniFile.GetSection("tos"); err == nil {
+		c.TOS.File = sec.Key("file").MustString(c.TOS.File)
+		c.TOS.Required = sec.Key("required").MustBool(c.TOS.Required)
 	}
 
-	// Traffic section
-	if v := os.Getenv("FLASHPAPER_TRAFFIC_LIMIT"); v != "" {
-		if limit, err := strconv.Atoi(v); err == nil {
-			c.Traffic.Limit = limit
+	// [server] section
+	if sec, err := iniFile.GetSection("server"); err == nil {
+		c.Server.Timeout = sec.Key("timeout").MustInt(c.Server.Timeout)
+		c.Server.HealthTimeout = sec.Key("timeout_health").MustInt(c.Server.HealthTimeout)
+		c.Server.CreateTimeout = sec.Key("timeout_create").MustInt(c.Server.CreateTimeout)
+		c.Server.ReadTimeout = sec.Key("timeout_read").MustInt(c.Server.ReadTimeout)
+		c.Server.MaxReads = sec.Key("max_concurrent_reads").MustInt(c.Server.MaxReads)
+		c.Server.MaxWrites = sec.Key("max_concurrent_writes").MustInt(c.Server.MaxWrites)
+		c.Server.ConnReadTimeout = sec.Key("conn_read_timeout").MustInt(c.Server.ConnReadTimeout)
+		c.Server.ConnHeaderTimeout = sec.Key("conn_header_timeout").MustInt(c.Server.ConnHeaderTimeout)
+		c.Server.ConnWriteTimeout = sec.Key("conn_write_timeout").MustInt(c.Server.ConnWriteTimeout)
+		c.Server.ConnIdleTimeout = sec.Key("conn_idle_timeout").MustInt(c.Server.ConnIdleTimeout)
+		c.Server.H2C = sec.Key("h2c").MustBool(c.Server.H2C)
+		c.Server.Compress = sec.Key("compress").MustBool(c.Server.Compress)
+		c.Server.CompressLevel = sec.Key("compress_level").MustInt(c.Server.CompressLevel)
+		if types := sec.Key("compress_types").MustString(""); types != "" {
+			c.Server.CompressTypes = splitList(types)
 		}
 	}
 
-	// Purge section
-	if v := os.Getenv("FLASHPAPER_PURGE_LIMIT"); v != "" {
-		if limit, err := strconv.Atoi(v); err == nil {
-			c.Purge.Limit = limit
+	// [tokens], [token_quota_pastes], [token_quota_bytes],
+	// [token_rate_limit], [token_rate_burst] sections, each keyed by token
+	// name
+	if sec, err := iniFile.GetSection("tokens"); err == nil {
+		for _, key := range sec.Keys() {
+			c.Tokens.Secrets[key.Name()] = key.String()
 		}
 	}
-}
+	if sec, err := iniFile.GetSection("token_quota_pastes"); err == nil {
+		for _, key := range sec.Keys() {
+			c.Tokens.QuotaPastes[key.Name()] = key.MustInt64(0)
+		}
+	}
+	if sec, err := iniFile.GetSection("token_quota_bytes"); err == nil {
+		for _, key := range sec.Keys() {
+			c.Tokens.QuotaBytes[key.Name()] = key.MustInt64(0)
+		}
+	}
+	if sec, err := iniFile.GetSection("token_rate_limit"); err == nil {
+		for _, key := range sec.Keys() {
+			c.Tokens.RateLimit[key.Name()] = key.MustInt64(0)
+		}
+	}
+	if sec, err := iniFile.GetSection("token_rate_burst"); err == nil {
+		for _, key := range sec.Keys() {
+			c.Tokens.RateBurst[key.Name()] = key.MustInt64(0)
+		}
+	}
+
+	// [auth] and [auth_users] sections
+	if sec, err := iniFile.GetSection("auth"); err == nil {
+		c.Auth.Create = sec.Key("create").MustString(c.Auth.Create)
+		c.Auth.OIDCIssuer = sec.Key("oidc_issuer").MustString(c.Auth.OIDCIssuer)
+		c.Auth.OIDCAudience = sec.Key("oidc_audience").MustString(c.Auth.OIDCAudience)
+	}
+	if sec, err := iniFile.GetSection("auth_users"); err == nil {
+		for _, key := range sec.Keys() {
+			c.Auth.Users[key.Name()] = key.String()
+		}
+	}
+
+	// [instance] section
+	if sec, err := iniFile.GetSection("instance"); err == nil {
+		c.Instance.Description = sec.Key("description").MustString(c.Instance.Description)
+		c.Instance.Contact = sec.Key("contact").MustString(c.Instance.Contact)
+		c.Instance.PublicKey = sec.Key("public_key").MustString(c.Instance.PublicKey)
+	}
+
+	// [api] section
+	if sec, err := iniFile.GetSection("api"); err == nil {
+		c.API.Secrets = sec.Key("secrets").MustBool(c.API.Secrets)
+		c.API.ServerEncryption = sec.Key("server_encryption").MustBool(c.API.ServerEncryption)
+	}
+
+	// [events] section
+	if sec, err := iniFile.GetSection("events"); err == nil {
+		c.Events.Enabled = sec.Key("enabled").MustBool(c.Events.Enabled)
+		c.Events.MaxClients = sec.Key("max_clients").MustInt(c.Events.MaxClients)
+		c.Events.Heartbeat = sec.Key("heartbeat").MustInt(c.Events.Heartbeat)
+		c.Events.Redis = sec.Key("redis").MustString(c.Events.Redis)
+		c.Events.RedisPassword = sec.Key("redis_password").MustString(c.Events.RedisPassword)
+		c.Events.RedisChannel = sec.Key("redis_channel").MustString(c.Events.RedisChannel)
+	}
+
+	// [stats] section
+	if sec, err := iniFile.GetSection("stats"); err == nil {
+		c.Stats.Enabled = sec.Key("enabled").MustBool(c.Stats.Enabled)
+		c.Stats.Cache = sec.Key("cache").MustInt(c.Stats.Cache)
+	}
+
+	// [pow] section
+	if sec, err := iniFile.GetSection("pow"); err == nil {
+		c.PoW.Enabled = sec.Key("enabled").MustBool(c.PoW.Enabled)
+		c.PoW.Difficulty = sec.Key("difficulty").MustInt(c.PoW.Difficulty)
+		c.PoW.TTL = sec.Key("ttl").MustInt(c.PoW.TTL)
+	}
+
+	// [captcha] section
+	if sec, err := iniFile.GetSection("captcha"); err == nil {
+		c.Captcha.Provider = sec.Key("provider").MustString(c.Captcha.Provider)
+		c.Captcha.SiteKey = sec.Key("sitekey").MustString(c.Captcha.SiteKey)
+		c.Captcha.Secret = sec.Key("secret").MustString(c.Captcha.Secret)
+	}
+
+	// [maintenance] section
+	if sec, err := iniFile.GetSection("maintenance"); err == nil {
+		c.Maintenance.Enabled = sec.Key("enabled").MustBool(c.Maintenance.Enabled)
+		c.Maintenance.ReadOnly = sec.Key("readonly").MustBool(c.Maintenance.ReadOnly)
+		c.Maintenance.Message = sec.Key("message").MustString(c.Maintenance.Message)
+	}
+
+	// [metrics] section
+	if sec, err := iniFile.GetSection("metrics"); err == nil {
+		c.Metrics.Enabled = sec.Key("enabled").MustBool(c.Metrics.Enabled)
+		c.Metrics.Address = sec.Key("address").MustString(c.Metrics.Address)
+		c.Metrics.Token = sec.Key("token").MustString(c.Metrics.Token)
+		c.Metrics.Debug = sec.Key("debug").MustBool(c.Metrics.Debug)
+	}
+
+	// [telemetry] section
+	if sec, err := iniFile.GetSection("telemetry"); err == nil {
+		c.Telemetry.Enabled = sec.Key("enabled").MustBool(c.Telemetry.Enabled)
+		c.Telemetry.Endpoint = sec.Key("endpoint").MustString(c.Telemetry.Endpoint)
+		c.Telemetry.ServiceName = sec.Key("service_name").MustString(c.Telemetry.ServiceName)
+		c.Telemetry.Sample = sec.Key("sample").MustInt(c.Telemetry.Sample)
+		c.Telemetry.ServerTiming = sec.Key("server_timing").MustBool(c.Telemetry.ServerTiming)
+
+		if headers := sec.Key("headers").MustString(""); headers != "" {
+			c.Telemetry.Headers = splitList(headers)
+		}
+	}
+
+	// [softlimit] section
+	if sec, err := iniFile.GetSection("softlimit"); err == nil {
+		c.SoftLimit.Size = sec.Key("size").MustInt(c.SoftLimit.Size)
+		c.SoftLimit.Comments = sec.Key("comments").MustInt(c.SoftLimit.Comments)
+	}
+
+	return nil
+}
+
+// loadFromEnv overrides configuration with environment variables.
+// Format: FLASHPAPER_SECTION_KEY (e.g., FLASHPAPER_MAIN_PORT)
+func (c *Config) loadFromEnv() {
+	// Main section
+	if v := os.Getenv("FLASHPAPER_MAIN_NAME"); v != "" {
+		c.Main.Name = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_HOST"); v != "" {
+		c.Main.Host = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err == nil {
+			c.Main.Port = port
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_BASEPATH"); v != "" {
+		c.Main.BasePath = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_SIZELIMIT"); v != "" {
+		if size, err := strconv.ParseInt(v, 10, 64); err == nil {
+			c.Main.SizeLimit = size
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_ATTACHMENTLIMIT"); v != "" {
+		if size, err := strconv.ParseInt(v, 10, 64); err == nil {
+			c.Main.AttachmentLimit = size
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_TEMPLATE"); v != "" {
+		c.Main.Template = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_TEMPLATEDIR"); v != "" {
+		c.Main.TemplateDir = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_STATICDIR"); v != "" {
+		c.Main.StaticDir = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_TEMPLATE_RELOAD"); v != "" {
+		c.Main.TemplateReload = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_LANGUAGESELECTION"); v != "" {
+		c.Main.LanguageSelection = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_LANGUAGEDEFAULT"); v != "" {
+		c.Main.LanguageDefault = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_MODERATION"); v != "" {
+		c.Main.Moderation = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_FORCE_BURNAFTERREADING"); v != "" {
+		c.Main.ForceBurnAfterReading = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_FORCE_OPENDISCUSSION"); v != "" {
+		c.Main.ForceOpenDiscussion = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_TLS_CERT"); v != "" {
+		c.Main.TLSCert = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_TLS_KEY"); v != "" {
+		c.Main.TLSKey = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_ACME"); v != "" {
+		c.Main.ACME = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_ACME_DOMAINS"); v != "" {
+		c.Main.ACMEDomains = splitList(v)
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_ACME_EMAIL"); v != "" {
+		c.Main.ACMEEmail = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_ACME_CACHE"); v != "" {
+		c.Main.ACMECache = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_ACME_DIRECTORY"); v != "" {
+		c.Main.ACMEDirectory = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_REDIRECT_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err == nil {
+			c.Main.RedirectPort = port
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_ACCESS_PROOF"); v != "" {
+		c.Main.AccessProof = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_EDITABLE"); v != "" {
+		c.Main.Editable = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_ICON"); v != "" {
+		c.Main.Icon = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_PUBLICURL"); v != "" {
+		c.Main.PublicURL = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_URLSHORTENER"); v != "" {
+		c.Main.URLShortener = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_URLSHORTENER_URL"); v != "" {
+		c.Main.URLShortenerURL = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_URLSHORTENER_SIGNATURE"); v != "" {
+		c.Main.URLShortenerSignature = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_LOGLEVEL"); v != "" {
+		c.Main.LogLevel = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_LOGFORMAT"); v != "" {
+		c.Main.LogFormat = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_LOGPRIVACY"); v != "" {
+		c.Main.LogPrivacy = v
+	}
+	if v := os.Getenv("FLASHPAPER_MAIN_HSTS_MAX_AGE"); v != "" {
+		if age, err := strconv.Atoi(v); err == nil {
+			c.Main.HSTSMaxAge = age
+		}
+	}
+
+	// Model section (storage backend)
+	if v := os.Getenv("FLASHPAPER_MODEL_CLASS"); v != "" {
+		c.Model.Class = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_DRIVER"); v != "" {
+		c.Model.Driver = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_DSN"); v != "" {
+		c.Model.DSN = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_DIR"); v != "" {
+		c.Model.Dir = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_SRV"); v != "" {
+		c.Model.SRV = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_PROBE_INTERVAL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Model.ProbeInterval = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_NO_MIGRATE"); v != "" {
+		c.Model.NoMigrate = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_BUCKET"); v != "" {
+		c.Model.Bucket = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_REGION"); v != "" {
+		c.Model.Region = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_ENDPOINT"); v != "" {
+		c.Model.Endpoint = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_PREFIX"); v != "" {
+		c.Model.Prefix = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_PATH_STYLE"); v != "" {
+		c.Model.PathStyle = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_ACCESS_KEY"); v != "" {
+		c.Model.AccessKey = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_SECRET_KEY"); v != "" {
+		c.Model.SecretKey = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_ENCRYPTION_KEYS"); v != "" {
+		c.Model.EncryptionKeys = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_ENCRYPTION_KEYS_FILE"); v != "" {
+		c.Model.EncryptionKeysFile = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_SHADOW"); v != "" {
+		c.Model.Shadow = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_QUERY_TIMEOUT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Model.QueryTimeout = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_MAX_OPEN_CONNS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Model.MaxOpenConns = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_MAX_IDLE_CONNS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Model.MaxIdleConns = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_CONN_MAX_LIFETIME"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Model.ConnMaxLifetime = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_SQLITE_JOURNAL_MODE"); v != "" {
+		c.Model.SQLiteJournalMode = v
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_SQLITE_BUSY_TIMEOUT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Model.SQLiteBusyTimeout = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_MODEL_SQLITE_SYNCHRONOUS"); v != "" {
+		c.Model.SQLiteSynchronous = v
+	}
+
+	// Shorthand environment variables for Docker compatibility
+	if v := os.Getenv("FLASHPAPER_DB_TYPE"); v != "" {
+		c.Model.Class = "Database"
+		c.Model.Driver = v
+	}
+	if v := os.Getenv("FLASHPAPER_DB_HOST"); v != "" {
+		// Construct DSN based on driver type
+		c.updateDSNFromEnv()
+	}
+
+	// Traffic section
+	if v := os.Getenv("FLASHPAPER_TRAFFIC_LIMIT"); v != "" {
+		if limit, err := strconv.Atoi(v); err == nil {
+			c.Traffic.Limit = limit
+		}
+	}
+	for _, setting := range []struct {
+		env   string
+		value *int
+	}{
+		{"FLASHPAPER_TRAFFIC_BURST", &c.Traffic.Burst},
+		{"FLASHPAPER_TRAFFIC_COMMENT_LIMIT", &c.Traffic.CommentLimit},
+		{"FLASHPAPER_TRAFFIC_COMMENT_BURST", &c.Traffic.CommentBurst},
+		{"FLASHPAPER_TRAFFIC_READ_LIMIT", &c.Traffic.ReadLimit},
+		{"FLASHPAPER_TRAFFIC_READ_BURST", &c.Traffic.ReadBurst},
+		{"FLASHPAPER_TRAFFIC_PRESET_REFRESH", &c.Traffic.PresetRefresh},
+		{"FLASHPAPER_TRAFFIC_SALT_ROTATION", &c.Traffic.SaltRotation},
+	} {
+		if v := os.Getenv(setting.env); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				*setting.value = n
+			}
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_TRAFFIC_STORE"); v != "" {
+		c.Traffic.Store = v
+	}
+	if v := os.Getenv("FLASHPAPER_TRAFFIC_PRESET"); v != "" {
+		c.Traffic.Preset = v
+	}
+	if v := os.Getenv("FLASHPAPER_TRAFFIC_DOWNLOAD_RATE"); v != "" {
+		if rate, err := strconv.ParseInt(v, 10, 64); err == nil {
+			c.Traffic.DownloadRate = rate
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_TRAFFIC_DOWNLOAD_RATE_GLOBAL"); v != "" {
+		if rate, err := strconv.ParseInt(v, 10, 64); err == nil {
+			c.Traffic.DownloadRateGlobal = rate
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_TRAFFIC_IDEMPOTENCY_TTL"); v != "" {
+		if ttl, err := strconv.Atoi(v); err == nil {
+			c.Traffic.IdempotencyTTL = ttl
+		}
+	}
+
+	// Purge section
+	if v := os.Getenv("FLASHPAPER_PURGE_LIMIT"); v != "" {
+		if limit, err := strconv.Atoi(v); err == nil {
+			c.Purge.Limit = limit
+		}
+	}
+
+	// Security section
+	if v := os.Getenv("FLASHPAPER_SECURITY_SERVER_HEADER"); v != "" {
+		c.Security.ServerHeader = v
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_DENYLIST"); v != "" {
+		c.Security.Denylist = splitList(v)
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_DENYLIST_READS"); v != "" {
+		c.Security.DenylistReads = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_TARPIT"); v != "" {
+		c.Security.Tarpit = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_CSP"); v != "" {
+		c.Security.CSP = v
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_CSP_NONCE"); v != "" {
+		c.Security.CSPNonce = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_FRAME_OPTIONS"); v != "" {
+		c.Security.FrameOptions = v
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_HSTS_INCLUDE_SUBDOMAINS"); v != "" {
+		c.Security.HSTSIncludeSubdomains = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_HSTS_PRELOAD"); v != "" {
+		c.Security.HSTSPreload = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_COOP"); v != "" {
+		c.Security.COOP = v
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_COEP"); v != "" {
+		c.Security.COEP = v
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_CORP"); v != "" {
+		c.Security.CORP = v
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_CORS_ORIGINS"); v != "" {
+		c.Security.CORSOrigins = splitList(v)
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_CORS_METHODS"); v != "" {
+		c.Security.CORSMethods = splitList(v)
+	}
+	if v := os.Getenv("FLASHPAPER_SECURITY_CORS_HEADERS"); v != "" {
+		c.Security.CORSHeaders = splitList(v)
+	}
+
+	// Callback section
+	if v := os.Getenv("FLASHPAPER_CALLBACK_ALLOWLIST"); v != "" {
+		c.Callback.Allowlist = splitList(v)
+	}
+	if v := os.Getenv("FLASHPAPER_CALLBACK_TIMEOUT"); v != "" {
+		if timeout, err := strconv.Atoi(v); err == nil {
+			c.Callback.Timeout = timeout
+		}
+	}
+
+	// Webhook section
+	if v := os.Getenv("FLASHPAPER_WEBHOOK_URLS"); v != "" {
+		c.Webhook.URLs = splitList(v)
+	}
+	if v := os.Getenv("FLASHPAPER_WEBHOOK_SECRET"); v != "" {
+		c.Webhook.Secret = v
+	}
+	if v := os.Getenv("FLASHPAPER_WEBHOOK_EVENTS"); v != "" {
+		c.Webhook.Events = splitList(v)
+	}
+	for env, dst := range map[string]*int{
+		"FLASHPAPER_WEBHOOK_TIMEOUT": &c.Webhook.Timeout,
+		"FLASHPAPER_WEBHOOK_RETRIES": &c.Webhook.Retries,
+		"FLASHPAPER_WEBHOOK_QUEUE":   &c.Webhook.Queue,
+		"FLASHPAPER_WEBHOOK_WORKERS": &c.Webhook.Workers,
+	} {
+		if v := os.Getenv(env); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				*dst = n
+			}
+		}
+	}
+
+	// Admin section
+	if v := os.Getenv("FLASHPAPER_ADMIN_TOKEN"); v != "" {
+		c.Admin.Token = v
+	}
+	if v := os.Getenv("FLASHPAPER_ADMIN_SIGNED_TOKENS"); v != "" {
+		c.Admin.SignedTokens = v == "true" || v == "1"
+	}
+
+	// Terms of service section
+	if v := os.Getenv("FLASHPAPER_TOS_FILE"); v != "" {
+		c.TOS.File = v
+	}
+	if v := os.Getenv("FLASHPAPER_TOS_REQUIRED"); v != "" {
+		c.TOS.Required = v == "true" || v == "1"
+	}
+
+	// Server section
+	serverInts := map[string]*int{
+		"FLASHPAPER_SERVER_TIMEOUT":               &c.Server.Timeout,
+		"FLASHPAPER_SERVER_TIMEOUT_HEALTH":        &c.Server.HealthTimeout,
+		"FLASHPAPER_SERVER_TIMEOUT_CREATE":        &c.Server.CreateTimeout,
+		"FLASHPAPER_SERVER_TIMEOUT_READ":          &c.Server.ReadTimeout,
+		"FLASHPAPER_SERVER_MAX_CONCURRENT_READS":  &c.Server.MaxReads,
+		"FLASHPAPER_SERVER_MAX_CONCURRENT_WRITES": &c.Server.MaxWrites,
+		"FLASHPAPER_SERVER_CONN_READ_TIMEOUT":     &c.Server.ConnReadTimeout,
+		"FLASHPAPER_SERVER_CONN_HEADER_TIMEOUT":   &c.Server.ConnHeaderTimeout,
+		"FLASHPAPER_SERVER_CONN_WRITE_TIMEOUT":    &c.Server.ConnWriteTimeout,
+		"FLASHPAPER_SERVER_CONN_IDLE_TIMEOUT":     &c.Server.ConnIdleTimeout,
+		"FLASHPAPER_SERVER_COMPRESS_LEVEL":        &c.Server.CompressLevel,
+	}
+	for name, target := range serverInts {
+		if v := os.Getenv(name); v != "" {
+			if seconds, err := strconv.Atoi(v); err == nil {
+				*target = seconds
+			}
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_SERVER_H2C"); v != "" {
+		c.Server.H2C = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_SERVER_COMPRESS"); v != "" {
+		c.Server.Compress = v == "true" || v == "1"
+	}
+
+	// API tokens: FLASHPAPER_TOKENS_<NAME>=secret keeps secrets out of files
+	for _, env := range os.Environ() {
+		name, secret, _ := strings.Cut(env, "=")
+		if name, ok := strings.CutPrefix(name, "FLASHPAPER_TOKENS_"); ok && name != "" && secret != "" {
+			c.Tokens.Secrets[strings.ToLower(name)] = secret
+		}
+	}
+
+	// Auth section; FLASHPAPER_AUTH_USERS_<NAME>=password adds users
+	if v := os.Getenv("FLASHPAPER_AUTH_CREATE"); v != "" {
+		c.Auth.Create = v
+	}
+	if v := os.Getenv("FLASHPAPER_AUTH_OIDC_ISSUER"); v != "" {
+		c.Auth.OIDCIssuer = v
+	}
+	if v := os.Getenv("FLASHPAPER_AUTH_OIDC_AUDIENCE"); v != "" {
+		c.Auth.OIDCAudience = v
+	}
+	for _, env := range os.Environ() {
+		name, password, _ := strings.Cut(env, "=")
+		if name, ok := strings.CutPrefix(name, "FLASHPAPER_AUTH_USERS_"); ok && name != "" && password != "" {
+			c.Auth.Users[strings.ToLower(name)] = password
+		}
+	}
+
+	// Instance section
+	if v := os.Getenv("FLASHPAPER_INSTANCE_DESCRIPTION"); v != "" {
+		c.Instance.Description = v
+	}
+	if v := os.Getenv("FLASHPAPER_INSTANCE_CONTACT"); v != "" {
+		c.Instance.Contact = v
+	}
+	if v := os.Getenv("FLASHPAPER_INSTANCE_PUBLIC_KEY"); v != "" {
+		c.Instance.PublicKey = v
+	}
+
+	// API section
+	if v := os.Getenv("FLASHPAPER_API_SECRETS"); v != "" {
+		c.API.Secrets = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_API_SERVER_ENCRYPTION"); v != "" {
+		c.API.ServerEncryption = v == "true" || v == "1"
+	}
+
+	// Events section
+	if v := os.Getenv("FLASHPAPER_EVENTS_ENABLED"); v != "" {
+		c.Events.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_EVENTS_MAX_CLIENTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Events.MaxClients = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_EVENTS_HEARTBEAT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Events.Heartbeat = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_EVENTS_REDIS"); v != "" {
+		c.Events.Redis = v
+	}
+	if v := os.Getenv("FLASHPAPER_EVENTS_REDIS_PASSWORD"); v != "" {
+		c.Events.RedisPassword = v
+	}
+	if v := os.Getenv("FLASHPAPER_EVENTS_REDIS_CHANNEL"); v != "" {
+		c.Events.RedisChannel = v
+	}
+
+	// Stats section
+	if v := os.Getenv("FLASHPAPER_STATS_ENABLED"); v != "" {
+		c.Stats.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_STATS_CACHE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Stats.Cache = n
+		}
+	}
+
+	// PoW section
+	if v := os.Getenv("FLASHPAPER_POW_ENABLED"); v != "" {
+		c.PoW.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_POW_DIFFICULTY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.PoW.Difficulty = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_POW_TTL"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.PoW.TTL = n
+		}
+	}
+
+	// Captcha section
+	if v := os.Getenv("FLASHPAPER_CAPTCHA_PROVIDER"); v != "" {
+		c.Captcha.Provider = v
+	}
+	if v := os.Getenv("FLASHPAPER_CAPTCHA_SITEKEY"); v != "" {
+		c.Captcha.SiteKey = v
+	}
+	if v := os.Getenv("FLASHPAPER_CAPTCHA_SECRET"); v != "" {
+		c.Captcha.Secret = v
+	}
+
+	// Maintenance section
+	if v := os.Getenv("FLASHPAPER_MAINTENANCE_ENABLED"); v != "" {
+		c.Maintenance.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAINTENANCE_READONLY"); v != "" {
+		c.Maintenance.ReadOnly = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_MAINTENANCE_MESSAGE"); v != "" {
+		c.Maintenance.Message = v
+	}
+
+	// Metrics section
+	if v := os.Getenv("FLASHPAPER_METRICS_ENABLED"); v != "" {
+		c.Metrics.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_METRICS_ADDRESS"); v != "" {
+		c.Metrics.Address = v
+	}
+	if v := os.Getenv("FLASHPAPER_METRICS_TOKEN"); v != "" {
+		c.Metrics.Token = v
+	}
+	if v := os.Getenv("FLASHPAPER_METRICS_DEBUG"); v != "" {
+		c.Metrics.Debug = v == "true" || v == "1"
+	}
+
+	// Telemetry section
+	if v := os.Getenv("FLASHPAPER_TELEMETRY_ENABLED"); v != "" {
+		c.Telemetry.Enabled = v == "true" || v == "1"
+	}
+	if v := os.Getenv("FLASHPAPER_TELEMETRY_ENDPOINT"); v != "" {
+		c.Telemetry.Endpoint = v
+	}
+	if v := os.Getenv("FLASHPAPER_TELEMETRY_HEADERS"); v != "" {
+		c.Telemetry.Headers = splitList(v)
+	}
+	if v := os.Getenv("FLASHPAPER_TELEMETRY_SERVICE_NAME"); v != "" {
+		c.Telemetry.ServiceName = v
+	}
+	if v := os.Getenv("FLASHPAPER_TELEMETRY_SAMPLE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			c.Telemetry.Sample = n
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_TELEMETRY_SERVER_TIMING"); v != "" {
+		c.Telemetry.ServerTiming = v == "true" || v == "1"
+	}
+
+	// Soft limit section
+	if v := os.Getenv("FLASHPAPER_SOFTLIMIT_SIZE"); v != "" {
+		if percent, err := strconv.Atoi(v); err == nil {
+			c.SoftLimit.Size = percent
+		}
+	}
+	if v := os.Getenv("FLASHPAPER_SOFTLIMIT_COMMENTS"); v != "" {
+		if percent, err := strconv.Atoi(v); err == nil {
+			c.SoftLimit.Comments = percent
+		}
+	}
+}
+
+// validTokenName reports whether name is usable as an API token name.
+// Names end up in storage keys, so they are limited to a safe alphabet.
+func validTokenName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseNetworks parses IP addresses and CIDR ranges such as "192.0.2.7",
+// "10.0.0.0/8", or "2001:db8::/32". An address is a single-host network.
+func ParseNetworks(items []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(items))
+	for _, item := range items {
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q", item)
+			}
+			networks = append(networks, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", item)
+		}
+		addr = addr.Unmap()
+		networks = append(networks, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return networks, nil
+}
+
+// splitList splits a comma-separated value into trimmed, non-empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// httpToken matches a method or header name (RFC 9110 token).
+var httpToken = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// validateCORS checks that origins are "*" or bare origins, since browsers
+// compare them to the Origin header as strings, and that methods and
+// headers are names. Extension origins such as chrome-extension://<id>
+// are allowed.
+func (s SecurityConfig) validateCORS() error {
+	for _, origin := range s.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return fmt.Errorf("cors_origins entry must be \"*\" or an origin such as https://app.example.com, got %q", origin)
+		}
+	}
+	for _, method := range s.CORSMethods {
+		if !httpToken.MatchString(method) {
+			return fmt.Errorf("invalid cors_methods entry %q", method)
+		}
+	}
+	for _, header := range s.CORSHeaders {
+		if !httpToken.MatchString(header) {
+			return fmt.Errorf("invalid cors_headers entry %q", header)
+		}
+	}
+	return nil
+}
+
+// validateTLS checks the TLS settings: either certificate files or ACME,
+// and the redirect listener must not collide with the main one.
+func (m MainConfig) validateTLS() error {
+	if (m.TLSCert == "") != (m.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	if m.ACME {
+		if m.TLSCert != "" {
+			return fmt.Errorf("acme and tls_cert are mutually exclusive")
+		}
+		if len(m.ACMEDomains) == 0 {
+			return fmt.Errorf("acme requires acme_domains")
+		}
+		if m.ACMECache == "" {
+			return fmt.Errorf("acme requires acme_cache")
+		}
+	}
+	if m.RedirectPort < 0 || m.RedirectPort > 65535 {
+		return fmt.Errorf("redirect_port must be between 0 and 65535, got %d", m.RedirectPort)
+	}
+	if m.TLSEnabled() && m.RedirectPort == m.Port {
+		return fmt.Errorf("redirect_port must differ from port, got %d", m.RedirectPort)
+	}
+	if m.HSTSMaxAge < 0 {
+		return fmt.Errorf("hsts_max_age must not be negative, got %d", m.HSTSMaxAge)
+	}
+	return nil
+}
 
 // updateDSNFromEnv constructs a database DSN from individual environment variables.
 // This provides a more Docker-friendly configuration approach.
@@ -398,6 +2234,34 @@ func (c *Config) updateDSNFromEnv() {
 	}
 }
 
+// applyTrafficPreset fills in what [traffic] preset implies and the file
+// left unset.
+func (c *Config) applyTrafficPreset() {
+	if c.Traffic.Preset == TrafficPresetCloudflare && c.Traffic.Header == "" {
+		c.Traffic.Header = cloudflare.Header
+	}
+}
+
+// validatePreset checks [traffic] preset against the settings it implies.
+func (t TrafficConfig) validatePreset() error {
+	switch t.Preset {
+	case "":
+	case TrafficPresetCloudflare:
+		if t.Header != "" && t.Header != cloudflare.Header {
+			return fmt.Errorf("traffic preset %q reads client addresses from %s, but header is %q", t.Preset, cloudflare.Header, t.Header)
+		}
+		if len(t.TrustedProxies) > 0 {
+			return fmt.Errorf("traffic preset %q trusts Cloudflare's networks; trusted_proxies must be empty", t.Preset)
+		}
+	default:
+		return fmt.Errorf("traffic preset must be empty or %q, got %q", TrafficPresetCloudflare, t.Preset)
+	}
+	if t.PresetRefresh < 0 {
+		return fmt.Errorf("preset_refresh must not be negative, got %d", t.PresetRefresh)
+	}
+	return nil
+}
+
 // Validate checks that the configuration is valid and consistent.
 func (c *Config) Validate() error {
 	// Port must be in valid range
@@ -405,22 +2269,118 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("port must be between 1 and 65535, got %d", c.Main.Port)
 	}
 
+	if err := c.Main.validateTLS(); err != nil {
+		return err
+	}
+
 	// Size limit must be positive
 	if c.Main.SizeLimit <= 0 {
 		return fmt.Errorf("sizelimit must be positive, got %d", c.Main.SizeLimit)
 	}
+	if c.Main.AttachmentLimit <= 0 {
+		return fmt.Errorf("attachmentlimit must be positive, got %d", c.Main.AttachmentLimit)
+	}
+
+	// Comment limit can't be negative (0 disables it)
+	if c.Main.CommentLimit < 0 {
+		return fmt.Errorf("commentlimit must not be negative, got %d", c.Main.CommentLimit)
+	}
+
+	switch c.Traffic.Store {
+	case TrafficStoreStorage, TrafficStoreMemory:
+	default:
+		return fmt.Errorf("traffic store must be %q or %q, got %q", TrafficStoreStorage, TrafficStoreMemory, c.Traffic.Store)
+	}
+
+	if c.Traffic.SaltRotation < 0 {
+		return fmt.Errorf("traffic salt_rotation must not be negative, got %d", c.Traffic.SaltRotation)
+	}
+
+	if err := c.Traffic.validatePreset(); err != nil {
+		return err
+	}
+	if _, err := ParseNetworks(c.Traffic.Exempted); err != nil {
+		return fmt.Errorf("traffic exempted: %w", err)
+	}
+	if _, err := ParseNetworks(c.Traffic.Creators); err != nil {
+		return fmt.Errorf("traffic creators: %w", err)
+	}
+	if _, err := ParseNetworks(c.Traffic.TrustedProxies); err != nil {
+		return fmt.Errorf("traffic trusted_proxies: %w", err)
+	}
+
+	// Download rates can't be negative (0 disables them)
+	if c.Traffic.DownloadRate < 0 || c.Traffic.DownloadRateGlobal < 0 {
+		return fmt.Errorf("download rates must not be negative")
+	}
+
+	// Every route needs a timeout; a zero one would cancel requests at once
+	s := c.Server
+	if s.Timeout <= 0 || s.HealthTimeout <= 0 || s.CreateTimeout <= 0 || s.ReadTimeout <= 0 {
+		return fmt.Errorf("server timeouts must be positive")
+	}
+	if s.MaxReads < 0 || s.MaxWrites < 0 {
+		return fmt.Errorf("server concurrency limits must not be negative")
+	}
+	if s.ConnReadTimeout < 0 || s.ConnHeaderTimeout < 0 || s.ConnWriteTimeout < 0 || s.ConnIdleTimeout < 0 {
+		return fmt.Errorf("server connection timeouts must not be negative")
+	}
+	longest := int(s.Longest() / time.Second)
+	if (s.ConnReadTimeout > 0 && s.ConnReadTimeout < longest) || (s.ConnWriteTimeout > 0 && s.ConnWriteTimeout < longest) {
+		return fmt.Errorf("server connection read and write timeouts must be at least the longest route timeout (%ds)", longest)
+	}
+	if s.Compress {
+		if s.CompressLevel < 1 || s.CompressLevel > 9 {
+			return fmt.Errorf("server compress_level must be between 1 and 9, got %d", s.CompressLevel)
+		}
+		for _, t := range s.CompressTypes {
+			// Compressors hold output back, which would stall live events
+			if strings.EqualFold(t, "text/event-stream") {
+				return fmt.Errorf("server compress_types can't include text/event-stream")
+			}
+		}
+	}
 
 	// Default expiration must be a valid option
 	if _, ok := c.Expire.Options[c.Expire.Default]; !ok {
 		return fmt.Errorf("default expiration %q is not a valid option", c.Expire.Default)
 	}
 
+	// Labels must belong to an option (catches typos in [expire_labels])
+	for name := range c.Expire.Labels {
+		if _, ok := c.Expire.Options[name]; !ok {
+			return fmt.Errorf("expiration label %q has no matching option", name)
+		}
+	}
+
 	// Storage class must be valid
 	switch c.Model.Class {
-	case "Database", "Filesystem":
+	case "Database", "Filesystem", "S3", "Blob":
 		// Valid
 	default:
-		return fmt.Errorf("model class must be 'Database' or 'Filesystem', got %q", c.Model.Class)
+		return fmt.Errorf("model class must be 'Database', 'Filesystem', 'S3', or 'Blob', got %q", c.Model.Class)
+	}
+
+	// Object storage needs a bucket, and static credentials come in pairs
+	if c.Model.Class == "S3" || c.Model.Class == "Blob" {
+		if c.Model.Bucket == "" {
+			return fmt.Errorf("model bucket is required for the %s class", c.Model.Class)
+		}
+		if c.Model.Class == "Blob" {
+			u, err := url.Parse(c.Model.Bucket)
+			if err != nil || u.Host == "" || (u.Scheme != "s3" && u.Scheme != "gs" && u.Scheme != "azblob") {
+				return fmt.Errorf("model bucket must be an s3://, gs://, or azblob:// URL for the Blob class, got %q", c.Model.Bucket)
+			}
+		}
+		if (c.Model.AccessKey == "") != (c.Model.SecretKey == "") {
+			return fmt.Errorf("model access_key and secret_key must be set together")
+		}
+		if c.Model.Endpoint != "" {
+			u, err := url.Parse(c.Model.Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("model endpoint must be an http(s) URL, got %q", c.Model.Endpoint)
+			}
+		}
 	}
 
 	// Database driver must be valid when using Database class
@@ -431,6 +2391,46 @@ func (c *Config) Validate() error {
 		default:
 			return fmt.Errorf("database driver must be 'sqlite3', 'postgres', or 'mysql', got %q", c.Model.Driver)
 		}
+		if c.Model.SRV != "" && c.Model.Driver == "sqlite3" {
+			return fmt.Errorf("model srv requires the postgres or mysql driver")
+		}
+		if c.Model.ProbeInterval < 0 {
+			return fmt.Errorf("model probe_interval must not be negative, got %d", c.Model.ProbeInterval)
+		}
+		for _, v := range []struct {
+			key string
+			n   int
+		}{
+			{"max_open_conns", c.Model.MaxOpenConns},
+			{"max_idle_conns", c.Model.MaxIdleConns},
+			{"conn_max_lifetime", c.Model.ConnMaxLifetime},
+			{"sqlite_busy_timeout", c.Model.SQLiteBusyTimeout},
+		} {
+			if v.n < 0 {
+				return fmt.Errorf("model %s must not be negative, got %d", v.key, v.n)
+			}
+		}
+		switch strings.ToLower(c.Model.SQLiteJournalMode) {
+		case "", "wal", "delete", "truncate", "persist", "memory", "off":
+			// Valid; empty leaves the database's own
+		default:
+			return fmt.Errorf("model sqlite_journal_mode must be wal, delete, truncate, persist, memory, or off, got %q", c.Model.SQLiteJournalMode)
+		}
+		switch strings.ToLower(c.Model.SQLiteSynchronous) {
+		case "", "off", "normal", "full", "extra":
+			// Valid; empty leaves SQLite's default
+		default:
+			return fmt.Errorf("model sqlite_synchronous must be off, normal, full, or extra, got %q", c.Model.SQLiteSynchronous)
+		}
+	}
+
+	if c.Model.QueryTimeout < 0 {
+		return fmt.Errorf("model query_timeout must not be negative, got %d", c.Model.QueryTimeout)
+	}
+
+	// Keys come from one place, so it's clear which one seals
+	if c.Model.EncryptionKeys != "" && c.Model.EncryptionKeysFile != "" {
+		return fmt.Errorf("model encryption_keys and encryption_keys_file are mutually exclusive")
 	}
 
 	// Icon type must be valid
@@ -449,9 +2449,241 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("compression must be 'zlib' or 'none', got %q", c.Main.Compression)
 	}
 
+	// Log settings must be valid
+	switch strings.ToLower(c.Main.LogLevel) {
+	case "debug", "info", "warn", "error":
+		// Valid
+	default:
+		return fmt.Errorf("loglevel must be 'debug', 'info', 'warn', or 'error', got %q", c.Main.LogLevel)
+	}
+	switch c.Main.LogFormat {
+	case "text", "json":
+		// Valid
+	default:
+		return fmt.Errorf("logformat must be 'text' or 'json', got %q", c.Main.LogFormat)
+	}
+	switch c.Main.LogPrivacy {
+	case LogPrivacyDebug, LogPrivacyRedact, LogPrivacyHash, LogPrivacyOff:
+		// Valid
+	default:
+		return fmt.Errorf("logprivacy must be 'debug', 'redact', 'hash', or 'off', got %q", c.Main.LogPrivacy)
+	}
+
+	// Forced paste options must be satisfiable together
+	if c.Main.ForceBurnAfterReading && c.Main.ForceOpenDiscussion {
+		return fmt.Errorf("force_burnafterreading and force_opendiscussion exclude each other")
+	}
+	if c.Main.ForceOpenDiscussion && !c.Main.Discussion {
+		return fmt.Errorf("force_opendiscussion requires discussion to be enabled")
+	}
+
+	if err := c.Main.validateURLShortener(); err != nil {
+		return err
+	}
+
+	// Moderation mode must be valid
+	switch c.Main.Moderation {
+	case ModerationOff, ModerationFlagged, ModerationAll:
+		// Valid
+	default:
+		return fmt.Errorf("moderation must be 'off', 'flagged', or 'all', got %q", c.Main.Moderation)
+	}
+
+	// Server header policy must be valid
+	switch c.Security.ServerHeader {
+	case ServerHeaderNone, ServerHeaderProduct, ServerHeaderFull:
+		// Valid
+	default:
+		return fmt.Errorf("server_header must be 'none', 'product', or 'full', got %q", c.Security.ServerHeader)
+	}
+	if _, err := ParseNetworks(c.Security.Denylist); err != nil {
+		return fmt.Errorf("security denylist: %w", err)
+	}
+	if _, err := ParseCSP(c.Security.CSP); err != nil {
+		return fmt.Errorf("security csp: %w", err)
+	}
+	switch c.Security.FrameOptions {
+	case FrameOptionsDeny, FrameOptionsSameOrigin, FrameOptionsNone:
+		// Valid
+	default:
+		return fmt.Errorf("frame_options must be 'deny', 'sameorigin', or 'none', got %q", c.Security.FrameOptions)
+	}
+	for _, h := range []struct {
+		key, value string
+		allowed    []string
+	}{
+		{"coop", c.Security.COOP, []string{"unsafe-none", "same-origin-allow-popups", "same-origin", "noopener-allow-popups"}},
+		{"coep", c.Security.COEP, []string{"unsafe-none", "require-corp", "credentialless"}},
+		{"corp", c.Security.CORP, []string{"same-site", "same-origin", "cross-origin"}},
+	} {
+		if h.value != "" && !slices.Contains(h.allowed, h.value) {
+			return fmt.Errorf("%s must be one of %s, got %q", h.key, strings.Join(h.allowed, ", "), h.value)
+		}
+	}
+	if err := c.Security.validateCORS(); err != nil {
+		return err
+	}
+
+	// Callback allowlist entries must be absolute http(s) URLs
+	for _, prefix := range c.Callback.Allowlist {
+		u, err := url.Parse(prefix)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("callback allowlist entry must be an absolute http(s) URL, got %q", prefix)
+		}
+	}
+	if c.Callback.Timeout <= 0 {
+		return fmt.Errorf("callback timeout must be positive, got %d", c.Callback.Timeout)
+	}
+
+	if err := c.Webhook.validate(); err != nil {
+		return err
+	}
+
+	if c.Events.Enabled {
+		if c.Events.MaxClients < 0 || c.Events.Heartbeat <= 0 {
+			return fmt.Errorf("events max_clients must not be negative and heartbeat must be positive")
+		}
+		if c.Events.Redis != "" {
+			if _, _, err := net.SplitHostPort(c.Events.Redis); err != nil {
+				return fmt.Errorf("events redis must be host:port: %w", err)
+			}
+			if c.Events.RedisChannel == "" {
+				return fmt.Errorf("events redis_channel must not be empty")
+			}
+		}
+	}
+
+	if c.Stats.Cache < 0 {
+		return fmt.Errorf("stats cache must not be negative, got %d", c.Stats.Cache)
+	}
+
+	// A short admin token is too easy to guess
+	if c.Admin.Token != "" && len(c.Admin.Token) < MinAdminTokenLength {
+		return fmt.Errorf("admin token must be at least %d characters", MinAdminTokenLength)
+	}
+
+	if c.Metrics.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Address); err != nil {
+			return fmt.Errorf("metrics address must be host:port, got %q", c.Metrics.Address)
+		}
+	}
+	if c.Metrics.Token != "" && len(c.Metrics.Token) < MinAdminTokenLength {
+		return fmt.Errorf("metrics token must be at least %d characters", MinAdminTokenLength)
+	}
+	// The profiler exposes memory contents; never on the public listener
+	if c.Metrics.Debug && c.Metrics.Address == "" {
+		return fmt.Errorf("metrics debug requires a metrics address")
+	}
+
+	if err := c.Telemetry.validate(); err != nil {
+		return err
+	}
+	if err := c.PoW.validate(); err != nil {
+		return err
+	}
+	if err := c.Captcha.validate(); err != nil {
+		return err
+	}
+
+	// Users can't accept terms they can't read
+	if c.TOS.Required && c.TOS.File == "" {
+		return fmt.Errorf("tos required is set but no tos file is configured")
+	}
+
+	// API tokens must be as hard to guess as the admin token, and
+	// distinguishable from each other and from it
+	bySecret := make(map[string]string, len(c.Tokens.Secrets))
+	for name, secret := range c.Tokens.Secrets {
+		if !validTokenName(name) {
+			return fmt.Errorf("token name %q may only contain letters, digits, '-' and '_'", name)
+		}
+		if len(secret) < MinAdminTokenLength {
+			return fmt.Errorf("token %q must be at least %d characters", name, MinAdminTokenLength)
+		}
+		if secret == c.Admin.Token {
+			return fmt.Errorf("token %q reuses the admin token", name)
+		}
+		if other, ok := bySecret[secret]; ok {
+			return fmt.Errorf("tokens %q and %q have the same secret", min(name, other), max(name, other))
+		}
+		bySecret[secret] = name
+	}
+	for section, quotas := range map[string]map[string]int64{
+		"token_quota_pastes": c.Tokens.QuotaPastes,
+		"token_quota_bytes":  c.Tokens.QuotaBytes,
+		"token_rate_limit":   c.Tokens.RateLimit,
+		"token_rate_burst":   c.Tokens.RateBurst,
+	} {
+		for name, quota := range quotas {
+			if _, ok := c.Tokens.Secrets[name]; !ok {
+				return fmt.Errorf("%s names unknown token %q", section, name)
+			}
+			if quota < 0 {
+				return fmt.Errorf("%s for token %q must not be negative, got %d", section, name, quota)
+			}
+		}
+	}
+
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+
+	// Soft limits are percentages of the hard ones
+	if c.SoftLimit.Size < 0 || c.SoftLimit.Size > 100 {
+		return fmt.Errorf("softlimit size must be between 0 and 100, got %d", c.SoftLimit.Size)
+	}
+	if c.SoftLimit.Comments < 0 || c.SoftLimit.Comments > 100 {
+		return fmt.Errorf("softlimit comments must be between 0 and 100, got %d", c.SoftLimit.Comments)
+	}
+
 	return nil
 }
 
+// OrderedOptions returns the expiration option keys in display order:
+// first those in Order, then any others ascending by duration, with
+// "never" (0) last and ties broken by key.
+func (e *ExpireConfig) OrderedOptions() []string {
+	keys := make([]string, 0, len(e.Options))
+	seen := make(map[string]bool, len(e.Options))
+	for _, key := range e.Order {
+		if _, ok := e.Options[key]; ok && !seen[key] {
+			keys = append(keys, key)
+			seen[key] = true
+		}
+	}
+
+	var rest []string
+	for key := range e.Options {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		a, b := e.Options[rest[i]], e.Options[rest[j]]
+		if (a == 0) != (b == 0) {
+			return b == 0 // never sorts last
+		}
+		if a != b {
+			return a < b
+		}
+		return rest[i] < rest[j]
+	})
+
+	return append(keys, rest...)
+}
+
+// Label returns the display label for an expiration option, falling back
+// to the built-in label for standard options and to the key otherwise.
+func (e *ExpireConfig) Label(key string) string {
+	if label := e.Labels[key]; label != "" {
+		return label
+	}
+	if label, ok := standardExpireLabels[key]; ok {
+		return label
+	}
+	return key
+}
+
 // GetExpireDuration returns the duration for a given expiration option.
 // Returns 0 (meaning never expires) if the option is not found.
 func (c *Config) GetExpireDuration(option string) time.Duration {
@@ -460,3 +2692,103 @@ func (c *Config) GetExpireDuration(option string) time.Duration {
 	}
 	return 0
 }
+
+// validate checks the webhook endpoints, events, and delivery settings.
+func (w WebhookConfig) validate() error {
+	for _, endpoint := range w.URLs {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook urls entry must be an absolute http(s) URL, got %q", endpoint)
+		}
+	}
+	for _, event := range w.Events {
+		if !slices.Contains(WebhookEvents, event) {
+			return fmt.Errorf("webhook events must be among %s, got %q", strings.Join(WebhookEvents, ", "), event)
+		}
+	}
+	if w.Timeout <= 0 || w.Queue <= 0 || w.Workers <= 0 {
+		return fmt.Errorf("webhook timeout, queue, and workers must be positive")
+	}
+	if w.Retries < 0 {
+		return fmt.Errorf("webhook retries must not be negative, got %d", w.Retries)
+	}
+	return nil
+}
+
+// validate checks the difficulty and challenge lifetime.
+func (p PoWConfig) validate() error {
+	// Beyond 32 bits, solving takes browsers hours (see util.MaxPoWDifficulty)
+	if p.Difficulty < 1 || p.Difficulty > 32 {
+		return fmt.Errorf("pow difficulty must be from 1 to 32, got %d", p.Difficulty)
+	}
+	if p.TTL <= 0 {
+		return fmt.Errorf("pow ttl must be positive, got %d", p.TTL)
+	}
+	return nil
+}
+
+// validate checks the provider and that it comes with both keys.
+func (c CaptchaConfig) validate() error {
+	switch c.Provider {
+	case "":
+		return nil
+	case CaptchaTurnstile, CaptchaHCaptcha, CaptchaReCAPTCHA:
+	default:
+		return fmt.Errorf("captcha provider must be 'turnstile', 'hcaptcha', 'recaptcha', or empty, got %q", c.Provider)
+	}
+	if c.SiteKey == "" || c.Secret == "" {
+		return fmt.Errorf("captcha provider %s requires sitekey and secret", c.Provider)
+	}
+	return nil
+}
+
+// validate checks the collector endpoint, export headers, and sampling.
+func (t TelemetryConfig) validate() error {
+	if t.Enabled {
+		if !isHTTPURL(t.Endpoint) {
+			return fmt.Errorf("telemetry endpoint must be an absolute http(s) URL, got %q", t.Endpoint)
+		}
+		if t.ServiceName == "" {
+			return fmt.Errorf("telemetry service_name must not be empty")
+		}
+	}
+	for _, header := range t.Headers {
+		if name, _, ok := strings.Cut(header, "="); !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("telemetry headers entry must be name=value, got %q", header)
+		}
+	}
+	if t.Sample < 0 || t.Sample > 100 {
+		return fmt.Errorf("telemetry sample must be a percentage from 0 to 100, got %d", t.Sample)
+	}
+	return nil
+}
+
+// validate checks that the creation gate has what its method needs.
+func (a AuthConfig) validate() error {
+	switch a.Create {
+	case "":
+	case AuthBasic:
+		if len(a.Users) == 0 {
+			return fmt.Errorf("auth create = %q needs users in [auth_users]", AuthBasic)
+		}
+		for name, password := range a.Users {
+			if name == "" || strings.Contains(name, ":") {
+				return fmt.Errorf("auth user name %q must not be empty or contain ':'", name)
+			}
+			if password == "" {
+				return fmt.Errorf("auth user %q has no password", name)
+			}
+		}
+	case AuthOIDC:
+		issuer, err := url.Parse(a.OIDCIssuer)
+		if err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+			return fmt.Errorf("auth oidc_issuer must be an http(s) URL, got %q", a.OIDCIssuer)
+		}
+		if a.OIDCAudience == "" {
+			return fmt.Errorf("auth create = %q needs oidc_audience", AuthOIDC)
+		}
+	default:
+		return fmt.Errorf("auth create must be empty, %q, or %q, got %q", AuthBasic, AuthOIDC, a.Create)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net/netip"
 	"os"
 	"path/filepath"
 	"testing"
@@ -73,6 +74,14 @@ func TestConfig_Validate_InvalidSizeLimit(t *testing.T) {
 	assert.Contains(t, err.Error(), "sizelimit")
 }
 
+func TestConfig_Validate_InvalidAttachmentLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Main.AttachmentLimit = 0
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "attachmentlimit")
+}
+
 func TestConfig_Validate_InvalidExpireDefault(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.Expire.Default = "invalid"
@@ -81,37 +90,1157 @@ func TestConfig_Validate_InvalidExpireDefault(t *testing.T) {
 	assert.Contains(t, err.Error(), "default expiration")
 }
 
-func TestConfig_Validate_InvalidModelClass(t *testing.T) {
-	cfg := DefaultConfig()
-	cfg.Model.Class = "InvalidClass"
-	err := cfg.Validate()
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "model class")
+func TestConfig_Validate_InvalidModelClass(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Model.Class = "InvalidClass"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "model class")
+}
+
+func TestConfig_Validate_InvalidDriver(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Model.Class = "Database"
+	cfg.Model.Driver = "invalid"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "database driver")
+}
+
+func TestLoad_S3Model(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[model]
+class = "S3"
+bucket = "flashpaper"
+endpoint = "http://minio:9000"
+path_style = true
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+	t.Setenv("FLASHPAPER_MODEL_REGION", "eu-central-1")
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "S3", cfg.Model.Class)
+	assert.Equal(t, "flashpaper", cfg.Model.Bucket)
+	assert.Equal(t, "eu-central-1", cfg.Model.Region)
+	assert.Equal(t, "http://minio:9000", cfg.Model.Endpoint)
+	assert.True(t, cfg.Model.PathStyle)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoad_NoMigrate(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+	require.NoError(t, os.WriteFile(configPath, []byte("[model]\nno_migrate = true\n"), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Model.NoMigrate)
+
+	t.Setenv("FLASHPAPER_MODEL_NO_MIGRATE", "false")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.False(t, cfg.Model.NoMigrate)
+}
+
+func TestLoad_QueryTimeout(t *testing.T) {
+	assert.Equal(t, 30, DefaultConfig().Model.QueryTimeout)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+	require.NoError(t, os.WriteFile(configPath, []byte("[model]\nquery_timeout = 5\n"), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 5, cfg.Model.QueryTimeout)
+
+	t.Setenv("FLASHPAPER_MODEL_QUERY_TIMEOUT", "0")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 0, cfg.Model.QueryTimeout)
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Model.QueryTimeout = -1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_ModelPool(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+	ini := "[model]\nmax_open_conns = 50\nconn_max_lifetime = 600\nsqlite_journal_mode = delete\nsqlite_synchronous = FULL\n"
+	require.NoError(t, os.WriteFile(configPath, []byte(ini), 0644))
+
+	t.Setenv("FLASHPAPER_MODEL_SQLITE_BUSY_TIMEOUT", "10000")
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 50, cfg.Model.MaxOpenConns)
+	assert.Equal(t, 0, cfg.Model.MaxIdleConns, "left to the driver")
+	assert.Equal(t, 600, cfg.Model.ConnMaxLifetime)
+	assert.Equal(t, "delete", cfg.Model.SQLiteJournalMode)
+	assert.Equal(t, 10000, cfg.Model.SQLiteBusyTimeout)
+	assert.Equal(t, "FULL", cfg.Model.SQLiteSynchronous)
+	assert.NoError(t, cfg.Validate())
+
+	for _, modify := range []func(*Config){
+		func(c *Config) { c.Model.MaxIdleConns = -1 },
+		func(c *Config) { c.Model.SQLiteBusyTimeout = -1 },
+		func(c *Config) { c.Model.SQLiteJournalMode = "wall" },
+		func(c *Config) { c.Model.SQLiteSynchronous = "sometimes" },
+	} {
+		cfg := DefaultConfig()
+		modify(cfg)
+		assert.Error(t, cfg.Validate())
+	}
+}
+
+func TestConfig_Validate_S3Model(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{"missing bucket", func(c *Config) { c.Model.Bucket = "" }},
+		{"access key only", func(c *Config) { c.Model.AccessKey = "AKIAEXAMPLE" }},
+		{"bad endpoint", func(c *Config) { c.Model.Endpoint = "minio:9000" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Model.Class = "S3"
+			cfg.Model.Bucket = "flashpaper"
+			tt.modify(cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
+}
+
+func TestConfig_Validate_BlobModel(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Model.Class = "Blob"
+	for _, bucket := range []string{"s3://flashpaper", "gs://flashpaper", "azblob://flashpaper"} {
+		cfg.Model.Bucket = bucket
+		assert.NoError(t, cfg.Validate(), bucket)
+	}
+	for _, bucket := range []string{"", "flashpaper", "gs://", "file:///data"} {
+		cfg.Model.Bucket = bucket
+		assert.Error(t, cfg.Validate(), bucket)
+	}
+}
+
+func TestConfig_Validate_DatabaseFailover(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Model.SRV = "_postgresql._tcp.example.com"
+	assert.Error(t, cfg.Validate(), "srv needs postgres or mysql")
+
+	cfg.Model.Driver = "postgres"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Model.ProbeInterval = -1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestConfig_Validate_TLS(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		valid  bool
+	}{
+		{"certificate files", func(c *Config) { c.Main.TLSCert, c.Main.TLSKey = "cert.pem", "key.pem" }, true},
+		{"cert without key", func(c *Config) { c.Main.TLSCert = "cert.pem" }, false},
+		{"acme", func(c *Config) { c.Main.ACME, c.Main.ACMEDomains = true, []string{"paste.example.com"} }, true},
+		{"acme without domains", func(c *Config) { c.Main.ACME = true }, false},
+		{"acme and files", func(c *Config) {
+			c.Main.ACME, c.Main.ACMEDomains = true, []string{"paste.example.com"}
+			c.Main.TLSCert, c.Main.TLSKey = "cert.pem", "key.pem"
+		}, false},
+		{"redirect on main port", func(c *Config) {
+			c.Main.TLSCert, c.Main.TLSKey = "cert.pem", "key.pem"
+			c.Main.RedirectPort = c.Main.Port
+		}, false},
+		{"negative hsts", func(c *Config) { c.Main.HSTSMaxAge = -1 }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			if tt.valid {
+				assert.NoError(t, cfg.Validate())
+			} else {
+				assert.Error(t, cfg.Validate())
+			}
+		})
+	}
+}
+
+func TestConfig_Validate_MetricsAddress(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Metrics.Address = "127.0.0.1:9090"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Metrics.Address = "9090"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "metrics address")
+}
+
+func TestConfig_Validate_MetricsManagement(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Metrics.Token = "short"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "metrics token")
+
+	cfg.Metrics.Token = "scrape-token-0123456789"
+	cfg.Metrics.Debug = true
+	err = cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "metrics address")
+
+	cfg.Metrics.Address = "127.0.0.1:9090"
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestConfig_Validate_ForcedOptions(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Main.ForceBurnAfterReading = true
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Main.ForceOpenDiscussion = true
+	assert.ErrorContains(t, cfg.Validate(), "exclude each other")
+
+	cfg.Main.ForceBurnAfterReading = false
+	cfg.Main.Discussion = false
+	assert.ErrorContains(t, cfg.Validate(), "requires discussion")
+}
+
+func TestConfig_Validate_URLShortener(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Main.URLShortener = URLShortenerLocal
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Main.URLShortener = "bitly"
+	assert.ErrorContains(t, cfg.Validate(), "urlshortener must be")
+
+	cfg.Main.URLShortener = URLShortenerYOURLS
+	cfg.Main.URLShortenerURL = "https://sho.rt/yourls-api.php"
+	cfg.Main.URLShortenerSignature = "signature"
+	assert.ErrorContains(t, cfg.Validate(), "requires publicurl")
+
+	cfg.Main.PublicURL = "paste.example.com"
+	assert.ErrorContains(t, cfg.Validate(), "publicurl must be")
+
+	cfg.Main.PublicURL = "https://paste.example.com/"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Main.URLShortenerSignature = ""
+	assert.ErrorContains(t, cfg.Validate(), "urlshortener_signature")
+
+	cfg.Main.URLShortener = URLShortenerGet
+	assert.ErrorContains(t, cfg.Validate(), "{url}")
+
+	cfg.Main.URLShortenerURL = "https://sho.rt/api?link={url}"
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestConfig_Validate_EncryptionKeys(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Model.EncryptionKeys = "k1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	assert.NoError(t, cfg.Validate())
+
+	cfg.Model.EncryptionKeysFile = "/run/secrets/flashpaper-keys"
+	assert.ErrorContains(t, cfg.Validate(), "mutually exclusive")
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"192.0.2.7", "10.1.2.3/8", "2001:db8::/32", "::ffff:198.51.100.1"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.7/32"),
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("198.51.100.1/32"),
+	}, networks)
+
+	_, err = ParseNetworks([]string{"192.0.2.0/33"})
+	assert.Error(t, err)
+
+	cfg := DefaultConfig()
+	cfg.Security.Denylist = []string{"not-an-ip"}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestConfig_Validate_InvalidIcon(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Main.Icon = "invalid"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "icon")
+}
+
+func TestConfig_Validate_InvalidCompression(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Main.Compression = "invalid"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "compression")
+}
+
+func TestConfig_Validate_InvalidModeration(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Main.Moderation = "some"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "moderation")
+}
+
+func TestConfig_Validate_InvalidServerHeader(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Security.ServerHeader = "verbose"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "server_header")
+}
+
+func TestLoad_SecuritySection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[security]
+server_header = full
+denylist_reads = true
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, ServerHeaderFull, cfg.Security.ServerHeader)
+	assert.True(t, cfg.Security.DenylistReads)
+
+	// Environment overrides the file
+	t.Setenv("FLASHPAPER_SECURITY_SERVER_HEADER", "product")
+	t.Setenv("FLASHPAPER_SECURITY_DENYLIST_READS", "false")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, ServerHeaderProduct, cfg.Security.ServerHeader)
+	assert.False(t, cfg.Security.DenylistReads)
+}
+
+func TestLoad_SecurityHeaders(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	// Backquotes keep ";" from starting a comment
+	content := `
+[security]
+csp = ` + "`img-src 'self' https://img.example.com; report-uri /csp`" + `
+csp_nonce = true
+frame_options = sameorigin
+hsts_include_subdomains = true
+coop = same-origin
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "img-src 'self' https://img.example.com; report-uri /csp", cfg.Security.CSP)
+	assert.True(t, cfg.Security.CSPNonce)
+	assert.Equal(t, FrameOptionsSameOrigin, cfg.Security.FrameOptions)
+	assert.True(t, cfg.Security.HSTSIncludeSubdomains)
+	assert.False(t, cfg.Security.HSTSPreload)
+	assert.Equal(t, "same-origin", cfg.Security.COOP)
+	assert.Empty(t, cfg.Security.COEP)
+
+	t.Setenv("FLASHPAPER_SECURITY_COEP", "require-cors")
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "coep must be one of")
+}
+
+func TestLoad_CORS(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[security]
+cors_origins = https://app.example.com, moz-extension://0a1b2c
+cors_methods = GET, POST
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://app.example.com", "moz-extension://0a1b2c"}, cfg.Security.CORSOrigins)
+	assert.Equal(t, []string{"GET", "POST"}, cfg.Security.CORSMethods)
+	assert.Equal(t, DefaultConfig().Security.CORSHeaders, cfg.Security.CORSHeaders)
+
+	// Origins have no path, and a bare host isn't one
+	t.Setenv("FLASHPAPER_SECURITY_CORS_ORIGINS", "https://app.example.com/path")
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "cors_origins")
+	t.Setenv("FLASHPAPER_SECURITY_CORS_ORIGINS", "app.example.com")
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "cors_origins")
+}
+
+func TestLoad_Webhook(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[webhook]
+urls = https://hooks.example.com/flashpaper, http://10.0.0.5:8080/in
+secret = s3cret
+events = paste.expired, comment.created
+retries = 0
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://hooks.example.com/flashpaper", "http://10.0.0.5:8080/in"}, cfg.Webhook.URLs)
+	assert.Equal(t, "s3cret", cfg.Webhook.Secret)
+	assert.Equal(t, []string{"paste.expired", "comment.created"}, cfg.Webhook.Events)
+	assert.Equal(t, 0, cfg.Webhook.Retries)
+	assert.Equal(t, 1000, cfg.Webhook.Queue)
+
+	t.Setenv("FLASHPAPER_WEBHOOK_EVENTS", "paste.read")
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "webhook events")
+
+	t.Setenv("FLASHPAPER_WEBHOOK_EVENTS", "")
+	t.Setenv("FLASHPAPER_WEBHOOK_URLS", "hooks.example.com")
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "webhook urls")
+
+	t.Setenv("FLASHPAPER_WEBHOOK_URLS", "")
+	t.Setenv("FLASHPAPER_WEBHOOK_WORKERS", "0")
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "workers")
+}
+
+func TestParseCSP(t *testing.T) {
+	directives, err := ParseCSP(" Script-Src 'self'  https://cdn.example.com ;; upgrade-insecure-requests; ")
+	require.NoError(t, err)
+	assert.Equal(t, []CSPDirective{
+		{Name: "script-src", Sources: []string{"'self'", "https://cdn.example.com"}},
+		{Name: "upgrade-insecure-requests", Sources: []string{}},
+	}, directives)
+
+	for _, policy := range []string{
+		"img-src 'self'; img-src data:",
+		"script_src 'self'",
+		"img-src 'self',data:",
+	} {
+		_, err := ParseCSP(policy)
+		assert.Error(t, err, policy)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Security.FrameOptions = "allow-from"
+	assert.ErrorContains(t, cfg.Validate(), "frame_options")
+}
+
+func TestConfig_Validate_InvalidCallbackAllowlist(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Callback.Allowlist = []string{"hooks.example.com/path"}
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "callback allowlist")
+}
+
+func TestLoad_CallbackSection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[callback]
+allowlist = https://hooks.example.com/fp/, , http://10.0.0.5:8080
+timeout = 3
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://hooks.example.com/fp/", "http://10.0.0.5:8080"}, cfg.Callback.Allowlist)
+	assert.Equal(t, 3, cfg.Callback.Timeout)
+}
+
+func TestLoad_AdminSection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[admin]
+token = "file-token-0123456789"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "file-token-0123456789", cfg.Admin.Token)
+
+	t.Setenv("FLASHPAPER_ADMIN_TOKEN", "env-token-0123456789")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "env-token-0123456789", cfg.Admin.Token)
+}
+
+func TestConfig_Validate_ShortAdminToken(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Admin.Token = "short"
+	assert.Error(t, cfg.Validate())
+
+	cfg.Admin.Token = ""
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoad_DownloadRates(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[traffic]
+download_rate = 1048576
+download_rate_global = 4194304
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1048576), cfg.Traffic.DownloadRate)
+	assert.Equal(t, int64(4194304), cfg.Traffic.DownloadRateGlobal)
+
+	t.Setenv("FLASHPAPER_TRAFFIC_DOWNLOAD_RATE", "2048")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2048), cfg.Traffic.DownloadRate)
+
+	cfg.Traffic.DownloadRateGlobal = -1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_SaltRotation(t *testing.T) {
+	cfg := DefaultConfig()
+	assert.Equal(t, 86400, cfg.Traffic.SaltRotation)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+	require.NoError(t, os.WriteFile(configPath, []byte("[traffic]\nsalt_rotation = 3600\n"), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 3600, cfg.Traffic.SaltRotation)
+
+	t.Setenv("FLASHPAPER_TRAFFIC_SALT_ROTATION", "0")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Zero(t, cfg.Traffic.SaltRotation)
+
+	cfg.Traffic.SaltRotation = -1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_CloudflarePreset(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[traffic]
+preset = "cloudflare"
+preset_refresh = 3600
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Warnings)
+	assert.Equal(t, TrafficPresetCloudflare, cfg.Traffic.Preset)
+	assert.Equal(t, "CF-Connecting-IP", cfg.Traffic.Header)
+	assert.Equal(t, 3600, cfg.Traffic.PresetRefresh)
+
+	// Any other address header contradicts the preset
+	content += `header = "X-Forwarded-For"` + "\n"
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+	_, err = Load(configPath)
+	assert.ErrorContains(t, err, "preset")
+
+	cfg = DefaultConfig()
+	cfg.Traffic.Preset = "akamai"
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_ServerTimeouts(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[server]
+timeout_health = 5
+timeout_create = 300
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 60, cfg.Server.Timeout)
+	assert.Equal(t, 5, cfg.Server.HealthTimeout)
+	assert.Equal(t, 300, cfg.Server.CreateTimeout)
+	assert.Equal(t, 30, cfg.Server.ReadTimeout)
+	assert.Equal(t, 300*time.Second, cfg.Server.Longest())
+
+	t.Setenv("FLASHPAPER_SERVER_TIMEOUT_READ", "45")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 45, cfg.Server.ReadTimeout)
+
+	cfg.Server.HealthTimeout = 0
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_ServerConcurrency(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[server]
+max_concurrent_reads = 64
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 64, cfg.Server.MaxReads)
+	assert.Zero(t, cfg.Server.MaxWrites)
+
+	t.Setenv("FLASHPAPER_SERVER_MAX_CONCURRENT_WRITES", "8")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 8, cfg.Server.MaxWrites)
+	require.NoError(t, cfg.Validate())
+
+	cfg.Server.MaxReads = -1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_ServerConnections(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[server]
+conn_read_timeout = 600
+conn_header_timeout = 10
+h2c = true
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 600, cfg.Server.ConnReadTimeout)
+	assert.Equal(t, 10, cfg.Server.ConnHeaderTimeout)
+	assert.Zero(t, cfg.Server.ConnWriteTimeout)
+	assert.Equal(t, 120, cfg.Server.ConnIdleTimeout)
+	assert.True(t, cfg.Server.H2C)
+
+	t.Setenv("FLASHPAPER_SERVER_CONN_IDLE_TIMEOUT", "30")
+	t.Setenv("FLASHPAPER_SERVER_H2C", "false")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 30, cfg.Server.ConnIdleTimeout)
+	assert.False(t, cfg.Server.H2C)
+	require.NoError(t, cfg.Validate())
+
+	// Shorter than timeout_create would cut off slow uploads
+	cfg.Server.ConnReadTimeout = 60
+	assert.Error(t, cfg.Validate())
+
+	cfg.Server.ConnReadTimeout = 0
+	cfg.Server.ConnIdleTimeout = -1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_ServerCompress(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+	require.NoError(t, os.WriteFile(configPath, []byte("[main]\nname = Test\n"), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.Compress)
+	assert.Equal(t, 5, cfg.Server.CompressLevel)
+	assert.Equal(t, DefaultCompressTypes, cfg.Server.CompressTypes)
+
+	content := `
+[server]
+compress_level = 9
+compress_types = text/html, application/json
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+	t.Setenv("FLASHPAPER_SERVER_COMPRESS", "false")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.False(t, cfg.Server.Compress)
+	assert.Equal(t, 9, cfg.Server.CompressLevel)
+	assert.Equal(t, []string{"text/html", "application/json"}, cfg.Server.CompressTypes)
+
+	cfg.Server.Compress = true
+	require.NoError(t, cfg.Validate())
+	cfg.Server.CompressLevel = 10
+	assert.ErrorContains(t, cfg.Validate(), "compress_level")
+	cfg.Server.CompressLevel = 5
+	cfg.Server.CompressTypes = []string{"text/event-stream"}
+	assert.ErrorContains(t, cfg.Validate(), "text/event-stream")
 }
 
-func TestConfig_Validate_InvalidDriver(t *testing.T) {
-	cfg := DefaultConfig()
-	cfg.Model.Class = "Database"
-	cfg.Model.Driver = "invalid"
-	err := cfg.Validate()
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "database driver")
+func TestLoad_Telemetry(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+	content := `
+[telemetry]
+enabled = true
+endpoint = https://otel.example.com:4318
+headers = x-api-key=secret, x-team = paste
+sample = 25
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+	t.Setenv("FLASHPAPER_TELEMETRY_SERVER_TIMING", "true")
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Telemetry.Enabled)
+	assert.Equal(t, "https://otel.example.com:4318", cfg.Telemetry.Endpoint)
+	assert.Equal(t, []string{"x-api-key=secret", "x-team = paste"}, cfg.Telemetry.Headers)
+	assert.Equal(t, "flashpaper", cfg.Telemetry.ServiceName)
+	assert.Equal(t, 25, cfg.Telemetry.Sample)
+	assert.True(t, cfg.Telemetry.ServerTiming)
+	require.NoError(t, cfg.Validate())
+
+	cfg.Telemetry.Sample = 101
+	assert.ErrorContains(t, cfg.Validate(), "telemetry sample")
+	cfg.Telemetry.Sample = 100
+	cfg.Telemetry.Headers = []string{"x-api-key"}
+	assert.ErrorContains(t, cfg.Validate(), "telemetry headers")
+	cfg.Telemetry.Headers = nil
+	cfg.Telemetry.Endpoint = "localhost:4318"
+	assert.ErrorContains(t, cfg.Validate(), "telemetry endpoint")
+
+	// The endpoint only matters once traces are exported
+	cfg.Telemetry.Enabled = false
+	assert.NoError(t, cfg.Validate())
 }
 
-func TestConfig_Validate_InvalidIcon(t *testing.T) {
+func TestLoad_LogPrivacy(t *testing.T) {
 	cfg := DefaultConfig()
-	cfg.Main.Icon = "invalid"
-	err := cfg.Validate()
+	assert.Equal(t, LogPrivacyDebug, cfg.Main.LogPrivacy)
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+	require.NoError(t, os.WriteFile(configPath, []byte("[main]\nlogprivacy = \"hash\"\n"), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, LogPrivacyHash, cfg.Main.LogPrivacy)
+
+	t.Setenv("FLASHPAPER_MAIN_LOGPRIVACY", "redact")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, LogPrivacyRedact, cfg.Main.LogPrivacy)
+
+	cfg.Main.LogPrivacy = "anonymize"
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_EventsSection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[events]
+enabled = true
+max_clients = 50
+redis = "redis.internal:6379"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Events.Enabled)
+	assert.Equal(t, 50, cfg.Events.MaxClients)
+	assert.Equal(t, 25, cfg.Events.Heartbeat)
+	assert.Equal(t, "redis.internal:6379", cfg.Events.Redis)
+	assert.Equal(t, "flashpaper:events", cfg.Events.RedisChannel)
+
+	t.Setenv("FLASHPAPER_EVENTS_REDIS_PASSWORD", "secret")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", cfg.Events.RedisPassword)
+	require.NoError(t, cfg.Validate())
+
+	cfg.Events.Redis = "redis.internal"
+	assert.Error(t, cfg.Validate())
+	cfg.Events.Redis = ""
+	cfg.Events.Heartbeat = 0
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_StatsSection(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing.ini"))
+	require.NoError(t, err)
+	assert.False(t, cfg.Stats.Enabled)
+	assert.Equal(t, 300, cfg.Stats.Cache)
+
+	t.Setenv("FLASHPAPER_STATS_ENABLED", "true")
+	t.Setenv("FLASHPAPER_STATS_CACHE", "60")
+	cfg, err = Load(filepath.Join(t.TempDir(), "missing.ini"))
+	require.NoError(t, err)
+	assert.True(t, cfg.Stats.Enabled)
+	assert.Equal(t, 60, cfg.Stats.Cache)
+
+	cfg.Stats.Cache = -1
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_TOSSection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[tos]
+file = "/etc/flashpaper/tos.md"
+required = true
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/flashpaper/tos.md", cfg.TOS.File)
+	assert.True(t, cfg.TOS.Required)
+
+	t.Setenv("FLASHPAPER_TOS_REQUIRED", "false")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.False(t, cfg.TOS.Required)
+}
+
+func TestLoad_PoWSection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[pow]
+enabled = true
+difficulty = 18
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.PoW.Enabled)
+	assert.Equal(t, 18, cfg.PoW.Difficulty)
+	assert.Equal(t, 300, cfg.PoW.TTL)
+
+	t.Setenv("FLASHPAPER_POW_TTL", "60")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 60, cfg.PoW.TTL)
+
+	for _, bad := range []PoWConfig{{Difficulty: 0, TTL: 300}, {Difficulty: 33, TTL: 300}, {Difficulty: 16, TTL: 0}} {
+		cfg := DefaultConfig()
+		cfg.PoW = bad
+		assert.Error(t, cfg.Validate(), "%+v", bad)
+	}
+}
+
+func TestLoad_CaptchaSection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[captcha]
+provider = "turnstile"
+sitekey = "0x4AAAAAAAexample"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	// A provider without its secret is refused
+	_, err := Load(configPath)
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "icon")
+
+	t.Setenv("FLASHPAPER_CAPTCHA_SECRET", "0x4AAAAAAAsecret")
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Captcha.Enabled())
+	assert.Equal(t, CaptchaTurnstile, cfg.Captcha.Provider)
+	assert.Equal(t, "0x4AAAAAAAexample", cfg.Captcha.SiteKey)
+	assert.Equal(t, "0x4AAAAAAAsecret", cfg.Captcha.Secret)
+
+	assert.False(t, DefaultConfig().Captcha.Enabled())
+	for _, bad := range []CaptchaConfig{
+		{Provider: "friendlycaptcha", SiteKey: "key", Secret: "secret"},
+		{Provider: CaptchaHCaptcha, Secret: "secret"},
+		{Provider: CaptchaReCAPTCHA, SiteKey: "key"},
+	} {
+		cfg := DefaultConfig()
+		cfg.Captcha = bad
+		assert.Error(t, cfg.Validate(), "%+v", bad)
+	}
 }
 
-func TestConfig_Validate_InvalidCompression(t *testing.T) {
+func TestLoad_MaintenanceSection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[maintenance]
+enabled = true
+message = "Migrating storage, back at 03:00 UTC"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Maintenance.Active())
+	assert.False(t, cfg.Maintenance.ReadOnly)
+	assert.Equal(t, "Migrating storage, back at 03:00 UTC", cfg.Maintenance.Message)
+	assert.Empty(t, cfg.Warnings)
+
+	// Read-only implies maintenance
+	t.Setenv("FLASHPAPER_MAINTENANCE_ENABLED", "false")
+	t.Setenv("FLASHPAPER_MAINTENANCE_READONLY", "1")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Maintenance.Active())
+	assert.True(t, cfg.Maintenance.ReadOnly)
+}
+
+func TestConfig_Validate_TOSRequiresFile(t *testing.T) {
 	cfg := DefaultConfig()
-	cfg.Main.Compression = "invalid"
-	err := cfg.Validate()
-	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "compression")
+	cfg.TOS.Required = true
+	assert.Error(t, cfg.Validate())
+
+	cfg.TOS.File = "tos.md"
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoad_SoftLimitSection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[softlimit]
+size = 90
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 90, cfg.SoftLimit.Size)
+	assert.Equal(t, 80, cfg.SoftLimit.Comments)
+
+	t.Setenv("FLASHPAPER_SOFTLIMIT_COMMENTS", "0")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, 0, cfg.SoftLimit.Comments)
+
+	cfg.SoftLimit.Size = 101
+	assert.Error(t, cfg.Validate())
+}
+
+func TestLoad_APISection(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[api]
+server_encryption = false
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.API.Secrets)
+	assert.False(t, cfg.API.ServerEncryption)
+
+	t.Setenv("FLASHPAPER_API_SECRETS", "false")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.False(t, cfg.API.Secrets)
+}
+
+func TestLoad_URLShortener(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[main]
+publicurl = "https://paste.example.com/"
+urlshortener = "yourls"
+urlshortener_url = "https://sho.rt/yourls-api.php"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+	t.Setenv("FLASHPAPER_MAIN_URLSHORTENER_SIGNATURE", "signature")
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "https://paste.example.com/", cfg.Main.PublicURL)
+	assert.Equal(t, URLShortenerYOURLS, cfg.Main.URLShortener)
+	assert.Equal(t, "https://sho.rt/yourls-api.php", cfg.Main.URLShortenerURL)
+	assert.Equal(t, "signature", cfg.Main.URLShortenerSignature)
+}
+
+func TestLoad_EncryptionKeys(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[model]
+encryption_keys_file = "/run/secrets/flashpaper-keys"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "/run/secrets/flashpaper-keys", cfg.Model.EncryptionKeysFile)
+	assert.Empty(t, cfg.Model.EncryptionKeys)
+
+	t.Setenv("FLASHPAPER_MODEL_ENCRYPTION_KEYS_FILE", "/etc/flashpaper/keys")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/flashpaper/keys", cfg.Model.EncryptionKeysFile)
+}
+
+func TestLoad_TokenSections(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[tokens]
+team-a = "team-a-secret-0123456789"
+
+[token_quota_pastes]
+team-a = 1000
+
+[token_quota_bytes]
+team-a = 104857600
+
+[token_rate_limit]
+team-a = 0
+
+[token_rate_burst]
+team-a = 50
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+	t.Setenv("FLASHPAPER_TOKENS_CI", "ci-secret-0123456789")
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.True(t, cfg.Tokens.Enabled())
+	assert.Equal(t, "team-a-secret-0123456789", cfg.Tokens.Secrets["team-a"])
+	assert.Equal(t, "ci-secret-0123456789", cfg.Tokens.Secrets["ci"])
+	assert.Equal(t, int64(1000), cfg.Tokens.QuotaPastes["team-a"])
+	assert.Equal(t, int64(104857600), cfg.Tokens.QuotaBytes["team-a"])
+	assert.Equal(t, map[string]int64{"team-a": 0}, cfg.Tokens.RateLimit)
+	assert.Equal(t, int64(50), cfg.Tokens.RateBurst["team-a"])
+}
+
+func TestConfig_Validate_Tokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{"short secret", func(c *Config) { c.Tokens.Secrets["a"] = "short" }},
+		{"bad name", func(c *Config) { c.Tokens.Secrets["team/a"] = "team-a-secret-0123456789" }},
+		{"shared secret", func(c *Config) {
+			c.Tokens.Secrets["a"] = "same-secret-0123456789"
+			c.Tokens.Secrets["b"] = "same-secret-0123456789"
+		}},
+		{"admin token", func(c *Config) {
+			c.Admin.Token = "admin-secret-0123456789"
+			c.Tokens.Secrets["a"] = "admin-secret-0123456789"
+		}},
+		{"unknown quota token", func(c *Config) { c.Tokens.QuotaPastes["nobody"] = 10 }},
+		{"negative quota", func(c *Config) {
+			c.Tokens.Secrets["a"] = "a-secret-0123456789"
+			c.Tokens.QuotaBytes["a"] = -1
+		}},
+		{"unknown rate limit token", func(c *Config) { c.Tokens.RateLimit["nobody"] = 0 }},
+		{"negative rate burst", func(c *Config) {
+			c.Tokens.Secrets["a"] = "a-secret-0123456789"
+			c.Tokens.RateBurst["a"] = -1
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
+}
+
+func TestLoad_AuthSections(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[auth]
+create = "basic"
+
+[auth_users]
+alice = "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+	t.Setenv("FLASHPAPER_AUTH_USERS_BOB", "bob-password")
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, AuthBasic, cfg.Auth.Create)
+	assert.Len(t, cfg.Auth.Users, 2)
+	assert.Equal(t, "bob-password", cfg.Auth.Users["bob"])
+
+	t.Setenv("FLASHPAPER_AUTH_CREATE", "oidc")
+	t.Setenv("FLASHPAPER_AUTH_OIDC_ISSUER", "https://login.example.com/realms/internal")
+	t.Setenv("FLASHPAPER_AUTH_OIDC_AUDIENCE", "flashpaper")
+	cfg, err = Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, AuthOIDC, cfg.Auth.Create)
+	assert.Equal(t, "https://login.example.com/realms/internal", cfg.Auth.OIDCIssuer)
+	assert.Equal(t, "flashpaper", cfg.Auth.OIDCAudience)
+}
+
+func TestConfig_Validate_Auth(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{"unknown method", func(c *Config) { c.Auth.Create = "ldap" }},
+		{"basic without users", func(c *Config) { c.Auth.Create = AuthBasic }},
+		{"colon in user name", func(c *Config) {
+			c.Auth.Create = AuthBasic
+			c.Auth.Users["a:b"] = "password"
+		}},
+		{"oidc without issuer", func(c *Config) {
+			c.Auth.Create = AuthOIDC
+			c.Auth.OIDCAudience = "flashpaper"
+		}},
+		{"oidc without audience", func(c *Config) {
+			c.Auth.Create = AuthOIDC
+			c.Auth.OIDCIssuer = "https://login.example.com"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			assert.Error(t, cfg.Validate())
+		})
+	}
 }
 
 func TestLoad_NonExistentFile_UsesDefaults(t *testing.T) {
@@ -272,6 +1401,58 @@ default = 2hours
 	assert.Equal(t, 5*time.Minute, cfg.GetExpireDuration("5min"))
 }
 
+func TestLoad_ExpireOptionsOrderAndLabels(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[expire_options]
+1hour = 3600
+2weeks = 1209600
+1day = 86400
+
+[expire_labels]
+2weeks = "Two weeks"
+1day = "A day"
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+
+	// Listed options keep file order, unlisted defaults follow in default order
+	assert.Equal(t, []string{
+		"1hour", "2weeks", "1day",
+		"5min", "10min", "1week", "1month", "1year", "never",
+	}, cfg.Expire.OrderedOptions())
+
+	assert.Equal(t, "Two weeks", cfg.Expire.Label("2weeks"))
+	assert.Equal(t, "A day", cfg.Expire.Label("1day"))
+	assert.Equal(t, "1 hour", cfg.Expire.Label("1hour"))
+}
+
+func TestExpireConfig_OrderedOptions_UnorderedFallBackToDuration(t *testing.T) {
+	e := ExpireConfig{
+		Options: map[string]time.Duration{
+			"never":  0,
+			"1day":   24 * time.Hour,
+			"1hour":  time.Hour,
+			"2hours": 2 * time.Hour,
+		},
+		Order: []string{"1day", "missing"},
+	}
+	assert.Equal(t, []string{"1day", "1hour", "2hours", "never"}, e.OrderedOptions())
+	assert.Equal(t, "2hours", e.Label("2hours"))
+}
+
+func TestConfig_Validate_ExpireLabelWithoutOption(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Expire.Labels["2weeks"] = "Two weeks"
+	err := cfg.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "2weeks")
+}
+
 func TestLoad_TrafficExemptedAndCreators(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "config.ini")
@@ -295,6 +1476,36 @@ creators = 192.168.1.100, 192.168.1.101
 	assert.Len(t, cfg.Traffic.Creators, 2)
 	assert.Contains(t, cfg.Traffic.Creators, "192.168.1.100")
 	assert.Contains(t, cfg.Traffic.Creators, "192.168.1.101")
+
+	cfg.Traffic.Creators = []string{"2001:db8::/32", "fd00::1"}
+	assert.NoError(t, cfg.Validate())
+	cfg.Traffic.Exempted = []string{"192.168.1.0/33"}
+	assert.ErrorContains(t, cfg.Validate(), "traffic exempted")
+}
+
+func TestLoad_TrafficTrustedProxies(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.ini")
+
+	content := `
+[traffic]
+header = X-Forwarded-For
+trusted_proxies = 10.0.0.0/8, 2001:db8::1
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "2001:db8::1"}, cfg.Traffic.TrustedProxies)
+
+	cfg.Traffic.TrustedProxies = []string{"proxy.internal"}
+	assert.ErrorContains(t, cfg.Validate(), "traffic trusted_proxies")
+
+	// The Cloudflare preset brings its own list
+	cfg.Traffic.TrustedProxies = []string{"10.0.0.0/8"}
+	cfg.Traffic.Header = ""
+	cfg.Traffic.Preset = TrafficPresetCloudflare
+	assert.ErrorContains(t, cfg.Validate(), "trusted_proxies must be empty")
 }
 
 func TestConfig_ValidDrivers(t *testing.T) {
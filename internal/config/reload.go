@@ -0,0 +1,53 @@
+// Package config provides the split between settings that can change while
+// the server runs and those that need a restart. On SIGHUP the server
+// re-reads its configuration; size limits, rate limits, expiration options,
+// purge, and maintenance settings take effect for the next request, while
+// listeners, the storage backend, and everything built from them at startup
+// stay as they are until the process is restarted.
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Reload returns a copy of c with the settings that are safe to change at
+// runtime taken from next, and the names of next's other settings that
+// differ from c, as "[section] Field", which only a restart applies.
+func (c *Config) Reload(next *Config) (*Config, []string) {
+	applied := *c
+	applied.Main.SizeLimit = next.Main.SizeLimit
+	applied.Main.AttachmentLimit = next.Main.AttachmentLimit
+	applied.Expire = next.Expire
+	applied.Traffic.Limit = next.Traffic.Limit
+	applied.Traffic.Burst = next.Traffic.Burst
+	applied.Traffic.CommentLimit = next.Traffic.CommentLimit
+	applied.Traffic.CommentBurst = next.Traffic.CommentBurst
+	applied.Traffic.ReadLimit = next.Traffic.ReadLimit
+	applied.Traffic.ReadBurst = next.Traffic.ReadBurst
+	applied.Tokens.RateLimit = next.Tokens.RateLimit
+	applied.Tokens.RateBurst = next.Tokens.RateBurst
+	applied.Purge = next.Purge
+	applied.Maintenance = next.Maintenance
+	applied.Warnings = next.Warnings
+
+	return &applied, changedSettings(&applied, next)
+}
+
+// changedSettings lists the settings that differ between a and b.
+func changedSettings(a, b *Config) []string {
+	var changed []string
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	for i := 0; i < va.NumField(); i++ {
+		section := va.Type().Field(i)
+		if section.Type.Kind() != reflect.Struct {
+			continue
+		}
+		for j := 0; j < section.Type.NumField(); j++ {
+			if !reflect.DeepEqual(va.Field(i).Field(j).Interface(), vb.Field(i).Field(j).Interface()) {
+				changed = append(changed, "["+strings.ToLower(section.Name)+"] "+section.Type.Field(j).Name)
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Reload(t *testing.T) {
+	current := DefaultConfig()
+	next := DefaultConfig()
+	next.Main.SizeLimit = 1 << 20
+	next.Traffic.Limit = 60
+	next.Traffic.ReadBurst = 5
+	next.Expire.Options = map[string]time.Duration{"1day": 24 * time.Hour}
+	next.Expire.Default = "1day"
+	next.Purge.Limit = 0
+	next.Maintenance.ReadOnly = true
+
+	applied, restart := current.Reload(next)
+	assert.Empty(t, restart)
+	assert.Equal(t, next.Main.SizeLimit, applied.Main.SizeLimit)
+	assert.Equal(t, 60, applied.Traffic.Limit)
+	assert.Equal(t, 5, applied.Traffic.ReadBurst)
+	assert.Equal(t, next.Expire, applied.Expire)
+	assert.Equal(t, 0, applied.Purge.Limit)
+	assert.True(t, applied.Maintenance.ReadOnly)
+	assert.Equal(t, int64(10*1024*1024), current.Main.SizeLimit, "current config is left alone")
+
+	// Listeners, storage, and the rest are only reported
+	next.Main.Port = 9090
+	next.Model.Class = "Filesystem"
+	next.Traffic.Exempted = []string{"10.0.0.0/8"}
+	applied, restart = current.Reload(next)
+	assert.Equal(t, []string{"[main] Port", "[traffic] Exempted", "[model] Class"}, restart)
+	assert.Equal(t, 8080, applied.Main.Port)
+	assert.Equal(t, "Database", applied.Model.Class)
+}
@@ -0,0 +1,397 @@
+// Package config provides the declarative schema of INI configuration keys.
+// The schema lets Load tell operators about keys it would otherwise ignore
+// silently: typos like "sizelimt", keys in the wrong section, values of the
+// wrong type, and keys that have been renamed.
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// KeyType is the expected type of a configuration value.
+type KeyType int
+
+// Configuration value types.
+const (
+	TypeString KeyType = iota
+	TypeInt
+	TypeBool
+	TypeList // Comma-separated strings
+)
+
+// String returns the type name used in warnings.
+func (t KeyType) String() string {
+	switch t {
+	case TypeInt:
+		return "integer"
+	case TypeBool:
+		return "boolean"
+	case TypeList:
+		return "list"
+	default:
+		return "string"
+	}
+}
+
+// AnyKey is a KeySpec.Key wildcard for sections with free-form keys.
+const AnyKey = "*"
+
+// KeySpec describes a single INI configuration key.
+type KeySpec struct {
+	// Section is the INI section name (e.g. "main")
+	Section string
+
+	// Key is the key name within the section, or AnyKey
+	Key string
+
+	// Type is the expected value type
+	Type KeyType
+
+	// Default is the value used when the key is absent, as written in INI
+	Default string
+
+	// DeprecatedBy names the key in the same section that replaces this one.
+	// A deprecated key still works: its value is used for the replacement
+	// unless the replacement is also set.
+	DeprecatedBy string
+}
+
+// Schema lists every configuration key Load understands.
+var Schema = []KeySpec{
+	{Section: "main", Key: "name", Type: TypeString, Default: "FlashPaper"},
+	{Section: "main", Key: "host", Type: TypeString, Default: "0.0.0.0"},
+	{Section: "main", Key: "port", Type: TypeInt, Default: "8080"},
+	{Section: "main", Key: "basepath", Type: TypeString, Default: ""},
+	{Section: "main", Key: "discussion", Type: TypeBool, Default: "true"},
+	{Section: "main", Key: "opendiscussion", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "commentlimit", Type: TypeInt, Default: "0"},
+	{Section: "main", Key: "moderation", Type: TypeString, Default: "off"},
+	{Section: "main", Key: "password", Type: TypeBool, Default: "true"},
+	{Section: "main", Key: "access_proof", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "editable", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "fileupload", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "burnafterreadingselected", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "force_burnafterreading", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "force_opendiscussion", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "sizelimit", Type: TypeInt, Default: "10485760"},
+	{Section: "main", Key: "attachmentlimit", Type: TypeInt, Default: "10485760"},
+	{Section: "main", Key: "template", Type: TypeString, Default: "bootstrap5"},
+	{Section: "main", Key: "templatedir", Type: TypeString, Default: ""},
+	{Section: "main", Key: "staticdir", Type: TypeString, Default: ""},
+	{Section: "main", Key: "template_reload", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "languageselection", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "languagedefault", Type: TypeString, Default: "en"},
+	{Section: "main", Key: "qrcode", Type: TypeBool, Default: "true"},
+	{Section: "main", Key: "publicurl", Type: TypeString, Default: ""},
+	{Section: "main", Key: "urlshortener", Type: TypeString, Default: ""},
+	{Section: "main", Key: "urlshortener_url", Type: TypeString, Default: ""},
+	{Section: "main", Key: "urlshortener_signature", Type: TypeString, Default: ""},
+	{Section: "main", Key: "icon", Type: TypeString, Default: "identicon"},
+	{Section: "main", Key: "httpwarning", Type: TypeBool, Default: "true"},
+	{Section: "main", Key: "compression", Type: TypeString, Default: "zlib"},
+	{Section: "main", Key: "tls_cert", Type: TypeString, Default: ""},
+	{Section: "main", Key: "tls_key", Type: TypeString, Default: ""},
+	{Section: "main", Key: "acme", Type: TypeBool, Default: "false"},
+	{Section: "main", Key: "acme_domains", Type: TypeList, Default: ""},
+	{Section: "main", Key: "acme_email", Type: TypeString, Default: ""},
+	{Section: "main", Key: "acme_cache", Type: TypeString, Default: "acme"},
+	{Section: "main", Key: "acme_directory", Type: TypeString, Default: ""},
+	{Section: "main", Key: "redirect_port", Type: TypeInt, Default: "80"},
+	{Section: "main", Key: "hsts_max_age", Type: TypeInt, Default: "31536000"},
+	{Section: "main", Key: "loglevel", Type: TypeString, Default: "info"},
+	{Section: "main", Key: "logformat", Type: TypeString, Default: "text"},
+	{Section: "main", Key: "logprivacy", Type: TypeString, Default: "debug"},
+
+	{Section: "expire", Key: "default", Type: TypeString, Default: "1week"},
+	{Section: "expire_options", Key: AnyKey, Type: TypeInt},
+	{Section: "expire_labels", Key: AnyKey, Type: TypeString},
+
+	{Section: "traffic", Key: "limit", Type: TypeInt, Default: "10"},
+	{Section: "traffic", Key: "burst", Type: TypeInt, Default: "1"},
+	{Section: "traffic", Key: "comment_limit", Type: TypeInt, Default: "10"},
+	{Section: "traffic", Key: "comment_burst", Type: TypeInt, Default: "1"},
+	{Section: "traffic", Key: "read_limit", Type: TypeInt, Default: "0"},
+	{Section: "traffic", Key: "read_burst", Type: TypeInt, Default: "10"},
+	{Section: "traffic", Key: "store", Type: TypeString, Default: "storage"},
+	{Section: "traffic", Key: "header", Type: TypeString, Default: ""},
+	{Section: "traffic", Key: "preset", Type: TypeString, Default: ""},
+	{Section: "traffic", Key: "preset_refresh", Type: TypeInt, Default: "86400"},
+	{Section: "traffic", Key: "exempted", Type: TypeList, Default: ""},
+	{Section: "traffic", Key: "creators", Type: TypeList, Default: ""},
+	{Section: "traffic", Key: "trusted_proxies", Type: TypeList, Default: ""},
+	{Section: "traffic", Key: "download_rate", Type: TypeInt, Default: "0"},
+	{Section: "traffic", Key: "download_rate_global", Type: TypeInt, Default: "0"},
+	{Section: "traffic", Key: "idempotency_ttl", Type: TypeInt, Default: "86400"},
+	{Section: "traffic", Key: "salt_rotation", Type: TypeInt, Default: "86400"},
+
+	{Section: "purge", Key: "limit", Type: TypeInt, Default: "300"},
+	{Section: "purge", Key: "batchsize", Type: TypeInt, Default: "10"},
+
+	{Section: "model", Key: "class", Type: TypeString, Default: "Database"},
+	{Section: "model", Key: "driver", Type: TypeString, Default: "sqlite3"},
+	{Section: "model", Key: "dsn", Type: TypeString, Default: "flashpaper.db"},
+	{Section: "model", Key: "dir", Type: TypeString, Default: "data"},
+	{Section: "model", Key: "srv", Type: TypeString, Default: ""},
+	{Section: "model", Key: "probe_interval", Type: TypeInt, Default: "5"},
+	{Section: "model", Key: "no_migrate", Type: TypeBool, Default: "false"},
+	{Section: "model", Key: "bucket", Type: TypeString, Default: ""},
+	{Section: "model", Key: "region", Type: TypeString, Default: "us-east-1"},
+	{Section: "model", Key: "endpoint", Type: TypeString, Default: ""},
+	{Section: "model", Key: "prefix", Type: TypeString, Default: ""},
+	{Section: "model", Key: "path_style", Type: TypeBool, Default: "false"},
+	{Section: "model", Key: "access_key", Type: TypeString, Default: ""},
+	{Section: "model", Key: "secret_key", Type: TypeString, Default: ""},
+	{Section: "model", Key: "encryption_keys", Type: TypeString, Default: ""},
+	{Section: "model", Key: "encryption_keys_file", Type: TypeString, Default: ""},
+	{Section: "model", Key: "shadow", Type: TypeString, Default: ""},
+	{Section: "model", Key: "query_timeout", Type: TypeInt, Default: "30"},
+	{Section: "model", Key: "max_open_conns", Type: TypeInt, Default: "0"},
+	{Section: "model", Key: "max_idle_conns", Type: TypeInt, Default: "0"},
+	{Section: "model", Key: "conn_max_lifetime", Type: TypeInt, Default: "0"},
+	{Section: "model", Key: "sqlite_journal_mode", Type: TypeString, Default: "wal"},
+	{Section: "model", Key: "sqlite_busy_timeout", Type: TypeInt, Default: "5000"},
+	{Section: "model", Key: "sqlite_synchronous", Type: TypeString, Default: "normal"},
+
+	{Section: "security", Key: "server_header", Type: TypeString, Default: ServerHeaderNone},
+	{Section: "security", Key: "denylist", Type: TypeList, Default: ""},
+	{Section: "security", Key: "denylist_reads", Type: TypeBool, Default: "false"},
+	{Section: "security", Key: "tarpit", Type: TypeBool, Default: "false"},
+	{Section: "security", Key: "csp", Type: TypeString, Default: ""},
+	{Section: "security", Key: "csp_nonce", Type: TypeBool, Default: "false"},
+	{Section: "security", Key: "frame_options", Type: TypeString, Default: FrameOptionsDeny},
+	{Section: "security", Key: "hsts_include_subdomains", Type: TypeBool, Default: "false"},
+	{Section: "security", Key: "hsts_preload", Type: TypeBool, Default: "false"},
+	{Section: "security", Key: "coop", Type: TypeString, Default: ""},
+	{Section: "security", Key: "coep", Type: TypeString, Default: ""},
+	{Section: "security", Key: "corp", Type: TypeString, Default: ""},
+	{Section: "security", Key: "cors_origins", Type: TypeList, Default: ""},
+	{Section: "security", Key: "cors_methods", Type: TypeList, Default: "GET, POST, PUT, DELETE"},
+	{Section: "security", Key: "cors_headers", Type: TypeList, Default: "Content-Type, X-Requested-With, Authorization, X-Access-Proof, X-Secret-Key, X-Delete-Token"},
+
+	{Section: "callback", Key: "allowlist", Type: TypeList, Default: ""},
+	{Section: "callback", Key: "timeout", Type: TypeInt, Default: "5"},
+	{Section: "webhook", Key: "urls", Type: TypeList, Default: ""},
+	{Section: "webhook", Key: "secret", Type: TypeString, Default: ""},
+	{Section: "webhook", Key: "events", Type: TypeList, Default: ""},
+	{Section: "webhook", Key: "timeout", Type: TypeInt, Default: "5"},
+	{Section: "webhook", Key: "retries", Type: TypeInt, Default: "5"},
+	{Section: "webhook", Key: "queue", Type: TypeInt, Default: "1000"},
+	{Section: "webhook", Key: "workers", Type: TypeInt, Default: "2"},
+
+	{Section: "admin", Key: "token", Type: TypeString, Default: ""},
+	{Section: "admin", Key: "signed_tokens", Type: TypeBool, Default: "false"},
+
+	{Section: "tos", Key: "file", Type: TypeString, Default: ""},
+	{Section: "tos", Key: "required", Type: TypeBool, Default: "false"},
+
+	{Section: "server", Key: "timeout", Type: TypeInt, Default: "60"},
+	{Section: "server", Key: "timeout_health", Type: TypeInt, Default: "2"},
+	{Section: "server", Key: "timeout_create", Type: TypeInt, Default: "120"},
+	{Section: "server", Key: "timeout_read", Type: TypeInt, Default: "30"},
+	{Section: "server", Key: "max_concurrent_reads", Type: TypeInt, Default: "0"},
+	{Section: "server", Key: "max_concurrent_writes", Type: TypeInt, Default: "0"},
+	{Section: "server", Key: "conn_read_timeout", Type: TypeInt, Default: "0"},
+	{Section: "server", Key: "conn_header_timeout", Type: TypeInt, Default: "0"},
+	{Section: "server", Key: "conn_write_timeout", Type: TypeInt, Default: "0"},
+	{Section: "server", Key: "conn_idle_timeout", Type: TypeInt, Default: "120"},
+	{Section: "server", Key: "h2c", Type: TypeBool, Default: "false"},
+	{Section: "server", Key: "compress", Type: TypeBool, Default: "true"},
+	{Section: "server", Key: "compress_level", Type: TypeInt, Default: "5"},
+	{Section: "server", Key: "compress_types", Type: TypeList, Default: strings.Join(DefaultCompressTypes, ", ")},
+
+	{Section: "tokens", Key: AnyKey, Type: TypeString},
+	{Section: "token_quota_pastes", Key: AnyKey, Type: TypeInt},
+	{Section: "token_quota_bytes", Key: AnyKey, Type: TypeInt},
+	{Section: "token_rate_limit", Key: AnyKey, Type: TypeInt},
+	{Section: "token_rate_burst", Key: AnyKey, Type: TypeInt},
+
+	{Section: "auth", Key: "create", Type: TypeString, Default: ""},
+	{Section: "auth", Key: "oidc_issuer", Type: TypeString, Default: ""},
+	{Section: "auth", Key: "oidc_audience", Type: TypeString, Default: ""},
+	{Section: "auth_users", Key: AnyKey, Type: TypeString},
+
+	{Section: "instance", Key: "description", Type: TypeString, Default: ""},
+	{Section: "instance", Key: "contact", Type: TypeString, Default: ""},
+	{Section: "instance", Key: "public_key", Type: TypeString, Default: ""},
+
+	{Section: "api", Key: "secrets", Type: TypeBool, Default: "true"},
+	{Section: "api", Key: "server_encryption", Type: TypeBool, Default: "true"},
+
+	{Section: "events", Key: "enabled", Type: TypeBool, Default: "false"},
+	{Section: "events", Key: "max_clients", Type: TypeInt, Default: "1000"},
+	{Section: "events", Key: "heartbeat", Type: TypeInt, Default: "25"},
+	{Section: "events", Key: "redis", Type: TypeString, Default: ""},
+	{Section: "events", Key: "redis_password", Type: TypeString, Default: ""},
+	{Section: "events", Key: "redis_channel", Type: TypeString, Default: "flashpaper:events"},
+
+	{Section: "stats", Key: "enabled", Type: TypeBool, Default: "false"},
+	{Section: "stats", Key: "cache", Type: TypeInt, Default: "300"},
+
+	{Section: "pow", Key: "enabled", Type: TypeBool, Default: "false"},
+	{Section: "pow", Key: "difficulty", Type: TypeInt, Default: "16"},
+	{Section: "pow", Key: "ttl", Type: TypeInt, Default: "300"},
+
+	{Section: "captcha", Key: "provider", Type: TypeString, Default: ""},
+	{Section: "captcha", Key: "sitekey", Type: TypeString, Default: ""},
+	{Section: "captcha", Key: "secret", Type: TypeString, Default: ""},
+
+	{Section: "maintenance", Key: "enabled", Type: TypeBool, Default: "false"},
+	{Section: "maintenance", Key: "readonly", Type: TypeBool, Default: "false"},
+	{Section: "maintenance", Key: "message", Type: TypeString, Default: ""},
+
+	{Section: "metrics", Key: "enabled", Type: TypeBool, Default: "false"},
+	{Section: "metrics", Key: "address", Type: TypeString, Default: ""},
+	{Section: "metrics", Key: "token", Type: TypeString, Default: ""},
+	{Section: "metrics", Key: "debug", Type: TypeBool, Default: "false"},
+
+	{Section: "telemetry", Key: "enabled", Type: TypeBool, Default: "false"},
+	{Section: "telemetry", Key: "endpoint", Type: TypeString, Default: "http://localhost:4318"},
+	{Section: "telemetry", Key: "headers", Type: TypeList, Default: ""},
+	{Section: "telemetry", Key: "service_name", Type: TypeString, Default: "flashpaper"},
+	{Section: "telemetry", Key: "sample", Type: TypeInt, Default: "100"},
+	{Section: "telemetry", Key: "server_timing", Type: TypeBool, Default: "false"},
+
+	{Section: "softlimit", Key: "size", Type: TypeInt, Default: "80"},
+	{Section: "softlimit", Key: "comments", Type: TypeInt, Default: "80"},
+}
+
+// checkSchema compares an INI file against schema, returning a warning for
+// every unknown, deprecated, or mistyped key. Deprecated keys are copied to
+// their replacement in iniFile so the loader only needs to know new names.
+func checkSchema(iniFile *ini.File, schema []KeySpec) []string {
+	specs := make(map[string]map[string]KeySpec)
+	for _, spec := range schema {
+		if specs[spec.Section] == nil {
+			specs[spec.Section] = make(map[string]KeySpec)
+		}
+		specs[spec.Section][spec.Key] = spec
+	}
+
+	var warnings []string
+	for _, sec := range iniFile.Sections() {
+		name := sec.Name()
+		if name == ini.DefaultSection {
+			for _, key := range sec.Keys() {
+				warnings = append(warnings, fmt.Sprintf("key %q is outside any section and is ignored", key.Name()))
+			}
+			continue
+		}
+
+		keys, ok := specs[name]
+		if !ok {
+			warnings = append(warnings, unknownWarning(fmt.Sprintf("unknown section [%s]", name), name, sectionNames(schema)))
+			continue
+		}
+
+		for _, key := range sec.Keys() {
+			spec, ok := keys[key.Name()]
+			if !ok {
+				spec, ok = keys[AnyKey]
+			}
+			if !ok {
+				warnings = append(warnings, unknownWarning(
+					fmt.Sprintf("[%s] unknown key %q", name, key.Name()), key.Name(), keyNames(keys)))
+				continue
+			}
+
+			if !validValue(spec.Type, key.Value()) {
+				warnings = append(warnings, fmt.Sprintf("[%s] %s: %q is not a valid %s, using the default",
+					name, key.Name(), key.Value(), spec.Type))
+			}
+
+			if spec.DeprecatedBy != "" {
+				warnings = append(warnings, fmt.Sprintf("[%s] %s is deprecated, use %s instead",
+					name, key.Name(), spec.DeprecatedBy))
+				if !sec.HasKey(spec.DeprecatedBy) {
+					sec.NewKey(spec.DeprecatedBy, key.Value())
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// validValue reports whether value parses as the given type.
+// Empty values are accepted; they mean "use the default".
+func validValue(t KeyType, value string) bool {
+	if value == "" {
+		return true
+	}
+	switch t {
+	case TypeInt:
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	case TypeBool:
+		switch strings.ToLower(value) {
+		case "1", "t", "true", "y", "yes", "on", "0", "f", "false", "n", "no", "off":
+			return true
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// unknownWarning appends a "did you mean" hint when a close match exists.
+func unknownWarning(message, name string, candidates []string) string {
+	best, bestDistance := "", 3 // Only suggest within two edits
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("%s (did you mean %q?)", message, best)
+	}
+	return message + " and is ignored"
+}
+
+// sectionNames returns the distinct section names in schema.
+func sectionNames(schema []KeySpec) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, spec := range schema {
+		if !seen[spec.Section] {
+			seen[spec.Section] = true
+			names = append(names, spec.Section)
+		}
+	}
+	return names
+}
+
+// keyNames returns the non-deprecated, non-wildcard keys of a section.
+func keyNames(keys map[string]KeySpec) []string {
+	var names []string
+	for name, spec := range keys {
+		if name != AnyKey && spec.DeprecatedBy == "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
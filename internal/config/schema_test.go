@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+func loadString(t *testing.T, content string) *Config {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.ini")
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	cfg, err := Load(configPath)
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestSchema_DefaultsMatchDefaultConfig(t *testing.T) {
+	var b strings.Builder
+	section := ""
+	for _, spec := range Schema {
+		if spec.Key == AnyKey || spec.DeprecatedBy != "" {
+			continue
+		}
+		if spec.Section != section {
+			section = spec.Section
+			fmt.Fprintf(&b, "[%s]\n", section)
+		}
+		fmt.Fprintf(&b, "%s = %q\n", spec.Key, spec.Default)
+	}
+
+	cfg := loadString(t, b.String())
+	assert.Empty(t, cfg.Warnings)
+
+	cfg.Warnings = nil
+	assert.Equal(t, DefaultConfig(), cfg)
+}
+
+func TestSchema_SampleConfigHasNoWarnings(t *testing.T) {
+	cfg, err := Load(filepath.Join("..", "..", "config.sample.ini"))
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Warnings)
+}
+
+func TestLoad_WarnsOnMisspelledKey(t *testing.T) {
+	cfg := loadString(t, `
+[main]
+sizelimt = 1024
+`)
+	require.Len(t, cfg.Warnings, 1)
+	assert.Contains(t, cfg.Warnings[0], `"sizelimt"`)
+	assert.Contains(t, cfg.Warnings[0], `did you mean "sizelimit"?`)
+	assert.Equal(t, DefaultConfig().Main.SizeLimit, cfg.Main.SizeLimit)
+}
+
+func TestLoad_WarnsOnUnknownKeyAndSection(t *testing.T) {
+	cfg := loadString(t, `
+stray = 1
+
+[main]
+colour = blue
+
+[trafic]
+limit = 5
+
+[plugins]
+enabled = true
+`)
+	assert.Equal(t, []string{
+		`key "stray" is outside any section and is ignored`,
+		`[main] unknown key "colour" and is ignored`,
+		`unknown section [trafic] (did you mean "traffic"?)`,
+		`unknown section [plugins] and is ignored`,
+	}, cfg.Warnings)
+}
+
+func TestLoad_WarnsOnInvalidType(t *testing.T) {
+	cfg := loadString(t, `
+[main]
+port = eighty
+discussion = maybe
+
+[expire_options]
+2weeks = fortnight
+`)
+	require.Len(t, cfg.Warnings, 3)
+	assert.Contains(t, cfg.Warnings[0], "not a valid integer")
+	assert.Contains(t, cfg.Warnings[1], "not a valid boolean")
+	assert.Contains(t, cfg.Warnings[2], "not a valid integer")
+	assert.Equal(t, 8080, cfg.Main.Port)
+	assert.True(t, cfg.Main.Discussion)
+}
+
+func TestCheckSchema_DeprecatedKey(t *testing.T) {
+	schema := []KeySpec{
+		{Section: "traffic", Key: "limit", Type: TypeInt, Default: "10"},
+		{Section: "traffic", Key: "ratelimit", Type: TypeInt, DeprecatedBy: "limit"},
+	}
+
+	iniFile, err := ini.Load([]byte("[traffic]\nratelimit = 30\n"))
+	require.NoError(t, err)
+
+	warnings := checkSchema(iniFile, schema)
+	assert.Equal(t, []string{"[traffic] ratelimit is deprecated, use limit instead"}, warnings)
+	assert.Equal(t, 30, iniFile.Section("traffic").Key("limit").MustInt(0))
+}
+
+func TestCheckSchema_DeprecatedKeyDoesNotOverrideReplacement(t *testing.T) {
+	schema := []KeySpec{
+		{Section: "traffic", Key: "limit", Type: TypeInt, Default: "10"},
+		{Section: "traffic", Key: "ratelimit", Type: TypeInt, DeprecatedBy: "limit"},
+	}
+
+	iniFile, err := ini.Load([]byte("[traffic]\nlimit = 5\nratelimit = 30\n"))
+	require.NoError(t, err)
+
+	warnings := checkSchema(iniFile, schema)
+	assert.Len(t, warnings, 1)
+	assert.Equal(t, 5, iniFile.Section("traffic").Key("limit").MustInt(0))
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("limit", "limit"))
+	assert.Equal(t, 1, editDistance("sizelimt", "sizelimit"))
+	assert.Equal(t, 1, editDistance("trafic", "traffic"))
+	assert.Equal(t, 3, editDistance("", "dsn"))
+}
@@ -0,0 +1,128 @@
+// Package events provides an in-process bus for paste lifecycle events.
+// Handlers publish what happened (a paste was created, read, or deleted, a
+// comment was posted, a purge finished) without knowing who cares; features
+// such as creator callbacks and metrics subscribe to the events they need.
+// Adding an integration is then a matter of adding a subscriber.
+//
+// Delivery is synchronous and in subscription order, on the publisher's
+// goroutine. Subscribers that do slow work (network calls, disk writes)
+// must hand it off to their own goroutine.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// Kind identifies what happened.
+type Kind string
+
+// Lifecycle events.
+const (
+	// PasteCreated is published after a paste is stored
+	PasteCreated Kind = "paste.created"
+
+	// PasteRead is published after paste content has been sent to a client
+	PasteRead Kind = "paste.read"
+
+	// PasteDeleted is published after a paste is gone; see Reason
+	PasteDeleted Kind = "paste.deleted"
+
+	// CommentCreated is published after a comment is stored
+	CommentCreated Kind = "comment.created"
+
+	// CommentDeleted is published after a comment's author deleted it
+	CommentDeleted Kind = "comment.deleted"
+
+	// PurgeCompleted is published after a purge cycle removed expired pastes
+	PurgeCompleted Kind = "purge.completed"
+)
+
+// Reason explains why a paste was deleted.
+type Reason string
+
+// Deletion reasons.
+const (
+	// ReasonToken means a client deleted the paste with its delete token
+	ReasonToken Reason = "token"
+
+	// ReasonExpired means the paste was found past its expiration time
+	ReasonExpired Reason = "expired"
+
+	// ReasonBurned means the paste was deleted after being read once
+	ReasonBurned Reason = "burned"
+)
+
+// Event describes one lifecycle event. Fields that don't apply to the
+// event's Kind are left zero.
+type Event struct {
+	Kind Kind
+	Time time.Time
+
+	// PasteID is set for every kind except PurgeCompleted
+	PasteID string
+
+	// CommentID is set for CommentCreated and CommentDeleted
+	CommentID string
+
+	// Paste is the stored paste, for PasteCreated and PasteRead.
+	// Subscribers must not modify it.
+	Paste *model.Paste
+
+	// Expire is the expiration option the creator chose, for PasteCreated
+	Expire string
+
+	// First reports whether this was the paste's first read, for PasteRead
+	First bool
+
+	// Reason is set for PasteDeleted
+	Reason Reason
+
+	// Purged is the number of pastes removed, for PurgeCompleted
+	Purged int
+}
+
+// Subscriber receives published events.
+type Subscriber func(Event)
+
+// Bus fans events out to subscribers.
+// A nil Bus accepts subscriptions and publications and does nothing.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []Subscriber
+}
+
+// New creates an empty Bus.
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers fn to receive every event published from now on.
+func (b *Bus) Subscribe(fn Subscriber) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Publish delivers e to all subscribers, stamping the time if unset.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
@@ -0,0 +1,42 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus_PublishOrder(t *testing.T) {
+	bus := New()
+
+	var got []string
+	bus.Subscribe(func(e Event) { got = append(got, "a:"+string(e.Kind)) })
+	bus.Subscribe(func(e Event) { got = append(got, "b:"+string(e.Kind)) })
+
+	bus.Publish(Event{Kind: PasteCreated, PasteID: "abc"})
+	bus.Publish(Event{Kind: PasteDeleted, PasteID: "abc", Reason: ReasonToken})
+
+	assert.Equal(t, []string{
+		"a:paste.created", "b:paste.created",
+		"a:paste.deleted", "b:paste.deleted",
+	}, got)
+}
+
+func TestBus_StampsTime(t *testing.T) {
+	bus := New()
+
+	var got Event
+	bus.Subscribe(func(e Event) { got = e })
+
+	bus.Publish(Event{Kind: PurgeCompleted, Purged: 3})
+	assert.False(t, got.Time.IsZero())
+	assert.Equal(t, 3, got.Purged)
+}
+
+func TestBus_Nil(t *testing.T) {
+	var bus *Bus
+	assert.NotPanics(t, func() {
+		bus.Subscribe(func(Event) {})
+		bus.Publish(Event{Kind: PasteRead})
+	})
+}
@@ -0,0 +1,73 @@
+// Package handler provides server-checked paste passwords.
+// A paste password normally only feeds into the client-side key, so anyone
+// holding the link can fetch the ciphertext and try passwords offline as
+// fast as their hardware allows. With [main] access_proof, a creator can
+// also send a proof of the password in meta.accessproof; the server then
+// hands the paste out, and takes comments on it, only to requests
+// presenting the same proof in the X-Access-Proof header. Password guesses
+// have to go through the server and its read limits.
+//
+// The proof is opaque to the server. The bundled UI derives it from the
+// password and the key in the URL fragment, so the server, which never
+// sees the key, can't test passwords against it either.
+package handler
+
+import (
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// headerAccessProof carries the proof on reads and comments.
+const headerAccessProof = "X-Access-Proof"
+
+// Error codes for gated pastes. Both come with 403 Forbidden.
+const (
+	ErrCodeAccessProofRequired = "access_proof_required"
+	ErrCodeAccessProofInvalid  = "access_proof_invalid"
+)
+
+// Bounds of proof lengths. A hex or base64 HMAC-SHA256 fits, and short
+// proofs, which might be passwords sent as they are, don't.
+const (
+	MinAccessProofLength = 32
+	MaxAccessProofLength = 256
+)
+
+// accessProof returns the access proof in a create request's meta, "" if
+// there is none. It writes the error response itself if the proof is
+// refused.
+func (h *Handler) accessProof(w http.ResponseWriter, meta map[string]interface{}) (string, bool) {
+	proof, _ := meta["accessproof"].(string)
+	switch {
+	case proof == "":
+		return "", true
+	case !h.config.Main.AccessProof:
+		h.jsonError(w, "Access proofs are not enabled on this server", http.StatusBadRequest)
+		return "", false
+	case len(proof) < MinAccessProofLength || len(proof) > MaxAccessProofLength:
+		h.jsonError(w, "Invalid access proof", http.StatusBadRequest)
+		return "", false
+	}
+	return proof, true
+}
+
+// checkAccessProof reports whether a request may have a paste, writing the
+// 403 response itself if not. Pastes created without a proof are open to
+// everyone.
+func (h *Handler) checkAccessProof(w http.ResponseWriter, r *http.Request, pasteID string, paste *model.Paste) bool {
+	if paste.Meta.AccessProof == "" {
+		return true
+	}
+	proof := r.Header.Get(headerAccessProof)
+	if proof == "" {
+		h.jsonErrorCode(w, "This paste requires its password", ErrCodeAccessProofRequired, http.StatusForbidden)
+		return false
+	}
+	if !util.ValidateAccessProof(proof, pasteID, paste.Meta.AccessProof) {
+		h.jsonErrorCode(w, "Wrong password", ErrCodeAccessProofInvalid, http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+const testAccessProof = "3f9c1a2b3f9c1a2b3f9c1a2b3f9c1a2b3f9c1a2b3f9c1a2b3f9c1a2b3f9c1a2b"
+
+// createWithProof creates a paste with the given access proof.
+func createWithProof(h *Handler, proof string, burn int) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "ciphertext",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 1 - burn, burn},
+		"meta":  map[string]interface{}{"expire": "1day", "accessproof": proof},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr
+}
+
+// readWithProof reads a paste, presenting proof if it isn't empty.
+func readWithProof(h *Handler, pasteID, proof string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("X-Requested-With", "JSONHttpRequest")
+	if proof != "" {
+		req.Header.Set(headerAccessProof, proof)
+	}
+	rr := httptest.NewRecorder()
+	h.handleGet(rr, req)
+	return rr
+}
+
+// TestAccessProof_Read tests that gated pastes are only served with the
+// proof, and that failed reads don't burn them.
+func TestAccessProof_Read(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Main.AccessProof = true
+
+	rr := createWithProof(h, testAccessProof, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var created map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	id, _ := created["id"].(string)
+
+	stored, _ := mockStore.ReadPaste(ctx, id)
+	if stored.Meta.AccessProof == "" || stored.Meta.AccessProof == testAccessProof {
+		t.Errorf("expected the proof stored hashed, got %q", stored.Meta.AccessProof)
+	}
+
+	tests := []struct {
+		proof string
+		code  string
+	}{
+		{"", ErrCodeAccessProofRequired},
+		{strings.Repeat("0", 64), ErrCodeAccessProofInvalid},
+	}
+	for _, tt := range tests {
+		rr := readWithProof(h, id, tt.proof)
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if rr.Code != http.StatusForbidden || response["code"] != tt.code {
+			t.Errorf("proof %q: expected %d %s, got %d %v", tt.proof, http.StatusForbidden, tt.code, rr.Code, response)
+		}
+	}
+	if !mockStore.PasteExists(ctx, id) {
+		t.Fatal("expected the paste to survive failed reads")
+	}
+
+	rr = readWithProof(h, id, testAccessProof)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d with the proof, got %d", http.StatusOK, rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), stored.Meta.AccessProof) {
+		t.Error("expected the stored hash kept from the response")
+	}
+	if mockStore.PasteExists(ctx, id) {
+		t.Error("expected the paste burned")
+	}
+}
+
+// TestAccessProof_Create tests refused proofs.
+func TestAccessProof_Create(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	if rr := createWithProof(h, testAccessProof, 0); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d while disabled, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	h.config.Main.AccessProof = true
+	if rr := createWithProof(h, "hunter2", 0); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a short proof, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr := createWithProof(h, "", 0); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d without a proof, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+// TestAccessProof_Comment tests that comments on gated pastes need the proof.
+func TestAccessProof_Comment(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Main.AccessProof = true
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	paste.Meta.OpenDiscussion = true
+	paste.Meta.AccessProof = "stored-hash"
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	rr := postComment(h, pasteID, "comment")
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if rr.Code != http.StatusForbidden || response["code"] != ErrCodeAccessProofRequired {
+		t.Errorf("expected %d %s, got %d %v", http.StatusForbidden, ErrCodeAccessProofRequired, rr.Code, response)
+	}
+	if n, _ := mockStore.CountComments(ctx, pasteID); n != 0 {
+		t.Errorf("expected no comments, got %d", n)
+	}
+}
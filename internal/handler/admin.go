@@ -0,0 +1,81 @@
+// Package handler provides the operator API under /admin.
+// Admin endpoints manage instance-wide state at runtime, so routine changes
+// don't need a config edit and restart. They are only mounted when an admin
+// token is configured, and every request must present it as a bearer token.
+// Instead of keeping a static token, operators can enable signed tokens and
+// mint short-lived ones with "flashpaper admin token".
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// adminRoutes returns the router mounted at /admin.
+func (h *Handler) adminRoutes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(h.requireAdmin)
+
+	r.Get("/announcement", h.getAnnouncement)
+	r.Put("/announcement", h.putAnnouncement)
+	r.Delete("/announcement", h.deleteAnnouncement)
+
+	r.Get("/denylist", h.getDenylist)
+	r.Post("/denylist", h.addDenylist)
+	r.Delete("/denylist", h.removeDenylist)
+
+	r.Get("/maintenance", h.getMaintenance)
+	r.Put("/maintenance", h.putMaintenance)
+	r.Delete("/maintenance", h.deleteMaintenance)
+
+	r.Get("/metrics", h.getMetrics)
+
+	r.Post("/templates/reload", h.reloadTemplates)
+
+	r.Get("/tokens", h.getTokenUsage)
+
+	r.Get("/whoami", h.whoami)
+
+	r.Put("/pastes/{pasteID}/pin", h.pinPaste)
+	r.Delete("/pastes/{pasteID}/pin", h.unpinPaste)
+
+	r.Get("/comments/pending", h.getPendingComments)
+	r.Post("/comments/{pasteID}/{commentID}/approve", h.approveComment)
+	r.Post("/comments/{pasteID}/{commentID}/reject", h.rejectComment)
+
+	return r
+}
+
+// requireAdmin rejects requests without the configured admin bearer token.
+func (h *Handler) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="flashpaper-admin"`)
+			h.jsonError(w, "Admin authentication required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether the request carries the admin token, or an
+// unexpired signed token when [admin] signed_tokens is on.
+// The comparison is constant-time so the token can't be guessed bytewise.
+func (h *Handler) isAdmin(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		return false
+	}
+
+	if want := h.config.Admin.Token; want != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+		return true
+	}
+	return h.config.Admin.SignedTokens && util.ValidateAdminToken(token, h.salt, h.clock.Now())
+}
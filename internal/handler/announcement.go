@@ -0,0 +1,118 @@
+// Package handler provides the instance announcement banner.
+// Operators set a short notice ("maintenance Sunday 02:00 UTC") through the
+// admin API; it is kept in key-value storage, shown at the top of the UI,
+// and included in the bootstrap config for other clients.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// MaxAnnouncementLength is the longest announcement accepted, in characters.
+const MaxAnnouncementLength = 500
+
+// Announcement levels, matching the UI's alert styles.
+const (
+	AnnouncementInfo    = "info"
+	AnnouncementWarning = "warning"
+	AnnouncementDanger  = "danger"
+)
+
+// announcementKey is the key-value key holding the current announcement.
+const announcementKey = "announcement"
+
+// Announcement is an instance-wide notice shown to all users.
+type Announcement struct {
+	Message string `json:"message"`
+	Level   string `json:"level"`   // info, warning, or danger
+	Updated int64  `json:"updated"` // Unix timestamp of the last change
+}
+
+// announcement returns the current announcement, or nil if none is set.
+func (h *Handler) announcement(ctx context.Context) *Announcement {
+	value, err := h.store.GetValue(ctx, storage.NamespaceAdmin, announcementKey)
+	if err != nil || value == "" {
+		return nil
+	}
+
+	var a Announcement
+	if err := json.Unmarshal([]byte(value), &a); err != nil || a.Message == "" {
+		return nil
+	}
+	return &a
+}
+
+// getAnnouncement handles GET /admin/announcement.
+func (h *Handler) getAnnouncement(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	h.jsonSuccess(w, map[string]interface{}{
+		"announcement": h.announcement(ctx),
+	})
+}
+
+// putAnnouncement handles PUT /admin/announcement.
+// Request format:
+//
+//	{"message": "Maintenance Sunday 02:00 UTC", "level": "warning"}
+//
+// The level defaults to info.
+func (h *Handler) putAnnouncement(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Message string `json:"message"`
+		Level   string `json:"level"`
+	}
+	if !h.decodeJSON(w, r.Body, &req) {
+		return
+	}
+
+	a := Announcement{
+		Message: strings.TrimSpace(req.Message),
+		Level:   req.Level,
+		Updated: h.clock.Now().Unix(),
+	}
+	if a.Message == "" {
+		h.jsonError(w, "No message provided", http.StatusBadRequest)
+		return
+	}
+	if utf8.RuneCountInString(a.Message) > MaxAnnouncementLength {
+		h.jsonError(w, "Announcement is too long", http.StatusBadRequest)
+		return
+	}
+	switch a.Level {
+	case "":
+		a.Level = AnnouncementInfo
+	case AnnouncementInfo, AnnouncementWarning, AnnouncementDanger:
+		// Valid
+	default:
+		h.jsonError(w, "Level must be info, warning, or danger", http.StatusBadRequest)
+		return
+	}
+
+	value, _ := json.Marshal(a)
+	if err := h.store.SetValue(ctx, storage.NamespaceAdmin, announcementKey, string(value)); err != nil {
+		h.jsonError(w, "Failed to store announcement", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"announcement": a,
+	})
+}
+
+// deleteAnnouncement handles DELETE /admin/announcement.
+func (h *Handler) deleteAnnouncement(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	// The Storage interface has no delete for values; empty means unset
+	if err := h.store.SetValue(ctx, storage.NamespaceAdmin, announcementKey, ""); err != nil {
+		h.jsonError(w, "Failed to clear announcement", http.StatusInternalServerError)
+		return
+	}
+	h.jsonSuccess(w, map[string]interface{}{})
+}
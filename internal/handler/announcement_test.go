@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+const testAdminToken = "test-admin-token-0123456789"
+
+// adminRequest sends a request to the admin API through the router.
+func adminRequest(h *Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var reader *bytes.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	return rr
+}
+
+// TestAdmin_Authentication tests that admin endpoints require the token.
+func TestAdmin_Authentication(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	// Not mounted without a configured token
+	if rr := adminRequest(h, http.MethodGet, "/admin/announcement", "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d with admin disabled, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	h.config.Admin.Token = testAdminToken
+
+	rr := adminRequest(h, http.MethodGet, "/admin/announcement", "", nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if rr.Header().Get("WWW-Authenticate") == "" {
+		t.Error("expected WWW-Authenticate header")
+	}
+
+	if rr := adminRequest(h, http.MethodGet, "/admin/announcement", "wrong-token-0123456789", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d with wrong token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	if rr := adminRequest(h, http.MethodGet, "/admin/announcement", testAdminToken, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d with token, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+// TestAdmin_SignedTokens tests short-lived tokens signed with the server salt.
+func TestAdmin_SignedTokens(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Admin.SignedTokens = true
+
+	token, err := util.GenerateAdminToken(h.salt, time.Now().Add(15*time.Minute))
+	if err != nil {
+		t.Fatalf("GenerateAdminToken failed: %v", err)
+	}
+	if rr := adminRequest(h, http.MethodGet, "/admin/announcement", token, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d with signed token, got %d", http.StatusOK, rr.Code)
+	}
+
+	expired, _ := util.GenerateAdminToken(h.salt, time.Now().Add(-time.Minute))
+	if rr := adminRequest(h, http.MethodGet, "/admin/announcement", expired, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d with expired token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	// Signed tokens are refused unless enabled, even with a static token set
+	h.config.Admin.SignedTokens = false
+	h.config.Admin.Token = testAdminToken
+	if rr := adminRequest(h, http.MethodGet, "/admin/announcement", token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d with signed tokens disabled, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+// TestAnnouncement_Lifecycle tests setting, reading, and clearing the banner.
+func TestAnnouncement_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+
+	rr := adminRequest(h, http.MethodPut, "/admin/announcement", testAdminToken, map[string]string{
+		"message": "  Maintenance Sunday 02:00 UTC  ",
+		"level":   "warning",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	a := h.announcement(ctx)
+	if a == nil || a.Message != "Maintenance Sunday 02:00 UTC" || a.Level != "warning" || a.Updated == 0 {
+		t.Fatalf("unexpected announcement %+v", a)
+	}
+
+	// Exposed in the bootstrap config
+	if cfg := h.clientConfig(ctx); cfg.Announcement == nil || cfg.Announcement.Message != a.Message {
+		t.Errorf("expected announcement in client config, got %+v", cfg.Announcement)
+	}
+
+	rr = adminRequest(h, http.MethodGet, "/admin/announcement", testAdminToken, nil)
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if got, _ := response["announcement"].(map[string]interface{}); got["level"] != "warning" {
+		t.Errorf("unexpected GET response %s", rr.Body.String())
+	}
+
+	rr = adminRequest(h, http.MethodDelete, "/admin/announcement", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if h.announcement(ctx) != nil {
+		t.Error("expected announcement to be cleared")
+	}
+	if h.clientConfig(ctx).Announcement != nil {
+		t.Error("expected no announcement in client config")
+	}
+}
+
+// TestAnnouncement_Validation tests rejected announcements.
+func TestAnnouncement_Validation(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+
+	tests := []struct {
+		name string
+		body map[string]string
+	}{
+		{"empty message", map[string]string{"message": "   "}},
+		{"too long", map[string]string{"message": strings.Repeat("x", MaxAnnouncementLength+1)}},
+		{"bad level", map[string]string{"message": "hi", "level": "purple"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := adminRequest(h, http.MethodPut, "/admin/announcement", testAdminToken, tt.body)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+		})
+	}
+
+	// Level defaults to info
+	adminRequest(h, http.MethodPut, "/admin/announcement", testAdminToken, map[string]string{"message": "hi"})
+	if a := h.announcement(ctx); a == nil || a.Level != AnnouncementInfo {
+		t.Errorf("expected info level, got %+v", a)
+	}
+}
+
+// TestAnnouncement_RenderedEscaped tests that the banner is HTML-escaped in the UI.
+func TestAnnouncement_RenderedEscaped(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initStaticFS()
+	h.initTemplates()
+	h.config.Admin.Token = testAdminToken
+
+	adminRequest(h, http.MethodPut, "/admin/announcement", testAdminToken, map[string]string{
+		"message": "<b>Upgrade</b> tonight",
+		"level":   "danger",
+	})
+
+	rr := httptest.NewRecorder()
+	h.serveUI(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `class="alert alert-danger announcement"`) {
+		t.Error("expected announcement banner in page")
+	}
+	if !strings.Contains(body, "&lt;b&gt;Upgrade&lt;/b&gt; tonight") {
+		t.Error("expected escaped announcement message")
+	}
+}
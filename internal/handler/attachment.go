@@ -0,0 +1,329 @@
+// Package handler provides attachment uploads and downloads.
+// Attachments used to travel only inside the paste's JSON document, so the
+// whole file was held in memory on every create and read. They are now
+// limited by [main] attachmentlimit on their own, apart from sizelimit, and
+// stored apart from the paste when the backend implements
+// storage.AttachmentStore.
+//
+// Besides the JSON "attachment" field, a paste can be created with a
+// multipart/form-data request: a "paste" part holding the usual JSON
+// request, followed by "attachment" parts that are streamed to storage.
+// Attachment parts may declare Content-Transfer-Encoding: base64. Reads
+// still return attachments in the "attachment" field, streamed from
+// storage into the response.
+//
+// Since PrivateBin 1.7 "attachment" and "attachmentname" may be arrays, one
+// entry per file. Pastes created with arrays, or with several attachment
+// parts, are returned with arrays; the others keep the single string form.
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// errAttachmentTooLarge is returned while reading an upload once it
+// passes [main] attachmentlimit.
+var errAttachmentTooLarge = errors.New("Attachment exceeds size limit")
+
+// handleMultipartPost creates a paste from a multipart/form-data request.
+func (h *Handler) handleMultipartPost(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		h.jsonError(w, "Invalid multipart request", http.StatusBadRequest)
+		return
+	}
+
+	part, err := mr.NextPart()
+	if isTooLarge(err) {
+		h.tooLarge(w)
+		return
+	}
+	if err != nil || part.FormName() != "paste" {
+		h.jsonError(w, "Expected paste part first", http.StatusBadRequest)
+		return
+	}
+	var req map[string]interface{}
+	if !h.decodeJSON(w, part, &req) {
+		return
+	}
+
+	if _, ok := req["attachment"]; ok {
+		h.jsonError(w, "Attachments must be sent as attachment parts", http.StatusBadRequest)
+		return
+	}
+
+	h.createPaste(w, r, req, mr)
+}
+
+// partReader returns the content of a multipart part, decoding base64
+// transfer encoding.
+func partReader(part *multipart.Part) io.Reader {
+	if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, part)
+	}
+	return part
+}
+
+// limitedUpload counts an attachment as it is read, failing with
+// errAttachmentTooLarge once it passes the limit, and remembers the first
+// read error so it can be told apart from storage errors.
+type limitedUpload struct {
+	r     io.Reader
+	n     int64
+	limit int64
+	err   error
+}
+
+func (u *limitedUpload) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	u.n += int64(n)
+	if u.n > u.limit {
+		err = errAttachmentTooLarge
+	}
+	if err != nil && err != io.EOF && u.err == nil {
+		u.err = err
+	}
+	return n, err
+}
+
+// stringList reads an "attachment" or "attachmentname" request field,
+// either a single string or an array of strings. list reports the array
+// form; ok is false if the field is neither.
+func stringList(v interface{}) (values model.StringList, list, ok bool) {
+	switch v := v.(type) {
+	case nil:
+		return nil, false, true
+	case string:
+		if v == "" {
+			return nil, false, true
+		}
+		return model.StringList{v}, false, true
+	case []interface{}:
+		values = make(model.StringList, len(v))
+		for i, item := range v {
+			s, isString := item.(string)
+			if !isString {
+				return nil, false, false
+			}
+			values[i] = s
+		}
+		return values, true, true
+	}
+	return nil, false, false
+}
+
+// readAttachments sets a new paste's attachments and their names from the
+// request fields. The attachments themselves come from the request only if
+// the paste wasn't sent as multipart; their total is checked against
+// [main] attachmentlimit. It answers the request itself and returns false
+// if that fails.
+func (h *Handler) readAttachments(w http.ResponseWriter, req map[string]interface{}, paste *model.Paste, multipart bool) bool {
+	attachments, list, ok := stringList(req["attachment"])
+	if !ok {
+		h.jsonError(w, "Invalid attachment", http.StatusBadRequest)
+		return false
+	}
+	names, namesList, ok := stringList(req["attachmentname"])
+	if !ok {
+		h.jsonError(w, "Invalid attachment name", http.StatusBadRequest)
+		return false
+	}
+
+	var total int64
+	for _, attachment := range attachments {
+		total += int64(len(attachment))
+	}
+	if total > h.live().Main.AttachmentLimit {
+		h.jsonError(w, errAttachmentTooLarge.Error(), http.StatusBadRequest)
+		return false
+	}
+	// Multipart attachments are counted as they are stored
+	if !multipart && len(names) > 0 && len(names) != len(attachments) {
+		h.jsonError(w, "Attachment names don't match attachments", http.StatusBadRequest)
+		return false
+	}
+
+	paste.Attachments = attachments
+	paste.AttachmentNames = names
+	paste.Meta.AttachmentList = list || namesList
+	return true
+}
+
+// storeAttachments stores a new paste's attachments, from the parts left
+// in parts if the paste came in a multipart request and from the JSON
+// field otherwise. Backends with an AttachmentStore get each as a separate
+// object; for the others uploads are read into the paste. It answers the
+// request itself and returns false if that fails.
+func (h *Handler) storeAttachments(w http.ResponseWriter, r *http.Request, id string, paste *model.Paste, parts *multipart.Reader) bool {
+	ctx := r.Context()
+	store, separate := storage.Attachments(h.store)
+	if parts == nil && (!separate || len(paste.Attachments) == 0) {
+		return true
+	}
+
+	// One limit across all attachments of the paste
+	src := &limitedUpload{limit: h.live().Main.AttachmentLimit}
+	var sizes []int64
+	var attachments model.StringList
+	var err error
+	for i := 0; err == nil && src.err == nil; i++ {
+		if parts == nil {
+			if i == len(paste.Attachments) {
+				break
+			}
+			src.r = strings.NewReader(paste.Attachments[i])
+		} else {
+			part, partErr := parts.NextPart()
+			if errors.Is(partErr, io.EOF) {
+				break
+			}
+			if partErr != nil {
+				src.err = partErr
+				break
+			}
+			if part.FormName() != "attachment" {
+				if separate {
+					store.DeleteAttachments(ctx, id)
+				}
+				h.jsonError(w, "Unexpected part "+part.FormName(), http.StatusBadRequest)
+				return false
+			}
+			src.r = partReader(part)
+		}
+
+		if separate {
+			var n int64
+			n, err = store.WriteAttachment(ctx, id, i, src)
+			sizes = append(sizes, n)
+		} else {
+			var data []byte
+			data, err = io.ReadAll(src)
+			attachments = append(attachments, string(data))
+		}
+	}
+	if separate && (err != nil || src.err != nil) {
+		store.DeleteAttachments(ctx, id)
+	}
+
+	switch {
+	case errors.Is(src.err, errAttachmentTooLarge):
+		h.jsonError(w, errAttachmentTooLarge.Error(), http.StatusBadRequest)
+		return false
+	case isTooLarge(src.err):
+		h.tooLarge(w)
+		return false
+	case src.err != nil:
+		h.jsonError(w, "Failed to read attachment", http.StatusBadRequest)
+		return false
+	case err != nil:
+		logging.FromContext(r.Context()).Error("Failed to store attachment", "error", err)
+		h.jsonError(w, "Failed to store attachment", http.StatusInternalServerError)
+		return false
+	}
+
+	if parts != nil {
+		count := len(attachments) + len(sizes)
+		if len(paste.AttachmentNames) > 0 && len(paste.AttachmentNames) != count {
+			if separate {
+				store.DeleteAttachments(ctx, id)
+			}
+			h.jsonError(w, "Attachment names don't match attachments", http.StatusBadRequest)
+			return false
+		}
+		if count > 1 {
+			paste.Meta.AttachmentList = true
+		}
+	}
+	if separate {
+		paste.Attachments = nil
+		paste.Meta.AttachmentSizes = sizes
+	} else {
+		paste.Attachments = attachments
+	}
+	return true
+}
+
+// discardAttachments removes the separately stored attachments of a paste
+// that could not be created, or that was burned after reading.
+func (h *Handler) discardAttachments(ctx context.Context, id string, paste *model.Paste) {
+	if len(paste.Meta.AttachmentSizes) == 0 {
+		return
+	}
+	if store, ok := storage.Attachments(h.store); ok {
+		store.DeleteAttachments(ctx, id)
+	}
+}
+
+// openAttachments opens a paste's separately stored attachments for a
+// read. It answers the request itself and returns false if that fails.
+func (h *Handler) openAttachments(w http.ResponseWriter, r *http.Request, pasteID string, paste *model.Paste) ([]io.ReadCloser, bool) {
+	ctx := r.Context()
+	attachments := make([]io.ReadCloser, 0, len(paste.Meta.AttachmentSizes))
+	for i := range paste.Meta.AttachmentSizes {
+		attachment, err := storage.OpenAttachment(ctx, h.store, pasteID, paste, i)
+		if err != nil {
+			closeAll(attachments)
+			logging.FromContext(r.Context()).Error("Failed to open attachment", "index", i, "error", err)
+			h.jsonError(w, "Failed to read attachment", http.StatusInternalServerError)
+			return nil, false
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, true
+}
+
+// closeAll closes opened attachments.
+func closeAll(attachments []io.ReadCloser) {
+	for _, attachment := range attachments {
+		attachment.Close()
+	}
+}
+
+// writeWithAttachments writes doc, which must encode as a JSON object,
+// with attachments streamed into an added "attachment" field: a string if
+// there is one and asList isn't set, an array of strings otherwise.
+func writeWithAttachments(w io.Writer, doc interface{}, attachments []io.ReadCloser, asList bool) error {
+	o, err := openObject(w, doc)
+	if err != nil {
+		return err
+	}
+	if err := writeAttachments(o, attachments, asList); err != nil {
+		return err
+	}
+	return o.close()
+}
+
+// jsonStringWriter escapes what it writes for a JSON string literal, the
+// way encoding/json does for ASCII. Other bytes pass through unchanged.
+type jsonStringWriter struct {
+	w io.Writer
+}
+
+func (j jsonStringWriter) Write(p []byte) (int, error) {
+	const hex = "0123456789abcdef"
+	buf := make([]byte, 0, len(p)+16)
+	for _, b := range p {
+		switch {
+		case b == '"' || b == '\\':
+			buf = append(buf, '\\', b)
+		case b < 0x20 || b == '<' || b == '>' || b == '&':
+			buf = append(buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf])
+		default:
+			buf = append(buf, b)
+		}
+	}
+	if _, err := j.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
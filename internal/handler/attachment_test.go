@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// attachmentPaste is the JSON request of the test pastes.
+var attachmentPaste = map[string]interface{}{
+	"v":              2,
+	"ct":             "encrypted-content",
+	"adata":          []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+	"meta":           map[string]interface{}{"expire": "1day"},
+	"attachmentname": "encrypted-name",
+}
+
+// createMultipart posts a paste with its attachment in a multipart part,
+// base64-encoded if encode is set.
+func createMultipart(h *Handler, attachment string, encode bool) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	pastePart, _ := mw.CreateFormField("paste")
+	json.NewEncoder(pastePart).Encode(attachmentPaste)
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="attachment"`)
+	if encode {
+		header.Set("Content-Transfer-Encoding", "base64")
+		attachment = base64.StdEncoding.EncodeToString([]byte(attachment))
+	}
+	attachmentPart, _ := mw.CreatePart(header)
+	attachmentPart.Write([]byte(attachment))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr
+}
+
+// readAttachment reads a paste through the JSON API and returns its attachment.
+func readAttachment(t *testing.T, h *Handler, pasteID string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.handleGet(rr, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", rr.Body.String(), err)
+	}
+	if response["status"] != float64(0) || response["attachmentname"] != "encrypted-name" {
+		t.Fatalf("unexpected response %v", response)
+	}
+	attachment, _ := response["attachment"].(string)
+	return attachment
+}
+
+// TestAttachment_Multipart tests streamed uploads, plain and base64-encoded.
+func TestAttachment_Multipart(t *testing.T) {
+	ctx := context.Background()
+	for _, encode := range []bool{false, true} {
+		h, mockStore := newTestHandler(t)
+		attachment := "data:application/octet-stream;base64," + strings.Repeat("QUJD", 1000)
+
+		rr := createMultipart(h, attachment, encode)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("encode %v: expected status %d, got %d: %s", encode, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		pasteID := response["id"].(string)
+
+		paste, _ := mockStore.ReadPaste(ctx, pasteID)
+		if len(paste.Attachments) != 0 || paste.AttachmentLength() != int64(len(attachment)) || paste.Meta.AttachmentList {
+			t.Errorf("encode %v: expected one attachment stored apart, got %q sizes %v", encode, paste.Attachments, paste.Meta.AttachmentSizes)
+		}
+		if got := string(mockStore.GetAttachment(pasteID, 0)); got != attachment {
+			t.Errorf("encode %v: stored attachment differs", encode)
+		}
+		if got := readAttachment(t, h, pasteID); got != attachment {
+			t.Errorf("encode %v: read attachment differs", encode)
+		}
+	}
+}
+
+// TestAttachment_JSONStoredApart tests that JSON attachments are stored
+// apart too, and escaped correctly when streamed back.
+func TestAttachment_JSONStoredApart(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	attachment := "{\"ct\":\"a<b>&c\\\\d\"}\n\t\x01é"
+
+	req := map[string]interface{}{"attachment": attachment}
+	for k, v := range attachmentPaste {
+		req[k] = v
+	}
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, httpReq)
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	pasteID, _ := response["id"].(string)
+	if got := string(mockStore.GetAttachment(pasteID, 0)); got != attachment {
+		t.Fatalf("expected attachment stored apart, got %q", got)
+	}
+
+	if got := readAttachment(t, h, pasteID); got != attachment {
+		t.Errorf("expected %q, got %q", attachment, got)
+	}
+
+	var doc rawDocument
+	if err := json.Unmarshal(requestRaw(h, pasteID).Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Attachment != attachment || doc.CipherText != "encrypted-content" {
+		t.Errorf("unexpected raw document %+v", doc)
+	}
+}
+
+// TestAttachment_SizeLimit tests [main] attachmentlimit on both upload paths.
+func TestAttachment_SizeLimit(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	h.config.Main.AttachmentLimit = 100
+	attachment := strings.Repeat("A", 101)
+
+	if rr := createMultipart(h, attachment, false); rr.Code != http.StatusBadRequest {
+		t.Errorf("multipart: expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	req := map[string]interface{}{"attachment": attachment}
+	for k, v := range attachmentPaste {
+		req[k] = v
+	}
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, httpReq)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("json: expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	if mockStore.GetPasteCount() != 0 {
+		t.Error("expected no paste stored")
+	}
+
+	if rr := createMultipart(h, attachment[:100], false); rr.Code != http.StatusOK {
+		t.Errorf("expected attachment at the limit accepted, got %d", rr.Code)
+	}
+}
+
+// TestAttachment_MultipartPasteFirst tests that the paste part must come first.
+func TestAttachment_MultipartPasteFirst(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormField("attachment")
+	part.Write([]byte("data"))
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// createJSON posts a paste with the given attachment fields.
+func createJSON(h *Handler, attachment, attachmentName interface{}) *httptest.ResponseRecorder {
+	req := map[string]interface{}{"attachment": attachment}
+	for k, v := range attachmentPaste {
+		req[k] = v
+	}
+	req["attachmentname"] = attachmentName
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, httpReq)
+	return rr
+}
+
+// readAttachments reads a paste through the JSON API and returns its
+// attachment fields as decoded.
+func readAttachments(t *testing.T, h *Handler, pasteID string) (attachment, attachmentName interface{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.handleGet(rr, req)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %q: %v", rr.Body.String(), err)
+	}
+	return response["attachment"], response["attachmentname"]
+}
+
+// TestAttachment_Lists tests PrivateBin 1.7 attachment arrays, and that
+// each paste is returned in the form it was created with.
+func TestAttachment_Lists(t *testing.T) {
+	tests := []struct {
+		name       string
+		attachment interface{}
+		names      interface{}
+	}{
+		{"single", "data:a", "name-a"},
+		{"one in array", []interface{}{"data:a"}, []interface{}{"name-a"}},
+		{"two", []interface{}{"data:a", "data:\"b\""}, []interface{}{"name-a", "name-b"}},
+	}
+	for _, tt := range tests {
+		h, _ := newTestHandler(t)
+		rr := createJSON(h, tt.attachment, tt.names)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", tt.name, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		pasteID := response["id"].(string)
+
+		attachment, names := readAttachments(t, h, pasteID)
+		if !reflect.DeepEqual(attachment, tt.attachment) || !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: expected %v %v, got %v %v", tt.name, tt.attachment, tt.names, attachment, names)
+		}
+
+		var doc map[string]interface{}
+		json.Unmarshal(requestRaw(h, pasteID).Body.Bytes(), &doc)
+		if !reflect.DeepEqual(doc["attachment"], tt.attachment) || !reflect.DeepEqual(doc["attachmentname"], tt.names) {
+			t.Errorf("%s: unexpected raw document %v", tt.name, doc)
+		}
+	}
+}
+
+// TestAttachment_ListsMultipart tests several attachment parts.
+func TestAttachment_ListsMultipart(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	pastePart, _ := mw.CreateFormField("paste")
+	req := map[string]interface{}{}
+	for k, v := range attachmentPaste {
+		req[k] = v
+	}
+	req["attachmentname"] = []string{"name-a", "name-b"}
+	json.NewEncoder(pastePart).Encode(req)
+	for _, data := range []string{"data:a", "data:b"} {
+		part, _ := mw.CreateFormField("attachment")
+		part.Write([]byte(data))
+	}
+	mw.Close()
+
+	httpReq := httptest.NewRequest(http.MethodPost, "/", &body)
+	httpReq.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, httpReq)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	pasteID := response["id"].(string)
+
+	if got := string(mockStore.GetAttachment(pasteID, 1)); got != "data:b" {
+		t.Errorf("expected second attachment stored, got %q", got)
+	}
+	attachment, _ := readAttachments(t, h, pasteID)
+	if want := []interface{}{"data:a", "data:b"}; !reflect.DeepEqual(attachment, want) {
+		t.Errorf("expected %v, got %v", want, attachment)
+	}
+}
+
+// TestAttachment_ListsInvalid tests rejected attachment fields.
+func TestAttachment_ListsInvalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		attachment interface{}
+		names      interface{}
+	}{
+		{"names mismatch", []interface{}{"data:a", "data:b"}, []interface{}{"name-a"}},
+		{"not strings", []interface{}{1, 2}, nil},
+		{"not a list", map[string]interface{}{"a": "b"}, nil},
+	}
+	for _, tt := range tests {
+		h, mockStore := newTestHandler(t)
+		if rr := createJSON(h, tt.attachment, tt.names); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", tt.name, http.StatusBadRequest, rr.Code)
+		}
+		if mockStore.GetPasteCount() != 0 {
+			t.Errorf("%s: expected no paste stored", tt.name)
+		}
+	}
+
+	// The limit applies to the total
+	h, _ := newTestHandler(t)
+	h.config.Main.AttachmentLimit = 100
+	two := []interface{}{strings.Repeat("A", 60), strings.Repeat("A", 60)}
+	if rr := createJSON(h, two, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("total: expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
@@ -0,0 +1,114 @@
+// Package handler provides the authentication gate for paste creation.
+// Internal instances may want anyone to read pastes but only staff to post
+// them. With [auth] create = "basic", creators send HTTP Basic credentials
+// of [auth_users], which browsers prompt for; with "oidc", a bearer token
+// from the organization's OpenID Connect provider. API tokens ([tokens])
+// pass the gate either way, so automation keeps working. Reading, and
+// commenting on, existing pastes stays open.
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/oidc"
+)
+
+// ErrCodeUnauthenticated is the error code of creations refused by the gate.
+const ErrCodeUnauthenticated = "unauthenticated"
+
+// authenticateCreator checks that a paste creation carries a valid API
+// token or the credentials [auth] create asks for, answering 401
+// Unauthorized itself if not. It returns the API token's name, "" for
+// creations without one.
+func (h *Handler) authenticateCreator(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token, ok := h.apiToken(r)
+	if ok && token != "" {
+		return token, true
+	}
+
+	switch h.config.Auth.Create {
+	case config.AuthBasic:
+		if h.basicUser(r) {
+			return "", true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="flashpaper", charset="UTF-8"`)
+	case config.AuthOIDC:
+		// Bearer tokens matching no API token may be OIDC tokens
+		if h.oidcUser(r) {
+			return "", true
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="flashpaper"`)
+	default:
+		if ok {
+			return "", true
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="flashpaper"`)
+		h.jsonErrorCode(w, "Invalid API token", ErrCodeInvalidToken, http.StatusUnauthorized)
+		return "", false
+	}
+	h.jsonErrorCode(w, "You must log in to create pastes", ErrCodeUnauthenticated, http.StatusUnauthorized)
+	return "", false
+}
+
+// dummyHash is compared against for unknown user names, so they take as
+// long to refuse as wrong passwords and can't be told apart by timing.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("flashpaper"), bcrypt.DefaultCost)
+	return hash
+})
+
+// basicUser reports whether the request carries the Basic credentials of
+// one of [auth_users]. Passwords may be configured as bcrypt hashes.
+func (h *Handler) basicUser(r *http.Request) bool {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	want, ok := h.config.Auth.Users[name]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	if strings.HasPrefix(want, "$2a$") || strings.HasPrefix(want, "$2b$") || strings.HasPrefix(want, "$2y$") {
+		return bcrypt.CompareHashAndPassword([]byte(want), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(want)) == 1
+}
+
+// oidcUser reports whether the request carries a bearer token from the
+// [auth] OIDC provider. Tokens are logged by subject at debug level.
+func (h *Handler) oidcUser(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	claims, err := h.oidcVerifier().Verify(r.Context(), token)
+	if err != nil {
+		log := logging.FromContext(r.Context())
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			log.Debug("OIDC token refused", "error", err)
+		} else {
+			log.Warn("OIDC provider unavailable", "error", err)
+		}
+		return false
+	}
+	logging.FromContext(r.Context()).Debug("OIDC token accepted", "subject", claims.Subject)
+	return true
+}
+
+// oidcVerifier returns the verifier of [auth] OIDC tokens, creating it on
+// first use.
+func (h *Handler) oidcVerifier() *oidc.Verifier {
+	h.oidcOnce.Do(func() {
+		h.oidc = oidc.New(h.config.Auth.OIDCIssuer, h.config.Auth.OIDCAudience, h.clock)
+	})
+	return h.oidc
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// createAs posts a paste with the given Authorization header, if any.
+func createAs(h *Handler, authorization string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "encrypted-content",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr
+}
+
+// basicAuth returns the Authorization header of Basic credentials.
+func basicAuth(name, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(name+":"+password))
+}
+
+// TestAuth_Basic tests that creation takes the credentials of a configured
+// user, with plain or bcrypt-hashed passwords, or an API token.
+func TestAuth_Basic(t *testing.T) {
+	h, _ := newTestHandler(t)
+	withTokens(h)
+	hash, err := bcrypt.GenerateFromPassword([]byte("bob-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.config.Auth = config.AuthConfig{
+		Create: config.AuthBasic,
+		Users:  map[string]string{"alice": "alice-password", "bob": string(hash)},
+	}
+
+	for name, tt := range map[string]struct {
+		authorization string
+		want          int
+	}{
+		"anonymous":       {"", http.StatusUnauthorized},
+		"wrong password":  {basicAuth("alice", "bob-password"), http.StatusUnauthorized},
+		"unknown user":    {basicAuth("carol", "alice-password"), http.StatusUnauthorized},
+		"plain password":  {basicAuth("alice", "alice-password"), http.StatusOK},
+		"bcrypt password": {basicAuth("bob", "bob-password"), http.StatusOK},
+		"API token":       {"Bearer " + testAPIToken, http.StatusOK},
+		"bad API token":   {"Bearer not-a-token-0123456789", http.StatusUnauthorized},
+	} {
+		rr := createAs(h, tt.authorization)
+		if rr.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tt.want, rr.Code, rr.Body.String())
+		}
+		if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a WWW-Authenticate challenge", name)
+		}
+	}
+}
+
+// TestAuth_OIDC tests that creation takes bearer tokens signed by the
+// configured provider for the configured audience.
+func TestAuth_OIDC(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	provider := httptest.NewServer(mux)
+	defer provider.Close()
+	issuer = provider.URL
+
+	h, _ := newTestHandler(t)
+	h.config.Auth = config.AuthConfig{Create: config.AuthOIDC, OIDCIssuer: issuer, OIDCAudience: "flashpaper"}
+
+	token := func(audience string) string {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"k1"}`))
+		payload, _ := json.Marshal(map[string]any{
+			"iss": issuer, "aud": audience, "sub": "user-1", "exp": time.Now().Add(time.Hour).Unix(),
+		})
+		signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+
+	if rr := createAs(h, ""); rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("expected 401 with a challenge without a token, got %d", rr.Code)
+	}
+	if rr := createAs(h, "Bearer "+token("other-app")); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for another audience's token, got %d", rr.Code)
+	}
+	if rr := createAs(h, "Bearer "+token("flashpaper")); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d with a valid token, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
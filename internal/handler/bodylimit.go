@@ -0,0 +1,91 @@
+// Package handler provides per-route request body limits. The server bounds
+// every body by what the largest paste needs (MaxRequestBody); routes that
+// only take a token and a few fields get a far smaller bound. Requests that
+// announce an oversized body in Content-Length are refused before any of it
+// is read, and bodies that turn out too large while decoding get the same
+// 413 response in the API's error format.
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// ErrCodeTooLarge is the error code of requests whose body is over the
+// route's limit.
+const ErrCodeTooLarge = "request_too_large"
+
+// requestEnvelopeOverhead is the allowance for JSON keys, adata, and meta
+// on top of the raw paste and attachment sizes.
+const requestEnvelopeOverhead = 1 << 20
+
+// smallBodyLimit bounds the bodies of routes that take a paste ID, a token,
+// and a few options, such as /extend and delete requests.
+const smallBodyLimit = 64 << 10
+
+// MaxRequestBody returns the largest request body the server will accept.
+// A create request carries the ciphertext plus an optional attachment,
+// wrapped in a JSON or multipart envelope with encryption parameters. A
+// multipart attachment may be sent base64-encoded, which adds a third.
+func MaxRequestBody(cfg *config.Config) int64 {
+	return cfg.Main.SizeLimit + cfg.Main.AttachmentLimit*4/3 + requestEnvelopeOverhead
+}
+
+// createBodyLimit is the body limit of paste, comment, and secret creation,
+// following reloads of the size limits.
+func (h *Handler) createBodyLimit() int64 {
+	return MaxRequestBody(h.live())
+}
+
+// limitBody returns middleware that refuses requests whose body is known
+// to be over limit() bytes and bounds the bodies of the rest.
+func (h *Handler) limitBody(limit func() int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := limit()
+			if r.ContentLength > n {
+				h.tooLarge(w)
+				return
+			}
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, n)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// smallBody is limitBody for routes taking only a few fields.
+func (h *Handler) smallBody() func(http.Handler) http.Handler {
+	return h.limitBody(func() int64 { return smallBodyLimit })
+}
+
+// decodeJSON decodes a request body into v. On failure it sends the error
+// response, 413 if the body was over its limit, and returns false.
+func (h *Handler) decodeJSON(w http.ResponseWriter, body io.Reader, v any) bool {
+	err := json.NewDecoder(body).Decode(v)
+	switch {
+	case err == nil:
+		return true
+	case isTooLarge(err):
+		h.tooLarge(w)
+	default:
+		h.jsonError(w, "Invalid JSON", http.StatusBadRequest)
+	}
+	return false
+}
+
+// isTooLarge reports whether err comes from reading past a body limit.
+func isTooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
+
+// tooLarge sends the response to a request whose body is over its limit.
+func (h *Handler) tooLarge(w http.ResponseWriter) {
+	h.jsonErrorCode(w, "Request body too large", ErrCodeTooLarge, http.StatusRequestEntityTooLarge)
+}
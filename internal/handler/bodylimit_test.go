@@ -0,0 +1,74 @@
+// Package handler provides tests for per-route body limits.
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingReader fails the test if the body is read at all.
+type failingReader struct{ t *testing.T }
+
+func (f failingReader) Read([]byte) (int, error) {
+	f.t.Error("expected the body not to be read")
+	return 0, io.ErrUnexpectedEOF
+}
+
+// expectTooLarge checks for the 413 response in the API's error format.
+func expectTooLarge(t *testing.T, rr *httptest.ResponseRecorder) {
+	t.Helper()
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, rr.Code, rr.Body.String())
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("expected a JSON error, got %q", rr.Body.String())
+	}
+	if resp["status"] != float64(1) || resp["code"] != ErrCodeTooLarge {
+		t.Errorf("unexpected error response %v", resp)
+	}
+}
+
+// TestLimitBody_ContentLength tests that bodies announced as too large are
+// refused before any of them is read.
+func TestLimitBody_ContentLength(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Main.SizeLimit = 1000
+	h.config.Main.AttachmentLimit = 1000
+
+	for _, path := range []string{"/", "/extend"} {
+		req := httptest.NewRequest(http.MethodPost, path, failingReader{t})
+		req.Header.Set("Content-Type", "application/json")
+		req.ContentLength = MaxRequestBody(h.config) + 1
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, req)
+		expectTooLarge(t, rr)
+	}
+}
+
+// TestLimitBody_Streamed tests bodies without a Content-Length that turn
+// out too large while decoding.
+func TestLimitBody_Streamed(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	body := `{"pasteid":"abcd1234abcd1234","deletetoken":"` + strings.Repeat("a", smallBodyLimit) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/extend", io.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	expectTooLarge(t, rr)
+
+	// Creation allows far more than the small routes
+	req = httptest.NewRequest(http.MethodPost, "/", io.NopCloser(strings.NewReader(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code == http.StatusRequestEntityTooLarge {
+		t.Errorf("expected creation to accept a %d-byte body", len(body))
+	}
+}
@@ -0,0 +1,79 @@
+// Package handler provides creator callback bookkeeping.
+// Callback URLs are kept in key-value storage rather than in paste metadata
+// because storage backends delete expired pastes as a side effect of
+// ReadPaste; the URL must outlive the paste to report its expiry.
+package handler
+
+import (
+	"context"
+
+	"github.com/liskl/flashpaper/internal/callback"
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// Wait blocks until background work started by handlers has finished.
+// Call it after the HTTP server has stopped accepting requests. Queued
+// webhooks are still delivered, but not retried; later events are dropped.
+func (h *Handler) Wait() {
+	h.background.Wait()
+	h.callbacks.Wait()
+	h.webhooks.Close()
+}
+
+// registerCallback records the callback URL for a newly created paste.
+func (h *Handler) registerCallback(ctx context.Context, pasteID, target string) {
+	if target == "" {
+		return
+	}
+	_ = h.store.SetValue(ctx, storage.NamespaceCallback, pasteID, target)
+}
+
+// callbackEvent translates lifecycle events into creator notifications.
+// Only the first read is reported, and a burned paste only forgets its
+// callback: the read event already told the whole story.
+func (h *Handler) callbackEvent(e events.Event) {
+	// Events carry no request; lookups run on their own
+	ctx := context.Background()
+	switch e.Kind {
+	case events.PasteRead:
+		if e.First {
+			h.notifyRead(ctx, e.PasteID)
+		}
+	case events.PasteDeleted:
+		switch e.Reason {
+		case events.ReasonToken:
+			h.notifyGone(ctx, e.PasteID, callback.EventDeleted)
+		case events.ReasonExpired:
+			h.notifyGone(ctx, e.PasteID, callback.EventExpired)
+		default:
+			h.notifyGone(ctx, e.PasteID, "")
+		}
+	}
+}
+
+// notifyRead reports the first successful read of a paste.
+// Callers decide what counts as first, using the paste's read receipt.
+func (h *Handler) notifyRead(ctx context.Context, pasteID string) {
+	target, _ := h.store.GetValue(ctx, storage.NamespaceCallback, pasteID)
+	if target == "" {
+		return
+	}
+	h.callbacks.Notify(target, pasteID, callback.EventRead)
+}
+
+// notifyGone reports that a paste no longer exists and forgets its callback.
+// Pass an empty event to forget the callback without notifying.
+func (h *Handler) notifyGone(ctx context.Context, pasteID string, event callback.Event) {
+	target, _ := h.store.GetValue(ctx, storage.NamespaceCallback, pasteID)
+	if target == "" {
+		return
+	}
+
+	// The Storage interface has no delete for values; empty means unset
+	_ = h.store.SetValue(ctx, storage.NamespaceCallback, pasteID, "")
+
+	if event != "" {
+		h.callbacks.Notify(target, pasteID, event)
+	}
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/callback"
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// callbackRecorder is a test server that records received callback events.
+type callbackRecorder struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []callback.Event
+}
+
+func newCallbackRecorder(t *testing.T) *callbackRecorder {
+	t.Helper()
+
+	rec := &callbackRecorder{}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p callback.Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decoding callback payload: %v", err)
+			return
+		}
+		rec.mu.Lock()
+		rec.events = append(rec.events, p.Event)
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *callbackRecorder) Events() []callback.Event {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]callback.Event(nil), rec.events...)
+}
+
+// createPasteWithCallback creates a paste through the API and returns its ID
+// and delete token.
+func createPasteWithCallback(t *testing.T, h *Handler, target string) (string, string) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "encrypted-content",
+		"adata": []interface{}{[]interface{}{}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day", "callback": target},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	h.handlePost(rr, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response["status"] != float64(0) {
+		t.Fatalf("creating paste: %s", rr.Body.String())
+	}
+	return response["id"].(string), response["deletetoken"].(string)
+}
+
+// TestCallback_ReadThenDelete tests that only the first read is reported,
+// followed by the deletion.
+func TestCallback_ReadThenDelete(t *testing.T) {
+	h, _ := newTestHandler(t)
+	rec := newCallbackRecorder(t)
+	h.callbacks = callback.New(config.CallbackConfig{Allowlist: []string{rec.URL + "/"}, Timeout: 5})
+
+	pasteID, deleteToken := createPasteWithCallback(t, h, rec.URL+"/hook")
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.handleGet(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("read %d: expected status %d, got %d", i, http.StatusOK, rr.Code)
+		}
+	}
+	h.callbacks.Wait() // deliveries are concurrent; pin the order for the assertion
+
+	body, _ := json.Marshal(map[string]interface{}{"pasteid": pasteID, "deletetoken": deleteToken})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	h.callbacks.Wait()
+	events := rec.Events()
+	if len(events) != 2 || events[0] != callback.EventRead || events[1] != callback.EventDeleted {
+		t.Errorf("expected [read deleted], got %v", events)
+	}
+}
+
+// TestCallback_Expired tests that expiry is reported even though the
+// storage layer removes the paste.
+func TestCallback_Expired(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	rec := newCallbackRecorder(t)
+	h.callbacks = callback.New(config.CallbackConfig{Allowlist: []string{rec.URL + "/"}, Timeout: 5})
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.Meta.ExpireDate = time.Now().Add(-time.Hour).Unix()
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	mockStore.SetValue(ctx, storage.NamespaceCallback, pasteID, rec.URL+"/hook")
+
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.handleGet(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	h.callbacks.Wait()
+	events := rec.Events()
+	if len(events) != 1 || events[0] != callback.EventExpired {
+		t.Errorf("expected [expired], got %v", events)
+	}
+	if target, _ := mockStore.GetValue(ctx, storage.NamespaceCallback, pasteID); target != "" {
+		t.Errorf("expected callback to be forgotten, got %q", target)
+	}
+}
+
+// TestCallback_Rejected tests that URLs outside the allowlist, or any URL
+// when callbacks are disabled, are rejected without storing the paste.
+func TestCallback_Rejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+	}{
+		{"disabled", nil},
+		{"not allowlisted", []string{"https://hooks.example.com/"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mockStore := newTestHandler(t)
+			h.callbacks = callback.New(config.CallbackConfig{Allowlist: tt.allowlist, Timeout: 5})
+
+			body, _ := json.Marshal(map[string]interface{}{
+				"v":    2,
+				"ct":   "encrypted-content",
+				"meta": map[string]interface{}{"callback": "http://169.254.169.254/latest"},
+			})
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			h.handlePost(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+			if n := mockStore.GetPasteCount(); n != 0 {
+				t.Errorf("expected no pastes stored, got %d", n)
+			}
+		})
+	}
+}
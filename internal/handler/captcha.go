@@ -0,0 +1,74 @@
+// Package handler provides captcha verification on paste creation.
+// With a [captcha] provider configured, the web UI renders the provider's
+// widget and sends its response token with the paste:
+//
+//	{"v": 2, "ct": "...", "captcha": "0.AbCdEf..."}
+//
+// The secret API takes the same "captcha" field. Tokens are checked with
+// the provider before anything is stored (see captcha.Verifier); the
+// provider keeps each one from verifying twice. Creators with an API token
+// are exempt, as are retries answered from the idempotency record.
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/captcha"
+	"github.com/liskl/flashpaper/internal/logging"
+)
+
+// Error codes for creations refused by the captcha check. Both come with
+// 403 Forbidden.
+const (
+	ErrCodeCaptchaRequired = "captcha_required"
+	ErrCodeCaptchaInvalid  = "captcha_invalid"
+)
+
+// captchaFromRequest returns the "captcha" field of a decoded create request.
+func captchaFromRequest(req map[string]interface{}) string {
+	response, _ := req["captcha"].(string)
+	return response
+}
+
+// checkCaptcha reports whether a creation may go ahead, writing the error
+// response itself if not. token is the creator's API token, if any.
+// Creations fail closed while the provider can't be reached.
+func (h *Handler) checkCaptcha(w http.ResponseWriter, r *http.Request, response, token string) bool {
+	if h.captcha == nil || token != "" {
+		return true
+	}
+	if response == "" {
+		h.jsonErrorCode(w, "Please solve the captcha", ErrCodeCaptchaRequired, http.StatusForbidden)
+		return false
+	}
+
+	err := h.captcha.Verify(r.Context(), response, getClientIP(r, h.config.Traffic.Header))
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, captcha.ErrRejected):
+		h.jsonErrorCode(w, "Captcha verification failed, please try again", ErrCodeCaptchaInvalid, http.StatusForbidden)
+	default:
+		logging.FromContext(r.Context()).Warn("Failed to verify captcha", "provider", h.config.Captcha.Provider, "error", err)
+		h.jsonError(w, "Captcha verification is unavailable", http.StatusServiceUnavailable)
+	}
+	return false
+}
+
+// captchaCSP adds the sources the captcha widget loads from to csp, the
+// active template's allowances, without modifying it.
+func (h *Handler) captchaCSP(csp map[string][]string) map[string][]string {
+	widget, ok := captcha.WidgetFor(h.config.Captcha.Provider)
+	if !ok {
+		return csp
+	}
+	merged := make(map[string][]string, len(csp)+len(widget.CSP))
+	for directive, sources := range csp {
+		merged[directive] = sources
+	}
+	for directive, sources := range widget.CSP {
+		merged[directive] = append(merged[directive][:len(merged[directive]):len(merged[directive])], sources...)
+	}
+	return merged
+}
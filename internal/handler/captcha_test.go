@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/captcha"
+	"github.com/liskl/flashpaper/internal/config"
+	fpMiddleware "github.com/liskl/flashpaper/internal/middleware"
+)
+
+// fakeCaptcha accepts "good-token" from the test client address once,
+// and fails every check while down is set.
+type fakeCaptcha struct {
+	used map[string]bool
+	down bool
+}
+
+// Verify implements captcha.Verifier.
+func (f *fakeCaptcha) Verify(ctx context.Context, response, remoteIP string) error {
+	if f.down {
+		return errors.New("siteverify: unexpected status 502 Bad Gateway")
+	}
+	if response != "good-token" || remoteIP != "192.0.2.1" || f.used[response] {
+		return fmt.Errorf("%w: invalid-input-response", captcha.ErrRejected)
+	}
+	f.used[response] = true
+	return nil
+}
+
+// withCaptcha configures Turnstile, verified by a fake.
+func withCaptcha(h *Handler) *fakeCaptcha {
+	h.config.Captcha = config.CaptchaConfig{Provider: config.CaptchaTurnstile, SiteKey: "0x4AAAAAAAexample", Secret: "secret"}
+	fake := &fakeCaptcha{used: map[string]bool{}}
+	h.captcha = fake
+	return fake
+}
+
+// createWithCaptcha creates a paste carrying the given captcha response,
+// with an API token if secret isn't empty.
+func createWithCaptcha(h *Handler, response, secret string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	request := map[string]interface{}{
+		"v":     2,
+		"ct":    "encrypted-content",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day"},
+	}
+	if response != "" {
+		request["captcha"] = response
+	}
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+
+	var data map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &data)
+	return rr, data
+}
+
+// TestCaptcha_Create tests creating pastes with and without a solved captcha.
+func TestCaptcha_Create(t *testing.T) {
+	h, _ := newTestHandler(t)
+	fake := withCaptcha(h)
+
+	rr, response := createWithCaptcha(h, "", "")
+	if rr.Code != http.StatusForbidden || response["code"] != ErrCodeCaptchaRequired {
+		t.Errorf("expected captcha_required, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr, response = createWithCaptcha(h, "bad-token", "")
+	if rr.Code != http.StatusForbidden || response["code"] != ErrCodeCaptchaInvalid {
+		t.Errorf("expected captcha_invalid, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr, _ := createWithCaptcha(h, "good-token", ""); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// The provider refuses tokens it has verified before
+	rr, response = createWithCaptcha(h, "good-token", "")
+	if rr.Code != http.StatusForbidden || response["code"] != ErrCodeCaptchaInvalid {
+		t.Errorf("expected captcha_invalid, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Creation fails closed while the provider is unreachable
+	fake.down = true
+	fake.used = map[string]bool{}
+	if rr, _ := createWithCaptcha(h, "good-token", ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	// Scripts with an API token are exempt
+	withTokens(h)
+	if rr, _ := createWithCaptcha(h, "", testAPIToken); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
+// TestCaptcha_Secret tests that the secret API takes the same response.
+func TestCaptcha_Secret(t *testing.T) {
+	h, _ := newSecretHandler(t)
+	withCaptcha(h)
+
+	rr, response := doSecret(h, http.MethodPost, "/", map[string]interface{}{"ciphertext": "opaque-blob"}, nil)
+	if rr.Code != http.StatusForbidden || response["code"] != ErrCodeCaptchaRequired {
+		t.Errorf("expected captcha_required, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr, _ = doSecret(h, http.MethodPost, "/", map[string]interface{}{"ciphertext": "opaque-blob", "captcha": "good-token"}, nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
+// TestCaptcha_UI tests that the page renders the widget and the policy
+// lets it load.
+func TestCaptcha_UI(t *testing.T) {
+	h, _ := newTestHandler(t)
+	if h.clientConfig(context.Background()).Captcha != nil {
+		t.Error("expected no captcha in client config")
+	}
+	if _, ok := h.CSPSources()["frame-src"]; ok {
+		t.Error("expected no frame-src sources without a captcha")
+	}
+
+	h.initStaticFS()
+	h.initTemplates()
+	withCaptcha(h)
+	cfg := h.clientConfig(context.Background()).Captcha
+	if cfg == nil || cfg.Provider != "turnstile" || cfg.SiteKey != "0x4AAAAAAAexample" || cfg.Field != "cf-turnstile-response" {
+		t.Fatalf("unexpected captcha in client config: %+v", cfg)
+	}
+
+	rr := httptest.NewRecorder()
+	h.serveUI(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := rr.Body.String()
+	if !strings.Contains(body, `<div class="captcha-widget cf-turnstile" data-sitekey="0x4AAAAAAAexample"></div>`) {
+		t.Error("expected the widget on the page")
+	}
+	if !strings.Contains(body, `<script src="https://challenges.cloudflare.com/turnstile/v0/api.js" async defer></script>`) {
+		t.Error("expected the widget script on the page")
+	}
+
+	policy := fpMiddleware.ContentSecurityPolicy(h.CSPSources())
+	if !strings.Contains(policy, "script-src 'self' 'unsafe-inline' https://challenges.cloudflare.com;") ||
+		!strings.Contains(policy, "frame-src 'self' https://challenges.cloudflare.com;") {
+		t.Errorf("unexpected policy %q", policy)
+	}
+}
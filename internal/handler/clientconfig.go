@@ -0,0 +1,191 @@
+// Package handler provides the UI bootstrap configuration.
+// The browser needs a handful of instance settings (expiration choices, size
+// limit, enabled features) before it can render the create form. Rather than
+// interpolating each value into the HTML, the server emits one sanitized JSON
+// document that the template embeds and the /config endpoint returns, so the
+// bundled JavaScript reads a single canonical source.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/captcha"
+	"github.com/liskl/flashpaper/internal/version"
+)
+
+// ClientConfig is the instance configuration exposed to the browser.
+// It must never contain secrets: everything here is public by design.
+type ClientConfig struct {
+	Name      string         `json:"name"`
+	Version   string         `json:"version"`
+	BasePath  string         `json:"basepath"`
+	SizeLimit int64          `json:"sizelimit"`
+	Expire    ClientExpire   `json:"expire"`
+	Features  ClientFeatures `json:"features"`
+
+	// Announcement is the operator's current notice, if any
+	Announcement *Announcement `json:"announcement,omitempty"`
+
+	// TOS describes the terms of service, if configured
+	TOS *ClientTOS `json:"tos,omitempty"`
+
+	// PoW is set when creation needs a proof of work (see pow.go)
+	PoW *ClientPoW `json:"pow,omitempty"`
+
+	// Captcha is set when creation needs a captcha (see captcha.go)
+	Captcha *ClientCaptcha `json:"captcha,omitempty"`
+
+	// Maintenance is set while new content is refused (see maintenance.go)
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// ClientTOS points the UI at the terms of service.
+type ClientTOS struct {
+	URL      string `json:"url"`      // Where the document is served
+	Required bool   `json:"required"` // Whether creation needs tos_accepted
+}
+
+// ClientPoW points the UI at proof-of-work challenges.
+type ClientPoW struct {
+	URL        string `json:"url"`        // Where challenges are issued
+	Difficulty int    `json:"difficulty"` // Leading zero bits required
+}
+
+// ClientCaptcha tells the UI which widget to render.
+type ClientCaptcha struct {
+	Provider string `json:"provider"` // [captcha] provider
+	SiteKey  string `json:"sitekey"`  // Public key the widget is rendered with
+	Script   string `json:"script"`   // Script rendering the widget
+	Class    string `json:"class"`    // Class of the widget's element
+	Field    string `json:"field"`    // Form field the widget puts its response in
+}
+
+// ClientExpire lists the expiration choices offered in the create form.
+type ClientExpire struct {
+	// Default is the option preselected in the dropdown
+	Default string `json:"default"`
+
+	// Options are in display order (see config.ExpireConfig.Order)
+	Options []ClientExpireOption `json:"options"`
+}
+
+// ClientExpireOption is a single entry in the expiration dropdown.
+type ClientExpireOption struct {
+	Value   string `json:"value"`   // Option key sent back in meta.expire
+	Label   string `json:"label"`   // Human-readable label
+	Seconds int64  `json:"seconds"` // Duration in seconds (0 = never)
+}
+
+// ClientFeatures toggles optional parts of the UI.
+type ClientFeatures struct {
+	Discussion               bool   `json:"discussion"`
+	OpenDiscussion           bool   `json:"opendiscussion"`
+	Password                 bool   `json:"password"`
+	AccessProof              bool   `json:"accessproof"`
+	FileUpload               bool   `json:"fileupload"`
+	BurnAfterReadingSelected bool   `json:"burnafterreadingselected"`
+	ForceBurnAfterReading    bool   `json:"forceburnafterreading"`
+	ForceOpenDiscussion      bool   `json:"forceopendiscussion"`
+	QRCode                   bool   `json:"qrcode"`
+	Compression              string `json:"compression"`
+	LiveComments             bool   `json:"livecomments"` // GET /events is served
+}
+
+// clientConfig builds the public bootstrap configuration from server config.
+func (h *Handler) clientConfig(ctx context.Context) ClientConfig {
+	main := h.config.Main
+
+	return ClientConfig{
+		Name:      main.Name,
+		Version:   version.Version,
+		BasePath:  main.BasePath,
+		SizeLimit: h.live().Main.SizeLimit,
+		Expire: ClientExpire{
+			Default: h.live().Expire.Default,
+			Options: h.expireOptions(),
+		},
+		Features: ClientFeatures{
+			Discussion:               main.Discussion,
+			OpenDiscussion:           main.OpenDiscussion,
+			Password:                 main.Password,
+			AccessProof:              main.AccessProof,
+			FileUpload:               main.FileUpload,
+			BurnAfterReadingSelected: main.BurnAfterReadingSelected,
+			ForceBurnAfterReading:    main.ForceBurnAfterReading,
+			ForceOpenDiscussion:      main.ForceOpenDiscussion,
+			QRCode:                   main.QRCode,
+			Compression:              main.Compression,
+			LiveComments:             h.streams != nil,
+		},
+		Announcement: h.announcement(ctx),
+		TOS:          h.clientTOS(),
+		PoW:          h.clientPoW(),
+		Captcha:      h.clientCaptcha(),
+		Maintenance:  h.maintenance(ctx),
+	}
+}
+
+// clientTOS returns the terms-of-service settings, or nil if not configured.
+func (h *Handler) clientTOS() *ClientTOS {
+	if h.config.TOS.File == "" {
+		return nil
+	}
+	return &ClientTOS{
+		URL:      "/tos",
+		Required: h.config.TOS.Required,
+	}
+}
+
+// clientPoW returns the proof-of-work settings, or nil if not required.
+func (h *Handler) clientPoW() *ClientPoW {
+	if !h.config.PoW.Enabled {
+		return nil
+	}
+	return &ClientPoW{
+		URL:        "/pow",
+		Difficulty: h.config.PoW.Difficulty,
+	}
+}
+
+// clientCaptcha returns the widget to render, or nil if creation needs no
+// captcha.
+func (h *Handler) clientCaptcha() *ClientCaptcha {
+	widget, ok := captcha.WidgetFor(h.config.Captcha.Provider)
+	if !ok {
+		return nil
+	}
+	return &ClientCaptcha{
+		Provider: h.config.Captcha.Provider,
+		SiteKey:  h.config.Captcha.SiteKey,
+		Script:   widget.Script,
+		Class:    widget.Class,
+		Field:    widget.Field,
+	}
+}
+
+// expireOptions returns the configured expiration options in display order.
+// See config.ExpireConfig.OrderedOptions for how the order is decided.
+func (h *Handler) expireOptions() []ClientExpireOption {
+	expire := &h.live().Expire
+	keys := expire.OrderedOptions()
+
+	options := make([]ClientExpireOption, 0, len(keys))
+	for _, key := range keys {
+		options = append(options, ClientExpireOption{
+			Value:   key,
+			Label:   expire.Label(key),
+			Seconds: int64(expire.Options[key].Seconds()),
+		})
+	}
+	return options
+}
+
+// serveConfig returns the bootstrap configuration as JSON.
+// This is the same document embedded in the UI template.
+func (h *Handler) serveConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.clientConfig(ctx))
+}
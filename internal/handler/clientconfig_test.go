@@ -0,0 +1,145 @@
+// Package handler provides tests for the UI bootstrap configuration.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestClientConfig_ExpireOrdering tests that options are ordered by duration with never last.
+func TestClientConfig_ExpireOrdering(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler(t)
+	h.config.Expire.Options["2hours"] = 2 * time.Hour
+
+	options := h.clientConfig(ctx).Expire.Options
+
+	var values []string
+	for _, o := range options {
+		values = append(values, o.Value)
+	}
+
+	expected := []string{"5min", "10min", "1hour", "2hours", "1day", "1week", "1month", "1year", "never"}
+	if strings.Join(values, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected order %v, got %v", expected, values)
+	}
+
+	// Standard options get friendly labels, custom ones fall back to the key
+	labels := make(map[string]string)
+	for _, o := range options {
+		labels[o.Value] = o.Label
+	}
+	if labels["1hour"] != "1 hour" {
+		t.Errorf("expected label '1 hour', got %q", labels["1hour"])
+	}
+	if labels["2hours"] != "2hours" {
+		t.Errorf("expected fallback label '2hours', got %q", labels["2hours"])
+	}
+}
+
+// TestClientConfig_ExpireConfiguredOrder tests that configured order and labels are honored.
+func TestClientConfig_ExpireConfiguredOrder(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler(t)
+	h.config.Expire.Options["2weeks"] = 14 * 24 * time.Hour
+	h.config.Expire.Order = []string{"1week", "2weeks", "never", "1day"}
+	h.config.Expire.Labels = map[string]string{"2weeks": "2 weeks"}
+
+	options := h.clientConfig(ctx).Expire.Options
+
+	var values []string
+	for _, o := range options {
+		values = append(values, o.Value)
+	}
+
+	expected := []string{"1week", "2weeks", "never", "1day", "5min", "10min", "1hour", "1month", "1year"}
+	if strings.Join(values, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected order %v, got %v", expected, values)
+	}
+	if options[1].Label != "2 weeks" {
+		t.Errorf("expected label '2 weeks', got %q", options[1].Label)
+	}
+	if options[1].Seconds != 14*24*60*60 {
+		t.Errorf("expected 1209600 seconds, got %d", options[1].Seconds)
+	}
+}
+
+// TestServeConfig tests the JSON bootstrap config endpoint.
+func TestServeConfig(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Main.Password = true
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	rr := httptest.NewRecorder()
+
+	h.serveConfig(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %s", ct)
+	}
+
+	var cfg ClientConfig
+	if err := json.Unmarshal(rr.Body.Bytes(), &cfg); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if cfg.Name != "TestPaste" {
+		t.Errorf("expected name 'TestPaste', got %q", cfg.Name)
+	}
+	if cfg.SizeLimit != 10*1024*1024 {
+		t.Errorf("expected sizelimit 10MiB, got %d", cfg.SizeLimit)
+	}
+	if cfg.Expire.Default != "1week" {
+		t.Errorf("expected default expire '1week', got %q", cfg.Expire.Default)
+	}
+	if !cfg.Features.Discussion || !cfg.Features.Password {
+		t.Errorf("expected discussion and password features enabled, got %+v", cfg.Features)
+	}
+
+	// The server salt must never leak into public config
+	if strings.Contains(rr.Body.String(), h.salt) {
+		t.Error("bootstrap config must not contain the server salt")
+	}
+}
+
+// TestServeUI_EmbedsConfig tests that the template embeds a parseable JSON config block.
+func TestServeUI_EmbedsConfig(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initTemplates()
+
+	// A hostile instance name must not be able to break out of the script block
+	h.config.Main.Name = `</script><script>alert(1)</script>`
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+
+	h.serveUI(rr, req)
+
+	body := rr.Body.String()
+	re := regexp.MustCompile(`(?s)<script type="application/json" id="flashpaper-config">(.*?)</script>`)
+	match := re.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatal("expected flashpaper-config script block in page")
+	}
+
+	var cfg ClientConfig
+	if err := json.Unmarshal([]byte(match[1]), &cfg); err != nil {
+		t.Fatalf("embedded config is not valid JSON: %v\n%s", err, match[1])
+	}
+
+	if cfg.Name != h.config.Main.Name {
+		t.Errorf("expected name to round-trip, got %q", cfg.Name)
+	}
+	if len(cfg.Expire.Options) != len(h.config.Expire.Options) {
+		t.Errorf("expected %d expire options, got %d", len(h.config.Expire.Options), len(cfg.Expire.Options))
+	}
+}
@@ -3,10 +3,13 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"net/http"
-	"time"
 
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/middleware"
 	"github.com/liskl/flashpaper/internal/model"
 	"github.com/liskl/flashpaper/internal/util"
 )
@@ -22,12 +25,20 @@ import (
 //	  "v": 2
 //	}
 func (h *Handler) createComment(w http.ResponseWriter, r *http.Request, req map[string]interface{}) {
+	ctx := r.Context()
+	receivedAt := h.clock.Now()
+
 	// Check if discussions are enabled globally
 	if !h.config.Main.Discussion {
 		h.jsonError(w, "Discussions are disabled", http.StatusForbidden)
 		return
 	}
 
+	// No new comments during maintenance (see maintenance.go)
+	if h.inMaintenance(w, r) {
+		return
+	}
+
 	// Get paste ID
 	pasteID, ok := req["pasteid"].(string)
 	if !ok || pasteID == "" {
@@ -41,17 +52,39 @@ func (h *Handler) createComment(w http.ResponseWriter, r *http.Request, req map[
 		return
 	}
 
+	// Denylisted clients are refused or tarpitted (see tarpit.go)
+	if h.denied(r) {
+		h.refuseDenied(w, r, pasteID)
+		return
+	}
+
+	// Per-client comment limit (see ratelimit.go)
+	if !h.allowRequest(w, r, limitComment) {
+		return
+	}
+
 	// Check if paste exists and has discussion enabled
-	paste, err := h.store.ReadPaste(pasteID)
+	paste, err := h.store.ReadPaste(ctx, pasteID)
 	if err != nil {
 		if err == model.ErrPasteNotFound || err == model.ErrPasteExpired {
 			h.jsonError(w, "Paste not found", http.StatusNotFound)
 			return
 		}
+		logging.FromContext(r.Context()).Error("Failed to read paste for comment", "error", err)
 		h.jsonError(w, "Failed to read paste", http.StatusInternalServerError)
 		return
 	}
 
+	if paste.Meta.Disabled {
+		h.jsonError(w, "Paste link is disabled", http.StatusForbidden)
+		return
+	}
+
+	// Gated pastes only take comments from those who know the password
+	if !h.checkAccessProof(w, r, pasteID, paste) {
+		return
+	}
+
 	// Verify discussion is enabled for this paste
 	if !paste.HasDiscussion() {
 		h.jsonError(w, "Discussion is disabled for this paste", http.StatusForbidden)
@@ -86,6 +119,7 @@ func (h *Handler) createComment(w http.ResponseWriter, r *http.Request, req map[
 
 	// Create comment model
 	comment := model.NewComment(pasteID)
+	comment.Meta.PostDate = receivedAt.Unix()
 	comment.Data = data
 	comment.ParentID = parentID
 
@@ -115,6 +149,22 @@ func (h *Handler) createComment(w http.ResponseWriter, r *http.Request, req map[
 		return
 	}
 
+	// Run operator-registered spam hooks
+	if len(h.commentHooks) > 0 {
+		decision := h.checkCommentHooks(h.newCommentInfo(ctx, paste, comment, clientIP, receivedAt))
+		switch decision.Action {
+		case CommentReject:
+			message := decision.Reason
+			if message == "" {
+				message = "Comment rejected"
+			}
+			h.jsonError(w, message, http.StatusForbidden)
+			return
+		case CommentFlag:
+			comment.Meta.Flagged = true
+		}
+	}
+
 	// Generate unique comment ID
 	var commentID string
 	for attempts := 0; attempts < 10; attempts++ {
@@ -123,13 +173,26 @@ func (h *Handler) createComment(w http.ResponseWriter, r *http.Request, req map[
 			h.jsonError(w, "Failed to generate comment ID", http.StatusInternalServerError)
 			return
 		}
-		if !h.store.CommentExists(pasteID, parentID, commentID) {
+		if !h.store.CommentExists(ctx, pasteID, parentID, commentID) {
 			break
 		}
 	}
 
+	// Held comments are marked pending before they are stored, so readers
+	// never see them unmoderated (see moderation.go)
+	held := h.holdComment(comment)
+	if held {
+		if err := h.setCommentState(ctx, pasteID, commentID, commentPending); err != nil {
+			h.jsonError(w, "Failed to store comment", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Store comment
-	if err := h.store.CreateComment(pasteID, parentID, commentID, comment); err != nil {
+	if err := h.store.CreateComment(ctx, pasteID, parentID, commentID, comment); err != nil {
+		if held {
+			_ = h.setCommentState(ctx, pasteID, commentID, "")
+		}
 		if err == model.ErrCommentExists {
 			h.jsonError(w, "Comment ID collision, please try again", http.StatusConflict)
 			return
@@ -138,31 +201,115 @@ func (h *Handler) createComment(w http.ResponseWriter, r *http.Request, req map[
 			h.jsonError(w, "Paste not found", http.StatusNotFound)
 			return
 		}
+		if err == model.ErrCommentLimitReached {
+			h.jsonError(w, "Comment limit reached for this paste", http.StatusForbidden)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to store comment", "error", err)
 		h.jsonError(w, "Failed to store comment", http.StatusInternalServerError)
 		return
 	}
+	if held {
+		_ = h.enqueueComment(ctx, pasteID, commentID, comment)
+	} else {
+		h.publish(events.Event{Kind: events.CommentCreated, PasteID: pasteID, CommentID: commentID})
+	}
 
-	// Build response
+	// Build response. The comment is stored; a missing token only means
+	// the author can't delete it themselves
+	deleteToken, _ := commentDeleteToken(commentID, h.deleteTokenSalt(paste))
 	response := map[string]interface{}{
-		"id":       commentID,
-		"url":      h.config.Main.BasePath + "/?" + pasteID,
-		"postdate": comment.Meta.PostDate,
+		"id":          commentID,
+		"url":         h.config.Main.BasePath + "/?" + pasteID,
+		"postdate":    comment.Meta.PostDate,
+		"deletetoken": deleteToken,
 	}
+	if held {
+		response["pending"] = true
+	}
+	h.addWarnings(response, h.commentWarnings(ctx, pasteID))
 
 	h.jsonSuccess(w, response)
 }
 
+// commentDeleteToken derives a comment's delete token from its ID and the
+// paste's salt. The prefix keeps it from ever equaling a paste's token.
+func commentDeleteToken(commentID, salt string) (string, error) {
+	return util.GenerateDeleteToken("comment:"+commentID, salt)
+}
+
+// deleteComment handles comment deletion by its author.
+// Request format:
+//
+//	{
+//	  "pasteid": "pasteID",
+//	  "commentid": "commentID",
+//	  "deletetoken": "token from the creation response"
+//	}
+//
+// The comment is removed from storage; its replies stay in the discussion.
+func (h *Handler) deleteComment(w http.ResponseWriter, r *http.Request, req map[string]interface{}) {
+	ctx := r.Context()
+	pasteID, _ := req["pasteid"].(string)
+	commentID, _ := req["commentid"].(string)
+	if util.ValidateIDOrError(pasteID) != nil || util.ValidateIDOrError(commentID) != nil {
+		h.jsonError(w, "Invalid paste or comment ID", http.StatusBadRequest)
+		return
+	}
+	deleteToken, ok := req["deletetoken"].(string)
+	if !ok || deleteToken == "" {
+		h.jsonError(w, "No delete token provided", http.StatusBadRequest)
+		return
+	}
+
+	paste, err := h.store.ReadPaste(ctx, pasteID)
+	if err != nil {
+		if err == model.ErrPasteNotFound || err == model.ErrPasteExpired {
+			h.jsonError(w, "Paste not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to read paste for comment deletion", "error", err)
+		h.jsonError(w, "Failed to read paste", http.StatusInternalServerError)
+		return
+	}
+
+	expected, err := commentDeleteToken(commentID, h.deleteTokenSalt(paste))
+	if err != nil || subtle.ConstantTimeCompare([]byte(deleteToken), []byte(expected)) != 1 {
+		h.jsonError(w, "Invalid delete token", http.StatusForbidden)
+		return
+	}
+
+	h.moderationMu.Lock()
+	if err := h.store.DeleteComment(ctx, pasteID, commentID); err != nil {
+		h.moderationMu.Unlock()
+		if err == model.ErrCommentNotFound {
+			h.jsonError(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to delete comment", "error", err)
+		h.jsonError(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+	// A comment deleted while held no longer needs moderating
+	_ = h.dequeueComment(ctx, pasteID, commentID)
+	h.moderationMu.Unlock()
+	h.publish(events.Event{Kind: events.CommentDeleted, PasteID: pasteID, CommentID: commentID})
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"id":        pasteID,
+		"commentid": commentID,
+	})
+}
+
 // getClientIP extracts the client IP address from the request.
-// If a header is configured (for reverse proxy setups), it uses that.
+// If a header is configured (for reverse proxy setups), it uses that,
+// unless the request came from a peer not trusted to set it.
 func getClientIP(r *http.Request, header string) string {
-	// Check configured header first (for reverse proxy)
+	// Check configured header first (for reverse proxy); the address
+	// middleware decides which peers and list entries to believe
 	if header != "" {
-		if ip := r.Header.Get(header); ip != "" {
-			// Handle comma-separated list (X-Forwarded-For can have multiple IPs)
-			if idx := indexOf(ip, ','); idx != -1 {
-				ip = ip[:idx]
-			}
-			return trimSpace(ip)
+		if ip := middleware.ForwardedAddr(r, header); ip != "" {
+			return ip
 		}
 	}
 
@@ -219,49 +366,6 @@ func trimSpace(s string) string {
 	return s[start:end]
 }
 
-// RateLimitMiddleware checks rate limiting for paste/comment creation.
-// This is called before createPaste and createComment.
-func (h *Handler) checkRateLimit(r *http.Request) error {
-	// If rate limiting is disabled, allow
-	if h.config.Traffic.Limit <= 0 {
-		return nil
-	}
-
-	// Get client IP
-	clientIP := getClientIP(r, h.config.Traffic.Header)
-
-	// Check if IP is exempted
-	for _, exempted := range h.config.Traffic.Exempted {
-		if clientIP == exempted {
-			return nil
-		}
-	}
-
-	// Hash IP for storage
-	ipHash := util.HashIP(clientIP, h.salt)
-
-	// Get last access time
-	lastAccessStr, err := h.store.GetValue("traffic", ipHash)
-	if err != nil {
-		return nil // Allow on error
-	}
-
-	if lastAccessStr != "" {
-		var lastAccess int64
-		if _, err := parseIntStr(lastAccessStr, &lastAccess); err == nil {
-			elapsed := time.Now().Unix() - lastAccess
-			if elapsed < int64(h.config.Traffic.Limit) {
-				return model.ErrRateLimited
-			}
-		}
-	}
-
-	// Update last access time
-	_ = h.store.SetValue("traffic", ipHash, formatInt(time.Now().Unix()))
-
-	return nil
-}
-
 // parseIntStr parses an integer from a string.
 func parseIntStr(s string, out *int64) (bool, error) {
 	var result int64
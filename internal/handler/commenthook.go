@@ -0,0 +1,126 @@
+// Package handler provides the comment pre-create hook extension point.
+// Operators can register CommentHook implementations to score comments for
+// spam before they are stored. Hooks only ever see metadata - sizes, timing,
+// the commenter's vizhash and hashed IP - never plaintext, which the server
+// doesn't have anyway.
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// CommentAction is a hook's decision about a comment.
+// Actions are ordered by severity; when several hooks are registered the
+// most severe decision wins.
+type CommentAction int
+
+// Comment hook actions.
+const (
+	// CommentAllow stores the comment normally
+	CommentAllow CommentAction = iota
+
+	// CommentFlag stores the comment but marks it as flagged, so clients
+	// can collapse or de-emphasize it
+	CommentFlag
+
+	// CommentReject refuses the comment with 403 Forbidden
+	CommentReject
+)
+
+// CommentInfo describes a comment that is about to be stored.
+type CommentInfo struct {
+	// PasteID is the paste being commented on
+	PasteID string
+
+	// ParentID is the comment being replied to (PasteID for top-level)
+	ParentID string
+
+	// DataSize is the length of the encrypted comment in bytes
+	DataSize int
+
+	// ADataSize is the length of the serialized authenticated data in bytes
+	ADataSize int
+
+	// Vizhash is the commenter's visual hash (derived from IP and server salt)
+	Vizhash string
+
+	// ClientHash is a salted hash of the commenter's IP, stable across
+	// requests, for hooks that track per-client rates themselves
+	ClientHash string
+
+	// CommentCount is the number of comments already on the paste
+	CommentCount int
+
+	// PasteAge is how long ago the paste was created
+	PasteAge time.Duration
+
+	// ReceivedAt is when the comment request arrived
+	ReceivedAt time.Time
+}
+
+// CommentDecision is the outcome of a CommentHook.
+type CommentDecision struct {
+	Action CommentAction
+
+	// Reason is an optional explanation. For rejections it is returned to
+	// the client, so it shouldn't reveal scoring details.
+	Reason string
+}
+
+// CommentHook inspects comments before they are stored.
+// Implementations must be safe for concurrent use.
+type CommentHook interface {
+	CheckComment(info *CommentInfo) CommentDecision
+}
+
+// CommentHookFunc adapts an ordinary function to the CommentHook interface.
+type CommentHookFunc func(info *CommentInfo) CommentDecision
+
+// CheckComment calls f(info).
+func (f CommentHookFunc) CheckComment(info *CommentInfo) CommentDecision {
+	return f(info)
+}
+
+// AddCommentHook registers a hook that runs before every comment is stored.
+// Hooks run in registration order. It must be called before the handler
+// starts serving requests.
+func (h *Handler) AddCommentHook(hook CommentHook) {
+	h.commentHooks = append(h.commentHooks, hook)
+}
+
+// checkCommentHooks runs all registered hooks and returns the most severe
+// decision. A reject short-circuits the remaining hooks.
+func (h *Handler) checkCommentHooks(info *CommentInfo) CommentDecision {
+	decision := CommentDecision{Action: CommentAllow}
+	for _, hook := range h.commentHooks {
+		d := hook.CheckComment(info)
+		if d.Action > decision.Action {
+			decision = d
+		}
+		if decision.Action == CommentReject {
+			break
+		}
+	}
+	return decision
+}
+
+// newCommentInfo collects hook metadata for a comment on paste.
+func (h *Handler) newCommentInfo(ctx context.Context, paste *model.Paste, comment *model.Comment, clientIP string, receivedAt time.Time) *CommentInfo {
+	count, _ := h.store.CountComments(ctx, comment.PasteID)
+
+	return &CommentInfo{
+		PasteID:      comment.PasteID,
+		ParentID:     comment.ParentID,
+		DataSize:     len(comment.Data),
+		ADataSize:    len(comment.AData),
+		Vizhash:      comment.Vizhash,
+		ClientHash:   util.HashIP(clientIP, h.salt),
+		CommentCount: count,
+		PasteAge:     receivedAt.Sub(time.Unix(paste.Meta.PostDate, 0)),
+		ReceivedAt:   receivedAt,
+	}
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// postComment submits a comment on pasteID and returns the recorder.
+func postComment(h *Handler, pasteID, data string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":       2,
+		"pasteid": pasteID,
+		"data":    data,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "192.0.2.1:1234"
+	rr := httptest.NewRecorder()
+
+	h.handlePost(rr, req)
+	return rr
+}
+
+// newDiscussionPaste stores a paste with discussion enabled.
+func newDiscussionPaste(mockStore *storage.Mock, pasteID string) {
+	ctx := context.Background()
+	paste := model.NewPaste()
+	paste.Data = "paste-with-discussion"
+	paste.Meta.OpenDiscussion = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+}
+
+// TestCommentHook_ReceivesMetadataOnly tests the information passed to hooks.
+func TestCommentHook_ReceivesMetadataOnly(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	pasteID := "d15c055ea5e01234"
+	newDiscussionPaste(mockStore, pasteID)
+
+	var got *CommentInfo
+	h.AddCommentHook(CommentHookFunc(func(info *CommentInfo) CommentDecision {
+		got = info
+		return CommentDecision{Action: CommentAllow}
+	}))
+
+	postComment(h, pasteID, "encrypted-one")
+	rr := postComment(h, pasteID, "encrypted-two")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if got.PasteID != pasteID || got.ParentID != pasteID {
+		t.Errorf("unexpected IDs: paste %q parent %q", got.PasteID, got.ParentID)
+	}
+	if got.DataSize != len("encrypted-two") {
+		t.Errorf("expected data size %d, got %d", len("encrypted-two"), got.DataSize)
+	}
+	if got.CommentCount != 1 {
+		t.Errorf("expected 1 existing comment, got %d", got.CommentCount)
+	}
+	if got.Vizhash == "" || got.ClientHash == "" || strings.Contains(got.ClientHash, "192.0.2.1") {
+		t.Errorf("expected hashed client identity, got vizhash %q client %q", got.Vizhash, got.ClientHash)
+	}
+	if got.PasteAge < 0 || got.ReceivedAt.IsZero() {
+		t.Errorf("unexpected timing: age %v received %v", got.PasteAge, got.ReceivedAt)
+	}
+}
+
+// TestCommentHook_Decisions tests reject and flag outcomes, and that the
+// most severe decision across hooks wins.
+func TestCommentHook_Decisions(t *testing.T) {
+	ctx := context.Background()
+	allow := CommentHookFunc(func(*CommentInfo) CommentDecision {
+		return CommentDecision{Action: CommentAllow}
+	})
+	flag := CommentHookFunc(func(*CommentInfo) CommentDecision {
+		return CommentDecision{Action: CommentFlag}
+	})
+	reject := CommentHookFunc(func(*CommentInfo) CommentDecision {
+		return CommentDecision{Action: CommentReject, Reason: "Looks like spam"}
+	})
+
+	tests := []struct {
+		name    string
+		hooks   []CommentHook
+		status  int
+		flagged bool
+	}{
+		{"no hooks", nil, http.StatusOK, false},
+		{"allow", []CommentHook{allow}, http.StatusOK, false},
+		{"flag", []CommentHook{allow, flag}, http.StatusOK, true},
+		{"reject wins", []CommentHook{flag, reject, allow}, http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mockStore := newTestHandler(t)
+			pasteID := "d15c055ea5e01234"
+			newDiscussionPaste(mockStore, pasteID)
+			for _, hook := range tt.hooks {
+				h.AddCommentHook(hook)
+			}
+
+			rr := postComment(h, pasteID, "encrypted-comment")
+			if rr.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+
+			comments, _ := mockStore.ReadComments(ctx, pasteID)
+			if tt.status != http.StatusOK {
+				if len(comments) != 0 {
+					t.Errorf("rejected comment was stored")
+				}
+				if !strings.Contains(rr.Body.String(), "Looks like spam") {
+					t.Errorf("expected rejection reason, got %s", rr.Body.String())
+				}
+				return
+			}
+			if len(comments) != 1 || comments[0].Meta.Flagged != tt.flagged {
+				t.Errorf("expected one comment with flagged=%v, got %+v", tt.flagged, comments)
+			}
+		})
+	}
+}
+
+// TestCommentHook_FlagExposedOnRead tests that flagged comments are marked
+// in the paste response.
+func TestCommentHook_FlagExposedOnRead(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	pasteID := "d15c055ea5e01234"
+	newDiscussionPaste(mockStore, pasteID)
+	h.AddCommentHook(CommentHookFunc(func(*CommentInfo) CommentDecision {
+		return CommentDecision{Action: CommentFlag}
+	}))
+	postComment(h, pasteID, "encrypted-comment")
+
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.handleGet(rr, req)
+
+	var response struct {
+		Comments []struct {
+			Meta map[string]interface{} `json:"meta"`
+		} `json:"comments"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Comments) != 1 || response.Comments[0].Meta["flagged"] != true {
+		t.Errorf("expected flagged comment in response, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,212 @@
+// Package handler provides the runtime denylist.
+// Besides [security] denylist, operators can deny addresses and networks
+// through the admin API, e.g. while a spam wave is underway, without a
+// config change. These entries are kept as one value in key-value storage,
+// so they survive restarts and apply to every instance sharing the backend.
+// Each instance rereads them every denylistRefresh, and at once after a
+// change made through itself.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// denylistKey is the key-value key holding the runtime denylist.
+const denylistKey = "denylist"
+
+// denylistRefresh is how long an instance uses the runtime denylist
+// before reading it from storage again.
+const denylistRefresh = 30 * time.Second
+
+// Limits on runtime denylist entries, keeping the stored value small.
+const (
+	MaxDenylistEntries = 10000
+	maxDenylistComment = 200
+)
+
+// DenylistEntry is a network denied through the admin API.
+type DenylistEntry struct {
+	Network string `json:"network"`           // CIDR range; addresses are stored as /32 or /128
+	Comment string `json:"comment,omitempty"` // Why, for other operators
+	Added   int64  `json:"added"`             // Unix time
+}
+
+// denylistEntries reads the runtime denylist from storage.
+func (h *Handler) denylistEntries(ctx context.Context) ([]DenylistEntry, error) {
+	value, err := h.store.GetValue(ctx, storage.NamespaceAdmin, denylistKey)
+	if err != nil || value == "" {
+		return nil, err
+	}
+	var entries []DenylistEntry
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// saveDenylist stores the runtime denylist and drops this instance's copy.
+func (h *Handler) saveDenylist(ctx context.Context, entries []DenylistEntry) error {
+	value, _ := json.Marshal(entries)
+	if err := h.store.SetValue(ctx, storage.NamespaceAdmin, denylistKey, string(value)); err != nil {
+		return err
+	}
+	h.denylistMu.Lock()
+	h.denylistLoaded = time.Time{}
+	h.denylistMu.Unlock()
+	return nil
+}
+
+// runtimeDenylist returns the runtime denylist's networks, reading them
+// from storage if this instance's copy is too old. If that fails, the old
+// copy is used until the next attempt.
+func (h *Handler) runtimeDenylist(ctx context.Context) []netip.Prefix {
+	h.denylistMu.Lock()
+	defer h.denylistMu.Unlock()
+	now := h.clock.Now()
+	if now.Sub(h.denylistLoaded) < denylistRefresh {
+		return h.denylistRuntime
+	}
+	h.denylistLoaded = now
+
+	entries, err := h.denylistEntries(ctx)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to read the denylist", "error", err)
+		return h.denylistRuntime
+	}
+	networks := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if prefix, err := netip.ParsePrefix(entry.Network); err == nil {
+			networks = append(networks, prefix)
+		}
+	}
+	h.denylistRuntime = networks
+	return networks
+}
+
+// deniedRead refuses reads from denylisted clients with [security]
+// denylist_reads set, and reports whether it did.
+func (h *Handler) deniedRead(w http.ResponseWriter, r *http.Request) bool {
+	if !h.config.Security.DenylistReads || !h.denied(r) {
+		return false
+	}
+	h.jsonError(w, "Forbidden", http.StatusForbidden)
+	return true
+}
+
+// getDenylist handles GET /admin/denylist.
+// Response format:
+//
+//	{"status": 0, "configured": ["192.0.2.0/24"], "entries": [{"network": "203.0.113.7/32", "comment": "spam", "added": 1700000000}]}
+func (h *Handler) getDenylist(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.denylistEntries(r.Context())
+	if err != nil {
+		h.jsonError(w, "Failed to read denylist", http.StatusInternalServerError)
+		return
+	}
+	configured := make([]string, 0, len(h.denylist))
+	for _, prefix := range h.denylist {
+		configured = append(configured, prefix.String())
+	}
+	if entries == nil {
+		entries = []DenylistEntry{}
+	}
+	h.jsonSuccess(w, map[string]interface{}{
+		"configured": configured,
+		"entries":    entries,
+	})
+}
+
+// addDenylist handles POST /admin/denylist.
+// Request format:
+//
+//	{"network": "203.0.113.0/24", "comment": "spam wave"}
+//
+// Adding a network that is already listed replaces its comment.
+func (h *Handler) addDenylist(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Network string `json:"network"`
+		Comment string `json:"comment"`
+	}
+	if !h.decodeJSON(w, r.Body, &req) {
+		return
+	}
+
+	networks, err := config.ParseNetworks([]string{strings.TrimSpace(req.Network)})
+	if err != nil {
+		h.jsonError(w, "Invalid address or network", http.StatusBadRequest)
+		return
+	}
+	entry := DenylistEntry{
+		Network: networks[0].String(),
+		Comment: strings.TrimSpace(req.Comment),
+		Added:   h.clock.Now().Unix(),
+	}
+	if utf8.RuneCountInString(entry.Comment) > maxDenylistComment {
+		h.jsonError(w, "Comment is too long", http.StatusBadRequest)
+		return
+	}
+
+	h.denylistAdminMu.Lock()
+	defer h.denylistAdminMu.Unlock()
+	entries, err := h.denylistEntries(ctx)
+	if err != nil {
+		h.jsonError(w, "Failed to read denylist", http.StatusInternalServerError)
+		return
+	}
+	entries = slices.DeleteFunc(entries, func(e DenylistEntry) bool { return e.Network == entry.Network })
+	if len(entries) >= MaxDenylistEntries {
+		h.jsonError(w, "Denylist is full", http.StatusConflict)
+		return
+	}
+	if err := h.saveDenylist(ctx, append(entries, entry)); err != nil {
+		h.jsonError(w, "Failed to store denylist", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"entry": entry,
+	})
+}
+
+// removeDenylist handles DELETE /admin/denylist?network=203.0.113.0/24.
+// Networks from [security] denylist can only be removed from the config.
+func (h *Handler) removeDenylist(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	networks, err := config.ParseNetworks([]string{strings.TrimSpace(r.URL.Query().Get("network"))})
+	if err != nil {
+		h.jsonError(w, "Invalid address or network", http.StatusBadRequest)
+		return
+	}
+	network := networks[0].String()
+
+	h.denylistAdminMu.Lock()
+	defer h.denylistAdminMu.Unlock()
+	entries, err := h.denylistEntries(ctx)
+	if err != nil {
+		h.jsonError(w, "Failed to read denylist", http.StatusInternalServerError)
+		return
+	}
+	kept := slices.DeleteFunc(entries, func(e DenylistEntry) bool { return e.Network == network })
+	if len(kept) == len(entries) {
+		h.jsonError(w, "Network is not on the runtime denylist", http.StatusNotFound)
+		return
+	}
+	if err := h.saveDenylist(ctx, kept); err != nil {
+		h.jsonError(w, "Failed to store denylist", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{})
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// TestDenylist_Runtime tests denying and allowing the test client address
+// (192.0.2.1) through the admin API.
+func TestDenylist_Runtime(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+
+	rr := adminRequest(h, http.MethodPost, "/admin/denylist", testAdminToken, map[string]string{
+		"network": " 192.0.2.1 ",
+		"comment": "spam wave",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Entry DenylistEntry `json:"entry"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response.Entry.Network != "192.0.2.1/32" || response.Entry.Comment != "spam wave" || response.Entry.Added == 0 {
+		t.Errorf("unexpected entry %+v", response.Entry)
+	}
+
+	// Applies at once on the instance that made the change
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	rr = adminRequest(h, http.MethodDelete, "/admin/denylist?network=192.0.2.1/32", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr = adminRequest(h, http.MethodDelete, "/admin/denylist?network=192.0.2.1", testAdminToken, nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestDenylist_RuntimeList tests listing and validation of entries.
+func TestDenylist_RuntimeList(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+	h.config.Security.Denylist = []string{"198.51.100.7"}
+	h.initDenylist()
+
+	for _, network := range []string{"203.0.113.0/24", "2001:db8::1", "203.0.113.9/24"} {
+		if rr := adminRequest(h, http.MethodPost, "/admin/denylist", testAdminToken, map[string]string{"network": network}); rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d", http.StatusOK, network, rr.Code)
+		}
+	}
+
+	rr := adminRequest(h, http.MethodGet, "/admin/denylist", testAdminToken, nil)
+	var response struct {
+		Configured []string        `json:"configured"`
+		Entries    []DenylistEntry `json:"entries"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if len(response.Configured) != 1 || response.Configured[0] != "198.51.100.7/32" {
+		t.Errorf("unexpected configured networks %v", response.Configured)
+	}
+	// Adding a network again replaces it rather than listing it twice
+	var networks []string
+	for _, entry := range response.Entries {
+		networks = append(networks, entry.Network)
+	}
+	if strings.Join(networks, " ") != "2001:db8::1/128 203.0.113.0/24" {
+		t.Errorf("unexpected entries %v", networks)
+	}
+
+	for _, body := range []map[string]string{
+		{"network": ""},
+		{"network": "not-an-ip"},
+		{"network": "203.0.113.0/33"},
+		{"network": "203.0.113.7", "comment": strings.Repeat("x", maxDenylistComment+1)},
+	} {
+		if rr := adminRequest(h, http.MethodPost, "/admin/denylist", testAdminToken, body); rr.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %v, got %d", http.StatusBadRequest, body, rr.Code)
+		}
+	}
+}
+
+// TestDenylist_RuntimeShared tests that entries added by another instance
+// sharing the storage apply once this instance's copy is refreshed.
+func TestDenylist_RuntimeShared(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	h.SetClock(fake)
+
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	mockStore.SetValue(ctx, storage.NamespaceAdmin, denylistKey, `[{"network":"192.0.2.0/24","added":1700000000}]`)
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected the cached denylist to apply, got status %d", rr.Code)
+	}
+
+	fake.Advance(denylistRefresh)
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d after a refresh, got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+// TestDenylist_Reads tests that [security] denylist_reads refuses reads.
+func TestDenylist_Reads(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	pasteID := "f468483c313401e8"
+	mockStore.CreatePaste(ctx, pasteID, model.NewPaste())
+	withDenylist(t, h, true)
+
+	// Only creation is refused by default
+	if rr := readPaste(h, pasteID); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// Reads aren't tarpitted
+	h.config.Security.DenylistReads = true
+	if rr := readPaste(h, pasteID); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
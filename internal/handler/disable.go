@@ -0,0 +1,80 @@
+// Package handler provides turning a paste's link off without deleting it.
+// When a link ends up somewhere it shouldn't, say pasted into a public
+// channel during an incident, deleting the paste is the only way to stop
+// readers, and the content is lost with it. Instead the holder of the delete
+// token can disable the paste: it stays stored and keeps its expiration, but
+// reads and new comments are refused until it is enabled again.
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// disablePaste handles link disable and enable requests.
+// Request format:
+//
+//	{"pasteid": "f468483c313401e8", "deletetoken": "...", "disabled": true}
+//
+// Response format:
+//
+//	{"status": 0, "id": "f468483c313401e8", "disabled": true}
+func (h *Handler) disablePaste(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.inReadOnly(w, r) {
+		return
+	}
+	var req map[string]interface{}
+	if !h.decodeJSON(w, r.Body, &req) {
+		return
+	}
+
+	// Get paste ID
+	pasteID, ok := req["pasteid"].(string)
+	if !ok || pasteID == "" {
+		h.jsonError(w, "No paste ID provided", http.StatusBadRequest)
+		return
+	}
+
+	// Validate paste ID format
+	if err := util.ValidateIDOrError(pasteID); err != nil {
+		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get delete token
+	deleteToken, ok := req["deletetoken"].(string)
+	if !ok || deleteToken == "" {
+		h.jsonError(w, "No delete token provided", http.StatusBadRequest)
+		return
+	}
+
+	disabled, ok := req["disabled"].(bool)
+	if !ok {
+		h.jsonError(w, "No disabled state provided", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := h.authorizedPaste(ctx, w, pasteID, deleteToken); !ok {
+		return
+	}
+
+	if err := h.store.SetDisabled(ctx, pasteID, disabled); err != nil {
+		if errors.Is(err, model.ErrPasteNotFound) {
+			h.jsonError(w, "Paste not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to disable paste", "error", err)
+		h.jsonError(w, "Failed to update paste", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"id":       pasteID,
+		"disabled": disabled,
+	})
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// requestDisable posts a disable request and returns the recorder.
+func requestDisable(h *Handler, pasteID, deleteToken string, disabled interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"pasteid":     pasteID,
+		"deletetoken": deleteToken,
+		"disabled":    disabled,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/disable", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	h.disablePaste(rr, req)
+	return rr
+}
+
+// TestDisable_Toggle tests that a disabled paste is kept but not served
+// until it is enabled again.
+func TestDisable_Toggle(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.Meta.OpenDiscussion = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+
+	rr := requestDisable(h, pasteID, deleteToken, true)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response["disabled"] != true {
+		t.Errorf("expected disabled true, got %v", response["disabled"])
+	}
+
+	if rr := readWithProof(h, pasteID, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d reading a disabled paste, got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr := postComment(h, pasteID, "comment"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d commenting on a disabled paste, got %d", http.StatusForbidden, rr.Code)
+	}
+	if !mockStore.PasteExists(ctx, pasteID) {
+		t.Fatal("disabling should keep the paste")
+	}
+
+	if rr := requestDisable(h, pasteID, deleteToken, false); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr := readWithProof(h, pasteID, ""); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d after enabling, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
+// TestDisable_BurnAfterReading tests that reading a disabled
+// burn-after-reading paste doesn't burn it.
+func TestDisable_BurnAfterReading(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.Meta.BurnAfterReading = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+
+	if rr := requestDisable(h, pasteID, deleteToken, true); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if rr := readWithProof(h, pasteID, ""); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+	if !mockStore.PasteExists(ctx, pasteID) {
+		t.Error("a refused read should not burn the paste")
+	}
+}
+
+// TestDisable_Errors tests disable request validation.
+func TestDisable_Errors(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+
+	missingID := "0123456789abcdef"
+	missingToken, _ := util.GenerateDeleteToken(missingID, h.salt)
+
+	tests := []struct {
+		name        string
+		pasteID     string
+		deleteToken string
+		disabled    interface{}
+		status      int
+	}{
+		{"missing paste ID", "", "token", true, http.StatusBadRequest},
+		{"missing token", pasteID, "", true, http.StatusBadRequest},
+		{"missing state", pasteID, deleteToken, nil, http.StatusBadRequest},
+		{"state not a boolean", pasteID, deleteToken, "yes", http.StatusBadRequest},
+		{"wrong token", pasteID, "wrong-token", true, http.StatusForbidden},
+		{"missing paste", missingID, missingToken, true, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := requestDisable(h, tt.pasteID, tt.deleteToken, tt.disabled)
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if stored, _ := mockStore.ReadPaste(ctx, pasteID); stored.Meta.Disabled {
+		t.Error("rejected requests should not disable the paste")
+	}
+}
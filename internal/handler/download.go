@@ -0,0 +1,68 @@
+// Package handler provides bandwidth limiting for paste downloads.
+// Large encrypted attachments are served through a throttled writer so a
+// single client can't saturate the server's uplink. Each download gets its
+// own limiter at [traffic] download_rate, and all downloads share one at
+// download_rate_global.
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/throttle"
+)
+
+// downloadWriteTimeout bounds each throttled write. A throttled download may
+// legitimately outlast the server's WriteTimeout, so the deadline is pushed
+// forward as data flows and only a stalled client is cut off.
+const downloadWriteTimeout = 30 * time.Second
+
+// initDownloads creates the limiter shared by all downloads.
+func (h *Handler) initDownloads() {
+	h.downloads = throttle.NewLimiter(h.config.Traffic.DownloadRateGlobal)
+}
+
+// throttledWriter is a ResponseWriter whose body writes are rate limited.
+type throttledWriter struct {
+	http.ResponseWriter
+	body io.Writer
+}
+
+// Write sends the body through the limiters.
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	return t.body.Write(p)
+}
+
+// deadlineWriter extends the connection's write deadline before each write.
+type deadlineWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// Write extends the deadline, then writes p.
+func (d deadlineWriter) Write(p []byte) (int, error) {
+	// Unsupported by some writers (e.g. in tests); the server default applies
+	d.rc.SetWriteDeadline(time.Now().Add(downloadWriteTimeout))
+	return d.w.Write(p)
+}
+
+// throttle wraps w with the per-download and global bandwidth limits.
+// It returns w unchanged when neither limit is configured.
+func (h *Handler) throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	perDownload := throttle.NewLimiter(h.config.Traffic.DownloadRate)
+	if perDownload == nil && h.downloads == nil {
+		return w
+	}
+
+	// The request timeout would cut off slow downloads midway; a client
+	// that goes away instead surfaces as a failed write
+	ctx := context.WithoutCancel(r.Context())
+	target := deadlineWriter{w: w, rc: http.NewResponseController(w)}
+
+	return &throttledWriter{
+		ResponseWriter: w,
+		body:           throttle.Writer(ctx, target, perDownload, h.downloads),
+	}
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// TestDownload_Unlimited tests that no limiter wraps the writer by default.
+func TestDownload_Unlimited(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	rr := httptest.NewRecorder()
+	if w := h.throttle(rr, httptest.NewRequest(http.MethodGet, "/", nil)); w != http.ResponseWriter(rr) {
+		t.Error("expected unthrottled writer without download limits")
+	}
+}
+
+// TestDownload_RawThrottled tests that raw downloads respect download_rate.
+func TestDownload_RawThrottled(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Traffic.DownloadRate = 64 * 1024
+
+	// One second's worth is sent immediately; the remaining half takes ~0.5s
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.Attachments = model.StringList{strings.Repeat("A", 96*1024)}
+	mockStore.CreatePaste(ctx, "abcdef1234567890", paste)
+
+	start := time.Now()
+	rr := requestRaw(h, "abcdef1234567890")
+	elapsed := time.Since(start)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), paste.Attachments[0]) {
+		t.Error("expected full attachment in throttled response")
+	}
+	if elapsed < 400*time.Millisecond {
+		t.Errorf("expected throttled download, took %v", elapsed)
+	}
+}
+
+// TestDownload_GlobalLimiter tests that the global limiter is shared.
+func TestDownload_GlobalLimiter(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Traffic.DownloadRateGlobal = 1024
+	h.initDownloads()
+
+	if h.downloads == nil {
+		t.Fatal("expected global limiter")
+	}
+	rr := httptest.NewRecorder()
+	if w := h.throttle(rr, httptest.NewRequest(http.MethodGet, "/", nil)); w == http.ResponseWriter(rr) {
+		t.Error("expected throttled writer with a global limit")
+	}
+}
@@ -0,0 +1,154 @@
+// Package handler provides replacing the content of a paste. Shared links
+// sometimes point at content that has to change, such as a secret that was
+// rotated, and a new paste would mean handing out a new URL. With [main]
+// editable set, creation responses carry an edit token, and the holder can
+// send new ciphertext for the paste under the same ID. The client encrypts
+// it with the key already in the URL, so existing links keep working.
+// Attachments, comments, expiration, and flags are kept.
+//
+// The edit token is separate from the delete token, so edit rights can be
+// handed out without the right to delete. PrivateBin has no edits.
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// editPaste handles paste edits, sent to the paste endpoint with PUT.
+// Request format:
+//
+//	{"pasteid": "f468483c313401e8", "edittoken": "...", "v": 2, "ct": "...", "adata": [...]}
+//
+// Response format:
+//
+//	{"status": 0, "id": "f468483c313401e8", "url": "/?f468483c313401e8"}
+//
+// Of adata only the formatter is taken; the discussion and burn flags the
+// paste was created with stay in force.
+func (h *Handler) editPaste(w http.ResponseWriter, r *http.Request, req map[string]interface{}) {
+	ctx := r.Context()
+	if !h.config.Main.Editable {
+		h.jsonError(w, "Editing pastes is disabled", http.StatusForbidden)
+		return
+	}
+	if h.inMaintenance(w, r) {
+		return
+	}
+
+	// Edits write as much as creations do
+	if !h.allowRequest(w, r, limitPaste) {
+		return
+	}
+
+	// Get paste ID
+	pasteID, ok := req["pasteid"].(string)
+	if !ok || pasteID == "" {
+		h.jsonError(w, "No paste ID provided", http.StatusBadRequest)
+		return
+	}
+
+	// Validate paste ID format
+	if err := util.ValidateIDOrError(pasteID); err != nil {
+		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get edit token
+	editToken, ok := req["edittoken"].(string)
+	if !ok || editToken == "" {
+		h.jsonError(w, "No edit token provided", http.StatusBadRequest)
+		return
+	}
+
+	ct, ok := req["ct"].(string)
+	if !ok || ct == "" {
+		h.jsonError(w, "No paste data provided", http.StatusBadRequest)
+		return
+	}
+	if int64(len(ct)) > h.live().Main.SizeLimit {
+		h.jsonError(w, "Paste exceeds size limit", http.StatusBadRequest)
+		return
+	}
+
+	// New ciphertext comes with a new IV, so adata must be sent again
+	adata, ok := req["adata"]
+	if !ok {
+		h.jsonError(w, "No adata provided", http.StatusBadRequest)
+		return
+	}
+	adataJSON, err := json.Marshal(adata)
+	if err != nil {
+		h.jsonError(w, "Invalid adata", http.StatusBadRequest)
+		return
+	}
+
+	paste, ok := h.editablePaste(ctx, w, pasteID, editToken)
+	if !ok {
+		return
+	}
+
+	update := &model.Paste{Data: ct, AData: adataJSON, Version: paste.Version}
+	if v, ok := req["v"].(float64); ok {
+		update.Version = int(v)
+	}
+	update.Meta.Formatter = paste.Meta.Formatter
+	update.ParseAData()
+	if err := update.Validate(); err != nil {
+		h.jsonError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.UpdatePaste(ctx, pasteID, update); err != nil {
+		if errors.Is(err, model.ErrPasteNotFound) {
+			h.jsonError(w, "Paste not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to edit paste", "error", err)
+		h.jsonError(w, "Failed to update paste", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"id":  pasteID,
+		"url": h.pasteURL(r, pasteID),
+	})
+}
+
+// editablePaste reads a paste and checks its edit token, writing the error
+// response and returning false if the paste can't be read or the token is
+// wrong. Secrets can't be edited: the secret API has no edit tokens.
+func (h *Handler) editablePaste(ctx context.Context, w http.ResponseWriter, pasteID, editToken string) (*model.Paste, bool) {
+	paste, err := h.store.ReadPaste(ctx, pasteID)
+	if err == nil && paste.Meta.Secret != "" {
+		err = model.ErrPasteNotFound
+	}
+	if err != nil {
+		if err == model.ErrPasteNotFound || err == model.ErrPasteExpired {
+			h.jsonError(w, "Paste not found", http.StatusNotFound)
+			return nil, false
+		}
+		h.jsonError(w, "Failed to read paste", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	expected, err := pasteEditToken(pasteID, h.deleteTokenSalt(paste))
+	if err != nil || subtle.ConstantTimeCompare([]byte(editToken), []byte(expected)) != 1 {
+		h.jsonError(w, "Invalid edit token", http.StatusForbidden)
+		return nil, false
+	}
+	return paste, true
+}
+
+// pasteEditToken derives a paste's edit token from its ID and salt. The
+// prefix keeps it from ever equaling the paste's delete token.
+func pasteEditToken(pasteID, salt string) (string, error) {
+	return util.GenerateDeleteToken("edit:"+pasteID, salt)
+}
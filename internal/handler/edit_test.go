@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// requestEdit sends an edit with PUT and returns the recorder.
+func requestEdit(h *Handler, pasteID, editToken, ct string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":         2,
+		"pasteid":   pasteID,
+		"edittoken": editToken,
+		"ct":        ct,
+		"adata":     []interface{}{[]interface{}{"iv2", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "markdown", 0, 0},
+	})
+	req := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	h.handlePost(rr, req)
+	return rr
+}
+
+// createDiscussed creates a paste with a discussion and returns the recorder.
+func createDiscussed(h *Handler) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "original",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 1, 0},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr
+}
+
+// TestEdit_ReplacesContent tests that the edit token returned at creation
+// replaces a paste's content under the same ID, and nothing else does.
+func TestEdit_ReplacesContent(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Main.Editable = true
+
+	var created map[string]interface{}
+	json.Unmarshal(createDiscussed(h).Body.Bytes(), &created)
+	pasteID := created["id"].(string)
+	editToken, _ := created["edittoken"].(string)
+	if editToken == "" || editToken == created["deletetoken"] {
+		t.Fatalf("expected an edit token apart from the delete token, got %v", created)
+	}
+
+	for name, token := range map[string]string{
+		"delete token": created["deletetoken"].(string),
+		"wrong token":  "0000000000000000000000000000000000000000000000000000000000000000",
+	} {
+		if rr := requestEdit(h, pasteID, token, "rotated"); rr.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status %d, got %d", name, http.StatusForbidden, rr.Code)
+		}
+	}
+
+	rr := requestEdit(h, pasteID, editToken, "rotated")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	paste, err := mockStore.ReadPaste(ctx, pasteID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paste.Data != "rotated" || paste.Meta.Formatter != model.FormatterMarkdown {
+		t.Errorf("expected the new content and formatter, got %q and %q", paste.Data, paste.Meta.Formatter)
+	}
+	if !paste.Meta.OpenDiscussion {
+		t.Error("expected the paste's flags to be kept")
+	}
+
+	if rr := requestEdit(h, "0123456789abcdef", editToken, "rotated"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d editing a missing paste, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestEdit_Disabled tests that without [main] editable no edit token is
+// handed out and edits are refused.
+func TestEdit_Disabled(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	var created map[string]interface{}
+	json.Unmarshal(createDiscussed(h).Body.Bytes(), &created)
+	if _, ok := created["edittoken"]; ok {
+		t.Errorf("expected no edit token, got %v", created)
+	}
+	token, _ := pasteEditToken(created["id"].(string), "")
+	if rr := requestEdit(h, created["id"].(string), token, "rotated"); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
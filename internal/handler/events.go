@@ -0,0 +1,54 @@
+// Package handler provides the handler's lifecycle event bus.
+// Request handlers publish events; creator callbacks, webhooks, metrics, and
+// the event log are subscribers like any other, registered when the bus is
+// first used.
+package handler
+
+import (
+	"log/slog"
+
+	"github.com/liskl/flashpaper/internal/events"
+)
+
+// Events returns the bus the handler publishes lifecycle events on.
+// Subscribe to it before the server starts taking traffic.
+func (h *Handler) Events() *events.Bus {
+	h.eventsOnce.Do(h.initEvents)
+	return h.events
+}
+
+// initEvents creates the bus and subscribes the built-in features.
+func (h *Handler) initEvents() {
+	h.events = events.New()
+	h.events.Subscribe(h.callbackEvent)
+	h.events.Subscribe(h.metricsEvent)
+	h.events.Subscribe(logEvent)
+	if h.streams != nil {
+		h.events.Subscribe(h.streamEvent)
+	}
+	if h.webhooks != nil {
+		h.events.Subscribe(h.webhookEvent)
+	}
+}
+
+// logEvent logs lifecycle events. Paste and comment IDs grant access to
+// content, so events naming them are logged at debug level only.
+func logEvent(e events.Event) {
+	switch e.Kind {
+	case events.PurgeCompleted:
+		slog.Info("Purged expired pastes", "count", e.Purged)
+	case events.PasteDeleted:
+		slog.Debug("Paste deleted", "paste_id", e.PasteID, "reason", string(e.Reason))
+	case events.CommentCreated:
+		slog.Debug("Comment created", "paste_id", e.PasteID, "comment_id", e.CommentID)
+	case events.CommentDeleted:
+		slog.Debug("Comment deleted", "paste_id", e.PasteID, "comment_id", e.CommentID)
+	default:
+		slog.Debug("Paste event", "event", string(e.Kind), "paste_id", e.PasteID)
+	}
+}
+
+// publish sends e to every subscriber.
+func (h *Handler) publish(e events.Event) {
+	h.Events().Publish(e)
+}
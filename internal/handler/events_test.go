@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// recordEvents subscribes to h's bus and returns the events seen so far.
+func recordEvents(h *Handler) func() []events.Event {
+	var got []events.Event
+	h.Events().Subscribe(func(e events.Event) { got = append(got, e) })
+	return func() []events.Event { return got }
+}
+
+// TestEvents_PasteLifecycle tests the events published for create, read, and delete.
+func TestEvents_PasteLifecycle(t *testing.T) {
+	h, _ := newTestHandler(t)
+	seen := recordEvents(h)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "encrypted",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var created map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&created)
+	pasteID := created["id"].(string)
+
+	for i := 0; i < 2; i++ {
+		req = httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+		req.Header.Set("Accept", "application/json")
+		h.handleGet(httptest.NewRecorder(), req)
+	}
+
+	body, _ = json.Marshal(map[string]interface{}{
+		"pasteid":     pasteID,
+		"deletetoken": created["deletetoken"],
+	})
+	req = httptest.NewRequest(http.MethodDelete, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	h.handleDelete(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	got := seen()
+	if len(got) != 4 {
+		t.Fatalf("expected 4 events, got %d: %+v", len(got), got)
+	}
+	if got[0].Kind != events.PasteCreated || got[0].PasteID != pasteID || got[0].Expire != "1day" || got[0].Paste == nil {
+		t.Errorf("unexpected create event: %+v", got[0])
+	}
+	if got[1].Kind != events.PasteRead || !got[1].First {
+		t.Errorf("expected first read event, got %+v", got[1])
+	}
+	if got[2].Kind != events.PasteRead || got[2].First {
+		t.Errorf("expected repeat read event, got %+v", got[2])
+	}
+	if got[3].Kind != events.PasteDeleted || got[3].Reason != events.ReasonToken {
+		t.Errorf("expected token delete event, got %+v", got[3])
+	}
+}
+
+// TestEvents_Comment tests the event published for a new comment.
+func TestEvents_Comment(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	seen := recordEvents(h)
+
+	pasteID := "abcdef1234567890"
+	newDiscussionPaste(mockStore, pasteID)
+
+	if rr := postComment(h, pasteID, "encrypted-comment"); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	got := seen()
+	if len(got) != 1 || got[0].Kind != events.CommentCreated || got[0].PasteID != pasteID || got[0].CommentID == "" {
+		t.Errorf("unexpected events: %+v", got)
+	}
+}
+
+// TestMaybePurge tests that creation triggers a rate-limited purge.
+func TestMaybePurge(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Purge.Limit = 300
+	h.config.Purge.BatchSize = 10
+	seen := recordEvents(h)
+
+	expired := model.NewPaste()
+	expired.Data = "old"
+	expired.Meta.ExpireDate = time.Now().Add(-time.Hour).Unix()
+	mockStore.CreatePaste(ctx, "0000000000000001", expired)
+	mockStore.CreatePaste(ctx, "0000000000000002", expired)
+
+	h.maybePurge(ctx)
+	h.Wait()
+
+	if mockStore.PasteExists(ctx, "0000000000000001") || mockStore.PasteExists(ctx, "0000000000000002") {
+		t.Error("expected expired pastes to be purged")
+	}
+	got := seen()
+	if len(got) != 1 || got[0].Kind != events.PurgeCompleted || got[0].Purged != 2 {
+		t.Fatalf("expected one purge event for 2 pastes, got %+v", got)
+	}
+
+	// Within the limit nothing runs
+	mockStore.CreatePaste(ctx, "0000000000000003", expired)
+	h.maybePurge(ctx)
+	h.Wait()
+	if !mockStore.PasteExists(ctx, "0000000000000003") {
+		t.Error("expected no purge within the limit")
+	}
+}
+
+// TestMaybePurge_RateLimits tests that purges drop rate limit buckets that
+// have filled up again, such as those keyed by an earlier salt.
+func TestMaybePurge_RateLimits(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Purge.Limit = 60
+	h.config.Traffic.Limit = 60
+	h.config.Traffic.Burst = 1
+
+	if rr, _ := createIdempotent(h, "", "encrypted-content"); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	h.Wait()
+	if len(trafficKeys(mockStore)) != 1 {
+		t.Fatalf("expected one rate limit bucket, got %v", trafficKeys(mockStore))
+	}
+
+	// Two minutes on, the bucket is full and the next purge is due
+	c := clock.NewFake(time.Now().Add(2 * time.Minute))
+	h.SetClock(c)
+	mockStore.SetClock(c)
+	h.maybePurge(ctx)
+	h.Wait()
+	if keys := trafficKeys(mockStore); len(keys) != 0 {
+		t.Errorf("expected full buckets to be purged, got %v", keys)
+	}
+}
+
+// trafficKeys returns the keys of the rate limiter's buckets in store.
+func trafficKeys(store *storage.Mock) []string {
+	var keys []string
+	store.Values(context.Background(), func(namespace, key, value string) error {
+		if namespace == storage.NamespaceTraffic {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys
+}
+
+// TestEvents_LogPasteIDsOnlyAtDebug tests that the event log names pastes
+// only when debug logging is on.
+func TestEvents_LogPasteIDsOnlyAtDebug(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	for _, tt := range []struct {
+		level string
+		want  bool
+	}{
+		{"info", false},
+		{"debug", true},
+	} {
+		var buf bytes.Buffer
+		logger, _ := logging.New(&buf, tt.level, logging.FormatText)
+		slog.SetDefault(logger)
+
+		h, _ := newTestHandler(t)
+		pasteID := createSized(h, 10)["id"].(string)
+
+		if got := strings.Contains(buf.String(), pasteID); got != tt.want {
+			t.Errorf("%s: paste ID logged = %v, want %v:\n%s", tt.level, got, tt.want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package handler provides live discussion updates over Server-Sent Events.
+// A reader of a paste with discussion open can keep GET /events?pasteid=...
+// open and is told when comments are posted or deleted, and when the paste
+// itself goes, instead of reloading the page to find out. Notices carry IDs
+// only: comments are encrypted, so the client fetches the paste again to
+// decrypt what changed.
+//
+// Streams are fed from the lifecycle event bus, which only sees this
+// process's requests. With several replicas behind a load balancer, [events]
+// redis relays each replica's notices to the others over Redis pub/sub.
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/redis"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+const (
+	// streamBuffer is the notices a stream may fall behind by before
+	// further ones are dropped for it
+	streamBuffer = 16
+
+	// streamRetry is the reconnection delay sent to clients
+	streamRetry = 5 * time.Second
+
+	// relayQueue bounds the notices waiting to be published to Redis
+	relayQueue = 256
+
+	// relayTimeout bounds one publish to Redis
+	relayTimeout = 5 * time.Second
+
+	// relayBackoff is the pause before resubscribing after Redis failed
+	relayBackoff = 5 * time.Second
+)
+
+// notice is one notification on an event stream. Kind is the SSE event
+// name: comment.created, comment.deleted, or paste.deleted.
+type notice struct {
+	Kind      events.Kind `json:"-"`
+	PasteID   string      `json:"pasteid"`
+	CommentID string      `json:"commentid,omitempty"`
+}
+
+// relayMessage is a notice as replicas exchange it over Redis.
+type relayMessage struct {
+	Origin    string      `json:"origin"` // Instance that published it
+	Kind      events.Kind `json:"kind"`
+	PasteID   string      `json:"pasteid"`
+	CommentID string      `json:"commentid,omitempty"`
+}
+
+// streamHub fans notices out to the streams watching each paste.
+type streamHub struct {
+	max int // Open streams allowed; 0 = unlimited
+
+	mu      sync.Mutex
+	streams map[string]map[chan notice]struct{} // By paste ID
+	count   int
+
+	closed    chan struct{} // Closed by close; streams end
+	closeOnce sync.Once
+}
+
+func newStreamHub(max int) *streamHub {
+	return &streamHub{
+		max:     max,
+		streams: map[string]map[chan notice]struct{}{},
+		closed:  make(chan struct{}),
+	}
+}
+
+// watch opens a stream for pasteID, unless max are open already.
+func (s *streamHub) watch(pasteID string) (chan notice, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.max > 0 && s.count >= s.max {
+		return nil, false
+	}
+	ch := make(chan notice, streamBuffer)
+	if s.streams[pasteID] == nil {
+		s.streams[pasteID] = map[chan notice]struct{}{}
+	}
+	s.streams[pasteID][ch] = struct{}{}
+	s.count++
+	return ch, true
+}
+
+// unwatch closes a stream opened by watch.
+func (s *streamHub) unwatch(pasteID string, ch chan notice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams[pasteID], ch)
+	if len(s.streams[pasteID]) == 0 {
+		delete(s.streams, pasteID)
+	}
+	s.count--
+}
+
+// broadcast sends n to the streams watching its paste. A stream too far
+// behind misses it rather than holding up the publisher.
+func (s *streamHub) broadcast(n notice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.streams[n.PasteID] {
+		select {
+		case ch <- n:
+		default:
+		}
+	}
+}
+
+// close ends every stream, for shutdown.
+func (s *streamHub) close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+// initStreams sets up the event streams and the Redis relay, if enabled.
+func (h *Handler) initStreams() {
+	cfg := h.config.Events
+	if !cfg.Enabled {
+		return
+	}
+	h.streams = newStreamHub(cfg.MaxClients)
+	if cfg.Redis != "" {
+		h.relay = redis.New(cfg.Redis, cfg.RedisPassword)
+		h.relayOut = make(chan notice, relayQueue)
+		var id [8]byte
+		rand.Read(id[:])
+		h.instanceID = hex.EncodeToString(id[:])
+	}
+}
+
+// CloseStreams ends all open event streams. The HTTP server's graceful
+// shutdown waits for responses to finish, which streams never do.
+func (h *Handler) CloseStreams() {
+	if h.streams != nil {
+		h.streams.close()
+	}
+}
+
+// streamEvent passes comment and deletion events on to the streams, and
+// to the other replicas.
+func (h *Handler) streamEvent(e events.Event) {
+	switch e.Kind {
+	case events.CommentCreated, events.CommentDeleted, events.PasteDeleted:
+	default:
+		return
+	}
+	n := notice{Kind: e.Kind, PasteID: e.PasteID, CommentID: e.CommentID}
+	h.streams.broadcast(n)
+	if h.relay != nil {
+		select {
+		case h.relayOut <- n:
+		default:
+			slog.Debug("Event relay queue full; notice dropped", "event", string(e.Kind))
+		}
+	}
+}
+
+// RunEventRelay exchanges notices with the other replicas through Redis
+// until ctx is done. It returns at once without [events] redis.
+func (h *Handler) RunEventRelay(ctx context.Context) {
+	if h.relay == nil {
+		return
+	}
+	defer h.relay.Close()
+
+	go h.publishRelayed(ctx)
+	for {
+		err := h.relay.Subscribe(ctx, h.config.Events.RedisChannel, h.relayed)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Event relay disconnected", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(relayBackoff):
+		}
+	}
+}
+
+// publishRelayed publishes this instance's notices until ctx is done.
+func (h *Handler) publishRelayed(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-h.relayOut:
+			data, _ := json.Marshal(relayMessage{
+				Origin:    h.instanceID,
+				Kind:      n.Kind,
+				PasteID:   n.PasteID,
+				CommentID: n.CommentID,
+			})
+			publishCtx, cancel := context.WithTimeout(ctx, relayTimeout)
+			if err := h.relay.Publish(publishCtx, h.config.Events.RedisChannel, data); err != nil {
+				slog.Warn("Event relay publish failed", "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// relayed passes a notice from another replica on to this one's streams.
+func (h *Handler) relayed(message []byte) {
+	var m relayMessage
+	if err := json.Unmarshal(message, &m); err != nil || m.Origin == h.instanceID {
+		return
+	}
+	if !util.ValidateID(m.PasteID) {
+		return
+	}
+	h.streams.broadcast(notice{Kind: m.Kind, PasteID: m.PasteID, CommentID: m.CommentID})
+}
+
+// streamEvents handles GET /events?pasteid=..., streaming the paste's
+// discussion notices as Server-Sent Events until the client leaves, the
+// paste is deleted, or the server shuts down. Only pastes a client could
+// read and comment on can be watched.
+func (h *Handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	pasteID := r.URL.Query().Get("pasteid")
+	if err := util.ValidateIDOrError(pasteID); err != nil {
+		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
+		return
+	}
+	if !h.allowRequest(w, r, limitRead) {
+		return
+	}
+
+	paste, err := h.store.ReadPaste(ctx, pasteID)
+	if err == nil && paste.Meta.Secret != "" {
+		err = model.ErrPasteNotFound
+	}
+	switch err {
+	case nil:
+	case model.ErrPasteNotFound, model.ErrPasteExpired:
+		h.jsonError(w, "Paste not found", http.StatusNotFound)
+		return
+	default:
+		h.jsonError(w, "Failed to read paste", http.StatusInternalServerError)
+		return
+	}
+	if paste.Meta.Disabled {
+		h.jsonError(w, "Paste link is disabled", http.StatusForbidden)
+		return
+	}
+	if !h.checkAccessProof(w, r, pasteID, paste) {
+		return
+	}
+	if !paste.HasDiscussion() {
+		h.jsonError(w, "Discussion is disabled for this paste", http.StatusForbidden)
+		return
+	}
+
+	ch, ok := h.streams.watch(pasteID)
+	if !ok {
+		w.Header().Set("Retry-After", fmt.Sprint(int(streamRetry.Seconds())))
+		h.jsonError(w, "Too many event streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.streams.unwatch(pasteID, ch)
+
+	// Streams outlive the server's read and write timeouts
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Unbuffered through nginx
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry.Milliseconds())
+	if rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(time.Duration(h.config.Events.Heartbeat) * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.streams.closed:
+			return
+		case n := <-ch:
+			data, _ := json.Marshal(n)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", n.Kind, data)
+			if rc.Flush() != nil || n.Kind == events.PasteDeleted {
+				return
+			}
+		case <-heartbeat.C:
+			io.WriteString(w, ": heartbeat\n\n")
+			if rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// newStreamingHandler returns a test handler with event streams enabled,
+// serving streamEvents from a real server so responses are streamed.
+func newStreamingHandler(t *testing.T, maxClients int) (*Handler, *httptest.Server) {
+	t.Helper()
+	h, mockStore := newTestHandler(t)
+	h.config.Events.Enabled = true
+	h.config.Events.MaxClients = maxClients
+	h.config.Events.Heartbeat = 25
+	h.initStreams()
+	newDiscussionPaste(mockStore, "abcdef1234567890")
+
+	srv := httptest.NewServer(http.HandlerFunc(h.streamEvents))
+	t.Cleanup(func() {
+		h.CloseStreams()
+		srv.Close()
+	})
+	return h, srv
+}
+
+// openStream opens an event stream for pasteID and returns its lines.
+func openStream(t *testing.T, srv *httptest.Server, pasteID string) (*http.Response, *bufio.Scanner) {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/events?pasteid=" + pasteID)
+	if err != nil {
+		t.Fatalf("opening stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp, bufio.NewScanner(resp.Body)
+}
+
+// nextEvent returns the name and data of the next event on a stream,
+// skipping the retry field and heartbeats.
+func nextEvent(t *testing.T, lines *bufio.Scanner) (string, notice) {
+	t.Helper()
+	var name string
+	for lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var n notice
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &n); err != nil {
+				t.Fatalf("bad event data %q: %v", line, err)
+			}
+			return name, n
+		}
+	}
+	t.Fatalf("stream ended: %v", lines.Err())
+	return "", notice{}
+}
+
+// waitWatching waits until n streams are open.
+func waitWatching(t *testing.T, h *Handler, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.streams.mu.Lock()
+		count := h.streams.count
+		h.streams.mu.Unlock()
+		if count == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d open streams, got %d", n, count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestStreamEvents_Comments tests that a stream is told about comments on
+// its paste and ends when the paste is deleted.
+func TestStreamEvents_Comments(t *testing.T) {
+	h, srv := newStreamingHandler(t, 0)
+
+	resp, lines := openStream(t, srv, "abcdef1234567890")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected Content-Type text/event-stream, got %q", ct)
+	}
+	waitWatching(t, h, 1)
+
+	if rr := postComment(h, "abcdef1234567890", "comment"); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d posting a comment, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	name, n := nextEvent(t, lines)
+	if name != string(events.CommentCreated) || n.PasteID != "abcdef1234567890" || n.CommentID == "" {
+		t.Errorf("expected comment.created for the paste, got %s %+v", name, n)
+	}
+
+	// Other pastes' events aren't sent
+	h.publish(events.Event{Kind: events.CommentCreated, PasteID: "0123456789abcdef", CommentID: "1111111111111111"})
+	h.publish(events.Event{Kind: events.PasteDeleted, PasteID: "abcdef1234567890"})
+	if name, _ := nextEvent(t, lines); name != string(events.PasteDeleted) {
+		t.Errorf("expected paste.deleted, got %s", name)
+	}
+	for lines.Scan() {
+		if lines.Text() != "" {
+			t.Errorf("expected the stream to end after paste.deleted, got %q", lines.Text())
+		}
+	}
+	waitWatching(t, h, 0)
+}
+
+// TestStreamEvents_Refused tests the pastes that can't be watched.
+func TestStreamEvents_Refused(t *testing.T) {
+	ctx := context.Background()
+	h, srv := newStreamingHandler(t, 0)
+
+	closed := model.NewPaste()
+	closed.Data = "no-discussion"
+	h.store.CreatePaste(ctx, "0123456789abcdef", closed)
+	disabled := model.NewPaste()
+	disabled.Meta.OpenDiscussion = true
+	disabled.Meta.Disabled = true
+	h.store.CreatePaste(ctx, "fedcba9876543210", disabled)
+
+	for _, tt := range []struct {
+		pasteID string
+		status  int
+	}{
+		{"not-an-id", http.StatusBadRequest},
+		{"1111111111111111", http.StatusNotFound},
+		{"0123456789abcdef", http.StatusForbidden},
+		{"fedcba9876543210", http.StatusForbidden},
+	} {
+		resp, _ := openStream(t, srv, tt.pasteID)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.pasteID, tt.status, resp.StatusCode)
+		}
+	}
+}
+
+// TestStreamEvents_MaxClients tests that streams beyond [events]
+// max_clients are turned away.
+func TestStreamEvents_MaxClients(t *testing.T) {
+	h, srv := newStreamingHandler(t, 1)
+
+	resp, _ := openStream(t, srv, "abcdef1234567890")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	waitWatching(t, h, 1)
+
+	resp, _ = openStream(t, srv, "abcdef1234567890")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+}
+
+// TestStreamEvents_Relayed tests that notices from other replicas reach
+// the streams, and this instance's own are ignored when they come back.
+func TestStreamEvents_Relayed(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.streams = newStreamHub(0)
+	h.instanceID = "self"
+	ch, _ := h.streams.watch("abcdef1234567890")
+
+	own, _ := json.Marshal(relayMessage{Origin: "self", Kind: events.CommentCreated, PasteID: "abcdef1234567890"})
+	h.relayed(own)
+	other, _ := json.Marshal(relayMessage{Origin: "other", Kind: events.CommentDeleted, PasteID: "abcdef1234567890", CommentID: "1111111111111111"})
+	h.relayed(other)
+	h.relayed([]byte("not json"))
+
+	select {
+	case n := <-ch:
+		if n.Kind != events.CommentDeleted || n.CommentID != "1111111111111111" {
+			t.Errorf("expected the other replica's comment.deleted, got %+v", n)
+		}
+	default:
+		t.Fatal("expected a relayed notice")
+	}
+	if len(ch) != 0 {
+		t.Errorf("expected only one notice, got %d more", len(ch))
+	}
+}
@@ -0,0 +1,95 @@
+// Package handler provides paste expiration extension.
+// A shared snippet sometimes turns out to be needed longer than planned.
+// The holder of the delete token can push its expiration out by picking one
+// of the configured expiration options again, counted from now, so an
+// extended paste never lives longer than a new paste could.
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// extendPaste handles expiration extension requests.
+// Request format:
+//
+//	{"pasteid": "f468483c313401e8", "deletetoken": "...", "expire": "1week"}
+//
+// Response format:
+//
+//	{"status": 0, "id": "f468483c313401e8", "expiredate": 1700604800}
+//
+// An expiredate of 0 means the paste no longer expires. Extensions that
+// would bring the expiration closer are refused.
+func (h *Handler) extendPaste(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.inReadOnly(w, r) {
+		return
+	}
+	var req map[string]interface{}
+	if !h.decodeJSON(w, r.Body, &req) {
+		return
+	}
+
+	// Get paste ID
+	pasteID, ok := req["pasteid"].(string)
+	if !ok || pasteID == "" {
+		h.jsonError(w, "No paste ID provided", http.StatusBadRequest)
+		return
+	}
+
+	// Validate paste ID format
+	if err := util.ValidateIDOrError(pasteID); err != nil {
+		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get delete token
+	deleteToken, ok := req["deletetoken"].(string)
+	if !ok || deleteToken == "" {
+		h.jsonError(w, "No delete token provided", http.StatusBadRequest)
+		return
+	}
+
+	// Only configured options; unknown ones would mean "never" otherwise
+	expire, _ := req["expire"].(string)
+	duration, ok := h.live().Expire.Options[expire]
+	if !ok {
+		h.jsonError(w, "Invalid expiration option", http.StatusBadRequest)
+		return
+	}
+
+	paste, ok := h.authorizedPaste(ctx, w, pasteID, deleteToken)
+	if !ok {
+		return
+	}
+
+	var expireDate int64
+	if duration > 0 {
+		expireDate = h.clock.Now().Add(duration).Unix()
+	}
+	current := paste.Meta.ExpireDate
+	if current == 0 || (expireDate != 0 && expireDate <= current) {
+		h.jsonError(w, "Paste already expires later", http.StatusConflict)
+		return
+	}
+
+	if err := h.store.SetExpireDate(ctx, pasteID, expireDate); err != nil {
+		if errors.Is(err, model.ErrPasteNotFound) {
+			h.jsonError(w, "Paste not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to extend paste", "error", err)
+		h.jsonError(w, "Failed to update paste", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"id":         pasteID,
+		"expiredate": expireDate,
+	})
+}
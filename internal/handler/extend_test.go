@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// requestExtend posts an extension request and returns the recorder.
+func requestExtend(h *Handler, pasteID, deleteToken, expire string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"pasteid":     pasteID,
+		"deletetoken": deleteToken,
+		"expire":      expire,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/extend", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	h.extendPaste(rr, req)
+	return rr
+}
+
+// TestExtend_PushesExpiration tests extending a paste, up to never.
+func TestExtend_PushesExpiration(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.SetExpiration(time.Hour)
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+
+	rr := requestExtend(h, pasteID, deleteToken, "1week")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+
+	stored, _ := mockStore.ReadPaste(ctx, pasteID)
+	want := time.Now().Add(7 * 24 * time.Hour).Unix()
+	if stored.Meta.ExpireDate < want-5 || stored.Meta.ExpireDate > want {
+		t.Errorf("expected expiration about %d, got %d", want, stored.Meta.ExpireDate)
+	}
+	if response["expiredate"] != float64(stored.Meta.ExpireDate) {
+		t.Errorf("expected expiredate %d, got %v", stored.Meta.ExpireDate, response["expiredate"])
+	}
+
+	// Shorter options are refused
+	if rr := requestExtend(h, pasteID, deleteToken, "1day"); rr.Code != http.StatusConflict {
+		t.Errorf("expected status %d for a shorter option, got %d", http.StatusConflict, rr.Code)
+	}
+
+	if rr := requestExtend(h, pasteID, deleteToken, "never"); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d for never, got %d", http.StatusOK, rr.Code)
+	}
+	if stored, _ := mockStore.ReadPaste(ctx, pasteID); stored.Meta.ExpireDate != 0 {
+		t.Errorf("expected paste to never expire, got %d", stored.Meta.ExpireDate)
+	}
+
+	// Nothing is later than never
+	if rr := requestExtend(h, pasteID, deleteToken, "1year"); rr.Code != http.StatusConflict {
+		t.Errorf("expected status %d for a paste that never expires, got %d", http.StatusConflict, rr.Code)
+	}
+}
+
+// TestExtend_Errors tests extension request validation.
+func TestExtend_Errors(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.SetExpiration(time.Hour)
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+
+	missingID := "0123456789abcdef"
+	missingToken, _ := util.GenerateDeleteToken(missingID, h.salt)
+
+	tests := []struct {
+		name        string
+		pasteID     string
+		deleteToken string
+		expire      string
+		status      int
+	}{
+		{"missing paste ID", "", "token", "1week", http.StatusBadRequest},
+		{"missing token", pasteID, "", "1week", http.StatusBadRequest},
+		{"unknown option", pasteID, deleteToken, "10years", http.StatusBadRequest},
+		{"missing option", pasteID, deleteToken, "", http.StatusBadRequest},
+		{"wrong token", pasteID, "wrong-token", "1week", http.StatusForbidden},
+		{"missing paste", missingID, missingToken, "1week", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := requestExtend(h, tt.pasteID, tt.deleteToken, tt.expire)
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if stored, _ := mockStore.ReadPaste(ctx, pasteID); stored.Meta.ExpireDate != paste.Meta.ExpireDate {
+		t.Error("rejected requests should not change the expiration")
+	}
+}
@@ -4,87 +4,210 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"html/template"
 	"io/fs"
 	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 
-	flashpaper "github.com/liskl/flashpaper"
+	"github.com/liskl/flashpaper/internal/assets"
+	"github.com/liskl/flashpaper/internal/callback"
+	"github.com/liskl/flashpaper/internal/captcha"
+	"github.com/liskl/flashpaper/internal/clock"
 	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/i18n"
+	"github.com/liskl/flashpaper/internal/metrics"
+	fpMiddleware "github.com/liskl/flashpaper/internal/middleware"
+	"github.com/liskl/flashpaper/internal/oidc"
+	"github.com/liskl/flashpaper/internal/ratelimit"
+	"github.com/liskl/flashpaper/internal/redis"
+	"github.com/liskl/flashpaper/internal/shortener"
 	"github.com/liskl/flashpaper/internal/storage"
-	"github.com/liskl/flashpaper/internal/util"
+	"github.com/liskl/flashpaper/internal/throttle"
+	"github.com/liskl/flashpaper/internal/version"
+	"github.com/liskl/flashpaper/internal/webhook"
 )
 
 // Handler contains dependencies for HTTP handlers.
 type Handler struct {
-	config   *config.Config
-	store    storage.Storage
-	salt     string             // Server salt for delete tokens
-	template *template.Template // Parsed HTML template
-	staticFS fs.FS              // Embedded static files (JS, CSS)
+	config    *config.Config
+	store     storage.Storage
+	salt      string              // Server salt for delete tokens
+	template  *template.Template  // Parsed HTML template (guarded by templateMu; see templates.go)
+	staticFS  fs.FS               // Static files (JS, CSS), embedded or overlaid by [main] staticdir
+	assets    *assets.Pipeline    // Fingerprinted view of staticFS (guarded by templateMu)
+	callbacks *callback.Notifier  // Creator notifications (nil if disabled)
+	webhooks  *webhook.Dispatcher // Operator webhooks (nil if disabled; see webhook.go)
+	captcha   captcha.Verifier    // Captcha checks on creation (nil if disabled; see captcha.go)
+	manifest  *TemplateManifest   // Active template's needs (see manifest.go; guarded by templateMu)
+	tos       *tosDocument        // Terms of service (nil if not configured)
+	downloads *throttle.Limiter   // Bandwidth shared by all downloads (nil if unlimited)
+	metrics   *metrics.Registry   // Usage metrics (see metrics.go)
+	denylist  []netip.Prefix      // Clients refused creation (see tarpit.go)
+	tarpit    chan struct{}       // Tarpit slots (nil if disabled; see tarpit.go)
+	events    *events.Bus         // Lifecycle events; use Events() (see events.go)
+	i18n      *i18n.Bundle        // UI translations (nil = English only; see i18n.go)
+
+	eventsOnce sync.Once
+	purging    atomic.Bool    // A purge cycle is running (see purge.go)
+	purged     atomic.Int64   // Pastes purged since startup (see stats.go)
+	background sync.WaitGroup // Work outliving its request; see Wait
+
+	pasteMetrics    *pasteMetrics
+	activityMetrics *activityMetrics
+
+	templateMu  sync.RWMutex
+	templateErr error // Why templates failed to load (reported by /readyz)
+	staticErr   error // Why static files failed to load (reported by /readyz)
+	devMu       sync.Mutex
+	devStamp    string // Files seen by the last [main] template_reload check (see templates.go)
+
+	commentHooks []CommentHook // Pre-create comment checks (see AddCommentHook)
+
+	tokenMu      sync.Mutex // Serializes token usage updates (see tokens.go)
+	moderationMu sync.Mutex // Serializes moderation queue updates (see moderation.go)
+	powMu        sync.Mutex // Serializes recording answered challenges (see pow.go)
+
+	denylistAdminMu sync.Mutex     // Serializes runtime denylist updates (see denylist.go)
+	denylistMu      sync.Mutex     // Guards the two below
+	denylistRuntime []netip.Prefix // This instance's copy of the runtime denylist
+	denylistLoaded  time.Time      // When it was read; zero to read it again
+
+	networksOnce sync.Once
+	exempted     []netip.Prefix // [traffic] exempted; use trafficNetworks (see networks.go)
+	creators     []netip.Prefix // [traffic] creators; likewise
+
+	limiterOnce sync.Once
+	limiter     *ratelimit.Limiter // Traffic limits; use rateLimiter (see ratelimit.go)
+
+	oidcOnce sync.Once
+	oidc     *oidc.Verifier // [auth] OIDC tokens; use oidcVerifier (see auth.go)
+
+	reloaded atomic.Pointer[config.Config] // Last config applied by Reload; use live (see reload.go)
+
+	idempotencyMu       sync.Mutex
+	idempotencyInFlight map[string]bool // Keys of creates being processed (see idempotency.go); created lazily
+
+	shortener shortener.Shortener // Paste URL shortener (nil if disabled; see shortlink.go)
+
+	streams    *streamHub    // Open event streams (nil if disabled; see eventstream.go)
+	relay      *redis.Client // Relay to other replicas (nil without [events] redis)
+	relayOut   chan notice   // Notices waiting for the relay
+	instanceID string        // Tells this instance's relayed notices apart
+
+	statsMu    sync.Mutex // Serializes taking stats (see stats.go)
+	statsCache *Stats     // Last stats taken; nil until the first request
+
+	clock clock.Clock // Post, expiration, and rate limit times; see SetClock
 }
 
 // New creates a new Handler with the given configuration and storage.
 func New(cfg *config.Config, store storage.Storage) *Handler {
 	h := &Handler{
-		config: cfg,
-		store:  store,
+		config:    cfg,
+		store:     store,
+		callbacks: callback.New(cfg.Callback),
+		webhooks:  webhook.New(cfg.Webhook),
+		captcha:   captcha.New(cfg.Captcha),
+		shortener: shortener.New(cfg.Main, store),
+		clock:     clock.System,
 	}
 
 	// Initialize or retrieve server salt
 	h.initSalt()
 
+	// Initialize static file serving
+	// Must precede templates, which reference fingerprinted asset URLs
+	h.initStaticFS()
+
 	// Initialize embedded templates
 	h.initTemplates()
+	h.initManifest()
+	if cfg.Main.TemplateReload {
+		h.devStamp = uiStamp(cfg.Main.TemplateDir, cfg.Main.StaticDir)
+	}
 
-	// Initialize static file serving
-	h.initStaticFS()
+	// UI translations
+	h.initI18n()
+
+	// Load the terms of service, if configured
+	h.initTOS()
+
+	// Global download bandwidth limit
+	h.initDownloads()
+
+	// Clients refused creation
+	h.initDenylist()
+
+	// Usage metrics
+	h.initMetrics()
+
+	// Live discussion updates
+	h.initStreams()
 
 	return h
 }
 
-// initTemplates parses the embedded HTML templates.
+// SetClock replaces the system clock for the handler and its storage, so
+// tests can expire pastes and refill rate limits without waiting. It must
+// be called before the handler starts serving requests.
+func (h *Handler) SetClock(c clock.Clock) {
+	h.clock = c
+	storage.SetClock(h.store, c)
+}
+
+// initTemplates parses the embedded HTML templates and any overrides.
 // Templates use Go's html/template for safe HTML rendering.
+// On failure the error is kept for Ready and serveUI uses its fallback.
 func (h *Handler) initTemplates() {
-	templateFS, err := flashpaper.TemplateFS()
+	h.template, h.templateErr = h.loadTemplates(h.assets)
+}
+
+// initManifest loads the manifest of the configured template.
+// A missing or invalid manifest leaves the default asset list and CSP,
+// and degrades readiness.
+func (h *Handler) initManifest() {
+	manifest, err := h.loadThemeManifest(h.template)
 	if err != nil {
-		// Log error but continue - will use fallback in serveUI
-		return
+		h.templateErr = errors.Join(h.templateErr, err)
+		manifest = defaultManifest(h.config.Main.Template)
 	}
+	h.manifest = manifest
+}
 
-	h.template, _ = template.ParseFS(templateFS, "*.html")
+// templateFuncs returns helper functions available to all templates.
+func templateFuncs(p *assets.Pipeline) template.FuncMap {
+	return template.FuncMap{
+		// asset maps a logical static path to its fingerprinted URL:
+		// {{asset "js/flashpaper.js"}} -> /js/flashpaper.3f9c1a2b.js
+		"asset": p.URL,
+	}
 }
 
-// initStaticFS sets up the embedded static file system.
+// initStaticFS sets up the static file system, embedded or overlaid.
+// Files are hashed once here so templates can reference fingerprinted URLs.
 func (h *Handler) initStaticFS() {
-	staticFS, err := flashpaper.StaticFS()
-	if err != nil {
-		return
-	}
-	h.staticFS = staticFS
+	h.staticFS, h.assets, h.staticErr = h.loadStatic()
 }
 
 // initSalt retrieves or generates the server salt.
 // The salt is used for generating delete tokens and must persist across restarts.
 func (h *Handler) initSalt() {
-	// Try to get existing salt
-	salt, err := h.store.GetValue(storage.NamespaceSalt, "server")
-	if err == nil && salt != "" {
-		h.salt = salt
-		return
-	}
-
-	// Generate new salt
-	salt, err = util.GenerateSalt()
+	salt, err := storage.ServerSalt(context.Background(), h.store)
 	if err != nil {
 		// Fall back to a less secure but functional salt
 		salt = "flashpaper-fallback-salt-change-me"
 	}
-
-	// Store for future use
-	_ = h.store.SetValue(storage.NamespaceSalt, "server", salt)
 	h.salt = salt
 }
 
@@ -92,36 +215,108 @@ func (h *Handler) initSalt() {
 func (h *Handler) Routes() chi.Router {
 	r := chi.NewRouter()
 
-	// Health check endpoint
-	r.Get("/health", h.healthCheck)
+	// Per-route timeouts from [server]; everything else gets the default
+	srv := h.config.Server
+	health := r.With(withTimeout(srv.HealthTimeout))
+	create := r.With(withTimeout(srv.CreateTimeout), h.limitBody(h.createBodyLimit))
+	read := r.With(withTimeout(srv.ReadTimeout))
+	base := r.With(withTimeout(srv.Timeout))
+
+	// Liveness and readiness probes (see health.go)
+	health.Get("/health", h.healthCheck)
+	health.Get("/healthz", h.healthCheck)
+	health.Get("/readyz", h.readyCheck)
+
+	// UI bootstrap configuration (same document embedded in the template)
+	base.Get("/config", h.serveConfig)
+
+	// Instance discovery for directory sites and clients
+	base.Get("/.well-known/flashpaper.json", h.serveWellKnown)
+
+	// Aggregate statistics (opt-in; see stats.go)
+	if h.config.Stats.Enabled {
+		base.Get("/stats", h.serveStats)
+	}
 
 	// Documentation pages
-	r.Get("/implementation", h.serveImplementation)
-	r.Get("/docs", h.serveDocs)
+	base.Get("/implementation", h.serveImplementation)
+	base.Get("/docs", h.serveDocs)
+
+	// Proof-of-work challenges (only when required; see pow.go)
+	if h.config.PoW.Enabled {
+		base.Get("/pow", h.servePoW)
+	}
+
+	// Terms of service (only when a document is configured)
+	if h.config.TOS.File != "" {
+		base.Get("/tos", h.serveTOS)
+	}
 
 	// Main paste operations
 	// PrivateBin uses query string for paste ID: /?pasteID
-	r.Get("/", h.handleGet)
-	r.Post("/", h.handlePost)
-	r.Put("/", h.handlePost)    // PrivateBin also accepts PUT
-	r.Delete("/", h.handleDelete)
+	read.Get("/", h.handleGet)
+	create.Post("/", h.handlePost)
+	create.Put("/", h.handlePost) // PrivateBin also accepts PUT; edits use it too
+	base.With(h.smallBody()).Delete("/", h.handleDelete)
+
+	// Path forms of paste links, for proxies and link unfurlers that drop
+	// or mangle bare query strings
+	read.Get("/p/{id}", h.handleGetPath)
+	read.Get("/paste/{id}", h.handleGetPath)
+
+	// First-read receipt (requires the delete token)
+	read.With(h.smallBody()).Post("/receipt", h.getReceipt)
+
+	// Expiration extension (requires the delete token)
+	base.With(h.smallBody()).Post("/extend", h.extendPaste)
+
+	// Turning a paste's link off and on (requires the delete token)
+	base.With(h.smallBody()).Post("/disable", h.disablePaste)
+
+	// Live discussion updates; streams stay open, so no timeout
+	if h.streams != nil {
+		r.Get("/events", h.streamEvents)
+	}
+
+	// Encrypted paste as a downloadable file
+	read.Get("/raw/{id}", h.getRaw)
+
+	// Short links of the local URL shortener
+	if h.config.Main.URLShortener == config.URLShortenerLocal {
+		base.Get("/s/{code}", h.followShortLink)
+	}
+
+	// JSON API for scripts (see secret.go); routes carry their own timeouts
+	if h.config.API.Secrets {
+		r.Mount(secretPath, h.secretRoutes())
+	}
+
+	// Operator API (only when admin tokens are configured)
+	if h.config.Admin.Enabled() {
+		base.Mount("/admin", h.adminRoutes())
+	}
+
+	// Translation catalogs for the UI script
+	base.Get("/i18n/{lang}.json", h.serveI18n)
 
 	// Static files served from embedded filesystem
-	// JS files: /js/flashpaper.js
-	// CSS files: /css/style.css
-	if h.staticFS != nil {
-		fileServer := http.FileServer(http.FS(h.staticFS))
-		r.Handle("/js/*", fileServer)
-		r.Handle("/css/*", fileServer)
+	// Fingerprinted: /js/flashpaper.3f9c1a2b.js (cached immutably)
+	// Plain:         /js/flashpaper.js (revalidated on every use)
+	if h.assets != nil {
+		base.HandleFunc("/js/*", h.serveStatic)
+		base.HandleFunc("/css/*", h.serveStatic)
 	}
 
 	return r
 }
 
-// healthCheck returns a simple health status.
-func (h *Handler) healthCheck(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+// withTimeout returns middleware that cancels a request's context after the
+// given number of seconds. Zero disables the timeout.
+func withTimeout(seconds int) func(http.Handler) http.Handler {
+	if seconds <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return middleware.Timeout(time.Duration(seconds) * time.Second)
 }
 
 // handleGet handles GET requests - either serve the UI or return paste data.
@@ -149,10 +344,28 @@ func (h *Handler) handleGet(w http.ResponseWriter, r *http.Request) {
 	h.serveUI(w, r)
 }
 
-// handlePost handles POST/PUT requests - create paste or comment.
+// handleGetPath handles GET /p/{id} and /paste/{id}, answering exactly as
+// GET /?{id} does. Links created by the server stay in the query form, so
+// PrivateBin clients can follow them.
+func (h *Handler) handleGetPath(w http.ResponseWriter, r *http.Request) {
+	pasteID := chi.URLParam(r, "id")
+	if isJSONRequest(r) {
+		h.getPaste(w, r, pasteID)
+		return
+	}
+	h.serveUI(w, r)
+}
+
+// handlePost handles POST/PUT requests - create, edit, or delete a paste,
+// or create a comment.
 func (h *Handler) handlePost(w http.ResponseWriter, r *http.Request) {
 	// Check content type
 	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		// Paste with a streamed attachment (see attachment.go)
+		h.handleMultipartPost(w, r)
+		return
+	}
 	if contentType != "application/json" && contentType != "application/x-www-form-urlencoded" {
 		h.jsonError(w, "Invalid content type", http.StatusBadRequest)
 		return
@@ -160,14 +373,19 @@ func (h *Handler) handlePost(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request body
 	var req map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.jsonError(w, "Invalid JSON", http.StatusBadRequest)
+	if !h.decodeJSON(w, r.Body, &req) {
+		return
+	}
+
+	// Check if this is an edit (has edittoken; see edit.go)
+	if _, hasEdit := req["edittoken"]; hasEdit {
+		h.editPaste(w, r, req)
 		return
 	}
 
 	// Check if this is a delete request (has deletetoken)
 	if _, hasDelete := req["deletetoken"]; hasDelete {
-		h.deletePaste(w, r, req)
+		h.handleDeleteRequest(w, r, req)
 		return
 	}
 
@@ -178,45 +396,75 @@ func (h *Handler) handlePost(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Otherwise, create new paste
-	h.createPaste(w, r, req)
+	h.createPaste(w, r, req, nil)
 }
 
 // handleDelete handles DELETE requests.
 func (h *Handler) handleDelete(w http.ResponseWriter, r *http.Request) {
 	var req map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.jsonError(w, "Invalid JSON", http.StatusBadRequest)
+	if !h.decodeJSON(w, r.Body, &req) {
 		return
 	}
 
+	h.handleDeleteRequest(w, r, req)
+}
+
+// handleDeleteRequest deletes a comment if the request names one, and the
+// paste otherwise.
+func (h *Handler) handleDeleteRequest(w http.ResponseWriter, r *http.Request, req map[string]interface{}) {
+	if h.inReadOnly(w, r) {
+		return
+	}
+	if _, hasComment := req["commentid"]; hasComment {
+		h.deleteComment(w, r, req)
+		return
+	}
 	h.deletePaste(w, r, req)
 }
 
 // TemplateData contains data passed to the HTML template.
 type TemplateData struct {
-	Name        string // Application name
-	BasePath    string // Base URL path
-	Version     string // Application version
-	Discussion  bool   // Whether discussions are globally enabled
-	BurnEnabled bool   // Whether burn-after-reading is enabled
+	Name        string          // Application name
+	BasePath    string          // Base URL path
+	Version     string          // Application version
+	Discussion  bool            // Whether discussions are globally enabled
+	BurnEnabled bool            // Whether burn-after-reading is enabled
+	Config      ClientConfig    // Bootstrap config rendered as a JSON script block
+	Template    TemplateInfo    // Styles, scripts, and data from the template manifest
+	CSPNonce    string          // Nonce for inline scripts and styles; "" unless [security] csp_nonce
+	Lang        *i18n.Locale    // Page language; {{.Lang.T "message"}} translates
+	Languages   []i18n.Language // Language picker choices (nil unless [main] languageselection)
 }
 
-// serveUI serves the main HTML page using the embedded template.
-func (h *Handler) serveUI(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-
-	// Prepare template data
-	data := TemplateData{
+// templateData builds the data shared by all HTML pages.
+func (h *Handler) templateData(r *http.Request) TemplateData {
+	ctx := r.Context()
+	return TemplateData{
 		Name:        h.config.Main.Name,
 		BasePath:    h.config.Main.BasePath,
-		Version:     "1.0.0",
+		Version:     version.Version,
 		Discussion:  h.config.Main.Discussion,
 		BurnEnabled: h.config.Main.BurnAfterReadingSelected,
+		Config:      h.clientConfig(ctx),
+		Template:    h.templateInfo(),
+		CSPNonce:    fpMiddleware.CSPNonce(r.Context()),
+		Lang:        h.locale(r),
+		Languages:   h.languages(),
 	}
+}
+
+// serveUI serves the main HTML page using the embedded template.
+func (h *Handler) serveUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	// Prepare template data
+	h.devReload()
+	data := h.templateData(r)
+	h.setLanguageHeaders(w, data.Lang)
 
 	// Try to execute template
-	if h.template != nil {
-		if err := h.template.ExecuteTemplate(w, "index.html", data); err == nil {
+	if tmpl := h.currentTemplate(); tmpl != nil {
+		if err := tmpl.ExecuteTemplate(w, pageTemplate(tmpl, h.config.Main.Template), data); err == nil {
 			return
 		}
 	}
@@ -241,17 +489,12 @@ func (h *Handler) serveImplementation(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	// Prepare template data
-	data := TemplateData{
-		Name:        h.config.Main.Name,
-		BasePath:    h.config.Main.BasePath,
-		Version:     "1.0.0",
-		Discussion:  h.config.Main.Discussion,
-		BurnEnabled: h.config.Main.BurnAfterReadingSelected,
-	}
+	h.devReload()
+	data := h.templateData(r)
 
 	// Try to execute template
-	if h.template != nil {
-		if err := h.template.ExecuteTemplate(w, "implementation.html", data); err == nil {
+	if tmpl := h.currentTemplate(); tmpl != nil {
+		if err := tmpl.ExecuteTemplate(w, "implementation.html", data); err == nil {
 			return
 		}
 	}
@@ -265,17 +508,12 @@ func (h *Handler) serveDocs(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	// Prepare template data
-	data := TemplateData{
-		Name:        h.config.Main.Name,
-		BasePath:    h.config.Main.BasePath,
-		Version:     "1.0.0",
-		Discussion:  h.config.Main.Discussion,
-		BurnEnabled: h.config.Main.BurnAfterReadingSelected,
-	}
+	h.devReload()
+	data := h.templateData(r)
 
 	// Try to execute template
-	if h.template != nil {
-		if err := h.template.ExecuteTemplate(w, "docs.html", data); err == nil {
+	if tmpl := h.currentTemplate(); tmpl != nil {
+		if err := tmpl.ExecuteTemplate(w, "docs.html", data); err == nil {
 			return
 		}
 	}
@@ -294,6 +532,18 @@ func (h *Handler) jsonError(w http.ResponseWriter, message string, status int) {
 	})
 }
 
+// jsonErrorCode sends a JSON error response with a machine-readable code.
+// The code lets clients react to specific failures without parsing messages.
+func (h *Handler) jsonErrorCode(w http.ResponseWriter, message, code string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  1,
+		"message": message,
+		"code":    code,
+	})
+}
+
 // jsonSuccess sends a JSON success response.
 func (h *Handler) jsonSuccess(w http.ResponseWriter, data map[string]interface{}) {
 	w.Header().Set("Content-Type", "application/json")
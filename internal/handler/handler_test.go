@@ -3,14 +3,18 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/liskl/flashpaper/internal/clock"
 	"github.com/liskl/flashpaper/internal/config"
+	fpMiddleware "github.com/liskl/flashpaper/internal/middleware"
 	"github.com/liskl/flashpaper/internal/model"
 	"github.com/liskl/flashpaper/internal/storage"
 	"github.com/liskl/flashpaper/internal/util"
@@ -22,10 +26,11 @@ func newTestHandler(t *testing.T) (*Handler, *storage.Mock) {
 
 	cfg := &config.Config{
 		Main: config.MainConfig{
-			Name:       "TestPaste",
-			BasePath:   "",
-			Discussion: true,
-			SizeLimit:  10 * 1024 * 1024, // 10MB
+			Name:            "TestPaste",
+			BasePath:        "",
+			Discussion:      true,
+			SizeLimit:       10 * 1024 * 1024, // 10MB
+			AttachmentLimit: 10 * 1024 * 1024,
 		},
 		Expire: config.ExpireConfig{
 			Default: "1week",
@@ -52,6 +57,7 @@ func newTestHandler(t *testing.T) (*Handler, *storage.Mock) {
 		config: cfg,
 		store:  mockStore,
 		salt:   "dGVzdC1zYWx0LTEyMzQ1LWZsYXNocGFwZXI=", // base64("test-salt-12345-flashpaper")
+		clock:  clock.System,
 	}
 
 	return h, mockStore
@@ -239,6 +245,7 @@ func TestCreatePaste_ExceedsSizeLimit(t *testing.T) {
 
 // TestGetPaste_ValidPaste tests retrieving an existing paste.
 func TestGetPaste_ValidPaste(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a paste directly in storage (16 lowercase hex chars)
@@ -246,7 +253,7 @@ func TestGetPaste_ValidPaste(t *testing.T) {
 	paste := model.NewPaste()
 	paste.Data = "encrypted-content"
 	paste.AData = []byte(`[["iv","salt",100000,256,128,"aes","gcm","zlib"],"plaintext",0,0]`)
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Request the paste with JSON header
 	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
@@ -281,6 +288,48 @@ func TestGetPaste_ValidPaste(t *testing.T) {
 	}
 }
 
+// TestGetPaste_PathRoutes tests that /p/{id} and /paste/{id} answer like
+// the query form: JSON for API requests, the UI otherwise.
+func TestGetPaste_PathRoutes(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.initTemplates()
+	router := h.Routes()
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	for _, path := range []string{"/p/" + pasteID, "/paste/" + pasteID} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Requested-With", "JSONHttpRequest")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if rr.Code != http.StatusOK || response["ct"] != "encrypted-content" {
+			t.Errorf("%s: expected the paste, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+			t.Errorf("%s: expected the UI, got %d %s", path, rr.Code, rr.Header().Get("Content-Type"))
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/p/nothere", nil)
+	req.Header.Set("X-Requested-With", "JSONHttpRequest")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid ID, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
 // TestGetPaste_NotFound tests retrieving a non-existent paste.
 func TestGetPaste_NotFound(t *testing.T) {
 	h, _ := newTestHandler(t)
@@ -314,6 +363,7 @@ func TestGetPaste_InvalidID(t *testing.T) {
 
 // TestGetPaste_BurnAfterReading tests that burn-after-reading pastes are deleted.
 func TestGetPaste_BurnAfterReading(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a burn-after-reading paste (16 lowercase hex chars)
@@ -322,7 +372,7 @@ func TestGetPaste_BurnAfterReading(t *testing.T) {
 	paste.Data = "secret-content"
 	paste.Meta.BurnAfterReading = true
 	paste.AData = []byte(`[["iv","salt",100000,256,128,"aes","gcm","zlib"],"plaintext",0,1]`)
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// First read should succeed
 	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
@@ -335,19 +385,146 @@ func TestGetPaste_BurnAfterReading(t *testing.T) {
 		t.Errorf("expected status %d on first read, got %d", http.StatusOK, rr.Code)
 	}
 
-	// Give time for async deletion
-	// Note: In real tests, you'd use synchronization
+	// Deleted with the read, not after it
+	if mockStore.PasteExists(ctx, pasteID) {
+		t.Error("expected paste to be burned by the first read")
+	}
+	rr = httptest.NewRecorder()
+	h.handleGet(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d on second read, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestGetPaste_BurnAfterReadingConcurrent tests that of concurrent readers
+// only one gets a burn-after-reading paste.
+func TestGetPaste_BurnAfterReadingConcurrent(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "baf1ead123456789"
+	paste := model.NewPaste()
+	paste.Data = "secret-content"
+	paste.Meta.BurnAfterReading = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	const readers = 8
+	codes := make(chan int, readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+			req.Header.Set("Accept", "application/json")
+			rr := httptest.NewRecorder()
+			h.handleGet(rr, req)
+			codes <- rr.Code
+		}()
+	}
+
+	served := 0
+	for i := 0; i < readers; i++ {
+		switch code := <-codes; code {
+		case http.StatusOK:
+			served++
+		case http.StatusNotFound:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if served != 1 {
+		t.Errorf("expected exactly one reader to get the paste, got %d", served)
+	}
+}
+
+// createWithReadLimit posts a paste with the given meta max_reads.
+func createWithReadLimit(h *Handler, maxReads interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "secret-content",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day", "max_reads": maxReads},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr
+}
+
+// TestGetPaste_ReadLimit tests that a paste with max_reads is served that
+// many times, counting down reads_left, and deleted with the last read.
+func TestGetPaste_ReadLimit(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	rr := createWithReadLimit(h, 3)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var created map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	pasteID := created["id"].(string)
+
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	for _, left := range []float64{2, 1, 0} {
+		rr := httptest.NewRecorder()
+		h.handleGet(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d with %v reads left, got %d", http.StatusOK, left, rr.Code)
+		}
+		var response struct {
+			Meta map[string]interface{} `json:"meta"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if response.Meta["max_reads"] != float64(3) || response.Meta["reads_left"] != left {
+			t.Errorf("expected max_reads 3 and reads_left %v, got %v", left, response.Meta)
+		}
+	}
+
+	if mockStore.PasteExists(ctx, pasteID) {
+		t.Error("expected paste to be deleted by its last read")
+	}
+	rr = httptest.NewRecorder()
+	h.handleGet(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d after the last read, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestCreatePaste_ReadLimit tests that a one-read limit is stored as
+// burn-after-reading and that bad limits are refused.
+func TestCreatePaste_ReadLimit(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	rr := createWithReadLimit(h, 1)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var created map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	paste, _ := mockStore.ReadPaste(ctx, created["id"].(string))
+	if !paste.Meta.BurnAfterReading || paste.HasReadLimit() {
+		t.Errorf("expected a burn-after-reading paste without a read limit, got %+v", paste.Meta)
+	}
+
+	for _, maxReads := range []interface{}{0, -2, 2.5, "3"} {
+		if rr := createWithReadLimit(h, maxReads); rr.Code != http.StatusBadRequest {
+			t.Errorf("max_reads %v: expected status %d, got %d", maxReads, http.StatusBadRequest, rr.Code)
+		}
+	}
 }
 
 // TestDeletePaste_ValidToken tests deleting a paste with valid token.
 func TestDeletePaste_ValidToken(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a paste (16 lowercase hex chars)
 	pasteID := "de1e7e0012345678"
 	paste := model.NewPaste()
 	paste.Data = "to-be-deleted"
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Generate valid delete token
 	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
@@ -369,20 +546,56 @@ func TestDeletePaste_ValidToken(t *testing.T) {
 	}
 
 	// Verify paste was deleted
-	if mockStore.PasteExists(pasteID) {
+	if mockStore.PasteExists(ctx, pasteID) {
 		t.Error("paste should have been deleted")
 	}
 }
 
+// TestDeletePaste_PerPasteSalt tests that new pastes get delete tokens from
+// their own salt, while pastes stored without one keep using the server salt.
+func TestDeletePaste_PerPasteSalt(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	created := createSized(h, 100)
+	pasteID := created["id"].(string)
+	deleteToken := created["deletetoken"].(string)
+
+	stored, _ := mockStore.ReadPaste(ctx, pasteID)
+	if stored.Meta.Salt == "" || stored.Meta.Salt == h.salt {
+		t.Fatalf("expected a per-paste salt, got %q", stored.Meta.Salt)
+	}
+	if legacy, _ := util.GenerateDeleteToken(pasteID, h.salt); deleteToken == legacy {
+		t.Fatal("delete token must not derive from the server salt")
+	}
+
+	// A server-salt token doesn't delete a salted paste
+	legacy, _ := util.GenerateDeleteToken(pasteID, h.salt)
+	body, _ := json.Marshal(map[string]interface{}{"pasteid": pasteID, "deletetoken": legacy})
+	rr := httptest.NewRecorder()
+	h.handleDelete(rr, httptest.NewRequest(http.MethodDelete, "/", bytes.NewReader(body)))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for server-salt token, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	body, _ = json.Marshal(map[string]interface{}{"pasteid": pasteID, "deletetoken": deleteToken})
+	rr = httptest.NewRecorder()
+	h.handleDelete(rr, httptest.NewRequest(http.MethodDelete, "/", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
 // TestDeletePaste_InvalidToken tests rejecting delete with wrong token.
 func TestDeletePaste_InvalidToken(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a paste (16 lowercase hex chars)
 	pasteID := "0de1e7e123456789"
 	paste := model.NewPaste()
 	paste.Data = "should-not-delete"
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Send delete request with invalid token
 	reqBody := map[string]interface{}{
@@ -401,7 +614,7 @@ func TestDeletePaste_InvalidToken(t *testing.T) {
 	}
 
 	// Verify paste still exists
-	if !mockStore.PasteExists(pasteID) {
+	if !mockStore.PasteExists(ctx, pasteID) {
 		t.Error("paste should not have been deleted with invalid token")
 	}
 }
@@ -469,6 +682,7 @@ func TestDeletePaste_NotFound(t *testing.T) {
 
 // TestCreateComment_ValidRequest tests creating a comment on a paste.
 func TestCreateComment_ValidRequest(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a paste with discussion enabled
@@ -476,7 +690,7 @@ func TestCreateComment_ValidRequest(t *testing.T) {
 	paste := model.NewPaste()
 	paste.Data = "paste-with-discussion"
 	paste.Meta.OpenDiscussion = true
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Create a comment
 	reqBody := map[string]interface{}{
@@ -508,8 +722,46 @@ func TestCreateComment_ValidRequest(t *testing.T) {
 	}
 }
 
+// TestCreateComment_LimitReached tests rejecting comments once a paste is full.
+func TestCreateComment_LimitReached(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	mockStore.CommentLimit = 1
+
+	pasteID := "d15c055ea5e01234"
+	paste := model.NewPaste()
+	paste.Data = "paste-with-discussion"
+	paste.Meta.OpenDiscussion = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	mockStore.CreateComment(ctx, pasteID, pasteID, "c0mme0t000000001", &model.Comment{Data: "first"})
+
+	reqBody := map[string]interface{}{
+		"v":        2,
+		"pasteid":  pasteID,
+		"parentid": pasteID,
+		"data":     "encrypted-comment",
+	}
+	body, _ := json.Marshal(reqBody)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	h.handlePost(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "Comment limit") {
+		t.Errorf("expected comment limit error, got %s", rr.Body.String())
+	}
+	if mockStore.GetCommentCount(pasteID) != 1 {
+		t.Errorf("expected 1 comment, got %d", mockStore.GetCommentCount(pasteID))
+	}
+}
+
 // TestCreateComment_DiscussionDisabled tests rejecting comments when globally disabled.
 func TestCreateComment_DiscussionDisabled(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Disable discussion globally
@@ -520,7 +772,7 @@ func TestCreateComment_DiscussionDisabled(t *testing.T) {
 	paste := model.NewPaste()
 	paste.Data = "paste-data"
 	paste.Meta.OpenDiscussion = true
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Try to create a comment
 	reqBody := map[string]interface{}{
@@ -541,6 +793,7 @@ func TestCreateComment_DiscussionDisabled(t *testing.T) {
 
 // TestCreateComment_PasteDiscussionDisabled tests rejecting comments on pastes without discussion.
 func TestCreateComment_PasteDiscussionDisabled(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a paste WITHOUT discussion enabled
@@ -548,7 +801,7 @@ func TestCreateComment_PasteDiscussionDisabled(t *testing.T) {
 	paste := model.NewPaste()
 	paste.Data = "no-discussion-paste"
 	paste.Meta.OpenDiscussion = false
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Try to create a comment
 	reqBody := map[string]interface{}{
@@ -569,6 +822,7 @@ func TestCreateComment_PasteDiscussionDisabled(t *testing.T) {
 
 // TestCreateComment_BurnAfterReadingPaste tests rejecting comments on burn-after-reading pastes.
 func TestCreateComment_BurnAfterReadingPaste(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a burn-after-reading paste
@@ -577,7 +831,7 @@ func TestCreateComment_BurnAfterReadingPaste(t *testing.T) {
 	paste.Data = "burn-paste"
 	paste.Meta.OpenDiscussion = true
 	paste.Meta.BurnAfterReading = true
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Try to create a comment
 	reqBody := map[string]interface{}{
@@ -618,6 +872,7 @@ func TestCreateComment_PasteNotFound(t *testing.T) {
 
 // TestGetPaste_WithComments tests retrieving a paste with comments.
 func TestGetPaste_WithComments(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a paste with discussion enabled
@@ -626,13 +881,13 @@ func TestGetPaste_WithComments(t *testing.T) {
 	paste.Data = "paste-content"
 	paste.Meta.OpenDiscussion = true
 	paste.AData = []byte(`[["iv","salt",100000,256,128,"aes","gcm","zlib"],"plaintext",1,0]`)
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Add a comment
 	comment := model.NewComment(pasteID)
 	comment.Data = "comment-content"
 	comment.ParentID = pasteID
-	mockStore.CreateComment(pasteID, pasteID, "c0ffee1234567890", comment)
+	mockStore.CreateComment(ctx, pasteID, pasteID, "c0ffee1234567890", comment)
 
 	// Request the paste
 	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
@@ -661,6 +916,114 @@ func TestGetPaste_WithComments(t *testing.T) {
 	}
 }
 
+// TestGetPaste_CommentIcons tests that comments carry an icon rendered from
+// their vizhash in the configured style, and none with icon = "none".
+func TestGetPaste_CommentIcons(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "7777777777777778"
+	paste := model.NewPaste()
+	paste.Data = "paste-content"
+	paste.Meta.OpenDiscussion = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	comment := model.NewComment(pasteID)
+	comment.Data = "comment-content"
+	comment.Vizhash, _ = util.GenerateVizhash("192.0.2.1", h.salt)
+	mockStore.CreateComment(ctx, pasteID, pasteID, "c0ffee1234567890", comment)
+
+	for style, prefix := range map[string]string{
+		"identicon": "data:image/png;base64,",
+		"jdenticon": "data:image/svg+xml;base64,",
+		"vizhash":   "data:image/png;base64,",
+		"none":      "",
+	} {
+		h.config.Main.Icon = style
+		req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.handleGet(rr, req)
+
+		var response struct {
+			Comments []struct {
+				Meta map[string]interface{} `json:"meta"`
+			} `json:"comments"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil || len(response.Comments) != 1 {
+			t.Fatalf("%s: expected 1 comment, got %s", style, rr.Body.String())
+		}
+		icon, _ := response.Comments[0].Meta["icon"].(string)
+		if prefix == "" && icon != "" || !strings.HasPrefix(icon, prefix) {
+			t.Errorf("%s: expected an icon starting %q, got %.40q", style, prefix, icon)
+		}
+	}
+}
+
+// TestDeleteComment_AuthorToken tests that a comment's author can delete it
+// with the token from the creation response, and nobody else can.
+func TestDeleteComment_AuthorToken(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "d15c055ea5e05678"
+	paste := model.NewPaste()
+	paste.Data = "paste-with-discussion"
+	paste.Meta.OpenDiscussion = true
+	paste.Meta.Salt = "cGFzdGUtc2FsdC0xMjM0NQ=="
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	body, _ := json.Marshal(map[string]interface{}{"v": 2, "pasteid": pasteID, "data": "encrypted-comment"})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	var created map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	commentID, _ := created["id"].(string)
+	deleteToken, _ := created["deletetoken"].(string)
+	if commentID == "" || deleteToken == "" {
+		t.Fatalf("expected comment ID and delete token, got %s", rr.Body.String())
+	}
+
+	deleteComment := func(token string) int {
+		body, _ := json.Marshal(map[string]interface{}{"pasteid": pasteID, "commentid": commentID, "deletetoken": token})
+		rr := httptest.NewRecorder()
+		h.handleDelete(rr, httptest.NewRequest(http.MethodDelete, "/", bytes.NewReader(body)))
+		return rr.Code
+	}
+
+	// Neither the paste's delete token nor a server-salt token will do
+	pasteToken, _ := util.GenerateDeleteToken(pasteID, paste.Meta.Salt)
+	if code := deleteComment(pasteToken); code != http.StatusForbidden {
+		t.Errorf("expected status %d for paste token, got %d", http.StatusForbidden, code)
+	}
+	serverToken, _ := commentDeleteToken(commentID, h.salt)
+	if code := deleteComment(serverToken); code != http.StatusForbidden {
+		t.Errorf("expected status %d for server-salt token, got %d", http.StatusForbidden, code)
+	}
+
+	if code := deleteComment(deleteToken); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	if n, _ := mockStore.CountComments(ctx, pasteID); n != 0 {
+		t.Errorf("expected deleted comment to be removed from storage, %d left", n)
+	}
+	if code := deleteComment(deleteToken); code != http.StatusNotFound {
+		t.Errorf("expected status %d for second delete, got %d", http.StatusNotFound, code)
+	}
+
+	// The paste survives without the comment
+	req = httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr = httptest.NewRecorder()
+	h.handleGet(rr, req)
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if comments, _ := response["comments"].([]interface{}); len(comments) != 0 {
+		t.Errorf("expected deleted comment to be hidden, got %v", comments)
+	}
+}
+
 // TestJSONError tests the JSON error response format.
 func TestJSONError(t *testing.T) {
 	h, _ := newTestHandler(t)
@@ -820,13 +1183,14 @@ func TestGetUI(t *testing.T) {
 
 // TestPostViaDeleteToken tests deletion via POST with deletetoken (PrivateBin compatibility).
 func TestPostViaDeleteToken(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a paste
 	pasteID := "8888888888888888"
 	paste := model.NewPaste()
 	paste.Data = "to-be-deleted-via-post"
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Generate valid delete token
 	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
@@ -848,7 +1212,7 @@ func TestPostViaDeleteToken(t *testing.T) {
 	}
 
 	// Verify paste was deleted
-	if mockStore.PasteExists(pasteID) {
+	if mockStore.PasteExists(ctx, pasteID) {
 		t.Error("paste should have been deleted via POST with deletetoken")
 	}
 }
@@ -958,18 +1322,50 @@ func TestGetClientIP(t *testing.T) {
 	}
 }
 
-// TestCheckRateLimit tests the rate limiting logic.
-func TestCheckRateLimit(t *testing.T) {
+// TestGetClientIP_TrustedProxies tests that behind [traffic] trusted_proxies
+// the configured header is only believed from the listed proxies.
+func TestGetClientIP_TrustedProxies(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	var got string
+	handler := fpMiddleware.ProxyRealIP(proxies, "X-Client-IP")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = getClientIP(r, "X-Client-IP")
+	}))
+
+	for peer, want := range map[string]string{
+		"10.0.0.2:5000":   "198.51.100.7",
+		"192.0.2.1:5000":  "192.0.2.1",
+		"[2001:db8::1]:1": "2001:db8::1",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = peer
+		req.Header.Set("X-Client-IP", "198.51.100.7")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if got != want {
+			t.Errorf("from %s: getClientIP() = %q, want %q", peer, got, want)
+		}
+	}
+}
+
+// allowPaste applies the paste creation limit to a request from addr.
+func allowPaste(h *Handler, addr string) (*httptest.ResponseRecorder, bool) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = addr
+	rr := httptest.NewRecorder()
+	return rr, h.allowRequest(rr, req, limitPaste)
+}
+
+// TestAllowRequest tests the rate limiting logic.
+func TestAllowRequest(t *testing.T) {
 	t.Run("rate limiting disabled", func(t *testing.T) {
 		h, _ := newTestHandler(t)
 		h.config.Traffic.Limit = 0 // Disabled
 
-		req := httptest.NewRequest(http.MethodPost, "/", nil)
-		req.RemoteAddr = "192.168.1.1:1234"
-
-		err := h.checkRateLimit(req)
-		if err != nil {
-			t.Errorf("expected no error when rate limiting disabled, got %v", err)
+		rr, allowed := allowPaste(h, "192.168.1.1:1234")
+		if !allowed {
+			t.Error("expected request to be allowed when rate limiting disabled")
+		}
+		if got := rr.Header().Get(RateLimitLimitHeader); got != "" {
+			t.Errorf("expected no %s header, got %q", RateLimitLimitHeader, got)
 		}
 	})
 
@@ -977,12 +1373,15 @@ func TestCheckRateLimit(t *testing.T) {
 		h, _ := newTestHandler(t)
 		h.config.Traffic.Limit = 10 // 10 seconds between requests
 
-		req := httptest.NewRequest(http.MethodPost, "/", nil)
-		req.RemoteAddr = "192.168.1.2:1234"
-
-		err := h.checkRateLimit(req)
-		if err != nil {
-			t.Errorf("expected first request to be allowed, got %v", err)
+		rr, allowed := allowPaste(h, "192.168.1.2:1234")
+		if !allowed {
+			t.Errorf("expected first request to be allowed, got status %d", rr.Code)
+		}
+		if got := rr.Header().Get(RateLimitLimitHeader); got != "1" {
+			t.Errorf("expected %s 1, got %q", RateLimitLimitHeader, got)
+		}
+		if got := rr.Header().Get(RateLimitRemainingHeader); got != "0" {
+			t.Errorf("expected %s 0, got %q", RateLimitRemainingHeader, got)
 		}
 	})
 
@@ -990,19 +1389,26 @@ func TestCheckRateLimit(t *testing.T) {
 		h, _ := newTestHandler(t)
 		h.config.Traffic.Limit = 300 // 5 minutes between requests
 
-		req := httptest.NewRequest(http.MethodPost, "/", nil)
-		req.RemoteAddr = "192.168.1.3:1234"
-
-		// First request
-		err := h.checkRateLimit(req)
-		if err != nil {
-			t.Errorf("first request should be allowed, got %v", err)
+		if _, allowed := allowPaste(h, "192.168.1.3:1234"); !allowed {
+			t.Fatal("first request should be allowed")
 		}
 
 		// Second immediate request should be rate limited
-		err = h.checkRateLimit(req)
-		if err != model.ErrRateLimited {
-			t.Errorf("expected ErrRateLimited, got %v", err)
+		rr, allowed := allowPaste(h, "192.168.1.3:1234")
+		if allowed {
+			t.Fatal("expected second request to be rate limited")
+		}
+		if rr.Code != http.StatusTooManyRequests {
+			t.Errorf("expected status %d, got %d", http.StatusTooManyRequests, rr.Code)
+		}
+		if got := rr.Header().Get("Retry-After"); got != "300" {
+			t.Errorf("expected Retry-After 300, got %q", got)
+		}
+		if got := rr.Header().Get(RateLimitRemainingHeader); got != "0" {
+			t.Errorf("expected %s 0, got %q", RateLimitRemainingHeader, got)
+		}
+		if got := rr.Header().Get(RateLimitResetHeader); got != "300" {
+			t.Errorf("expected %s 300, got %q", RateLimitResetHeader, got)
 		}
 	})
 
@@ -1011,18 +1417,11 @@ func TestCheckRateLimit(t *testing.T) {
 		h.config.Traffic.Limit = 10
 		h.config.Traffic.Exempted = []string{"192.168.1.4"}
 
-		req := httptest.NewRequest(http.MethodPost, "/", nil)
-		req.RemoteAddr = "192.168.1.4:1234"
-
 		// Should always be allowed
-		err := h.checkRateLimit(req)
-		if err != nil {
-			t.Errorf("exempted IP should be allowed, got %v", err)
-		}
-
-		err = h.checkRateLimit(req)
-		if err != nil {
-			t.Errorf("exempted IP should always be allowed, got %v", err)
+		for i := 0; i < 2; i++ {
+			if rr, allowed := allowPaste(h, "192.168.1.4:1234"); !allowed {
+				t.Errorf("request %d: exempted IP should be allowed, got status %d", i, rr.Code)
+			}
 		}
 	})
 }
@@ -1364,6 +1763,79 @@ func TestStaticFileNotFound(t *testing.T) {
 	}
 }
 
+// TestStaticFileFingerprinting tests that templates reference hashed asset URLs
+// and that those URLs are served with immutable cache headers.
+func TestStaticFileFingerprinting(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initStaticFS()
+	h.initTemplates()
+
+	router := h.Routes()
+
+	// The page must reference the fingerprinted script, not the plain path
+	hashedJS := h.assets.URL("js/flashpaper.js")
+	if hashedJS == "/js/flashpaper.js" {
+		t.Fatal("expected fingerprinted URL for js/flashpaper.js")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), `src="`+hashedJS+`"`) {
+		t.Errorf("expected page to reference %s", hashedJS)
+	}
+
+	// Fingerprinted asset is cacheable forever
+	req = httptest.NewRequest(http.MethodGet, hashedJS, nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d for %s, got %d", http.StatusOK, hashedJS, rr.Code)
+	}
+	if cc := rr.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("expected immutable Cache-Control, got %q", cc)
+	}
+}
+
+// TestCacheHeaders_BehindSecurityHeaders tests that assets override the
+// security middleware's no-store while pages and API responses keep it.
+func TestCacheHeaders_BehindSecurityHeaders(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initStaticFS()
+	h.initTemplates()
+	router := fpMiddleware.SecurityHeaders(h.config)(h.Routes())
+
+	tests := []struct {
+		name         string
+		path         string
+		accept       string
+		cacheControl string
+		pragma       string
+	}{
+		{"fingerprinted asset", h.assets.URL("css/style.css"), "", "public, max-age=31536000, immutable", ""},
+		{"plain asset", "/css/style.css", "", "no-cache", ""},
+		{"page", "/", "text/html", "no-store, no-cache, must-revalidate", "no-cache"},
+		{"API", "/?0123456789abcdef", "application/json", "no-store, no-cache, must-revalidate", "no-cache"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if cc := rr.Header().Get("Cache-Control"); cc != tt.cacheControl {
+			t.Errorf("%s: expected Cache-Control %q, got %q", tt.name, tt.cacheControl, cc)
+		}
+		if p := rr.Header().Get("Pragma"); p != tt.pragma {
+			t.Errorf("%s: expected Pragma %q, got %q", tt.name, tt.pragma, p)
+		}
+	}
+}
+
 // TestHandlerNew tests the New constructor function.
 func TestHandlerNew(t *testing.T) {
 	cfg := &config.Config{
@@ -1407,13 +1879,14 @@ func TestHandlerNew(t *testing.T) {
 
 // TestHandleDeleteMethod tests the DELETE HTTP method handler.
 func TestHandleDeleteMethod(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	// Create a paste
 	pasteID := "de1e7eaabbccdd00"
 	paste := model.NewPaste()
 	paste.Data = "to-be-deleted"
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Generate valid delete token
 	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
@@ -1435,7 +1908,7 @@ func TestHandleDeleteMethod(t *testing.T) {
 	}
 
 	// Verify paste was deleted
-	if mockStore.PasteExists(pasteID) {
+	if mockStore.PasteExists(ctx, pasteID) {
 		t.Error("paste should have been deleted")
 	}
 }
@@ -1457,6 +1930,7 @@ func TestHandleDeleteInvalidJSON(t *testing.T) {
 
 // TestServeUI_WithPasteID tests serving UI when paste ID is in query.
 func TestServeUI_WithPasteID(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 	h.initTemplates()
 
@@ -1464,7 +1938,7 @@ func TestServeUI_WithPasteID(t *testing.T) {
 	pasteID := "abcd1234abcd1234"
 	paste := model.NewPaste()
 	paste.Data = "test-content"
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Request with paste ID but no JSON header (should serve UI)
 	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
@@ -1483,6 +1957,36 @@ func TestServeUI_WithPasteID(t *testing.T) {
 	}
 }
 
+// TestServeUI_CSPNonce tests that inline scripts and styles carry the
+// nonce the security middleware put in the policy.
+func TestServeUI_CSPNonce(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initTemplates()
+	cfg := config.DefaultConfig()
+	cfg.Security.CSPNonce = true
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	fpMiddleware.SecurityHeaders(cfg)(http.HandlerFunc(h.serveUI)).ServeHTTP(rr, req)
+
+	csp := rr.Header().Get("Content-Security-Policy")
+	start := strings.Index(csp, "'nonce-")
+	if start < 0 {
+		t.Fatalf("expected a nonce in %q", csp)
+	}
+	nonce := csp[start+len("'nonce-"):]
+	nonce = nonce[:strings.Index(nonce, "'")]
+
+	body := rr.Body.String()
+	inline := strings.Count(body, "<script>") + strings.Count(body, "<style>")
+	if inline != 0 || !strings.Contains(body, `<style nonce="`+nonce+`">`) || !strings.Contains(body, `<script nonce="`+nonce+`">`) {
+		t.Errorf("expected every inline script and style to carry nonce %q", nonce)
+	}
+	if strings.Contains(body, "onload=") {
+		t.Error("expected no inline event handlers")
+	}
+}
+
 // TestServeUI_Fallback tests UI fallback when template is nil.
 func TestServeUI_Fallback(t *testing.T) {
 	h, _ := newTestHandler(t)
@@ -1508,13 +2012,14 @@ func TestServeUI_Fallback(t *testing.T) {
 
 // TestGetPaste_WithQueryParams tests paste ID extraction with extra query params.
 func TestGetPaste_WithQueryParams(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	pasteID := "aaaa1111bbbb2222"
 	paste := model.NewPaste()
 	paste.Data = "test-data"
 	paste.AData = []byte(`[["iv","salt",100000,256,128,"aes","gcm","zlib"],"plaintext",0,0]`)
-	mockStore.CreatePaste(pasteID, paste)
+	mockStore.CreatePaste(ctx, pasteID, paste)
 
 	// Request with extra query parameters after paste ID
 	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID+"&extra=param", nil)
@@ -1537,6 +2042,7 @@ func TestGetPaste_WithQueryParams(t *testing.T) {
 
 // TestCreatePaste_WithBurnAfterReading tests creating a burn-after-reading paste.
 func TestCreatePaste_WithBurnAfterReading(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	reqBody := map[string]interface{}{
@@ -1570,7 +2076,7 @@ func TestCreatePaste_WithBurnAfterReading(t *testing.T) {
 	pasteID := response["id"].(string)
 
 	// Verify burn flag was set
-	paste, _ := mockStore.ReadPaste(pasteID)
+	paste, _ := mockStore.ReadPaste(ctx, pasteID)
 	if !paste.Meta.BurnAfterReading {
 		t.Error("burn after reading flag should be set")
 	}
@@ -1578,6 +2084,7 @@ func TestCreatePaste_WithBurnAfterReading(t *testing.T) {
 
 // TestCreatePaste_WithOpenDiscussion tests creating a paste with open discussion.
 func TestCreatePaste_WithOpenDiscussion(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	reqBody := map[string]interface{}{
@@ -1611,7 +2118,7 @@ func TestCreatePaste_WithOpenDiscussion(t *testing.T) {
 	pasteID := response["id"].(string)
 
 	// Verify discussion flag was set
-	paste, _ := mockStore.ReadPaste(pasteID)
+	paste, _ := mockStore.ReadPaste(ctx, pasteID)
 	if !paste.Meta.OpenDiscussion {
 		t.Error("open discussion flag should be set")
 	}
@@ -1619,6 +2126,7 @@ func TestCreatePaste_WithOpenDiscussion(t *testing.T) {
 
 // TestCreatePaste_NeverExpire tests creating a paste that never expires.
 func TestCreatePaste_NeverExpire(t *testing.T) {
+	ctx := context.Background()
 	h, mockStore := newTestHandler(t)
 
 	reqBody := map[string]interface{}{
@@ -1652,8 +2160,44 @@ func TestCreatePaste_NeverExpire(t *testing.T) {
 	pasteID := response["id"].(string)
 
 	// Verify no expiration (ExpireDate = 0 means never expires)
-	paste, _ := mockStore.ReadPaste(pasteID)
+	paste, _ := mockStore.ReadPaste(ctx, pasteID)
 	if paste.Meta.ExpireDate != 0 {
 		t.Errorf("expected no expiration (0), got %d", paste.Meta.ExpireDate)
 	}
 }
+
+// TestWithTimeout tests the per-route timeout middleware.
+func TestWithTimeout(t *testing.T) {
+	deadlineAfter := func(seconds int) (time.Duration, bool) {
+		var remaining time.Duration
+		var ok bool
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var deadline time.Time
+			deadline, ok = r.Context().Deadline()
+			remaining = time.Until(deadline)
+		})
+		withTimeout(seconds)(next).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		return remaining, ok
+	}
+
+	if remaining, ok := deadlineAfter(2); !ok || remaining > 2*time.Second || remaining < time.Second {
+		t.Errorf("expected ~2s deadline, got %v (set: %v)", remaining, ok)
+	}
+	if _, ok := deadlineAfter(0); ok {
+		t.Error("expected no deadline for a zero timeout")
+	}
+}
+
+// TestRoutes_HealthTimeout tests that routes get their configured timeout.
+func TestRoutes_HealthTimeout(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Server = config.ServerConfig{Timeout: 60, HealthTimeout: 2, CreateTimeout: 120, ReadTimeout: 30}
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+}
@@ -0,0 +1,82 @@
+// Package handler provides the liveness and readiness probes.
+// /healthz (and /health, kept for existing monitors) only shows that the
+// process serves requests, so an orchestrator restarts it when it doesn't.
+// /readyz checks what serving needs: the storage backend must answer a
+// ping, and the UI templates and static files must have loaded. An
+// instance failing it should be taken out of the load balancer, not
+// restarted, since a restart won't bring the database back.
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// healthCheck handles GET /healthz and /health, reporting that the
+// process is alive.
+func (h *Handler) healthCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// readiness is the /readyz response. Errors lists the failed checks'
+// errors, as before checks were reported one by one.
+type readiness struct {
+	Status string                    `json:"status"`
+	Checks map[string]readinessCheck `json:"checks"`
+	Errors []string                  `json:"errors,omitempty"`
+}
+
+// readinessCheck is the status of one dependency in /readyz.
+type readinessCheck struct {
+	Status string `json:"status"` // "ok" or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// readyCheck handles GET /readyz, reporting whether the instance is fit to
+// take traffic, with the status of each dependency. Without storage
+// nothing works and the status is "unavailable"; without templates the
+// API still works, but a load balancer should prefer instances that
+// aren't serving the fallback page, so the status is "degraded". Both are
+// answered with 503.
+func (h *Handler) readyCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := "ok"
+	checks := map[string]readinessCheck{}
+	var problems []string
+
+	// The backend's error may name hosts, so it only goes to the log
+	if err := h.store.Ping(r.Context()); err != nil {
+		slog.Warn("Storage not ready", "error", err)
+		checks["storage"] = readinessCheck{Status: "error", Error: "storage unreachable"}
+		problems = append(problems, "storage unreachable")
+		status = "unavailable"
+	} else {
+		checks["storage"] = readinessCheck{Status: "ok"}
+	}
+
+	h.templateMu.RLock()
+	for _, dep := range []struct {
+		name string
+		err  error
+	}{{"static", h.staticErr}, {"templates", h.templateErr}} {
+		name, err := dep.name, dep.err
+		if err != nil {
+			checks[name] = readinessCheck{Status: "error", Error: err.Error()}
+			problems = append(problems, err.Error())
+			if status == "ok" {
+				status = "degraded"
+			}
+		} else {
+			checks[name] = readinessCheck{Status: "ok"}
+		}
+	}
+	h.templateMu.RUnlock()
+
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(readiness{Status: status, Checks: checks, Errors: problems})
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHealthz tests that liveness doesn't depend on storage.
+func TestHealthz(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	mockStore.PingErr = errors.New("connection refused")
+
+	for _, path := range []string{"/healthz", "/health"} {
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", path, http.StatusOK, rr.Code)
+		}
+	}
+}
+
+// TestReadyz_Storage tests that an unreachable backend fails readiness.
+func TestReadyz_Storage(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	h.initTemplates()
+	mockStore.PingErr = errors.New("dial tcp 10.0.0.5:5432: connection refused")
+
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), "10.0.0.5") {
+		t.Errorf("backend error leaked: %s", rr.Body.String())
+	}
+
+	var resp readiness
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Status != "unavailable" {
+		t.Errorf("expected status unavailable, got %q", resp.Status)
+	}
+	if resp.Checks["storage"].Status != "error" {
+		t.Errorf("expected storage check to fail, got %+v", resp.Checks["storage"])
+	}
+	if resp.Checks["templates"].Status != "ok" || resp.Checks["static"].Status != "ok" {
+		t.Errorf("expected UI checks to pass, got %+v", resp.Checks)
+	}
+
+	// Recovers once the backend answers again
+	mockStore.PingErr = nil
+	rr = httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,80 @@
+// Package handler provides the languages of the web UI. Each page is
+// rendered in the visitor's language: the one picked in the UI when
+// [main] languageselection is on, otherwise the first available one the
+// browser's Accept-Language asks for, otherwise [main] languagedefault.
+// The page's own text is translated by the templates; the script fetches
+// the same catalog from /i18n/{lang}.json for the messages it shows.
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	flashpaper "github.com/liskl/flashpaper"
+	"github.com/liskl/flashpaper/internal/i18n"
+)
+
+// langCookie holds the language picked in the UI, named as in PrivateBin.
+const langCookie = "lang"
+
+// initI18n loads the embedded translation catalogs. If they can't be
+// loaded, the UI is served in English.
+func (h *Handler) initI18n() {
+	catalogs, err := flashpaper.I18nFS()
+	if err == nil {
+		h.i18n, err = i18n.Load(catalogs)
+	}
+	if err != nil {
+		slog.Error("Translations not loaded; serving the UI in English", "error", err)
+		return
+	}
+	if lang := strings.ToLower(h.config.Main.LanguageDefault); !h.i18n.Has(lang) {
+		slog.Warn("No translation for the default language; using English", "languagedefault", lang)
+	}
+}
+
+// locale returns the language to render r's page in.
+func (h *Handler) locale(r *http.Request) *i18n.Locale {
+	if h.config.Main.LanguageSelection {
+		if c, err := r.Cookie(langCookie); err == nil && h.i18n.Has(strings.ToLower(c.Value)) {
+			return h.i18n.Locale(strings.ToLower(c.Value))
+		}
+	}
+	fallback := strings.ToLower(h.config.Main.LanguageDefault)
+	return h.i18n.Locale(h.i18n.Match(r.Header.Get("Accept-Language"), fallback))
+}
+
+// languages returns the choices of the UI's language picker, or nil if
+// [main] languageselection is off.
+func (h *Handler) languages() []i18n.Language {
+	if !h.config.Main.LanguageSelection {
+		return nil
+	}
+	return h.i18n.Languages()
+}
+
+// setLanguageHeaders describes a page rendered in lang to the client and
+// to caches, which must keep the languages apart.
+func (h *Handler) setLanguageHeaders(w http.ResponseWriter, lang *i18n.Locale) {
+	w.Header().Set("Content-Language", lang.Code)
+	w.Header().Add("Vary", "Accept-Language")
+	if h.config.Main.LanguageSelection {
+		w.Header().Add("Vary", "Cookie")
+	}
+}
+
+// serveI18n handles GET /i18n/{lang}.json with the language's catalog in
+// PrivateBin's format. English, the source language, has an empty one.
+func (h *Handler) serveI18n(w http.ResponseWriter, r *http.Request) {
+	data, ok := h.i18n.CatalogJSON(strings.ToLower(chi.URLParam(r, "lang")))
+	if !ok {
+		h.jsonError(w, "Unknown language", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(data)
+}
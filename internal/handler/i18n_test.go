@@ -0,0 +1,188 @@
+// Package handler provides tests for UI languages.
+package handler
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	flashpaper "github.com/liskl/flashpaper"
+	"github.com/liskl/flashpaper/internal/i18n"
+)
+
+// serveLanguage requests the main page with the given Accept-Language and
+// lang cookie.
+func serveLanguage(h *Handler, acceptLanguage, cookie string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if acceptLanguage != "" {
+		req.Header.Set("Accept-Language", acceptLanguage)
+	}
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: langCookie, Value: cookie})
+	}
+	rr := httptest.NewRecorder()
+	h.serveUI(rr, req)
+	return rr
+}
+
+// TestServeUI_Language tests Accept-Language negotiation and the fallback.
+func TestServeUI_Language(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Main.LanguageDefault = "en"
+	h.initI18n()
+	h.initStaticFS()
+	h.initTemplates()
+
+	rr := serveLanguage(h, "de-DE,de;q=0.9,en;q=0.5", "")
+	body := rr.Body.String()
+	if !strings.Contains(body, `<html lang="de"`) || !strings.Contains(body, ">Senden</button>") {
+		t.Error("expected the page in German")
+	}
+	if got := rr.Header().Get("Content-Language"); got != "de" {
+		t.Errorf("expected Content-Language de, got %q", got)
+	}
+	if got := rr.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept-Language" {
+		t.Errorf("expected Vary: Accept-Language, got %v", got)
+	}
+	if strings.Contains(body, `id="language"`) {
+		t.Error("expected no language picker without languageselection")
+	}
+
+	// Unavailable languages fall back to languagedefault
+	h.config.Main.LanguageDefault = "fr"
+	body = serveLanguage(h, "ja", "").Body.String()
+	if !strings.Contains(body, `<html lang="fr"`) || !strings.Contains(body, ">Envoyer</button>") {
+		t.Error("expected the page in the default language")
+	}
+
+	// The cookie only counts with the picker on
+	h.config.Main.LanguageDefault = "en"
+	if body := serveLanguage(h, "", "es").Body.String(); !strings.Contains(body, `<html lang="en"`) {
+		t.Error("expected the lang cookie to be ignored without languageselection")
+	}
+}
+
+// TestServeUI_LanguageSelection tests the picker and the language it sets.
+func TestServeUI_LanguageSelection(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Main.LanguageSelection = true
+	h.config.Main.LanguageDefault = "en"
+	h.initI18n()
+	h.initStaticFS()
+	h.initTemplates()
+
+	rr := serveLanguage(h, "de", "es")
+	body := rr.Body.String()
+	if !strings.Contains(body, `<html lang="es"`) || !strings.Contains(body, ">Enviar</button>") {
+		t.Error("expected the picked language to win over Accept-Language")
+	}
+	for _, want := range []string{
+		`<select id="language" aria-label="Idioma">`,
+		`<option value="es" selected>Español</option>`,
+		`<option value="de">Deutsch</option>`,
+		`<option value="en">English</option>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in the page", want)
+		}
+	}
+	if got := rr.Header().Values("Vary"); len(got) != 2 || got[1] != "Cookie" {
+		t.Errorf("expected Vary to include Cookie, got %v", got)
+	}
+
+	// An unknown cookie value is ignored
+	if body := serveLanguage(h, "de", "xx").Body.String(); !strings.Contains(body, `<html lang="de"`) {
+		t.Error("expected Accept-Language after an unknown picked language")
+	}
+}
+
+// TestServeI18n tests the catalog endpoint.
+func TestServeI18n(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initI18n()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := get("/i18n/de.json")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var catalog map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &catalog); err != nil {
+		t.Fatalf("expected a JSON catalog: %v", err)
+	}
+	if catalog["Send"] != "Senden" {
+		t.Errorf("expected the German catalog, got %v", catalog["Send"])
+	}
+
+	if rr := get("/i18n/en.json"); rr.Code != http.StatusOK || rr.Body.String() != "{}" {
+		t.Errorf("expected an empty English catalog, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rr := get("/i18n/xx.json"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown language, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestCatalogs_Complete tests that the shipped catalogs translate every
+// message of the main page and its script.
+func TestCatalogs_Complete(t *testing.T) {
+	templates, err := flashpaper.TemplateFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	static, err := flashpaper.StaticFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := fs.ReadFile(templates, "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := fs.ReadFile(static, "js/flashpaper.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, m := range regexp.MustCompile(`Lang\.T "([^"]+)"`).FindAllStringSubmatch(string(page), -1) {
+		messages = append(messages, m[1])
+	}
+	for _, m := range regexp.MustCompile(`\bt\('([^']+)'`).FindAllStringSubmatch(string(script), -1) {
+		messages = append(messages, m[1])
+	}
+	if len(messages) < 40 {
+		t.Fatalf("found only %d messages; has the markup changed?", len(messages))
+	}
+
+	catalogs, err := flashpaper.I18nFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := i18n.Load(catalogs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lang := range bundle.Languages() {
+		if lang.Code == i18n.Source {
+			continue
+		}
+		data, _ := bundle.CatalogJSON(lang.Code)
+		var catalog map[string]interface{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range messages {
+			if _, ok := catalog[m]; !ok {
+				t.Errorf("%s: no translation of %q", lang.Code, m)
+			}
+		}
+	}
+}
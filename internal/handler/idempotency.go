@@ -0,0 +1,148 @@
+// Package handler provides idempotent paste creation.
+// Flaky mobile connections and automated pipelines retry requests whose
+// response never arrived. A client that sends an Idempotency-Key header with
+// a create request gets the original paste ID and delete token back on retry,
+// instead of a second copy of the paste.
+//
+// Records are kept in key-value storage under a hash of the key, together
+// with a fingerprint of the request, for [traffic] idempotency_ttl seconds.
+// They hold the paste ID only; a replay derives the tokens from the paste
+// again, so no live token is stored outside it.
+// Reusing a key for a different request is rejected, and a retry that arrives
+// while the original is still being processed gets 409 Conflict.
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/storage"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// IdempotencyHeader is the request header carrying the client's key.
+const IdempotencyHeader = "Idempotency-Key"
+
+// Idempotency key length bounds. Keys should be random (e.g. UUIDs): anyone
+// who knows a key and the request can retrieve the delete token of the
+// paste it created.
+const (
+	MinIdempotencyKeyLength = 16
+	MaxIdempotencyKeyLength = 255
+)
+
+// idempotencyRecord is the stored outcome of a keyed create request.
+type idempotencyRecord struct {
+	PasteID     string `json:"id"`
+	Fingerprint string `json:"fingerprint"` // Hash of the request body
+	Created     int64  `json:"created"`     // Unix timestamp
+}
+
+// idempotentRequest tracks a keyed create request being processed.
+type idempotentRequest struct {
+	key         string // Storage key (hash of the client's key)
+	fingerprint string
+}
+
+// idempotencyStorageKey hashes a client's key for use as a storage key.
+// Raw keys may contain characters unsafe in filesystem storage paths.
+func idempotencyStorageKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// beginIdempotent handles the Idempotency-Key header of a create request.
+// It returns done == true if the response has already been written: a replay
+// of the original result, or an error. Otherwise it returns the request to
+// pass to finishIdempotent, or nil if the request isn't keyed. The caller
+// must call finishIdempotent either way.
+func (h *Handler) beginIdempotent(w http.ResponseWriter, r *http.Request, req map[string]interface{}) (ir *idempotentRequest, done bool) {
+	ctx := r.Context()
+	ttl := h.config.Traffic.IdempotencyTTL
+	header := r.Header.Get(IdempotencyHeader)
+	if ttl <= 0 || header == "" {
+		return nil, false
+	}
+	if len(header) < MinIdempotencyKeyLength || len(header) > MaxIdempotencyKeyLength {
+		h.jsonError(w, "Invalid idempotency key", http.StatusBadRequest)
+		return nil, true
+	}
+
+	// Map keys marshal in sorted order, so equal requests hash equally
+	body, _ := json.Marshal(req)
+	bodySum := sha256.Sum256(body)
+	ir = &idempotentRequest{
+		key:         idempotencyStorageKey(header),
+		fingerprint: hex.EncodeToString(bodySum[:]),
+	}
+
+	// Concurrent retries must not both create a paste
+	h.idempotencyMu.Lock()
+	if h.idempotencyInFlight[ir.key] {
+		h.idempotencyMu.Unlock()
+		h.jsonError(w, "A request with this idempotency key is in progress", http.StatusConflict)
+		return nil, true
+	}
+	if h.idempotencyInFlight == nil {
+		h.idempotencyInFlight = map[string]bool{}
+	}
+	h.idempotencyInFlight[ir.key] = true
+	h.idempotencyMu.Unlock()
+
+	value, err := h.store.GetValue(ctx, storage.NamespaceIdempotency, ir.key)
+	if err != nil || value == "" {
+		return ir, false
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil || h.clock.Now().Unix()-record.Created > int64(ttl) {
+		return ir, false // Unreadable or expired; handle as a new request
+	}
+
+	if record.Fingerprint != ir.fingerprint {
+		h.finishIdempotent(ctx, ir, "")
+		h.jsonError(w, "Idempotency key was already used for a different request", http.StatusUnprocessableEntity)
+		return nil, true
+	}
+
+	h.finishIdempotent(ctx, ir, "")
+	// The tokens derive from the paste's salt, which goes with the paste
+	paste, err := h.store.ReadPaste(ctx, record.PasteID)
+	if err != nil {
+		h.jsonError(w, "Paste not found", http.StatusNotFound)
+		return nil, true
+	}
+	deleteToken, _ := util.GenerateDeleteToken(record.PasteID, h.deleteTokenSalt(paste))
+	var editToken string
+	if h.config.Main.Editable {
+		editToken, _ = pasteEditToken(record.PasteID, h.deleteTokenSalt(paste))
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	h.jsonSuccess(w, h.createdResponse(r, record.PasteID, deleteToken, editToken))
+	return nil, true
+}
+
+// finishIdempotent records the paste created for a keyed request, if any,
+// and releases the key for retries. A nil request is ignored.
+func (h *Handler) finishIdempotent(ctx context.Context, ir *idempotentRequest, pasteID string) {
+	if ir == nil {
+		return
+	}
+
+	if pasteID != "" {
+		value, _ := json.Marshal(idempotencyRecord{
+			PasteID:     pasteID,
+			Fingerprint: ir.fingerprint,
+			Created:     h.clock.Now().Unix(),
+		})
+		// Best effort: the paste exists either way, a retry would just
+		// create another one
+		h.store.SetValue(ctx, storage.NamespaceIdempotency, ir.key, string(value))
+	}
+
+	h.idempotencyMu.Lock()
+	delete(h.idempotencyInFlight, ir.key)
+	h.idempotencyMu.Unlock()
+}
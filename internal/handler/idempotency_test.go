@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+const testIdempotencyKey = "5f0c8a6e-1b7d-4e2a-9c3f-0a1b2c3d4e5f"
+
+// createIdempotent posts a paste with the given Idempotency-Key.
+func createIdempotent(h *Handler, key, ct string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    ct,
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyHeader, key)
+	}
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	return rr, response
+}
+
+// TestIdempotency_Replay tests that a retry returns the original paste.
+func TestIdempotency_Replay(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	h.config.Traffic.IdempotencyTTL = 3600
+
+	rr, first := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	rr, retry := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d on retry, got %d", http.StatusOK, rr.Code)
+	}
+	if retry["id"] != first["id"] || retry["deletetoken"] != first["deletetoken"] {
+		t.Errorf("expected original paste %v, got %v", first, retry)
+	}
+	if rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header")
+	}
+	if n := mockStore.GetPasteCount(); n != 1 {
+		t.Errorf("expected 1 stored paste, got %d", n)
+	}
+
+	// A different key creates a new paste
+	_, other := createIdempotent(h, testIdempotencyKey+"-2", "encrypted-content")
+	if other["id"] == first["id"] {
+		t.Error("expected a new paste for a different key")
+	}
+}
+
+// TestIdempotency_DifferentRequest tests rejecting a reused key.
+func TestIdempotency_DifferentRequest(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Traffic.IdempotencyTTL = 3600
+
+	createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	rr, _ := createIdempotent(h, testIdempotencyKey, "other-content")
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+}
+
+// TestIdempotency_InFlight tests that a concurrent retry is turned away.
+func TestIdempotency_InFlight(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Traffic.IdempotencyTTL = 3600
+
+	// Simulate the original request still being processed
+	_, first := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	h.idempotencyMu.Lock()
+	h.idempotencyInFlight[idempotencyStorageKey(testIdempotencyKey)] = true
+	h.idempotencyMu.Unlock()
+
+	rr, _ := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, rr.Code)
+	}
+
+	delete(h.idempotencyInFlight, idempotencyStorageKey(testIdempotencyKey))
+	if _, retry := createIdempotent(h, testIdempotencyKey, "encrypted-content"); retry["id"] != first["id"] {
+		t.Error("expected replay once the original finished")
+	}
+}
+
+// TestIdempotency_Disabled tests that the header is ignored without a TTL
+// and that malformed keys are rejected.
+func TestIdempotency_Disabled(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	_, first := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	_, second := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	if first["id"] == second["id"] {
+		t.Error("expected separate pastes with idempotency disabled")
+	}
+
+	h.config.Traffic.IdempotencyTTL = 3600
+	if rr, _ := createIdempotent(h, "short", "encrypted-content"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a short key, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestIdempotency_StoresNoTokens tests that records leave the tokens out,
+// and that a replay for a paste since deleted doesn't hand them out.
+func TestIdempotency_StoresNoTokens(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Traffic.IdempotencyTTL = 3600
+	h.config.Main.Editable = true
+
+	_, first := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	value, _ := mockStore.GetValue(ctx, storage.NamespaceIdempotency, idempotencyStorageKey(testIdempotencyKey))
+	for _, token := range []string{"deletetoken", "edittoken"} {
+		if first[token] == nil || strings.Contains(value, first[token].(string)) {
+			t.Errorf("expected %s issued and left out of the record %s", token, value)
+		}
+	}
+
+	// Replays derive the same tokens again
+	_, retry := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	if retry["deletetoken"] != first["deletetoken"] || retry["edittoken"] != first["edittoken"] {
+		t.Errorf("expected original tokens %v, got %v", first, retry)
+	}
+
+	if err := mockStore.DeletePaste(ctx, first["id"].(string)); err != nil {
+		t.Fatal(err)
+	}
+	rr, gone := createIdempotent(h, testIdempotencyKey, "encrypted-content")
+	if rr.Code != http.StatusNotFound || gone["deletetoken"] != nil {
+		t.Errorf("expected status %d without tokens, got %d: %v", http.StatusNotFound, rr.Code, gone)
+	}
+}
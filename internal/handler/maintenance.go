@@ -0,0 +1,146 @@
+// Package handler provides maintenance mode.
+// During storage migrations and similar work the instance shouldn't take
+// new content, but the links people already hold should keep working.
+// Maintenance mode refuses new pastes, comments, edits, and secrets with
+// 503 while reads go on; read-only mode also refuses every other request
+// that would write to storage. [maintenance] sets the mode, and operators
+// can override it at runtime through the admin API; the override is kept in
+// key-value storage until cleared.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// ErrCodeMaintenance is the error code for requests refused in maintenance.
+const ErrCodeMaintenance = "maintenance"
+
+// maintenanceKey is the key-value key holding the admin API's override.
+const maintenanceKey = "maintenance"
+
+// Default notices, for when neither config nor the admin set a message.
+const (
+	maintenanceMessage = "This instance is undergoing maintenance. " +
+		"Existing pastes can still be read, but new pastes and comments can't be created right now."
+	readOnlyMessage = "This instance is read-only for maintenance. " +
+		"Existing pastes can still be read, but nothing can be created, changed, or deleted right now."
+)
+
+// Maintenance is the instance's maintenance state.
+type Maintenance struct {
+	Enabled  bool   `json:"enabled"`
+	ReadOnly bool   `json:"readonly"`          // Refuse all writes, not only new content
+	Message  string `json:"message"`           // Notice shown to users
+	Updated  int64  `json:"updated,omitempty"` // Unix time of the admin override; 0 if from config
+}
+
+// maintenance returns the current maintenance state, or nil if the instance
+// is running normally. An admin override wins over [maintenance].
+func (h *Handler) maintenance(ctx context.Context) *Maintenance {
+	var m Maintenance
+	value, err := h.store.GetValue(ctx, storage.NamespaceAdmin, maintenanceKey)
+	if err != nil || value == "" || json.Unmarshal([]byte(value), &m) != nil {
+		cfg := h.live().Maintenance
+		m = Maintenance{Enabled: cfg.Active(), ReadOnly: cfg.ReadOnly, Message: cfg.Message}
+	}
+	if !m.Enabled {
+		return nil
+	}
+
+	if m.Message == "" {
+		m.Message = maintenanceMessage
+		if m.ReadOnly {
+			m.Message = readOnlyMessage
+		}
+	}
+	return &m
+}
+
+// inMaintenance refuses requests for new content in maintenance mode,
+// and reports whether it did.
+func (h *Handler) inMaintenance(w http.ResponseWriter, r *http.Request) bool {
+	m := h.maintenance(r.Context())
+	if m == nil {
+		return false
+	}
+	h.jsonErrorCode(w, m.Message, ErrCodeMaintenance, http.StatusServiceUnavailable)
+	return true
+}
+
+// inReadOnly refuses requests that would write to storage in read-only
+// mode, and reports whether it did.
+func (h *Handler) inReadOnly(w http.ResponseWriter, r *http.Request) bool {
+	m := h.maintenance(r.Context())
+	if m == nil || !m.ReadOnly {
+		return false
+	}
+	h.jsonErrorCode(w, m.Message, ErrCodeMaintenance, http.StatusServiceUnavailable)
+	return true
+}
+
+// getMaintenance handles GET /admin/maintenance.
+func (h *Handler) getMaintenance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	h.jsonSuccess(w, map[string]interface{}{
+		"maintenance": h.maintenance(ctx),
+	})
+}
+
+// putMaintenance handles PUT /admin/maintenance.
+// Request format:
+//
+//	{"enabled": true, "readonly": false, "message": "Back at 03:00 UTC"}
+//
+// "enabled": false turns maintenance off even if [maintenance] has it on.
+// The message defaults to a generic notice.
+func (h *Handler) putMaintenance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Enabled  bool   `json:"enabled"`
+		ReadOnly bool   `json:"readonly"`
+		Message  string `json:"message"`
+	}
+	if !h.decodeJSON(w, r.Body, &req) {
+		return
+	}
+
+	m := Maintenance{
+		Enabled:  req.Enabled || req.ReadOnly,
+		ReadOnly: req.ReadOnly,
+		Message:  strings.TrimSpace(req.Message),
+		Updated:  h.clock.Now().Unix(),
+	}
+	if utf8.RuneCountInString(m.Message) > MaxAnnouncementLength {
+		h.jsonError(w, "Message is too long", http.StatusBadRequest)
+		return
+	}
+
+	value, _ := json.Marshal(m)
+	if err := h.store.SetValue(ctx, storage.NamespaceAdmin, maintenanceKey, string(value)); err != nil {
+		h.jsonError(w, "Failed to store maintenance mode", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"maintenance": h.maintenance(ctx),
+	})
+}
+
+// deleteMaintenance handles DELETE /admin/maintenance, clearing the
+// override so [maintenance] applies again.
+func (h *Handler) deleteMaintenance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := h.store.SetValue(ctx, storage.NamespaceAdmin, maintenanceKey, ""); err != nil {
+		h.jsonError(w, "Failed to clear maintenance mode", http.StatusInternalServerError)
+		return
+	}
+	h.jsonSuccess(w, map[string]interface{}{
+		"maintenance": h.maintenance(ctx),
+	})
+}
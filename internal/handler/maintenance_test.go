@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// checkMaintenance fails the test unless rr is a maintenance refusal.
+func checkMaintenance(t *testing.T, rr *httptest.ResponseRecorder) {
+	t.Helper()
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusServiceUnavailable, rr.Code, rr.Body.String())
+	}
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response["code"] != ErrCodeMaintenance || response["message"] == "" {
+		t.Errorf("unexpected response %s", rr.Body.String())
+	}
+}
+
+// postJSON sends body to POST / as JSON.
+func postJSON(h *Handler, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr
+}
+
+// readPaste reads pasteID through the JSON API.
+func readPaste(h *Handler, pasteID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.handleGet(rr, req)
+	return rr
+}
+
+// TestMaintenance_RefusesNewContent tests that maintenance mode refuses
+// pastes and comments while reads and deletes go on.
+func TestMaintenance_RefusesNewContent(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Maintenance.Enabled = true
+	h.config.Maintenance.Message = "Migrating storage, back at 03:00 UTC"
+
+	rr, response := createIdempotent(h, "", "ciphertext")
+	checkMaintenance(t, rr)
+	if response["message"] != "Migrating storage, back at 03:00 UTC" {
+		t.Errorf("expected the configured message, got %v", response["message"])
+	}
+
+	pasteID := "d15c055ea5e01234"
+	paste := model.NewPaste()
+	paste.Data = "paste-with-discussion"
+	paste.Meta.OpenDiscussion = true
+	paste.Meta.BurnAfterReading = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	checkMaintenance(t, postJSON(h, map[string]interface{}{
+		"v":        2,
+		"pasteid":  pasteID,
+		"parentid": pasteID,
+		"data":     "encrypted-comment",
+	}))
+	if mockStore.GetCommentCount(pasteID) != 0 {
+		t.Error("expected no comment to be stored")
+	}
+
+	// Reads, burning included, still work
+	if rr := readPaste(h, pasteID); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if mockStore.PasteExists(ctx, pasteID) {
+		t.Error("expected the paste to be burned")
+	}
+
+	// And so do deletes
+	pasteID = "de1e7e0012345678"
+	mockStore.CreatePaste(ctx, pasteID, model.NewPaste())
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+	if rr := postJSON(h, map[string]interface{}{"pasteid": pasteID, "deletetoken": deleteToken}); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
+// TestMaintenance_ReadOnly tests that read-only mode refuses every write
+// but keeps plain reads working.
+func TestMaintenance_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Maintenance.ReadOnly = true
+
+	rr, response := createIdempotent(h, "", "ciphertext")
+	checkMaintenance(t, rr)
+	if response["message"] != readOnlyMessage {
+		t.Errorf("expected the read-only notice, got %v", response["message"])
+	}
+
+	pasteID := "f468483c313401e8"
+	mockStore.CreatePaste(ctx, pasteID, model.NewPaste())
+	if rr := readPaste(h, pasteID); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if receipt, _ := mockStore.GetReadReceipt(ctx, pasteID); receipt.FirstRead != 0 {
+		t.Error("expected no read receipt")
+	}
+
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+	checkMaintenance(t, postJSON(h, map[string]interface{}{"pasteid": pasteID, "deletetoken": deleteToken}))
+	if !mockStore.PasteExists(ctx, pasteID) {
+		t.Error("expected the paste to be kept")
+	}
+
+	// Burn-after-reading pastes wait until maintenance is over
+	burnID := "baf1ead123456789"
+	burn := model.NewPaste()
+	burn.Meta.BurnAfterReading = true
+	mockStore.CreatePaste(ctx, burnID, burn)
+	checkMaintenance(t, readPaste(h, burnID))
+	if !mockStore.PasteExists(ctx, burnID) {
+		t.Error("expected the paste not to be burned")
+	}
+}
+
+// TestMaintenance_Admin tests switching maintenance through the admin API.
+func TestMaintenance_Admin(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+
+	rr := adminRequest(h, http.MethodPut, "/admin/maintenance", testAdminToken, map[string]interface{}{"enabled": true})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	m := h.maintenance(ctx)
+	if m == nil || m.ReadOnly || m.Message != maintenanceMessage || m.Updated == 0 {
+		t.Fatalf("unexpected maintenance %+v", m)
+	}
+	if cfg := h.clientConfig(ctx); cfg.Maintenance == nil {
+		t.Error("expected maintenance in client config")
+	}
+	rr, _ = createIdempotent(h, "", "ciphertext")
+	checkMaintenance(t, rr)
+
+	// The override wins over [maintenance] either way
+	h.config.Maintenance.ReadOnly = true
+	adminRequest(h, http.MethodPut, "/admin/maintenance", testAdminToken, map[string]interface{}{"enabled": false})
+	if m := h.maintenance(ctx); m != nil {
+		t.Errorf("expected maintenance to be off, got %+v", m)
+	}
+
+	// Clearing it returns to the config
+	rr = adminRequest(h, http.MethodDelete, "/admin/maintenance", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if m := h.maintenance(ctx); m == nil || !m.ReadOnly || m.Updated != 0 {
+		t.Errorf("expected read-only mode from config, got %+v", m)
+	}
+
+	rr = adminRequest(h, http.MethodPut, "/admin/maintenance", testAdminToken, map[string]interface{}{
+		"enabled": true,
+		"message": strings.Repeat("x", MaxAnnouncementLength+1),
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestMaintenance_Page tests the banner and disabled buttons in the UI.
+func TestMaintenance_Page(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initStaticFS()
+	h.initTemplates()
+	h.config.Maintenance.Enabled = true
+
+	rr := httptest.NewRecorder()
+	h.serveUI(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `class="alert alert-warning maintenance"`) {
+		t.Error("expected maintenance banner in page")
+	}
+	if !strings.Contains(body, `id="create-paste" class="btn btn-primary" disabled`) {
+		t.Error("expected the send button to be disabled")
+	}
+}
@@ -0,0 +1,186 @@
+// Package handler provides per-template metadata.
+// Each UI template may ship a manifest (<template>.manifest.json in the
+// template directory) describing the stylesheets and scripts it loads,
+// which of them to preload, any Content-Security-Policy allowances it needs
+// beyond the defaults, and free-form values for the template itself.
+//
+// Example bootstrap-dark.manifest.json:
+//
+//	{
+//	  "styles": ["css/style.css", "css/dark.css"],
+//	  "scripts": ["js/flashpaper.js"],
+//	  "preload": [{"href": "css/dark.css", "as": "style"}],
+//	  "csp": {"font-src": ["https://fonts.example.com"]},
+//	  "data": {"theme": "dark"}
+//	}
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// TemplateManifest describes what a UI template needs from the server.
+type TemplateManifest struct {
+	// Name is the template name (the [main] template setting)
+	Name string `json:"-"`
+
+	// Styles are stylesheets to load, as logical static paths
+	Styles []string `json:"styles"`
+
+	// Scripts are scripts to load, in order, as logical static paths
+	Scripts []string `json:"scripts"`
+
+	// Preload lists resources to fetch early with <link rel="preload">
+	Preload []PreloadAsset `json:"preload"`
+
+	// CSP maps policy directives to sources added on top of the defaults,
+	// e.g. {"img-src": ["https://cdn.example.com"]}
+	CSP map[string][]string `json:"csp"`
+
+	// Data holds template-specific values, available as .Template.Data
+	Data map[string]string `json:"data"`
+}
+
+// PreloadAsset is a resource hint for the browser.
+type PreloadAsset struct {
+	Href string `json:"href"` // Logical static path or absolute URL
+	As   string `json:"as"`   // Destination: style, script, font, image
+}
+
+// defaultManifest is used by templates that don't ship a manifest.
+// It matches what the bundled templates have always loaded.
+func defaultManifest(name string) *TemplateManifest {
+	return &TemplateManifest{
+		Name:    name,
+		Styles:  []string{"css/style.css"},
+		Scripts: []string{"js/flashpaper.js"},
+	}
+}
+
+// cspDirectives are the directives a manifest may extend.
+// Framing, base URI, and form targets are deliberately not extensible.
+var cspDirectives = map[string]bool{
+	"default-src": true,
+	"script-src":  true,
+	"style-src":   true,
+	"img-src":     true,
+	"font-src":    true,
+	"connect-src": true,
+	"media-src":   true,
+	"worker-src":  true,
+}
+
+// preloadDestinations are the accepted PreloadAsset.As values.
+var preloadDestinations = map[string]bool{
+	"style":  true,
+	"script": true,
+	"font":   true,
+	"image":  true,
+}
+
+// loadManifest reads the manifest for the named template from fsys.
+// A missing manifest is not an error; the default manifest is returned.
+func loadManifest(fsys fs.FS, name string) (*TemplateManifest, error) {
+	data, err := fs.ReadFile(fsys, name+".manifest.json")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return defaultManifest(name), nil
+		}
+		return nil, err
+	}
+
+	manifest := defaultManifest(name)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("parsing %s manifest: %w", name, err)
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s manifest: %w", name, err)
+	}
+	return manifest, nil
+}
+
+// Validate checks that the manifest can't break or weaken the page.
+// CSP sources end up in a response header, so they must not contain
+// characters that would start a new directive or policy.
+func (m *TemplateManifest) Validate() error {
+	for directive, sources := range m.CSP {
+		if !cspDirectives[directive] {
+			return fmt.Errorf("csp directive %q is not allowed", directive)
+		}
+		for _, source := range sources {
+			if source == "" || strings.ContainsAny(source, ";,\r\n ") {
+				return fmt.Errorf("csp source %q for %s is invalid", source, directive)
+			}
+			if source == "*" || source == "'unsafe-eval'" {
+				return fmt.Errorf("csp source %s for %s is too permissive", source, directive)
+			}
+		}
+	}
+
+	for _, p := range m.Preload {
+		if p.Href == "" {
+			return fmt.Errorf("preload entry is missing href")
+		}
+		if !preloadDestinations[p.As] {
+			return fmt.Errorf("preload %q has invalid destination %q", p.Href, p.As)
+		}
+	}
+	return nil
+}
+
+// TemplateInfo is the manifest as seen by templates.
+// Asset paths are resolved to fingerprinted URLs.
+type TemplateInfo struct {
+	Name    string
+	Styles  []string
+	Scripts []string
+	Preload []PreloadAsset
+	Data    map[string]string
+}
+
+// templateInfo resolves the active manifest for rendering.
+func (h *Handler) templateInfo() TemplateInfo {
+	h.templateMu.RLock()
+	m, pipeline := h.manifest, h.assets
+	h.templateMu.RUnlock()
+	if m == nil {
+		m = defaultManifest(h.config.Main.Template)
+	}
+
+	// Absolute URLs (e.g. a CDN allowed through the manifest's CSP) pass through
+	resolve := func(href string) string {
+		if strings.Contains(href, "://") {
+			return href
+		}
+		return pipeline.URL(href)
+	}
+
+	info := TemplateInfo{
+		Name: m.Name,
+		Data: m.Data,
+	}
+	for _, s := range m.Styles {
+		info.Styles = append(info.Styles, resolve(s))
+	}
+	for _, s := range m.Scripts {
+		info.Scripts = append(info.Scripts, resolve(s))
+	}
+	for _, p := range m.Preload {
+		info.Preload = append(info.Preload, PreloadAsset{Href: resolve(p.Href), As: p.As})
+	}
+	return info
+}
+
+// CSPSources returns the extra Content-Security-Policy sources the active
+// template and the captcha widget need, keyed by directive. The server
+// passes them to the security headers middleware.
+func (h *Handler) CSPSources() map[string][]string {
+	if h.manifest == nil {
+		return h.captchaCSP(nil)
+	}
+	return h.captchaCSP(h.manifest.CSP)
+}
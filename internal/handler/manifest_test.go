@@ -0,0 +1,132 @@
+// Package handler provides tests for per-template manifests.
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	flashpaper "github.com/liskl/flashpaper"
+)
+
+// TestLoadManifest_Missing tests that templates without a manifest get the defaults.
+func TestLoadManifest_Missing(t *testing.T) {
+	m, err := loadManifest(fstest.MapFS{}, "custom")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Name != "custom" {
+		t.Errorf("expected name 'custom', got %q", m.Name)
+	}
+	if len(m.Styles) != 1 || m.Styles[0] != "css/style.css" {
+		t.Errorf("expected default styles, got %v", m.Styles)
+	}
+	if len(m.CSP) != 0 {
+		t.Errorf("expected no CSP additions, got %v", m.CSP)
+	}
+}
+
+// TestLoadManifest_Parses tests reading a manifest from the template directory.
+func TestLoadManifest_Parses(t *testing.T) {
+	fsys := fstest.MapFS{
+		"dark.manifest.json": {Data: []byte(`{
+			"styles": ["css/style.css", "css/dark.css"],
+			"preload": [{"href": "css/dark.css", "as": "style"}],
+			"csp": {"font-src": ["https://fonts.example.com"]},
+			"data": {"theme": "dark"}
+		}`)},
+	}
+
+	m, err := loadManifest(fsys, "dark")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(m.Styles, ",") != "css/style.css,css/dark.css" {
+		t.Errorf("unexpected styles %v", m.Styles)
+	}
+	// Unset fields keep their defaults
+	if len(m.Scripts) != 1 || m.Scripts[0] != "js/flashpaper.js" {
+		t.Errorf("expected default scripts, got %v", m.Scripts)
+	}
+	if got := m.CSP["font-src"]; len(got) != 1 || got[0] != "https://fonts.example.com" {
+		t.Errorf("unexpected font-src %v", got)
+	}
+	if m.Data["theme"] != "dark" {
+		t.Errorf("expected theme 'dark', got %q", m.Data["theme"])
+	}
+}
+
+// TestLoadManifest_Invalid tests that unsafe manifests are rejected.
+func TestLoadManifest_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{"malformed JSON", `{"styles": [`},
+		{"frame-ancestors not extensible", `{"csp": {"frame-ancestors": ["https://evil.example"]}}`},
+		{"directive injection", `{"csp": {"img-src": ["https://a.example; script-src *"]}}`},
+		{"wildcard source", `{"csp": {"script-src": ["*"]}}`},
+		{"unsafe-eval", `{"csp": {"script-src": ["'unsafe-eval'"]}}`},
+		{"bad preload destination", `{"preload": [{"href": "css/x.css", "as": "document"}]}`},
+		{"preload without href", `{"preload": [{"as": "style"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := fstest.MapFS{"t.manifest.json": {Data: []byte(tt.manifest)}}
+			if _, err := loadManifest(fsys, "t"); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+// TestLoadManifest_Bundled tests that the shipped template manifests are valid.
+func TestLoadManifest_Bundled(t *testing.T) {
+	templateFS, err := flashpaper.TemplateFS()
+	if err != nil {
+		t.Fatalf("template FS: %v", err)
+	}
+	for _, name := range []string{"bootstrap5", "bootstrap-dark", "page"} {
+		if _, err := loadManifest(templateFS, name); err != nil {
+			t.Errorf("bundled manifest %s: %v", name, err)
+		}
+	}
+}
+
+// TestServeUI_ManifestAssets tests that the page loads what the manifest lists.
+func TestServeUI_ManifestAssets(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initStaticFS()
+	h.initTemplates()
+	h.manifest = &TemplateManifest{
+		Name:    "custom",
+		Styles:  []string{"css/style.css"},
+		Scripts: []string{"https://cdn.example.com/extra.js", "js/flashpaper.js"},
+		Preload: []PreloadAsset{{Href: "js/flashpaper.js", As: "script"}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h.serveUI(rr, req)
+
+	body := rr.Body.String()
+	hashedJS := h.assets.URL("js/flashpaper.js")
+	for _, want := range []string{
+		`<script src="https://cdn.example.com/extra.js" defer></script>`,
+		`<script src="` + hashedJS + `" defer></script>`,
+		`<link rel="preload" href="` + hashedJS + `" as="script">`,
+		`href="` + h.assets.URL("css/style.css") + `"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected page to contain %s", want)
+		}
+	}
+
+	// External script is listed before the bundled one
+	if strings.Index(body, "cdn.example.com") > strings.Index(body, hashedJS+`" defer`) {
+		t.Error("expected scripts in manifest order")
+	}
+}
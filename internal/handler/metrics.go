@@ -0,0 +1,145 @@
+// Package handler provides the application's usage metrics.
+// Only aggregates are recorded: how large created pastes are, which
+// expiration and formatter options people choose, how many pastes and
+// comments come and go, and how long storage operations take. Nothing
+// identifies a paste or a client. Operators read them from GET
+// /admin/metrics to tune [main] sizelimit and [expire_options], next to Go
+// runtime and process metrics for sizing the instance itself; [metrics]
+// also serves them at /metrics for Prometheus to scrape.
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/metrics"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// pasteSizeBuckets spans 1 KiB to 64 MiB in powers of four.
+var pasteSizeBuckets = metrics.ExponentialBuckets(1024, 4, 9)
+
+// pasteMetrics are recorded when pastes are created.
+type pasteMetrics struct {
+	size      *metrics.Histogram  // Encrypted size including attachment
+	expire    *metrics.CounterVec // By chosen expiration option
+	formatter *metrics.CounterVec // By chosen formatter
+	warnings  *metrics.CounterVec // Soft limit warnings by code (see softlimit.go)
+}
+
+// activityMetrics count paste and comment lifecycle events.
+type activityMetrics struct {
+	created    *metrics.CounterVec // Pastes stored
+	read       *metrics.CounterVec // Paste views, by first or repeat read
+	deleted    *metrics.CounterVec // Pastes gone, by events.Reason; purges count as expired
+	comments   *metrics.CounterVec // Comments stored
+	rejections *metrics.CounterVec // Requests refused with 429, by limit
+}
+
+// initMetrics creates the handler's registry and metrics.
+func (h *Handler) initMetrics() {
+	h.metrics = metrics.NewRegistry()
+	h.metrics.RegisterRuntime()
+	h.pasteMetrics = &pasteMetrics{
+		size: h.metrics.Histogram("flashpaper_paste_size_bytes",
+			"Size of created pastes in bytes, as encrypted and including attachments.", pasteSizeBuckets),
+		expire: h.metrics.Counter("flashpaper_paste_expire_total",
+			"Pastes created by chosen expiration option.", "expire"),
+		formatter: h.metrics.Counter("flashpaper_paste_formatter_total",
+			"Pastes created by chosen formatter.", "formatter"),
+		warnings: h.metrics.Counter("flashpaper_soft_limit_warnings_total",
+			"Requests accepted close to a hard limit, by warning code.", "code"),
+	}
+	h.activityMetrics = &activityMetrics{
+		created: h.metrics.Counter("flashpaper_pastes_created_total",
+			"Pastes created."),
+		read: h.metrics.Counter("flashpaper_pastes_read_total",
+			"Paste views, by whether it was the paste's first read.", "first"),
+		deleted: h.metrics.Counter("flashpaper_pastes_deleted_total",
+			"Pastes deleted, by reason (token, burned, expired).", "reason"),
+		comments: h.metrics.Counter("flashpaper_comments_created_total",
+			"Comments created."),
+		rejections: h.metrics.Counter("flashpaper_rate_limit_rejections_total",
+			"Requests refused for exceeding a rate limit or quota, by limit.", "limit"),
+	}
+	if i, ok := h.store.(storage.Instrumenter); ok {
+		i.Instrument(h.metrics)
+	}
+}
+
+// Metrics returns the registry holding the handler's metrics.
+func (h *Handler) Metrics() *metrics.Registry {
+	return h.metrics
+}
+
+// metricsEvent records lifecycle events in the usage metrics.
+func (h *Handler) metricsEvent(e events.Event) {
+	if e.Kind == events.PasteCreated {
+		h.recordCreated(e.Paste, e.Expire)
+	}
+
+	m := h.activityMetrics
+	if m == nil {
+		return
+	}
+	switch e.Kind {
+	case events.PasteCreated:
+		m.created.Inc()
+	case events.PasteRead:
+		m.read.Inc(strconv.FormatBool(e.First))
+	case events.PasteDeleted:
+		m.deleted.Inc(string(e.Reason))
+	case events.CommentCreated:
+		m.comments.Inc()
+	case events.PurgeCompleted:
+		m.deleted.Add(float64(e.Purged), string(events.ReasonExpired))
+	}
+}
+
+// recordRejection counts a request refused with 429 Too Many Requests.
+// limit names what was exceeded ("quota", "traffic", "comment", "read").
+func (h *Handler) recordRejection(limit string) {
+	if m := h.activityMetrics; m != nil {
+		m.rejections.Inc(limit)
+	}
+}
+
+// recordCreated records a newly created paste's size and options.
+// expire is the option the client asked for; anything that isn't a
+// configured option is counted as "other" to keep label values bounded.
+func (h *Handler) recordCreated(paste *model.Paste, expire string) {
+	m := h.pasteMetrics
+	if m == nil {
+		return
+	}
+
+	if _, ok := h.live().Expire.Options[expire]; !ok {
+		expire = "other"
+	}
+	formatter := paste.Meta.Formatter
+	if formatter == "" {
+		formatter = model.FormatterPlainText
+	}
+
+	m.size.Observe(float64(int64(len(paste.Data)) + paste.AttachmentLength()))
+	m.expire.Inc(expire)
+	m.formatter.Inc(formatter)
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format, without
+// authentication. The server mounts it at /metrics when [metrics] is enabled.
+func (h *Handler) MetricsHandler() http.Handler {
+	return http.HandlerFunc(h.getMetrics)
+}
+
+// getMetrics handles GET /admin/metrics in the Prometheus text format.
+func (h *Handler) getMetrics(w http.ResponseWriter, r *http.Request) {
+	if h.metrics == nil {
+		http.Error(w, "Metrics not available", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", metrics.ContentType)
+	h.metrics.WriteText(w)
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// TestMetrics_RecordCreated tests the size and option metrics on create.
+func TestMetrics_RecordCreated(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initMetrics()
+
+	create := func(expire, formatter string) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"v":     2,
+			"ct":    strings.Repeat("x", 2000),
+			"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, formatter, 0, 0},
+			"meta":  map[string]interface{}{"expire": expire},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		h.handlePost(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+	}
+
+	create("1day", "plaintext")
+	create("1day", "markdown")
+	create("42years", "plaintext")
+
+	m := h.pasteMetrics
+	if got := m.expire.Value("1day"); got != 2 {
+		t.Errorf("expected 2 pastes with 1day, got %v", got)
+	}
+	if got := m.expire.Value("other"); got != 1 {
+		t.Errorf("expected unknown option counted as other, got %v", got)
+	}
+	if got := m.formatter.Value("markdown"); got != 1 {
+		t.Errorf("expected 1 markdown paste, got %v", got)
+	}
+	if got := m.size.Count(); got != 3 {
+		t.Errorf("expected 3 size observations, got %d", got)
+	}
+}
+
+// TestMetrics_Activity tests the lifecycle counters and storage latencies.
+func TestMetrics_Activity(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.store = storage.WithMetrics(h.store, "database")
+	h.initMetrics()
+
+	if resp := createSized(h, 100); resp["status"] != float64(0) {
+		t.Fatalf("expected paste to be created, got %v", resp)
+	}
+	h.publish(events.Event{Kind: events.PasteDeleted, PasteID: "a1b2c3d4e5f6a7b8", Reason: events.ReasonBurned})
+	h.publish(events.Event{Kind: events.PurgeCompleted, Purged: 3})
+	h.recordRejection("quota")
+
+	m := h.activityMetrics
+	if got := m.created.Value(); got != 1 {
+		t.Errorf("expected 1 created paste, got %v", got)
+	}
+	if got := m.deleted.Value("expired"); got != 3 {
+		t.Errorf("expected purged pastes counted as expired, got %v", got)
+	}
+	if got := m.deleted.Value("burned"); got != 1 {
+		t.Errorf("expected 1 burned paste, got %v", got)
+	}
+	if got := m.rejections.Value("quota"); got != 1 {
+		t.Errorf("expected 1 quota rejection, got %v", got)
+	}
+
+	var out bytes.Buffer
+	h.metrics.WriteText(&out)
+	want := `flashpaper_storage_operation_duration_seconds_count{backend="database",operation="create_paste"} 1`
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("expected %q in output:\n%s", want, out.String())
+	}
+}
+
+// TestMetrics_AdminEndpoint tests the exposition through the admin API.
+func TestMetrics_AdminEndpoint(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initMetrics()
+	h.config.Admin.Token = testAdminToken
+
+	if rr := adminRequest(h, http.MethodGet, "/admin/metrics", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+
+	rr := adminRequest(h, http.MethodGet, "/admin/metrics", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unexpected Content-Type %q", rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), "# TYPE flashpaper_paste_size_bytes histogram") {
+		t.Errorf("expected size histogram in output:\n%s", rr.Body.String())
+	}
+}
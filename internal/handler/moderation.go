@@ -0,0 +1,211 @@
+// Package handler provides discussion moderation.
+// With [main] moderation enabled, new comments are stored but held as
+// pending: readers don't see them until an operator approves them through
+// the admin API. Rejected comments stay stored but are never shown;
+// comments their authors delete are removed from storage.
+//
+// Moderation state lives in key-value storage, keyed by paste and comment
+// ID, next to a queue of pending comments for the admin listing.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// Moderation states stored per comment; empty means published.
+const (
+	commentPending  = "pending"
+	commentRejected = "rejected"
+)
+
+// moderationQueueKey holds the pending comments under NamespaceModeration.
+// Comment keys contain a dot, so they can't collide with it.
+const moderationQueueKey = "queue"
+
+// pendingComment is an entry in the moderation queue.
+type pendingComment struct {
+	PasteID   string `json:"pasteid"`
+	CommentID string `json:"commentid"`
+	PostDate  int64  `json:"postdate"`
+	Flagged   bool   `json:"flagged,omitempty"`
+}
+
+// moderationKey is the storage key of a comment's moderation state.
+// Keys double as file names in the Filesystem backend, so no slashes.
+func moderationKey(pasteID, commentID string) string {
+	return pasteID + "." + commentID
+}
+
+// holdComment reports whether a new comment must wait for approval.
+func (h *Handler) holdComment(comment *model.Comment) bool {
+	switch h.config.Main.Moderation {
+	case config.ModerationAll:
+		return true
+	case config.ModerationFlagged:
+		return comment.Meta.Flagged
+	default:
+		return false
+	}
+}
+
+// commentState returns a comment's moderation state.
+func (h *Handler) commentState(ctx context.Context, pasteID, commentID string) string {
+	state, _ := h.store.GetValue(ctx, storage.NamespaceModeration, moderationKey(pasteID, commentID))
+	return state
+}
+
+// setCommentState records a comment's moderation state.
+func (h *Handler) setCommentState(ctx context.Context, pasteID, commentID, state string) error {
+	return h.store.SetValue(ctx, storage.NamespaceModeration, moderationKey(pasteID, commentID), state)
+}
+
+// visibleComments returns a paste's comments without those that are
+// pending or rejected. Any other state hides a comment too, such as the
+// "deleted" that author deletion recorded before comments could be
+// removed from storage. Moderation state is checked even with
+// moderation off, so turning it off neither publishes rejected comments
+// nor surprises anyone with old ones.
+//
+// A discussion that can't be read at all is left out, as the paste can
+// still be shown; once comments have been sent, errors end the response.
+func (h *Handler) visibleComments(ctx context.Context, pasteID string) commentSource {
+	return func(yield func(*model.Comment) error) error {
+		sent := false
+		err := h.store.IterateComments(ctx, pasteID, func(c *model.Comment) error {
+			if h.commentState(ctx, pasteID, c.ID) != "" {
+				return nil
+			}
+			sent = true
+			return yield(c)
+		})
+		if !sent {
+			return nil
+		}
+		return err
+	}
+}
+
+// loadModerationQueue reads the pending comments, oldest first.
+func (h *Handler) loadModerationQueue(ctx context.Context) []pendingComment {
+	var queue []pendingComment
+	if value, _ := h.store.GetValue(ctx, storage.NamespaceModeration, moderationQueueKey); value != "" {
+		_ = json.Unmarshal([]byte(value), &queue)
+	}
+	return queue
+}
+
+// saveModerationQueue stores the pending comments.
+func (h *Handler) saveModerationQueue(ctx context.Context, queue []pendingComment) error {
+	data, err := json.Marshal(queue)
+	if err != nil {
+		return err
+	}
+	return h.store.SetValue(ctx, storage.NamespaceModeration, moderationQueueKey, string(data))
+}
+
+// enqueueComment adds a stored, pending comment to the moderation queue.
+func (h *Handler) enqueueComment(ctx context.Context, pasteID, commentID string, comment *model.Comment) error {
+	h.moderationMu.Lock()
+	defer h.moderationMu.Unlock()
+
+	queue := append(h.loadModerationQueue(ctx), pendingComment{
+		PasteID:   pasteID,
+		CommentID: commentID,
+		PostDate:  comment.Meta.PostDate,
+		Flagged:   comment.Meta.Flagged,
+	})
+	return h.saveModerationQueue(ctx, queue)
+}
+
+// dequeueComment removes a comment from the moderation queue.
+// Caller must hold moderationMu.
+func (h *Handler) dequeueComment(ctx context.Context, pasteID, commentID string) error {
+	queue := h.loadModerationQueue(ctx)
+	remaining := queue[:0]
+	for _, entry := range queue {
+		if entry.PasteID != pasteID || entry.CommentID != commentID {
+			remaining = append(remaining, entry)
+		}
+	}
+	if len(remaining) == len(queue) {
+		return nil
+	}
+	return h.saveModerationQueue(ctx, remaining)
+}
+
+// getPendingComments handles GET /admin/comments/pending.
+// Entries whose paste has since been deleted or expired are dropped.
+func (h *Handler) getPendingComments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	h.moderationMu.Lock()
+	queue := h.loadModerationQueue(ctx)
+	live := make([]pendingComment, 0, len(queue))
+	for _, entry := range queue {
+		if h.store.PasteExists(ctx, entry.PasteID) {
+			live = append(live, entry)
+		}
+	}
+	if len(live) != len(queue) {
+		_ = h.saveModerationQueue(ctx, live)
+	}
+	h.moderationMu.Unlock()
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"comments": live,
+	})
+}
+
+// approveComment handles POST /admin/comments/{pasteID}/{commentID}/approve.
+func (h *Handler) approveComment(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, "")
+}
+
+// rejectComment handles POST /admin/comments/{pasteID}/{commentID}/reject.
+func (h *Handler) rejectComment(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, commentRejected)
+}
+
+// moderate settles a pending comment: an empty state publishes it.
+func (h *Handler) moderate(w http.ResponseWriter, r *http.Request, state string) {
+	ctx := r.Context()
+	pasteID := chi.URLParam(r, "pasteID")
+	commentID := chi.URLParam(r, "commentID")
+	if util.ValidateIDOrError(pasteID) != nil || util.ValidateIDOrError(commentID) != nil {
+		h.jsonError(w, "Invalid paste or comment ID", http.StatusBadRequest)
+		return
+	}
+
+	h.moderationMu.Lock()
+	if h.commentState(ctx, pasteID, commentID) != commentPending {
+		h.moderationMu.Unlock()
+		h.jsonError(w, "Comment is not pending moderation", http.StatusNotFound)
+		return
+	}
+	if err := h.setCommentState(ctx, pasteID, commentID, state); err != nil {
+		h.moderationMu.Unlock()
+		h.jsonError(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+	_ = h.dequeueComment(ctx, pasteID, commentID)
+	h.moderationMu.Unlock()
+
+	// Subscribers learn about a held comment only once readers can see it
+	if state == "" {
+		h.publish(events.Event{Kind: events.CommentCreated, PasteID: pasteID, CommentID: commentID})
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"pasteid":   pasteID,
+		"commentid": commentID,
+	})
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/events"
+)
+
+// readComments returns the IDs of the comments readers see on a paste.
+func readComments(t *testing.T, h *Handler, pasteID string) []string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.handleGet(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Comments []struct {
+			ID string `json:"id"`
+		} `json:"comments"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	ids := make([]string, len(resp.Comments))
+	for i, c := range resp.Comments {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+// pendingComments lists the moderation queue through the admin API.
+func pendingComments(t *testing.T, h *Handler) []pendingComment {
+	t.Helper()
+
+	rr := adminRequest(h, http.MethodGet, "/admin/comments/pending", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Comments []pendingComment `json:"comments"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return resp.Comments
+}
+
+// TestModeration_ApproveAndReject tests holding, approving, and rejecting comments.
+func TestModeration_ApproveAndReject(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+	h.config.Main.Moderation = config.ModerationAll
+	var created []string
+	h.Events().Subscribe(func(e events.Event) {
+		if e.Kind == events.CommentCreated {
+			created = append(created, e.CommentID)
+		}
+	})
+
+	pasteID := "abcdef1234567890"
+	newDiscussionPaste(mockStore, pasteID)
+
+	var ids []string
+	for _, data := range []string{"first", "second"} {
+		rr := postComment(h, pasteID, data)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var resp map[string]interface{}
+		json.NewDecoder(rr.Body).Decode(&resp)
+		if resp["pending"] != true {
+			t.Errorf("expected pending in response, got %v", resp)
+		}
+		ids = append(ids, resp["id"].(string))
+	}
+
+	if got := readComments(t, h, pasteID); len(got) != 0 {
+		t.Errorf("expected pending comments to be hidden, got %v", got)
+	}
+	if len(created) != 0 {
+		t.Errorf("expected no events for held comments, got %v", created)
+	}
+	if queue := pendingComments(t, h); len(queue) != 2 || queue[0].CommentID != ids[0] {
+		t.Fatalf("expected both comments queued in order, got %+v", queue)
+	}
+
+	// Approve the first, reject the second
+	rr := adminRequest(h, http.MethodPost, "/admin/comments/"+pasteID+"/"+ids[0]+"/approve", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("approve: expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	rr = adminRequest(h, http.MethodPost, "/admin/comments/"+pasteID+"/"+ids[1]+"/reject", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reject: expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	if got := readComments(t, h, pasteID); len(got) != 1 || got[0] != ids[0] {
+		t.Errorf("expected only the approved comment, got %v", got)
+	}
+	if queue := pendingComments(t, h); len(queue) != 0 {
+		t.Errorf("expected empty queue, got %+v", queue)
+	}
+	if len(created) != 1 || created[0] != ids[0] {
+		t.Errorf("expected one event for the approved comment, got %v", created)
+	}
+
+	// Settled comments can't be moderated again
+	rr = adminRequest(h, http.MethodPost, "/admin/comments/"+pasteID+"/"+ids[1]+"/approve", testAdminToken, nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for settled comment, got %d", http.StatusNotFound, rr.Code)
+	}
+
+	// Turning moderation off doesn't publish rejected comments
+	h.config.Main.Moderation = config.ModerationOff
+	if got := readComments(t, h, pasteID); len(got) != 1 {
+		t.Errorf("expected rejected comment to stay hidden, got %v", got)
+	}
+}
+
+// TestModeration_Flagged tests that only flagged comments are held in flagged mode.
+func TestModeration_Flagged(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	h.config.Main.Moderation = config.ModerationFlagged
+	h.AddCommentHook(CommentHookFunc(func(info *CommentInfo) CommentDecision {
+		if info.DataSize > 10 {
+			return CommentDecision{Action: CommentFlag}
+		}
+		return CommentDecision{Action: CommentAllow}
+	}))
+
+	pasteID := "abcdef1234567890"
+	newDiscussionPaste(mockStore, pasteID)
+
+	postComment(h, pasteID, "short")
+	postComment(h, pasteID, "suspiciously long comment")
+
+	if got := readComments(t, h, pasteID); len(got) != 1 {
+		t.Errorf("expected only the unflagged comment, got %v", got)
+	}
+}
@@ -0,0 +1,69 @@
+// Package handler provides client address matching for [traffic] exempted
+// and creators. Both lists take IPv4 and IPv6 addresses and CIDR ranges.
+// They are parsed on first use rather than in New, which tests bypass.
+package handler
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// clientAddr returns the client's address as used for rate limits and
+// access lists, or false if it can't be parsed.
+func (h *Handler) clientAddr(r *http.Request) (netip.Addr, bool) {
+	ip := getClientIP(r, h.config.Traffic.Header)
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		// Some proxies forward "address:port"
+		addrPort, err := netip.ParseAddrPort(ip)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addrPort.Addr()
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// trafficNetworks returns the parsed exempted and creators lists.
+// Validate has checked them.
+func (h *Handler) trafficNetworks() (exempted, creators []netip.Prefix) {
+	h.networksOnce.Do(func() {
+		h.exempted, _ = config.ParseNetworks(h.config.Traffic.Exempted)
+		h.creators, _ = config.ParseNetworks(h.config.Traffic.Creators)
+	})
+	return h.exempted, h.creators
+}
+
+// isExempted reports whether the client is exempt from rate limiting.
+func (h *Handler) isExempted(r *http.Request) bool {
+	exempted, _ := h.trafficNetworks()
+	if len(exempted) == 0 {
+		return false
+	}
+	addr, ok := h.clientAddr(r)
+	return ok && containsAddr(exempted, addr)
+}
+
+// mayCreate reports whether the client may create pastes. With no
+// creators configured, everyone may; otherwise clients whose address
+// can't be determined may not.
+func (h *Handler) mayCreate(r *http.Request) bool {
+	_, creators := h.trafficNetworks()
+	if len(creators) == 0 {
+		return true
+	}
+	addr, ok := h.clientAddr(r)
+	return ok && containsAddr(creators, addr)
+}
+
+// containsAddr reports whether any of networks contains addr.
+func containsAddr(networks []netip.Prefix, addr netip.Addr) bool {
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// createFrom posts a paste from the given remote address.
+func createFrom(h *Handler, remoteAddr string) int {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "encrypted-content",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = remoteAddr
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr.Code
+}
+
+// TestExempted_Networks tests CIDR ranges and IPv6 in [traffic] exempted.
+func TestExempted_Networks(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Traffic.Limit = 300
+	h.config.Traffic.Exempted = []string{"192.168.1.0/24", "2001:db8::/32"}
+
+	for _, addr := range []string{"192.168.1.77:1234", "[2001:db8::5]:1234"} {
+		for i := 0; i < 3; i++ {
+			if code := createFrom(h, addr); code != http.StatusOK {
+				t.Errorf("%s request %d: expected status %d, got %d", addr, i+1, http.StatusOK, code)
+			}
+		}
+	}
+
+	createFrom(h, "192.168.2.1:1234")
+	if code := createFrom(h, "192.168.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("expected clients outside the ranges limited, got %d", code)
+	}
+}
+
+// TestCreators_Enforced tests that only [traffic] creators can create pastes.
+func TestCreators_Enforced(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Traffic.Creators = []string{"10.0.0.0/8", "2001:db8::1"}
+
+	for addr, want := range map[string]int{
+		"10.1.2.3:1234":           http.StatusOK,
+		"[::ffff:10.1.2.3]:1234":  http.StatusOK,
+		"[2001:db8::1]:1234":      http.StatusOK,
+		"[2001:db8::2]:1234":      http.StatusForbidden,
+		"192.0.2.1:1234":          http.StatusForbidden,
+		"not-an-address":          http.StatusForbidden,
+		"[fe80::1%eth0]:1234":     http.StatusForbidden,
+		"[2001:db8::1%eth0]:1234": http.StatusOK,
+	} {
+		if code := createFrom(h, addr); code != want {
+			t.Errorf("%s: expected status %d, got %d", addr, want, code)
+		}
+	}
+}
@@ -4,10 +4,16 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime/multipart"
 	"net/http"
-	"time"
 
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/logging"
 	"github.com/liskl/flashpaper/internal/model"
 	"github.com/liskl/flashpaper/internal/util"
 )
@@ -19,9 +25,67 @@ import (
 //	  "v": 2,
 //	  "ct": "base64_ciphertext",
 //	  "adata": [[iv, salt, iter, ks, ts, algo, mode, compression], formatter, opendiscussion, burnafterreading],
-//	  "meta": {"expire": "1day"}
+//	  "meta": {"expire": "1day", "category": "text", "max_reads": 3, "callback": "https://hooks.example.com/..."},
+//	  "attachment": "data", "attachmentname": "name"
 //	}
-func (h *Handler) createPaste(w http.ResponseWriter, r *http.Request, req map[string]interface{}) {
+//
+// The optional category (text, archive, image) is a coarse content hint kept
+// in plain metadata for raw downloads. The optional max_reads deletes the
+// paste after that many reads; a limit of one is burn-after-reading, and is
+// stored as such. The optional callback URL must match the configured
+// allowlist; it is notified when the paste is first read, deleted, or found
+// expired.
+//
+// The attachment fields may also be arrays, one entry per file.
+//
+// parts holds the attachment parts of a multipart request, nil otherwise.
+func (h *Handler) createPaste(w http.ResponseWriter, r *http.Request, req map[string]interface{}, parts *multipart.Reader) {
+	ctx := r.Context()
+	// No new content during maintenance (see maintenance.go)
+	if h.inMaintenance(w, r) {
+		return
+	}
+
+	// Denylisted clients are refused or tarpitted (see tarpit.go)
+	if h.denied(r) {
+		h.refuseDenied(w, r, "")
+		return
+	}
+
+	// Only [traffic] creators may create pastes, if any are configured
+	if !h.mayCreate(r) {
+		h.jsonError(w, "You are not allowed to create pastes", http.StatusForbidden)
+		return
+	}
+
+	// API token, if any (see tokens.go), or [auth] credentials (see auth.go)
+	token, ok := h.authenticateCreator(w, r)
+	if !ok {
+		return
+	}
+
+	// Terms-of-service gate (see tos.go)
+	if !h.tosAccepted(req) {
+		h.jsonErrorCode(w, "You must accept the terms of service", ErrCodeTOSNotAccepted, http.StatusForbidden)
+		return
+	}
+
+	// Retries carrying the same Idempotency-Key get the original result
+	idem, done := h.beginIdempotent(w, r, req)
+	if done {
+		return
+	}
+	var pasteID string
+	defer func() { h.finishIdempotent(ctx, idem, pasteID) }()
+
+	// Proof of work (see pow.go), the per-client creation limit (see
+	// ratelimit.go), and the captcha, checked last as it takes a request
+	// to the provider (see captcha.go); retries answered above need none
+	if !h.checkPoW(w, r, powFromRequest(req), token) || !h.allowRequest(w, r, limitPaste) ||
+		!h.checkCaptcha(w, r, captchaFromRequest(req), token) {
+		return
+	}
+
 	// Extract ciphertext
 	ct, ok := req["ct"].(string)
 	if !ok || ct == "" {
@@ -30,13 +94,15 @@ func (h *Handler) createPaste(w http.ResponseWriter, r *http.Request, req map[st
 	}
 
 	// Check size limit
-	if int64(len(ct)) > h.config.Main.SizeLimit {
+	if int64(len(ct)) > h.live().Main.SizeLimit {
 		h.jsonError(w, "Paste exceeds size limit", http.StatusBadRequest)
 		return
 	}
 
 	// Create paste model
+	now := h.clock.Now()
 	paste := model.NewPaste()
+	paste.Meta.PostDate = now.Unix()
 	paste.Data = ct
 
 	// Get version (default to 2)
@@ -55,161 +121,352 @@ func (h *Handler) createPaste(w http.ResponseWriter, r *http.Request, req map[st
 	}
 
 	// Get meta options
+	var callbackURL, expireOption, accessProof string
 	if meta, ok := req["meta"].(map[string]interface{}); ok {
 		// Expiration
 		if expire, ok := meta["expire"].(string); ok {
-			duration := h.config.GetExpireDuration(expire)
-			paste.SetExpiration(duration)
+			expireOption = expire
+			duration := h.live().GetExpireDuration(expire)
+			paste.SetExpirationFrom(now, duration)
+		}
+
+		// Content category (validated with the rest of the paste)
+		if category, ok := meta["category"].(string); ok {
+			paste.Meta.Category = category
+		}
+
+		// Read limit; burn-after-reading is the one-read case, so clients
+		// and code that only know that flag see such pastes as it
+		if v, ok := meta["max_reads"]; ok {
+			n, ok := v.(float64)
+			if !ok || n < 1 || n > math.MaxInt32 || n != math.Trunc(n) {
+				h.jsonError(w, model.ErrInvalidReadLimit.Error(), http.StatusBadRequest)
+				return
+			}
+			if n == 1 {
+				paste.Meta.BurnAfterReading = true
+			} else {
+				paste.Meta.MaxReads = int(n)
+				paste.Meta.ReadsLeft = int(n)
+			}
 		}
-	}
 
-	// Handle attachment if present
-	if attachment, ok := req["attachment"].(string); ok {
-		paste.Attachment = attachment
+		// Creator callback (validated before anything is stored)
+		if target, ok := meta["callback"].(string); ok && target != "" {
+			if err := h.callbacks.Check(target); err != nil {
+				h.jsonError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			callbackURL = target
+		}
+
+		// Server-checked password (see accessproof.go)
+		if accessProof, ok = h.accessProof(w, meta); !ok {
+			return
+		}
 	}
-	if attachmentName, ok := req["attachmentname"].(string); ok {
-		paste.AttachmentName = attachmentName
+
+	// Handle attachments if present; uploads are checked as they are stored
+	if !h.readAttachments(w, req, paste, parts != nil) {
+		return
 	}
 
+	// Instance-wide option overrides (see policy.go)
+	policyWarnings := h.applyPastePolicy(paste)
+
 	// Validate paste
 	if err := paste.Validate(); err != nil {
 		h.jsonError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Generate unique paste ID
-	var pasteID string
+	id, ok := h.newPasteID(ctx, w)
+	if !ok {
+		return
+	}
+	if accessProof != "" {
+		paste.Meta.AccessProof = util.HashAccessProof(id, accessProof)
+	}
+	if !h.storeNewPaste(w, r, id, paste, parts, token) {
+		return
+	}
+	pasteID = id
+
+	h.registerCallback(ctx, pasteID, callbackURL)
+	h.publish(events.Event{Kind: events.PasteCreated, PasteID: pasteID, Paste: paste, Expire: expireOption})
+	h.maybePurge(ctx)
+
+	// Paste is created; if the token can't be generated, still return success
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, paste.Meta.Salt)
+	var editToken string
+	if h.config.Main.Editable {
+		editToken, _ = pasteEditToken(pasteID, paste.Meta.Salt)
+	}
+
+	response := h.createdResponse(r, pasteID, deleteToken, editToken)
+	h.addWarnings(response, append(policyWarnings, h.sizeWarnings(int64(len(ct)))...))
+	h.jsonSuccess(w, response)
+}
+
+// newPasteID generates an ID for a new paste, writing the error response
+// itself if that fails. IDs in use are avoided, but CreatePaste is the
+// final word.
+func (h *Handler) newPasteID(ctx context.Context, w http.ResponseWriter) (string, bool) {
+	var id string
 	var err error
 	for attempts := 0; attempts < 10; attempts++ {
-		pasteID, err = util.GenerateID()
+		id, err = util.GenerateID()
 		if err != nil {
 			h.jsonError(w, "Failed to generate paste ID", http.StatusInternalServerError)
-			return
+			return "", false
 		}
-		if !h.store.PasteExists(pasteID) {
+		if !h.store.PasteExists(ctx, id) {
 			break
 		}
 	}
+	return id, true
+}
+
+// storeNewPaste stores a validated paste under id with a salt of its own,
+// along with its attachments, counting it against the creator's API token
+// (if any). It writes the error response itself if that fails.
+func (h *Handler) storeNewPaste(w http.ResponseWriter, r *http.Request, id string, paste *model.Paste, parts *multipart.Reader, token string) bool {
+	ctx := r.Context()
+	// Delete tokens derive from a salt of the paste's own, so a leaked
+	// salt exposes one paste rather than all of them
+	var err error
+	paste.Meta.Salt, err = util.GenerateSalt()
+	if err != nil {
+		h.jsonError(w, "Failed to generate paste salt", http.StatusInternalServerError)
+		return false
+	}
 
-	// Store server salt for delete token
-	paste.Meta.Salt = h.salt
+	// Store the attachments, apart from the paste if the backend can
+	if !h.storeAttachments(w, r, id, paste, parts) {
+		return false
+	}
+
+	// Count the paste against its token's quotas
+	size := int64(len(paste.Data)) + paste.AttachmentLength()
+	var period string
+	if token != "" {
+		if period, err = h.reserveTokenUsage(ctx, token, size); err != nil {
+			h.discardAttachments(ctx, id, paste)
+			if errors.Is(err, errQuotaExceeded) {
+				h.recordRejection("quota")
+				h.jsonErrorCode(w, err.Error(), ErrCodeQuotaExceeded, http.StatusTooManyRequests)
+				return false
+			}
+			h.jsonError(w, "Failed to account paste", http.StatusInternalServerError)
+			return false
+		}
+	}
 
 	// Create paste in storage
-	if err := h.store.CreatePaste(pasteID, paste); err != nil {
+	if err := h.store.CreatePaste(ctx, id, paste); err != nil {
+		if token != "" {
+			h.releaseTokenUsage(ctx, token, period, size)
+		}
+		h.discardAttachments(ctx, id, paste)
 		if err == model.ErrPasteExists {
 			h.jsonError(w, "Paste ID collision, please try again", http.StatusConflict)
-			return
+			return false
 		}
+		logging.FromContext(r.Context()).Error("Failed to store paste", "error", err)
 		h.jsonError(w, "Failed to store paste", http.StatusInternalServerError)
-		return
-	}
-
-	// Generate delete token
-	deleteToken, err := util.GenerateDeleteToken(pasteID, h.salt)
-	if err != nil {
-		// Paste is created but we couldn't generate token - still return success
-		deleteToken = ""
+		return false
 	}
+	return true
+}
 
-	// Build response
+// createdResponse builds the response for a newly created paste. The
+// edit token is left out if empty, when edits are disabled.
+func (h *Handler) createdResponse(r *http.Request, pasteID, deleteToken, editToken string) map[string]interface{} {
 	response := map[string]interface{}{
 		"id":          pasteID,
-		"url":         h.config.Main.BasePath + "/?" + pasteID,
+		"url":         h.pasteURL(r, pasteID),
 		"deletetoken": deleteToken,
 	}
-
-	h.jsonSuccess(w, response)
+	if editToken != "" {
+		response["edittoken"] = editToken
+	}
+	return response
 }
 
 // getPaste handles paste retrieval requests.
 // Returns the encrypted paste data and metadata.
 func (h *Handler) getPaste(w http.ResponseWriter, r *http.Request, pasteID string) {
+	ctx := r.Context()
+	paste, ok := h.loadPaste(w, r, pasteID)
+	if !ok {
+		return
+	}
+
+	// Comments, if discussion is enabled, are read as they're sent
+	comments := commentsFrom(nil)
+	if paste.HasDiscussion() {
+		comments = withIcons(h.visibleComments(ctx, pasteID), h.config.Main.Icon)
+	}
+
+	// Build response matching PrivateBin format
+	meta := map[string]interface{}{
+		"postdate":       paste.Meta.PostDate,
+		"opendiscussion": paste.Meta.OpenDiscussion,
+	}
+	if paste.Meta.Category != "" {
+		meta["category"] = paste.Meta.Category
+	}
+	if paste.HasReadLimit() {
+		meta["max_reads"] = paste.Meta.MaxReads
+		meta["reads_left"] = paste.Meta.ReadsLeft
+	}
+	response := map[string]interface{}{
+		"status": 0,
+		"id":     pasteID,
+		"url":    h.config.Main.BasePath + "/?" + pasteID,
+		"ct":     paste.Data,
+		"adata":  paste.AData,
+		"v":      paste.Version,
+		"meta":   meta,
+	}
+
+	// Add attachments if present, in the form they were sent
+	if len(paste.Attachments) > 0 {
+		response["attachment"] = paste.Attachments.Value(paste.Meta.AttachmentList)
+	}
+	if len(paste.AttachmentNames) > 0 {
+		response["attachmentname"] = paste.AttachmentNames.Value(paste.Meta.AttachmentList)
+	}
+
+	// Attachments can be large; keep them within the download bandwidth
+	// limits, except on pastes an operator pinned
+	out := w
+	if paste.HasAttachment() && !paste.Meta.Pinned {
+		out = h.throttle(w, r)
+	}
+
+	var attachments []io.ReadCloser
+	if len(paste.Meta.AttachmentSizes) > 0 {
+		// Stored apart from the paste; stream them into the response
+		if attachments, ok = h.openAttachments(w, r, pasteID, paste); !ok {
+			return
+		}
+		defer closeAll(attachments)
+	}
+
+	out.Header().Set("Content-Type", "application/json")
+	if err := writePaste(out, response, comments, attachments, paste.Meta.AttachmentList); err != nil {
+		logging.FromContext(r.Context()).Warn("Failed to send paste", "error", err)
+	}
+	h.finishRead(ctx, pasteID, paste)
+}
+
+// writePaste writes a paste response: doc, then the comments and
+// separately stored attachments, each streamed in as it is read.
+func writePaste(w io.Writer, doc map[string]interface{}, comments commentSource, attachments []io.ReadCloser, asList bool) error {
+	o, err := openObject(w, doc)
+	if err != nil {
+		return err
+	}
+	if err := writeComments(o, comments); err != nil {
+		return err
+	}
+	if attachments != nil {
+		if err := writeAttachments(o, attachments, asList); err != nil {
+			return err
+		}
+	}
+	return o.close()
+}
+
+// loadPaste validates a paste ID and reads the paste, writing the JSON
+// error response itself if that fails. Shared by every endpoint that
+// hands out paste content, so all of them count against the read limit;
+// pinned pastes are exempt.
+func (h *Handler) loadPaste(w http.ResponseWriter, r *http.Request, pasteID string) (*model.Paste, bool) {
+	ctx := r.Context()
 	// Validate paste ID format
 	if err := util.ValidateIDOrError(pasteID); err != nil {
 		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
-	// Read paste from storage
-	paste, err := h.store.ReadPaste(pasteID)
+	if h.deniedRead(w, r) {
+		return nil, false
+	}
+
+	// Read paste from storage. Burn-after-reading pastes are deleted in the
+	// same step, so only one of several concurrent readers gets them, and
+	// pastes with a read limit count the read in it likewise.
+	// Secrets are only served by the secret API (see secret.go).
+	paste, err := h.store.ReadPaste(ctx, pasteID)
+	if err == nil && paste.Meta.Secret != "" {
+		err = model.ErrPasteNotFound
+	}
+	// Checked before the password and burning: a disabled link reveals nothing
+	if err == nil && paste.Meta.Disabled {
+		err = model.ErrPasteDisabled
+	}
+	// Pinned pastes are checked once loaded, so misses still count
+	if !(err == nil && paste.Meta.Pinned) && !h.allowRequest(w, r, limitRead) {
+		return nil, false
+	}
+	// Requests without the password mustn't burn the paste
+	if err == nil && !h.checkAccessProof(w, r, pasteID, paste) {
+		return nil, false
+	}
+	// Read-only maintenance keeps pastes as they are, so these wait
+	if err == nil && (paste.IsBurnAfterReading() || paste.HasReadLimit()) && h.inReadOnly(w, r) {
+		return nil, false
+	}
+	if err == nil && paste.IsBurnAfterReading() {
+		paste, err = h.store.ReadAndDeletePaste(ctx, pasteID)
+	} else if err == nil && paste.HasReadLimit() {
+		paste, err = h.store.CountRead(ctx, pasteID)
+	}
 	if err != nil {
 		switch err {
 		case model.ErrPasteNotFound:
 			h.jsonError(w, "Paste not found", http.StatusNotFound)
 		case model.ErrPasteExpired:
+			h.publish(events.Event{Kind: events.PasteDeleted, PasteID: pasteID, Reason: events.ReasonExpired})
 			h.jsonError(w, "Paste has expired", http.StatusNotFound)
+		case model.ErrPasteDisabled:
+			h.jsonError(w, "Paste link is disabled", http.StatusForbidden)
 		default:
 			h.jsonError(w, "Failed to read paste", http.StatusInternalServerError)
 		}
-		return
-	}
-
-	// Handle burn-after-reading
-	// Note: Delete happens AFTER sending response so client gets the data
-	shouldDelete := paste.IsBurnAfterReading()
-
-	// Get comments if discussion is enabled
-	var comments []*model.Comment
-	if paste.HasDiscussion() {
-		comments, _ = h.store.ReadComments(pasteID)
+		return nil, false
 	}
+	return paste, true
+}
 
-	// Build response matching PrivateBin format
-	response := map[string]interface{}{
-		"id":   pasteID,
-		"url":  h.config.Main.BasePath + "/?" + pasteID,
-		"ct":   paste.Data,
-		"adata": paste.AData,
-		"v":    paste.Version,
-		"meta": map[string]interface{}{
-			"postdate":       paste.Meta.PostDate,
-			"opendiscussion": paste.Meta.OpenDiscussion,
-		},
-	}
-
-	// Add attachment if present
-	if paste.Attachment != "" {
-		response["attachment"] = paste.Attachment
-	}
-	if paste.AttachmentName != "" {
-		response["attachmentname"] = paste.AttachmentName
-	}
-
-	// Add comments if any
-	if len(comments) > 0 {
-		commentData := make([]map[string]interface{}, len(comments))
-		for i, c := range comments {
-			commentData[i] = map[string]interface{}{
-				"id":       c.ID,
-				"parentid": c.ParentID,
-				"pasteid":  c.PasteID,
-				"data":     c.Data,
-				"adata":    c.AData,
-				"v":        c.Version,
-				"meta": map[string]interface{}{
-					"postdate": c.Meta.PostDate,
-					"vizhash":  c.Vizhash,
-				},
-			}
+// finishRead does the bookkeeping after paste content has been sent:
+// the read receipt, the read event, and burn-after-reading.
+func (h *Handler) finishRead(ctx context.Context, pasteID string, paste *model.Paste) {
+	if !paste.IsLastRead() {
+		// Record the first read; subscribers such as callbacks only care
+		// about that one. Read-only maintenance doesn't record it.
+		first := false
+		if m := h.maintenance(ctx); m == nil || !m.ReadOnly {
+			first, _ = h.store.MarkRead(ctx, pasteID)
 		}
-		response["comments"] = commentData
-		response["comment_count"] = len(comments)
+		h.publish(events.Event{Kind: events.PasteRead, PasteID: pasteID, Paste: paste, First: first})
+		return
 	}
 
-	h.jsonSuccess(w, response)
-
-	// Delete after response if burn-after-reading
-	if shouldDelete {
-		go func() {
-			time.Sleep(100 * time.Millisecond) // Brief delay to ensure response is sent
-			h.store.DeletePaste(pasteID)
-		}()
-	}
+	// loadPaste already deleted the paste, so this was its last read, and
+	// its only one if burned; separately stored attachments were kept
+	// until they had been sent
+	h.publish(events.Event{Kind: events.PasteRead, PasteID: pasteID, Paste: paste, First: paste.IsBurnAfterReading()})
+	h.discardAttachments(ctx, pasteID, paste)
+	h.publish(events.Event{Kind: events.PasteDeleted, PasteID: pasteID, Reason: events.ReasonBurned})
 }
 
 // deletePaste handles paste deletion requests.
 // Requires the correct delete token for authentication.
 func (h *Handler) deletePaste(w http.ResponseWriter, r *http.Request, req map[string]interface{}) {
+	ctx := r.Context()
 	// Get paste ID
 	pasteID, ok := req["pasteid"].(string)
 	if !ok || pasteID == "" {
@@ -230,23 +487,59 @@ func (h *Handler) deletePaste(w http.ResponseWriter, r *http.Request, req map[st
 		return
 	}
 
-	// Validate delete token
-	if !util.ValidateDeleteToken(deleteToken, pasteID, h.salt) {
-		h.jsonError(w, "Invalid delete token", http.StatusForbidden)
+	if !h.checkDeleteToken(ctx, w, pasteID, deleteToken) {
 		return
 	}
 
 	// Delete paste
-	if err := h.store.DeletePaste(pasteID); err != nil {
+	if err := h.store.DeletePaste(ctx, pasteID); err != nil {
 		if err == model.ErrPasteNotFound {
 			h.jsonError(w, "Paste not found", http.StatusNotFound)
 			return
 		}
+		logging.FromContext(r.Context()).Error("Failed to delete paste", "error", err)
 		h.jsonError(w, "Failed to delete paste", http.StatusInternalServerError)
 		return
 	}
+	h.publish(events.Event{Kind: events.PasteDeleted, PasteID: pasteID, Reason: events.ReasonToken})
 
 	h.jsonSuccess(w, map[string]interface{}{
 		"id": pasteID,
 	})
 }
+
+// deleteTokenSalt returns the salt a paste's delete token derives from:
+// its own, or the server salt for pastes stored before per-paste salts.
+func (h *Handler) deleteTokenSalt(paste *model.Paste) string {
+	if paste.Meta.Salt != "" {
+		return paste.Meta.Salt
+	}
+	return h.salt
+}
+
+// checkDeleteToken validates a paste's delete token, which needs the paste's
+// salt. It writes the error response and returns false if the paste can't be
+// read or the token is wrong.
+func (h *Handler) checkDeleteToken(ctx context.Context, w http.ResponseWriter, pasteID, deleteToken string) bool {
+	_, ok := h.authorizedPaste(ctx, w, pasteID, deleteToken)
+	return ok
+}
+
+// authorizedPaste is checkDeleteToken for callers that need the paste too.
+func (h *Handler) authorizedPaste(ctx context.Context, w http.ResponseWriter, pasteID, deleteToken string) (*model.Paste, bool) {
+	paste, err := h.store.ReadPaste(ctx, pasteID)
+	if err != nil {
+		if err == model.ErrPasteNotFound || err == model.ErrPasteExpired {
+			h.jsonError(w, "Paste not found", http.StatusNotFound)
+			return nil, false
+		}
+		h.jsonError(w, "Failed to read paste", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !util.ValidateDeleteToken(deleteToken, pasteID, h.deleteTokenSalt(paste)) {
+		h.jsonError(w, "Invalid delete token", http.StatusForbidden)
+		return nil, false
+	}
+	return paste, true
+}
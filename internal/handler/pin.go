@@ -0,0 +1,56 @@
+// Package handler provides paste pinning through the admin API.
+// Operators pin pastes the instance itself relies on, such as a privacy
+// policy published as a paste, so they never expire, are never purged, and
+// are served without download rate limits. The flag lives in the paste's
+// meta; see storage.Pin.
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// pinPaste handles PUT /admin/pastes/{pasteID}/pin.
+func (h *Handler) pinPaste(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, true)
+}
+
+// unpinPaste handles DELETE /admin/pastes/{pasteID}/pin.
+// The paste's original expiration applies again.
+func (h *Handler) unpinPaste(w http.ResponseWriter, r *http.Request) {
+	h.setPinned(w, r, false)
+}
+
+// setPinned pins or unpins the paste named in the URL.
+func (h *Handler) setPinned(w http.ResponseWriter, r *http.Request, pinned bool) {
+	ctx := r.Context()
+	pasteID := chi.URLParam(r, "pasteID")
+	if err := util.ValidateIDOrError(pasteID); err != nil {
+		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
+		return
+	}
+
+	err := storage.Pin(ctx, h.store, pasteID, pinned)
+	switch {
+	case errors.Is(err, model.ErrPasteNotFound), errors.Is(err, model.ErrPasteExpired):
+		h.jsonError(w, "Paste not found", http.StatusNotFound)
+		return
+	case errors.Is(err, model.ErrPinBurnAfterReading):
+		h.jsonError(w, "Burn-after-reading pastes cannot be pinned", http.StatusConflict)
+		return
+	case err != nil:
+		h.jsonError(w, "Failed to update paste", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"pasteid": pasteID,
+		"pinned":  pinned,
+	})
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// TestPin_PinAndUnpin tests pinning and unpinning through the admin API.
+func TestPin_PinAndUnpin(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "privacy policy"
+	paste.Meta.ExpireDate = time.Now().Add(time.Hour).Unix()
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	rr := adminRequest(h, http.MethodPut, "/admin/pastes/"+pasteID+"/pin", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// Once past its expiration, a pinned paste is still served and not purged
+	stored, _ := mockStore.ReadPaste(ctx, pasteID)
+	stored.Meta.ExpireDate = time.Now().Add(-time.Hour).Unix()
+	mockStore.DeletePaste(ctx, pasteID)
+	mockStore.CreatePaste(ctx, pasteID, stored)
+
+	if expired, _ := mockStore.GetExpiredPastes(ctx, 10); len(expired) != 0 {
+		t.Errorf("expected pinned paste not to be purged, got %v", expired)
+	}
+	if _, err := mockStore.ReadPaste(ctx, pasteID); err != nil {
+		t.Errorf("expected pinned paste to be readable, got %v", err)
+	}
+
+	rr = adminRequest(h, http.MethodDelete, "/admin/pastes/"+pasteID+"/pin", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if _, err := mockStore.ReadPaste(ctx, pasteID); err != model.ErrPasteExpired {
+		t.Errorf("expected unpinned paste to expire, got %v", err)
+	}
+}
+
+// TestPin_Refused tests pins the admin API refuses.
+func TestPin_Refused(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+
+	burnID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "burn"
+	paste.Meta.BurnAfterReading = true
+	mockStore.CreatePaste(ctx, burnID, paste)
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"burn after reading", "/admin/pastes/" + burnID + "/pin", http.StatusConflict},
+		{"missing paste", "/admin/pastes/0000000000000000/pin", http.StatusNotFound},
+		{"invalid id", "/admin/pastes/nope/pin", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := adminRequest(h, http.MethodPut, tt.path, testAdminToken, nil)
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Package handler provides instance-wide paste option policy.
+// [main] force_burnafterreading and force_opendiscussion override what the
+// client asked for. The flags in adata can't be changed: adata is
+// authenticated by the client's encryption, and rewriting it would make the
+// paste undecryptable. The server acts on the stored meta flags instead,
+// which are rewritten, so a forced burn-after-reading paste is deleted on
+// its first read whatever its adata says. The bundled UI sets matching adata
+// flags from the client config; other clients learn of the override from a
+// warning in the create response.
+package handler
+
+import (
+	"strings"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// applyPastePolicy forces the configured options onto a new paste and
+// returns a warning if any differed from the client's choice.
+func (h *Handler) applyPastePolicy(paste *model.Paste) []Warning {
+	main := h.config.Main
+	var forced []string
+
+	if main.ForceBurnAfterReading {
+		if !paste.Meta.BurnAfterReading {
+			paste.Meta.BurnAfterReading = true
+			forced = append(forced, "burn-after-reading enabled")
+		}
+		if paste.Meta.OpenDiscussion {
+			paste.Meta.OpenDiscussion = false
+			forced = append(forced, "discussion disabled")
+		}
+		forced = append(forced, dropReadLimit(paste)...)
+	}
+	if main.ForceOpenDiscussion {
+		if paste.Meta.BurnAfterReading {
+			paste.Meta.BurnAfterReading = false
+			forced = append(forced, "burn-after-reading disabled")
+		}
+		if !paste.Meta.OpenDiscussion {
+			paste.Meta.OpenDiscussion = true
+			forced = append(forced, "discussion enabled")
+		}
+		forced = append(forced, dropReadLimit(paste)...)
+	}
+
+	if len(forced) == 0 {
+		return nil
+	}
+	return []Warning{{
+		Code:    WarnPolicyApplied,
+		Message: "Instance policy applied: " + strings.Join(forced, ", "),
+	}}
+}
+
+// dropReadLimit removes a paste's read limit, which neither forced option
+// leaves room for, and returns what was forced.
+func dropReadLimit(paste *model.Paste) []string {
+	if !paste.HasReadLimit() {
+		return nil
+	}
+	paste.Meta.MaxReads = 0
+	paste.Meta.ReadsLeft = 0
+	return []string{"read limit removed"}
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// createWithFlags posts a paste whose adata carries the given
+// opendiscussion and burnafterreading flags.
+func createWithFlags(h *Handler, discussion, burn int) map[string]interface{} {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "encrypted-content",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", discussion, burn},
+		"meta":  map[string]interface{}{"expire": "1day"},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	return response
+}
+
+// policyWarned reports whether a create response carries the policy warning.
+func policyWarned(response map[string]interface{}) bool {
+	warnings, _ := response["warnings"].([]interface{})
+	for _, w := range warnings {
+		if w.(map[string]interface{})["code"] == WarnPolicyApplied {
+			return true
+		}
+	}
+	return false
+}
+
+// TestPolicy_ForceBurnAfterReading tests that every paste is stored burn-after-reading.
+func TestPolicy_ForceBurnAfterReading(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Main.ForceBurnAfterReading = true
+
+	for _, tt := range []struct {
+		name             string
+		discussion, burn int
+		warned           bool
+	}{
+		{"plain", 0, 0, true},
+		{"discussion", 1, 0, true},
+		{"already burning", 0, 1, false},
+	} {
+		response := createWithFlags(h, tt.discussion, tt.burn)
+		if response["status"] != float64(0) {
+			t.Fatalf("%s: expected success, got %v", tt.name, response)
+		}
+		paste, err := mockStore.ReadPaste(ctx, response["id"].(string))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !paste.Meta.BurnAfterReading || paste.Meta.OpenDiscussion {
+			t.Errorf("%s: expected burn-after-reading without discussion, got %+v", tt.name, paste.Meta)
+		}
+		if got := policyWarned(response); got != tt.warned {
+			t.Errorf("%s: expected policy warning %v, got %v", tt.name, tt.warned, got)
+		}
+	}
+}
+
+// TestPolicy_ForceOpenDiscussion tests that every paste is stored with a discussion.
+func TestPolicy_ForceOpenDiscussion(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Main.ForceOpenDiscussion = true
+
+	response := createWithFlags(h, 0, 1)
+	paste, err := mockStore.ReadPaste(ctx, response["id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paste.Meta.BurnAfterReading || !paste.Meta.OpenDiscussion {
+		t.Errorf("expected discussion without burn-after-reading, got %+v", paste.Meta)
+	}
+	if !policyWarned(response) {
+		t.Error("expected policy warning")
+	}
+}
+
+// TestPolicy_ReadLimit tests that forced options drop a paste's read limit.
+func TestPolicy_ReadLimit(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Main.ForceOpenDiscussion = true
+
+	var response map[string]interface{}
+	json.Unmarshal(createWithReadLimit(h, 3).Body.Bytes(), &response)
+	paste, err := mockStore.ReadPaste(ctx, response["id"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paste.HasReadLimit() || !paste.Meta.OpenDiscussion {
+		t.Errorf("expected discussion without a read limit, got %+v", paste.Meta)
+	}
+	if !policyWarned(response) {
+		t.Error("expected policy warning")
+	}
+}
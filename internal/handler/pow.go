@@ -0,0 +1,103 @@
+// Package handler provides the proof-of-work gate on paste creation.
+// With [pow] enabled, clients fetch a challenge from GET /pow and send a
+// nonce solving it (see util.CheckPoW) with the paste:
+//
+//	{"v": 2, "ct": "...", "pow": {"challenge": "fpw1.16...", "nonce": "48213"}}
+//
+// The secret API takes the same "pow" field. Each challenge is good for
+// one paste: answered challenges are recorded in key-value storage until
+// they expire, and the purge cycle removes them after that. Creators with
+// an API token are exempt, as are retries answered from the idempotency
+// record.
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/storage"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// Error codes for creations refused by the gate. Both come with 403 Forbidden.
+const (
+	ErrCodePoWRequired = "pow_required"
+	ErrCodePoWInvalid  = "pow_invalid"
+)
+
+// powSolution is the "pow" field of a create request.
+type powSolution struct {
+	Challenge string `json:"challenge"`
+	Nonce     string `json:"nonce"`
+}
+
+// powFromRequest returns the "pow" field of a decoded create request.
+func powFromRequest(req map[string]interface{}) powSolution {
+	field, _ := req["pow"].(map[string]interface{})
+	challenge, _ := field["challenge"].(string)
+	nonce, _ := field["nonce"].(string)
+	return powSolution{Challenge: challenge, Nonce: nonce}
+}
+
+// servePoW handles GET /pow, issuing a challenge.
+// Response format:
+//
+//	{"status": 0, "challenge": "fpw1.16.1700000300...", "difficulty": 16, "expires": 1700000300}
+func (h *Handler) servePoW(w http.ResponseWriter, r *http.Request) {
+	pow := h.config.PoW
+	expires := h.clock.Now().Add(time.Duration(pow.TTL) * time.Second)
+	challenge, err := util.GeneratePoWChallenge(h.salt, pow.Difficulty, expires)
+	if err != nil {
+		h.jsonError(w, "Failed to create challenge", http.StatusInternalServerError)
+		return
+	}
+	h.jsonSuccess(w, map[string]interface{}{
+		"challenge":  challenge,
+		"difficulty": pow.Difficulty,
+		"expires":    expires.Unix(),
+	})
+}
+
+// checkPoW reports whether a creation may go ahead, writing the error
+// response itself if not. token is the creator's API token, if any.
+func (h *Handler) checkPoW(w http.ResponseWriter, r *http.Request, solution powSolution, token string) bool {
+	if !h.config.PoW.Enabled || token != "" {
+		return true
+	}
+	if solution.Challenge == "" {
+		h.jsonErrorCode(w, "A proof of work is required; get a challenge from /pow", ErrCodePoWRequired, http.StatusForbidden)
+		return false
+	}
+
+	difficulty, expires, ok := util.ValidatePoWChallenge(solution.Challenge, h.salt, h.clock.Now())
+	if !ok {
+		h.jsonErrorCode(w, "Invalid or expired proof-of-work challenge", ErrCodePoWInvalid, http.StatusForbidden)
+		return false
+	}
+	if !util.CheckPoW(solution.Challenge, solution.Nonce, difficulty) {
+		h.jsonErrorCode(w, "Invalid proof of work", ErrCodePoWInvalid, http.StatusForbidden)
+		return false
+	}
+
+	// Challenges are long and may be crafted; store them by hash. Instances
+	// sharing storage can each accept a challenge answered to them at the
+	// same instant, but no more than that.
+	sum := sha256.Sum256([]byte(solution.Challenge))
+	key := hex.EncodeToString(sum[:])
+	ctx := r.Context()
+	h.powMu.Lock()
+	defer h.powMu.Unlock()
+	if used, _ := h.store.GetValue(ctx, storage.NamespacePoW, key); used != "" {
+		h.jsonErrorCode(w, "Proof-of-work challenge already used", ErrCodePoWInvalid, http.StatusForbidden)
+		return false
+	}
+	// Holds the expiry, so PurgeValues can tell when it's no longer needed
+	if err := h.store.SetValue(ctx, storage.NamespacePoW, key, strconv.FormatInt(expires.Unix(), 10)); err != nil {
+		h.jsonError(w, "Failed to record proof of work", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
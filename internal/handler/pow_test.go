@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/storage"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// withPoW requires proofs of work of a low difficulty, so tests solve
+// challenges quickly.
+func withPoW(h *Handler) {
+	h.config.PoW.Enabled = true
+	h.config.PoW.Difficulty = 8
+	h.config.PoW.TTL = 300
+	h.SetClock(clock.NewFake(time.Unix(1700000000, 0)))
+}
+
+// getChallenge fetches a challenge from GET /pow.
+func getChallenge(t *testing.T, h *Handler) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pow", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var response struct {
+		Challenge  string `json:"challenge"`
+		Difficulty int    `json:"difficulty"`
+		Expires    int64  `json:"expires"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response.Difficulty != 8 || response.Expires != 1700000300 {
+		t.Errorf("unexpected challenge %+v", response)
+	}
+	return response.Challenge
+}
+
+// createWithPoW creates a paste carrying the given solution.
+func createWithPoW(h *Handler, challenge, nonce string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "encrypted-content",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day"},
+		"pow":   map[string]string{"challenge": challenge, "nonce": nonce},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	return rr, response
+}
+
+// TestPoW_Create tests creating a paste with a solved challenge, once.
+func TestPoW_Create(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	withPoW(h)
+
+	challenge := getChallenge(t, h)
+	nonce := util.SolvePoW(challenge, 8)
+	rr, response := createWithPoW(h, challenge, nonce)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if id, _ := response["id"].(string); !mockStore.PasteExists(ctx, id) {
+		t.Error("expected the paste to be stored")
+	}
+
+	// Each challenge is good for one paste
+	rr, response = createWithPoW(h, challenge, nonce)
+	if rr.Code != http.StatusForbidden || response["code"] != ErrCodePoWInvalid {
+		t.Errorf("expected a replay to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Answered challenges are kept until they expire
+	values := 0
+	mockStore.Values(ctx, func(namespace, key, value string) error {
+		if namespace == storage.NamespacePoW {
+			values++
+		}
+		return nil
+	})
+	if values != 1 {
+		t.Errorf("expected 1 answered challenge, got %d", values)
+	}
+}
+
+// TestPoW_Refused tests creations without a valid solution.
+func TestPoW_Refused(t *testing.T) {
+	h, _ := newTestHandler(t)
+	withPoW(h)
+
+	rr, response := createWithPoW(h, "", "")
+	if rr.Code != http.StatusForbidden || response["code"] != ErrCodePoWRequired {
+		t.Errorf("expected pow_required, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	challenge := getChallenge(t, h)
+	nonce := util.SolvePoW(challenge, 8)
+	expired, _ := util.GeneratePoWChallenge(h.salt, 8, h.clock.Now().Add(-time.Second))
+	foreign, _ := util.GeneratePoWChallenge("b3RoZXItc2FsdA==", 8, h.clock.Now().Add(time.Minute))
+	for name, solution := range map[string][2]string{
+		"wrong nonce":   {challenge, nonce + "0"},
+		"no nonce":      {challenge, ""},
+		"expired":       {expired, util.SolvePoW(expired, 8)},
+		"other salt":    {foreign, util.SolvePoW(foreign, 8)},
+		"easier":        {strings.Replace(challenge, "fpw1.8.", "fpw1.1.", 1), "0"},
+		"not challenge": {"challenge", "0"},
+	} {
+		if solution[1] == nonce+"0" && util.CheckPoW(challenge, solution[1], 8) {
+			continue // Happens to solve it as well
+		}
+		rr, response := createWithPoW(h, solution[0], solution[1])
+		if rr.Code != http.StatusForbidden || response["code"] != ErrCodePoWInvalid {
+			t.Errorf("%s: expected pow_invalid, got %d: %s", name, rr.Code, rr.Body.String())
+		}
+	}
+
+	// The challenge wasn't used up by the failed attempts
+	if rr, _ := createWithPoW(h, challenge, nonce); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
+
+// TestPoW_Disabled tests that /pow is only served when required.
+func TestPoW_Disabled(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/pow", nil))
+	if rr.Code == http.StatusOK && strings.Contains(rr.Body.String(), "challenge") {
+		t.Error("expected no challenges without [pow] enabled")
+	}
+	if h.clientConfig(context.Background()).PoW != nil {
+		t.Error("expected no pow in client config")
+	}
+
+	withPoW(h)
+	if cfg := h.clientConfig(context.Background()); cfg.PoW == nil || cfg.PoW.URL != "/pow" || cfg.PoW.Difficulty != 8 {
+		t.Errorf("unexpected pow in client config: %+v", cfg.PoW)
+	}
+}
+
+// TestPoW_Secret tests that the secret API takes the same proof.
+func TestPoW_Secret(t *testing.T) {
+	h, _ := newSecretHandler(t)
+	withPoW(h)
+
+	rr, response := doSecret(h, http.MethodPost, "/", map[string]interface{}{"ciphertext": "opaque-blob"}, nil)
+	if rr.Code != http.StatusForbidden || response["code"] != ErrCodePoWRequired {
+		t.Errorf("expected pow_required, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	challenge := getChallenge(t, h)
+	rr, _ = doSecret(h, http.MethodPost, "/", map[string]interface{}{
+		"ciphertext": "opaque-blob",
+		"pow":        map[string]string{"challenge": challenge, "nonce": util.SolvePoW(challenge, 8)},
+	}, nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// API-token creators are exempt
+	h.config.Tokens.Secrets = map[string]string{"team-a": testAPIToken}
+	rr, _ = doSecret(h, http.MethodPost, "/", map[string]interface{}{"ciphertext": "opaque-blob"},
+		map[string]string{"Authorization": "Bearer " + testAPIToken})
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,75 @@
+// Package handler provides opportunistic purging of expired pastes.
+// Like PrivateBin, FlashPaper has no scheduler: paste creation triggers a
+// purge at most once every [purge] limit seconds, removing up to
+// [purge] batchsize expired pastes in the background, along with the rate
+// limiter's buckets that have filled up again, which include every bucket
+// keyed by an earlier period's salt.
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// purgeKey is the key under NamespacePurge holding the last run's Unix time.
+const purgeKey = "last"
+
+// maybePurge starts a purge cycle if the last one is old enough.
+// The timestamp lives in storage so instances sharing it share the schedule.
+func (h *Handler) maybePurge(ctx context.Context) {
+	limit := int64(h.live().Purge.Limit)
+	if limit <= 0 {
+		return
+	}
+
+	// Only one cycle per process at a time
+	if !h.purging.CompareAndSwap(false, true) {
+		return
+	}
+
+	now := h.clock.Now().Unix()
+	value, _ := h.store.GetValue(ctx, storage.NamespacePurge, purgeKey)
+	if last, err := strconv.ParseInt(value, 10, 64); err == nil && now-last < limit {
+		h.purging.Store(false)
+		return
+	}
+	_ = h.store.SetValue(ctx, storage.NamespacePurge, purgeKey, strconv.FormatInt(now, 10))
+
+	// The cycle outlives the request that started it
+	ctx = context.WithoutCancel(ctx)
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		defer h.purging.Store(false)
+
+		// A full bucket is as good as none
+		if h.config.Traffic.Store != config.TrafficStoreMemory {
+			if err := h.store.PurgeValues(ctx, storage.NamespaceTraffic, 0); err != nil {
+				slog.Warn("Purging rate limits failed", "error", err)
+			}
+		}
+
+		// Answered challenges are only kept until they expire
+		if h.config.PoW.Enabled {
+			if err := h.store.PurgeValues(ctx, storage.NamespacePoW, 0); err != nil {
+				slog.Warn("Purging proof-of-work challenges failed", "error", err)
+			}
+		}
+
+		purged, err := h.store.Purge(ctx, h.live().Purge.BatchSize)
+		if err != nil {
+			slog.Error("Purge failed", "error", err)
+			return
+		}
+		if purged == 0 {
+			return
+		}
+		h.purged.Add(int64(purged))
+		h.publish(events.Event{Kind: events.PurgeCompleted, Purged: purged})
+	}()
+}
@@ -0,0 +1,166 @@
+// Package handler provides per-client rate limiting.
+// Paste creation, comment creation, and paste reads each have their own
+// token bucket per client ([traffic] limit/burst, comment_limit/burst,
+// read_limit/burst). Clients are identified by a salted hash of their
+// address, so the backing store never sees one, and the salt changes every
+// [traffic] salt_rotation seconds, so stored hashes can't be linked to a
+// client for longer than that. Exempted clients (see
+// networks.go) are never limited. Paste creations with an API token listed
+// in [token_rate_limit] or [token_rate_burst] use the token's own limits
+// instead, so automation can be allowed more than anonymous clients.
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/ratelimit"
+	"github.com/liskl/flashpaper/internal/storage"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// ErrCodeRateLimited is the error code of responses refused by the limiter.
+const ErrCodeRateLimited = "rate_limited"
+
+// Rate-limited actions. Each names its buckets' key prefix and its label
+// on flashpaper_rate_limit_rejections_total.
+const (
+	limitPaste   = "traffic"
+	limitComment = "comment"
+	limitRead    = "read"
+)
+
+// rateLimiter returns the limiter, creating it on first use.
+func (h *Handler) rateLimiter() *ratelimit.Limiter {
+	h.limiterOnce.Do(func() {
+		// Buckets are taken with a check-and-set where the backend can,
+		// so replicas sharing it count every request
+		var kv ratelimit.KV = h.store
+		if swapper, ok := storage.Swapper(h.store); ok {
+			kv = struct {
+				storage.Storage
+				storage.ValueSwapper
+			}{h.store, swapper}
+		}
+		store := ratelimit.NewKVStore(kv, storage.NamespaceTraffic)
+		if h.config.Traffic.Store == config.TrafficStoreMemory {
+			store = ratelimit.NewMemoryStore(h.clock)
+		}
+		h.limiter = ratelimit.New(store, h.clock)
+	})
+	return h.limiter
+}
+
+// rateRule returns the configured rule for an action.
+func (h *Handler) rateRule(action string) ratelimit.Rule {
+	t := h.live().Traffic
+	seconds, burst := t.Limit, t.Burst
+	switch action {
+	case limitComment:
+		seconds, burst = t.CommentLimit, t.CommentBurst
+	case limitRead:
+		seconds, burst = t.ReadLimit, t.ReadBurst
+	}
+	return ratelimit.Rule{Interval: time.Duration(seconds) * time.Second, Burst: burst}
+}
+
+// tokenRule returns the rule overriding rateRule for a paste creation
+// with an API token that has its own limits, and the token's name.
+func (h *Handler) tokenRule(r *http.Request, action string) (string, ratelimit.Rule, bool) {
+	if action != limitPaste {
+		return "", ratelimit.Rule{}, false
+	}
+	name, ok := h.apiToken(r)
+	if !ok || name == "" {
+		return "", ratelimit.Rule{}, false
+	}
+	cfg := h.live()
+	seconds, hasLimit := cfg.Tokens.RateLimit[name]
+	burst, hasBurst := cfg.Tokens.RateBurst[name]
+	if !hasLimit && !hasBurst {
+		return "", ratelimit.Rule{}, false
+	}
+	if !hasLimit {
+		seconds = int64(cfg.Traffic.Limit)
+	}
+	if !hasBurst {
+		burst = int64(cfg.Traffic.Burst)
+	}
+	return name, ratelimit.Rule{Interval: time.Duration(seconds) * time.Second, Burst: int(burst)}, true
+}
+
+// takeRequest counts a request against the client's bucket for action.
+// Limiter failures let the request through.
+func (h *Handler) takeRequest(r *http.Request, action string) ratelimit.Result {
+	rule := h.rateRule(action)
+	salt := util.RotatingSalt(h.salt, int64(h.live().Traffic.SaltRotation), h.clock.Now())
+	key := action + "." + util.HashIP(getClientIP(r, h.config.Traffic.Header), salt)
+	// A token's bucket is shared by every client using it
+	if name, tokenRule, ok := h.tokenRule(r, action); ok {
+		rule, key = tokenRule, action+".token."+name
+	}
+	if !rule.Enabled() {
+		return ratelimit.Result{Allowed: true}
+	}
+
+	if h.isExempted(r) {
+		return ratelimit.Result{Allowed: true}
+	}
+
+	result, err := h.rateLimiter().Allow(r.Context(), key, rule)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Rate limiter unavailable", "error", err)
+	}
+	if !result.Allowed {
+		h.recordRejection(action)
+	}
+	return result
+}
+
+// Rate limit response headers, telling clients where they stand before
+// they are refused.
+const (
+	// RateLimitLimitHeader is the bucket size: requests allowed at once
+	RateLimitLimitHeader = "X-RateLimit-Limit"
+
+	// RateLimitRemainingHeader is how many requests are left right now
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+
+	// RateLimitResetHeader is the seconds until the bucket is full again
+	RateLimitResetHeader = "X-RateLimit-Reset"
+)
+
+// allowRequest applies the limit for action, answering 429 Too Many
+// Requests itself if the client must wait. Limited requests, allowed or
+// not, carry the rate limit headers.
+func (h *Handler) allowRequest(w http.ResponseWriter, r *http.Request, action string) bool {
+	result := h.takeRequest(r, action)
+	setRateLimitHeaders(w.Header(), result)
+	if result.Allowed {
+		return true
+	}
+	w.Header().Set("Retry-After", ceilSeconds(result.RetryAfter))
+	h.jsonErrorCode(w, model.ErrRateLimited.Error(), ErrCodeRateLimited, http.StatusTooManyRequests)
+	return false
+}
+
+// setRateLimitHeaders reports result in the rate limit headers. Requests
+// no limit applies to (disabled, or exempted clients) get none.
+func setRateLimitHeaders(header http.Header, result ratelimit.Result) {
+	if result.Limit == 0 {
+		return
+	}
+	header.Set(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+	header.Set(RateLimitRemainingHeader, strconv.Itoa(max(result.Remaining, 0)))
+	header.Set(RateLimitResetHeader, ceilSeconds(result.Reset))
+}
+
+// ceilSeconds formats d in whole seconds, rounded up so clients waiting
+// that long aren't refused again.
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
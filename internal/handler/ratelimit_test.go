@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// TestRateLimit_PasteBurst tests that a burst of creations is allowed
+// before clients are answered 429 with Retry-After, and that the bucket
+// refills as time passes.
+func TestRateLimit_PasteBurst(t *testing.T) {
+	h, _ := newTestHandler(t)
+	c := clock.NewFake(time.Now())
+	h.SetClock(c)
+	h.config.Traffic.Limit = 60
+	h.config.Traffic.Burst = 3
+	h.config.Traffic.Store = config.TrafficStoreMemory
+
+	for i := 0; i < 3; i++ {
+		rr, _ := createIdempotent(h, "", "encrypted-content")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status %d, got %d: %s", i+1, http.StatusOK, rr.Code, rr.Body.String())
+		}
+		checkRateLimitHeaders(t, rr, "3", strconv.Itoa(2-i), strconv.Itoa(60*(i+1)))
+	}
+
+	rr, response := createIdempotent(h, "", "encrypted-content")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("expected Retry-After 60, got %q", got)
+	}
+	checkRateLimitHeaders(t, rr, "3", "0", "180")
+	if response["code"] != ErrCodeRateLimited {
+		t.Errorf("expected code %q, got %v", ErrCodeRateLimited, response["code"])
+	}
+
+	c.Advance(time.Minute)
+	if rr, _ := createIdempotent(h, "", "encrypted-content"); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d after a minute, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+// checkRateLimitHeaders checks the rate limit headers of a response.
+func checkRateLimitHeaders(t *testing.T, rr *httptest.ResponseRecorder, limit, remaining, reset string) {
+	t.Helper()
+	for header, want := range map[string]string{
+		RateLimitLimitHeader:     limit,
+		RateLimitRemainingHeader: remaining,
+		RateLimitResetHeader:     reset,
+	} {
+		if got := rr.Header().Get(header); got != want {
+			t.Errorf("expected %s %q, got %q", header, want, got)
+		}
+	}
+}
+
+// TestRateLimit_Unlimited tests that requests no limit applies to get no
+// rate limit headers.
+func TestRateLimit_Unlimited(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Traffic.Limit = 0
+
+	rr, _ := createIdempotent(h, "", "encrypted-content")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	checkRateLimitHeaders(t, rr, "", "", "")
+}
+
+// TestRateLimit_SaltRotation tests that clients are hashed with a new salt
+// every [traffic] salt_rotation seconds, which starts them on new buckets.
+func TestRateLimit_SaltRotation(t *testing.T) {
+	for _, rotation := range []int{86400, 0} {
+		h, _ := newTestHandler(t)
+		c := clock.NewFake(time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC))
+		h.SetClock(c)
+		h.config.Traffic.Limit = 7 * 86400
+		h.config.Traffic.Burst = 1
+		h.config.Traffic.SaltRotation = rotation
+		h.config.Traffic.Store = config.TrafficStoreMemory
+
+		if rr, _ := createIdempotent(h, "", "encrypted-content"); rr.Code != http.StatusOK {
+			t.Fatalf("rotation %d: expected status %d, got %d", rotation, http.StatusOK, rr.Code)
+		}
+		if rr, _ := createIdempotent(h, "", "encrypted-content"); rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("rotation %d: expected status %d, got %d", rotation, http.StatusTooManyRequests, rr.Code)
+		}
+
+		// Past midnight UTC, a new day's salt
+		c.Advance(2 * time.Hour)
+		want := http.StatusOK
+		if rotation == 0 {
+			want = http.StatusTooManyRequests
+		}
+		if rr, _ := createIdempotent(h, "", "encrypted-content"); rr.Code != want {
+			t.Errorf("rotation %d: expected status %d the next day, got %d", rotation, want, rr.Code)
+		}
+	}
+}
+
+// TestRateLimit_Reads tests the read limit and that it leaves creation alone.
+func TestRateLimit_Reads(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Traffic.ReadLimit = 60
+	h.config.Traffic.ReadBurst = 1
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.AData = []byte(`[["iv","salt",100000,256,128,"aes","gcm","zlib"],"plaintext",0,0]`)
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		h.handleGet(rr, req)
+		return rr.Code
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("expected first read allowed, got %d", code)
+	}
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Errorf("expected second read limited, got %d", code)
+	}
+
+	// Pinned pastes stay readable; misses are still limited
+	pasteID = "abcdef1234567891"
+	paste.Meta.Pinned = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	if code := get(); code != http.StatusOK {
+		t.Errorf("expected pinned paste read allowed, got %d", code)
+	}
+	pasteID = "abcdef1234567892"
+	if code := get(); code != http.StatusTooManyRequests {
+		t.Errorf("expected missing paste read limited, got %d", code)
+	}
+
+	// Buckets are kept in storage by default
+	values := 0
+	mockStore.Values(ctx, func(namespace, key, value string) error {
+		if namespace == "traffic" {
+			values++
+		}
+		return nil
+	})
+	if values != 1 {
+		t.Errorf("expected 1 stored bucket, got %d", values)
+	}
+
+	if rr, _ := createIdempotent(h, "", "encrypted-content"); rr.Code != http.StatusOK {
+		t.Errorf("expected creation unaffected, got %d", rr.Code)
+	}
+}
+
+// TestRateLimit_Comments tests the comment limit.
+func TestRateLimit_Comments(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Traffic.CommentLimit = 60
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.Meta.OpenDiscussion = true
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	comment := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"v":        2,
+			"pasteid":  pasteID,
+			"parentid": pasteID,
+			"data":     "encrypted-comment",
+			"adata":    []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		h.handlePost(rr, req)
+		return rr
+	}
+	rr := comment()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected first comment allowed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	checkRateLimitHeaders(t, rr, "1", "0", "60")
+	rr = comment()
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected second comment limited, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("expected Retry-After 60, got %q", got)
+	}
+	checkRateLimitHeaders(t, rr, "1", "0", "60")
+}
@@ -0,0 +1,102 @@
+// Package handler provides the raw paste download endpoint.
+// It returns a paste's encrypted document as a file, for command-line
+// clients and archiving. The server still can't decrypt anything: the
+// creator's optional content category only picks the suggested filename
+// and a hint header, so decrypting tools know what to expect.
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// categoryExtensions maps content categories to the extension of the
+// decrypted content, used in the suggested download filename.
+var categoryExtensions = map[string]string{
+	model.CategoryText:    "txt",
+	model.CategoryArchive: "archive",
+	model.CategoryImage:   "image",
+}
+
+// rawDocument is the downloadable form of a paste: everything needed to
+// decrypt it given the key from the URL fragment, and nothing else.
+type rawDocument struct {
+	ID             string          `json:"id"`
+	Version        int             `json:"v"`
+	CipherText     string          `json:"ct"`
+	AData          json.RawMessage `json:"adata"`
+	Attachment     interface{}     `json:"attachment,omitempty"`
+	AttachmentName interface{}     `json:"attachmentname,omitempty"`
+	Category       string          `json:"category,omitempty"`
+}
+
+// rawFilename returns the suggested download filename for a paste,
+// e.g. "f468483c313401e8.txt.json" for a text paste.
+func rawFilename(pasteID, category string) string {
+	if ext, ok := categoryExtensions[category]; ok {
+		return fmt.Sprintf("%s.%s.json", pasteID, ext)
+	}
+	return pasteID + ".json"
+}
+
+// getRaw serves GET /raw/{id}: the encrypted paste as a JSON attachment.
+// It counts as a read, so receipts, callbacks, and burn-after-reading
+// behave exactly as for the JSON API.
+func (h *Handler) getRaw(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	pasteID := chi.URLParam(r, "id")
+
+	paste, ok := h.loadPaste(w, r, pasteID)
+	if !ok {
+		return
+	}
+
+	var attachments []io.ReadCloser
+	if len(paste.Meta.AttachmentSizes) > 0 {
+		if attachments, ok = h.openAttachments(w, r, pasteID, paste); !ok {
+			return
+		}
+		defer closeAll(attachments)
+	}
+
+	doc := rawDocument{
+		ID:         pasteID,
+		Version:    paste.Version,
+		CipherText: paste.Data,
+		AData:      paste.AData,
+		Category:   paste.Meta.Category,
+	}
+	if len(paste.Attachments) > 0 {
+		doc.Attachment = paste.Attachments.Value(paste.Meta.AttachmentList)
+	}
+	if len(paste.AttachmentNames) > 0 {
+		doc.AttachmentName = paste.AttachmentNames.Value(paste.Meta.AttachmentList)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", rawFilename(pasteID, paste.Meta.Category)))
+	if paste.Meta.Category != "" {
+		w.Header().Set("X-Content-Category", paste.Meta.Category)
+	}
+	out := w
+	if !paste.Meta.Pinned {
+		out = h.throttle(w, r)
+	}
+	if attachments != nil {
+		// Stored apart from the paste; stream them into the document
+		if err := writeWithAttachments(out, doc, attachments, paste.Meta.AttachmentList); err != nil {
+			logging.FromContext(r.Context()).Warn("Failed to send attachment", "error", err)
+		}
+	} else {
+		json.NewEncoder(out).Encode(doc)
+	}
+
+	h.finishRead(ctx, pasteID, paste)
+}
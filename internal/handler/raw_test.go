@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// requestRaw downloads a paste through the router and returns the recorder.
+func requestRaw(h *Handler, pasteID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/raw/"+pasteID, nil)
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	return rr
+}
+
+// TestRaw_Download tests the raw download headers and document.
+func TestRaw_Download(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.AData = json.RawMessage(`[["iv","salt",100000,256,128,"aes","gcm","zlib"],"plaintext",0,0]`)
+	paste.Meta.Category = model.CategoryText
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	rr := requestRaw(h, pasteID)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected Content-Type application/json, got %s", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="abcdef1234567890.txt.json"` {
+		t.Errorf("unexpected Content-Disposition %q", cd)
+	}
+	if cat := rr.Header().Get("X-Content-Category"); cat != "text" {
+		t.Errorf("expected X-Content-Category text, got %q", cat)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if doc["ct"] != "encrypted-content" || doc["category"] != "text" {
+		t.Errorf("unexpected document %s", rr.Body.String())
+	}
+	if _, ok := doc["adata"].([]interface{}); !ok {
+		t.Errorf("expected adata array, got %v", doc["adata"])
+	}
+
+	// Downloading counts as a read
+	receipt, _ := mockStore.GetReadReceipt(ctx, pasteID)
+	if !receipt.IsRead() {
+		t.Error("expected raw download to mark the paste read")
+	}
+}
+
+// TestRaw_NoCategory tests the fallback filename.
+func TestRaw_NoCategory(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	mockStore.CreatePaste(ctx, "abcdef1234567890", paste)
+
+	rr := requestRaw(h, "abcdef1234567890")
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="abcdef1234567890.json"` {
+		t.Errorf("unexpected Content-Disposition %q", cd)
+	}
+	if cat := rr.Header().Get("X-Content-Category"); cat != "" {
+		t.Errorf("expected no category header, got %q", cat)
+	}
+}
+
+// TestRaw_BurnAfterReading tests that raw downloads burn like API reads.
+func TestRaw_BurnAfterReading(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	paste.Meta.BurnAfterReading = true
+	mockStore.CreatePaste(ctx, "abcdef1234567890", paste)
+
+	if rr := requestRaw(h, "abcdef1234567890"); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	if mockStore.PasteExists(ctx, "abcdef1234567890") {
+		t.Error("expected paste to be burned after raw download")
+	}
+}
+
+// TestRaw_Errors tests missing and invalid paste IDs.
+func TestRaw_Errors(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	if rr := requestRaw(h, "0000000000000000"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for missing paste, got %d", http.StatusNotFound, rr.Code)
+	}
+	if rr := requestRaw(h, "not-an-id"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for invalid ID, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestCreatePaste_Category tests that the category is stored and returned.
+func TestCreatePaste_Category(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	create := func(category string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"v":     2,
+			"ct":    "encrypted-content",
+			"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+			"meta":  map[string]interface{}{"expire": "1day", "category": category},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		h.handlePost(rr, req)
+		return rr
+	}
+
+	rr := create("bogus")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for unknown category, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	rr = create("archive")
+	var created map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	pasteID, _ := created["id"].(string)
+
+	stored, err := mockStore.ReadPaste(ctx, pasteID)
+	if err != nil {
+		t.Fatalf("expected stored paste: %v", err)
+	}
+	if stored.Meta.Category != model.CategoryArchive {
+		t.Errorf("expected category archive, got %q", stored.Meta.Category)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+	req.Header.Set("Accept", "application/json")
+	rr = httptest.NewRecorder()
+	h.handleGet(rr, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	meta, _ := response["meta"].(map[string]interface{})
+	if meta["category"] != "archive" {
+		t.Errorf("expected meta.category archive, got %v", meta["category"])
+	}
+}
@@ -0,0 +1,68 @@
+// Package handler provides the read receipt endpoint.
+// Receipts tell a paste's creator whether and when the paste was first read.
+// The delete token doubles as proof of creatorship, so only the creator can
+// learn about reads; the token is sent in the body to keep it out of logs.
+package handler
+
+import (
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// getReceipt handles read receipt requests.
+// Request format:
+//
+//	{"pasteid": "f468483c313401e8", "deletetoken": "..."}
+//
+// Response format:
+//
+//	{"status": 0, "id": "f468483c313401e8", "read": true, "firstread": 1700000000}
+func (h *Handler) getReceipt(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req map[string]interface{}
+	if !h.decodeJSON(w, r.Body, &req) {
+		return
+	}
+
+	// Get paste ID
+	pasteID, ok := req["pasteid"].(string)
+	if !ok || pasteID == "" {
+		h.jsonError(w, "No paste ID provided", http.StatusBadRequest)
+		return
+	}
+
+	// Validate paste ID format
+	if err := util.ValidateIDOrError(pasteID); err != nil {
+		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
+		return
+	}
+
+	// Get delete token
+	deleteToken, ok := req["deletetoken"].(string)
+	if !ok || deleteToken == "" {
+		h.jsonError(w, "No delete token provided", http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkDeleteToken(ctx, w, pasteID, deleteToken) {
+		return
+	}
+
+	receipt, err := h.store.GetReadReceipt(ctx, pasteID)
+	if err != nil {
+		if err == model.ErrPasteNotFound {
+			h.jsonError(w, "Paste not found", http.StatusNotFound)
+			return
+		}
+		h.jsonError(w, "Failed to read receipt", http.StatusInternalServerError)
+		return
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"id":        pasteID,
+		"read":      receipt.IsRead(),
+		"firstread": receipt.FirstRead,
+	})
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// requestReceipt posts a receipt request and returns the recorder.
+func requestReceipt(h *Handler, pasteID, deleteToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"pasteid":     pasteID,
+		"deletetoken": deleteToken,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/receipt", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	h.getReceipt(rr, req)
+	return rr
+}
+
+// TestReceipt_TracksFirstRead tests that the receipt flips to read after
+// the first retrieval and keeps its original timestamp.
+func TestReceipt_TracksFirstRead(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	mockStore.CreatePaste(ctx, pasteID, paste)
+	deleteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+
+	var response map[string]interface{}
+	rr := requestReceipt(h, pasteID, deleteToken)
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if rr.Code != http.StatusOK || response["read"] != false {
+		t.Fatalf("expected unread receipt, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/?"+pasteID, nil)
+		req.Header.Set("Accept", "application/json")
+		h.handleGet(httptest.NewRecorder(), req)
+	}
+
+	rr = requestReceipt(h, pasteID, deleteToken)
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	if response["read"] != true {
+		t.Errorf("expected read receipt, got %s", rr.Body.String())
+	}
+
+	receipt, _ := mockStore.GetReadReceipt(ctx, pasteID)
+	if response["firstread"] != float64(receipt.FirstRead) {
+		t.Errorf("expected firstread %d, got %v", receipt.FirstRead, response["firstread"])
+	}
+
+	// The paste itself is untouched
+	if !mockStore.PasteExists(ctx, pasteID) {
+		t.Error("reading a receipt should not delete the paste")
+	}
+}
+
+// TestReceipt_Errors tests receipt request validation.
+func TestReceipt_Errors(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	mockStore.CreatePaste(ctx, pasteID, paste)
+
+	missingID := "0123456789abcdef"
+	missingToken, _ := util.GenerateDeleteToken(missingID, h.salt)
+
+	tests := []struct {
+		name        string
+		pasteID     string
+		deleteToken string
+		status      int
+	}{
+		{"missing paste ID", "", "token", http.StatusBadRequest},
+		{"invalid paste ID", "not-an-id", "token", http.StatusBadRequest},
+		{"missing token", pasteID, "", http.StatusBadRequest},
+		{"wrong token", pasteID, "wrong-token", http.StatusForbidden},
+		{"paste not found", missingID, missingToken, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := requestReceipt(h, tt.pasteID, tt.deleteToken)
+			if rr.Code != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
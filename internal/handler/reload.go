@@ -0,0 +1,25 @@
+// Package handler provides configuration reloads while serving.
+// h.config is the configuration the handler was built with, and most of it
+// shapes state set up once in New (routes, templates, networks). Settings
+// that config.Reload lets change at runtime are read through live instead,
+// so a reload reaches the next request without locking every read.
+package handler
+
+import "github.com/liskl/flashpaper/internal/config"
+
+// live returns the current configuration: the last one applied by Reload,
+// or h.config if there was none.
+func (h *Handler) live() *config.Config {
+	if cfg := h.reloaded.Load(); cfg != nil {
+		return cfg
+	}
+	return h.config
+}
+
+// Reload applies the runtime settings of next (see config.Reload) and
+// returns the names of the changed settings that need a restart.
+func (h *Handler) Reload(next *config.Config) []string {
+	cfg, restart := h.live().Reload(next)
+	h.reloaded.Store(cfg)
+	return restart
+}
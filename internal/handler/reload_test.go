@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// TestReload tests that runtime settings apply to the next request and
+// that other changes are reported instead.
+func TestReload(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler(t)
+	if resp := createSized(h, 2000); resp["status"] != float64(0) {
+		t.Fatalf("expected creation before reload, got %v", resp)
+	}
+
+	next := *h.config
+	next.Main.SizeLimit = 1000
+	next.Main.Port = 9090
+	next.Expire.Default = "1day"
+	restart := h.Reload(&next)
+	if want := []string{"[main] Port"}; !reflect.DeepEqual(restart, want) {
+		t.Errorf("expected restart for %v, got %v", want, restart)
+	}
+
+	if resp := createSized(h, 2000); resp["status"] != float64(1) {
+		t.Errorf("expected the reloaded size limit to refuse the paste, got %v", resp)
+	}
+	if cfg := h.clientConfig(ctx); cfg.SizeLimit != 1000 || cfg.Expire.Default != "1day" {
+		t.Errorf("expected the reloaded limits in the client config, got %+v", cfg)
+	}
+	if h.config.Main.SizeLimit == 1000 {
+		t.Error("expected the startup config to be left alone")
+	}
+}
@@ -0,0 +1,329 @@
+// Package handler provides the secret API.
+// Scripts handing over a password or a key don't want to speak PrivateBin's
+// format, with its adata and client-side key derivation. /api/v1/secret is a
+// small JSON API for them: create a secret, read it once (or until it
+// expires), delete it. It is versioned and kept stable on its own terms,
+// apart from the PrivateBin-compatible endpoints, which follow PrivateBin.
+//
+// Secrets are stored as pastes, marked with their kind, and each API only
+// serves its own. The creator either encrypts the secret itself and sends
+// ciphertext the server never looks into, or, with [api] server_encryption,
+// sends plaintext for the server to encrypt under a new key. The key is
+// returned to the creator and never stored, so stored secrets stay
+// unreadable without it, but the server does see the plaintext in transit.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// secretPath is where the secret API is served.
+const secretPath = "/api/v1/secret"
+
+// Headers of secret API requests. Keys and tokens are kept out of URLs,
+// which end up in access logs and browser histories.
+const (
+	headerSecretKey   = "X-Secret-Key"
+	headerDeleteToken = "X-Delete-Token"
+)
+
+// Error codes of the secret API, beyond those shared with paste creation.
+const (
+	ErrCodeSecretKeyRequired = "secret_key_required"
+	ErrCodeSecretKeyInvalid  = "secret_key_invalid"
+)
+
+// secretRequest is the body of POST /api/v1/secret.
+type secretRequest struct {
+	Ciphertext  string      `json:"ciphertext"`   // Encrypted by the creator, in any encoding
+	Plaintext   string      `json:"plaintext"`    // For the server to encrypt
+	TTL         int64       `json:"ttl"`          // Seconds; 0 for the [expire] default
+	Burn        bool        `json:"burn"`         // Delete on first read
+	TOSAccepted bool        `json:"tos_accepted"` // See tos.go
+	PoW         powSolution `json:"pow"`          // See pow.go
+	Captcha     string      `json:"captcha"`      // See captcha.go
+}
+
+// secretRoutes returns the secret API router.
+func (h *Handler) secretRoutes() chi.Router {
+	srv := h.config.Server
+	r := chi.NewRouter()
+	r.With(withTimeout(srv.CreateTimeout), h.limitBody(h.createBodyLimit)).Post("/", h.createSecret)
+	r.With(withTimeout(srv.ReadTimeout)).Get("/{id}", h.getSecret)
+	r.With(withTimeout(srv.Timeout), h.smallBody()).Delete("/{id}", h.deleteSecret)
+	return r
+}
+
+// createSecret handles POST /api/v1/secret.
+// Request format (exactly one of ciphertext and plaintext):
+//
+//	{"ciphertext": "...", "ttl": 3600, "burn": true}
+//	{"plaintext": "hunter2", "ttl": 3600, "burn": true}
+//
+// Response format (key only for plaintext):
+//
+//	{"status": 0, "id": "f468483c313401e8", "url": "/api/v1/secret/f468483c313401e8",
+//	 "deletetoken": "...", "expiredate": 1700003600, "burn": true, "key": "..."}
+//
+// The TTL may be up to the longest configured expiration option. An
+// expiredate of 0 means the secret never expires.
+func (h *Handler) createSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.inMaintenance(w, r) {
+		return
+	}
+	if h.denied(r) {
+		h.jsonError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !h.mayCreate(r) {
+		h.jsonError(w, "You are not allowed to create pastes", http.StatusForbidden)
+		return
+	}
+	token, ok := h.authenticateCreator(w, r)
+	if !ok {
+		return
+	}
+
+	var req secretRequest
+	if !h.decodeJSON(w, r.Body, &req) {
+		return
+	}
+	if h.config.TOS.Required && !req.TOSAccepted {
+		h.jsonErrorCode(w, "You must accept the terms of service", ErrCodeTOSNotAccepted, http.StatusForbidden)
+		return
+	}
+
+	kind, content := model.SecretCiphertext, req.Ciphertext
+	if req.Plaintext != "" {
+		kind, content = model.SecretServer, req.Plaintext
+	}
+	switch {
+	case req.Ciphertext != "" && req.Plaintext != "":
+		h.jsonError(w, "Send either ciphertext or plaintext, not both", http.StatusBadRequest)
+		return
+	case content == "":
+		h.jsonError(w, "No secret provided", http.StatusBadRequest)
+		return
+	case kind == model.SecretServer && !h.config.API.ServerEncryption:
+		h.jsonError(w, "Server-side encryption is disabled; send ciphertext", http.StatusBadRequest)
+		return
+	case int64(len(content)) > h.live().Main.SizeLimit:
+		h.jsonError(w, "Paste exceeds size limit", http.StatusBadRequest)
+		return
+	}
+
+	ttl, ok := h.secretTTL(req.TTL)
+	if !ok {
+		h.jsonError(w, "Invalid TTL", http.StatusBadRequest)
+		return
+	}
+
+	if !h.checkPoW(w, r, req.PoW, token) || !h.allowRequest(w, r, limitPaste) ||
+		!h.checkCaptcha(w, r, req.Captcha, token) {
+		return
+	}
+
+	now := h.clock.Now()
+	paste := model.NewPaste()
+	paste.Meta.PostDate = now.Unix()
+	paste.Meta.Secret = kind
+	paste.Meta.BurnAfterReading = req.Burn || h.config.Main.ForceBurnAfterReading
+	paste.SetExpirationFrom(now, ttl)
+
+	id, ok := h.newPasteID(ctx, w)
+	if !ok {
+		return
+	}
+	var key string
+	if kind == model.SecretServer {
+		var err error
+		if paste.Data, key, err = util.SealSecret(id, []byte(content)); err != nil {
+			logging.FromContext(r.Context()).Error("Failed to encrypt secret", "error", err)
+			h.jsonError(w, "Failed to encrypt secret", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		paste.Data = content
+	}
+	if !h.storeNewPaste(w, r, id, paste, nil, token) {
+		return
+	}
+
+	h.publish(events.Event{Kind: events.PasteCreated, PasteID: id, Paste: paste})
+	h.maybePurge(ctx)
+
+	deleteToken, _ := util.GenerateDeleteToken(id, paste.Meta.Salt)
+	response := map[string]interface{}{
+		"id":          id,
+		"url":         h.config.Main.BasePath + secretPath + "/" + id,
+		"deletetoken": deleteToken,
+		"expiredate":  paste.Meta.ExpireDate,
+		"burn":        paste.Meta.BurnAfterReading,
+	}
+	if key != "" {
+		response["key"] = key
+	}
+	h.addWarnings(response, h.sizeWarnings(int64(len(content))))
+	h.jsonSuccess(w, response)
+}
+
+// secretTTL returns the expiration of a secret with the requested TTL in
+// seconds. Zero picks the [expire] default; beyond the longest configured
+// option (other than never) is refused.
+func (h *Handler) secretTTL(seconds int64) (time.Duration, bool) {
+	cfg := h.live()
+	if seconds == 0 {
+		return cfg.GetExpireDuration(cfg.Expire.Default), true
+	}
+	var longest time.Duration
+	for _, d := range cfg.Expire.Options {
+		longest = max(longest, d)
+	}
+	// Checked in seconds, as a Duration of that many could overflow
+	if seconds < 0 || seconds > int64(longest/time.Second) {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// getSecret handles GET /api/v1/secret/{id}.
+// Secrets the server encrypted need their key in the X-Secret-Key header.
+// Response format (plaintext for those, ciphertext for the others):
+//
+//	{"status": 0, "id": "f468483c313401e8", "plaintext": "hunter2",
+//	 "postdate": 1700000000, "expiredate": 1700003600, "burn": true}
+//
+// A burn-after-reading secret is deleted by the first read that succeeds;
+// a wrong key leaves it in place.
+func (h *Handler) getSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	if err := util.ValidateIDOrError(id); err != nil {
+		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
+		return
+	}
+	if h.deniedRead(w, r) || !h.allowRequest(w, r, limitRead) {
+		return
+	}
+
+	paste, err := h.store.ReadPaste(ctx, id)
+	if err == nil && paste.Meta.Secret == "" {
+		err = model.ErrPasteNotFound
+	}
+	// Disabled through /disable; checked before the key and burning
+	if err == nil && paste.Meta.Disabled {
+		err = model.ErrPasteDisabled
+	}
+	if err != nil {
+		h.secretReadError(w, id, err)
+		return
+	}
+
+	// Decrypt before a burn-after-reading secret is consumed
+	var plaintext []byte
+	if paste.Meta.Secret == model.SecretServer {
+		key := r.Header.Get(headerSecretKey)
+		if key == "" {
+			h.jsonErrorCode(w, "This secret needs its key", ErrCodeSecretKeyRequired, http.StatusUnauthorized)
+			return
+		}
+		if plaintext, err = util.OpenSecret(id, paste.Data, key); err != nil {
+			if !errors.Is(err, util.ErrSecretKey) {
+				logging.FromContext(r.Context()).Error("Failed to decrypt secret", "error", err)
+			}
+			h.jsonErrorCode(w, "Invalid secret key", ErrCodeSecretKeyInvalid, http.StatusForbidden)
+			return
+		}
+	}
+
+	// Only one of several concurrent readers gets a burn-after-reading secret
+	if paste.IsBurnAfterReading() {
+		if h.inReadOnly(w, r) {
+			return
+		}
+		if paste, err = h.store.ReadAndDeletePaste(ctx, id); err != nil {
+			h.secretReadError(w, id, err)
+			return
+		}
+	}
+
+	response := map[string]interface{}{
+		"id":         id,
+		"postdate":   paste.Meta.PostDate,
+		"expiredate": paste.Meta.ExpireDate,
+		"burn":       paste.Meta.BurnAfterReading,
+	}
+	if plaintext != nil {
+		response["plaintext"] = string(plaintext)
+	} else {
+		response["ciphertext"] = paste.Data
+	}
+	h.jsonSuccess(w, response)
+	h.finishRead(ctx, id, paste)
+}
+
+// secretReadError writes the response for a failed secret read.
+func (h *Handler) secretReadError(w http.ResponseWriter, id string, err error) {
+	switch err {
+	case model.ErrPasteNotFound:
+		h.jsonError(w, "Secret not found", http.StatusNotFound)
+	case model.ErrPasteExpired:
+		h.publish(events.Event{Kind: events.PasteDeleted, PasteID: id, Reason: events.ReasonExpired})
+		h.jsonError(w, "Secret has expired", http.StatusNotFound)
+	case model.ErrPasteDisabled:
+		h.jsonError(w, "Secret is disabled", http.StatusGone)
+	default:
+		h.jsonError(w, "Failed to read secret", http.StatusInternalServerError)
+	}
+}
+
+// deleteSecret handles DELETE /api/v1/secret/{id}, with the delete token
+// in the X-Delete-Token header.
+func (h *Handler) deleteSecret(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	if err := util.ValidateIDOrError(id); err != nil {
+		h.jsonError(w, "Invalid paste ID", http.StatusBadRequest)
+		return
+	}
+	deleteToken := r.Header.Get(headerDeleteToken)
+	if deleteToken == "" {
+		h.jsonError(w, "No delete token provided", http.StatusBadRequest)
+		return
+	}
+	if h.inReadOnly(w, r) {
+		return
+	}
+
+	paste, ok := h.authorizedPaste(ctx, w, id, deleteToken)
+	if !ok {
+		return
+	}
+	if paste.Meta.Secret == "" {
+		h.jsonError(w, "Secret not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.store.DeletePaste(ctx, id); err != nil {
+		if err == model.ErrPasteNotFound {
+			h.jsonError(w, "Secret not found", http.StatusNotFound)
+			return
+		}
+		logging.FromContext(r.Context()).Error("Failed to delete secret", "error", err)
+		h.jsonError(w, "Failed to delete secret", http.StatusInternalServerError)
+		return
+	}
+	h.publish(events.Event{Kind: events.PasteDeleted, PasteID: id, Reason: events.ReasonToken})
+
+	h.jsonSuccess(w, map[string]interface{}{"id": id})
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// newSecretHandler returns a test handler with the secret API enabled.
+func newSecretHandler(t *testing.T) (*Handler, *clock.Fake) {
+	t.Helper()
+	h, _ := newTestHandler(t)
+	h.config.API = config.APIConfig{Secrets: true, ServerEncryption: true}
+	c := clock.NewFake(time.Unix(1_700_000_000, 0))
+	h.SetClock(c)
+	return h, c
+}
+
+// doSecret sends a request to the secret API and decodes the response.
+func doSecret(h *Handler, method, path string, body interface{}, headers map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rr := httptest.NewRecorder()
+	h.secretRoutes().ServeHTTP(rr, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &response)
+	return rr, response
+}
+
+// TestSecret_Ciphertext tests storing and burning a client-encrypted secret.
+func TestSecret_Ciphertext(t *testing.T) {
+	h, c := newSecretHandler(t)
+
+	rr, created := doSecret(h, http.MethodPost, "/", map[string]interface{}{
+		"ciphertext": "opaque-blob", "ttl": 3600, "burn": true,
+	}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	id, _ := created["id"].(string)
+	if created["url"] != "/api/v1/secret/"+id || created["key"] != nil {
+		t.Errorf("unexpected response %v", created)
+	}
+	if created["expiredate"] != float64(c.Now().Add(time.Hour).Unix()) {
+		t.Errorf("expected expiredate an hour ahead, got %v", created["expiredate"])
+	}
+
+	// The PrivateBin API doesn't serve secrets, nor burn them
+	req := httptest.NewRequest(http.MethodGet, "/?"+id, nil)
+	req.Header.Set("X-Requested-With", "JSONHttpRequest")
+	prr := httptest.NewRecorder()
+	h.getPaste(prr, req, id)
+	if prr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d from the PrivateBin API, got %d", http.StatusNotFound, prr.Code)
+	}
+
+	rr, read := doSecret(h, http.MethodGet, "/"+id, nil, nil)
+	if rr.Code != http.StatusOK || read["ciphertext"] != "opaque-blob" || read["burn"] != true {
+		t.Fatalf("unexpected read %d %v", rr.Code, read)
+	}
+	if rr, _ := doSecret(h, http.MethodGet, "/"+id, nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d after burning, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestSecret_ServerEncryption tests secrets the server encrypts, and that
+// reads without the right key don't burn them.
+func TestSecret_ServerEncryption(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newSecretHandler(t)
+
+	rr, created := doSecret(h, http.MethodPost, "/", map[string]interface{}{
+		"plaintext": "hunter2", "burn": true,
+	}, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	id, _ := created["id"].(string)
+	key, _ := created["key"].(string)
+	if key == "" {
+		t.Fatal("expected a key")
+	}
+	stored, err := h.store.ReadPaste(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored.Data, "hunter2") || stored.Meta.Secret != model.SecretServer {
+		t.Errorf("expected the secret stored encrypted, got %+v", stored)
+	}
+
+	if rr, _ := doSecret(h, http.MethodGet, "/"+id, nil, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without key, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	_, wrongKey, _ := util.SealSecret(id, []byte("other"))
+	if rr, _ := doSecret(h, http.MethodGet, "/"+id, nil, map[string]string{"X-Secret-Key": wrongKey}); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d with the wrong key, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	rr, read := doSecret(h, http.MethodGet, "/"+id, nil, map[string]string{"X-Secret-Key": key})
+	if rr.Code != http.StatusOK || read["plaintext"] != "hunter2" {
+		t.Fatalf("unexpected read %d %v", rr.Code, read)
+	}
+	if h.store.PasteExists(ctx, id) {
+		t.Error("expected the secret burned")
+	}
+}
+
+// TestSecret_Expiry tests the default TTL and expired secrets.
+func TestSecret_Expiry(t *testing.T) {
+	h, c := newSecretHandler(t)
+
+	_, created := doSecret(h, http.MethodPost, "/", map[string]interface{}{"ciphertext": "opaque-blob"}, nil)
+	id, _ := created["id"].(string)
+	if created["expiredate"] != float64(c.Now().Add(7*24*time.Hour).Unix()) {
+		t.Errorf("expected the default expiration, got %v", created["expiredate"])
+	}
+
+	// Not burned, so readable until it expires
+	for i := 0; i < 2; i++ {
+		if rr, _ := doSecret(h, http.MethodGet, "/"+id, nil, nil); rr.Code != http.StatusOK {
+			t.Fatalf("read %d: expected status %d, got %d", i, http.StatusOK, rr.Code)
+		}
+	}
+	c.Advance(8 * 24 * time.Hour)
+	if rr, _ := doSecret(h, http.MethodGet, "/"+id, nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d once expired, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestSecret_CreateErrors tests secret request validation.
+func TestSecret_CreateErrors(t *testing.T) {
+	h, _ := newSecretHandler(t)
+
+	tests := []struct {
+		name             string
+		body             map[string]interface{}
+		serverEncryption bool
+	}{
+		{"no secret", map[string]interface{}{"ttl": 60}, true},
+		{"both kinds", map[string]interface{}{"ciphertext": "a", "plaintext": "b"}, true},
+		{"server encryption disabled", map[string]interface{}{"plaintext": "b"}, false},
+		{"negative TTL", map[string]interface{}{"ciphertext": "a", "ttl": -1}, true},
+		{"TTL beyond options", map[string]interface{}{"ciphertext": "a", "ttl": 2 * 365 * 24 * 3600}, true},
+		// 2^55 seconds wraps to a Duration of 0, which would never expire
+		{"overflowing TTL", map[string]interface{}{"ciphertext": "a", "ttl": json.Number("36028797018963968")}, true},
+		{"maximum TTL", map[string]interface{}{"ciphertext": "a", "ttl": json.Number("9223372036854775807")}, true},
+		{"oversized", map[string]interface{}{"ciphertext": strings.Repeat("a", 10*1024*1024+1)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.config.API.ServerEncryption = tt.serverEncryption
+			if rr, _ := doSecret(h, http.MethodPost, "/", tt.body, nil); rr.Code != http.StatusBadRequest {
+				t.Errorf("expected status %d, got %d: %s", http.StatusBadRequest, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestSecret_Delete tests deleting secrets with the delete token.
+func TestSecret_Delete(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newSecretHandler(t)
+
+	_, created := doSecret(h, http.MethodPost, "/", map[string]interface{}{"ciphertext": "opaque-blob"}, nil)
+	id, _ := created["id"].(string)
+	token, _ := created["deletetoken"].(string)
+
+	if rr, _ := doSecret(h, http.MethodDelete, "/"+id, nil, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d without token, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if rr, _ := doSecret(h, http.MethodDelete, "/"+id, nil, map[string]string{"X-Delete-Token": "wrong"}); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d with the wrong token, got %d", http.StatusForbidden, rr.Code)
+	}
+	if rr, _ := doSecret(h, http.MethodDelete, "/"+id, nil, map[string]string{"X-Delete-Token": token}); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if h.store.PasteExists(ctx, id) {
+		t.Error("expected the secret deleted")
+	}
+
+	// Regular pastes aren't deleted through the secret API
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "encrypted-content"
+	h.store.CreatePaste(ctx, pasteID, paste)
+	pasteToken, _ := util.GenerateDeleteToken(pasteID, h.salt)
+	if rr, _ := doSecret(h, http.MethodDelete, "/"+pasteID, nil, map[string]string{"X-Delete-Token": pasteToken}); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for a paste, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestSecret_Disabled tests that a secret disabled through /disable is
+// neither served nor burned until it is enabled again.
+func TestSecret_Disabled(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newSecretHandler(t)
+
+	for _, body := range []map[string]interface{}{
+		{"ciphertext": "opaque-blob", "burn": true},
+		{"plaintext": "hunter2", "burn": true},
+	} {
+		_, created := doSecret(h, http.MethodPost, "/", body, nil)
+		id, _ := created["id"].(string)
+		token, _ := created["deletetoken"].(string)
+		key, _ := created["key"].(string)
+		headers := map[string]string{headerSecretKey: key}
+
+		if rr := requestDisable(h, id, token, true); rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d disabling, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if rr, _ := doSecret(h, http.MethodGet, "/"+id, nil, headers); rr.Code != http.StatusGone {
+			t.Errorf("expected status %d reading a disabled secret, got %d", http.StatusGone, rr.Code)
+		}
+		if !h.store.PasteExists(ctx, id) {
+			t.Fatal("a refused read should not burn the secret")
+		}
+
+		if rr := requestDisable(h, id, token, false); rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d enabling, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		if rr, _ := doSecret(h, http.MethodGet, "/"+id, nil, headers); rr.Code != http.StatusOK {
+			t.Errorf("expected status %d after enabling, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Package handler provides short paste URLs.
+// With [main] urlshortener set, the URL in paste creation responses is a
+// short link from the configured shortener. A shortener that fails costs
+// the creator nothing but the short link: the full URL is returned instead.
+// Short links of the local shortener are followed here, at /s/{code}.
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/shortener"
+)
+
+// pasteURL returns the URL in paste creation responses: the paste's URL
+// without the key, or a short link for it.
+func (h *Handler) pasteURL(r *http.Request, pasteID string) string {
+	url := h.config.Main.BasePath + "/?" + pasteID
+	if h.shortener == nil {
+		return url
+	}
+
+	// External shorteners need absolute URLs; validation ensures there's
+	// a public URL for them
+	longURL := url
+	if public := h.config.Main.PublicURL; public != "" {
+		longURL = strings.TrimSuffix(public, "/") + "/?" + pasteID
+	}
+	short, err := h.shortener.Shorten(r.Context(), pasteID, longURL)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("Failed to shorten paste URL", "shortener", h.config.Main.URLShortener, "error", err)
+		return url
+	}
+	return short
+}
+
+// followShortLink handles GET /s/{code}, redirecting to the paste. The
+// browser keeps the key in the fragment, as the redirect sets none.
+func (h *Handler) followShortLink(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	local, ok := h.shortener.(*shortener.Local)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	code := chi.URLParam(r, "code")
+	pasteID, err := local.Resolve(r.Context(), code)
+	if err != nil {
+		http.Error(w, "Failed to read short link", http.StatusInternalServerError)
+		return
+	}
+	if pasteID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.store.PasteExists(ctx, pasteID) {
+		_ = local.Forget(r.Context(), code)
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, h.config.Main.BasePath+"/?"+pasteID, http.StatusFound)
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/shortener"
+)
+
+// TestShortLink_Local tests short links kept by the instance.
+func TestShortLink_Local(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Main.URLShortener = config.URLShortenerLocal
+	h.config.Main.BasePath = "/paste"
+	h.shortener = shortener.New(h.config.Main, mockStore)
+
+	resp := createSized(h, 10)
+	id, _ := resp["id"].(string)
+	url, _ := resp["url"].(string)
+	if !strings.HasPrefix(url, "/paste/s/") {
+		t.Fatalf("expected a short link, got %q", url)
+	}
+
+	follow := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, strings.TrimPrefix(url, "/paste"), nil))
+		return rr
+	}
+	rr := follow()
+	if rr.Code != http.StatusFound || rr.Header().Get("Location") != "/paste/?"+id {
+		t.Errorf("expected a redirect to the paste, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	mockStore.DeletePaste(ctx, id)
+	if rr := follow(); rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d once the paste is gone, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// TestShortLink_ShortenerDown tests that creation succeeds with the full
+// URL when the shortener fails.
+func TestShortLink_ShortenerDown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	h, _ := newTestHandler(t)
+	h.shortener = &shortener.Get{Template: srv.URL + "/?link={url}", Client: srv.Client()}
+
+	resp := createSized(h, 10)
+	id, _ := resp["id"].(string)
+	if resp["status"] != float64(0) || resp["url"] != "/?"+id {
+		t.Errorf("expected the full URL, got %v", resp)
+	}
+}
@@ -0,0 +1,79 @@
+// Package handler provides soft limit warnings.
+// A request that comes close to a hard limit ([softlimit] percent of it)
+// still succeeds, but the response lists warnings so clients can react
+// before the next request is refused, and the warnings are counted in
+// the metrics so operators see limits being approached.
+package handler
+
+import (
+	"context"
+	"fmt"
+)
+
+// Warning codes returned in the "warnings" array of a success response.
+const (
+	// WarnSizeNearLimit means the paste is close to [main] sizelimit
+	WarnSizeNearLimit = "size_near_limit"
+
+	// WarnCommentsNearLimit means the discussion is close to [main] commentlimit
+	WarnCommentsNearLimit = "comments_near_limit"
+
+	// WarnPolicyApplied means the server overrode paste options (see policy.go)
+	WarnPolicyApplied = "policy_applied"
+)
+
+// Warning is an advisory note on a successful response.
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// nearLimit reports whether value has reached percent of limit.
+// A zero percent or limit disables the check.
+func nearLimit(value, limit int64, percent int) bool {
+	if percent <= 0 || limit <= 0 {
+		return false
+	}
+	return value*100 >= limit*int64(percent)
+}
+
+// sizeWarnings checks a paste's ciphertext size against the soft limit.
+func (h *Handler) sizeWarnings(size int64) []Warning {
+	limit := h.live().Main.SizeLimit
+	if !nearLimit(size, limit, h.config.SoftLimit.Size) {
+		return nil
+	}
+	return []Warning{{
+		Code:    WarnSizeNearLimit,
+		Message: fmt.Sprintf("Paste uses %d%% of the %d byte size limit", size*100/limit, limit),
+	}}
+}
+
+// commentWarnings checks a discussion's comment count against the soft limit.
+func (h *Handler) commentWarnings(ctx context.Context, pasteID string) []Warning {
+	limit := int64(h.config.Main.CommentLimit)
+	if h.config.SoftLimit.Comments <= 0 || limit <= 0 {
+		return nil
+	}
+	count, err := h.store.CountComments(ctx, pasteID)
+	if err != nil || !nearLimit(int64(count), limit, h.config.SoftLimit.Comments) {
+		return nil
+	}
+	return []Warning{{
+		Code:    WarnCommentsNearLimit,
+		Message: fmt.Sprintf("Discussion has %d of %d allowed comments", count, limit),
+	}}
+}
+
+// addWarnings attaches warnings to a success response and counts them.
+func (h *Handler) addWarnings(response map[string]interface{}, warnings []Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+	response["warnings"] = warnings
+	if m := h.pasteMetrics; m != nil {
+		for _, warning := range warnings {
+			m.warnings.Inc(warning.Code)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// createSized creates a paste with ciphertext of the given length.
+func createSized(h *Handler, size int) map[string]interface{} {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    strings.Repeat("x", size),
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+
+	var resp map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	return resp
+}
+
+// TestSoftLimit_Size tests the warning for pastes close to the size limit.
+func TestSoftLimit_Size(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initMetrics()
+	h.config.Main.SizeLimit = 1000
+	h.config.SoftLimit.Size = 80
+
+	if resp := createSized(h, 799); resp["warnings"] != nil {
+		t.Errorf("expected no warnings below the threshold, got %v", resp["warnings"])
+	}
+
+	resp := createSized(h, 800)
+	if resp["status"] != float64(0) {
+		t.Fatalf("expected success at the threshold, got %v", resp)
+	}
+	warnings, _ := resp["warnings"].([]interface{})
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", resp["warnings"])
+	}
+	if code := warnings[0].(map[string]interface{})["code"]; code != WarnSizeNearLimit {
+		t.Errorf("expected code %q, got %v", WarnSizeNearLimit, code)
+	}
+	if got := h.pasteMetrics.warnings.Value(WarnSizeNearLimit); got != 1 {
+		t.Errorf("expected 1 counted warning, got %v", got)
+	}
+
+	// Disabled threshold
+	h.config.SoftLimit.Size = 0
+	if resp := createSized(h, 999); resp["warnings"] != nil {
+		t.Errorf("expected no warnings when disabled, got %v", resp["warnings"])
+	}
+}
+
+// TestSoftLimit_Comments tests the warning for discussions close to the comment limit.
+func TestSoftLimit_Comments(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	h.config.Main.CommentLimit = 4
+	h.config.SoftLimit.Comments = 50
+
+	pasteID := "abcdef1234567890"
+	newDiscussionPaste(mockStore, pasteID)
+
+	var resp map[string]interface{}
+	rr := postComment(h, pasteID, "first")
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp["warnings"] != nil {
+		t.Errorf("expected no warnings for 1 of 4 comments, got %v", resp["warnings"])
+	}
+
+	resp = nil
+	rr = postComment(h, pasteID, "second")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	warnings, _ := resp["warnings"].([]interface{})
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning for 2 of 4 comments, got %v", resp["warnings"])
+	}
+	if code := warnings[0].(map[string]interface{})["code"]; code != WarnCommentsNearLimit {
+		t.Errorf("expected code %q, got %v", WarnCommentsNearLimit, code)
+	}
+}
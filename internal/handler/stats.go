@@ -0,0 +1,106 @@
+// Package handler provides the public statistics endpoint.
+// /stats publishes a few aggregate figures about the instance - how many
+// pastes it holds, how many were created in the last day, their typical
+// size, and how purging is keeping up - for status pages and directory
+// sites. Nothing in it identifies a paste or a client; the average size is
+// given as a range so it doesn't track individual pastes coming and going.
+// Counting can mean reading every paste, so figures are cached for
+// [stats] cache seconds.
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// Stats is served at /stats.
+type Stats struct {
+	Pastes      int        `json:"pastes"`      // Stored and not expired
+	Created24h  int        `json:"created24h"`  // Of those, created in the last 24 hours
+	Backend     string     `json:"backend"`     // [model] class, lowercased
+	AverageSize SizeRange  `json:"averagesize"` // Average paste size, attachments included
+	Purge       PurgeStats `json:"purge"`
+	Generated   int64      `json:"generated"` // Unix time the figures were taken
+}
+
+// SizeRange is a range of sizes in bytes, one of pasteSizeBuckets.
+type SizeRange struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max,omitempty"` // Exclusive; 0 = no upper bound
+}
+
+// PurgeStats describe the purging of expired pastes.
+type PurgeStats struct {
+	LastRun int64 `json:"lastrun,omitempty"` // Unix time of the last cycle, on any instance
+	Purged  int64 `json:"purged"`            // Pastes this instance purged since it started
+}
+
+// serveStats serves the aggregate statistics, taking them anew once the
+// cached ones are [stats] cache seconds old.
+func (h *Handler) serveStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	stats, err := h.stats(ctx)
+	if err != nil {
+		slog.Error("Failed to gather stats", "error", err)
+		h.jsonError(w, "Failed to gather statistics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(h.config.Stats.Cache))
+	json.NewEncoder(w).Encode(stats)
+}
+
+// stats returns the cached statistics, or takes them if they are stale.
+// Concurrent requests wait for one backend scan rather than each starting
+// their own.
+func (h *Handler) stats(ctx context.Context) (*Stats, error) {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+
+	now := h.clock.Now()
+	maxAge := time.Duration(h.config.Stats.Cache) * time.Second
+	if h.statsCache != nil && now.Sub(time.Unix(h.statsCache.Generated, 0)) < maxAge {
+		return h.statsCache, nil
+	}
+
+	counted, err := h.store.PasteStats(ctx, now.Add(-24*time.Hour).Unix())
+	if err != nil {
+		return nil, err
+	}
+	stats := &Stats{
+		Pastes:     counted.Pastes,
+		Created24h: counted.Recent,
+		Backend:    strings.ToLower(h.config.Model.Class),
+		Purge:      PurgeStats{Purged: h.purged.Load()},
+		Generated:  now.Unix(),
+	}
+	if counted.Pastes > 0 {
+		stats.AverageSize = sizeRange(counted.Bytes / int64(counted.Pastes))
+	}
+	value, _ := h.store.GetValue(ctx, storage.NamespacePurge, purgeKey)
+	stats.Purge.LastRun, _ = strconv.ParseInt(value, 10, 64)
+
+	h.statsCache = stats
+	return stats, nil
+}
+
+// sizeRange returns the bucket of pasteSizeBuckets size falls in.
+func sizeRange(size int64) SizeRange {
+	var r SizeRange
+	for _, bound := range pasteSizeBuckets {
+		if size < int64(bound) {
+			r.Max = int64(bound)
+			return r
+		}
+		r.Min = int64(bound)
+	}
+	return r
+}
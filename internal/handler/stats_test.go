@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// getStats requests /stats through the router and decodes the response.
+func getStats(t *testing.T, h *Handler) Stats {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var stats Stats
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decoding stats: %v", err)
+	}
+	return stats
+}
+
+// TestServeStats tests the figures /stats reports and that they are
+// cached for [stats] cache seconds.
+func TestServeStats(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	h.config.Stats = config.StatsConfig{Enabled: true, Cache: 60}
+	h.config.Model.Class = "Database"
+	c := clock.NewFake(time.Unix(1_700_000_000, 0))
+	h.SetClock(c)
+
+	for i, age := range []time.Duration{time.Hour, 48 * time.Hour} {
+		paste := model.NewPaste()
+		paste.Data = strings.Repeat("x", 5000)
+		paste.Meta.PostDate = c.Now().Add(-age).Unix()
+		mockStore.CreatePaste(ctx, "abcdef123456789"+string(rune('0'+i)), paste)
+	}
+	mockStore.SetValue(ctx, storage.NamespacePurge, purgeKey, "1699999000")
+	h.purged.Add(3)
+
+	stats := getStats(t, h)
+	if stats.Pastes != 2 || stats.Created24h != 1 {
+		t.Errorf("expected 2 pastes, 1 created in 24h, got %d and %d", stats.Pastes, stats.Created24h)
+	}
+	if stats.Backend != "database" {
+		t.Errorf("expected backend database, got %q", stats.Backend)
+	}
+	if stats.AverageSize != (SizeRange{Min: 4096, Max: 16384}) {
+		t.Errorf("expected average size 4-16 KiB, got %+v", stats.AverageSize)
+	}
+	if stats.Purge.LastRun != 1699999000 || stats.Purge.Purged != 3 {
+		t.Errorf("unexpected purge stats %+v", stats.Purge)
+	}
+
+	// Cached until [stats] cache seconds have passed
+	mockStore.CreatePaste(ctx, "0123456789abcdef", model.NewPaste())
+	if stats := getStats(t, h); stats.Pastes != 2 {
+		t.Errorf("expected cached count 2, got %d", stats.Pastes)
+	}
+	c.Advance(time.Minute)
+	if stats := getStats(t, h); stats.Pastes != 3 {
+		t.Errorf("expected fresh count 3, got %d", stats.Pastes)
+	}
+}
+
+// TestServeStats_Disabled tests that /stats isn't mounted by default.
+func TestServeStats_Disabled(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rr.Code == http.StatusOK {
+		t.Errorf("expected /stats to be unmounted, got status %d", rr.Code)
+	}
+}
+
+// TestSizeRange tests bucketing of average sizes.
+func TestSizeRange(t *testing.T) {
+	tests := []struct {
+		size int64
+		want SizeRange
+	}{
+		{0, SizeRange{Max: 1024}},
+		{1023, SizeRange{Max: 1024}},
+		{1024, SizeRange{Min: 1024, Max: 4096}},
+		{5 << 20, SizeRange{Min: 4 << 20, Max: 16 << 20}},
+		{1 << 30, SizeRange{Min: 64 << 20}},
+	}
+	for _, tt := range tests {
+		if got := sizeRange(tt.size); got != tt.want {
+			t.Errorf("sizeRange(%d) = %+v, want %+v", tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Package handler provides streamed JSON responses.
+// A paste with a long discussion used to be answered by copying every
+// comment into a map and encoding the whole response at once, so memory
+// use peaked at several times the size of the discussion on every read.
+// Responses are now written in parts: the paste's own fields first, then
+// each comment as it is encoded, then attachments stored apart.
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/liskl/flashpaper/internal/icon"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// objectStream writes a JSON object in parts: the fields of a document,
+// followed by fields whose values are written as they're produced.
+type objectStream struct {
+	w     io.Writer
+	empty bool // No field written yet
+}
+
+// openObject writes doc, which must encode as a JSON object, without its
+// closing brace. Further fields go after it.
+func openObject(w io.Writer, doc interface{}) (*objectStream, error) {
+	prefix, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefix) < 2 || prefix[len(prefix)-1] != '}' {
+		return nil, errors.New("document is not a JSON object")
+	}
+	if _, err := w.Write(prefix[:len(prefix)-1]); err != nil {
+		return nil, err
+	}
+	return &objectStream{w: w, empty: len(prefix) == 2}, nil
+}
+
+// field starts a field. The caller writes its value next.
+func (o *objectStream) field(name string) error {
+	key, _ := json.Marshal(name)
+	prefix := ","
+	if o.empty {
+		prefix = ""
+		o.empty = false
+	}
+	_, err := io.WriteString(o.w, prefix+string(key)+":")
+	return err
+}
+
+// close writes the closing brace and a newline, as json.Encoder would.
+func (o *objectStream) close() error {
+	_, err := io.WriteString(o.w, "}\n")
+	return err
+}
+
+// commentSource calls yield for each comment of a response in turn,
+// stopping at the first error.
+type commentSource func(yield func(*model.Comment) error) error
+
+// commentsFrom returns a commentSource over comments already read.
+func commentsFrom(comments []*model.Comment) commentSource {
+	return func(yield func(*model.Comment) error) error {
+		for _, c := range comments {
+			if err := yield(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// withIcons returns a commentSource over the comments of src with their
+// icons rendered in style. Comments whose icon can't be rendered go without.
+func withIcons(src commentSource, style string) commentSource {
+	if style == icon.StyleNone {
+		return src
+	}
+	return func(yield func(*model.Comment) error) error {
+		return src(func(c *model.Comment) error {
+			c.Meta.Icon, _ = icon.DataURI(style, c.Vizhash)
+			return yield(c)
+		})
+	}
+}
+
+// commentEntry is a comment in a paste response, in PrivateBin's format.
+type commentEntry struct {
+	ID       string           `json:"id"`
+	ParentID string           `json:"parentid"`
+	PasteID  string           `json:"pasteid"`
+	Data     string           `json:"data"`
+	AData    json.RawMessage  `json:"adata"`
+	Version  int              `json:"v"`
+	Meta     commentEntryMeta `json:"meta"`
+}
+
+type commentEntryMeta struct {
+	PostDate int64  `json:"postdate"`
+	Vizhash  string `json:"vizhash"`
+	Icon     string `json:"icon,omitempty"`
+	Flagged  bool   `json:"flagged,omitempty"`
+}
+
+// writeComments writes the "comments" array and "comment_count" fields,
+// encoding each comment as comments yields it. Neither field is written if
+// there are no comments.
+func writeComments(o *objectStream, comments commentSource) error {
+	count := 0
+	err := comments(func(c *model.Comment) error {
+		open := ","
+		if count == 0 {
+			if err := o.field("comments"); err != nil {
+				return err
+			}
+			open = "["
+		}
+		entry, err := json.Marshal(commentEntry{
+			ID:       c.ID,
+			ParentID: c.ParentID,
+			PasteID:  c.PasteID,
+			Data:     c.Data,
+			AData:    c.AData,
+			Version:  c.Version,
+			Meta: commentEntryMeta{
+				PostDate: c.Meta.PostDate,
+				Vizhash:  c.Vizhash,
+				Icon:     c.Meta.Icon,
+				Flagged:  c.Meta.Flagged,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(o.w, open); err != nil {
+			return err
+		}
+		if _, err := o.w.Write(entry); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err != nil || count == 0 {
+		return err
+	}
+	if _, err := io.WriteString(o.w, "]"); err != nil {
+		return err
+	}
+	if err := o.field("comment_count"); err != nil {
+		return err
+	}
+	_, err = io.WriteString(o.w, strconv.Itoa(count))
+	return err
+}
+
+// writeAttachments writes the "attachment" field with attachments streamed
+// into it: a string if there is one and asList isn't set, an array of
+// strings otherwise.
+func writeAttachments(o *objectStream, attachments []io.ReadCloser, asList bool) error {
+	if err := o.field("attachment"); err != nil {
+		return err
+	}
+	asList = asList || len(attachments) != 1
+	if asList {
+		if _, err := io.WriteString(o.w, "["); err != nil {
+			return err
+		}
+	}
+	for i, attachment := range attachments {
+		open := `"`
+		if i > 0 {
+			open = `,"`
+		}
+		if _, err := io.WriteString(o.w, open); err != nil {
+			return err
+		}
+		if _, err := io.Copy(jsonStringWriter{o.w}, attachment); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(o.w, `"`); err != nil {
+			return err
+		}
+	}
+	if asList {
+		_, err := io.WriteString(o.w, "]")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// TestWritePaste tests that streamed responses decode to the document
+// encoding/json would have produced for the whole response.
+func TestWritePaste(t *testing.T) {
+	comment := func(id string, flagged bool) *model.Comment {
+		c := model.NewComment("abcdef1234567890")
+		c.ID = id
+		c.ParentID = "abcdef1234567890"
+		c.Data = "comment <" + id + ">"
+		c.AData = json.RawMessage(`["adata"]`)
+		c.Version = 2
+		c.Vizhash = "data:image/png;base64,AAAA"
+		c.Meta.PostDate = 1_700_000_000
+		c.Meta.Flagged = flagged
+		return c
+	}
+	entry := func(c *model.Comment) map[string]interface{} {
+		meta := map[string]interface{}{"postdate": c.Meta.PostDate, "vizhash": c.Vizhash}
+		if c.Meta.Flagged {
+			meta["flagged"] = true
+		}
+		return map[string]interface{}{
+			"id": c.ID, "parentid": c.ParentID, "pasteid": c.PasteID,
+			"data": c.Data, "adata": c.AData, "v": c.Version, "meta": meta,
+		}
+	}
+	first, second := comment("1111111111111111", false), comment("2222222222222222", true)
+
+	tests := []struct {
+		name        string
+		comments    []*model.Comment
+		attachments []string
+		asList      bool
+		want        map[string]interface{}
+	}{
+		{"plain", nil, nil, false, map[string]interface{}{}},
+		{"comments", []*model.Comment{first, second}, nil, false, map[string]interface{}{
+			"comments":      []interface{}{entry(first), entry(second)},
+			"comment_count": 2,
+		}},
+		{"attachment", nil, []string{`a "quoted" file`}, false, map[string]interface{}{
+			"attachment": `a "quoted" file`,
+		}},
+		{"both", []*model.Comment{first}, []string{"a", "b"}, true, map[string]interface{}{
+			"comments":      []interface{}{entry(first)},
+			"comment_count": 1,
+			"attachment":    []string{"a", "b"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := map[string]interface{}{"status": 0, "id": "abcdef1234567890", "ct": "<ciphertext>"}
+			var attachments []io.ReadCloser
+			for _, a := range tt.attachments {
+				attachments = append(attachments, io.NopCloser(strings.NewReader(a)))
+			}
+
+			var buf bytes.Buffer
+			if err := writePaste(&buf, doc, commentsFrom(tt.comments), attachments, tt.asList); err != nil {
+				t.Fatal(err)
+			}
+
+			for k, v := range tt.want {
+				doc[k] = v
+			}
+			var got, want interface{}
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+			}
+			expected, _ := json.Marshal(doc)
+			json.Unmarshal(expected, &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %s, want %s", buf.String(), expected)
+			}
+		})
+	}
+}
+
+// TestWritePaste_CommentError tests that an error reading comments stops
+// the response.
+func TestWritePaste_CommentError(t *testing.T) {
+	failed := errors.New("storage failed")
+	comments := func(yield func(*model.Comment) error) error {
+		if err := yield(model.NewComment("abcdef1234567890")); err != nil {
+			return err
+		}
+		return failed
+	}
+
+	var buf bytes.Buffer
+	if err := writePaste(&buf, map[string]interface{}{"id": "abcdef1234567890"}, comments, nil, false); err != failed {
+		t.Errorf("expected the storage error, got %v", err)
+	}
+}
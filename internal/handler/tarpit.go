@@ -0,0 +1,88 @@
+// Package handler provides the creation denylist and its tarpit.
+// Clients whose address is on [security] denylist, or the runtime denylist
+// (see denylist.go), can't create pastes or comments. By default they get 403 Forbidden at once, which tells a bot
+// to move on to its next proxy. With [security] tarpit they get a fake
+// success instead: after a delay of several seconds, a response shaped like
+// a real one with a made-up paste ID and delete token. Nothing is stored.
+// The bot wastes its time and can't easily tell that it has been blocked.
+package handler
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// tarpitSlots bounds how many requests may be held in the tarpit at once.
+// Each one holds a connection, so past this denylisted clients get 403.
+const tarpitSlots = 64
+
+// tarpitDelay returns how long to hold a tarpitted request; replaced in tests.
+var tarpitDelay = func() time.Duration {
+	return 5*time.Second + time.Duration(rand.Int63n(int64(10*time.Second)))
+}
+
+// initDenylist parses [security] denylist. Validate has checked it.
+func (h *Handler) initDenylist() {
+	h.denylist, _ = config.ParseNetworks(h.config.Security.Denylist)
+	if h.config.Security.Tarpit {
+		h.tarpit = make(chan struct{}, tarpitSlots)
+	}
+}
+
+// denied reports whether the client is on the configured or the runtime
+// denylist.
+func (h *Handler) denied(r *http.Request) bool {
+	addr, ok := h.clientAddr(r)
+	if !ok {
+		return false
+	}
+	return containsAddr(h.denylist, addr) || containsAddr(h.runtimeDenylist(r.Context()), addr)
+}
+
+// refuseDenied answers a create request from a denylisted client.
+// pasteID is the paste a comment was posted to, or "" for a new paste.
+func (h *Handler) refuseDenied(w http.ResponseWriter, r *http.Request, pasteID string) {
+	select {
+	case h.tarpit <- struct{}{}:
+		defer func() { <-h.tarpit }()
+	default:
+		// Tarpit disabled (nil channel) or full
+		h.jsonError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	timer := time.NewTimer(tarpitDelay())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+		return
+	}
+
+	// Mirror the real responses so the decoy is indistinguishable by shape
+	id, err := util.GenerateID()
+	if err != nil {
+		h.jsonError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if pasteID == "" {
+		token, _ := util.RandomHex(32)
+		h.jsonSuccess(w, map[string]interface{}{
+			"id":          id,
+			"url":         h.config.Main.BasePath + "/?" + id,
+			"deletetoken": token,
+		})
+		return
+	}
+	token, _ := util.RandomHex(32)
+	h.jsonSuccess(w, map[string]interface{}{
+		"id":          id,
+		"url":         h.config.Main.BasePath + "/?" + pasteID,
+		"postdate":    h.clock.Now().Unix(),
+		"deletetoken": token,
+	})
+}
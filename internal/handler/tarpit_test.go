@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// withDenylist puts the test client address (192.0.2.1) on the denylist.
+func withDenylist(t *testing.T, h *Handler, tarpit bool) {
+	t.Helper()
+
+	h.config.Security.Denylist = []string{"198.51.100.7", "192.0.2.0/24"}
+	h.config.Security.Tarpit = tarpit
+	h.initDenylist()
+
+	delay := tarpitDelay
+	tarpitDelay = func() time.Duration { return 0 }
+	t.Cleanup(func() { tarpitDelay = delay })
+}
+
+// TestDenylist_Forbidden tests that denylisted clients can't create pastes.
+func TestDenylist_Forbidden(t *testing.T) {
+	h, _ := newTestHandler(t)
+	withDenylist(t, h, false)
+
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	// Other clients are unaffected
+	h.config.Security.Denylist = []string{"2001:db8::/32"}
+	h.initDenylist()
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+// TestDenylist_TarpitPaste tests the decoy response to a paste creation.
+func TestDenylist_TarpitPaste(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	withDenylist(t, h, true)
+
+	rr := createWithTOS(h, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected decoy status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var resp map[string]interface{}
+	json.NewDecoder(rr.Body).Decode(&resp)
+
+	id, _ := resp["id"].(string)
+	if resp["status"] != float64(0) || !util.ValidateID(id) {
+		t.Fatalf("expected a plausible success response, got %v", resp)
+	}
+	if token, _ := resp["deletetoken"].(string); len(token) != 64 {
+		t.Errorf("expected a 64-character delete token, got %q", token)
+	}
+	if mockStore.PasteExists(ctx, id) {
+		t.Error("expected nothing to be stored for a tarpitted client")
+	}
+}
+
+// TestDenylist_TarpitComment tests the decoy response to a comment.
+func TestDenylist_TarpitComment(t *testing.T) {
+	ctx := context.Background()
+	h, mockStore := newTestHandler(t)
+	pasteID := "7a4b1c2d3e4f5a6b"
+	newDiscussionPaste(mockStore, pasteID)
+	withDenylist(t, h, true)
+
+	rr := postComment(h, pasteID, "spam")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected decoy status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if comments, _ := mockStore.ReadComments(ctx, pasteID); len(comments) != 0 {
+		t.Errorf("expected no stored comments, got %d", len(comments))
+	}
+}
+
+// TestDenylist_TarpitFull tests that a full tarpit falls back to 403.
+func TestDenylist_TarpitFull(t *testing.T) {
+	h, _ := newTestHandler(t)
+	withDenylist(t, h, true)
+	for i := 0; i < tarpitSlots; i++ {
+		h.tarpit <- struct{}{}
+	}
+
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
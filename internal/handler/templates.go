@@ -0,0 +1,251 @@
+// Package handler provides template loading, reloading, and readiness
+// reporting for FlashPaper. Templates come from the embedded filesystem,
+// optionally overlaid with files from [main] templatedir, and static files
+// likewise with [main] staticdir. [main] template picks the theme: an
+// embedded one (bootstrap5, bootstrap-dark, page) or one whose manifest or
+// page template is in templatedir. Load failures are recorded rather than
+// swallowed, so /readyz can report a degraded UI while the fallback pages
+// keep the API usable. With [main] template_reload, changed files are
+// picked up on the next page or asset request.
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	flashpaper "github.com/liskl/flashpaper"
+	"github.com/liskl/flashpaper/internal/assets"
+)
+
+// loadTemplates parses the embedded templates, then the override directory.
+// An override file replaces the embedded template with the same name.
+// Templates link static files through p.
+func (h *Handler) loadTemplates(p *assets.Pipeline) (*template.Template, error) {
+	templateFS, err := flashpaper.TemplateFS()
+	if err != nil {
+		return nil, fmt.Errorf("embedded templates: %w", err)
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs(p)).ParseFS(templateFS, "*.html")
+	if err != nil {
+		return nil, fmt.Errorf("embedded templates: %w", err)
+	}
+
+	dir := h.config.Main.TemplateDir
+	if dir == "" {
+		return tmpl, nil
+	}
+	if err := checkDir(dir); err != nil {
+		return nil, fmt.Errorf("template directory: %w", err)
+	}
+	overrides, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("template directory: %w", err)
+	}
+	if len(overrides) == 0 {
+		return tmpl, nil
+	}
+	if _, err := tmpl.ParseFiles(overrides...); err != nil {
+		return nil, fmt.Errorf("template directory: %w", err)
+	}
+	return tmpl, nil
+}
+
+// checkDir returns an error unless dir is a directory.
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// loadStatic indexes the embedded static files, overlaid with [main]
+// staticdir. If the directory can't be used, the embedded files are served
+// and the error is returned with them.
+func (h *Handler) loadStatic() (fs.FS, *assets.Pipeline, error) {
+	staticFS, err := flashpaper.StaticFS()
+	if err != nil {
+		return nil, nil, fmt.Errorf("static files: %w", err)
+	}
+	var dirErr error
+	if dir := h.config.Main.StaticDir; dir != "" {
+		if dirErr = checkDir(dir); dirErr == nil {
+			staticFS = assets.Overlay(os.DirFS(dir), staticFS)
+		} else {
+			dirErr = fmt.Errorf("static directory: %w", dirErr)
+		}
+	}
+
+	pipeline, err := assets.New(staticFS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("static files: %w", err)
+	}
+	return staticFS, pipeline, dirErr
+}
+
+// loadThemeManifest loads the manifest of the [main] template theme,
+// looking in templatedir before the embedded templates. A theme without a
+// manifest but with its own page template in tmpl gets the default
+// manifest; any other name is unknown.
+func (h *Handler) loadThemeManifest(tmpl *template.Template) (*TemplateManifest, error) {
+	name := h.config.Main.Template
+	embedded, err := flashpaper.TemplateFS()
+	if err != nil {
+		return nil, fmt.Errorf("embedded templates: %w", err)
+	}
+	sources := []fs.FS{embedded}
+	if dir := h.config.Main.TemplateDir; dir != "" {
+		sources = []fs.FS{os.DirFS(dir), embedded}
+	}
+	for _, fsys := range sources {
+		if _, err := fs.Stat(fsys, name+".manifest.json"); err == nil {
+			return loadManifest(fsys, name)
+		}
+	}
+	if tmpl != nil && tmpl.Lookup(name+".html") != nil {
+		return defaultManifest(name), nil
+	}
+	return nil, fmt.Errorf("unknown template %q", name)
+}
+
+// pageTemplate returns the name of the main page's template in tmpl: the
+// theme's own <theme>.html if there is one, index.html otherwise.
+func pageTemplate(tmpl *template.Template, theme string) string {
+	if tmpl.Lookup(theme+".html") != nil {
+		return theme + ".html"
+	}
+	return "index.html"
+}
+
+// uiStamp summarizes the names, sizes, and modification times of the
+// files under dirs, so a change to any of them changes the stamp.
+func uiStamp(dirs ...string) string {
+	sum := fnv.New64a()
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				fmt.Fprintf(sum, "%s\x00%d\x00%d\x00", path, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return strconv.FormatUint(sum.Sum64(), 16)
+}
+
+// devReload reloads static files, templates, and the theme manifest when
+// files under templatedir or staticdir have changed since it last looked.
+// It does nothing without [main] template_reload. Templates that fail to
+// parse leave the previous set in use; problems are reported by /readyz.
+// Changes to a manifest's CSP allowances still need a restart.
+func (h *Handler) devReload() {
+	if !h.config.Main.TemplateReload {
+		return
+	}
+	h.devMu.Lock()
+	defer h.devMu.Unlock()
+	stamp := uiStamp(h.config.Main.TemplateDir, h.config.Main.StaticDir)
+	if stamp == h.devStamp {
+		return
+	}
+	h.devStamp = stamp
+
+	staticFS, pipeline, staticErr := h.loadStatic()
+	if pipeline == nil {
+		pipeline = h.currentAssets()
+	}
+	tmpl, templateErr := h.loadTemplates(pipeline)
+	manifest, manifestErr := h.loadThemeManifest(tmpl)
+
+	h.templateMu.Lock()
+	defer h.templateMu.Unlock()
+	if staticFS != nil {
+		h.staticFS, h.assets = staticFS, pipeline
+	}
+	if tmpl != nil {
+		h.template = tmpl
+	}
+	if manifest != nil {
+		h.manifest = manifest
+	}
+	h.staticErr = staticErr
+	h.templateErr = errors.Join(templateErr, manifestErr)
+	if err := errors.Join(staticErr, h.templateErr); err != nil {
+		slog.Warn("UI files changed but didn't all load", "error", err)
+		return
+	}
+	slog.Info("Reloaded changed UI files")
+}
+
+// currentAssets returns the static file pipeline in use (nil if none).
+func (h *Handler) currentAssets() *assets.Pipeline {
+	h.templateMu.RLock()
+	defer h.templateMu.RUnlock()
+	return h.assets
+}
+
+// serveStatic serves static files through the current pipeline.
+func (h *Handler) serveStatic(w http.ResponseWriter, r *http.Request) {
+	h.devReload()
+	h.currentAssets().ServeHTTP(w, r)
+}
+
+// currentTemplate returns the template set in use (nil if none loaded).
+func (h *Handler) currentTemplate() *template.Template {
+	h.templateMu.RLock()
+	defer h.templateMu.RUnlock()
+	return h.template
+}
+
+// Ready reports why the UI is degraded, or nil if it's fully available.
+// Errors come from initialization of the static files and templates.
+func (h *Handler) Ready() error {
+	h.templateMu.RLock()
+	defer h.templateMu.RUnlock()
+	return errors.Join(h.staticErr, h.templateErr)
+}
+
+// reloadTemplates handles POST /admin/templates/reload.
+// A set that fails to parse is rejected and the current one stays in use,
+// so a typo in an override can't take down a working UI.
+func (h *Handler) reloadTemplates(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := h.loadTemplates(h.currentAssets())
+	if err != nil {
+		h.jsonError(w, "Templates not reloaded: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	h.templateMu.Lock()
+	h.template = tmpl
+	h.templateErr = nil
+	h.templateMu.Unlock()
+
+	var names []string
+	for _, t := range tmpl.Templates() {
+		if t.Name() != "" {
+			names = append(names, t.Name())
+		}
+	}
+	sort.Strings(names)
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"templates": names,
+	})
+}
@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLoadTemplates_Override tests that override files replace embedded ones.
+func TestLoadTemplates_Override(t *testing.T) {
+	h, _ := newTestHandler(t)
+	dir := t.TempDir()
+	h.config.Main.TemplateDir = dir
+
+	if err := os.WriteFile(filepath.Join(dir, "docs.html"), []byte("custom docs"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.initTemplates()
+
+	if err := h.Ready(); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.serveDocs(rr, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if got := rr.Body.String(); got != "custom docs" {
+		t.Errorf("expected override body, got %q", got)
+	}
+}
+
+// TestReadyz tests the readiness endpoint in healthy and degraded states.
+func TestReadyz(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initTemplates()
+
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rr
+	}
+
+	if rr := get(); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	// A missing override directory degrades the UI
+	h.config.Main.TemplateDir = filepath.Join(t.TempDir(), "missing")
+	h.initTemplates()
+
+	rr := get()
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	var resp struct {
+		Status string   `json:"status"`
+		Errors []string `json:"errors"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Status != "degraded" || len(resp.Errors) != 1 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if !strings.Contains(resp.Errors[0], "template directory") {
+		t.Errorf("expected template directory error, got %q", resp.Errors[0])
+	}
+}
+
+// TestReloadTemplates tests re-parsing templates through the admin API.
+func TestReloadTemplates(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+	dir := t.TempDir()
+	h.config.Main.TemplateDir = filepath.Join(dir, "missing")
+	h.initTemplates()
+
+	if h.Ready() == nil {
+		t.Fatal("expected degraded state before reload")
+	}
+
+	// Fixing the directory and reloading clears the degraded state
+	h.config.Main.TemplateDir = dir
+	if err := os.WriteFile(filepath.Join(dir, "docs.html"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rr := adminRequest(h, http.MethodPost, "/admin/templates/reload", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"docs.html"`) {
+		t.Errorf("expected template list in response, got %s", rr.Body.String())
+	}
+	if err := h.Ready(); err != nil {
+		t.Errorf("expected ready after reload, got %v", err)
+	}
+
+	// A broken override is rejected and the previous set stays in use
+	if err := os.WriteFile(filepath.Join(dir, "docs.html"), []byte("{{.Broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rr = adminRequest(h, http.MethodPost, "/admin/templates/reload", testAdminToken, nil)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected status %d, got %d", http.StatusUnprocessableEntity, rr.Code)
+	}
+	if err := h.Ready(); err != nil {
+		t.Errorf("expected failed reload to keep ready state, got %v", err)
+	}
+
+	docs := httptest.NewRecorder()
+	h.serveDocs(docs, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if got := docs.Body.String(); got != "v1" {
+		t.Errorf("expected previous templates to stay in use, got %q", got)
+	}
+
+	// Requires the admin token
+	if rr := adminRequest(h, http.MethodPost, "/admin/templates/reload", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without token, got %d", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+// serveTheme loads the UI with the given [main] template and returns the
+// main page.
+func serveTheme(t *testing.T, h *Handler, theme string) string {
+	t.Helper()
+	h.config.Main.Template = theme
+	h.initStaticFS()
+	h.initTemplates()
+	h.initManifest()
+
+	rr := httptest.NewRecorder()
+	h.serveUI(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	return rr.Body.String()
+}
+
+// TestServeUI_Themes tests the embedded themes and an unknown one.
+func TestServeUI_Themes(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	body := serveTheme(t, h, "bootstrap-dark")
+	if err := h.Ready(); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+	if !strings.Contains(body, `<html lang="en" data-theme="dark">`) {
+		t.Error("expected bootstrap-dark to default to the dark theme")
+	}
+
+	body = serveTheme(t, h, "page")
+	if !strings.Contains(body, h.assets.URL("css/page.css")) {
+		t.Error("expected the page theme to load its stylesheet")
+	}
+	if strings.Contains(body, `<html lang="en" data-theme=`) {
+		t.Error("expected the page theme to leave the default theme to the browser")
+	}
+
+	// An unknown theme still serves the default page, but isn't ready
+	body = serveTheme(t, h, "nosuchtheme")
+	if !strings.Contains(body, h.assets.URL("js/flashpaper.js")) {
+		t.Error("expected the default page for an unknown theme")
+	}
+	if err := h.Ready(); err == nil || !strings.Contains(err.Error(), `unknown template "nosuchtheme"`) {
+		t.Errorf("expected an unknown template error, got %v", err)
+	}
+}
+
+// TestServeUI_ThemePage tests a theme defined by its own page template in
+// templatedir.
+func TestServeUI_ThemePage(t *testing.T) {
+	h, _ := newTestHandler(t)
+	dir := t.TempDir()
+	h.config.Main.TemplateDir = dir
+	if err := os.WriteFile(filepath.Join(dir, "mine.html"), []byte(`mine {{asset "js/flashpaper.js"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := serveTheme(t, h, "mine")
+	if err := h.Ready(); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+	if body != "mine "+h.assets.URL("js/flashpaper.js") {
+		t.Errorf("expected the theme's page, got %q", body)
+	}
+}
+
+// TestStaticDir tests that files in staticdir replace embedded ones.
+func TestStaticDir(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.initStaticFS()
+	embeddedURL := h.assets.URL("css/style.css")
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "css"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "css", "style.css"), []byte("body { color: red; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.config.Main.StaticDir = dir
+	h.initStaticFS()
+	if h.staticErr != nil {
+		t.Fatalf("unexpected error: %v", h.staticErr)
+	}
+
+	url := h.assets.URL("css/style.css")
+	if url == embeddedURL {
+		t.Error("expected the replaced file to get a new fingerprint")
+	}
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "body { color: red; }" {
+		t.Errorf("expected the replacement to be served, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// Files staticdir doesn't replace are still served
+	rr = httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, h.assets.URL("js/flashpaper.js"), nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected embedded files to be served, got %d", rr.Code)
+	}
+
+	// A missing directory falls back to the embedded files
+	h.config.Main.StaticDir = filepath.Join(dir, "missing")
+	h.initStaticFS()
+	if h.staticErr == nil || !strings.Contains(h.staticErr.Error(), "static directory") {
+		t.Errorf("expected a static directory error, got %v", h.staticErr)
+	}
+	if h.assets.URL("css/style.css") != embeddedURL {
+		t.Error("expected the embedded files after a failed overlay")
+	}
+}
+
+// TestTemplateReload tests that changed templates are picked up on the
+// next request with template_reload, and only with it.
+func TestTemplateReload(t *testing.T) {
+	h, _ := newTestHandler(t)
+	dir := t.TempDir()
+	h.config.Main.TemplateDir = dir
+	docs := filepath.Join(dir, "docs.html")
+	if err := os.WriteFile(docs, []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.initStaticFS()
+	h.initTemplates()
+	h.devStamp = uiStamp(dir)
+
+	serve := func() string {
+		rr := httptest.NewRecorder()
+		h.serveDocs(rr, httptest.NewRequest(http.MethodGet, "/docs", nil))
+		return rr.Body.String()
+	}
+
+	if err := os.WriteFile(docs, []byte("version 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := serve(); got != "v1" {
+		t.Errorf("expected no reload without template_reload, got %q", got)
+	}
+
+	h.config.Main.TemplateReload = true
+	if got := serve(); got != "version 2" {
+		t.Errorf("expected the changed template, got %q", got)
+	}
+
+	// A broken template keeps the last good set and degrades readiness
+	if err := os.WriteFile(docs, []byte("{{.Broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := serve(); got != "version 2" {
+		t.Errorf("expected the previous templates to stay in use, got %q", got)
+	}
+	if h.Ready() == nil {
+		t.Error("expected a broken template to degrade readiness")
+	}
+}
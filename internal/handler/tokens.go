@@ -0,0 +1,162 @@
+// Package handler provides API token authentication and usage accounting.
+// Teams sharing an instance each get a named token ([tokens]) and send it
+// as "Authorization: Bearer <secret>" when creating pastes. Creations are
+// counted per token and calendar month (UTC), both as pastes and as bytes,
+// for chargeback; [token_quota_pastes] and [token_quota_bytes] cap them so
+// one team can't exhaust the instance for the others. Requests without a
+// token stay anonymous and are not accounted.
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// Error codes for token authentication and quotas.
+const (
+	ErrCodeInvalidToken  = "invalid_token"
+	ErrCodeQuotaExceeded = "quota_exceeded"
+)
+
+// errQuotaExceeded is returned when a creation would exceed a token quota.
+var errQuotaExceeded = errors.New("token quota exceeded")
+
+// tokenUsage is a token's consumption in one accounting period.
+type tokenUsage struct {
+	Pastes int64 `json:"pastes"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// usagePeriod returns the accounting period containing t.
+func usagePeriod(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// tokenUsageKey is the storage key of a token's usage in a period.
+// Keys double as file names in the Filesystem backend; config.Validate
+// restricts token names accordingly.
+func tokenUsageKey(name, period string) string {
+	return name + "." + period
+}
+
+// apiToken identifies the API token a request carries.
+// It returns "" and true for anonymous requests, and false if the request
+// carries a bearer secret matching no configured token.
+func (h *Handler) apiToken(r *http.Request) (string, bool) {
+	if !h.config.Tokens.Enabled() {
+		return "", true
+	}
+	secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", true
+	}
+
+	// Compare against every token so timing doesn't reveal which one matched
+	var name string
+	for candidate, want := range h.config.Tokens.Secrets {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(want)) == 1 {
+			name = candidate
+		}
+	}
+	return name, name != ""
+}
+
+// loadTokenUsage reads a token's usage in a period (zero if none).
+func (h *Handler) loadTokenUsage(ctx context.Context, name, period string) tokenUsage {
+	var usage tokenUsage
+	if value, _ := h.store.GetValue(ctx, storage.NamespaceTokenUsage, tokenUsageKey(name, period)); value != "" {
+		_ = json.Unmarshal([]byte(value), &usage)
+	}
+	return usage
+}
+
+// saveTokenUsage stores a token's usage in a period.
+func (h *Handler) saveTokenUsage(ctx context.Context, name, period string, usage tokenUsage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return h.store.SetValue(ctx, storage.NamespaceTokenUsage, tokenUsageKey(name, period), string(data))
+}
+
+// reserveTokenUsage counts a paste of size bytes against a token's quotas.
+// The paste is counted before it is stored so concurrent creations can't
+// overshoot a quota together; call releaseTokenUsage if storing fails.
+// Returns the period the paste was counted in.
+func (h *Handler) reserveTokenUsage(ctx context.Context, name string, size int64) (string, error) {
+	period := usagePeriod(h.clock.Now())
+
+	h.tokenMu.Lock()
+	defer h.tokenMu.Unlock()
+
+	usage := h.loadTokenUsage(ctx, name, period)
+	if quota := h.config.Tokens.QuotaPastes[name]; quota > 0 && usage.Pastes+1 > quota {
+		return "", fmt.Errorf("%w: %d pastes per month", errQuotaExceeded, quota)
+	}
+	if quota := h.config.Tokens.QuotaBytes[name]; quota > 0 && usage.Bytes+size > quota {
+		return "", fmt.Errorf("%w: %d bytes per month", errQuotaExceeded, quota)
+	}
+
+	usage.Pastes++
+	usage.Bytes += size
+	if err := h.saveTokenUsage(ctx, name, period, usage); err != nil {
+		return "", err
+	}
+	return period, nil
+}
+
+// releaseTokenUsage undoes a reservation for a paste that wasn't stored.
+func (h *Handler) releaseTokenUsage(ctx context.Context, name, period string, size int64) {
+	h.tokenMu.Lock()
+	defer h.tokenMu.Unlock()
+
+	usage := h.loadTokenUsage(ctx, name, period)
+	usage.Pastes = max(usage.Pastes-1, 0)
+	usage.Bytes = max(usage.Bytes-size, 0)
+	_ = h.saveTokenUsage(ctx, name, period, usage)
+}
+
+// getTokenUsage handles GET /admin/tokens.
+// The optional period query parameter (YYYY-MM) selects a past month.
+func (h *Handler) getTokenUsage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = usagePeriod(h.clock.Now())
+	} else if _, err := time.Parse("2006-01", period); err != nil {
+		h.jsonError(w, "Invalid period, expected YYYY-MM", http.StatusBadRequest)
+		return
+	}
+
+	names := make([]string, 0, len(h.config.Tokens.Secrets))
+	for name := range h.config.Tokens.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tokens := make([]map[string]interface{}, 0, len(names))
+	for _, name := range names {
+		usage := h.loadTokenUsage(ctx, name, period)
+		tokens = append(tokens, map[string]interface{}{
+			"name":         name,
+			"pastes":       usage.Pastes,
+			"bytes":        usage.Bytes,
+			"quota_pastes": h.config.Tokens.QuotaPastes[name],
+			"quota_bytes":  h.config.Tokens.QuotaBytes[name],
+		})
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"period": period,
+		"tokens": tokens,
+	})
+}
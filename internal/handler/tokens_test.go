@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+const testAPIToken = "team-a-token-0123456789"
+
+// createWithToken creates a paste of the given size, sending secret as API token.
+func createWithToken(h *Handler, secret string, size int) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    strings.Repeat("x", size),
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set("Authorization", "Bearer "+secret)
+	}
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr
+}
+
+// withTokens configures a single API token named "team-a".
+func withTokens(h *Handler) {
+	h.config.Tokens.Secrets = map[string]string{"team-a": testAPIToken}
+	h.config.Tokens.QuotaPastes = map[string]int64{}
+	h.config.Tokens.QuotaBytes = map[string]int64{}
+}
+
+// TestTokens_Accounting tests that creations are counted per token.
+func TestTokens_Accounting(t *testing.T) {
+	h, _ := newTestHandler(t)
+	withTokens(h)
+
+	for _, size := range []int{100, 250} {
+		if rr := createWithToken(h, testAPIToken, size); rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Anonymous creations are not accounted
+	if rr := createWithToken(h, "", 500); rr.Code != http.StatusOK {
+		t.Fatalf("expected anonymous creation to succeed, got %d", rr.Code)
+	}
+
+	h.config.Admin.Token = testAdminToken
+	rr := adminRequest(h, http.MethodGet, "/admin/tokens", testAdminToken, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Period string `json:"period"`
+		Tokens []struct {
+			Name   string `json:"name"`
+			Pastes int64  `json:"pastes"`
+			Bytes  int64  `json:"bytes"`
+		} `json:"tokens"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Tokens) != 1 || resp.Tokens[0].Name != "team-a" {
+		t.Fatalf("unexpected tokens: %+v", resp.Tokens)
+	}
+	if resp.Tokens[0].Pastes != 2 || resp.Tokens[0].Bytes != 350 {
+		t.Errorf("expected 2 pastes and 350 bytes, got %+v", resp.Tokens[0])
+	}
+
+	// Past periods are empty
+	rr = adminRequest(h, http.MethodGet, "/admin/tokens?period=2000-01", testAdminToken, nil)
+	if !strings.Contains(rr.Body.String(), `"pastes":0`) {
+		t.Errorf("expected no usage in 2000-01, got %s", rr.Body.String())
+	}
+	if rr := adminRequest(h, http.MethodGet, "/admin/tokens?period=last", testAdminToken, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for bad period, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// TestTokens_InvalidToken tests that unknown bearer secrets are refused.
+func TestTokens_InvalidToken(t *testing.T) {
+	h, mockStore := newTestHandler(t)
+	withTokens(h)
+
+	rr := createWithToken(h, "not-a-configured-token", 100)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d, got %d", http.StatusUnauthorized, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), ErrCodeInvalidToken) {
+		t.Errorf("expected code %q, got %s", ErrCodeInvalidToken, rr.Body.String())
+	}
+	if mockStore.GetPasteCount() != 0 {
+		t.Error("expected no paste to be stored")
+	}
+}
+
+// TestTokens_Quotas tests the per-token paste and byte quotas.
+func TestTokens_Quotas(t *testing.T) {
+	h, _ := newTestHandler(t)
+	withTokens(h)
+	h.config.Tokens.QuotaPastes["team-a"] = 2
+	h.config.Tokens.QuotaBytes["team-a"] = 1000
+
+	if rr := createWithToken(h, testAPIToken, 900); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	rr := createWithToken(h, testAPIToken, 200)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected byte quota to refuse with %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), ErrCodeQuotaExceeded) {
+		t.Errorf("expected code %q, got %s", ErrCodeQuotaExceeded, rr.Body.String())
+	}
+
+	if rr := createWithToken(h, testAPIToken, 100); rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr := createWithToken(h, testAPIToken, 1); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected paste quota to refuse with %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+
+	// Other clients are unaffected
+	if rr := createWithToken(h, "", 100); rr.Code != http.StatusOK {
+		t.Errorf("expected anonymous creation to succeed, got %d", rr.Code)
+	}
+}
+
+// TestTokens_RateLimit tests that a token's own rate limits replace the
+// anonymous ones for its creations only.
+func TestTokens_RateLimit(t *testing.T) {
+	h, _ := newTestHandler(t)
+	withTokens(h)
+	h.config.Traffic.Limit = 60
+	h.config.Traffic.Burst = 1
+	h.config.Traffic.Store = config.TrafficStoreMemory
+	h.config.Tokens.RateBurst = map[string]int64{"team-a": 3}
+
+	for i := 0; i < 3; i++ {
+		if rr := createWithToken(h, testAPIToken, 100); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected status %d, got %d: %s", i+1, http.StatusOK, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := createWithToken(h, testAPIToken, 100); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the token's burst to run out, got %d", rr.Code)
+	}
+
+	// Anonymous clients keep their own bucket and limits
+	if rr := createWithToken(h, "", 100); rr.Code != http.StatusOK {
+		t.Fatalf("expected anonymous creation to succeed, got %d", rr.Code)
+	}
+	if rr := createWithToken(h, "", 100); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the anonymous limit to apply, got %d", rr.Code)
+	}
+
+	// 0 lifts the limit for the token
+	h.config.Tokens.RateLimit = map[string]int64{"team-a": 0}
+	for i := 0; i < 10; i++ {
+		if rr := createWithToken(h, testAPIToken, 100); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: expected unlimited token, got %d", i+1, rr.Code)
+		}
+	}
+}
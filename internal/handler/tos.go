@@ -0,0 +1,78 @@
+// Package handler provides the terms-of-service document and acceptance gate.
+// Operators of public instances may need users to acknowledge terms before
+// publishing. When configured, the document is served at /tos and, if
+// acceptance is required, paste creation is refused unless the client sends
+// "tos_accepted": true alongside the paste.
+package handler
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrCodeTOSNotAccepted is the error code returned when a paste is created
+// without accepting the terms of service. Clients match on it to prompt the
+// user instead of showing a generic failure.
+const ErrCodeTOSNotAccepted = "tos_not_accepted"
+
+// tosDocument is the loaded terms-of-service file.
+type tosDocument struct {
+	content     []byte
+	contentType string
+}
+
+// initTOS reads the configured terms-of-service file.
+// The file is read once at startup; a read failure leaves /tos returning 503
+// rather than stopping the server, matching how templates degrade.
+func (h *Handler) initTOS() {
+	path := h.config.TOS.File
+	if path == "" {
+		return
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	h.tos = &tosDocument{
+		content:     content,
+		contentType: tosContentType(path),
+	}
+}
+
+// tosContentType picks the media type from the file extension.
+// HTML documents are served as-is; everything else is treated as Markdown.
+func tosContentType(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		return "text/html; charset=utf-8"
+	default:
+		return "text/markdown; charset=utf-8"
+	}
+}
+
+// serveTOS handles GET /tos.
+func (h *Handler) serveTOS(w http.ResponseWriter, r *http.Request) {
+	if h.tos == nil {
+		http.Error(w, "Terms of service not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", h.tos.contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(h.tos.content)
+}
+
+// tosAccepted reports whether a create request satisfies the terms gate.
+// Only a JSON true counts; "true" strings and 1 are rejected so clients
+// can't acknowledge by accident.
+func (h *Handler) tosAccepted(req map[string]interface{}) bool {
+	if !h.config.TOS.Required {
+		return true
+	}
+	accepted, _ := req["tos_accepted"].(bool)
+	return accepted
+}
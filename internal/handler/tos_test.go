@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withTOS configures a terms-of-service file for the handler.
+func withTOS(t *testing.T, h *Handler, name, content string, required bool) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write tos file: %v", err)
+	}
+	h.config.TOS.File = path
+	h.config.TOS.Required = required
+	h.initTOS()
+}
+
+// createWithTOS posts a paste, optionally setting tos_accepted.
+func createWithTOS(h *Handler, accepted interface{}) *httptest.ResponseRecorder {
+	request := map[string]interface{}{
+		"v":     2,
+		"ct":    "encrypted-content",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day"},
+	}
+	if accepted != nil {
+		request["tos_accepted"] = accepted
+	}
+
+	body, _ := json.Marshal(request)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.handlePost(rr, req)
+	return rr
+}
+
+// TestTOS_Serve tests serving the document with the right content type.
+func TestTOS_Serve(t *testing.T) {
+	tests := []struct {
+		file        string
+		contentType string
+	}{
+		{"tos.md", "text/markdown; charset=utf-8"},
+		{"tos.HTML", "text/html; charset=utf-8"},
+		{"tos.txt", "text/markdown; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			h, _ := newTestHandler(t)
+			withTOS(t, h, tt.file, "# Terms\n\nBe nice.", false)
+
+			req := httptest.NewRequest(http.MethodGet, "/tos", nil)
+			rr := httptest.NewRecorder()
+			h.Routes().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.contentType, ct)
+			}
+			if !strings.Contains(rr.Body.String(), "Be nice.") {
+				t.Errorf("unexpected body %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestTOS_NotConfigured tests that /tos is absent without a file.
+func TestTOS_NotConfigured(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/tos", nil)
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status %d, got %d", http.StatusNotFound, rr.Code)
+	}
+	if h.clientConfig(ctx).TOS != nil {
+		t.Error("expected no tos in client config")
+	}
+
+	// Unreadable file degrades to 503
+	h.config.TOS.File = filepath.Join(t.TempDir(), "missing.md")
+	h.initTOS()
+	rr = httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d for unreadable file, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+}
+
+// TestTOS_RequiredGate tests that creation needs tos_accepted when required.
+func TestTOS_RequiredGate(t *testing.T) {
+	ctx := context.Background()
+	h, _ := newTestHandler(t)
+	withTOS(t, h, "tos.md", "# Terms", true)
+
+	for _, accepted := range []interface{}{nil, false, "true", 1} {
+		rr := createWithTOS(h, accepted)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("tos_accepted=%v: expected status %d, got %d", accepted, http.StatusForbidden, rr.Code)
+			continue
+		}
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		if response["code"] != ErrCodeTOSNotAccepted {
+			t.Errorf("tos_accepted=%v: expected code %q, got %v", accepted, ErrCodeTOSNotAccepted, response["code"])
+		}
+	}
+
+	if rr := createWithTOS(h, true); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d when accepted, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	cfg := h.clientConfig(ctx)
+	if cfg.TOS == nil || !cfg.TOS.Required || cfg.TOS.URL != "/tos" {
+		t.Errorf("unexpected client tos config %+v", cfg.TOS)
+	}
+}
+
+// TestTOS_NotRequired tests that an optional document doesn't gate creation.
+func TestTOS_NotRequired(t *testing.T) {
+	h, _ := newTestHandler(t)
+	withTOS(t, h, "tos.md", "# Terms", false)
+
+	if rr := createWithTOS(h, nil); rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+}
@@ -0,0 +1,34 @@
+// Package handler provides the webhook subscriber. Lifecycle events are
+// translated into webhook payloads and queued; the webhook package makes
+// the requests, so publishing never waits on the operator's endpoints.
+package handler
+
+import (
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/webhook"
+)
+
+// webhookEvent queues the events webhooks report. A paste deleted after
+// being read once counts as deleted; one found past its expiration time,
+// as expired.
+func (h *Handler) webhookEvent(e events.Event) {
+	p := webhook.Payload{
+		PasteID:   e.PasteID,
+		CommentID: e.CommentID,
+		Time:      e.Time.Unix(),
+	}
+	switch e.Kind {
+	case events.PasteCreated:
+		p.Event = webhook.PasteCreated
+	case events.PasteDeleted:
+		p.Event = webhook.PasteDeleted
+		if e.Reason == events.ReasonExpired {
+			p.Event = webhook.PasteExpired
+		}
+	case events.CommentCreated:
+		p.Event = webhook.CommentCreated
+	default:
+		return
+	}
+	h.webhooks.Send(p)
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/events"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/webhook"
+)
+
+// TestWebhookEvent tests which lifecycle events reach webhooks, and as what.
+func TestWebhookEvent(t *testing.T) {
+	var mu sync.Mutex
+	var got []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		got = append(got, payload)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	h, _ := newTestHandler(t)
+	cfg := config.DefaultConfig().Webhook
+	cfg.URLs = []string{srv.URL}
+	cfg.Workers = 1 // Deliver in order
+	h.webhooks = webhook.New(cfg)
+
+	now := time.Unix(1700000000, 0)
+	paste := &model.Paste{ID: "abcdef1234567890", Data: "ciphertext"}
+	for _, e := range []events.Event{
+		{Kind: events.PasteCreated, PasteID: paste.ID, Paste: paste, Expire: "1day"},
+		{Kind: events.PasteRead, PasteID: paste.ID, Paste: paste, First: true},
+		{Kind: events.CommentCreated, PasteID: paste.ID, CommentID: "0123456789abcdef"},
+		{Kind: events.PasteDeleted, PasteID: paste.ID, Reason: events.ReasonBurned},
+		{Kind: events.PasteDeleted, PasteID: paste.ID, Reason: events.ReasonExpired},
+		{Kind: events.PurgeCompleted, Purged: 3},
+	} {
+		e.Time = now
+		h.publish(e)
+	}
+	h.Wait()
+
+	want := []string{"paste.created", "comment.created", "paste.deleted", "paste.expired"}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d deliveries, got %d: %v", len(want), len(got), got)
+	}
+	for i, payload := range got {
+		if payload["event"] != want[i] || payload["pasteid"] != paste.ID || payload["time"] != float64(now.Unix()) {
+			t.Errorf("delivery %d: unexpected payload %v", i, payload)
+		}
+		if len(payload) > 4 {
+			t.Errorf("delivery %d: expected only IDs, event, and time, got %v", i, payload)
+		}
+	}
+	if got[1]["commentid"] != "0123456789abcdef" {
+		t.Errorf("expected the comment ID, got %v", got[1])
+	}
+}
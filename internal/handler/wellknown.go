@@ -0,0 +1,122 @@
+// Package handler provides the instance discovery document.
+// /.well-known/flashpaper.json describes the instance - software version,
+// enabled features, limits, and how to reach the operator - so directory
+// sites listing public instances, and clients choosing one, can discover
+// capabilities without scraping the UI. Like /config it holds nothing that
+// isn't already visible to every visitor, plus the [instance] settings the
+// operator chose to publish.
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/version"
+)
+
+// InstanceDocument is served at /.well-known/flashpaper.json.
+type InstanceDocument struct {
+	Software    string `json:"software"` // Always "FlashPaper"
+	Version     string `json:"version"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Contact     string `json:"contact,omitempty"`
+	PublicKey   string `json:"publickey,omitempty"`
+
+	// API lists the protocols clients can use to create and read pastes
+	API []string `json:"api"`
+
+	Features InstanceFeatures `json:"features"`
+	Limits   InstanceLimits   `json:"limits"`
+}
+
+// InstanceFeatures lists what the instance supports.
+type InstanceFeatures struct {
+	Discussion     bool   `json:"discussion"`
+	OpenDiscussion bool   `json:"opendiscussion"`
+	Moderation     string `json:"moderation"` // off, flagged, or all
+	Password       bool   `json:"password"`
+	FileUpload     bool   `json:"fileupload"`
+	Compression    string `json:"compression"`
+	TOS            bool   `json:"tos"`       // Terms of service are published at /tos
+	APITokens      bool   `json:"apitokens"` // Bearer tokens are accepted on create
+	Callbacks      bool   `json:"callbacks"` // Creator callback URLs are accepted
+
+	// AccessProof is whether creators may have the server check passwords
+	// (see accessproof.go)
+	AccessProof bool `json:"accessproof"`
+
+	// Editable is whether creators get edit tokens (see edit.go)
+	Editable bool `json:"editable"`
+
+	// ServerEncryption is whether the secret API encrypts plaintext
+	ServerEncryption bool `json:"serverencryption"`
+}
+
+// InstanceLimits lists the limits clients must stay within.
+type InstanceLimits struct {
+	SizeLimit       int64                `json:"sizelimit"`       // Bytes per paste
+	AttachmentLimit int64                `json:"attachmentlimit"` // Bytes per attachment
+	CommentLimit    int                  `json:"commentlimit"`    // Per paste; 0 = unlimited
+	DefaultExpire   string               `json:"defaultexpire"`
+	Expire          []ClientExpireOption `json:"expire"`
+}
+
+// instanceDocument builds the discovery document from the configuration.
+func (h *Handler) instanceDocument() InstanceDocument {
+	main := h.config.Main
+	api := []string{"privatebin-v2"}
+	if h.config.API.Secrets {
+		api = append(api, "secret-v1")
+	}
+
+	return InstanceDocument{
+		Software:    "FlashPaper",
+		Version:     version.Version,
+		Name:        main.Name,
+		Description: h.config.Instance.Description,
+		Contact:     h.config.Instance.Contact,
+		PublicKey:   h.config.Instance.PublicKey,
+		API:         api,
+		Features: InstanceFeatures{
+			Discussion:     main.Discussion,
+			OpenDiscussion: main.OpenDiscussion,
+			Moderation:     moderationMode(main.Moderation),
+			Password:       main.Password,
+			FileUpload:     main.FileUpload,
+			Compression:    main.Compression,
+			TOS:            h.config.TOS.File != "",
+			APITokens:      h.config.Tokens.Enabled(),
+			Callbacks:      len(h.config.Callback.Allowlist) > 0,
+
+			AccessProof:      main.AccessProof,
+			Editable:         main.Editable,
+			ServerEncryption: h.config.API.Secrets && h.config.API.ServerEncryption,
+		},
+		Limits: InstanceLimits{
+			SizeLimit:       h.live().Main.SizeLimit,
+			AttachmentLimit: h.live().Main.AttachmentLimit,
+			CommentLimit:    main.CommentLimit,
+			DefaultExpire:   h.live().Expire.Default,
+			Expire:          h.expireOptions(),
+		},
+	}
+}
+
+// moderationMode reports the moderation setting, with "off" for unset.
+func moderationMode(mode string) string {
+	if mode == "" {
+		return config.ModerationOff
+	}
+	return mode
+}
+
+// serveWellKnown handles GET /.well-known/flashpaper.json.
+// Directory sites fetch it from their own pages, hence the open CORS policy.
+func (h *Handler) serveWellKnown(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	json.NewEncoder(w).Encode(h.instanceDocument())
+}
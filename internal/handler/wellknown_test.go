@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestWellKnown_Document tests the discovery document and its headers.
+func TestWellKnown_Document(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Instance.Contact = "mailto:ops@example.com"
+	h.config.Instance.PublicKey = "RWQf6LRCGA9i53mlYecO4IzT51TGPpvWucNSCh1CBM0QTaLn73Y7GFO3"
+	h.config.Tokens.Secrets = map[string]string{"team-a": "super-secret-token-value"}
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/flashpaper.json", nil)
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected open CORS for directory sites, got %q", got)
+	}
+	if strings.Contains(rr.Body.String(), "super-secret-token-value") {
+		t.Fatal("discovery document must not contain token secrets")
+	}
+
+	var doc InstanceDocument
+	if err := json.NewDecoder(rr.Body).Decode(&doc); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if doc.Software != "FlashPaper" || doc.Name != "TestPaste" {
+		t.Errorf("unexpected identity %q/%q", doc.Software, doc.Name)
+	}
+	if doc.Contact != "mailto:ops@example.com" || doc.PublicKey == "" {
+		t.Errorf("expected operator contact and key, got %q/%q", doc.Contact, doc.PublicKey)
+	}
+	if !doc.Features.Discussion || !doc.Features.APITokens || doc.Features.Moderation != "off" {
+		t.Errorf("unexpected features %+v", doc.Features)
+	}
+	if doc.Limits.SizeLimit != 10*1024*1024 || doc.Limits.AttachmentLimit != 10*1024*1024 || len(doc.Limits.Expire) != 8 {
+		t.Errorf("unexpected limits %+v", doc.Limits)
+	}
+}
@@ -0,0 +1,62 @@
+// Package handler provides the reverse proxy check at /admin/whoami.
+// When every visitor shares one rate limit, or the denylist never matches,
+// the cause is nearly always the proxy setup: the proxy doesn't forward the
+// client address, forwards it in another header than the one configured,
+// or isn't trusted to. whoami shows what the server made of the request
+// that reached it, so an operator can call it through the proxy and see.
+package handler
+
+import (
+	"net/http"
+
+	"github.com/liskl/flashpaper/internal/middleware"
+)
+
+// whoamiHeaders are the forwarding headers shown by whoami, besides
+// [traffic] header.
+var whoamiHeaders = []string{
+	"CF-Connecting-IP", "True-Client-IP", "X-Real-IP", "X-Forwarded-For",
+	"Forwarded", "X-Forwarded-Proto",
+}
+
+// whoami handles GET /admin/whoami, describing how the client address and
+// scheme of the request were determined.
+func (h *Handler) whoami(w http.ResponseWriter, r *http.Request) {
+	// Without a recording middleware (as in tests), RemoteAddr is the peer
+	addr, ok := middleware.ClientAddrFrom(r.Context())
+	if !ok {
+		addr = middleware.ClientAddr{Peer: r.RemoteAddr, Trusted: h.config.Traffic.Header != ""}
+	}
+
+	// Same order as getClientIP: the configured header, then RemoteAddr
+	source := addr.Header
+	if name := h.config.Traffic.Header; name != "" && middleware.ForwardedAddr(r, name) != "" {
+		source = http.CanonicalHeaderKey(name)
+	}
+	if source == "" {
+		source = "peer"
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	headers := map[string]string{}
+	for _, name := range append(whoamiHeaders, h.config.Traffic.Header) {
+		if value := r.Header.Get(name); name != "" && value != "" {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+
+	h.jsonSuccess(w, map[string]interface{}{
+		"client_ip":      getClientIP(r, h.config.Traffic.Header),
+		"source":         source,
+		"peer":           addr.Peer,
+		"trusted":        addr.Trusted,
+		"scheme":         scheme,
+		"headers":        headers,
+		"traffic_header": h.config.Traffic.Header,
+		"traffic_preset": h.config.Traffic.Preset,
+	})
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWhoami tests the client address report for each source.
+func TestWhoami(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.config.Admin.Token = testAdminToken
+
+	whoami := func(headers map[string]string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/whoami", nil)
+		req.RemoteAddr = "192.0.2.1:5000"
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		h.Routes().ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return response
+	}
+
+	resp := whoami(map[string]string{"X-Forwarded-For": "198.51.100.7"})
+	if resp["client_ip"] != "192.0.2.1" || resp["source"] != "peer" || resp["trusted"] != false {
+		t.Errorf("expected the peer address, untrusted, got %v", resp)
+	}
+	if resp["peer"] != "192.0.2.1:5000" || resp["scheme"] != "http" {
+		t.Errorf("expected peer and scheme, got %v", resp)
+	}
+	if headers, _ := resp["headers"].(map[string]interface{}); headers["X-Forwarded-For"] != "198.51.100.7" {
+		t.Errorf("expected the forwarding headers echoed, got %v", resp["headers"])
+	}
+
+	h.config.Traffic.Header = "x-forwarded-for"
+	resp = whoami(map[string]string{"X-Forwarded-For": "198.51.100.7, 10.0.0.1"})
+	if resp["client_ip"] != "198.51.100.7" || resp["source"] != "X-Forwarded-For" || resp["trusted"] != true {
+		t.Errorf("expected the address from the configured header, got %v", resp)
+	}
+	resp = whoami(nil)
+	if resp["client_ip"] != "192.0.2.1" || resp["source"] != "peer" {
+		t.Errorf("expected the peer address without the header, got %v", resp)
+	}
+}
@@ -0,0 +1,211 @@
+// Package i18n provides translations of the web UI. Catalogs use
+// PrivateBin's language file format, so its translations can be dropped in:
+// one <lang>.json file per language mapping each English message to its
+// translation, or to a list of plural forms for messages taking a count.
+// English is the source language and needs no catalog.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Source is the language the messages are written in.
+const Source = "en"
+
+// Message is a translation: a single string, or one string per plural form
+// in the order of PluralForm.
+type Message []string
+
+// UnmarshalJSON accepts a string or an array of strings.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*m = Message{s}
+		return nil
+	}
+	var forms []string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return fmt.Errorf("expected a string or an array of strings")
+	}
+	if len(forms) == 0 {
+		return fmt.Errorf("expected at least one plural form")
+	}
+	*m = forms
+	return nil
+}
+
+// Catalog maps English messages to their translations.
+type Catalog map[string]Message
+
+// Language is a language a Bundle has a catalog for.
+type Language struct {
+	Code string // Lowercase tag, e.g. "de" or "pt-br"
+	Name string // Name in the language itself, e.g. "Deutsch"
+}
+
+// Bundle holds the catalogs of all available languages. A nil Bundle has
+// English only.
+type Bundle struct {
+	catalogs map[string]Catalog
+	raw      map[string][]byte
+}
+
+// Load reads every <lang>.json catalog in the root of fsys.
+func Load(fsys fs.FS) (*Bundle, error) {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	b := &Bundle{
+		catalogs: make(map[string]Catalog, len(files)),
+		raw:      make(map[string][]byte, len(files)),
+	}
+	for _, file := range files {
+		code := strings.ToLower(strings.TrimSuffix(path.Base(file), ".json"))
+		if code == Source {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		var catalog Catalog
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		b.catalogs[code] = catalog
+		b.raw[code] = data
+	}
+	return b, nil
+}
+
+// Has reports whether code is an available language.
+func (b *Bundle) Has(code string) bool {
+	if code == Source {
+		return true
+	}
+	if b == nil {
+		return false
+	}
+	_, ok := b.catalogs[code]
+	return ok
+}
+
+// Languages lists the available languages, English included, by code.
+func (b *Bundle) Languages() []Language {
+	codes := []string{Source}
+	if b != nil {
+		for code := range b.catalogs {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	languages := make([]Language, len(codes))
+	for i, code := range codes {
+		name := names[code]
+		if name == "" {
+			name = code
+		}
+		languages[i] = Language{Code: code, Name: name}
+	}
+	return languages
+}
+
+// CatalogJSON returns the catalog of code as it was loaded, for clients
+// translating on their own; English has an empty one. ok is false for
+// languages not available.
+func (b *Bundle) CatalogJSON(code string) (data []byte, ok bool) {
+	if code == Source {
+		return []byte("{}"), true
+	}
+	if b == nil {
+		return nil, false
+	}
+	data, ok = b.raw[code]
+	return data, ok
+}
+
+// Match returns the available language an Accept-Language header asks for
+// first, or fallback if it asks for none of them. A tag matches a catalog
+// of the same tag, or failing that, of its primary language ("de-AT"
+// matches "de").
+func (b *Bundle) Match(acceptLanguage, fallback string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		choices = append(choices, choice{tag, q})
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+
+	for _, c := range choices {
+		if b.Has(c.tag) {
+			return c.tag
+		}
+		if primary, _, ok := strings.Cut(c.tag, "-"); ok && b.Has(primary) {
+			return primary
+		}
+	}
+	return fallback
+}
+
+// Locale returns the translator for code, English if it isn't available.
+func (b *Bundle) Locale(code string) *Locale {
+	if code == Source || !b.Has(code) {
+		return &Locale{Code: Source}
+	}
+	return &Locale{Code: code, catalog: b.catalogs[code]}
+}
+
+// Locale translates messages into one language.
+type Locale struct {
+	Code    string
+	catalog Catalog
+}
+
+// T translates message and formats it with args as fmt.Sprintf would.
+// A translation with plural forms picks the form for args[0], which must
+// be an int. Messages without a translation are used as they are.
+func (l *Locale) T(message string, args ...any) string {
+	text := message
+	if forms := l.catalog[message]; len(forms) > 0 {
+		text = forms[0]
+		if n, ok := firstInt(args); ok && len(forms) > 1 {
+			text = forms[min(PluralForm(l.Code, n), len(forms)-1)]
+		}
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// firstInt returns args[0] if it's an int.
+func firstInt(args []any) (int, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	n, ok := args[0].(int)
+	return n, ok
+}
@@ -0,0 +1,103 @@
+package i18n
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBundle(t *testing.T) *Bundle {
+	t.Helper()
+	b, err := Load(fstest.MapFS{
+		"de.json": {Data: []byte(`{
+			"Send": "Senden",
+			"Created: %s": "Erstellt: %s",
+			"%d comments": ["%d Kommentar", "%d Kommentare"]
+		}`)},
+		"pl.json":    {Data: []byte(`{"%d comments": ["%d komentarz", "%d komentarze", "%d komentarzy"]}`)},
+		"pt-br.json": {Data: []byte(`{"Send": "Enviar"}`)},
+		"en.json":    {Data: []byte(`{"Send": "ignored"}`)},
+	})
+	require.NoError(t, err)
+	return b
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	_, err := Load(fstest.MapFS{"de.json": {Data: []byte(`{"Send": 1}`)}})
+	assert.ErrorContains(t, err, "de.json")
+
+	_, err = Load(fstest.MapFS{"de.json": {Data: []byte(`{"Send": []}`)}})
+	assert.Error(t, err)
+}
+
+func TestLocale_T(t *testing.T) {
+	b := testBundle(t)
+
+	de := b.Locale("de")
+	assert.Equal(t, "Senden", de.T("Send"))
+	assert.Equal(t, "Erstellt: heute", de.T("Created: %s", "heute"))
+	assert.Equal(t, "Untranslated", de.T("Untranslated"))
+	assert.Equal(t, "1 Kommentar", de.T("%d comments", 1))
+	assert.Equal(t, "5 Kommentare", de.T("%d comments", 5))
+
+	pl := b.Locale("pl")
+	assert.Equal(t, "1 komentarz", pl.T("%d comments", 1))
+	assert.Equal(t, "3 komentarze", pl.T("%d comments", 3))
+	assert.Equal(t, "12 komentarzy", pl.T("%d comments", 12))
+
+	// English is the source and never read from a catalog
+	assert.Equal(t, "Send", b.Locale("en").T("Send"))
+	assert.Equal(t, "en", b.Locale("xx").Code)
+}
+
+func TestBundle_Match(t *testing.T) {
+	b := testBundle(t)
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"de-AT,de;q=0.9,en;q=0.8", "de"},
+		{"fr-CH, fr;q=0.9, pl;q=0.5, de;q=0.7", "de"},
+		{"pt-BR", "pt-br"},
+		{"pt-PT", "fallback"},
+		{"en-US,de;q=0.5", "en"},
+		{"de;q=0, *", "fallback"},
+		{"", "fallback"},
+		{"de;q=bogus", "fallback"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, b.Match(tt.accept, "fallback"), tt.accept)
+	}
+}
+
+func TestBundle_Languages(t *testing.T) {
+	b := testBundle(t)
+	assert.Equal(t, []Language{
+		{Code: "de", Name: "Deutsch"},
+		{Code: "en", Name: "English"},
+		{Code: "pl", Name: "polski"},
+		{Code: "pt-br", Name: "pt-br"},
+	}, b.Languages())
+
+	// A nil Bundle has English only
+	var none *Bundle
+	assert.Equal(t, []Language{{Code: "en", Name: "English"}}, none.Languages())
+	assert.Equal(t, "en", none.Locale("de").Code)
+	_, ok := none.CatalogJSON("de")
+	assert.False(t, ok)
+}
+
+func TestPluralForm(t *testing.T) {
+	assert.Equal(t, 0, PluralForm("en", 1))
+	assert.Equal(t, 1, PluralForm("en", 0))
+	assert.Equal(t, 0, PluralForm("fr", 0))
+	assert.Equal(t, 1, PluralForm("fr", 2))
+	assert.Equal(t, 0, PluralForm("ru", 21))
+	assert.Equal(t, 1, PluralForm("ru", 22))
+	assert.Equal(t, 2, PluralForm("ru", 11))
+	assert.Equal(t, 5, PluralForm("ar", 102))
+	assert.Equal(t, 0, PluralForm("ja", 7))
+}
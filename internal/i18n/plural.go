@@ -0,0 +1,135 @@
+// Package i18n provides the plural rules of the languages PrivateBin has
+// catalogs for, and their names for the language picker.
+package i18n
+
+// PluralForm returns which of a translation's plural forms to use for n
+// things in the language code. The rules are PrivateBin's, so its catalogs
+// list their forms in the order expected here.
+func PluralForm(code string, n int) int {
+	switch code {
+	case "ar":
+		switch {
+		case n == 0:
+			return 0
+		case n == 1:
+			return 1
+		case n == 2:
+			return 2
+		case n%100 >= 3 && n%100 <= 10:
+			return 3
+		case n%100 >= 11:
+			return 4
+		}
+		return 5
+	case "cs", "sk":
+		switch {
+		case n == 1:
+			return 0
+		case n >= 2 && n <= 4:
+			return 1
+		}
+		return 2
+	case "co", "fa", "fr", "oc", "tr", "zh":
+		if n > 1 {
+			return 1
+		}
+		return 0
+	case "he":
+		switch {
+		case n == 1:
+			return 0
+		case n == 2:
+			return 1
+		case (n < 0 || n > 10) && n%10 == 0:
+			return 2
+		}
+		return 3
+	case "id", "ja", "jbo", "th":
+		return 0
+	case "lt":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return 0
+		case n%10 >= 2 && n%100 < 10 || n%100 >= 20:
+			return 1
+		}
+		return 2
+	case "pl":
+		switch {
+		case n == 1:
+			return 0
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 10 || n%100 >= 20):
+			return 1
+		}
+		return 2
+	case "ro":
+		switch {
+		case n == 1:
+			return 0
+		case n == 0 || (n%100 > 0 && n%100 < 20):
+			return 1
+		}
+		return 2
+	case "ru", "uk":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return 0
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 10 || n%100 >= 20):
+			return 1
+		}
+		return 2
+	case "sl":
+		switch n % 100 {
+		case 1:
+			return 1
+		case 2:
+			return 2
+		case 3, 4:
+			return 3
+		}
+		return 0
+	}
+	// English and most other European languages: one, other
+	if n != 1 {
+		return 1
+	}
+	return 0
+}
+
+// names are the languages' names for themselves, shown in the language
+// picker. Catalogs of other languages are listed by code.
+var names = map[string]string{
+	"ar":  "العربية",
+	"bg":  "български",
+	"ca":  "català",
+	"co":  "corsu",
+	"cs":  "čeština",
+	"de":  "Deutsch",
+	"el":  "ελληνικά",
+	"en":  "English",
+	"es":  "Español",
+	"et":  "eesti",
+	"fa":  "فارسی",
+	"fi":  "suomi",
+	"fr":  "français",
+	"he":  "עברית",
+	"hu":  "magyar",
+	"id":  "bahasa Indonesia",
+	"it":  "italiano",
+	"ja":  "日本語",
+	"jbo": "jbobau",
+	"lt":  "lietuvių",
+	"nl":  "Nederlands",
+	"no":  "Norsk",
+	"oc":  "occitan",
+	"pl":  "polski",
+	"pt":  "português",
+	"ro":  "română",
+	"ru":  "русский",
+	"sk":  "slovenčina",
+	"sl":  "slovenščina",
+	"th":  "ไทย",
+	"tr":  "Türkçe",
+	"uk":  "українська",
+	"zh":  "中文",
+}
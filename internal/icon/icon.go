@@ -0,0 +1,235 @@
+// Package icon renders comment avatars from vizhashes. A comment's vizhash
+// is an HMAC of the commenter's address, so comments by one commenter on a
+// paste get the same icon without the address being stored. Icons come as
+// data URIs, ready for an img element, in one of the styles [main] icon
+// names:
+//
+//   - identicon: a 5x5 mirrored grid of cells in one color, as a PNG
+//   - jdenticon: geometric shapes turned around a 4x4 grid, as an SVG
+//   - vizhash: PrivateBin's style of gradient and overlapping shapes, as a
+//     16x16 PNG
+//
+// Icons depend on nothing but the vizhash, so they can be rendered again on
+// every read instead of being stored.
+package icon
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+)
+
+// Icon styles, as [main] icon names them.
+const (
+	StyleIdenticon = "identicon"
+	StyleJdenticon = "jdenticon"
+	StyleVizhash   = "vizhash"
+	StyleNone      = "none"
+)
+
+// DataURI returns the icon for vizhash in style as a data URI. It returns
+// an empty string for style none or an empty vizhash, and an error for
+// unknown styles.
+func DataURI(style, vizhash string) (string, error) {
+	if style == StyleNone || vizhash == "" {
+		return "", nil
+	}
+	hash := seed(vizhash)
+	switch style {
+	case StyleIdenticon:
+		return pngURI(identicon(hash))
+	case StyleVizhash:
+		return pngURI(vizhashImage(hash))
+	case StyleJdenticon:
+		return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(jdenticon(hash))), nil
+	default:
+		return "", fmt.Errorf("unknown icon style %q", style)
+	}
+}
+
+// seed returns the bytes icons are drawn from. Vizhashes are base64 HMACs;
+// anything else is hashed, so every string gets a well-spread seed.
+func seed(vizhash string) []byte {
+	if hash, err := base64.StdEncoding.DecodeString(vizhash); err == nil && len(hash) >= 32 {
+		return hash
+	}
+	sum := sha512.Sum512([]byte(vizhash))
+	return sum[:]
+}
+
+// pngURI encodes img as a PNG data URI.
+func pngURI(img image.Image) (string, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// hue returns the hue, in degrees, that two bytes of hash pick.
+func hue(hash []byte, i int) float64 {
+	return float64(int(hash[i])<<8|int(hash[i+1])) / 65536 * 360
+}
+
+// hsl converts a hue in degrees and saturation and lightness in [0, 1] to
+// an opaque color.
+func hsl(h, s, l float64) color.RGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g = c, x
+	case h < 120:
+		r, g = x, c
+	case h < 180:
+		g, b = c, x
+	case h < 240:
+		g, b = x, c
+	case h < 300:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
+}
+
+// identicon draws a 5x5 grid mirrored around its middle column, one cell
+// per bit of hash, on a transparent background.
+func identicon(hash []byte) image.Image {
+	const cells, cell, margin = 5, 8, 4
+	const size = cells*cell + 2*margin
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{
+		color.Transparent,
+		hsl(hue(hash, 0), 0.55, 0.5),
+	})
+	for row := 0; row < cells; row++ {
+		for col := 0; col < (cells+1)/2; col++ {
+			bit := row*3 + col
+			if hash[2+bit/8]>>(bit%8)&1 == 0 {
+				continue
+			}
+			for _, c := range []int{col, cells - 1 - col} {
+				x0, y0 := margin+c*cell, margin+row*cell
+				for y := y0; y < y0+cell; y++ {
+					for x := x0; x < x0+cell; x++ {
+						img.SetColorIndex(x, y, 1)
+					}
+				}
+			}
+		}
+	}
+	return img
+}
+
+// vizhashImage draws a 16x16 icon the way PrivateBin's vizhash does: a
+// diagonal gradient between two colors of hash, with translucent
+// rectangles and ellipses laid over it from the rest of the bytes.
+func vizhashImage(hash []byte) image.Image {
+	const size = 16
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	from := hsl(hue(hash, 0), 0.6, 0.45)
+	to := hsl(hue(hash, 2), 0.6, 0.65)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			t := float64(x+y) / (2 * (size - 1))
+			img.SetRGBA(x, y, color.RGBA{
+				uint8(float64(from.R)*(1-t) + float64(to.R)*t),
+				uint8(float64(from.G)*(1-t) + float64(to.G)*t),
+				uint8(float64(from.B)*(1-t) + float64(to.B)*t),
+				255,
+			})
+		}
+	}
+
+	// Seven bytes per shape: kind, color, and a box
+	for i := 4; i+7 <= len(hash) && i < 4+7*6; i += 7 {
+		b := hash[i : i+7]
+		c := color.RGBA{b[1], b[2], b[3], 255}
+		x0, y0 := int(b[4]%size), int(b[5]%size)
+		w, h := 2+int(b[6]&0x0f)%(size/2), 2+int(b[6]>>4)%(size/2)
+		for y := y0; y < y0+h && y < size; y++ {
+			for x := x0; x < x0+w && x < size; x++ {
+				if b[0]&1 == 1 && !inEllipse(x-x0, y-y0, w, h) {
+					continue
+				}
+				blend(img, x, y, c)
+			}
+		}
+	}
+	return img
+}
+
+// inEllipse reports whether pixel (x, y) of a w by h box falls in the
+// ellipse filling it.
+func inEllipse(x, y, w, h int) bool {
+	dx := (float64(x) + 0.5 - float64(w)/2) / (float64(w) / 2)
+	dy := (float64(y) + 0.5 - float64(h)/2) / (float64(h) / 2)
+	return dx*dx+dy*dy <= 1
+}
+
+// blend lays c over pixel (x, y) of img at half opacity.
+func blend(img *image.RGBA, x, y int, c color.RGBA) {
+	p := img.RGBAAt(x, y)
+	img.SetRGBA(x, y, color.RGBA{
+		uint8((uint16(p.R) + uint16(c.R)) / 2),
+		uint8((uint16(p.G) + uint16(c.G)) / 2),
+		uint8((uint16(p.B) + uint16(c.B)) / 2),
+		255,
+	})
+}
+
+// jdenticonShapes are the shapes jdenticon cells are drawn with, as SVG
+// path data for a cell of size 1 at the origin.
+var jdenticonShapes = []string{
+	"M0 0H1V1H0Z",           // Square
+	"M0 0H1L0 1Z",           // Triangle
+	"M.5 0L1 .5L.5 1L0 .5Z", // Diamond
+	"M0 0H1V.5H0Z",          // Half square
+	"M.25 .25H.75V.75H.25Z", // Inner square
+	"M0 1L.5 0L1 1Z",        // Peak
+	"M.5 .15A.35 .35 0 1 1 .5 .85A.35 .35 0 1 1 .5 .15Z", // Circle
+}
+
+// jdenticon draws a 4x4 grid after jdenticon: one shape in the corners and
+// one along the sides, each turned a quarter for every quadrant, and a
+// third in the middle, in two shades of a hue of hash.
+func jdenticon(hash []byte) string {
+	const cells, cell, margin = 4, 10, 4
+	const size = cells*cell + 2*margin
+	h := hue(hash, 0)
+	dark := hsl(h, 0.5, 0.4)
+	light := hsl(h, 0.5, 0.7)
+	corner := jdenticonShapes[int(hash[2])%len(jdenticonShapes)]
+	side := jdenticonShapes[int(hash[3])%len(jdenticonShapes)]
+	middle := jdenticonShapes[int(hash[4])%len(jdenticonShapes)]
+	turn := int(hash[5])
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, size, size, size, size)
+	shape := func(path string, col, row, rotation int, c color.RGBA) {
+		x, y := margin+col*cell, margin+row*cell
+		fmt.Fprintf(&b, `<path fill="#%02x%02x%02x" transform="translate(%d %d) rotate(%d %d %d) scale(%d)" d="%s"/>`,
+			c.R, c.G, c.B, x, y, rotation%4*90, cell/2, cell/2, cell, path)
+	}
+	corners := [][2]int{{0, 0}, {3, 0}, {3, 3}, {0, 3}}
+	sides := [][2]int{{1, 0}, {2, 0}, {3, 1}, {3, 2}, {2, 3}, {1, 3}, {0, 2}, {0, 1}}
+	for i, p := range corners {
+		shape(corner, p[0], p[1], turn+i, dark)
+	}
+	for i, p := range sides {
+		shape(side, p[0], p[1], turn+i/2, light)
+	}
+	for i, p := range [][2]int{{1, 1}, {2, 1}, {2, 2}, {1, 2}} {
+		shape(middle, p[0], p[1], i, dark)
+	}
+	b.WriteString("</svg>")
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package icon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// vizhash returns a vizhash as comments get them.
+func vizhash(t *testing.T, ip string) string {
+	t.Helper()
+	v, err := util.GenerateVizhash(ip, base64.StdEncoding.EncodeToString([]byte("server salt")))
+	require.NoError(t, err)
+	return v
+}
+
+// decode splits a data URI into its media type and content.
+func decode(t *testing.T, uri string) (string, []byte) {
+	t.Helper()
+	mediaType, data, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ";base64,")
+	require.True(t, ok, "not a base64 data URI: %.40s", uri)
+	raw, err := base64.StdEncoding.DecodeString(data)
+	require.NoError(t, err)
+	return mediaType, raw
+}
+
+func TestDataURI_Styles(t *testing.T) {
+	v := vizhash(t, "192.0.2.1")
+	tests := []struct {
+		style     string
+		mediaType string
+		size      int
+	}{
+		{StyleIdenticon, "image/png", 48},
+		{StyleVizhash, "image/png", 16},
+		{StyleJdenticon, "image/svg+xml", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			uri, err := DataURI(tt.style, v)
+			require.NoError(t, err)
+			mediaType, raw := decode(t, uri)
+			assert.Equal(t, tt.mediaType, mediaType)
+			if tt.size > 0 {
+				img, err := png.Decode(bytes.NewReader(raw))
+				require.NoError(t, err)
+				assert.Equal(t, tt.size, img.Bounds().Dx())
+				assert.Equal(t, tt.size, img.Bounds().Dy())
+			} else {
+				assert.NoError(t, xml.Unmarshal(raw, new(struct{})), "well-formed SVG")
+			}
+
+			// The same commenter gets the same icon, others another
+			again, err := DataURI(tt.style, v)
+			require.NoError(t, err)
+			assert.Equal(t, uri, again)
+			other, err := DataURI(tt.style, vizhash(t, "192.0.2.2"))
+			require.NoError(t, err)
+			assert.NotEqual(t, uri, other)
+		})
+	}
+}
+
+func TestDataURI_None(t *testing.T) {
+	uri, err := DataURI(StyleNone, vizhash(t, "192.0.2.1"))
+	require.NoError(t, err)
+	assert.Empty(t, uri)
+
+	// Comments stored without a vizhash get no icon
+	uri, err = DataURI(StyleIdenticon, "")
+	require.NoError(t, err)
+	assert.Empty(t, uri)
+}
+
+func TestDataURI_UnknownStyle(t *testing.T) {
+	_, err := DataURI("gravatar", vizhash(t, "192.0.2.1"))
+	assert.Error(t, err)
+}
+
+func TestDataURI_NotBase64(t *testing.T) {
+	// Vizhashes from other servers may be anything; they still get an icon
+	uri, err := DataURI(StyleIdenticon, "not a vizhash")
+	require.NoError(t, err)
+	mediaType, _ := decode(t, uri)
+	assert.Equal(t, "image/png", mediaType)
+}
@@ -0,0 +1,65 @@
+// Package logging configures structured logging for FlashPaper.
+// Everything logs through log/slog, as logfmt-style text or JSON lines, at
+// the level set by [main] loglevel. Request handlers get a logger carrying
+// the request ID from the request context, so lines from one request can be
+// correlated in Loki, ELK, and the like.
+//
+// Paste and comment IDs are capabilities: anyone who has one (and the key)
+// can read the paste. They are only logged at debug level.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Formats for [main] logformat.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel parses a [main] loglevel value: debug, info, warn, or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+// New returns a logger writing to w at the given level and format.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case FormatText, "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+type contextKey struct{}
+
+// WithLogger returns a copy of ctx carrying l.
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	for s, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"INFO":  slog.LevelInfo,
+		"warn":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		level, err := ParseLevel(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, level, s)
+	}
+
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestNew_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", FormatJSON)
+	require.NoError(t, err)
+
+	logger.Debug("hidden", "paste_id", "f468483c313401e8")
+	logger.Info("shown", "status", 200)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 1, "debug lines must be dropped at info level")
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "shown", entry["msg"])
+	assert.Equal(t, float64(200), entry["status"])
+}
+
+func TestNew_RejectsUnknownFormat(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "info", "xml")
+	assert.Error(t, err)
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Same(t, slog.Default(), FromContext(context.Background()))
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, "info", FormatText)
+	require.NoError(t, err)
+	logger = logger.With("request_id", "abc")
+
+	FromContext(WithLogger(context.Background(), logger)).Info("hello")
+	assert.Contains(t, buf.String(), "request_id=abc")
+}
@@ -0,0 +1,390 @@
+// Package metrics provides a small metrics registry with Prometheus text
+// exposition. FlashPaper records only aggregate usage (sizes, chosen options,
+// counts) - never paste content, IDs, or client addresses - so operators can
+// tune limits from real usage without weakening the zero-knowledge model.
+//
+// The registry supports labeled counters, gauges, and histograms, which
+// covers what the application records, without pulling in a client library.
+// RegisterRuntime adds the standard Go runtime and process metrics.
+// All types are safe for concurrent use, and methods on nil metrics are
+// no-ops so callers need no "metrics enabled" checks.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of WriteText output.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// metric is a named family of series in a registry.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and renders them in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// register adds m under name. Duplicate names are a programming error.
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.metrics[name]; exists {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.metrics[name] = m
+}
+
+// WriteText writes all metrics, sorted by name, in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	family := make([]metric, len(names))
+	for i, name := range names {
+		family[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range family {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]float64 // Encoded label set -> value
+}
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, series: map[string]float64{}}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds delta (which must not be negative) to the counter for the
+// given label values, in the order the labels were declared.
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if c == nil || delta < 0 {
+		return
+	}
+	key := labelSet(c.labels, values)
+
+	c.mu.Lock()
+	c.series[key] += delta
+	c.mu.Unlock()
+}
+
+// Value returns the counter for the given label values.
+func (c *CounterVec) Value(values ...string) float64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.series[labelSet(c.labels, values)]
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.series))
+	for key := range c.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.series[key]))
+	}
+}
+
+// GaugeVec is a family of gauges partitioned by label values.
+type GaugeVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]float64 // Encoded label set -> value
+}
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{name: name, help: help, labels: labels, series: map[string]float64{}}
+	r.register(name, g)
+	return g
+}
+
+// Set sets the gauge for the given label values.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	if g == nil {
+		return
+	}
+	key := labelSet(g.labels, values)
+
+	g.mu.Lock()
+	g.series[key] = v
+	g.mu.Unlock()
+}
+
+// Value returns the gauge for the given label values.
+func (g *GaugeVec) Value(values ...string) float64 {
+	if g == nil {
+		return 0
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.series[labelSet(g.labels, values)]
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	writeHeader(w, g.name, g.help, "gauge")
+	keys := make([]string, 0, len(g.series))
+	for key := range g.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, key, formatFloat(g.series[key]))
+	}
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64 // Upper bounds, ascending
+
+	mu     sync.Mutex
+	counts []uint64 // Per bucket, not cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// Histogram registers a histogram with the given bucket upper bounds.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &Histogram{name: name, help: help, buckets: sorted, counts: make([]uint64, len(sorted)+1)}
+	r.register(name, h)
+	return h
+}
+
+// Observe records a value.
+func (h *Histogram) Observe(v float64) {
+	if h == nil {
+		return
+	}
+	i := sort.SearchFloat64s(h.buckets, v) // First bucket with bound >= v
+
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64 // Upper bounds, ascending
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries // Encoded label set -> series
+}
+
+// histogramSeries is one labeled series of a HistogramVec.
+type histogramSeries struct {
+	counts []uint64 // Per bucket, not cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec registers a histogram family with the given bucket upper
+// bounds and label names.
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: sorted, series: map[string]*histogramSeries{}}
+	r.register(name, h)
+	return h
+}
+
+// Observe records a value for the given label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	if h == nil {
+		return
+	}
+	key := labelSet(h.labels, values)
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+	h.mu.Unlock()
+}
+
+// Count returns the number of observations for the given label values.
+func (h *HistogramVec) Count(values ...string) uint64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[labelSet(h.labels, values)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		// The le label goes after the series' own labels
+		bucket := func(le string) string {
+			if key == "" {
+				return `{le="` + le + `"}`
+			}
+			return key[:len(key)-1] + `,le="` + le + `"}`
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, bucket(formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, bucket("+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, s.count)
+	}
+}
+
+// ExponentialBuckets returns count bounds starting at start, each factor
+// times the previous.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// writeHeader writes the HELP and TYPE lines of a metric family.
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// labelSet encodes label values as {a="x",b="y"}, the form they take in
+// the exposition. Missing values are empty; extras are ignored.
+func labelSet(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escape.Replace(value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// formatFloat renders a sample value the way Prometheus expects.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_total", "A test counter.", "kind")
+
+	c.Inc("a")
+	c.Inc("a")
+	c.Add(3, "b")
+	c.Add(-1, "b") // Counters never decrease
+
+	assert.Equal(t, float64(2), c.Value("a"))
+	assert.Equal(t, float64(3), c.Value("b"))
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+	assert.Equal(t, `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{kind="a"} 2
+test_total{kind="b"} 3
+`, buf.String())
+}
+
+func TestGaugeVec(t *testing.T) {
+	r := NewRegistry()
+	g := r.Gauge("test_up", "A test gauge.", "host")
+
+	g.Set(1, "a")
+	g.Set(1, "b")
+	g.Set(0, "a") // Gauges go down too
+
+	assert.Equal(t, float64(0), g.Value("a"))
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+	assert.Equal(t, `# HELP test_up A test gauge.
+# TYPE test_up gauge
+test_up{host="a"} 0
+test_up{host="b"} 1
+`, buf.String())
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("test_bytes", "A test histogram.", []float64{100, 10})
+
+	h.Observe(5)
+	h.Observe(10) // Bounds are inclusive
+	h.Observe(50)
+	h.Observe(1000)
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+	assert.Equal(t, `# HELP test_bytes A test histogram.
+# TYPE test_bytes histogram
+test_bytes_bucket{le="10"} 2
+test_bytes_bucket{le="100"} 3
+test_bytes_bucket{le="+Inf"} 4
+test_bytes_sum 1065
+test_bytes_count 4
+`, buf.String())
+}
+
+func TestLabelEscaping(t *testing.T) {
+	assert.Equal(t, `{a="x\"y\\z\n"}`, labelSet([]string{"a"}, []string{"x\"y\\z\n"}))
+	assert.Equal(t, `{a="",b=""}`, labelSet([]string{"a", "b"}, nil))
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := r.HistogramVec("test_seconds", "A test histogram.", []float64{0.1, 1}, "op")
+
+	h.Observe(0.05, "read")
+	h.Observe(0.5, "read")
+	h.Observe(2, "write")
+	assert.Equal(t, uint64(2), h.Count("read"))
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+	assert.Equal(t, `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{op="read",le="0.1"} 1
+test_seconds_bucket{op="read",le="1"} 2
+test_seconds_bucket{op="read",le="+Inf"} 2
+test_seconds_sum{op="read"} 0.55
+test_seconds_count{op="read"} 2
+test_seconds_bucket{op="write",le="0.1"} 0
+test_seconds_bucket{op="write",le="1"} 0
+test_seconds_bucket{op="write",le="+Inf"} 1
+test_seconds_sum{op="write"} 2
+test_seconds_count{op="write"} 1
+`, buf.String())
+}
+
+func TestNilMetricsAreNoOps(t *testing.T) {
+	var c *CounterVec
+	var g *GaugeVec
+	var h *Histogram
+	var hv *HistogramVec
+
+	c.Inc("x")
+	g.Set(1, "x")
+	h.Observe(1)
+	hv.Observe(1, "x")
+	assert.Equal(t, float64(0), c.Value("x"))
+	assert.Equal(t, float64(0), g.Value("x"))
+	assert.Equal(t, uint64(0), h.Count())
+	assert.Equal(t, uint64(0), hv.Count("x"))
+}
+
+func TestRegistry_DuplicatePanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("dup_total", "")
+	assert.Panics(t, func() { r.Counter("dup_total", "") })
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	assert.Equal(t, []float64{1, 4, 16}, ExponentialBuckets(1, 4, 3))
+}
+
+func TestRegisterRuntime(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterRuntime()
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteText(&buf))
+	out := buf.String()
+
+	assert.Contains(t, out, "# TYPE go_goroutines gauge\n")
+	assert.Contains(t, out, `go_gc_duration_seconds{quantile="0.5"} `)
+	assert.Contains(t, out, "\ngo_memstats_heap_alloc_bytes ")
+	if runtime.GOOS == "linux" {
+		assert.Contains(t, out, "\nprocess_resident_memory_bytes ")
+		assert.Contains(t, out, "\nprocess_open_fds ")
+		assert.Contains(t, out, "\nprocess_start_time_seconds ")
+	}
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// collector is a metric computed when the registry is written.
+type collector func(w *bufio.Writer)
+
+func (c collector) write(w *bufio.Writer) { c(w) }
+
+// RegisterRuntime adds Go runtime metrics (goroutines, heap, GC pauses) and,
+// on Linux, process metrics (CPU, memory, file descriptors). Names follow the
+// Prometheus Go client, so existing dashboards and alerts apply.
+func (r *Registry) RegisterRuntime() {
+	r.register("go", collector(writeGoMetrics))
+	r.register("process", collector(writeProcessMetrics))
+}
+
+// writeGoMetrics writes the go_* families.
+func writeGoMetrics(w *bufio.Writer) {
+	writeValue(w, "go_goroutines", "Number of goroutines that currently exist.",
+		"gauge", float64(runtime.NumGoroutine()))
+	threads, _ := runtime.ThreadCreateProfile(nil)
+	writeValue(w, "go_threads", "Number of OS threads created.", "gauge", float64(threads))
+
+	writeHeader(w, "go_info", "Information about the Go environment.", "gauge")
+	fmt.Fprintf(w, "go_info%s 1\n", labelSet([]string{"version"}, []string{runtime.Version()}))
+
+	// GC pauses as a summary of min, quartiles, and max
+	stats := debug.GCStats{PauseQuantiles: make([]time.Duration, 5)}
+	debug.ReadGCStats(&stats)
+	writeHeader(w, "go_gc_duration_seconds", "A summary of the pause duration of garbage collection cycles.", "summary")
+	for i, q := range []string{"0", "0.25", "0.5", "0.75", "1"} {
+		fmt.Fprintf(w, "go_gc_duration_seconds{quantile=\"%s\"} %s\n", q, formatFloat(stats.PauseQuantiles[i].Seconds()))
+	}
+	fmt.Fprintf(w, "go_gc_duration_seconds_sum %s\n", formatFloat(stats.PauseTotal.Seconds()))
+	fmt.Fprintf(w, "go_gc_duration_seconds_count %d\n", stats.NumGC)
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	for _, m := range []struct {
+		name, help, kind string
+		value            float64
+	}{
+		{"go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", "counter", float64(ms.TotalAlloc)},
+		{"go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", "gauge", float64(ms.HeapAlloc)},
+		{"go_memstats_heap_idle_bytes", "Number of heap bytes waiting to be used.", "gauge", float64(ms.HeapIdle)},
+		{"go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", "gauge", float64(ms.HeapInuse)},
+		{"go_memstats_heap_objects", "Number of allocated objects.", "gauge", float64(ms.HeapObjects)},
+		{"go_memstats_heap_released_bytes", "Number of heap bytes released to OS.", "gauge", float64(ms.HeapReleased)},
+		{"go_memstats_heap_sys_bytes", "Number of heap bytes obtained from system.", "gauge", float64(ms.HeapSys)},
+		{"go_memstats_last_gc_time_seconds", "Number of seconds since 1970 of last garbage collection.", "gauge", float64(ms.LastGC) / 1e9},
+		{"go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", "gauge", float64(ms.NextGC)},
+		{"go_memstats_stack_inuse_bytes", "Number of bytes in use by the stack allocator.", "gauge", float64(ms.StackInuse)},
+		{"go_memstats_sys_bytes", "Number of bytes obtained from system.", "gauge", float64(ms.Sys)},
+	} {
+		writeValue(w, m.name, m.help, m.kind, m.value)
+	}
+}
+
+// userHZ is the unit of CPU times in /proc/self/stat. It is 100 on every
+// Linux platform Go supports.
+const userHZ = 100
+
+// writeProcessMetrics writes the process_* families from /proc. Nothing is
+// written where /proc isn't available.
+func writeProcessMetrics(w *bufio.Writer) {
+	stat, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return
+	}
+	// The command name may contain spaces; the fields after it don't.
+	// Field 3 (state) is fields[0] here.
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 22 {
+		return
+	}
+	field := func(n int) float64 {
+		v, _ := strconv.ParseFloat(fields[n-3], 64)
+		return v
+	}
+
+	cpu := (field(14) + field(15)) / userHZ
+	writeValue(w, "process_cpu_seconds_total", "Total user and system CPU time spent in seconds.", "counter", cpu)
+	writeValue(w, "process_resident_memory_bytes", "Resident memory size in bytes.",
+		"gauge", field(24)*float64(os.Getpagesize()))
+	writeValue(w, "process_virtual_memory_bytes", "Virtual memory size in bytes.", "gauge", field(23))
+
+	if boot := bootTime(); boot > 0 {
+		writeValue(w, "process_start_time_seconds", "Start time of the process since unix epoch in seconds.",
+			"gauge", boot+field(22)/userHZ)
+	}
+
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		writeValue(w, "process_open_fds", "Number of open file descriptors.", "gauge", float64(len(fds)))
+	}
+	if limit := maxFDs(); limit > 0 {
+		writeValue(w, "process_max_fds", "Maximum number of open file descriptors.", "gauge", limit)
+	}
+}
+
+// bootTime returns the system boot time in seconds since the epoch, or 0.
+func bootTime() float64 {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "btime "); ok {
+			boot, _ := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return boot
+		}
+	}
+	return 0
+}
+
+// maxFDs returns the soft limit on open files, or 0 if unknown or unlimited.
+func maxFDs() float64 {
+	data, err := os.ReadFile("/proc/self/limits")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rest, ok := strings.CutPrefix(line, "Max open files"); ok {
+			fields := strings.Fields(rest)
+			if len(fields) > 0 {
+				limit, _ := strconv.ParseFloat(fields[0], 64)
+				return limit
+			}
+		}
+	}
+	return 0
+}
+
+// writeValue writes a metric family with a single unlabeled sample.
+func writeValue(w *bufio.Writer, name, help, kind string, v float64) {
+	writeHeader(w, name, help, kind)
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
@@ -0,0 +1,30 @@
+// Package middleware provides bearer token authentication for operational
+// endpoints. Prometheus and profilers are configured with a fixed token, so
+// a constant-time comparison against one shared secret is all that's needed.
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// BearerToken returns middleware that answers 401 Unauthorized unless the
+// request carries token in its Authorization header. An empty token lets
+// every request through.
+func BearerToken(token, realm string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if token == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
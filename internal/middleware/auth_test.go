@@ -0,0 +1,46 @@
+// Package middleware provides tests for bearer token authentication.
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	protected := BearerToken("scrape-token-0123456789", "flashpaper-metrics")(ok)
+
+	for _, tt := range []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no header", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer scrape-token-9876543210", http.StatusUnauthorized},
+		{"not bearer", "Basic c2NyYXBlLXRva2Vu", http.StatusUnauthorized},
+		{"valid", "Bearer scrape-token-0123456789", http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			protected.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rr.Code)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate header")
+			}
+		})
+	}
+
+	// Without a token nothing is required
+	rr := httptest.NewRecorder()
+	BearerToken("", "flashpaper-metrics")(ok).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d without a token, got %d", http.StatusOK, rr.Code)
+	}
+}
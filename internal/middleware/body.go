@@ -0,0 +1,69 @@
+// Package middleware provides request body management for FlashPaper.
+// Handlers frequently return early (invalid content type, bad JSON, failed
+// validation) without consuming the whole request body. Leaving unread bytes
+// on the wire prevents the server from reusing the keep-alive connection, so
+// every such response costs the client a fresh TCP (and TLS) handshake.
+package middleware
+
+import (
+	"io"
+	"net/http"
+)
+
+// RequestBody returns middleware that bounds, drains, and closes request bodies.
+//
+// Before the handler runs, the body is wrapped with http.MaxBytesReader so no
+// handler can read more than limit bytes. After the handler returns, any
+// unread remainder (up to limit) is discarded and the body is closed, which
+// lets net/http reuse the connection for the next request. Bodies whose
+// Content-Length is over the limit are closed without draining.
+//
+// A limit of 0 or less disables the size bound but still drains and closes.
+func RequestBody(limit int64) func(http.Handler) http.Handler {
+	return RequestBodyLimit(func() int64 { return limit })
+}
+
+// RequestBodyLimit is RequestBody with a limit that may change while
+// serving, such as on a configuration reload. It is read once per request.
+func RequestBodyLimit(limitFn func() int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			limit := limitFn()
+			if limit > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			body := r.Body
+
+			next.ServeHTTP(w, r)
+
+			// A body announced as over the limit can't be drained within
+			// it, and net/http closes the connection anyway
+			if limit > 0 && r.ContentLength > limit {
+				_ = body.Close()
+				return
+			}
+			DrainBody(body, limit)
+		})
+	}
+}
+
+// DrainBody discards up to limit unread bytes from body and closes it.
+// Bodies larger than limit are not drained further; the server will close
+// the connection instead of reading an unbounded amount of data.
+// A limit of 0 or less drains without a bound.
+func DrainBody(body io.ReadCloser, limit int64) {
+	if body == nil {
+		return
+	}
+	if limit > 0 {
+		_, _ = io.CopyN(io.Discard, body, limit)
+	} else {
+		_, _ = io.Copy(io.Discard, body)
+	}
+	_ = body.Close()
+}
@@ -0,0 +1,171 @@
+// Package middleware provides tests for request body management.
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+)
+
+// earlyReturnHandler rejects every request without touching the body,
+// mimicking handlers that fail validation before decoding JSON.
+var earlyReturnHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write([]byte(`{"status":1,"message":"Invalid content type"}`))
+})
+
+// postTwice sends two identical POST requests over the same client and
+// reports whether the second one reused the first connection.
+func postTwice(t *testing.T, url string, body []byte) bool {
+	t.Helper()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.CloseIdleConnections()
+
+	reused := false
+	for i := 0; i < 2; i++ {
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if i == 1 {
+					reused = info.Reused
+				}
+			},
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to build request: %v", err)
+		}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	return reused
+}
+
+// TestRequestBody_ConnectionReuse verifies that early-returning handlers
+// keep the connection reusable once the middleware drains the body.
+func TestRequestBody_ConnectionReuse(t *testing.T) {
+	// Larger than net/http's built-in post-handler discard (256 KiB),
+	// so without draining the server must close the connection.
+	body := bytes.Repeat([]byte("a"), 1<<20)
+
+	t.Run("without middleware", func(t *testing.T) {
+		srv := httptest.NewServer(earlyReturnHandler)
+		defer srv.Close()
+
+		if postTwice(t, srv.URL, body) {
+			t.Error("expected connection not to be reused without draining")
+		}
+	})
+
+	t.Run("with middleware", func(t *testing.T) {
+		srv := httptest.NewServer(RequestBody(2 << 20)(earlyReturnHandler))
+		defer srv.Close()
+
+		if !postTwice(t, srv.URL, body) {
+			t.Error("expected connection to be reused after draining")
+		}
+	})
+}
+
+// TestRequestBody_EnforcesLimit verifies that handlers cannot read past the limit.
+func TestRequestBody_EnforcesLimit(t *testing.T) {
+	var readErr error
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	wrapped := RequestBody(10)(handler)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("this body is longer than ten bytes"))
+	rr := httptest.NewRecorder()
+
+	wrapped.ServeHTTP(rr, req)
+
+	if readErr == nil {
+		t.Fatal("expected error reading past the body limit")
+	}
+
+	var maxErr *http.MaxBytesError
+	if !errors.As(readErr, &maxErr) {
+		t.Errorf("expected *http.MaxBytesError, got %T: %v", readErr, readErr)
+	}
+}
+
+// TestRequestBody_NoBody verifies requests without a body pass through untouched.
+func TestRequestBody_NoBody(t *testing.T) {
+	called := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if r.Body != http.NoBody {
+			t.Errorf("expected http.NoBody, got %T", r.Body)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+
+	RequestBody(1024)(handler).ServeHTTP(rr, req)
+
+	if !called {
+		t.Error("inner handler was not called")
+	}
+}
+
+// closeTracker records whether Close was called and how much was read.
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+// TestDrainBody tests bounded draining and closing of bodies.
+func TestDrainBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		limit     int64
+		remaining int
+	}{
+		{"fully drained within limit", 100, 1000, 0},
+		{"stops at limit", 100, 40, 60},
+		{"unbounded drain", 100, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bytes.NewReader(bytes.Repeat([]byte("x"), tt.size))
+			body := &closeTracker{Reader: reader}
+
+			DrainBody(body, tt.limit)
+
+			if !body.closed {
+				t.Error("expected body to be closed")
+			}
+			if reader.Len() != tt.remaining {
+				t.Errorf("expected %d bytes remaining, got %d", tt.remaining, reader.Len())
+			}
+		})
+	}
+
+	// A nil body must not panic
+	DrainBody(nil, 10)
+}
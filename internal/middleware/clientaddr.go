@@ -0,0 +1,173 @@
+// Package middleware provides a record of how client addresses were found.
+// Behind a reverse proxy, the address a request is rate limited by comes
+// from a forwarding header rather than the connection, and a proxy that
+// doesn't set the header, or a server that doesn't trust it, puts every
+// visitor behind one address. The middleware that rewrites RemoteAddr
+// notes what it saw, so /admin/whoami can show an operator the outcome.
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ClientAddr records how a request's RemoteAddr was arrived at.
+type ClientAddr struct {
+	Peer    string // RemoteAddr of the connection, before any rewriting
+	Header  string // Header RemoteAddr was taken from; "" if it wasn't
+	Trusted bool   // Whether forwarding headers from Peer are believed
+}
+
+type clientAddrKey struct{}
+
+// proxiesKey carries the trusted proxies of ProxyRealIP, for ForwardedAddr.
+type proxiesKey struct{}
+
+// withClientAddr returns r carrying a, which the caller may still fill in.
+func withClientAddr(r *http.Request, a *ClientAddr) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), clientAddrKey{}, a))
+}
+
+// ClientAddrFrom returns the record carried by ctx, if a middleware of
+// this package set one.
+func ClientAddrFrom(ctx context.Context) (ClientAddr, bool) {
+	a, ok := ctx.Value(clientAddrKey{}).(*ClientAddr)
+	if !ok {
+		return ClientAddr{}, false
+	}
+	return *a, true
+}
+
+// realIPHeaders are the headers chi's RealIP takes addresses from, in the
+// order it tries them.
+var realIPHeaders = []string{"True-Client-IP", "X-Real-IP", "X-Forwarded-For"}
+
+// RealIP is chi's RealIP, which trusts forwarding headers from every
+// peer, recording a ClientAddr.
+func RealIP(next http.Handler) http.Handler {
+	record := middleware.RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := r.Context().Value(clientAddrKey{}).(*ClientAddr)
+		if r.RemoteAddr != a.Peer {
+			for _, name := range realIPHeaders {
+				if r.Header.Get(name) != "" {
+					a.Header = name
+					break
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record.ServeHTTP(w, withClientAddr(r, &ClientAddr{Peer: r.RemoteAddr, Trusted: true}))
+	})
+}
+
+// ProxyRealIP returns middleware like RealIP that believes forwarding
+// headers only from peers in proxies, and of those only header ("" for
+// X-Forwarded-For): a proxy that sets one header passes the others through
+// from the client. Requests from other peers keep their connection address.
+// The headers RealIP reads, other than a trusted header, are removed. Of an
+// X-Forwarded-For list, the client is the last address not in proxies:
+// those before it were set by whoever reached the first proxy, and can't be
+// told from spoofed ones.
+func ProxyRealIP(proxies []netip.Prefix, header string) func(http.Handler) http.Handler {
+	if header == "" {
+		header = "X-Forwarded-For"
+	}
+	header = http.CanonicalHeaderKey(header)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			record := &ClientAddr{Peer: r.RemoteAddr}
+			r = withClientAddr(r, record)
+			r = r.WithContext(context.WithValue(r.Context(), proxiesKey{}, proxies))
+
+			if peer, ok := parseAddr(r.RemoteAddr); ok && containsAddr(proxies, peer) {
+				record.Trusted = true
+			}
+			for _, name := range realIPHeaders {
+				if !record.Trusted || http.CanonicalHeaderKey(name) != header {
+					r.Header.Del(name)
+				}
+			}
+			if record.Trusted {
+				if client, ok := lastUntrusted(r.Header.Get(header), proxies); ok {
+					r.RemoteAddr = net.JoinHostPort(client.String(), "0")
+					record.Header = header
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ForwardedAddr returns the client address r's header gives, or "" if it
+// has none or r came from a peer not trusted to set it. Of a list, as in
+// X-Forwarded-For, it is the first address, or behind ProxyRealIP the last
+// not of a trusted proxy.
+func ForwardedAddr(r *http.Request, header string) string {
+	value := r.Header.Get(header)
+	if value == "" {
+		return ""
+	}
+	if a, ok := ClientAddrFrom(r.Context()); ok && !a.Trusted {
+		return ""
+	}
+	proxies, ok := r.Context().Value(proxiesKey{}).([]netip.Prefix)
+	if !ok {
+		first, _, _ := strings.Cut(value, ",")
+		return strings.TrimSpace(first)
+	}
+	if client, ok := lastUntrusted(value, proxies); ok {
+		return client.String()
+	}
+	return ""
+}
+
+// lastUntrusted returns the last address of a comma-separated list that
+// isn't in proxies, or the first if all of them are. It fails if an
+// address it comes to doesn't parse.
+func lastUntrusted(list string, proxies []netip.Prefix) (netip.Addr, bool) {
+	if list == "" {
+		return netip.Addr{}, false
+	}
+	items := strings.Split(list, ",")
+	for i := len(items) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(strings.TrimSpace(items[i]))
+		if !ok {
+			return netip.Addr{}, false
+		}
+		if i == 0 || !containsAddr(proxies, addr) {
+			return addr, true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// parseAddr parses an address, with or without a port.
+func parseAddr(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(s)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addrPort.Addr()
+	}
+	return addr.WithZone("").Unmap(), true
+}
+
+// containsAddr reports whether any of networks contains addr.
+func containsAddr(networks []netip.Prefix, addr netip.Addr) bool {
+	for _, network := range networks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
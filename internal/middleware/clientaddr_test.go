@@ -0,0 +1,174 @@
+// Package middleware provides tests for client address records.
+package middleware
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/cloudflare"
+)
+
+func TestClientAddr(t *testing.T) {
+	var got ClientAddr
+	var recorded bool
+	record := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, recorded = ClientAddrFrom(r.Context())
+	})
+	realIP := RealIP(record)
+	cf := Cloudflare(cloudflare.New(), slog.New(slog.NewTextHandler(io.Discard, nil)))(record)
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		peer    string
+		header  string
+		value   string
+		want    ClientAddr
+	}{
+		{"direct", realIP, "192.0.2.1:5000", "", "", ClientAddr{Peer: "192.0.2.1:5000", Trusted: true}},
+		{"forwarded", realIP, "10.0.0.2:5000", "X-Forwarded-For", "198.51.100.7, 10.0.0.1",
+			ClientAddr{Peer: "10.0.0.2:5000", Header: "X-Forwarded-For", Trusted: true}},
+		{"invalid header", realIP, "10.0.0.2:5000", "X-Real-IP", "unknown", ClientAddr{Peer: "10.0.0.2:5000", Trusted: true}},
+		{"cloudflare", cf, "104.16.1.1:443", cloudflare.Header, "198.51.100.7",
+			ClientAddr{Peer: "104.16.1.1:443", Header: cloudflare.Header, Trusted: true}},
+		{"cloudflare bypassed", cf, "192.0.2.1:5000", cloudflare.Header, "198.51.100.7", ClientAddr{Peer: "192.0.2.1:5000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			tt.handler.ServeHTTP(httptest.NewRecorder(), req)
+			if !recorded || got != tt.want {
+				t.Errorf("expected %+v, got %+v (recorded %v)", tt.want, got, recorded)
+			}
+		})
+	}
+
+	if _, ok := ClientAddrFrom(httptest.NewRequest(http.MethodGet, "/", nil).Context()); ok {
+		t.Error("expected no record without the middleware")
+	}
+}
+
+func TestProxyRealIP(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")}
+	var got ClientAddr
+	var remote, forwarded string
+	handler := ProxyRealIP(proxies, "")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ClientAddrFrom(r.Context())
+		remote = r.RemoteAddr
+		forwarded = ForwardedAddr(r, "X-Forwarded-For")
+	}))
+
+	tests := []struct {
+		name      string
+		peer      string
+		header    string
+		value     string
+		remote    string
+		forwarded string
+		want      ClientAddr
+	}{
+		{"direct", "192.0.2.1:5000", "", "", "192.0.2.1:5000", "",
+			ClientAddr{Peer: "192.0.2.1:5000"}},
+		{"spoofed", "192.0.2.1:5000", "X-Forwarded-For", "198.51.100.7", "192.0.2.1:5000", "",
+			ClientAddr{Peer: "192.0.2.1:5000"}},
+		{"spoofed real ip", "192.0.2.1:5000", "X-Real-IP", "198.51.100.7", "192.0.2.1:5000", "",
+			ClientAddr{Peer: "192.0.2.1:5000"}},
+		{"proxied", "10.0.0.2:5000", "X-Forwarded-For", "198.51.100.7", "198.51.100.7:0", "198.51.100.7",
+			ClientAddr{Peer: "10.0.0.2:5000", Header: "X-Forwarded-For", Trusted: true}},
+		{"proxied chain", "10.0.0.2:5000", "X-Forwarded-For", "203.0.113.9, 198.51.100.7, 10.0.0.1",
+			"198.51.100.7:0", "198.51.100.7",
+			ClientAddr{Peer: "10.0.0.2:5000", Header: "X-Forwarded-For", Trusted: true}},
+		{"proxies only", "[2001:db8::2]:443", "X-Forwarded-For", "10.0.0.3, 10.0.0.1", "10.0.0.3:0", "10.0.0.3",
+			ClientAddr{Peer: "[2001:db8::2]:443", Header: "X-Forwarded-For", Trusted: true}},
+		{"invalid header", "10.0.0.2:5000", "X-Forwarded-For", "198.51.100.7, unknown", "10.0.0.2:5000", "",
+			ClientAddr{Peer: "10.0.0.2:5000", Trusted: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+			if remote != tt.remote {
+				t.Errorf("expected RemoteAddr %q, got %q", tt.remote, remote)
+			}
+			if forwarded != tt.forwarded {
+				t.Errorf("expected forwarded address %q, got %q", tt.forwarded, forwarded)
+			}
+		})
+	}
+}
+
+// TestProxyRealIP_OnlyConfiguredHeader tests that a trusted proxy which
+// only appends X-Forwarded-For doesn't pass on other headers the client set.
+func TestProxyRealIP_OnlyConfiguredHeader(t *testing.T) {
+	proxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for _, header := range []string{"", "X-Forwarded-For"} {
+		var got ClientAddr
+		var remote string
+		var spoofed []string
+		handler := ProxyRealIP(proxies, header)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = ClientAddrFrom(r.Context())
+			remote = r.RemoteAddr
+			spoofed = []string{r.Header.Get("True-Client-IP"), r.Header.Get("X-Real-IP")}
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.2:5000"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		req.Header.Set("True-Client-IP", "203.0.113.66")
+		req.Header.Set("X-Real-IP", "203.0.113.67")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if remote != "198.51.100.7:0" {
+			t.Errorf("header %q: expected RemoteAddr from X-Forwarded-For, got %q", header, remote)
+		}
+		if got.Header != "X-Forwarded-For" {
+			t.Errorf("header %q: expected X-Forwarded-For recorded, got %q", header, got.Header)
+		}
+		if spoofed[0] != "" || spoofed[1] != "" {
+			t.Errorf("header %q: expected other headers removed, got %q", header, spoofed)
+		}
+	}
+
+	// A configured header is the only one read
+	var remote string
+	handler := ProxyRealIP(proxies, "X-Real-IP")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote = r.RemoteAddr
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.2:5000"
+	req.Header.Set("True-Client-IP", "203.0.113.66")
+	req.Header.Set("X-Real-IP", "198.51.100.7")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if remote != "198.51.100.7:0" {
+		t.Errorf("expected RemoteAddr from X-Real-IP, got %q", remote)
+	}
+}
+
+func TestForwardedAddr_RealIP(t *testing.T) {
+	// Behind RealIP every peer is trusted, and lists give their first address
+	var forwarded string
+	handler := RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = ForwardedAddr(r, "X-Client-IP")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Client-IP", " 198.51.100.7 , 10.0.0.1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if forwarded != "198.51.100.7" {
+		t.Errorf("expected 198.51.100.7, got %q", forwarded)
+	}
+}
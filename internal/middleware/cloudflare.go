@@ -0,0 +1,79 @@
+// Package middleware provides client address handling behind Cloudflare.
+// Only the connection's peer address can be trusted: a request that
+// bypassed Cloudflare and hit the origin directly can carry any
+// CF-Connecting-IP header it likes, and would otherwise pick its own
+// address for rate limits, denylists, and logs.
+package middleware
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/cloudflare"
+)
+
+// directWarnInterval is how often requests that bypassed Cloudflare are
+// reported; each report counts those since the last one.
+const directWarnInterval = time.Minute
+
+// Cloudflare returns middleware for servers behind Cloudflare, in place of
+// chi's RealIP. Requests from Cloudflare's networks get the visitor's
+// address from CF-Connecting-IP as their RemoteAddr. Any other request
+// reached the server directly: the header is removed and a warning logged,
+// at most once per minute. Loopback and private peers, such as health
+// checks and sidecars, are let through without a warning. Like RealIP, it
+// records a ClientAddr.
+func Cloudflare(ranges *cloudflare.Ranges, logger *slog.Logger) func(http.Handler) http.Handler {
+	var (
+		mu       sync.Mutex
+		direct   int
+		lastWarn time.Time
+	)
+	warn := func(peer netip.Addr) {
+		mu.Lock()
+		defer mu.Unlock()
+		direct++
+		if time.Since(lastWarn) < directWarnInterval {
+			return
+		}
+		logger.Warn("Requests are reaching the server without passing through Cloudflare",
+			"remote", peer.String(), "count", direct)
+		direct = 0
+		lastWarn = time.Now()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			record := &ClientAddr{Peer: r.RemoteAddr}
+			r = withClientAddr(r, record)
+
+			peer, err := netip.ParseAddrPort(r.RemoteAddr)
+			if err != nil {
+				r.Header.Del(cloudflare.Header)
+				next.ServeHTTP(w, r)
+				return
+			}
+			addr := peer.Addr().Unmap()
+
+			if !ranges.Contains(addr) {
+				r.Header.Del(cloudflare.Header)
+				if !addr.IsLoopback() && !addr.IsPrivate() {
+					warn(addr)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			record.Trusted = true
+			if visitor, err := netip.ParseAddr(r.Header.Get(cloudflare.Header)); err == nil {
+				r.RemoteAddr = net.JoinHostPort(visitor.Unmap().String(), "0")
+				record.Header = cloudflare.Header
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
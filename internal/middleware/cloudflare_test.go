@@ -0,0 +1,60 @@
+// Package middleware provides tests for client addresses behind Cloudflare.
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/cloudflare"
+)
+
+func TestCloudflare(t *testing.T) {
+	var logs bytes.Buffer
+	var remote, header string
+	h := Cloudflare(cloudflare.New(), slog.New(slog.NewTextHandler(&logs, nil)))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			remote, header = r.RemoteAddr, r.Header.Get(cloudflare.Header)
+		}))
+
+	serve := func(peer string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = peer
+		req.Header.Set(cloudflare.Header, "198.51.100.7")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Proxied: the visitor's address replaces Cloudflare's
+	serve("104.16.1.1:443")
+	if remote != "198.51.100.7:0" || header != "198.51.100.7" {
+		t.Errorf("expected visitor address, got %q (header %q)", remote, header)
+	}
+	serve("[2606:4700::1]:443")
+	if remote != "198.51.100.7:0" {
+		t.Errorf("expected visitor address over IPv6, got %q", remote)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("expected no warnings for proxied requests, got %q", logs.String())
+	}
+
+	// Direct: the header is dropped and the bypass reported once a minute
+	serve("192.0.2.1:5000")
+	if remote != "192.0.2.1:5000" || header != "" {
+		t.Errorf("expected peer address and no header, got %q (header %q)", remote, header)
+	}
+	serve("192.0.2.2:5000")
+	if n := strings.Count(logs.String(), "without passing through Cloudflare"); n != 1 {
+		t.Errorf("expected 1 warning, got %d: %q", n, logs.String())
+	}
+
+	// Private peers such as health checks are not reported
+	logs.Reset()
+	serve("10.0.0.5:5000")
+	serve("127.0.0.1:5000")
+	if header != "" || logs.Len() != 0 {
+		t.Errorf("expected private peers stripped without warning, got header %q, logs %q", header, logs.String())
+	}
+}
@@ -0,0 +1,145 @@
+// Package middleware provides response compression.
+// Ciphertext barely compresses, but the pages, scripts, and JSON envelopes
+// around it do, often to a fifth of their size. Responses of the listed
+// content types are compressed with brotli or gzip, whichever the client
+// prefers; static assets come precompressed and pass through untouched.
+// Event streams are never compressed, as the compressor would hold events
+// back until enough of them piled up.
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/liskl/flashpaper/internal/assets"
+)
+
+// eventStreamType is the content type that is never compressed.
+const eventStreamType = "text/event-stream"
+
+// encoder is a compressing writer that can be flushed and reused.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Compress returns middleware compressing responses of the given content
+// types at level (1, fastest, to 9, smallest) for clients that accept it.
+// Responses that already have a Content-Encoding are left as they are.
+func Compress(level int, types []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(types))
+	for _, t := range types {
+		allowed[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	delete(allowed, eventStreamType)
+
+	pools := map[string]*sync.Pool{
+		assets.EncodingBrotli: {New: func() any { return brotli.NewWriterLevel(nil, level) }},
+		assets.EncodingGzip: {New: func() any {
+			w, _ := gzip.NewWriterLevel(nil, level) // Validate checked level
+			return w
+		}},
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				allowed:        allowed,
+				encoding: assets.Negotiate(r.Header.Get("Accept-Encoding"), func(string) bool {
+					return true
+				}),
+				pools: pools,
+			}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter compresses a response once its headers show it should be.
+type compressWriter struct {
+	http.ResponseWriter
+	allowed  map[string]bool
+	encoding string // Negotiated with the client; "" for none
+	pools    map[string]*sync.Pool
+
+	wroteHeader bool
+	enc         encoder // Set while compressing
+}
+
+// WriteHeader decides whether to compress the response, then sends the
+// headers.
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader || code < http.StatusOK {
+		// Informational responses come before the final headers
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if cw.allowed[mediaType] && h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		code != http.StatusNoContent && code != http.StatusNotModified {
+		// Caches must key on Accept-Encoding, compressed or not
+		h.Add("Vary", "Accept-Encoding")
+		if cw.encoding != "" {
+			h.Set("Content-Encoding", cw.encoding)
+			h.Del("Content-Length")
+			if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+				h.Set("ETag", "W/"+etag) // Not the bytes the ETag was made for
+			}
+			cw.enc = cw.pools[cw.encoding].Get().(encoder)
+			cw.enc.Reset(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// Write compresses p if the response is being compressed.
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been compressed so far to the client.
+func (cw *compressWriter) Flush() {
+	if cw.enc != nil {
+		_ = cw.enc.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can
+// reach connection deadlines through the compressor.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the compressed stream and returns the encoder to its pool.
+func (cw *compressWriter) close() {
+	if cw.enc == nil {
+		return
+	}
+	_ = cw.enc.Close()
+	cw.enc.Reset(nil)
+	cw.pools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+}
@@ -0,0 +1,171 @@
+// Package middleware provides tests for response compression.
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// compressBody is a response body that compresses well.
+var compressBody = strings.Repeat(`{"status":0,"id":"f468483c313401e8"}`, 100)
+
+// serveCompressed runs a request with Accept-Encoding accept through the
+// compression middleware, with next setting the response headers.
+func serveCompressed(t *testing.T, accept string, next func(w http.ResponseWriter)) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := Compress(5, config.DefaultCompressTypes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w)
+		io.WriteString(w, compressBody)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if accept != "" {
+		req.Header.Set("Accept-Encoding", accept)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+// decodeBody decodes a response body by its Content-Encoding.
+func decodeBody(t *testing.T, rr *httptest.ResponseRecorder) string {
+	t.Helper()
+	var r io.Reader = rr.Body
+	switch rr.Header().Get("Content-Encoding") {
+	case "gzip":
+		gz, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	case "br":
+		r = brotli.NewReader(rr.Body)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCompress_Negotiation(t *testing.T) {
+	json := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", "3600")
+		w.Header().Set("ETag", `"abc"`)
+	}
+	tests := []struct {
+		accept   string
+		encoding string
+	}{
+		{"gzip, deflate, br", "br"},
+		{"gzip", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"", ""},
+		{"identity", ""},
+	}
+	for _, tt := range tests {
+		rr := serveCompressed(t, tt.accept, json)
+		if got := rr.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%q: expected encoding %q, got %q", tt.accept, tt.encoding, got)
+		}
+		if rr.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("%q: expected Vary: Accept-Encoding, got %q", tt.accept, rr.Header().Get("Vary"))
+		}
+		if body := decodeBody(t, rr); body != compressBody {
+			t.Errorf("%q: body doesn't round-trip", tt.accept)
+		}
+		if tt.encoding == "" {
+			continue
+		}
+		if rr.Body.Len() >= len(compressBody) {
+			t.Errorf("%q: expected a smaller body, got %d bytes", tt.accept, rr.Body.Len())
+		}
+		if rr.Header().Get("Content-Length") != "" || rr.Header().Get("ETag") != `W/"abc"` {
+			t.Errorf("%q: expected no Content-Length and a weak ETag, got %v", tt.accept, rr.Header())
+		}
+	}
+}
+
+func TestCompress_Skipped(t *testing.T) {
+	tests := map[string]func(w http.ResponseWriter){
+		"unlisted type": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "image/png")
+		},
+		"no type": func(w http.ResponseWriter) {},
+		"precompressed": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/css")
+			w.Header().Set("Content-Encoding", "identity")
+		},
+		"event stream": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "text/event-stream")
+		},
+		"no content": func(w http.ResponseWriter) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotModified)
+		},
+	}
+	for name, next := range tests {
+		rr := serveCompressed(t, "gzip, br", next)
+		if enc := rr.Header().Get("Content-Encoding"); enc == "gzip" || enc == "br" {
+			t.Errorf("%s: expected no compression, got %q", name, enc)
+		}
+		if rr.Code != http.StatusNotModified && rr.Body.String() != compressBody {
+			t.Errorf("%s: expected the body unchanged", name)
+		}
+	}
+}
+
+func TestCompress_EventStreamNeverCompressed(t *testing.T) {
+	// Even when listed, events must reach clients as they're written
+	handler := Compress(5, []string{"text/event-stream"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: {}\n\n")
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("expected flushing through the compressor, got %v", err)
+		}
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "data: {}\n\n" || !rr.Flushed {
+		t.Errorf("expected the event flushed uncompressed, got %q %q", rr.Header().Get("Content-Encoding"), rr.Body.String())
+	}
+}
+
+func TestCompress_Flush(t *testing.T) {
+	// Flushed output must decode up to what was written so far
+	var partial string
+	handler := Compress(5, config.DefaultCompressTypes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"first":`)
+		w.(http.Flusher).Flush()
+		partial = w.(interface{ Unwrap() http.ResponseWriter }).Unwrap().(*httptest.ResponseRecorder).Body.String()
+		io.WriteString(w, `true}`)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	gz, err := gzip.NewReader(strings.NewReader(partial))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 64)
+	n, _ := io.ReadAtLeast(gz, got, len(`{"first":`))
+	if string(got[:n]) != `{"first":` {
+		t.Errorf("expected the flushed part, got %q", got[:n])
+	}
+	if body := decodeBody(t, rr); body != `{"first":true}` {
+		t.Errorf("expected the whole body, got %q", body)
+	}
+}
@@ -0,0 +1,56 @@
+// Package middleware provides a cap on requests in flight.
+// A small instance under a traffic spike otherwise accepts every request,
+// buffers every upload, and is OOM-killed, failing all of them. With a cap,
+// requests beyond it are turned away at once with 503 and Retry-After, and
+// the ones already admitted finish. Reads and writes have separate caps,
+// so a flood of large uploads doesn't stop pastes from being read.
+package middleware
+
+import (
+	"net/http"
+	"slices"
+)
+
+// concurrencyRetryAfter is the Retry-After of refused requests, in seconds.
+// Slots free up as soon as any admitted request finishes.
+const concurrencyRetryAfter = "1"
+
+// Concurrency returns middleware that serves at most maxReads GET and
+// HEAD requests and at most maxWrites other requests at once, answering
+// the rest with 503 Service Unavailable. A cap of 0 or less is unlimited.
+// Requests for the exempt paths, such as health checks, are always served.
+func Concurrency(maxReads, maxWrites int, exempt ...string) func(http.Handler) http.Handler {
+	reads, writes := semaphore(maxReads), semaphore(maxWrites)
+	return func(next http.Handler) http.Handler {
+		if reads == nil && writes == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slots := writes
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				slots = reads
+			}
+			if slots == nil || slices.Contains(exempt, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			default:
+				w.Header().Set("Retry-After", concurrencyRetryAfter)
+				http.Error(w, "Server busy", http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
+
+// semaphore returns a channel with n slots, or nil for no limit.
+func semaphore(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
@@ -0,0 +1,77 @@
+// Package middleware provides tests for the concurrency limiter.
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestConcurrency tests that requests beyond a cap are refused while the
+// admitted ones are in flight, and that the other cap is unaffected.
+func TestConcurrency(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := Concurrency(1, 1, "/healthz")(blocking)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	// Take the only read slot
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve(http.MethodGet, "/slow")
+	}()
+	<-started
+
+	rr := serve(http.MethodGet, "/")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After 1, got %q", got)
+	}
+	if rr := serve(http.MethodHead, "/"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected HEAD to count as a read, got %d", rr.Code)
+	}
+	if rr := serve(http.MethodPost, "/"); rr.Code != http.StatusOK {
+		t.Errorf("expected writes to have their own cap, got %d", rr.Code)
+	}
+	if rr := serve(http.MethodGet, "/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("expected exempt path to be served, got %d", rr.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	if rr := serve(http.MethodGet, "/"); rr.Code != http.StatusOK {
+		t.Errorf("expected the slot to be freed, got %d", rr.Code)
+	}
+}
+
+// TestConcurrency_Unlimited tests that a cap of 0 admits everything.
+func TestConcurrency_Unlimited(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	if got := Concurrency(0, 0)(next); got == nil {
+		t.Fatal("expected a handler")
+	}
+
+	handler := Concurrency(0, 1)(next)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+}
@@ -0,0 +1,84 @@
+// Package middleware provides Cross-Origin Resource Sharing for the JSON
+// API, so pages and browser extensions of other origins can create and
+// read pastes. Preflight requests are answered here, before routing, since
+// the API has no OPTIONS routes of its own.
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// corsMaxAge is how long browsers may cache a preflight answer, in seconds.
+const corsMaxAge = "600"
+
+// corsExposeHeaders are the API's response headers scripts may read
+// besides the basic ones: where the client stands with the rate limits
+// and when to retry after a 429, and the name and category of a raw
+// download.
+const corsExposeHeaders = "Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, " +
+	"Content-Disposition, X-Content-Category"
+
+// CORS returns middleware that lets the configured origins call the
+// given paths. A path ending in "/*" covers everything below it. With no
+// origins configured it does nothing.
+func CORS(cfg config.SecurityConfig, paths ...string) func(http.Handler) http.Handler {
+	anyOrigin := slices.Contains(cfg.CORSOrigins, "*")
+	methods := strings.Join(cfg.CORSMethods, ", ")
+	headers := strings.Join(cfg.CORSHeaders, ", ")
+
+	return func(next http.Handler) http.Handler {
+		if len(cfg.CORSOrigins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !matchPath(paths, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// Responses differ by origin, so caches must keep them apart
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if origin == "" || !(anyOrigin || slices.Contains(cfg.CORSOrigins, origin)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !preflight {
+				w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Browsers check the request against these lists themselves
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// matchPath reports whether path is one of paths or below one ending in
+// "/*".
+func matchPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok && strings.HasSuffix(prefix, "/") {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if p == path {
+			return true
+		}
+	}
+	return false
+}
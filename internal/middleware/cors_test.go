@@ -0,0 +1,112 @@
+// Package middleware provides tests for CORS handling.
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// serveCORS sends a request with the given origin through the CORS
+// middleware and reports whether the handler behind it ran.
+func serveCORS(t *testing.T, cfg config.SecurityConfig, method, path, origin string, preflight bool) (*httptest.ResponseRecorder, bool) {
+	t.Helper()
+	served := false
+	handler := CORS(cfg, "/", "/api/*")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+	req := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type, x-requested-with")
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, served
+}
+
+// TestCORS tests requests from allowed and other origins.
+func TestCORS(t *testing.T) {
+	cfg := config.DefaultConfig().Security
+	cfg.CORSOrigins = []string{"https://app.example.com", "moz-extension://abc"}
+
+	tests := []struct {
+		name      string
+		path      string
+		origin    string
+		wantAllow string
+	}{
+		{"allowed origin", "/", "https://app.example.com", "https://app.example.com"},
+		{"prefix path", "/api/v1/secret", "https://app.example.com", "https://app.example.com"},
+		{"other origin", "/", "https://evil.example.com", ""},
+		{"no origin", "/", "", ""},
+		{"path not covered", "/admin/pastes", "https://app.example.com", ""},
+		{"prefix is not a path", "/api", "https://app.example.com", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, served := serveCORS(t, cfg, http.MethodGet, tt.path, tt.origin, false)
+			if !served {
+				t.Error("expected the request to reach the handler")
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.wantAllow {
+				t.Errorf("expected Access-Control-Allow-Origin %q, got %q", tt.wantAllow, got)
+			}
+		})
+	}
+}
+
+// TestCORS_Preflight tests that preflights are answered without reaching
+// the router, which has no OPTIONS routes.
+func TestCORS_Preflight(t *testing.T) {
+	cfg := config.DefaultConfig().Security
+	cfg.CORSOrigins = []string{"https://app.example.com"}
+
+	rr, served := serveCORS(t, cfg, http.MethodOptions, "/", "https://app.example.com", true)
+	if served {
+		t.Error("expected the preflight to be answered by the middleware")
+	}
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, rr.Code)
+	}
+	for header, expected := range map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, POST, PUT, DELETE",
+		"Access-Control-Allow-Headers": "Content-Type, X-Requested-With, Authorization, X-Access-Proof, X-Secret-Key, X-Delete-Token",
+		"Access-Control-Max-Age":       corsMaxAge,
+		"Vary":                         "Origin",
+	} {
+		if got := rr.Header().Get(header); got != expected {
+			t.Errorf("%s: expected %q, got %q", header, expected, got)
+		}
+	}
+
+	// Preflights from other origins go on unanswered
+	rr, served = serveCORS(t, cfg, http.MethodOptions, "/", "https://evil.example.com", true)
+	if !served || rr.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Error("expected a preflight from another origin to get no CORS headers")
+	}
+}
+
+// TestCORS_AnyOrigin tests the "*" wildcard and the disabled default.
+func TestCORS_AnyOrigin(t *testing.T) {
+	cfg := config.DefaultConfig().Security
+	rr, _ := serveCORS(t, cfg, http.MethodGet, "/", "https://app.example.com", false)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected CORS off by default, got %q", got)
+	}
+
+	cfg.CORSOrigins = []string{"*"}
+	rr, _ = serveCORS(t, cfg, http.MethodPost, "/", "https://anywhere.example.com", false)
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected Access-Control-Allow-Origin *, got %q", got)
+	}
+	if got := rr.Header().Get("Access-Control-Expose-Headers"); got != corsExposeHeaders {
+		t.Errorf("expected %q to be exposed, got %q", corsExposeHeaders, got)
+	}
+}
@@ -0,0 +1,94 @@
+// Package middleware provides structured request logging for FlashPaper.
+// Each request gets a logger carrying its request ID, and its trace ID when
+// it is traced, in the context (see logging.FromContext) and one log line
+// when it completes. Requests are
+// logged by route pattern; the actual path and query, which carry paste IDs
+// and delete tokens, are added as [main] logprivacy allows: verbatim at debug
+// level only (the default), masked or hashed at every level, or verbatim at
+// every level.
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/logging"
+	"github.com/liskl/flashpaper/internal/tracing"
+)
+
+// secretPattern matches paste and comment IDs (16 hex digits), delete
+// tokens (64), and any other long hex run a request URI carries.
+var secretPattern = regexp.MustCompile(`\b[0-9a-fA-F]{16,}\b`)
+
+// RequestLogger returns middleware logging each request to logger, showing
+// request URIs as privacy, a config.LogPrivacy mode, allows. It must run
+// after chi's RequestID middleware and inside a chi router, like Metrics.
+func RequestLogger(logger *slog.Logger, privacy string) func(http.Handler) http.Handler {
+	uri := uriFormatter(privacy)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			l := logger
+			if id := middleware.GetReqID(r.Context()); id != "" {
+				l = l.With("request_id", id)
+			}
+			if id := tracing.FromContext(r.Context()).TraceID(); id != "" {
+				l = l.With("trace_id", id)
+			}
+			next.ServeHTTP(ww, r.WithContext(logging.WithLogger(r.Context(), l)))
+
+			code := status(ww)
+			level := slog.LevelInfo
+			if code >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("route", routePattern(r)),
+				slog.Int("status", code),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote", r.RemoteAddr),
+			}
+			if privacy != config.LogPrivacyDebug || l.Enabled(r.Context(), slog.LevelDebug) {
+				attrs = append(attrs, slog.String("uri", uri(r.RequestURI)))
+			}
+			l.LogAttrs(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+// uriFormatter returns how request URIs are logged in a privacy mode.
+// Hashes are keyed per process, so they can't be reversed by hashing every
+// possible ID, but also don't match across restarts or replicas.
+func uriFormatter(privacy string) func(string) string {
+	switch privacy {
+	case config.LogPrivacyRedact:
+		return func(uri string) string {
+			return secretPattern.ReplaceAllLiteralString(uri, "[redacted]")
+		}
+	case config.LogPrivacyHash:
+		key := make([]byte, 32)
+		rand.Read(key)
+		return func(uri string) string {
+			return secretPattern.ReplaceAllStringFunc(uri, func(secret string) string {
+				mac := hmac.New(sha256.New, key)
+				mac.Write([]byte(secret))
+				return "[" + hex.EncodeToString(mac.Sum(nil)[:6]) + "]"
+			})
+		}
+	default:
+		return func(uri string) string { return uri }
+	}
+}
@@ -0,0 +1,96 @@
+// Package middleware provides tests for request logging.
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/logging"
+)
+
+// loggedRequest serves GET /raw/f468483c313401e8?download through
+// RequestLogger at the given level and privacy mode and returns the log
+// output.
+func loggedRequest(t *testing.T, level, privacy string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, level, logging.FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(RequestLogger(logger, privacy))
+	r.Get("/raw/{id}", func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("inside")
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/raw/f468483c313401e8?download", nil))
+	return buf.String()
+}
+
+// TestRequestLogger tests the request line and the request-scoped logger.
+func TestRequestLogger(t *testing.T) {
+	out := loggedRequest(t, "info", config.LogPrivacyDebug)
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d:\n%s", len(lines), out)
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "request_id=") {
+			t.Errorf("expected request ID in %q", line)
+		}
+	}
+	for _, want := range []string{"method=GET", "route=/raw/{id}", "status=404"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("expected %s in %q", want, lines[1])
+		}
+	}
+}
+
+// TestRequestLogger_PasteIDsOnlyAtDebug tests that paths carrying paste IDs
+// are left out unless debug logging is on.
+func TestRequestLogger_PasteIDsOnlyAtDebug(t *testing.T) {
+	if out := loggedRequest(t, "info", config.LogPrivacyDebug); strings.Contains(out, "f468483c313401e8") {
+		t.Errorf("paste ID logged at info level:\n%s", out)
+	}
+	if out := loggedRequest(t, "debug", config.LogPrivacyDebug); !strings.Contains(out, "uri=/raw/f468483c313401e8?download") {
+		t.Errorf("expected the request URI at debug level:\n%s", out)
+	}
+}
+
+// TestRequestLogger_Privacy tests that the privacy modes log the request
+// URI at every level, masking or hashing the paste ID unless turned off.
+func TestRequestLogger_Privacy(t *testing.T) {
+	if out := loggedRequest(t, "debug", config.LogPrivacyRedact); !strings.Contains(out, "uri=/raw/[redacted]?download") {
+		t.Errorf("expected the redacted request URI:\n%s", out)
+	}
+	if out := loggedRequest(t, "info", config.LogPrivacyOff); !strings.Contains(out, "uri=/raw/f468483c313401e8?download") {
+		t.Errorf("expected the request URI at info level:\n%s", out)
+	}
+
+	out := loggedRequest(t, "info", config.LogPrivacyHash)
+	if strings.Contains(out, "f468483c313401e8") {
+		t.Errorf("paste ID logged in hash mode:\n%s", out)
+	}
+	if !regexp.MustCompile(`uri=/raw/\[[0-9a-f]{12}\]\?download`).MatchString(out) {
+		t.Errorf("expected the hashed request URI:\n%s", out)
+	}
+
+	// One process hashes the same ID the same way, so requests correlate
+	hash := uriFormatter(config.LogPrivacyHash)
+	if a, b := hash("/raw/f468483c313401e8"), hash("/?f468483c313401e8"); a[len("/raw/"):] != b[len("/?"):] {
+		t.Errorf("expected matching hashes, got %q and %q", a, b)
+	}
+}
@@ -0,0 +1,58 @@
+// Package middleware provides HTTP request metrics for FlashPaper.
+// Requests are timed into a histogram labeled by method, route pattern, and
+// status code. Route patterns ("/api/v1/paste/{pasteID}") rather than paths
+// keep the number of series bounded and paste IDs out of the metrics.
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/liskl/flashpaper/internal/metrics"
+)
+
+// httpLatencyBuckets spans 1ms to about 65s.
+var httpLatencyBuckets = metrics.ExponentialBuckets(0.001, 4, 9)
+
+// Metrics returns middleware recording request durations in r as
+// flashpaper_http_request_duration_seconds. It must run inside a chi
+// router so the matched route pattern is known once the request is done.
+func Metrics(r *metrics.Registry) func(http.Handler) http.Handler {
+	latency := r.HistogramVec("flashpaper_http_request_duration_seconds",
+		"HTTP request latency in seconds, by method, route, and status code.",
+		httpLatencyBuckets, "method", "route", "code")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+
+			next.ServeHTTP(ww, req)
+
+			latency.Observe(time.Since(start).Seconds(), req.Method, routePattern(req), strconv.Itoa(status(ww)))
+		})
+	}
+}
+
+// routePattern returns the chi route pattern a finished request matched,
+// or "unmatched".
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return "unmatched"
+}
+
+// status returns the response status, which is 200 if nothing was written.
+func status(ww middleware.WrapResponseWriter) int {
+	if code := ww.Status(); code != 0 {
+		return code
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,46 @@
+// Package middleware provides tests for HTTP request metrics.
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/metrics"
+)
+
+// TestMetrics_RoutePattern tests that requests are labeled by the matched
+// route pattern, including routes of mounted sub-routers.
+func TestMetrics_RoutePattern(t *testing.T) {
+	registry := metrics.NewRegistry()
+
+	api := chi.NewRouter()
+	api.Get("/paste/{pasteID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := chi.NewRouter()
+	r.Use(Metrics(registry))
+	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	r.Mount("/", api)
+
+	for _, path := range []string{"/ping", "/paste/a1b2c3d4e5f6a7b8", "/paste/0000000000000000"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	var out bytes.Buffer
+	if err := registry.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`flashpaper_http_request_duration_seconds_count{method="GET",route="/ping",code="200"} 1`,
+		`flashpaper_http_request_duration_seconds_count{method="GET",route="/paste/{pasteID}",code="404"} 2`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+}
@@ -3,19 +3,64 @@
 package middleware
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/version"
 )
 
+// ProductName is the product token used in the Server header.
+const ProductName = "FlashPaper"
+
 // SecurityHeaders returns middleware that adds security headers to responses.
 // These headers protect against common web vulnerabilities and are required
 // for secure operation of encrypted paste services.
 func SecurityHeaders(cfg *config.Config) func(http.Handler) http.Handler {
+	return SecurityHeadersWithCSP(cfg, nil)
+}
+
+// SecurityHeadersWithCSP is SecurityHeaders with extra Content-Security-Policy
+// sources, such as those a UI template declares in its manifest.
+func SecurityHeadersWithCSP(cfg *config.Config, extra CSPSources) func(http.Handler) http.Handler {
+	sc := cfg.Security
+	server := ServerHeader(sc.ServerHeader)
+	// Validate has checked the overrides
+	overrides, _ := config.ParseCSP(sc.CSP)
+	csp := buildPolicy(extra, overrides, sc.FrameOptions, sc.CSPNonce)
+	var frameOptions string
+	switch sc.FrameOptions {
+	case config.FrameOptionsDeny:
+		frameOptions = "DENY"
+	case config.FrameOptionsSameOrigin:
+		frameOptions = "SAMEORIGIN"
+	}
+	var hsts string
+	if cfg.Main.TLSEnabled() && cfg.Main.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(cfg.Main.HSTSMaxAge)
+		if sc.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if sc.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Version disclosure according to the configured policy
+			if server != "" {
+				w.Header().Set("Server", server)
+			}
+
 			// Prevent clickjacking
-			w.Header().Set("X-Frame-Options", "DENY")
+			if frameOptions != "" {
+				w.Header().Set("X-Frame-Options", frameOptions)
+			}
 
 			// Prevent MIME type sniffing
 			w.Header().Set("X-Content-Type-Options", "nosniff")
@@ -32,21 +77,167 @@ func SecurityHeaders(cfg *config.Config) func(http.Handler) http.Handler {
 
 			// Content Security Policy
 			// Restricts resource loading to prevent XSS and data injection
-			csp := "default-src 'self'; " +
-				"script-src 'self' 'unsafe-inline'; " +
-				"style-src 'self' 'unsafe-inline'; " +
-				"img-src 'self' data: blob:; " +
-				"font-src 'self'; " +
-				"connect-src 'self'; " +
-				"frame-ancestors 'none'; " +
-				"base-uri 'self'; " +
-				"form-action 'self'"
-			w.Header().Set("Content-Security-Policy", csp)
+			policy := csp
+			if sc.CSPNonce {
+				nonce := newNonce()
+				policy = strings.ReplaceAll(policy, cspNoncePlaceholder, "'nonce-"+nonce+"'")
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+			}
+			w.Header().Set("Content-Security-Policy", policy)
 
 			// Permissions Policy (formerly Feature-Policy)
 			w.Header().Set("Permissions-Policy", "geolocation=(), microphone=(), camera=()")
 
+			// Cross-origin isolation, for deployments that opt in
+			if sc.COOP != "" {
+				w.Header().Set("Cross-Origin-Opener-Policy", sc.COOP)
+			}
+			if sc.COEP != "" {
+				w.Header().Set("Cross-Origin-Embedder-Policy", sc.COEP)
+			}
+			if sc.CORP != "" {
+				w.Header().Set("Cross-Origin-Resource-Policy", sc.CORP)
+			}
+
+			// Keep browsers on HTTPS once they have reached it; browsers
+			// ignore the header over plain HTTP
+			if hsts != "" && r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
+
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+type cspNonceKey struct{}
+
+// cspNoncePlaceholder stands in a policy for the nonce of each response.
+const cspNoncePlaceholder = "'nonce-{}'"
+
+// CSPNonce returns the nonce the policy of ctx's response allows inline
+// scripts and styles by, or "" if nonces are turned off.
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// newNonce returns a random nonce; 128 bits as the CSP spec recommends.
+func newNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b) // Nothing for templates to escape
+}
+
+// CSPSources maps Content-Security-Policy directives to additional sources.
+type CSPSources map[string][]string
+
+// cspDefaults is the baseline policy, in header order.
+var cspDefaults = []struct {
+	directive string
+	sources   []string
+}{
+	{"default-src", []string{"'self'"}},
+	{"script-src", []string{"'self'", "'unsafe-inline'"}},
+	{"style-src", []string{"'self'", "'unsafe-inline'"}},
+	{"img-src", []string{"'self'", "data:", "blob:"}},
+	{"font-src", []string{"'self'"}},
+	{"connect-src", []string{"'self'"}},
+	{"media-src", nil},  // Falls back to default-src unless extended
+	{"worker-src", nil}, // Falls back to default-src unless extended
+	{"frame-src", nil},  // Falls back to default-src unless extended
+	{"frame-ancestors", []string{"'none'"}},
+	{"base-uri", []string{"'self'"}},
+	{"form-action", []string{"'self'"}},
+}
+
+// ContentSecurityPolicy builds the policy header value: the baseline with
+// extra sources appended to their directives. Directives without sources
+// are omitted; unknown directives in extra are ignored.
+func ContentSecurityPolicy(extra CSPSources) string {
+	return buildPolicy(extra, nil, config.FrameOptionsDeny, false)
+}
+
+// buildPolicy is ContentSecurityPolicy with the operator's settings:
+// frame-ancestors following X-Frame-Options, overrides replacing or adding
+// directives, and with nonce set, 'unsafe-inline' in script-src and
+// style-src replaced by cspNoncePlaceholder.
+func buildPolicy(extra CSPSources, overrides []config.CSPDirective, frameOptions string, nonce bool) string {
+	var parts []string
+	add := func(directive string, sources []string) {
+		if nonce && (directive == "script-src" || directive == "style-src") {
+			var replaced []string
+			for _, source := range sources {
+				if source != "'unsafe-inline'" {
+					replaced = append(replaced, source)
+				}
+			}
+			sources = append(replaced, cspNoncePlaceholder)
+		}
+		parts = append(parts, strings.TrimSpace(directive+" "+strings.Join(sources, " ")))
+	}
+
+	overridden := make(map[string][]string, len(overrides))
+	for _, o := range overrides {
+		overridden[o.Name] = o.Sources
+	}
+	for _, d := range cspDefaults {
+		if sources, ok := overridden[d.directive]; ok {
+			add(d.directive, sources)
+			delete(overridden, d.directive)
+			continue
+		}
+		sources := d.sources
+		if d.directive == "frame-ancestors" {
+			switch frameOptions {
+			case config.FrameOptionsSameOrigin:
+				sources = []string{"'self'"}
+			case config.FrameOptionsNone:
+				sources = nil
+			}
+		}
+		if sources == nil && len(extra[d.directive]) > 0 {
+			sources = []string{"'self'"} // Keep what default-src allowed
+		}
+		for _, source := range extra[d.directive] {
+			if !contains(sources, source) {
+				sources = append(sources[:len(sources):len(sources)], source)
+			}
+		}
+		if len(sources) > 0 {
+			add(d.directive, sources)
+		}
+	}
+	// Directives the baseline lacks, such as report-uri, in given order
+	for _, o := range overrides {
+		if sources, ok := overridden[o.Name]; ok {
+			add(o.Name, sources)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// contains reports whether list includes s.
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ServerHeader returns the Server header value for a disclosure policy.
+// Returns an empty string (omit the header) for "none" or unknown policies.
+func ServerHeader(policy string) string {
+	switch policy {
+	case config.ServerHeaderProduct:
+		return ProductName
+	case config.ServerHeaderFull:
+		return ProductName + "/" + version.Version
+	default:
+		return ""
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/version"
 )
 
 // TestSecurityHeaders tests that all security headers are set correctly.
@@ -153,6 +154,85 @@ func TestSecurityHeaders_DifferentMethods(t *testing.T) {
 	}
 }
 
+// TestSecurityHeaders_ServerHeader tests the Server header disclosure policy.
+func TestSecurityHeaders_ServerHeader(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected string
+	}{
+		{config.ServerHeaderNone, ""},
+		{config.ServerHeaderProduct, "FlashPaper"},
+		{config.ServerHeaderFull, "FlashPaper/" + version.Version},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Security.ServerHeader = tt.policy
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rr := httptest.NewRecorder()
+
+			SecurityHeaders(cfg)(handler).ServeHTTP(rr, req)
+
+			got, present := rr.Header()["Server"]
+			if tt.expected == "" {
+				if present {
+					t.Errorf("expected no Server header, got %v", got)
+				}
+				return
+			}
+			if rr.Header().Get("Server") != tt.expected {
+				t.Errorf("expected Server %q, got %q", tt.expected, rr.Header().Get("Server"))
+			}
+		})
+	}
+}
+
+// TestSecurityHeaders_HSTS tests that HSTS is sent only over HTTPS when
+// the server terminates TLS.
+func TestSecurityHeaders_HSTS(t *testing.T) {
+	tests := []struct {
+		name     string
+		tlsCert  string
+		maxAge   int
+		https    bool
+		expected string
+	}{
+		{"tls over https", "cert.pem", 31536000, true, "max-age=31536000"},
+		{"tls over http", "cert.pem", 31536000, false, ""},
+		{"tls without max age", "cert.pem", 0, true, ""},
+		{"behind proxy", "", 31536000, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Main.TLSCert = tt.tlsCert
+			cfg.Main.HSTSMaxAge = tt.maxAge
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			target := "http://example.com/"
+			if tt.https {
+				target = "https://example.com/"
+			}
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			rr := httptest.NewRecorder()
+
+			SecurityHeaders(cfg)(handler).ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Strict-Transport-Security"); got != tt.expected {
+				t.Errorf("expected Strict-Transport-Security %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
 // containsSubstring checks if a string contains a substring.
 func containsSubstring(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsSubstringHelper(s, substr))
@@ -166,3 +246,158 @@ func containsSubstringHelper(s, substr string) bool {
 	}
 	return false
 }
+
+// TestContentSecurityPolicy tests extending the baseline policy.
+func TestContentSecurityPolicy(t *testing.T) {
+	base := ContentSecurityPolicy(nil)
+	if !containsSubstring(base, "default-src 'self'; script-src 'self' 'unsafe-inline'") {
+		t.Errorf("unexpected baseline policy %q", base)
+	}
+	if containsSubstring(base, "media-src") {
+		t.Errorf("expected media-src to be omitted by default, got %q", base)
+	}
+
+	csp := ContentSecurityPolicy(CSPSources{
+		"font-src":  {"https://fonts.example.com", "'self'"},
+		"media-src": {"https://media.example.com"},
+		"bogus-src": {"https://ignored.example.com"},
+	})
+	for _, want := range []string{
+		"font-src 'self' https://fonts.example.com;",
+		"media-src 'self' https://media.example.com;",
+		"frame-ancestors 'none'",
+	} {
+		if !containsSubstring(csp, want) {
+			t.Errorf("expected %q in %q", want, csp)
+		}
+	}
+	if containsSubstring(csp, "ignored.example.com") {
+		t.Errorf("unknown directive leaked into %q", csp)
+	}
+
+	// Extending must not mutate the baseline
+	if ContentSecurityPolicy(nil) != base {
+		t.Error("baseline policy changed after extension")
+	}
+}
+
+// TestSecurityHeadersWithCSP tests that extra sources reach the header.
+func TestSecurityHeadersWithCSP(t *testing.T) {
+	cfg := config.DefaultConfig()
+	wrapped := SecurityHeadersWithCSP(cfg, CSPSources{"img-src": {"https://img.example.com"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := httptest.NewRecorder()
+	wrapped.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	csp := rr.Header().Get("Content-Security-Policy")
+	if !containsSubstring(csp, "img-src 'self' data: blob: https://img.example.com") {
+		t.Errorf("expected extended img-src, got %q", csp)
+	}
+}
+
+// TestSecurityHeaders_Configured tests the [security] header settings.
+func TestSecurityHeaders_Configured(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Main.TLSCert = "cert.pem"
+	cfg.Main.HSTSMaxAge = 31536000
+	cfg.Security.HSTSIncludeSubdomains = true
+	cfg.Security.HSTSPreload = true
+	cfg.Security.FrameOptions = config.FrameOptionsSameOrigin
+	cfg.Security.COOP = "same-origin"
+	cfg.Security.COEP = "require-corp"
+	cfg.Security.CORP = "same-site"
+	cfg.Security.CSP = "img-src 'self'; report-uri /csp-report; upgrade-insecure-requests"
+
+	rr := httptest.NewRecorder()
+	SecurityHeadersWithCSP(cfg, CSPSources{"img-src": {"https://img.example.com"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+
+	for header, expected := range map[string]string{
+		"X-Frame-Options":              "SAMEORIGIN",
+		"Strict-Transport-Security":    "max-age=31536000; includeSubDomains; preload",
+		"Cross-Origin-Opener-Policy":   "same-origin",
+		"Cross-Origin-Embedder-Policy": "require-corp",
+		"Cross-Origin-Resource-Policy": "same-site",
+	} {
+		if got := rr.Header().Get(header); got != expected {
+			t.Errorf("%s: expected %q, got %q", header, expected, got)
+		}
+	}
+
+	csp := rr.Header().Get("Content-Security-Policy")
+	for _, want := range []string{
+		"img-src 'self';",
+		"frame-ancestors 'self';",
+		"; report-uri /csp-report; upgrade-insecure-requests",
+	} {
+		if !containsSubstring(csp, want) {
+			t.Errorf("expected %q in %q", want, csp)
+		}
+	}
+	if containsSubstring(csp, "img.example.com") {
+		t.Errorf("overridden directive kept template sources: %q", csp)
+	}
+}
+
+// TestSecurityHeaders_FrameOptionsNone tests that allowing framing drops
+// both the header and frame-ancestors.
+func TestSecurityHeaders_FrameOptionsNone(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.FrameOptions = config.FrameOptionsNone
+
+	rr := httptest.NewRecorder()
+	SecurityHeaders(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if got := rr.Header().Get("X-Frame-Options"); got != "" {
+		t.Errorf("expected no X-Frame-Options, got %q", got)
+	}
+	if csp := rr.Header().Get("Content-Security-Policy"); containsSubstring(csp, "frame-ancestors") {
+		t.Errorf("expected no frame-ancestors, got %q", csp)
+	}
+	if got := rr.Header().Get("Cross-Origin-Opener-Policy"); got != "" {
+		t.Errorf("expected no Cross-Origin-Opener-Policy by default, got %q", got)
+	}
+}
+
+// TestSecurityHeaders_CSPNonce tests that each response gets its own
+// nonce, in both the policy and the request context.
+func TestSecurityHeaders_CSPNonce(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.CSPNonce = true
+
+	var nonce string
+	wrapped := SecurityHeaders(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r.Context())
+	}))
+
+	seen := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		wrapped.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if nonce == "" || seen[nonce] {
+			t.Fatalf("expected a fresh nonce, got %q", nonce)
+		}
+		seen[nonce] = true
+		csp := rr.Header().Get("Content-Security-Policy")
+		for _, want := range []string{
+			"script-src 'self' 'nonce-" + nonce + "';",
+			"style-src 'self' 'nonce-" + nonce + "';",
+		} {
+			if !containsSubstring(csp, want) {
+				t.Errorf("expected %q in %q", want, csp)
+			}
+		}
+		if containsSubstring(csp, "unsafe-inline") {
+			t.Errorf("expected 'unsafe-inline' to be replaced, got %q", csp)
+		}
+	}
+
+	// Requests the middleware hasn't seen carry none
+	if got := CSPNonce(httptest.NewRequest(http.MethodGet, "/", nil).Context()); got != "" {
+		t.Errorf("expected no nonce, got %q", got)
+	}
+}
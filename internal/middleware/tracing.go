@@ -0,0 +1,98 @@
+// Package middleware provides request tracing for FlashPaper.
+// Each request gets a server span, named by method and route pattern like
+// the metrics, that continues the caller's trace if it sent a traceparent
+// header; the storage operations the request makes become its children.
+// With Server-Timing on, responses also report where their time went.
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/liskl/flashpaper/internal/tracing"
+)
+
+// Tracing returns middleware tracing requests with t (which may be nil to
+// only report Server-Timing) and, with serverTiming, adding a Server-Timing
+// header to every response. It must run inside a chi router, like Metrics,
+// and before RequestLogger so log lines carry the trace ID.
+func Tracing(t *tracing.Tracer, serverTiming bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := tracing.Extract(r.Context(), r.Header.Get(tracing.TraceparentHeader))
+			var timings *tracing.Timings
+			if serverTiming {
+				ctx, timings = tracing.WithTimings(ctx)
+			}
+			ctx, span := t.Start(ctx, r.Method, tracing.KindServer)
+
+			tw := &timingWriter{ResponseWriter: w, timings: timings}
+			next.ServeHTTP(tw, r.WithContext(ctx))
+
+			code := tw.code
+			if code == 0 {
+				code = http.StatusOK
+			}
+			route := routePattern(r)
+			span.SetName(r.Method + " " + route)
+			span.SetAttr("http.request.method", r.Method)
+			span.SetAttr("http.route", route)
+			span.SetAttr("http.response.status_code", code)
+			if id := middleware.GetReqID(r.Context()); id != "" {
+				span.SetAttr("flashpaper.request_id", id)
+			}
+			if code >= http.StatusInternalServerError {
+				span.SetError(httpError(code))
+			}
+			span.End()
+		})
+	}
+}
+
+// httpError is a server error response, as a span's failure.
+type httpError int
+
+func (e httpError) Error() string {
+	return http.StatusText(int(e))
+}
+
+// timingWriter records the response status and adds the Server-Timing
+// header, if there are timings, as the response begins.
+type timingWriter struct {
+	http.ResponseWriter
+	timings *tracing.Timings
+	code    int // Final status; 0 until written
+}
+
+// WriteHeader adds Server-Timing to the final headers and sends them.
+func (tw *timingWriter) WriteHeader(code int) {
+	if tw.code == 0 && code >= http.StatusOK {
+		tw.code = code
+		if tw.timings != nil {
+			tw.Header().Set(tracing.TimingHeader, tw.timings.Header())
+		}
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+// Write sends the headers first if the handler didn't.
+func (tw *timingWriter) Write(p []byte) (int, error) {
+	if tw.code == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client.
+func (tw *timingWriter) Flush() {
+	if tw.code == 0 {
+		tw.WriteHeader(http.StatusOK)
+	}
+	_ = http.NewResponseController(tw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
@@ -0,0 +1,113 @@
+// Package middleware provides tests for request tracing.
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/tracing"
+)
+
+// tracedRouter returns a router with Tracing whose /p/{id} route starts a
+// storage span, as the traced storage wrapper does.
+func tracedRouter(serverTiming bool) http.Handler {
+	r := chi.NewRouter()
+	r.Use(Tracing(nil, serverTiming))
+	r.Get("/p/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := (*tracing.Tracer)(nil).Start(r.Context(), "storage.read_paste", tracing.KindInternal)
+		span.End()
+		w.WriteHeader(http.StatusNotFound)
+	})
+	return r
+}
+
+func TestTracing_ServerTiming(t *testing.T) {
+	rr := httptest.NewRecorder()
+	tracedRouter(true).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/p/f468483c313401e8", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected the handler's status, got %d", rr.Code)
+	}
+	timing := rr.Header().Get(tracing.TimingHeader)
+	if !regexp.MustCompile(`^storage\.read_paste;dur=[0-9.]+, total;dur=[0-9.]+$`).MatchString(timing) {
+		t.Errorf("expected storage and total timings, got %q", timing)
+	}
+}
+
+func TestTracing_ServerTimingOff(t *testing.T) {
+	rr := httptest.NewRecorder()
+	tracedRouter(false).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/p/f468483c313401e8", nil))
+
+	if timing := rr.Header().Get(tracing.TimingHeader); timing != "" {
+		t.Errorf("expected no Server-Timing, got %q", timing)
+	}
+}
+
+func TestTracing_Flush(t *testing.T) {
+	// Streaming handlers flush before writing; the timings go out first
+	r := chi.NewRouter()
+	r.Use(Tracing(nil, true))
+	r.Get("/events", func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("expected flushing through the tracer, got %v", err)
+		}
+	})
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/events", nil))
+
+	if !rr.Flushed || rr.Header().Get(tracing.TimingHeader) == "" {
+		t.Errorf("expected a flushed response with timings, got %v", rr.Header())
+	}
+}
+
+func TestTracing_Spans(t *testing.T) {
+	bodies := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer collector.Close()
+	cfg := config.DefaultConfig().Telemetry
+	cfg.Enabled = true
+	cfg.Endpoint = collector.URL
+	tracer := tracing.New(cfg)
+
+	r := chi.NewRouter()
+	r.Use(Tracing(tracer, false))
+	r.Get("/p/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracer.Start(r.Context(), "storage.read_paste", tracing.KindInternal)
+		span.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/p/f468483c313401e8", nil)
+	req.Header.Set(tracing.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	body := string(<-bodies)
+	for _, want := range []string{
+		`"name":"GET /p/{id}"`,
+		`"name":"storage.read_paste"`,
+		`"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`,
+		`"parentSpanId":"00f067aa0ba902b7"`,
+		`"key":"http.response.status_code","value":{"intValue":"500"}`,
+		`"status":{"code":2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %s in the export, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "f468483c313401e8") {
+		t.Error("expected no paste ID in the export")
+	}
+}
@@ -0,0 +1,51 @@
+// Package model defines the list type of paste attachments.
+// PrivateBin 1.7 turned the "attachment" and "attachmentname" fields from
+// single strings into arrays. StringList reads both forms, and writes a
+// single string back as one, so documents stay readable by older versions.
+package model
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// StringList is a list of strings that also decodes from a single string.
+type StringList []string
+
+// MarshalJSON encodes a one-element list as a plain string.
+func (l StringList) MarshalJSON() ([]byte, error) {
+	if len(l) == 1 {
+		return json.Marshal(l[0])
+	}
+	return json.Marshal([]string(l))
+}
+
+// UnmarshalJSON decodes an array of strings or a single string. An empty
+// string decodes to an empty list, as it means no attachment.
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = nil
+		if single != "" {
+			*l = StringList{single}
+		}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("expected a string or an array of strings")
+	}
+	*l = list
+	return nil
+}
+
+// Value returns the list as a single string if it has one element and
+// asList isn't set, and as a []string otherwise, for API responses in the
+// form the client used.
+func (l StringList) Value(asList bool) interface{} {
+	if len(l) == 1 && !asList {
+		return l[0]
+	}
+	return []string(l)
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringList_DecodesBothForms(t *testing.T) {
+	var p Paste
+	require.NoError(t, json.Unmarshal([]byte(`{"attachment":"one","attachmentname":["a","b"]}`), &p))
+	assert.Equal(t, StringList{"one"}, p.Attachments)
+	assert.Equal(t, StringList{"a", "b"}, p.AttachmentNames)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"attachment":""}`), &p))
+	assert.Empty(t, p.Attachments)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"attachment":[1]}`), &p))
+}
+
+func TestStringList_EncodesSingleAsString(t *testing.T) {
+	data, err := json.Marshal(Paste{Attachments: StringList{"one"}})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"attachment":"one"`)
+	assert.NotContains(t, string(data), "attachmentname")
+
+	data, err = json.Marshal(Paste{Attachments: StringList{"one", "two"}})
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"attachment":["one","two"]`)
+}
+
+func TestStringList_Value(t *testing.T) {
+	assert.Equal(t, "one", StringList{"one"}.Value(false))
+	assert.Equal(t, []string{"one"}, StringList{"one"}.Value(true))
+	assert.Equal(t, []string{"one", "two"}, StringList{"one", "two"}.Value(false))
+}
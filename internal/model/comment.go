@@ -51,6 +51,10 @@ type CommentMeta struct {
 
 	// Nickname is an optional encrypted nickname
 	Nickname string `json:"nickname,omitempty"`
+
+	// Flagged marks a comment a spam hook considered suspicious.
+	// Flagged comments are stored and served, but clients may hide them.
+	Flagged bool `json:"flagged,omitempty"`
 }
 
 // NewComment creates a new Comment with default values.
@@ -97,6 +101,7 @@ func (c *Comment) ForStorage() *Comment {
 		Meta: CommentMeta{
 			PostDate: c.Meta.PostDate,
 			Nickname: c.Meta.Nickname,
+			Flagged:  c.Meta.Flagged,
 		},
 	}
 }
@@ -115,6 +120,7 @@ func (c *Comment) ForResponse() *Comment {
 			PostDate: c.Meta.PostDate,
 			Icon:     c.Meta.Icon,
 			Nickname: c.Meta.Nickname,
+			Flagged:  c.Meta.Flagged,
 		},
 	}
 }
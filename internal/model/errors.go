@@ -32,6 +32,10 @@ var (
 	// new comments (e.g., burn-after-reading paste)
 	ErrDiscussionClosed = errors.New("discussion is closed")
 
+	// ErrCommentLimitReached is returned when a paste already holds the
+	// maximum number of comments allowed by the server
+	ErrCommentLimitReached = errors.New("comment limit reached for this paste")
+
 	// ErrInvalidDeleteToken is returned when the provided delete token doesn't match
 	ErrInvalidDeleteToken = errors.New("invalid delete token")
 
@@ -53,12 +57,31 @@ var (
 	// ErrInvalidFormatter is returned when an invalid formatter is specified
 	ErrInvalidFormatter = errors.New("invalid formatter")
 
+	// ErrInvalidCategory is returned when an unknown content category is specified
+	ErrInvalidCategory = errors.New("invalid content category")
+
 	// ErrStorageFailure is returned when the storage backend encounters an error
 	ErrStorageFailure = errors.New("storage operation failed")
 
 	// ErrBurnAfterReadingWithDiscussion is returned when trying to enable both
 	// burn-after-reading and discussion on the same paste
 	ErrBurnAfterReadingWithDiscussion = errors.New("burn-after-reading and discussion cannot both be enabled")
+
+	// ErrReadLimitWithDiscussion is returned when trying to enable both a
+	// read limit and discussion on the same paste
+	ErrReadLimitWithDiscussion = errors.New("read limit and discussion cannot both be enabled")
+
+	// ErrInvalidReadLimit is returned for read limits that aren't a whole
+	// number of reads, or that contradict burn-after-reading
+	ErrInvalidReadLimit = errors.New("invalid read limit")
+
+	// ErrPinBurnAfterReading is returned when trying to pin a
+	// burn-after-reading paste, which can't outlive its first read anyway
+	ErrPinBurnAfterReading = errors.New("burn-after-reading pastes cannot be pinned")
+
+	// ErrPasteDisabled is returned when reading or commenting on a paste
+	// whose creator disabled its link
+	ErrPasteDisabled = errors.New("paste link is disabled")
 )
 
 // IsNotFound returns true if the error indicates a resource was not found.
@@ -81,6 +104,7 @@ func IsValidationError(err error) bool {
 		errors.Is(err, ErrPasteTooLarge) ||
 		errors.Is(err, ErrInvalidExpiration) ||
 		errors.Is(err, ErrInvalidFormatter) ||
+		errors.Is(err, ErrInvalidCategory) ||
 		errors.Is(err, ErrBurnAfterReadingWithDiscussion)
 }
 
@@ -89,7 +113,8 @@ func IsValidationError(err error) bool {
 func IsForbidden(err error) bool {
 	return errors.Is(err, ErrInvalidDeleteToken) ||
 		errors.Is(err, ErrDiscussionDisabled) ||
-		errors.Is(err, ErrDiscussionClosed)
+		errors.Is(err, ErrDiscussionClosed) ||
+		errors.Is(err, ErrCommentLimitReached)
 }
 
 // IsTooManyRequests returns true if the error indicates rate limiting.
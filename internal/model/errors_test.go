@@ -73,6 +73,7 @@ func TestIsValidationError(t *testing.T) {
 		{"ErrPasteTooLarge", ErrPasteTooLarge, true},
 		{"ErrInvalidExpiration", ErrInvalidExpiration, true},
 		{"ErrInvalidFormatter", ErrInvalidFormatter, true},
+		{"ErrInvalidCategory", ErrInvalidCategory, true},
 		{"ErrBurnAfterReadingWithDiscussion", ErrBurnAfterReadingWithDiscussion, true},
 		{"wrapped ErrInvalidPasteID", fmt.Errorf("wrapper: %w", ErrInvalidPasteID), true},
 		{"ErrPasteNotFound", ErrPasteNotFound, false},
@@ -101,6 +102,7 @@ func TestIsForbidden(t *testing.T) {
 		{"ErrInvalidDeleteToken", ErrInvalidDeleteToken, true},
 		{"ErrDiscussionDisabled", ErrDiscussionDisabled, true},
 		{"ErrDiscussionClosed", ErrDiscussionClosed, true},
+		{"ErrCommentLimitReached", ErrCommentLimitReached, true},
 		{"wrapped ErrInvalidDeleteToken", fmt.Errorf("wrapper: %w", ErrInvalidDeleteToken), true},
 		{"wrapped ErrDiscussionDisabled", fmt.Errorf("wrapper: %w", ErrDiscussionDisabled), true},
 		{"ErrPasteNotFound", ErrPasteNotFound, false},
@@ -161,8 +163,10 @@ func TestErrorMessages(t *testing.T) {
 		ErrRateLimited,
 		ErrInvalidExpiration,
 		ErrInvalidFormatter,
+		ErrInvalidCategory,
 		ErrStorageFailure,
 		ErrBurnAfterReadingWithDiscussion,
+		ErrCommentLimitReached,
 	}
 
 	seen := make(map[string]bool)
@@ -16,6 +16,23 @@ const (
 	FormatterMarkdown        = "markdown"
 )
 
+// Content category constants give a coarse, creator-chosen hint about what a
+// paste contains once decrypted. They are stored in plain metadata, so they
+// are deliberately vague: enough to pick a download name, nothing more.
+const (
+	CategoryText    = "text"
+	CategoryArchive = "archive"
+	CategoryImage   = "image"
+)
+
+// Secret kinds mark pastes created through the secret API rather than the
+// PrivateBin-compatible one. Each API serves only its own pastes: a secret
+// has no adata for the web client to decrypt it with.
+const (
+	SecretCiphertext = "ciphertext" // Encrypted by the creator; opaque to the server
+	SecretServer     = "server"     // Encrypted by the server with a key only the creator got
+)
+
 // Paste represents an encrypted paste stored in FlashPaper.
 // The actual content is encrypted client-side using AES-256-GCM,
 // so the server only sees ciphertext and metadata.
@@ -27,11 +44,13 @@ type Paste struct {
 	// This is the "ct" field in PrivateBin's API
 	Data string `json:"data"`
 
-	// AttachmentName is the filename of an attached file (if any)
-	AttachmentName string `json:"attachmentname,omitempty"`
+	// AttachmentNames are the encrypted filenames of attached files, in
+	// the order of Attachments
+	AttachmentNames StringList `json:"attachmentname,omitempty"`
 
-	// Attachment is the encrypted attachment content (base64-encoded)
-	Attachment string `json:"attachment,omitempty"`
+	// Attachments are the encrypted attachment contents (base64-encoded).
+	// Clients before PrivateBin 1.7 send at most one
+	Attachments StringList `json:"attachment,omitempty"`
 
 	// Meta contains paste metadata (expiration, burn settings, etc.)
 	Meta PasteMeta `json:"meta"`
@@ -77,18 +96,70 @@ type PasteMeta struct {
 	// BurnAfterReading indicates the paste should be deleted after first view
 	BurnAfterReading bool `json:"burnafterreading,omitempty"`
 
+	// MaxReads is the number of reads a paste with a read limit allows
+	// before it is deleted, burn-after-reading generalized (0 = no limit).
+	// Pastes limited to one read are stored as burn-after-reading ones
+	MaxReads int `json:"max_reads,omitempty"`
+
+	// ReadsLeft counts down the reads of a paste with a read limit; the
+	// read that takes it to 0 deletes the paste
+	ReadsLeft int `json:"reads_left,omitempty"`
+
 	// OpenDiscussion indicates if comments are enabled
 	OpenDiscussion bool `json:"opendiscussion,omitempty"`
 
 	// Formatter specifies how to render the paste (plaintext, syntaxhighlighting, markdown)
 	Formatter string `json:"formatter,omitempty"`
 
-	// Salt is the server-side salt used for delete token generation
+	// Category is the optional content category (text, archive, image).
+	// Unlike the formatter it lives outside the encrypted adata, so the
+	// server can use it for raw downloads.
+	Category string `json:"category,omitempty"`
+
+	// Salt is the paste's own salt its delete token derives from. Pastes
+	// created before per-paste salts have none and use the server salt.
 	// Never exposed to clients
 	Salt string `json:"-"`
 
 	// TimeToLive is used during creation to specify expiration
 	TimeToLive int64 `json:"time_to_live,omitempty"`
+
+	// Pinned marks a paste an administrator exempted from expiration,
+	// purging, and download rate limits. ExpireDate is kept, so unpinning
+	// restores the original expiration.
+	Pinned bool `json:"pinned,omitempty"`
+
+	// Disabled marks a paste whose creator turned its link off with the
+	// delete token. It is kept, but not served, until turned back on.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// AttachmentSizes are the lengths of attachments the backend stores
+	// apart from the paste document, in which case Attachments is empty.
+	// Empty means any attachments are inline.
+	AttachmentSizes []int64 `json:"attachmentsizes,omitempty"`
+
+	// AttachmentList records that the client sent attachments as arrays
+	// (PrivateBin 1.7 and later), so they are returned the same way even
+	// if there is only one.
+	AttachmentList bool `json:"attachmentlist,omitempty"`
+
+	// Secret is the secret kind of pastes created through the secret API,
+	// empty for all others
+	Secret string `json:"secret,omitempty"`
+
+	// AccessProof is the hash of the proof readers must present, for
+	// pastes whose creator asked the server to check the password (see
+	// util.HashAccessProof). Never exposed to clients
+	AccessProof string `json:"-"`
+}
+
+// ExpiresAt returns the Unix time at which the paste is due for purging,
+// or 0 if it never is: either it has no expiration or it is pinned.
+func (m *PasteMeta) ExpiresAt() int64 {
+	if m.Pinned {
+		return 0
+	}
+	return m.ExpireDate
 }
 
 // NewPaste creates a new Paste with default values.
@@ -103,12 +174,18 @@ func NewPaste() *Paste {
 }
 
 // IsExpired checks if the paste has passed its expiration time.
-// Pastes with ExpireDate of 0 never expire.
+// Pastes with ExpireDate of 0 and pinned pastes never expire.
 func (p *Paste) IsExpired() bool {
-	if p.Meta.ExpireDate == 0 {
+	return p.IsExpiredAt(time.Now())
+}
+
+// IsExpiredAt is IsExpired as of now, for callers with their own clock.
+func (p *Paste) IsExpiredAt(now time.Time) bool {
+	expiresAt := p.Meta.ExpiresAt()
+	if expiresAt == 0 {
 		return false // Never expires
 	}
-	return time.Now().Unix() > p.Meta.ExpireDate
+	return now.Unix() > expiresAt
 }
 
 // IsBurnAfterReading returns true if the paste should be deleted after reading.
@@ -116,11 +193,50 @@ func (p *Paste) IsBurnAfterReading() bool {
 	return p.Meta.BurnAfterReading
 }
 
+// HasReadLimit returns true if the paste is deleted after MaxReads reads.
+func (p *Paste) HasReadLimit() bool {
+	return p.Meta.MaxReads > 0
+}
+
+// IsLastRead reports whether the read that returned the paste deleted it:
+// that of a burn-after-reading paste, or the one using up a read limit.
+func (p *Paste) IsLastRead() bool {
+	return p.IsBurnAfterReading() || (p.HasReadLimit() && p.Meta.ReadsLeft == 0)
+}
+
 // HasDiscussion returns true if discussions (comments) are enabled.
 func (p *Paste) HasDiscussion() bool {
 	return p.Meta.OpenDiscussion
 }
 
+// HasAttachment reports whether the paste has attachments, inline or
+// stored separately.
+func (p *Paste) HasAttachment() bool {
+	return p.AttachmentCount() > 0
+}
+
+// AttachmentCount returns the number of attachments, wherever they are
+// stored.
+func (p *Paste) AttachmentCount() int {
+	if len(p.Meta.AttachmentSizes) > 0 {
+		return len(p.Meta.AttachmentSizes)
+	}
+	return len(p.Attachments)
+}
+
+// AttachmentLength returns the total size of the attachments in bytes,
+// wherever they are stored.
+func (p *Paste) AttachmentLength() int64 {
+	var total int64
+	for _, size := range p.Meta.AttachmentSizes {
+		total += size
+	}
+	for _, attachment := range p.Attachments {
+		total += int64(len(attachment))
+	}
+	return total
+}
+
 // Validate checks if the paste data is valid.
 // This should be called before storing a new paste.
 func (p *Paste) Validate() error {
@@ -139,6 +255,16 @@ func (p *Paste) Validate() error {
 		}
 	}
 
+	// Validate category if specified
+	if p.Meta.Category != "" {
+		switch p.Meta.Category {
+		case CategoryText, CategoryArchive, CategoryImage:
+			// Valid
+		default:
+			return ErrInvalidCategory
+		}
+	}
+
 	// Cannot have both burn-after-reading and discussion enabled
 	// This would create a logical conflict: discussion requires persistence,
 	// but burn-after-reading deletes on first view
@@ -146,16 +272,30 @@ func (p *Paste) Validate() error {
 		return ErrBurnAfterReadingWithDiscussion
 	}
 
+	// The same goes for a read limit, which burn-after-reading is the
+	// one-read case of
+	if p.Meta.MaxReads < 0 || (p.Meta.MaxReads > 0 && p.Meta.BurnAfterReading) {
+		return ErrInvalidReadLimit
+	}
+	if p.Meta.MaxReads > 0 && p.Meta.OpenDiscussion {
+		return ErrReadLimitWithDiscussion
+	}
+
 	return nil
 }
 
 // SetExpiration sets the expiration time based on duration.
 // A duration of 0 means the paste never expires.
 func (p *Paste) SetExpiration(d time.Duration) {
+	p.SetExpirationFrom(time.Now(), d)
+}
+
+// SetExpirationFrom sets the expiration time to d after now.
+func (p *Paste) SetExpirationFrom(now time.Time, d time.Duration) {
 	if d == 0 {
 		p.Meta.ExpireDate = 0 // Never expires
 	} else {
-		p.Meta.ExpireDate = time.Now().Add(d).Unix()
+		p.Meta.ExpireDate = now.Add(d).Unix()
 	}
 }
 
@@ -163,19 +303,28 @@ func (p *Paste) SetExpiration(d time.Duration) {
 // This removes fields that shouldn't be persisted (like URL, comments).
 func (p *Paste) ForStorage() *Paste {
 	return &Paste{
-		ID:             p.ID,
-		Data:           p.Data,
-		AttachmentName: p.AttachmentName,
-		Attachment:     p.Attachment,
-		AData:          p.AData,
-		Version:        p.Version,
+		ID:              p.ID,
+		Data:            p.Data,
+		AttachmentNames: p.AttachmentNames,
+		Attachments:     p.Attachments,
+		AData:           p.AData,
+		Version:         p.Version,
 		Meta: PasteMeta{
 			PostDate:         p.Meta.PostDate,
 			ExpireDate:       p.Meta.ExpireDate,
 			BurnAfterReading: p.Meta.BurnAfterReading,
+			MaxReads:         p.Meta.MaxReads,
+			ReadsLeft:        p.Meta.ReadsLeft,
 			OpenDiscussion:   p.Meta.OpenDiscussion,
 			Formatter:        p.Meta.Formatter,
+			Category:         p.Meta.Category,
 			Salt:             p.Meta.Salt,
+			Pinned:           p.Meta.Pinned,
+			Disabled:         p.Meta.Disabled,
+			AttachmentSizes:  p.Meta.AttachmentSizes,
+			AttachmentList:   p.Meta.AttachmentList,
+			Secret:           p.Meta.Secret,
+			AccessProof:      p.Meta.AccessProof,
 		},
 	}
 }
@@ -184,21 +333,24 @@ func (p *Paste) ForStorage() *Paste {
 // This removes sensitive server-side fields (like salt).
 func (p *Paste) ForResponse() *Paste {
 	return &Paste{
-		ID:             p.ID,
-		Data:           p.Data,
-		AttachmentName: p.AttachmentName,
-		Attachment:     p.Attachment,
-		AData:          p.AData,
-		Version:        p.Version,
-		URL:            p.URL,
-		Comments:       p.Comments,
-		CommentCount:   p.CommentCount,
-		CommentOffset:  p.CommentOffset,
+		ID:              p.ID,
+		Data:            p.Data,
+		AttachmentNames: p.AttachmentNames,
+		Attachments:     p.Attachments,
+		AData:           p.AData,
+		Version:         p.Version,
+		URL:             p.URL,
+		Comments:        p.Comments,
+		CommentCount:    p.CommentCount,
+		CommentOffset:   p.CommentOffset,
 		Meta: PasteMeta{
 			PostDate:         p.Meta.PostDate,
 			BurnAfterReading: p.Meta.BurnAfterReading,
+			MaxReads:         p.Meta.MaxReads,
+			ReadsLeft:        p.Meta.ReadsLeft,
 			OpenDiscussion:   p.Meta.OpenDiscussion,
 			Formatter:        p.Meta.Formatter,
+			Category:         p.Meta.Category,
 			// Note: ExpireDate and Salt are NOT included
 		},
 	}
@@ -38,6 +38,28 @@ func TestPaste_IsExpired_NeverExpires(t *testing.T) {
 	assert.False(t, p.IsExpired())
 }
 
+func TestPaste_IsExpired_Pinned(t *testing.T) {
+	p := NewPaste()
+	p.Meta.ExpireDate = time.Now().Add(-time.Hour).Unix()
+	p.Meta.Pinned = true
+
+	assert.False(t, p.IsExpired())
+	assert.Zero(t, p.Meta.ExpiresAt())
+
+	p.Meta.Pinned = false
+	assert.Equal(t, p.Meta.ExpireDate, p.Meta.ExpiresAt())
+}
+
+func TestPaste_IsExpiredAt(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	p := NewPaste()
+	p.SetExpirationFrom(now, time.Hour)
+
+	assert.Equal(t, now.Add(time.Hour).Unix(), p.Meta.ExpireDate)
+	assert.False(t, p.IsExpiredAt(now.Add(time.Hour)))
+	assert.True(t, p.IsExpiredAt(now.Add(time.Hour+time.Second)))
+}
+
 func TestPaste_IsBurnAfterReading(t *testing.T) {
 	p := NewPaste()
 
@@ -56,6 +78,23 @@ func TestPaste_HasDiscussion(t *testing.T) {
 	assert.True(t, p.HasDiscussion())
 }
 
+func TestPaste_Attachment_InlineAndSeparate(t *testing.T) {
+	p := NewPaste()
+	assert.False(t, p.HasAttachment())
+	assert.Equal(t, int64(0), p.AttachmentLength())
+
+	p.Attachments = StringList{"data:inline", "data:second"}
+	assert.True(t, p.HasAttachment())
+	assert.Equal(t, 2, p.AttachmentCount())
+	assert.Equal(t, int64(22), p.AttachmentLength())
+
+	p.Attachments = nil
+	p.Meta.AttachmentSizes = []int64{4096}
+	assert.True(t, p.HasAttachment())
+	assert.Equal(t, 1, p.AttachmentCount())
+	assert.Equal(t, int64(4096), p.AttachmentLength())
+}
+
 func TestPaste_Validate_ValidPaste(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -127,6 +166,16 @@ func TestPaste_Validate_InvalidFormatter_ReturnsError(t *testing.T) {
 	assert.ErrorIs(t, err, ErrInvalidFormatter)
 }
 
+func TestPaste_Validate_Category(t *testing.T) {
+	for _, category := range []string{"", CategoryText, CategoryArchive, CategoryImage} {
+		p := &Paste{Data: "encrypted", Meta: PasteMeta{Category: category}}
+		assert.NoError(t, p.Validate(), category)
+	}
+
+	p := &Paste{Data: "encrypted", Meta: PasteMeta{Category: "application/pdf"}}
+	assert.ErrorIs(t, p.Validate(), ErrInvalidCategory)
+}
+
 func TestPaste_Validate_BurnAndDiscussion_ReturnsError(t *testing.T) {
 	p := &Paste{
 		Data: "encrypted",
@@ -139,6 +188,29 @@ func TestPaste_Validate_BurnAndDiscussion_ReturnsError(t *testing.T) {
 	assert.ErrorIs(t, err, ErrBurnAfterReadingWithDiscussion)
 }
 
+func TestPaste_Validate_ReadLimit(t *testing.T) {
+	p := &Paste{Data: "encrypted", Meta: PasteMeta{MaxReads: 3, ReadsLeft: 3}}
+	assert.NoError(t, p.Validate())
+
+	p.Meta.OpenDiscussion = true
+	assert.ErrorIs(t, p.Validate(), ErrReadLimitWithDiscussion)
+
+	p.Meta.OpenDiscussion = false
+	p.Meta.BurnAfterReading = true
+	assert.ErrorIs(t, p.Validate(), ErrInvalidReadLimit)
+
+	p.Meta.BurnAfterReading = false
+	p.Meta.MaxReads = -1
+	assert.ErrorIs(t, p.Validate(), ErrInvalidReadLimit)
+}
+
+func TestPaste_IsLastRead(t *testing.T) {
+	assert.False(t, (&Paste{}).IsLastRead())
+	assert.True(t, (&Paste{Meta: PasteMeta{BurnAfterReading: true}}).IsLastRead())
+	assert.False(t, (&Paste{Meta: PasteMeta{MaxReads: 3, ReadsLeft: 1}}).IsLastRead())
+	assert.True(t, (&Paste{Meta: PasteMeta{MaxReads: 3}}).IsLastRead())
+}
+
 func TestPaste_SetExpiration_Duration(t *testing.T) {
 	p := NewPaste()
 	now := time.Now()
@@ -0,0 +1,20 @@
+// Package model defines the ReadReceipt record for FlashPaper pastes.
+// A read receipt records when a paste was first successfully retrieved.
+// It complements burn-after-reading for creators who want confirmation that
+// a recipient has opened a paste without having it deleted.
+package model
+
+// ReadReceipt records the first read of a paste.
+// Receipts are only disclosed to holders of the paste's delete token.
+type ReadReceipt struct {
+	// PasteID is the paste this receipt belongs to
+	PasteID string `json:"pasteid"`
+
+	// FirstRead is the Unix timestamp of the first read (0 = never read)
+	FirstRead int64 `json:"firstread"`
+}
+
+// IsRead reports whether the paste has been read at least once.
+func (r *ReadReceipt) IsRead() bool {
+	return r.FirstRead != 0
+}
@@ -0,0 +1,373 @@
+// Package oidc verifies bearer tokens issued by an OpenID Connect provider,
+// such as Keycloak, Dex, Okta, or Entra ID. Tokens are JWTs signed with one
+// of the provider's keys, which are found through its discovery document
+// (issuer + /.well-known/openid-configuration) on first use and fetched
+// again when a token names a key not seen yet, as providers rotate them.
+// Only asymmetric signatures (RS*, PS*, ES*) are accepted: "none" and HMAC
+// would let anyone who knows the client ID forge tokens.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+)
+
+// ErrInvalidToken is returned, wrapped, for tokens that fail verification.
+var ErrInvalidToken = errors.New("invalid token")
+
+const (
+	// leeway absorbs clock skew between the provider and this server
+	leeway = time.Minute
+
+	// refetchInterval bounds how often unknown key IDs refetch the keys,
+	// so tokens naming made-up keys can't make every request a fetch
+	refetchInterval = time.Minute
+
+	// retryInterval bounds how often keys are fetched again after a failed
+	// fetch, so an unreachable provider doesn't hold up every bearer-token
+	// request for the client timeout
+	retryInterval = 10 * time.Second
+
+	// maxDocumentSize bounds the discovery document and key set
+	maxDocumentSize = 1 << 20
+)
+
+// Claims are the verified claims of a token.
+type Claims struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email"`
+
+	// Name is the user name the provider shows, if it sends one
+	Name string `json:"preferred_username"`
+}
+
+// claims are the claims a token is checked against.
+type claims struct {
+	Claims
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// audience is the aud claim, a string or an array of them.
+type audience []string
+
+// UnmarshalJSON accepts both forms of aud.
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Verifier checks tokens of one issuer and audience. It is safe for
+// concurrent use.
+type Verifier struct {
+	issuer   string
+	audience string
+	client   *http.Client
+	clock    clock.Clock
+
+	mu       sync.Mutex
+	jwksURL  string                      // From the discovery document
+	keys     map[string]crypto.PublicKey // By key ID
+	fetched  time.Time                   // When keys were last fetched
+	failed   time.Time                   // When the last fetch failed, if it did
+	fetchErr error                       // Why it failed
+}
+
+// New returns a verifier for tokens from issuer (the provider's issuer URL,
+// exactly as it appears in tokens) issued for audience (the client ID).
+func New(issuer, audience string, c clock.Clock) *Verifier {
+	return &Verifier{
+		issuer:   issuer,
+		audience: audience,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		clock:    c,
+	}
+}
+
+// Verify checks a token's signature, issuer, audience, and validity period
+// and returns its claims. Errors for tokens that fail the checks wrap
+// ErrInvalidToken; others mean the provider couldn't be reached.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	now := v.clock.Now()
+	switch {
+	case c.Issuer != v.issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidToken, c.Issuer)
+	case !c.Audience.contains(v.audience):
+		return nil, fmt.Errorf("%w: not issued for %q", ErrInvalidToken, v.audience)
+	case c.Expiry == 0 || now.After(time.Unix(c.Expiry, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)):
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return &c.Claims, nil
+}
+
+// contains reports whether a lists want.
+func (a audience) contains(want string) bool {
+	for _, aud := range a {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the signing key with the given ID, fetching the keys if it
+// isn't known and they haven't been fetched within refetchInterval. After
+// a failed fetch, the failure is returned for retryInterval without asking
+// the provider again.
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	now := v.clock.Now()
+	if v.fetchErr != nil && now.Sub(v.failed) < retryInterval {
+		return nil, v.fetchErr
+	}
+	if v.keys != nil && now.Sub(v.fetched) < refetchInterval {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	if err := v.fetchKeys(ctx); err != nil {
+		v.failed, v.fetchErr = now, err
+		return nil, err
+	}
+	v.fetchErr = nil
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// fetchKeys replaces the keys with the provider's current ones. Caller
+// must hold mu.
+func (v *Verifier) fetchKeys(ctx context.Context) error {
+	if v.jwksURL == "" {
+		var discovery struct {
+			Issuer  string `json:"issuer"`
+			JWKSURI string `json:"jwks_uri"`
+		}
+		url := strings.TrimSuffix(v.issuer, "/") + "/.well-known/openid-configuration"
+		if err := v.fetch(ctx, url, &discovery); err != nil {
+			return fmt.Errorf("fetching OIDC discovery document: %w", err)
+		}
+		if discovery.Issuer != v.issuer {
+			return fmt.Errorf("OIDC discovery document is for issuer %q, not %q", discovery.Issuer, v.issuer)
+		}
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("OIDC discovery document has no jwks_uri")
+		}
+		v.jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.fetch(ctx, v.jwksURL, &set); err != nil {
+		return fmt.Errorf("fetching OIDC keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	v.keys = keys
+	v.fetched = v.clock.Now()
+	return nil
+}
+
+// fetch decodes the JSON document at url into dst.
+func (v *Verifier) fetch(ctx context.Context, url string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(dst)
+}
+
+// jwk is a JSON Web Key (RFC 7517) of the kinds providers sign with.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`   // RSA modulus
+	E   string `json:"e"`   // RSA exponent
+	Crv string `json:"crv"` // EC curve
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the key as an *rsa.PublicKey or *ecdsa.PublicKey.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// verifySignature checks a JWS signature of signed with key under alg.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an RSA key", alg)
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, signature, nil)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+	case strings.HasPrefix(alg, "ES"):
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%s needs an EC key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("bad signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+}
+
+// decodeSegment decodes a base64url JSON segment of a token into v.
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// decodeInt decodes a base64url big-endian integer of a JWK.
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("bad key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,222 @@
+// Package oidc provides tests for bearer token verification.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/clock"
+)
+
+// provider is a fake OpenID Connect provider serving discovery and keys.
+type provider struct {
+	server  *httptest.Server
+	keys    atomic.Pointer[[]map[string]string]
+	fetches atomic.Int32
+	down    atomic.Bool // Fail key fetches
+}
+
+// newProvider starts a provider publishing the given keys.
+func newProvider(t *testing.T, keys ...map[string]string) *provider {
+	t.Helper()
+	p := &provider{}
+	p.keys.Store(&keys)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": p.server.URL, "jwks_uri": p.server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		p.fetches.Add(1)
+		if p.down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": *p.keys.Load()})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// rsaJWK returns key's public half as a JWK.
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA", "kid": kid, "use": "sig",
+		"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// sign returns claims as a JWT signed with key under alg.
+func sign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := newProvider(t, rsaJWK("k1", key))
+	now := time.Unix(1717286400, 0)
+	v := New(p.server.URL, "flashpaper", clock.NewFake(now))
+	ctx := context.Background()
+
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss": p.server.URL, "aud": "flashpaper", "sub": "user-1",
+			"preferred_username": "alice", "exp": now.Add(time.Hour).Unix(),
+		}
+	}
+
+	claims, err := v.Verify(ctx, sign(t, "RS256", "k1", key, valid()))
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, "alice", claims.Name)
+
+	// aud may also be a list
+	c := valid()
+	c["aud"] = []string{"other", "flashpaper"}
+	_, err = v.Verify(ctx, sign(t, "RS256", "k1", key, c))
+	assert.NoError(t, err)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	for name, token := range map[string]string{
+		"wrong issuer":   sign(t, "RS256", "k1", key, with(valid(), "iss", "https://evil.example.com")),
+		"wrong audience": sign(t, "RS256", "k1", key, with(valid(), "aud", "other")),
+		"expired":        sign(t, "RS256", "k1", key, with(valid(), "exp", now.Add(-time.Hour).Unix())),
+		"not yet valid":  sign(t, "RS256", "k1", key, with(valid(), "nbf", now.Add(time.Hour).Unix())),
+		"no expiry":      sign(t, "RS256", "k1", key, with(valid(), "exp", nil)),
+		"forged":         sign(t, "RS256", "k1", other, valid()),
+		"unknown key":    sign(t, "RS256", "k2", key, valid()),
+		"not a JWT":      "bearer-secret",
+	} {
+		_, err := v.Verify(ctx, token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
+
+// with returns claims with name set to value, or removed for nil.
+func with(claims map[string]any, name string, value any) map[string]any {
+	if value == nil {
+		delete(claims, name)
+	} else {
+		claims[name] = value
+	}
+	return claims
+}
+
+// TestVerify_Algorithms tests that EC keys are supported and that "none"
+// and HMAC signatures, which need no private key, are refused.
+func TestVerify_Algorithms(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p := newProvider(t, map[string]string{
+		"kty": "EC", "kid": "ec", "crv": "P-256",
+		"x": base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+		"y": base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+	})
+	now := time.Now()
+	v := New(p.server.URL, "flashpaper", clock.NewFake(now))
+	claims := map[string]any{"iss": p.server.URL, "aud": "flashpaper", "sub": "svc", "exp": now.Add(time.Hour).Unix()}
+
+	_, err = v.Verify(context.Background(), sign(t, "ES256", "ec", ecKey, claims))
+	assert.NoError(t, err)
+
+	token := sign(t, "ES256", "ec", ecKey, claims)
+	for _, alg := range []string{"none", "HS256"} {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","kid":"ec"}`))
+		forged := header + token[len(base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"ec","typ":"JWT"}`))):]
+		_, err := v.Verify(context.Background(), forged)
+		assert.ErrorIs(t, err, ErrInvalidToken, alg)
+	}
+}
+
+// TestVerify_KeyRotation tests that a token signed with a new key refetches
+// the keys, but not more than once per refetchInterval.
+func TestVerify_KeyRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := newProvider(t, rsaJWK("old", oldKey))
+	c := clock.NewFake(time.Now())
+	v := New(p.server.URL, "flashpaper", c)
+	ctx := context.Background()
+	claims := map[string]any{"iss": p.server.URL, "aud": "flashpaper", "exp": c.Now().Add(time.Hour).Unix()}
+
+	_, err = v.Verify(ctx, sign(t, "RS256", "old", oldKey, claims))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), p.fetches.Load())
+
+	// Within refetchInterval of the last fetch, unknown keys are refused
+	// without asking the provider
+	p.keys.Store(&[]map[string]string{rsaJWK("old", oldKey), rsaJWK("new", newKey)})
+	_, err = v.Verify(ctx, sign(t, "RS256", "new", newKey, claims))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, int32(1), p.fetches.Load())
+
+	c.Advance(refetchInterval)
+	_, err = v.Verify(ctx, sign(t, "RS256", "new", newKey, claims))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), p.fetches.Load())
+}
+
+// TestVerify_ProviderDown tests that a failed key fetch is remembered for
+// retryInterval, so an outage doesn't make every token a fetch.
+func TestVerify_ProviderDown(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := newProvider(t, rsaJWK("k1", key))
+	p.down.Store(true)
+	c := clock.NewFake(time.Now())
+	v := New(p.server.URL, "flashpaper", c)
+	ctx := context.Background()
+	token := sign(t, "RS256", "k1", key, map[string]any{"iss": p.server.URL, "aud": "flashpaper", "exp": c.Now().Add(time.Hour).Unix()})
+
+	for i := 0; i < 3; i++ {
+		_, err = v.Verify(ctx, token)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrInvalidToken)
+	}
+	assert.Equal(t, int32(1), p.fetches.Load())
+
+	// Once retryInterval has passed, the provider is asked again
+	p.down.Store(false)
+	c.Advance(retryInterval)
+	_, err = v.Verify(ctx, token)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), p.fetches.Load())
+}
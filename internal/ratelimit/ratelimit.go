@@ -0,0 +1,264 @@
+// Package ratelimit limits how often each client may perform an action.
+// A Rule is a token bucket: a client may make Burst requests at once, and
+// regains one every Interval. Buckets are tracked with the generic cell rate
+// algorithm, which reduces a bucket to a single timestamp (the time at which
+// it will be full again), so a Store only has to keep one value per client.
+//
+// Two stores are provided: MemoryStore for a single instance, and KVStore on
+// the storage key-value layer, which replicas sharing a backend also share.
+// A KVStore on a backend that can swap values conditionally takes requests
+// with a check-and-set, so replicas don't let one client's concurrent
+// requests through on the same token.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+)
+
+// Rule is a token bucket. The zero Rule allows everything.
+type Rule struct {
+	Interval time.Duration // Time to regain one request; 0 = unlimited
+	Burst    int           // Requests allowed at once; less than 1 means 1
+}
+
+// Enabled reports whether the rule limits anything.
+func (r Rule) Enabled() bool {
+	return r.Interval > 0
+}
+
+// burst returns the bucket size.
+func (r Rule) burst() int {
+	return max(r.Burst, 1)
+}
+
+// Result is the outcome of Allow.
+type Result struct {
+	Allowed    bool
+	Limit      int           // Bucket size
+	Remaining  int           // Requests left right now
+	RetryAfter time.Duration // Until the next request is allowed; 0 if Allowed
+	Reset      time.Duration // Until the bucket is full again
+}
+
+// Store keeps each key's bucket as the time it will be full again.
+type Store interface {
+	// Load returns the stored time, or the zero time if there is none.
+	Load(ctx context.Context, key string) (time.Time, error)
+
+	// Save stores the time for key. It may drop entries already in the
+	// past, which are equivalent to none.
+	Save(ctx context.Context, key string, full time.Time) error
+}
+
+// Swapper is implemented by stores that can save a bucket only if it is
+// unchanged since it was loaded.
+type Swapper interface {
+	// CompareAndSave stores full for key if its time is still old, as Load
+	// returned it, and reports whether it did.
+	CompareAndSave(ctx context.Context, key string, old, full time.Time) (bool, error)
+}
+
+// swapAttempts is how often a request contends for a bucket others keep
+// changing before it is refused.
+const swapAttempts = 5
+
+// errContended is returned by take when the bucket kept changing.
+var errContended = errors.New("bucket contended")
+
+// Limiter applies rules to keys using a store. Checks are serialized within
+// the process; across replicas, only a store that is a Swapper keeps
+// concurrent requests from one client from both being allowed.
+type Limiter struct {
+	mu    sync.Mutex
+	store Store
+	clock clock.Clock
+}
+
+// New returns a limiter keeping its state in store, refilling buckets as
+// c advances.
+func New(store Store, c clock.Clock) *Limiter {
+	return &Limiter{store: store, clock: c}
+}
+
+// Allow takes a request from key's bucket under rule. If the store fails,
+// the request is allowed and the error returned, so a storage outage
+// doesn't take the service down with it.
+func (l *Limiter) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	burst := rule.burst()
+	if !rule.Enabled() {
+		return Result{Allowed: true, Limit: burst, Remaining: burst}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		result, err := l.take(ctx, key, rule, burst)
+		if err != errContended {
+			return result, err
+		}
+		if attempt == swapAttempts {
+			// Others took the bucket's requests in the meantime
+			return Result{Limit: burst, RetryAfter: rule.Interval, Reset: rule.Interval}, nil
+		}
+	}
+}
+
+// take makes one attempt at taking a request from key's bucket. It returns
+// errContended if a Swapper store saw the bucket change since loading it.
+func (l *Limiter) take(ctx context.Context, key string, rule Rule, burst int) (Result, error) {
+	now := l.clock.Now()
+	stored, err := l.store.Load(ctx, key)
+	if err != nil {
+		return Result{Allowed: true, Limit: burst, Remaining: burst - 1}, err
+	}
+	full := stored
+	if full.Before(now) {
+		full = now
+	}
+
+	// Taking a request pushes the full time one interval further; it is
+	// allowed if that leaves the bucket no more than burst intervals short
+	next := full.Add(rule.Interval)
+	allowAt := next.Add(-time.Duration(burst) * rule.Interval)
+	if now.Before(allowAt) {
+		return Result{
+			Limit:      burst,
+			RetryAfter: allowAt.Sub(now),
+			Reset:      full.Sub(now),
+		}, nil
+	}
+
+	if swapper, ok := l.store.(Swapper); ok {
+		var swapped bool
+		swapped, err = swapper.CompareAndSave(ctx, key, stored, next)
+		if err == nil && !swapped {
+			return Result{}, errContended
+		}
+	} else {
+		err = l.store.Save(ctx, key, next)
+	}
+	return Result{
+		Allowed:   true,
+		Limit:     burst,
+		Remaining: int(now.Sub(allowAt) / rule.Interval),
+		Reset:     next.Sub(now),
+	}, err
+}
+
+// sweepEvery is how many saves a MemoryStore makes between sweeps.
+const sweepEvery = 1024
+
+// MemoryStore keeps buckets in memory. Buckets that have filled up are
+// swept out periodically, so memory follows the number of recent clients.
+type MemoryStore struct {
+	mu    sync.Mutex
+	full  map[string]time.Time
+	saves int
+	clock clock.Clock // Decides which buckets are full
+}
+
+// NewMemoryStore returns an empty in-memory store. It should share the
+// limiter's clock.
+func NewMemoryStore(c clock.Clock) *MemoryStore {
+	return &MemoryStore{full: map[string]time.Time{}, clock: c}
+}
+
+// Load returns key's stored time.
+func (m *MemoryStore) Load(ctx context.Context, key string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.full[key], nil
+}
+
+// Save stores key's time, sweeping full buckets every so often.
+func (m *MemoryStore) Save(ctx context.Context, key string, full time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.full[key] = full
+	m.saves++
+	if m.saves%sweepEvery == 0 {
+		now := m.clock.Now()
+		for k, t := range m.full {
+			if t.Before(now) {
+				delete(m.full, k)
+			}
+		}
+	}
+	return nil
+}
+
+// KV is the part of storage.Storage a KVStore needs.
+type KV interface {
+	GetValue(ctx context.Context, namespace, key string) (string, error)
+	SetValue(ctx context.Context, namespace, key, value string) error
+}
+
+// SwapKV is a KV that can also set a value only if it still holds what was
+// read, as storage.ValueSwapper.
+type SwapKV interface {
+	KV
+	CompareAndSwapValue(ctx context.Context, namespace, key, old, new string) (bool, error)
+}
+
+// KVStore keeps buckets in key-value storage under a namespace, as Unix
+// milliseconds. Values it can't parse count as none.
+type KVStore struct {
+	kv        KV
+	namespace string
+}
+
+// NewKVStore returns a store keeping buckets in kv under namespace. If kv
+// is a SwapKV, the store is a Swapper.
+func NewKVStore(kv KV, namespace string) Store {
+	s := &KVStore{kv: kv, namespace: namespace}
+	if swap, ok := kv.(SwapKV); ok {
+		return &swapKVStore{KVStore: s, swap: swap}
+	}
+	return s
+}
+
+// Load returns key's stored time.
+func (s *KVStore) Load(ctx context.Context, key string) (time.Time, error) {
+	value, err := s.kv.GetValue(ctx, s.namespace, key)
+	if err != nil || value == "" {
+		return time.Time{}, err
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// Save stores key's time.
+func (s *KVStore) Save(ctx context.Context, key string, full time.Time) error {
+	return s.kv.SetValue(ctx, s.namespace, key, strconv.FormatInt(full.UnixMilli(), 10))
+}
+
+// swapKVStore is a KVStore on a SwapKV.
+type swapKVStore struct {
+	*KVStore
+	swap SwapKV
+}
+
+// CompareAndSave stores key's time if it is still old. The value is read
+// again to swap from it as stored: Load counts values it can't parse as
+// none, and those must be replaced rather than contended for forever.
+func (s *swapKVStore) CompareAndSave(ctx context.Context, key string, old, full time.Time) (bool, error) {
+	value, err := s.kv.GetValue(ctx, s.namespace, key)
+	if err != nil {
+		return false, err
+	}
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil && !time.UnixMilli(ms).Equal(old) {
+		return false, nil // Changed since Load
+	}
+	return s.swap.CompareAndSwapValue(ctx, s.namespace, key, value, strconv.FormatInt(full.UnixMilli(), 10))
+}
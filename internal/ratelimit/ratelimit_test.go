@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/clock"
+)
+
+// testClock is the fake clock limiters under test run on.
+func testClock() *clock.Fake {
+	return clock.NewFake(time.Unix(1_700_000_000, 0))
+}
+
+// testLimiter returns a limiter on store whose clock advances only when
+// the returned function is called.
+func testLimiter(store Store) (*Limiter, func(time.Duration)) {
+	c := testClock()
+	return New(store, c), c.Advance
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	ctx := context.Background()
+	l, _ := testLimiter(NewMemoryStore(testClock()))
+	for i := 0; i < 100; i++ {
+		result, err := l.Allow(ctx, "client", Rule{})
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+}
+
+func TestLimiter_OnePerInterval(t *testing.T) {
+	ctx := context.Background()
+	l, advance := testLimiter(NewMemoryStore(testClock()))
+	rule := Rule{Interval: 10 * time.Second}
+
+	result, err := l.Allow(ctx, "client", rule)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Allowed: true, Limit: 1, Reset: 10 * time.Second}, result)
+
+	result, err = l.Allow(ctx, "client", rule)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 10*time.Second, result.RetryAfter)
+
+	// Other clients have their own buckets
+	result, _ = l.Allow(ctx, "other", rule)
+	assert.True(t, result.Allowed)
+
+	advance(9 * time.Second)
+	result, _ = l.Allow(ctx, "client", rule)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+
+	advance(time.Second)
+	result, _ = l.Allow(ctx, "client", rule)
+	assert.True(t, result.Allowed)
+}
+
+func TestLimiter_Burst(t *testing.T) {
+	ctx := context.Background()
+	l, advance := testLimiter(NewMemoryStore(testClock()))
+	rule := Rule{Interval: time.Minute, Burst: 3}
+
+	for want := 2; want >= 0; want-- {
+		result, err := l.Allow(ctx, "client", rule)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, want, result.Remaining)
+	}
+	result, _ := l.Allow(ctx, "client", rule)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Minute, result.RetryAfter)
+	assert.Equal(t, 3*time.Minute, result.Reset)
+
+	// One request regained per interval, never more than the burst
+	advance(time.Minute)
+	result, _ = l.Allow(ctx, "client", rule)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	advance(time.Hour)
+	result, _ = l.Allow(ctx, "client", rule)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+}
+
+// mapKV is an in-memory KV.
+type mapKV struct {
+	values map[string]string
+	err    error
+}
+
+func (m *mapKV) GetValue(ctx context.Context, namespace, key string) (string, error) {
+	return m.values[namespace+"/"+key], m.err
+}
+
+func (m *mapKV) SetValue(ctx context.Context, namespace, key, value string) error {
+	m.values[namespace+"/"+key] = value
+	return m.err
+}
+
+func TestKVStore(t *testing.T) {
+	ctx := context.Background()
+	kv := &mapKV{values: map[string]string{}}
+	l, _ := testLimiter(NewKVStore(kv, "traffic"))
+	rule := Rule{Interval: 10 * time.Second}
+
+	result, err := l.Allow(ctx, "paste.abc", rule)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, "1700000010000", kv.values["traffic/paste.abc"])
+
+	// A second limiter on the same storage sees the bucket
+	other, _ := testLimiter(NewKVStore(kv, "traffic"))
+	result, _ = other.Allow(ctx, "paste.abc", rule)
+	assert.False(t, result.Allowed)
+
+	// Values from before, or otherwise unreadable, count as none
+	kv.values["traffic/paste.abc"] = "garbage"
+	result, _ = other.Allow(ctx, "paste.abc", rule)
+	assert.True(t, result.Allowed)
+}
+
+// swapKV is a mapKV that can swap values, running before (once) first.
+type swapKV struct {
+	mapKV
+	before func()
+}
+
+func (s *swapKV) CompareAndSwapValue(ctx context.Context, namespace, key, old, new string) (bool, error) {
+	if before := s.before; before != nil {
+		s.before = nil
+		before()
+	}
+	if s.values[namespace+"/"+key] != old {
+		return false, nil
+	}
+	s.values[namespace+"/"+key] = new
+	return true, nil
+}
+
+func TestKVStore_Swap(t *testing.T) {
+	ctx := context.Background()
+	kv := &swapKV{mapKV: mapKV{values: map[string]string{}}}
+	l, _ := testLimiter(NewKVStore(kv, "traffic"))
+	other, _ := testLimiter(NewKVStore(kv, "traffic"))
+	rule := Rule{Interval: 10 * time.Second}
+
+	// Another replica takes the only request between Load and save
+	var otherResult Result
+	kv.before = func() { otherResult, _ = other.Allow(ctx, "paste.abc", rule) }
+	result, err := l.Allow(ctx, "paste.abc", rule)
+	require.NoError(t, err)
+	assert.True(t, otherResult.Allowed)
+	assert.False(t, result.Allowed, "the request lost the bucket's only token")
+	assert.Equal(t, "1700000010000", kv.values["traffic/paste.abc"])
+
+	// Unreadable values are swapped out, not contended for
+	kv.values["traffic/paste.abc"] = "garbage"
+	result, _ = l.Allow(ctx, "paste.abc", rule)
+	assert.True(t, result.Allowed)
+}
+
+func TestLimiter_StoreErrorAllows(t *testing.T) {
+	ctx := context.Background()
+	kv := &mapKV{values: map[string]string{}, err: errors.New("database down")}
+	l, _ := testLimiter(NewKVStore(kv, "traffic"))
+
+	result, err := l.Allow(ctx, "client", Rule{Interval: time.Hour})
+	assert.Error(t, err)
+	assert.True(t, result.Allowed)
+}
@@ -0,0 +1,223 @@
+// Package redis provides the small part of the Redis protocol FlashPaper
+// needs to relay notifications between replicas: PUBLISH and SUBSCRIBE on
+// one channel, with optional AUTH. Pulling in a full client library for two
+// commands isn't worth the dependency; like the S3 backend's client, this
+// speaks the wire protocol (RESP) directly.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// dialTimeout bounds connecting and authenticating.
+const dialTimeout = 5 * time.Second
+
+// Client publishes to and subscribes to a Redis server. Publishing reuses
+// one connection, redialed after failures; each Subscribe has its own.
+type Client struct {
+	addr     string
+	password string
+
+	mu   sync.Mutex // Guards conn and serializes publishes
+	conn *conn      // Publishing connection; nil until used or after a failure
+}
+
+// New returns a client for the server at addr (host:port).
+func New(addr, password string) *Client {
+	return &Client{addr: addr, password: password}
+}
+
+// conn is a connection speaking RESP.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// dial connects to the server and authenticates.
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc)}
+	if c.password != "" {
+		nc.SetDeadline(time.Now().Add(dialTimeout))
+		if _, err := cn.do("AUTH", c.password); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("redis auth: %w", err)
+		}
+		nc.SetDeadline(time.Time{})
+	}
+	return cn, nil
+}
+
+// Publish sends message to channel and returns once the server took it.
+func (c *Client) Publish(ctx context.Context, channel string, message []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		cn, err := c.dial(ctx)
+		if err != nil {
+			return err
+		}
+		c.conn = cn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+	} else {
+		c.conn.SetDeadline(time.Time{})
+	}
+	if _, err := c.conn.do("PUBLISH", channel, string(message)); err != nil {
+		// The connection may be out of step with the server; start over
+		var serverErr Error
+		if !errors.As(err, &serverErr) {
+			c.conn.Close()
+			c.conn = nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Subscribe calls fn with every message published to channel until ctx
+// is done or the connection fails. It returns ctx's error in the first
+// case; callers resubscribe after the second, after a pause.
+func (c *Client) Subscribe(ctx context.Context, channel string, fn func(message []byte)) error {
+	cn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer cn.Close()
+
+	// Closing the connection unblocks the read below
+	stop := context.AfterFunc(ctx, func() { cn.Close() })
+	defer stop()
+
+	if err := cn.send("SUBSCRIBE", channel); err != nil {
+		return err
+	}
+	for {
+		reply, err := cn.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		// Pushes are ["message", channel, payload]; the rest are
+		// confirmations
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 3 {
+			continue
+		}
+		if kind, _ := parts[0].(string); kind != "message" {
+			continue
+		}
+		if payload, ok := parts[2].(string); ok {
+			fn([]byte(payload))
+		}
+	}
+}
+
+// Close closes the publishing connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// Error is an error reply from the server.
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// do sends a command and reads its reply.
+func (cn *conn) do(args ...string) (interface{}, error) {
+	if err := cn.send(args...); err != nil {
+		return nil, err
+	}
+	reply, err := cn.read()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// send writes a command as an array of bulk strings.
+func (cn *conn) send(args ...string) error {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := cn.Write(buf)
+	return err
+}
+
+// read reads one reply: a string for simple and bulk strings, nil for a
+// null bulk string, an int64, an Error, or a []interface{} of replies.
+func (cn *conn) read() (interface{}, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return Error(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = cn.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
@@ -0,0 +1,151 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a Redis server knowing AUTH, PUBLISH, and SUBSCRIBE.
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu          sync.Mutex
+	subscribers map[string][]*conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{ln: ln, password: password, subscribers: map[string][]*conn{}}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(&conn{Conn: nc, r: bufio.NewReader(nc)})
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(cn *conn) {
+	defer cn.Close()
+	authed := f.password == ""
+	for {
+		reply, err := cn.read()
+		if err != nil {
+			return
+		}
+		args, _ := reply.([]interface{})
+		if len(args) == 0 {
+			return
+		}
+		switch cmd, _ := args[0].(string); cmd {
+		case "AUTH":
+			if args[1] != f.password {
+				cn.Write([]byte("-WRONGPASS invalid password\r\n"))
+				continue
+			}
+			authed = true
+			cn.Write([]byte("+OK\r\n"))
+		case "SUBSCRIBE":
+			if !authed {
+				cn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+				continue
+			}
+			channel := args[1].(string)
+			f.mu.Lock()
+			f.subscribers[channel] = append(f.subscribers[channel], cn)
+			f.mu.Unlock()
+			cn.send("subscribe", channel)
+		case "PUBLISH":
+			if !authed {
+				cn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+				continue
+			}
+			channel, message := args[1].(string), args[2].(string)
+			f.mu.Lock()
+			subscribers := f.subscribers[channel]
+			f.mu.Unlock()
+			for _, sub := range subscribers {
+				sub.send("message", channel, message)
+			}
+			cn.Write([]byte(":" + strconv.Itoa(len(subscribers)) + "\r\n"))
+		}
+	}
+}
+
+// subscribed waits until channel has n subscribers.
+func (f *fakeRedis) subscribed(t *testing.T, channel string, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return len(f.subscribers[channel]) == n
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestClient_PublishSubscribe(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	c := New(f.ln.Addr().String(), "secret")
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan string, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Subscribe(ctx, "events", func(message []byte) { received <- string(message) })
+	}()
+	f.subscribed(t, "events", 1)
+
+	require.NoError(t, c.Publish(context.Background(), "events", []byte("hello\r\nworld")))
+	select {
+	case message := <-received:
+		assert.Equal(t, "hello\r\nworld", message)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	// Other channels aren't delivered
+	require.NoError(t, c.Publish(context.Background(), "other", []byte("ignored")))
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Empty(t, received)
+}
+
+func TestClient_Auth(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+
+	c := New(f.ln.Addr().String(), "wrong")
+	err := c.Publish(context.Background(), "events", []byte("message"))
+	assert.ErrorContains(t, err, "WRONGPASS")
+
+	c = New(f.ln.Addr().String(), "")
+	err = c.Publish(context.Background(), "events", []byte("message"))
+	var serverErr Error
+	assert.ErrorAs(t, err, &serverErr)
+}
+
+func TestClient_Unreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c := New(addr, "")
+	assert.Error(t, c.Publish(context.Background(), "events", []byte("message")))
+	assert.Error(t, c.Subscribe(context.Background(), "events", func([]byte) {}))
+}
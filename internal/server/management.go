@@ -0,0 +1,52 @@
+// Package server provides the management listener.
+// With [metrics] address set, /metrics and, if [metrics] debug is on, the
+// Go profiler under /debug/pprof/ are served on their own host:port rather
+// than the public listener, so a reverse proxy in front of FlashPaper needs
+// no rules to hide them. [metrics] token protects both with a bearer token.
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/handler"
+	fpMiddleware "github.com/liskl/flashpaper/internal/middleware"
+)
+
+// metricsRealm is the WWW-Authenticate realm of the management endpoints.
+const metricsRealm = "flashpaper-metrics"
+
+// managementServer returns the server for the management listener, or nil
+// if [metrics] address is unset or there is nothing to serve on it.
+func managementServer(cfg *config.Config, h *handler.Handler) *http.Server {
+	m := cfg.Metrics
+	if m.Address == "" || !(m.Enabled || m.Debug) {
+		return nil
+	}
+
+	r := chi.NewRouter()
+	r.Use(fpMiddleware.BearerToken(m.Token, metricsRealm))
+	if m.Enabled {
+		r.Method(http.MethodGet, "/metrics", h.MetricsHandler())
+	}
+	if m.Debug {
+		r.HandleFunc("/debug/pprof/*", pprof.Index)
+		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	// CPU profiles and traces stream for ?seconds= (30 by default), so
+	// writes get a generous deadline
+	return &http.Server{
+		Addr:              m.Address,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      5 * time.Minute,
+	}
+}
@@ -6,74 +6,263 @@ package server
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/cloudflare"
 	"github.com/liskl/flashpaper/internal/config"
 	"github.com/liskl/flashpaper/internal/handler"
 	fpMiddleware "github.com/liskl/flashpaper/internal/middleware"
 	"github.com/liskl/flashpaper/internal/storage"
+	"github.com/liskl/flashpaper/internal/tracing"
 )
 
 // Server wraps the HTTP server with FlashPaper configuration.
 type Server struct {
-	httpServer *http.Server
-	config     *config.Config
-	store      storage.Storage
+	httpServer     *http.Server
+	metricsServer  *http.Server // Management listener for /metrics and /debug (nil if none)
+	redirectServer *http.Server // HTTP to HTTPS redirects (nil unless TLS)
+	handler        *handler.Handler
+	config         *config.Config
+	store          storage.Storage
+	cloudflare     *cloudflare.Ranges // Cloudflare's networks (nil unless that preset is on)
+	tracer         *tracing.Tracer    // Exports spans (nil unless [telemetry] enabled)
+	background     context.Context    // Done once Shutdown begins
+	stop           context.CancelFunc // Cancels background
+	bodyLimit      *atomic.Int64      // Largest request body; changes on Reload
 }
 
 // New creates a new FlashPaper HTTP server.
 func New(cfg *config.Config, store storage.Storage) (*Server, error) {
+	// Create the main handler first; its template manifest shapes the CSP.
+	// Storage calls are timed for the metrics the handler registers, and
+	// traced if telemetry or Server-Timing is on.
+	backend := strings.ToLower(cfg.Model.Class)
+	tracer := tracing.New(cfg.Telemetry)
+	traced := store
+	if tracer != nil || cfg.Telemetry.ServerTiming {
+		traced = storage.WithTracing(store, tracer, backend)
+	}
+	h := handler.New(cfg, storage.WithMetrics(traced, backend))
+	if err := h.Ready(); err != nil {
+		// Keep serving the API; /readyz reports the degraded UI
+		slog.Warn("UI degraded", "error", err)
+	}
+
 	// Create the main router
 	r := chi.NewRouter()
 
 	// Apply middleware stack
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
+	// Behind Cloudflare, forwarded addresses are only trusted from its
+	// networks; otherwise from [traffic] trusted_proxies, if any are listed
+	var ranges *cloudflare.Ranges
+	proxies, _ := config.ParseNetworks(cfg.Traffic.TrustedProxies) // Checked by Validate
+	switch {
+	case cfg.Traffic.Preset == config.TrafficPresetCloudflare:
+		ranges = cloudflare.New()
+		r.Use(fpMiddleware.Cloudflare(ranges, slog.Default()))
+	case len(proxies) > 0:
+		r.Use(fpMiddleware.ProxyRealIP(proxies, cfg.Traffic.Header))
+	default:
+		r.Use(fpMiddleware.RealIP)
+	}
+	if tracer != nil || cfg.Telemetry.ServerTiming {
+		r.Use(fpMiddleware.Tracing(tracer, cfg.Telemetry.ServerTiming))
+	}
+	r.Use(fpMiddleware.RequestLogger(slog.Default(), cfg.Main.LogPrivacy))
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(fpMiddleware.Metrics(h.Metrics()))
+	// Turn away requests beyond the in-flight caps, after they are counted
+	// and logged; probes and scrapes must get through a spike, and event
+	// streams, which hold their slot for as long as they are open, are
+	// capped by [events] max_clients instead
+	r.Use(fpMiddleware.Concurrency(cfg.Server.MaxReads, cfg.Server.MaxWrites,
+		"/health", "/healthz", "/readyz", "/metrics", "/events"))
+	// Request timeouts are per route (see [server] and handler.Routes)
 
-	// Security headers
-	r.Use(fpMiddleware.SecurityHeaders(cfg))
+	// Security headers, with any CSP allowances the UI template declares
+	r.Use(fpMiddleware.SecurityHeadersWithCSP(cfg, h.CSPSources()))
 
-	// Create the main handler
-	h := handler.New(cfg, store)
+	// Cross-origin access to the JSON API for [security] cors_origins;
+	// the operator API and the UI's assets stay same-origin
+	r.Use(fpMiddleware.CORS(cfg.Security, "/", "/receipt", "/extend", "/disable",
+		"/raw/*", "/p/*", "/paste/*", "/config", "/.well-known/flashpaper.json", "/stats", "/api/*"))
+
+	// Bound request bodies and drain whatever handlers leave unread,
+	// so early error responses don't cost clients their keep-alive connection
+	bodyLimit := new(atomic.Int64)
+	bodyLimit.Store(handler.MaxRequestBody(cfg))
+	r.Use(fpMiddleware.RequestBodyLimit(bodyLimit.Load))
+
+	// Compress pages and JSON; static assets carry their own encodings
+	if cfg.Server.Compress {
+		r.Use(fpMiddleware.Compress(cfg.Server.CompressLevel, cfg.Server.CompressTypes))
+	}
+
+	// Prometheus scrape endpoint, next to the app or on the management
+	// listener (see management.go)
+	metricsServer := managementServer(cfg, h)
+	if cfg.Metrics.Enabled && cfg.Metrics.Address == "" {
+		r.With(fpMiddleware.BearerToken(cfg.Metrics.Token, metricsRealm)).
+			Method(http.MethodGet, "/metrics", h.MetricsHandler())
+	}
 
 	// Mount routes
 	r.Mount("/", h.Routes())
 
+	tlsConfig, redirectServer, err := tlsSetup(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create HTTP server
+	// Connection deadlines must outlast the longest route timeout, or a slow
+	// upload would be cut off before its route gives up; the margin leaves
+	// room to send the timeout response itself
 	addr := fmt.Sprintf("%s:%d", cfg.Main.Host, cfg.Main.Port)
+	deadline := max(cfg.Server.Longest(), 30*time.Second) + 5*time.Second
+	readTimeout := seconds(cfg.Server.ConnReadTimeout, deadline)
+	idleTimeout := seconds(cfg.Server.ConnIdleTimeout, 120*time.Second)
+	var root http.Handler = r
+	if cfg.Server.H2C && tlsConfig == nil {
+		// Without TLS there is no ALPN to offer HTTP/2, so accept it in
+		// cleartext from proxies that open with the HTTP/2 preface
+		root = h2c.NewHandler(r, &http2.Server{IdleTimeout: idleTimeout})
+	}
 	httpServer := &http.Server{
-		Addr:         addr,
-		Handler:      r,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:              addr,
+		Handler:           root,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: seconds(cfg.Server.ConnHeaderTimeout, readTimeout),
+		WriteTimeout:      seconds(cfg.Server.ConnWriteTimeout, deadline),
+		IdleTimeout:       idleTimeout,
+		TLSConfig:         tlsConfig,
 	}
+	// Open event streams never finish on their own; end them so graceful
+	// shutdown doesn't wait out its deadline
+	httpServer.RegisterOnShutdown(h.CloseStreams)
 
+	background, stop := context.WithCancel(context.Background())
 	return &Server{
-		httpServer: httpServer,
-		config:     cfg,
-		store:      store,
+		httpServer:     httpServer,
+		metricsServer:  metricsServer,
+		redirectServer: redirectServer,
+		handler:        h,
+		config:         cfg,
+		store:          store,
+		cloudflare:     ranges,
+		tracer:         tracer,
+		background:     background,
+		stop:           stop,
+		bodyLimit:      bodyLimit,
 	}, nil
 }
 
-// ListenAndServe starts the HTTP server.
+// AddCommentHook registers an anti-spam hook that runs before every
+// comment is stored. Call it before ListenAndServe.
+func (s *Server) AddCommentHook(hook handler.CommentHook) {
+	s.handler.AddCommentHook(hook)
+}
+
+// Reload applies the settings of next that can change while serving (see
+// config.Reload) and returns the changed settings that need a restart.
+func (s *Server) Reload(next *config.Config) []string {
+	restart := s.handler.Reload(next)
+	s.bodyLimit.Store(handler.MaxRequestBody(next))
+	return restart
+}
+
+// SetClock replaces the system clock for expiration and rate limiting.
+// Call it before serving.
+func (s *Server) SetClock(c clock.Clock) {
+	s.handler.SetClock(c)
+}
+
+// ListenAndServe starts the HTTP server, and the management server if
+// [metrics] address is set. With TLS on, it serves HTTPS and starts the
+// redirect server. Failures of the extra listeners are logged but don't
+// stop the application. Behind Cloudflare, its networks are refreshed in
+// the background until Shutdown, as is the [events] Redis relay.
 func (s *Server) ListenAndServe() error {
-	return s.httpServer.ListenAndServe()
+	if s.cloudflare != nil && s.config.Traffic.PresetRefresh > 0 {
+		go s.cloudflare.Run(s.background, time.Duration(s.config.Traffic.PresetRefresh)*time.Second)
+	}
+	go s.handler.RunEventRelay(s.background)
+	if s.metricsServer != nil {
+		go func() {
+			slog.Info("Management listening", "addr", s.metricsServer.Addr)
+			if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("Management server error", "error", err)
+			}
+		}()
+	}
+	if s.httpServer.TLSConfig == nil {
+		return s.httpServer.ListenAndServe()
+	}
+
+	if s.redirectServer != nil {
+		go func() {
+			slog.Info("Redirecting HTTP to HTTPS", "addr", s.redirectServer.Addr)
+			if err := s.redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("Redirect server error", "error", err)
+			}
+		}()
+	}
+	// Certificates come from TLSConfig
+	return s.httpServer.ListenAndServeTLS("", "")
+}
+
+// Serve serves plain HTTP on l until Shutdown, without the management,
+// redirect, or TLS setup of ListenAndServe. The testserver package runs
+// instances this way on a random port.
+func (s *Server) Serve(l net.Listener) error {
+	return s.httpServer.Serve(l)
 }
 
 // Shutdown gracefully shuts down the server.
+// In-flight requests finish first, then any background work they started
+// (such as creator callbacks) is allowed to complete, and finally the
+// storage backend is drained, and the remaining spans are exported. The
+// caller still closes the storage.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	s.stop()
+	if s.metricsServer != nil {
+		_ = s.metricsServer.Shutdown(ctx)
+	}
+	if s.redirectServer != nil {
+		_ = s.redirectServer.Shutdown(ctx)
+	}
+	err := s.httpServer.Shutdown(ctx)
+	s.handler.Wait()
+	if drainErr := storage.Drain(ctx, s.store); drainErr != nil && err == nil {
+		err = fmt.Errorf("draining storage: %w", drainErr)
+	}
+	if traceErr := s.tracer.Shutdown(ctx); traceErr != nil && err == nil {
+		err = traceErr
+	}
+	return err
 }
 
 // Addr returns the server's address.
 func (s *Server) Addr() string {
 	return s.httpServer.Addr
 }
+
+// seconds converts a configured number of seconds, using fallback for 0.
+func seconds(n int, fallback time.Duration) time.Duration {
+	if n <= 0 {
+		return fallback
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,84 @@
+// Package server provides native TLS for FlashPaper.
+// With [main] tls_cert and tls_key, or [main] acme, the server speaks HTTPS
+// itself rather than relying on a TLS-terminating proxy. ACME certificates
+// are obtained on the first handshake for each of [main] acme_domains and
+// renewed in the background. A plain HTTP listener on [main] redirect_port
+// sends browsers to HTTPS and answers ACME HTTP-01 challenges.
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// tlsSetup returns the TLS configuration for the main listener and the
+// HTTP redirect server, or nils when TLS is off. The redirect server is
+// also nil when [main] redirect_port is 0.
+func tlsSetup(cfg *config.Config) (*tls.Config, *http.Server, error) {
+	m := cfg.Main
+	if !m.TLSEnabled() {
+		return nil, nil, nil
+	}
+
+	redirect := httpsRedirect(m.Port)
+	var tlsConfig *tls.Config
+	if m.ACME {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(m.ACMEDomains...),
+			Cache:      autocert.DirCache(m.ACMECache),
+			Email:      m.ACMEEmail,
+		}
+		if m.ACMEDirectory != "" {
+			manager.Client = &acme.Client{DirectoryURL: m.ACMEDirectory}
+		}
+		tlsConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(redirect)
+	} else {
+		// Fail at startup rather than on the first handshake
+		cert, err := tls.LoadX509KeyPair(m.TLSCert, m.TLSKey)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading TLS certificate: %w", err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		}
+	}
+	tlsConfig.MinVersion = tls.VersionTLS12
+
+	var redirectServer *http.Server
+	if m.RedirectPort != 0 {
+		redirectServer = &http.Server{
+			Addr:              net.JoinHostPort(m.Host, strconv.Itoa(m.RedirectPort)),
+			Handler:           redirect,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      30 * time.Second,
+		}
+	}
+	return tlsConfig, redirectServer, nil
+}
+
+// httpsRedirect returns a handler that permanently redirects requests to
+// the same host and path on the HTTPS port.
+func httpsRedirect(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
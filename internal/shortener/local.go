@@ -0,0 +1,130 @@
+// Package shortener provides the local URL shortener.
+// Short codes are kept in storage next to the other key-value state, and
+// the handler redirects /s/{code} to the paste. A code outlives its paste
+// until it is next followed, which finds the paste gone and drops it.
+package shortener
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// CodeLength is the length of local short codes, of which there are
+// some 3.5 trillion. Guessing one only finds a paste ID: the key is never
+// part of a short link.
+const CodeLength = 7
+
+// codeAlphabet holds the characters of short codes.
+const codeAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// pasteKeyPrefix marks the keys that map paste IDs to their codes, which
+// are never taken for codes since those are CodeLength characters long.
+const pasteKeyPrefix = "paste:"
+
+// maxCodeAttempts bounds the search for an unused code.
+const maxCodeAttempts = 10
+
+// errNoCode is returned when no unused code was found.
+var errNoCode = errors.New("no unused short code found")
+
+// Local shortens URLs to codes kept in storage, served under /s/.
+type Local struct {
+	store storage.Storage
+	base  string // Prefix of short URLs, without a trailing slash
+}
+
+// NewLocal returns a local shortener whose short URLs start with base, the
+// instance's public URL or base path.
+func NewLocal(store storage.Storage, base string) *Local {
+	return &Local{store: store, base: strings.TrimSuffix(base, "/")}
+}
+
+// Shorten implements Shortener. A paste keeps the code it was given first.
+func (l *Local) Shorten(ctx context.Context, pasteID, longURL string) (string, error) {
+	code, err := l.store.GetValue(ctx, storage.NamespaceShortLink, pasteKeyPrefix+pasteID)
+	if err != nil {
+		return "", err
+	}
+	if code == "" {
+		if code, err = l.newCode(ctx, pasteID); err != nil {
+			return "", err
+		}
+	}
+	return l.base + "/s/" + code, nil
+}
+
+// newCode stores an unused code for a paste.
+func (l *Local) newCode(ctx context.Context, pasteID string) (string, error) {
+	for i := 0; i < maxCodeAttempts; i++ {
+		code, err := randomCode()
+		if err != nil {
+			return "", err
+		}
+		if taken, err := l.store.GetValue(ctx, storage.NamespaceShortLink, code); err != nil {
+			return "", err
+		} else if taken != "" {
+			continue
+		}
+		if err := l.store.SetValue(ctx, storage.NamespaceShortLink, code, pasteID); err != nil {
+			return "", err
+		}
+		if err := l.store.SetValue(ctx, storage.NamespaceShortLink, pasteKeyPrefix+pasteID, code); err != nil {
+			return "", err
+		}
+		return code, nil
+	}
+	return "", errNoCode
+}
+
+// Resolve returns the ID of the paste a code stands for, or "" if the code
+// is unknown.
+func (l *Local) Resolve(ctx context.Context, code string) (string, error) {
+	if !ValidCode(code) {
+		return "", nil
+	}
+	return l.store.GetValue(ctx, storage.NamespaceShortLink, code)
+}
+
+// Forget drops a code whose paste is gone, so it can be handed out again.
+func (l *Local) Forget(ctx context.Context, code string) error {
+	pasteID, err := l.Resolve(ctx, code)
+	if err != nil || pasteID == "" {
+		return err
+	}
+	// Storage has no deletion of values; empty ones read as missing
+	if err := l.store.SetValue(ctx, storage.NamespaceShortLink, code, ""); err != nil {
+		return err
+	}
+	return l.store.SetValue(ctx, storage.NamespaceShortLink, pasteKeyPrefix+pasteID, "")
+}
+
+// ValidCode reports whether s has the form of a local short code.
+func ValidCode(s string) bool {
+	if len(s) != CodeLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(codeAlphabet, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// randomCode returns a random short code.
+func randomCode() (string, error) {
+	b := make([]byte, CodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// 256 isn't a multiple of 62, so the first 8 characters are slightly
+	// more likely; that costs a fraction of a bit of the code's entropy
+	for i := range b {
+		b[i] = codeAlphabet[int(b[i])%len(codeAlphabet)]
+	}
+	return string(b), nil
+}
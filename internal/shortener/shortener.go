@@ -0,0 +1,139 @@
+// Package shortener shortens the URLs of newly created pastes, as
+// PrivateBin's urlshortener option does. Paste URLs are long: a 16 character
+// ID, then a key of over 40 characters in the fragment. Shorteners only get
+// the part before the fragment; the key stays with the client, which
+// appends it to the short link. Browsers carry a fragment over redirects
+// that don't set their own, so the short link still opens the paste.
+//
+// Providers are the local shortener, which keeps short codes in this
+// instance's storage, a YOURLS instance, and any service answering a GET
+// request with the short URL as plain text.
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+// requestTimeout bounds a request to an external shortener. Paste creation
+// waits for it, so it is kept short.
+const requestTimeout = 5 * time.Second
+
+// maxResponseSize bounds a response read from an external shortener.
+const maxResponseSize = 64 << 10
+
+// Shortener shortens the URL of a newly created paste.
+type Shortener interface {
+	// Shorten returns a short URL for the paste with the given ID, whose
+	// full URL without the key is longURL.
+	Shorten(ctx context.Context, pasteID, longURL string) (string, error)
+}
+
+// New returns the shortener configured in [main], or nil if none is. The
+// configuration must have been validated.
+func New(cfg config.MainConfig, store storage.Storage) Shortener {
+	client := &http.Client{Timeout: requestTimeout}
+	switch cfg.URLShortener {
+	case config.URLShortenerLocal:
+		base := cfg.BasePath
+		if cfg.PublicURL != "" {
+			base = cfg.PublicURL
+		}
+		return NewLocal(store, base)
+	case config.URLShortenerYOURLS:
+		return &YOURLS{APIURL: cfg.URLShortenerURL, Signature: cfg.URLShortenerSignature, Client: client}
+	case config.URLShortenerGet:
+		return &Get{Template: cfg.URLShortenerURL, Client: client}
+	default:
+		return nil
+	}
+}
+
+// YOURLS shortens URLs with a YOURLS instance's API.
+type YOURLS struct {
+	APIURL    string // yourls-api.php
+	Signature string // Passwordless API token
+	Client    *http.Client
+}
+
+// yourlsResponse is the part of a shorturl response YOURLS shortens with.
+// A URL shortened before is reported as a failure that still carries the
+// existing short URL.
+type yourlsResponse struct {
+	ShortURL string `json:"shorturl"`
+	Message  string `json:"message"`
+}
+
+// Shorten implements Shortener.
+func (y *YOURLS) Shorten(ctx context.Context, pasteID, longURL string) (string, error) {
+	query := url.Values{
+		"signature": {y.Signature},
+		"action":    {"shorturl"},
+		"format":    {"json"},
+		"url":       {longURL},
+	}
+	body, err := fetch(ctx, y.Client, y.APIURL+"?"+query.Encode())
+	if err != nil {
+		return "", fmt.Errorf("yourls: %w", err)
+	}
+	var response yourlsResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("yourls: invalid response: %w", err)
+	}
+	if response.ShortURL == "" {
+		return "", fmt.Errorf("yourls: %s", response.Message)
+	}
+	return response.ShortURL, nil
+}
+
+// Get shortens URLs with a GET request to a URL template, reading the
+// short URL from the plain text response.
+type Get struct {
+	Template string // Request URL with {url} where the escaped URL goes
+	Client   *http.Client
+}
+
+// Shorten implements Shortener.
+func (g *Get) Shorten(ctx context.Context, pasteID, longURL string) (string, error) {
+	body, err := fetch(ctx, g.Client, strings.ReplaceAll(g.Template, "{url}", url.QueryEscape(longURL)))
+	if err != nil {
+		return "", err
+	}
+	short := strings.TrimSpace(string(body))
+	if u, err := url.Parse(short); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("response is not a URL: %.100q", short)
+	}
+	return short, nil
+}
+
+// fetch sends a GET request and returns the body of a 2xx response.
+func fetch(ctx context.Context, client *http.Client, target string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Errors quote the request URL, which may carry the signature
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+}
@@ -0,0 +1,115 @@
+package shortener
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/storage"
+)
+
+const longURL = "https://paste.example.com/?abcdef1234567890"
+
+func TestNew(t *testing.T) {
+	store := storage.NewMock()
+	assert.Nil(t, New(config.MainConfig{}, store))
+	assert.IsType(t, &Local{}, New(config.MainConfig{URLShortener: config.URLShortenerLocal}, store))
+	assert.IsType(t, &YOURLS{}, New(config.MainConfig{URLShortener: config.URLShortenerYOURLS}, store))
+	assert.IsType(t, &Get{}, New(config.MainConfig{URLShortener: config.URLShortenerGet}, store))
+}
+
+func TestYOURLS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("signature") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"message": "Please log in"})
+			return
+		}
+		assert.Equal(t, "shorturl", q.Get("action"))
+		assert.Equal(t, "json", q.Get("format"))
+		assert.Equal(t, longURL, q.Get("url"))
+		// Already shortened URLs come back as failures with the short URL
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "fail", "code": "error:url", "shorturl": "https://sho.rt/ab",
+		})
+	}))
+	defer srv.Close()
+
+	y := &YOURLS{APIURL: srv.URL + "/yourls-api.php", Signature: "secret", Client: srv.Client()}
+	short, err := y.Shorten(context.Background(), "abcdef1234567890", longURL)
+	require.NoError(t, err)
+	assert.Equal(t, "https://sho.rt/ab", short)
+
+	y.Signature = "wrong"
+	_, err = y.Shorten(context.Background(), "abcdef1234567890", longURL)
+	assert.ErrorContains(t, err, "403")
+}
+
+func TestYOURLS_ErrorsOmitSignature(t *testing.T) {
+	y := &YOURLS{APIURL: "http://127.0.0.1:1/yourls-api.php", Signature: "secret", Client: http.DefaultClient}
+	_, err := y.Shorten(context.Background(), "abcdef1234567890", longURL)
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+}
+
+func TestGet(t *testing.T) {
+	response := "https://sho.rt/xyz\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, longURL, r.URL.Query().Get("link"))
+		w.Write([]byte(response))
+	}))
+	defer srv.Close()
+
+	g := &Get{Template: srv.URL + "/api?link={url}", Client: srv.Client()}
+	short, err := g.Shorten(context.Background(), "abcdef1234567890", longURL)
+	require.NoError(t, err)
+	assert.Equal(t, "https://sho.rt/xyz", short)
+
+	response = "<html>rate limited</html>"
+	_, err = g.Shorten(context.Background(), "abcdef1234567890", longURL)
+	assert.ErrorContains(t, err, "not a URL")
+}
+
+func TestLocal(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMock()
+	l := NewLocal(store, "https://paste.example.com/")
+
+	short, err := l.Shorten(context.Background(), "abcdef1234567890", longURL)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(short, "https://paste.example.com/s/"), short)
+	code := strings.TrimPrefix(short, "https://paste.example.com/s/")
+	assert.True(t, ValidCode(code))
+
+	// Shortening a paste again returns its code
+	again, err := l.Shorten(context.Background(), "abcdef1234567890", longURL)
+	require.NoError(t, err)
+	assert.Equal(t, short, again)
+
+	pasteID, err := l.Resolve(ctx, code)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef1234567890", pasteID)
+
+	require.NoError(t, l.Forget(ctx, code))
+	pasteID, err = l.Resolve(ctx, code)
+	require.NoError(t, err)
+	assert.Empty(t, pasteID)
+	other, err := l.Shorten(context.Background(), "abcdef1234567890", longURL)
+	require.NoError(t, err)
+	assert.NotEqual(t, short, other)
+}
+
+func TestValidCode(t *testing.T) {
+	assert.True(t, ValidCode("aZ09xyQ"))
+	assert.False(t, ValidCode("aZ09xy"))
+	assert.False(t, ValidCode("paste:a"))
+	assert.False(t, ValidCode("../../x"))
+}
@@ -0,0 +1,274 @@
+// Package storage provides portable archives of a backend's contents.
+// ExportArchive writes everything a backend stores to a tar archive that
+// ImportArchive loads into any backend, for backups and for moving between
+// hosts without access to both backends at once (which Migrate needs).
+//
+// Archive layout:
+//
+//	manifest.json            <- format version and creation time
+//	pastes/f468483c313401e8.json
+//	                         <- paste with its comments and read state
+//	values.json              <- key-value entries, including the server salt
+//
+// Paste records are in the filesystem backend's format, with attachments
+// inline, so an archive can also be read without FlashPaper.
+package storage
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// archiveVersion is the version of the archive format ExportArchive
+// writes.
+const archiveVersion = 1
+
+// archiveManifest describes an archive.
+type archiveManifest struct {
+	Version int   `json:"version"`
+	Created int64 `json:"created"`
+}
+
+// archivedPaste is a paste record of an archive.
+type archivedPaste struct {
+	pasteStorageData
+	Read     bool             `json:"read,omitempty"`
+	Comments []*model.Comment `json:"comments,omitempty"`
+}
+
+// archivedValue is a key-value entry of an archive.
+type archivedValue struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+}
+
+// ExportArchive writes everything stored in src to w as a tar archive.
+// src must implement Exporter. Expired pastes are left out; reading them
+// removes them from src as it would when serving. Stats count as for
+// Migrate, with Existing unused.
+func ExportArchive(ctx context.Context, w io.Writer, src Storage) (MigrateStats, error) {
+	var stats MigrateStats
+
+	exporter, ok := src.(Exporter)
+	if !ok {
+		return stats, errors.New("source storage can't enumerate its contents")
+	}
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	if err := writeArchiveJSON(tw, "manifest.json", now, archiveManifest{Version: archiveVersion, Created: now.Unix()}); err != nil {
+		return stats, err
+	}
+
+	ids, err := exporter.PasteIDs(ctx)
+	if err != nil {
+		return stats, err
+	}
+	for _, id := range ids {
+		record, err := archivePaste(ctx, src, id)
+		if errors.Is(err, model.ErrPasteNotFound) || errors.Is(err, model.ErrPasteExpired) {
+			stats.Skipped++
+			continue
+		}
+		if err != nil {
+			return stats, fmt.Errorf("paste %s: %w", id, err)
+		}
+		if err := writeArchiveJSON(tw, "pastes/"+id+".json", now, record); err != nil {
+			return stats, err
+		}
+		stats.Pastes++
+		stats.Comments += len(record.Comments)
+	}
+
+	values := []archivedValue{}
+	err = exporter.Values(ctx, func(namespace, key, value string) error {
+		values = append(values, archivedValue{Namespace: namespace, Key: key, Value: value})
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	if err := writeArchiveJSON(tw, "values.json", now, values); err != nil {
+		return stats, err
+	}
+	stats.Values = len(values)
+	return stats, tw.Close()
+}
+
+// archivePaste reads a paste of src with everything belonging to it.
+func archivePaste(ctx context.Context, src Storage, id string) (*archivedPaste, error) {
+	paste, err := src.ReadPaste(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Attachments stored apart go inline
+	attachments := paste.Attachments
+	if n := len(paste.Meta.AttachmentSizes); n > 0 {
+		attachments = make(model.StringList, n)
+		for i := range attachments {
+			r, err := OpenAttachment(ctx, src, id, paste, i)
+			if err != nil {
+				return nil, fmt.Errorf("attachment %d: %w", i, err)
+			}
+			data, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return nil, fmt.Errorf("attachment %d: %w", i, err)
+			}
+			attachments[i] = string(data)
+		}
+		paste.Meta.AttachmentSizes = nil
+	}
+
+	record := &archivedPaste{pasteStorageData: pasteStorageData{
+		Data:           paste.Data,
+		AttachmentName: paste.AttachmentNames,
+		Attachment:     attachments,
+		AData:          paste.AData,
+		Version:        paste.Version,
+		Meta:           storedMeta(paste.Meta),
+	}}
+	err = src.IterateComments(ctx, id, func(c *model.Comment) error {
+		record.Comments = append(record.Comments, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := src.GetReadReceipt(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	record.Read = receipt.IsRead()
+	return record, nil
+}
+
+// writeArchiveJSON adds a JSON file to tw.
+func writeArchiveJSON(tw *tar.Writer, name string, modTime time.Time, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// ImportArchive loads an archive written by ExportArchive into dst. Like
+// Migrate it leaves pastes already at dst alone, so an interrupted import
+// can be run again, and read receipts record the time of the import.
+// Pastes that expired since the export are skipped.
+func ImportArchive(ctx context.Context, dst Storage, r io.Reader) (MigrateStats, error) {
+	var stats MigrateStats
+	tr := tar.NewReader(r)
+	manifest := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("reading archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		switch name := header.Name; {
+		case name == "manifest.json":
+			var m archiveManifest
+			if err := json.NewDecoder(tr).Decode(&m); err != nil {
+				return stats, fmt.Errorf("reading manifest: %w", err)
+			}
+			if m.Version != archiveVersion {
+				return stats, fmt.Errorf("unsupported archive version %d", m.Version)
+			}
+			manifest = true
+		case !manifest:
+			return stats, errors.New("not a FlashPaper archive: no manifest")
+		case name == "values.json":
+			var values []archivedValue
+			if err := json.NewDecoder(tr).Decode(&values); err != nil {
+				return stats, fmt.Errorf("reading values: %w", err)
+			}
+			for _, v := range values {
+				if err := dst.SetValue(ctx, v.Namespace, v.Key, v.Value); err != nil {
+					return stats, fmt.Errorf("value %s/%s: %w", v.Namespace, v.Key, err)
+				}
+				stats.Values++
+			}
+		case strings.HasPrefix(name, "pastes/") && path.Ext(name) == ".json":
+			id := strings.TrimSuffix(path.Base(name), ".json")
+			if !util.ValidateID(id) {
+				return stats, fmt.Errorf("invalid paste file %q", name)
+			}
+			var record archivedPaste
+			if err := json.NewDecoder(tr).Decode(&record); err != nil {
+				return stats, fmt.Errorf("paste %s: %w", id, err)
+			}
+			if err := importArchivedPaste(ctx, dst, id, &record, &stats); err != nil {
+				return stats, fmt.Errorf("paste %s: %w", id, err)
+			}
+		}
+	}
+	if !manifest {
+		return stats, errors.New("not a FlashPaper archive: no manifest")
+	}
+	return stats, nil
+}
+
+// importArchivedPaste stores one paste record. Comments are stored even if
+// the paste already exists, since a previous run may have stopped partway
+// through them.
+func importArchivedPaste(ctx context.Context, dst Storage, id string, record *archivedPaste, stats *MigrateStats) error {
+	paste := record.paste(id)
+	if paste.IsExpiredAt(time.Now()) {
+		stats.Skipped++
+		return nil
+	}
+	switch err := dst.CreatePaste(ctx, id, paste); {
+	case errors.Is(err, model.ErrPasteExists):
+		stats.Existing++
+	case err != nil:
+		return err
+	default:
+		stats.Pastes++
+	}
+
+	for _, c := range record.Comments {
+		// IDs end up in file names
+		if !util.ValidateID(c.ID) || !util.ValidateID(c.ParentID) {
+			return fmt.Errorf("invalid comment ID %q", c.ID)
+		}
+		c.PasteID = id
+		err := dst.CreateComment(ctx, id, c.ParentID, c.ID, c)
+		if errors.Is(err, model.ErrCommentExists) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("comment %s: %w", c.ID, err)
+		}
+		stats.Comments++
+	}
+
+	if record.Read {
+		if _, err := dst.MarkRead(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
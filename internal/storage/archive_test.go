@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+func TestArchive_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+
+	pasteID := "abcdef1234567890"
+	n, err := src.WriteAttachment(ctx, pasteID, 0, strings.NewReader("attachment"))
+	require.NoError(t, err)
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	paste.AttachmentNames = model.StringList{"name"}
+	paste.Meta.AttachmentSizes = []int64{n}
+	paste.Meta.OpenDiscussion = true
+	paste.Meta.Salt = "salt"
+	paste.SetExpiration(time.Hour)
+	require.NoError(t, src.CreatePaste(ctx, pasteID, paste))
+	comment := model.NewComment(pasteID)
+	comment.Data = "comment"
+	require.NoError(t, src.CreateComment(ctx, pasteID, pasteID, "1111111111111111", comment))
+	reply := model.NewComment(pasteID)
+	reply.Data = "reply"
+	require.NoError(t, src.CreateComment(ctx, pasteID, "1111111111111111", "2222222222222222", reply))
+	_, err = src.MarkRead(ctx, pasteID)
+	require.NoError(t, err)
+	require.NoError(t, src.SetValue(ctx, NamespaceSalt, "server", "serversalt"))
+
+	expired := model.NewPaste()
+	expired.Data = "expired"
+	expired.Meta.ExpireDate = 1000
+	require.NoError(t, src.CreatePaste(ctx, "0123456789abcdef", expired))
+
+	var archive bytes.Buffer
+	stats, err := ExportArchive(ctx, &archive, src)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateStats{Pastes: 1, Skipped: 1, Comments: 2, Values: 1}, stats)
+
+	dst := NewMock()
+	stats, err = ImportArchive(ctx, dst, bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, MigrateStats{Pastes: 1, Comments: 2, Values: 1}, stats)
+
+	read, err := dst.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", read.Data)
+	assert.Equal(t, model.StringList{"attachment"}, read.Attachments)
+	assert.Equal(t, model.StringList{"name"}, read.AttachmentNames)
+	assert.Equal(t, "salt", read.Meta.Salt)
+	assert.Equal(t, paste.Meta.ExpireDate, read.Meta.ExpireDate)
+	assert.True(t, read.Meta.OpenDiscussion)
+	comments, err := dst.ReadComments(ctx, pasteID)
+	require.NoError(t, err)
+	require.Len(t, comments, 2)
+	assert.Equal(t, "1111111111111111", comments[1].ParentID)
+	receipt, err := dst.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.True(t, receipt.IsRead())
+	salt, err := dst.GetValue(ctx, NamespaceSalt, "server")
+	require.NoError(t, err)
+	assert.Equal(t, "serversalt", salt)
+
+	// Importing again copies nothing twice
+	stats, err = ImportArchive(ctx, dst, bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Existing)
+	assert.Zero(t, stats.Comments)
+}
+
+// tarOf returns a tar archive of the given name/content pairs.
+func tarOf(t *testing.T, files ...string) *bytes.Buffer {
+	t.Helper()
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for i := 0; i < len(files); i += 2 {
+		require.NoError(t, writeArchiveJSON(tw, files[i], time.Now(), rawJSON(files[i+1])))
+	}
+	require.NoError(t, tw.Close())
+	return &b
+}
+
+// rawJSON is JSON written as it is.
+type rawJSON string
+
+func (r rawJSON) MarshalJSON() ([]byte, error) { return []byte(r), nil }
+
+func TestImportArchive_Invalid(t *testing.T) {
+	ctx := context.Background()
+	manifest := `{"version":1,"created":0}`
+	for name, archive := range map[string]*bytes.Buffer{
+		"no manifest":   tarOf(t, "values.json", `[]`),
+		"empty":         tarOf(t),
+		"version":       tarOf(t, "manifest.json", `{"version":99}`),
+		"paste file":    tarOf(t, "manifest.json", manifest, "pastes/../../etc.json", `{}`),
+		"comment ID":    tarOf(t, "manifest.json", manifest, "pastes/abcdef1234567890.json", `{"data":"x","v":2,"meta":{},"comments":[{"id":"../x","parentid":"abcdef1234567890","data":"x"}]}`),
+		"not a tarball": bytes.NewBufferString("plain text"),
+	} {
+		_, err := ImportArchive(ctx, NewMock(), archive)
+		assert.Error(t, err, name)
+	}
+}
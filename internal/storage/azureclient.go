@@ -0,0 +1,302 @@
+// Package storage provides a minimal client for the Azure Blob Storage REST
+// API, covering the blob operations the Blob backend needs. Requests are
+// signed with the storage account's Shared Key, which Azure and the
+// Azurite emulator both accept.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// azureVersion is the REST API version requests ask for.
+const azureVersion = "2021-08-06"
+
+// azureClient talks to one blob container.
+type azureClient struct {
+	endpoint  string // Account's blob endpoint, without a trailing slash
+	container string
+	account   string
+	key       []byte // Decoded account key
+	http      *http.Client
+	now       func() time.Time
+}
+
+// newAzureClient creates a client for container. The account name and key
+// come from [model] access_key and secret_key, or AZURE_STORAGE_ACCOUNT and
+// AZURE_STORAGE_KEY. [model] endpoint replaces the account's endpoint,
+// e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite.
+func newAzureClient(container string, m config.ModelConfig) (*azureClient, error) {
+	account := envOr(m.AccessKey, "AZURE_STORAGE_ACCOUNT")
+	secret := envOr(m.SecretKey, "AZURE_STORAGE_KEY")
+	if account == "" || secret == "" {
+		return nil, errors.New("no Azure credentials: set [model] access_key and secret_key, or AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_KEY")
+	}
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("azure account key is not base64: %w", err)
+	}
+
+	endpoint := m.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+	return &azureClient{
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		container: container,
+		account:   account,
+		key:       key,
+		http:      &http.Client{Timeout: blobTimeout},
+		now:       time.Now,
+	}, nil
+}
+
+// do sends a signed request for a blob ("" for the container itself) and
+// returns the response if it succeeded. 404 maps to errObjectNotFound; 412,
+// and 409 for a blob that already exists, to errPreconditionFailed.
+func (c *azureClient) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	rawURL := c.endpoint + "/" + uriEncode(c.container, true)
+	if key != "" {
+		rawURL += "/" + uriEncode(key, false)
+	}
+	if len(query) > 0 {
+		rawURL += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	signSharedKey(req, c.account, c.key, c.now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errObjectNotFound
+	case resp.StatusCode == http.StatusPreconditionFailed,
+		resp.StatusCode == http.StatusConflict && resp.Header.Get("X-Ms-Error-Code") == "BlobAlreadyExists":
+		return nil, errPreconditionFailed
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return nil, fmt.Errorf("azure %s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(msg))
+}
+
+// putObject uploads a block blob in a single request. An exclusive write
+// is conditional on If-None-Match: *.
+func (c *azureClient) putObject(ctx context.Context, key string, body []byte, exclusive bool) error {
+	header := http.Header{}
+	header.Set("X-Ms-Blob-Type", "BlockBlob")
+	if exclusive {
+		header.Set("If-None-Match", "*")
+	}
+	resp, err := c.do(ctx, http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *azureClient) getObject(ctx context.Context, key string) ([]byte, error) {
+	body, err := c.openObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// getVersion reads a blob and its ETag.
+func (c *azureClient) getVersion(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.Header.Get("ETag"), err
+}
+
+// replaceObject uploads a block blob on the condition If-Match: version.
+func (c *azureClient) replaceObject(ctx context.Context, key string, body []byte, version string) error {
+	header := http.Header{}
+	header.Set("X-Ms-Blob-Type", "BlockBlob")
+	header.Set("If-Match", version)
+	resp, err := c.do(ctx, http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// deleteVersion removes a blob on the condition If-Match: version.
+func (c *azureClient) deleteVersion(ctx context.Context, key, version string) error {
+	header := http.Header{}
+	header.Set("If-Match", version)
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *azureClient) openObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *azureClient) headObject(ctx context.Context, key string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+func (c *azureClient) deleteObject(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// azureListing is the part of a List Blobs response we use.
+type azureListing struct {
+	Blobs []struct {
+		Name string `xml:"Name"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func (c *azureClient) listObjects(ctx context.Context, prefix string, fn func(key string) bool) error {
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+	for {
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var listing azureListing
+		err = xml.NewDecoder(resp.Body).Decode(&listing)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding azure listing: %w", err)
+		}
+
+		for _, blob := range listing.Blobs {
+			if !fn(blob.Name) {
+				return nil
+			}
+		}
+		if listing.NextMarker == "" {
+			return nil
+		}
+		query.Set("marker", listing.NextMarker)
+	}
+}
+
+func (c *azureClient) headBucket(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodHead, "", url.Values{"restype": {"container"}}, nil, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return fmt.Errorf("azure container %q not found", c.container)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *azureClient) close() {
+	c.http.CloseIdleConnections()
+}
+
+// signSharedKey signs req with an Azure Storage account key, adding the
+// x-ms-date and x-ms-version headers it covers.
+func signSharedKey(req *http.Request, account string, key []byte, now time.Time) {
+	req.Header.Set("X-Ms-Date", now.UTC().Format(http.TimeFormat))
+	req.Header.Set("X-Ms-Version", azureVersion)
+
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var msHeaders []string
+	for name, values := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(msHeaders)
+
+	resource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, superseded by x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(msHeaders, "\n"),
+		resource,
+	}, "\n")
+
+	signature := base64.StdEncoding.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "SharedKey "+account+":"+signature)
+}
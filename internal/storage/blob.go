@@ -0,0 +1,1159 @@
+// Package storage provides the object storage implementation of the Storage
+// interface, for deployments without persistent volumes. The S3 class keeps
+// pastes in an S3-compatible bucket (AWS S3, MinIO, Backblaze B2); the Blob
+// class in a bucket named by URL, whose scheme picks the service:
+// s3://bucket, gs://bucket for Google Cloud Storage, or azblob://container
+// for Azure Blob Storage. Both go through the same code, on a client for
+// the service's REST API (see objectStore).
+//
+// Object layout of the S3 class, below the configured prefix:
+//
+//	pastes/f468483c313401e8                     <- paste (same JSON as Filesystem)
+//	receipts/f468483c313401e8                   <- first-read timestamp (if read)
+//	attachments/f468483c313401e8/0              <- attachments (if stored separately)
+//	comments/f468483c313401e8/c1.p1.json        <- comment
+//	expiry/1700000000/f468483c313401e8          <- empty expiration index entry
+//	values/traffic/<key>                        <- key-value storage
+//
+// The Blob class keeps a paste's objects together, named as the Filesystem
+// backend names its files:
+//
+//	f4/68/f468483c313401e8                      <- paste
+//	f4/68/f468483c313401e8.read                 <- first-read timestamp (if read)
+//	f4/68/f468483c313401e8.attachment.0         <- attachments (if stored separately)
+//	f4/68/f468483c313401e8.discussion/c1.p1.json <- comment
+//	expiry/1700000000/f468483c313401e8          <- as above
+//	values/traffic/<key>                        <- as above
+//
+// Expiration index keys sort by time, so purging lists only expired entries
+// instead of reading every paste.
+//
+// Paste creation, first reads, rewrites, and burn-after-reading deletes
+// use conditional writes, so they stay exclusive across instances sharing
+// a bucket on services that support them. Comment limits are enforced per
+// instance.
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// blobTimeout bounds each request to the service.
+const blobTimeout = 30 * time.Second
+
+// blobRetries is how often a conditional rewrite or delete that lost a
+// race with another instance is retried. Each round has a winner, so this
+// covers that many instances contending for one paste.
+const blobRetries = 10
+
+// Errors reported by object store clients for specific response statuses.
+var (
+	errObjectNotFound     = errors.New("object not found")
+	errPreconditionFailed = errors.New("precondition failed")
+)
+
+// objectStore is a bucket on an object storage service. Keys are plain
+// strings; "/" has no meaning beyond sorting.
+type objectStore interface {
+	// putObject stores an object. With exclusive set, the write is
+	// conditional on the object not existing yet and fails with
+	// errPreconditionFailed otherwise.
+	putObject(ctx context.Context, key string, body []byte, exclusive bool) error
+
+	// getObject reads an object, failing with errObjectNotFound if there
+	// is none.
+	getObject(ctx context.Context, key string) ([]byte, error)
+
+	// getVersion reads an object along with its version (the ETag, or the
+	// generation on Cloud Storage), failing with errObjectNotFound if
+	// there is none.
+	getVersion(ctx context.Context, key string) ([]byte, string, error)
+
+	// replaceObject overwrites an object if it is still at version. It
+	// fails with errPreconditionFailed if the object has been changed
+	// since, and errObjectNotFound if it has been deleted (Cloud Storage
+	// reports errPreconditionFailed for that too).
+	replaceObject(ctx context.Context, key string, body []byte, version string) error
+
+	// deleteVersion removes an object if it is still at version, failing
+	// as replaceObject does otherwise.
+	deleteVersion(ctx context.Context, key, version string) error
+
+	// openObject opens an object for streaming. The body must be closed.
+	openObject(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// headObject reports whether an object exists.
+	headObject(ctx context.Context, key string) (bool, error)
+
+	// deleteObject removes an object; a missing one is not an error.
+	deleteObject(ctx context.Context, key string) error
+
+	// listObjects calls fn for each key under prefix, in lexicographic
+	// order, until fn returns false.
+	listObjects(ctx context.Context, prefix string, fn func(key string) bool) error
+
+	// headBucket checks that the bucket exists and the credentials can
+	// use it.
+	headBucket(ctx context.Context) error
+
+	// close releases idle connections.
+	close()
+}
+
+// Blob implements the Storage interface on a bucket.
+type Blob struct {
+	client       objectStore
+	prefix       string
+	nested       bool          // Filesystem's layout (Blob class) instead of S3's
+	commentLimit int           // Max comments per paste (0 = unlimited)
+	timeout      time.Duration // Bound on each operation (0 = none)
+	mu           sync.Mutex    // Serializes check-then-write sequences
+	clock        clock.Clock   // Expiration and receipt times (see SetClock)
+}
+
+// NewS3 creates a new S3 storage backend.
+// Credentials come from the config, the standard AWS environment variables,
+// or the ECS task role, in that order.
+func NewS3(cfg *config.Config) (*Blob, error) {
+	client, err := newS3Client(cfg.Model.Bucket, cfg.Model)
+	if err != nil {
+		return nil, err
+	}
+	return newBlob(client, cfg, false), nil
+}
+
+// NewBlob creates a new Blob storage backend on the bucket [model] bucket
+// names by URL. [model] endpoint, access_key, and secret_key apply to
+// whichever service the URL's scheme picks:
+//
+//	s3://bucket       S3 or compatible; region and path_style apply too
+//	gs://bucket       Google Cloud Storage
+//	azblob://name     Azure Blob Storage container
+func NewBlob(cfg *config.Config) (*Blob, error) {
+	u, err := url.Parse(cfg.Model.Bucket)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("model bucket must be a URL such as gs://name, got %q", cfg.Model.Bucket)
+	}
+
+	var client objectStore
+	switch u.Scheme {
+	case "s3":
+		client, err = newS3Client(u.Host, cfg.Model)
+	case "gs":
+		client, err = newGCSClient(u.Host, cfg.Model)
+	case "azblob":
+		client, err = newAzureClient(u.Host, cfg.Model)
+	default:
+		return nil, fmt.Errorf("unsupported bucket URL scheme %q (want s3, gs, or azblob)", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBlob(client, cfg, true), nil
+}
+
+// newBlob creates a backend on client.
+func newBlob(client objectStore, cfg *config.Config, nested bool) *Blob {
+	prefix := cfg.Model.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Blob{
+		client:       client,
+		prefix:       prefix,
+		nested:       nested,
+		commentLimit: cfg.Main.CommentLimit,
+		timeout:      time.Duration(cfg.Model.QueryTimeout) * time.Second,
+		clock:        clock.System,
+	}
+}
+
+// envOr returns value, or the environment variable name if value is empty.
+func envOr(value, name string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// SetClock sets the clock expiration is judged by.
+func (s *Blob) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// ctx returns the context for one storage operation within parent,
+// bounded by [model] query_timeout.
+func (s *Blob) ctx(parent context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, s.timeout)
+}
+
+// pastesPrefix returns the key prefix all pastes are under.
+func (s *Blob) pastesPrefix() string {
+	if s.nested {
+		return s.prefix
+	}
+	return s.prefix + "pastes/"
+}
+
+// pasteKey returns the object key of a paste: in the nested layout, below
+// two levels named by the ID's first four characters, like pastePath.
+func (s *Blob) pasteKey(id string) string {
+	if s.nested && len(id) >= 4 {
+		return s.prefix + id[:2] + "/" + id[2:4] + "/" + id
+	}
+	return s.pastesPrefix() + id
+}
+
+// pasteID returns the ID of the paste stored at key, if key is a paste's.
+func (s *Blob) pasteID(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, s.pastesPrefix())
+	if !ok || rest == "" {
+		return "", false
+	}
+	if !s.nested {
+		return rest, true
+	}
+	// Receipts, attachments, comments, and the index share the prefix
+	id := path.Base(rest)
+	return id, util.ValidateID(id) && s.pasteKey(id) == key
+}
+
+// attachmentPrefix returns the key prefix of a paste's attachments.
+func (s *Blob) attachmentPrefix(id string) string {
+	if s.nested {
+		return s.pasteKey(id) + ".attachment."
+	}
+	return s.prefix + "attachments/" + id + "/"
+}
+
+// attachmentKey returns the object key of one of a paste's attachments.
+func (s *Blob) attachmentKey(id string, index int) string {
+	return s.attachmentPrefix(id) + strconv.Itoa(index)
+}
+
+// receiptKey returns the object key of a paste's read receipt.
+func (s *Blob) receiptKey(id string) string {
+	if s.nested {
+		return s.pasteKey(id) + ".read"
+	}
+	return s.prefix + "receipts/" + id
+}
+
+// discussionPrefix returns the key prefix of a paste's comments.
+func (s *Blob) discussionPrefix(id string) string {
+	if s.nested {
+		return s.pasteKey(id) + ".discussion/"
+	}
+	return s.prefix + "comments/" + id + "/"
+}
+
+// commentKey returns the object key of a comment.
+func (s *Blob) commentKey(pasteID, parentID, commentID string) string {
+	return s.discussionPrefix(pasteID) + commentID + "." + parentID + ".json"
+}
+
+// expiryPrefix returns the key prefix of the expiration index.
+func (s *Blob) expiryPrefix() string {
+	return s.prefix + "expiry/"
+}
+
+// expiryKey returns the expiration index key of a paste. Timestamps are
+// zero-padded so the keys sort chronologically.
+func (s *Blob) expiryKey(id string, expireDate int64) string {
+	return fmt.Sprintf("%s%010d/%s", s.expiryPrefix(), expireDate, id)
+}
+
+// valueKey returns the object key of a stored value.
+func (s *Blob) valueKey(namespace, key string) string {
+	return s.prefix + "values/" + namespace + "/" + key
+}
+
+// CreatePaste stores a new paste and its expiration index entry.
+func (s *Blob) CreatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.client.headObject(ctx, s.pasteKey(id))
+	if err != nil {
+		return fmt.Errorf("checking paste: %w", err)
+	}
+	if exists {
+		return model.ErrPasteExists
+	}
+
+	data, err := json.Marshal(pasteStorageData{
+		Data:           paste.Data,
+		AttachmentName: paste.AttachmentNames,
+		Attachment:     paste.Attachments,
+		AData:          paste.AData,
+		Version:        paste.Version,
+		Meta:           storedMeta(paste.Meta),
+	})
+	if err != nil {
+		return fmt.Errorf("serializing paste: %w", err)
+	}
+
+	err = s.client.putObject(ctx, s.pasteKey(id), data, true)
+	if errors.Is(err, errPreconditionFailed) {
+		return model.ErrPasteExists
+	}
+	if err != nil {
+		return fmt.Errorf("writing paste: %w", err)
+	}
+
+	// A paste missing from the index would never be purged, so roll it back
+	if expiresAt := paste.Meta.ExpiresAt(); expiresAt > 0 {
+		if err := s.client.putObject(ctx, s.expiryKey(id, expiresAt), nil, false); err != nil {
+			s.client.deleteObject(ctx, s.pasteKey(id))
+			return fmt.Errorf("writing expiration index: %w", err)
+		}
+	}
+	return nil
+}
+
+// ReadPaste retrieves a paste from the bucket.
+func (s *Blob) ReadPaste(ctx context.Context, id string) (*model.Paste, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	paste, err := s.loadPaste(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if paste.IsExpiredAt(s.clock.Now()) {
+		s.mu.Lock()
+		s.removePaste(ctx, id, paste.Meta.ExpiresAt())
+		s.mu.Unlock()
+		return nil, model.ErrPasteExpired
+	}
+
+	return paste, nil
+}
+
+// loadPaste reads a paste whether or not it has expired.
+func (s *Blob) loadPaste(ctx context.Context, id string) (*model.Paste, error) {
+	data, err := s.client.getObject(ctx, s.pasteKey(id))
+	if errors.Is(err, errObjectNotFound) {
+		return nil, model.ErrPasteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading paste: %w", err)
+	}
+
+	var storageData pasteStorageData
+	if err := json.Unmarshal(data, &storageData); err != nil {
+		return nil, fmt.Errorf("deserializing paste: %w", err)
+	}
+	return storageData.paste(id), nil
+}
+
+// DeletePaste removes a paste with its comments, receipt, and index entry.
+func (s *Blob) DeletePaste(ctx context.Context, id string) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.client.getObject(ctx, s.pasteKey(id))
+	if errors.Is(err, errObjectNotFound) {
+		return model.ErrPasteNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("reading paste: %w", err)
+	}
+
+	// An unreadable paste has no usable expiration; its index entry, if
+	// any, is dropped by the next purge
+	var storageData pasteStorageData
+	_ = json.Unmarshal(data, &storageData)
+
+	return s.removePaste(ctx, id, storageData.Meta.ExpiresAt())
+}
+
+// ReadAndDeletePaste reads a paste and deletes it before anything else, so
+// no later read can get it. The delete is conditional on the version read,
+// so of instances sharing the bucket only one gets the paste; a paste
+// rewritten in between is read again.
+func (s *Blob) ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data []byte
+	for attempt := 0; ; attempt++ {
+		var version string
+		var err error
+		data, version, err = s.client.getVersion(ctx, s.pasteKey(id))
+		if errors.Is(err, errObjectNotFound) {
+			return nil, model.ErrPasteNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading paste: %w", err)
+		}
+
+		err = s.client.deleteVersion(ctx, s.pasteKey(id), version)
+		if errors.Is(err, errPreconditionFailed) && attempt < blobRetries {
+			continue
+		}
+		if errors.Is(err, errObjectNotFound) {
+			return nil, model.ErrPasteNotFound // Another instance got it
+		}
+		if err != nil {
+			return nil, fmt.Errorf("deleting paste: %w", err)
+		}
+		break
+	}
+
+	var storageData pasteStorageData
+	if err := json.Unmarshal(data, &storageData); err != nil {
+		return nil, fmt.Errorf("deserializing paste: %w", err)
+	}
+	paste := storageData.paste(id)
+
+	// Purging drops index entries whose paste is gone, should this fail
+	if err := s.removeRelated(ctx, id, paste.Meta.ExpiresAt()); err != nil {
+		return nil, err
+	}
+	if paste.IsExpiredAt(s.clock.Now()) {
+		if err := s.deleteAttachments(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, model.ErrPasteExpired
+	}
+	return paste, nil
+}
+
+// CountRead uses up one of a paste's reads. The count is rewritten
+// conditionally (see rewrite), so instances sharing the bucket count
+// together.
+func (s *Blob) CountRead(ctx context.Context, id string) (*model.Paste, error) {
+	return countRead(ctx, s, id, func(update func(meta *pasteMeta)) error {
+		return s.updateMeta(ctx, id, update)
+	})
+}
+
+// removePaste deletes a paste's objects, the paste itself last so a
+// failure part way leaves something that can be deleted again.
+// Caller must hold mu.
+func (s *Blob) removePaste(ctx context.Context, id string, expireDate int64) error {
+	if err := s.removeRelated(ctx, id, expireDate); err != nil {
+		return err
+	}
+	if err := s.deleteAttachments(ctx, id); err != nil {
+		return err
+	}
+	if err := s.client.deleteObject(ctx, s.pasteKey(id)); err != nil {
+		return fmt.Errorf("deleting paste: %w", err)
+	}
+	return nil
+}
+
+// removeRelated deletes a paste's comments, read receipt, and expiration
+// index entry. Caller must hold mu.
+func (s *Blob) removeRelated(ctx context.Context, id string, expireDate int64) error {
+	var comments []string
+	err := s.client.listObjects(ctx, s.discussionPrefix(id), func(key string) bool {
+		comments = append(comments, key)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("listing comments: %w", err)
+	}
+	for _, key := range comments {
+		if err := s.client.deleteObject(ctx, key); err != nil {
+			return fmt.Errorf("deleting comment: %w", err)
+		}
+	}
+
+	if err := s.client.deleteObject(ctx, s.receiptKey(id)); err != nil {
+		return fmt.Errorf("deleting read receipt: %w", err)
+	}
+	if expireDate > 0 {
+		if err := s.client.deleteObject(ctx, s.expiryKey(id, expireDate)); err != nil {
+			return fmt.Errorf("deleting expiration index: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetPinned rewrites a paste with its Pinned flag set or cleared, and
+// takes it out of or back into the expiration index.
+func (s *Blob) SetPinned(ctx context.Context, id string, pinned bool) error {
+	return s.updateMeta(ctx, id, func(meta *pasteMeta) { meta.Pinned = pinned })
+}
+
+// SetDisabled rewrites a paste with its Disabled flag set or cleared.
+func (s *Blob) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	return s.updateMeta(ctx, id, func(meta *pasteMeta) { meta.Disabled = disabled })
+}
+
+// SetExpireDate rewrites a paste with a new expiration date, and moves its
+// expiration index entry.
+func (s *Blob) SetExpireDate(ctx context.Context, id string, expireDate int64) error {
+	return s.updateMeta(ctx, id, func(meta *pasteMeta) { meta.ExpireDate = expireDate })
+}
+
+// UpdatePaste rewrites a paste with its content replaced.
+func (s *Blob) UpdatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	return s.rewrite(ctx, id, func(d *pasteStorageData) { d.setContent(paste) })
+}
+
+// updateMeta rewrites a paste with its meta changed by update.
+func (s *Blob) updateMeta(ctx context.Context, id string, update func(meta *pasteMeta)) error {
+	return s.rewrite(ctx, id, func(d *pasteStorageData) { update(&d.Meta) })
+}
+
+// rewrite rewrites a paste as changed by update, keeping the expiration
+// index in step. The old index entry is removed before the paste is
+// written and the new one added after, so a failure part way never leaves
+// an entry that would purge the paste early. The write is conditional on
+// the version read; a paste another instance rewrote in between is read
+// and updated again.
+func (s *Blob) rewrite(ctx context.Context, id string, update func(d *pasteStorageData)) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		data, version, err := s.client.getVersion(ctx, s.pasteKey(id))
+		if errors.Is(err, errObjectNotFound) {
+			return model.ErrPasteNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("reading paste: %w", err)
+		}
+
+		var storageData pasteStorageData
+		if err := json.Unmarshal(data, &storageData); err != nil {
+			return fmt.Errorf("deserializing paste: %w", err)
+		}
+		oldExpiry := storageData.Meta.ExpiresAt()
+		update(&storageData)
+		newExpiry := storageData.Meta.ExpiresAt()
+		if data, err = json.Marshal(storageData); err != nil {
+			return fmt.Errorf("serializing paste: %w", err)
+		}
+
+		if oldExpiry > 0 && oldExpiry != newExpiry {
+			if err := s.client.deleteObject(ctx, s.expiryKey(id, oldExpiry)); err != nil {
+				return fmt.Errorf("deleting expiration index: %w", err)
+			}
+		}
+		err = s.client.replaceObject(ctx, s.pasteKey(id), data, version)
+		if errors.Is(err, errPreconditionFailed) && attempt < blobRetries {
+			continue
+		}
+		if errors.Is(err, errObjectNotFound) {
+			return model.ErrPasteNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("writing paste: %w", err)
+		}
+		// After a retry the entry may be one an earlier attempt removed
+		if newExpiry > 0 && (newExpiry != oldExpiry || attempt > 0) {
+			if err := s.client.putObject(ctx, s.expiryKey(id, newExpiry), nil, false); err != nil {
+				return fmt.Errorf("writing expiration index: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// PasteExists checks if a paste exists in the bucket.
+func (s *Blob) PasteExists(ctx context.Context, id string) bool {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	exists, _ := s.client.headObject(ctx, s.pasteKey(id))
+	return exists
+}
+
+// CreateComment stores a new comment, enforcing the comment limit.
+func (s *Blob) CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.client.headObject(ctx, s.pasteKey(pasteID))
+	if err != nil {
+		return fmt.Errorf("checking paste: %w", err)
+	}
+	if !exists {
+		return model.ErrPasteNotFound
+	}
+
+	key := s.commentKey(pasteID, parentID, commentID)
+	exists, err = s.client.headObject(ctx, key)
+	if err != nil {
+		return fmt.Errorf("checking comment: %w", err)
+	}
+	if exists {
+		return model.ErrCommentExists
+	}
+
+	if s.commentLimit > 0 {
+		count, err := s.countComments(ctx, pasteID)
+		if err != nil {
+			return err
+		}
+		if count >= s.commentLimit {
+			return model.ErrCommentLimitReached
+		}
+	}
+
+	data, err := json.Marshal(commentStorageData{
+		Data:     comment.Data,
+		AData:    comment.AData,
+		Version:  comment.Version,
+		Vizhash:  comment.Vizhash,
+		PostDate: comment.Meta.PostDate,
+		Flagged:  comment.Meta.Flagged,
+	})
+	if err != nil {
+		return fmt.Errorf("serializing comment: %w", err)
+	}
+
+	err = s.client.putObject(ctx, key, data, true)
+	if errors.Is(err, errPreconditionFailed) {
+		return model.ErrCommentExists
+	}
+	if err != nil {
+		return fmt.Errorf("writing comment: %w", err)
+	}
+	return nil
+}
+
+// CountComments returns the number of comments on a paste.
+func (s *Blob) CountComments(ctx context.Context, pasteID string) (int, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	return s.countComments(ctx, pasteID)
+}
+
+// countComments counts a paste's comment objects.
+func (s *Blob) countComments(ctx context.Context, pasteID string) (int, error) {
+	count := 0
+	err := s.client.listObjects(ctx, s.discussionPrefix(pasteID), func(key string) bool {
+		if strings.HasSuffix(key, ".json") {
+			count++
+		}
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing comments: %w", err)
+	}
+	return count, nil
+}
+
+// ReadComments retrieves all comments for a paste.
+func (s *Blob) ReadComments(ctx context.Context, pasteID string) ([]*model.Comment, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	prefix := s.discussionPrefix(pasteID)
+	var keys []string
+	err := s.client.listObjects(ctx, prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing comments: %w", err)
+	}
+
+	var comments []*model.Comment
+	for _, key := range keys {
+		// Parse key: commentID.parentID.json
+		name, ok := strings.CutSuffix(strings.TrimPrefix(key, prefix), ".json")
+		if !ok {
+			continue
+		}
+		commentID, parentID, ok := strings.Cut(name, ".")
+		if !ok || strings.Contains(parentID, ".") {
+			continue
+		}
+
+		data, err := s.client.getObject(ctx, key)
+		if err != nil {
+			continue // Skip comments deleted since listing
+		}
+		var storageData commentStorageData
+		if err := json.Unmarshal(data, &storageData); err != nil {
+			continue // Skip invalid objects
+		}
+
+		comments = append(comments, &model.Comment{
+			ID:       commentID,
+			PasteID:  pasteID,
+			ParentID: parentID,
+			Data:     storageData.Data,
+			AData:    storageData.AData,
+			Version:  storageData.Version,
+			Vizhash:  storageData.Vizhash,
+			Meta: model.CommentMeta{
+				PostDate: storageData.PostDate,
+				Flagged:  storageData.Flagged,
+			},
+		})
+	}
+
+	// Sort by post date
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].Meta.PostDate < comments[j].Meta.PostDate
+	})
+
+	return comments, nil
+}
+
+// IterateComments calls fn for each comment on a paste, oldest first. As
+// listings are in key order rather than by date, the comments are all
+// read before fn is first called.
+func (s *Blob) IterateComments(ctx context.Context, pasteID string, fn func(*model.Comment) error) error {
+	comments, err := s.ReadComments(ctx, pasteID)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommentExists checks if a comment exists.
+func (s *Blob) CommentExists(ctx context.Context, pasteID, parentID, commentID string) bool {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	exists, _ := s.client.headObject(ctx, s.commentKey(pasteID, parentID, commentID))
+	return exists
+}
+
+// DeleteComment removes a comment object.
+// The comment's parent isn't known, so its key is found by listing the
+// discussion prefix; comments are counted by listing, so no counter changes.
+func (s *Blob) DeleteComment(ctx context.Context, pasteID, commentID string) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prefix := s.discussionPrefix(pasteID) + commentID + "."
+	var found string
+	err := s.client.listObjects(ctx, prefix, func(key string) bool {
+		if strings.HasSuffix(key, ".json") {
+			found = key
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("listing comments: %w", err)
+	}
+	if found == "" {
+		return model.ErrCommentNotFound
+	}
+
+	if err := s.client.deleteObject(ctx, found); err != nil {
+		return fmt.Errorf("deleting comment: %w", err)
+	}
+	return nil
+}
+
+// MarkRead records the first read of a paste.
+// The receipt is written conditionally, so only one reader wins.
+func (s *Blob) MarkRead(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	exists, err := s.client.headObject(ctx, s.pasteKey(id))
+	if err != nil {
+		return false, fmt.Errorf("checking paste: %w", err)
+	}
+	if !exists {
+		return false, model.ErrPasteNotFound
+	}
+
+	// Services without conditional writes ignore If-None-Match, so check too
+	read, err := s.client.headObject(ctx, s.receiptKey(id))
+	if err != nil {
+		return false, fmt.Errorf("checking read receipt: %w", err)
+	}
+	if read {
+		return false, nil
+	}
+
+	err = s.client.putObject(ctx, s.receiptKey(id), []byte(strconv.FormatInt(s.clock.Now().Unix(), 10)), true)
+	if errors.Is(err, errPreconditionFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("writing read receipt: %w", err)
+	}
+	return true, nil
+}
+
+// GetReadReceipt retrieves the read receipt for a paste.
+func (s *Blob) GetReadReceipt(ctx context.Context, id string) (*model.ReadReceipt, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	exists, err := s.client.headObject(ctx, s.pasteKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("checking paste: %w", err)
+	}
+	if !exists {
+		return nil, model.ErrPasteNotFound
+	}
+
+	receipt := &model.ReadReceipt{PasteID: id}
+	data, err := s.client.getObject(ctx, s.receiptKey(id))
+	if errors.Is(err, errObjectNotFound) {
+		return receipt, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading read receipt: %w", err)
+	}
+	if receipt.FirstRead, err = strconv.ParseInt(string(data), 10, 64); err != nil {
+		return nil, fmt.Errorf("parsing read receipt: %w", err)
+	}
+	return receipt, nil
+}
+
+// SetValue stores a key-value pair.
+func (s *Blob) SetValue(ctx context.Context, namespace, key, value string) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	if err := s.client.putObject(ctx, s.valueKey(namespace, key), []byte(value), false); err != nil {
+		return fmt.Errorf("writing value: %w", err)
+	}
+	return nil
+}
+
+// GetValue retrieves a stored value.
+func (s *Blob) GetValue(ctx context.Context, namespace, key string) (string, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	data, err := s.client.getObject(ctx, s.valueKey(namespace, key))
+	if errors.Is(err, errObjectNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading value: %w", err)
+	}
+	return string(data), nil
+}
+
+// WriteAttachment stores an attachment as an object of its own. Requests
+// are signed over their payload, so it is buffered before the upload; it
+// still stays out of the paste object that every read and purge loads.
+func (s *Blob) WriteAttachment(ctx context.Context, id string, index int, r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), fmt.Errorf("reading attachment: %w", err)
+	}
+
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+	if err := s.client.putObject(ctx, s.attachmentKey(id, index), data, false); err != nil {
+		return 0, fmt.Errorf("writing attachment: %w", err)
+	}
+	return int64(len(data)), nil
+}
+
+// OpenAttachment streams an attachment object. The operation timeout
+// covers the whole download.
+func (s *Blob) OpenAttachment(ctx context.Context, id string, index int) (io.ReadCloser, error) {
+	ctx, cancel := s.ctx(ctx)
+	body, err := s.client.openObject(ctx, s.attachmentKey(id, index))
+	if err != nil {
+		cancel()
+		if errors.Is(err, errObjectNotFound) {
+			return nil, model.ErrPasteNotFound
+		}
+		return nil, fmt.Errorf("reading attachment: %w", err)
+	}
+	return &blobBody{ReadCloser: body, cancel: cancel}, nil
+}
+
+// blobBody releases an operation's context when the body is closed.
+type blobBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *blobBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// DeleteAttachments removes a paste's attachment objects.
+func (s *Blob) DeleteAttachments(ctx context.Context, id string) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+	return s.deleteAttachments(ctx, id)
+}
+
+// deleteAttachments removes the objects under a paste's attachment prefix.
+func (s *Blob) deleteAttachments(ctx context.Context, id string) error {
+	var keys []string
+	err := s.client.listObjects(ctx, s.attachmentPrefix(id), func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("listing attachments: %w", err)
+	}
+	for _, key := range keys {
+		if err := s.client.deleteObject(ctx, key); err != nil {
+			return fmt.Errorf("deleting attachment: %w", err)
+		}
+	}
+	return nil
+}
+
+// PasteIDs returns the IDs of all stored pastes. In the nested layout, that
+// lists every object below the prefix.
+func (s *Blob) PasteIDs(ctx context.Context) ([]string, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	var ids []string
+	err := s.client.listObjects(ctx, s.pastesPrefix(), func(key string) bool {
+		if id, ok := s.pasteID(key); ok {
+			ids = append(ids, id)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing pastes: %w", err)
+	}
+	return ids, nil
+}
+
+// IteratePastes calls fn for every paste that hasn't expired. The IDs are
+// listed first; each paste is then fetched only when its turn comes, and
+// skipped if it was deleted in the meantime.
+func (s *Blob) IteratePastes(ctx context.Context, fn func(*model.Paste) error) error {
+	ids, err := s.PasteIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		pasteCtx, cancel := s.ctx(ctx)
+		paste, err := s.loadPaste(pasteCtx, id)
+		cancel()
+		switch {
+		case errors.Is(err, model.ErrPasteNotFound):
+			continue
+		case err != nil:
+			return fmt.Errorf("paste %s: %w", id, err)
+		case paste.IsExpiredAt(s.clock.Now()):
+			continue // Left for Purge
+		}
+		if err := fn(paste); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PasteStats tallies the pastes that haven't expired. Buckets have no query
+// for object contents, so every paste is fetched.
+func (s *Blob) PasteStats(ctx context.Context, since int64) (*PasteStats, error) {
+	return tallyPastes(ctx, s, since)
+}
+
+// Values calls fn for every stored value.
+func (s *Blob) Values(ctx context.Context, fn func(namespace, key, value string) error) error {
+	listCtx, cancel := s.ctx(ctx)
+	prefix := s.prefix + "values/"
+	var keys []string
+	err := s.client.listObjects(listCtx, prefix, func(key string) bool {
+		keys = append(keys, strings.TrimPrefix(key, prefix))
+		return true
+	})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("listing values: %w", err)
+	}
+
+	for _, k := range keys {
+		namespace, key, ok := strings.Cut(k, "/")
+		if !ok {
+			continue
+		}
+		value, err := s.GetValue(ctx, namespace, key)
+		if err != nil {
+			return err
+		}
+		if err := fn(namespace, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiryEntry is an entry of the expiration index.
+type expiryEntry struct {
+	id         string
+	expireDate int64
+}
+
+// expiredEntries lists up to batchSize expired index entries, oldest first.
+func (s *Blob) expiredEntries(ctx context.Context, batchSize int) ([]expiryEntry, error) {
+	prefix := s.expiryPrefix()
+	now := s.clock.Now().Unix()
+
+	var entries []expiryEntry
+	err := s.client.listObjects(ctx, prefix, func(key string) bool {
+		stamp, id, ok := strings.Cut(strings.TrimPrefix(key, prefix), "/")
+		expireDate, err := strconv.ParseInt(stamp, 10, 64)
+		if !ok || err != nil {
+			return true
+		}
+		if expireDate >= now {
+			return false // Everything after this expires later still
+		}
+		entries = append(entries, expiryEntry{id, expireDate})
+		return len(entries) < batchSize
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing expiration index: %w", err)
+	}
+	return entries, nil
+}
+
+// GetExpiredPastes returns a list of expired paste IDs, oldest first.
+func (s *Blob) GetExpiredPastes(ctx context.Context, batchSize int) ([]string, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	entries, err := s.expiredEntries(ctx, batchSize)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.id
+	}
+	return ids, nil
+}
+
+// Purge deletes expired pastes. Index entries whose paste is already gone
+// (e.g. after a partly failed delete) are removed along the way.
+func (s *Blob) Purge(ctx context.Context, batchSize int) (int, error) {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	entries, err := s.expiredEntries(ctx, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, e := range entries {
+		if err := s.removePaste(ctx, e.id, e.expireDate); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// PurgeValues removes old key-value entries.
+func (s *Blob) PurgeValues(ctx context.Context, namespace string, maxAge int64) error {
+	ctx, cancel := s.ctx(ctx)
+	defer cancel()
+
+	cutoff := s.clock.Now().Unix() - maxAge
+
+	var keys []string
+	err := s.client.listObjects(ctx, s.valueKey(namespace, ""), func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return nil // Ignore errors
+	}
+
+	for _, key := range keys {
+		data, err := s.client.getObject(ctx, key)
+		if err != nil {
+			continue
+		}
+
+		// Try to parse as timestamp
+		var timestamp int64
+		if _, err := fmt.Sscanf(string(data), "%d", &timestamp); err == nil {
+			if valueSeconds(timestamp) < cutoff {
+				s.client.deleteObject(ctx, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Warmup checks that the bucket is reachable with the configured
+// credentials, so a typo or missing permission fails at startup rather
+// than on the first paste. Writes are synchronous, so there's nothing to
+// drain at shutdown.
+func (s *Blob) Warmup(ctx context.Context) error {
+	if err := s.client.headBucket(ctx); err != nil {
+		return fmt.Errorf("bucket not usable: %w", err)
+	}
+	return nil
+}
+
+// Ping checks that the bucket can be reached.
+func (s *Blob) Ping(ctx context.Context) error {
+	return s.client.headBucket(ctx)
+}
+
+// Close releases idle connections.
+func (s *Blob) Close() error {
+	s.client.close()
+	return nil
+}
@@ -0,0 +1,567 @@
+// Package storage provides tests for the Blob storage implementation on
+// each service it has a client for.
+package storage
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// fakeBucket holds the objects of a fake service.
+type fakeBucket struct {
+	mu         sync.Mutex
+	objects    map[string][]byte
+	versions   map[string]int64 // Generation of each object
+	generation int64            // Last generation handed out
+}
+
+// store writes an object as a new generation.
+func (f *fakeBucket) store(key string, body []byte) {
+	if f.versions == nil {
+		f.versions = map[string]int64{}
+	}
+	f.generation++
+	f.objects[key] = body
+	f.versions[key] = f.generation
+}
+
+// remove deletes an object.
+func (f *fakeBucket) remove(key string) {
+	delete(f.objects, key)
+	delete(f.versions, key)
+}
+
+// version returns an object's generation, 0 for a missing one.
+func (f *fakeBucket) version(key string) string {
+	return strconv.FormatInt(f.versions[key], 10)
+}
+
+// etag returns an object's generation as an ETag.
+func (f *fakeBucket) etag(key string) string {
+	return `"` + f.version(key) + `"`
+}
+
+// checkMatch answers a request conditional on If-Match for a missing or
+// changed object, and reports whether it did.
+func (f *fakeBucket) checkMatch(w http.ResponseWriter, r *http.Request, key string) bool {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		return false
+	}
+	if _, exists := f.objects[key]; !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return true
+	}
+	if match != f.etag(key) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return true
+	}
+	return false
+}
+
+// keys returns the stored object keys, sorted.
+func (f *fakeBucket) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// page returns up to two keys under prefix after marker, and the marker
+// of the next page ("" if there is none).
+func (f *fakeBucket) page(prefix, marker string) (keys []string, next string) {
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > 2 {
+		return keys[:2], keys[1]
+	}
+	return keys, ""
+}
+
+// fakeGCS is an in-memory Cloud Storage JSON API server that issues tokens
+// for the service account key it was created with.
+type fakeGCS struct {
+	fakeBucket
+	bucket string
+	key    *rsa.PublicKey
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		f.token(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	bucket, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"), "/")
+	if upload, ok := strings.CutPrefix(r.URL.Path, "/upload/storage/v1/b/"); ok {
+		bucket, rest = strings.TrimSuffix(upload, "/o"), ""
+	}
+	if bucket != f.bucket {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key, isObject := strings.CutPrefix(rest, "o/")
+
+	switch {
+	case r.Method == http.MethodPost:
+		name := query.Get("name")
+		if match := query.Get("ifGenerationMatch"); match != "" && match != f.version(name) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.store(name, body)
+		fmt.Fprintf(w, `{"name":%q}`, name)
+	case rest == "":
+		fmt.Fprintf(w, `{"name":%q}`, f.bucket)
+	case rest == "o":
+		var listing gcsListing
+		keys, next := f.page(query.Get("prefix"), query.Get("pageToken"))
+		for _, key := range keys {
+			listing.Items = append(listing.Items, struct {
+				Name string `json:"name"`
+			}{key})
+		}
+		listing.NextPageToken = next
+		json.NewEncoder(w).Encode(listing)
+	case !isObject:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodGet:
+		body, exists := f.objects[key]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if query.Get("alt") == "media" {
+			w.Header().Set("X-Goog-Generation", f.version(key))
+			w.Write(body)
+			return
+		}
+		fmt.Fprintf(w, `{"name":%q}`, key)
+	case r.Method == http.MethodDelete:
+		if _, exists := f.objects[key]; !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if match := query.Get("ifGenerationMatch"); match != "" && match != f.version(key) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		f.remove(key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// token exchanges a JWT signed with the service account's key for a token.
+func (f *fakeGCS) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	parts := strings.Split(r.PostForm.Get("assertion"), ".")
+	if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	if rsa.VerifyPKCS1v15(f.key, crypto.SHA256, sum[:], sig) != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprint(w, `{"access_token":"test-token","expires_in":3600,"token_type":"Bearer"}`)
+}
+
+// fakeAzure is an in-memory Azure Blob Storage server for the Azurite
+// account, checking each request's Shared Key signature.
+type fakeAzure struct {
+	fakeBucket
+	container string
+	key       []byte
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	date, err := http.ParseTime(r.Header.Get("X-Ms-Date"))
+	if err != nil || r.Header.Get("X-Ms-Version") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	signed := r.Clone(context.Background())
+	signed.Header.Del("Authorization")
+	signSharedKey(signed, "devstoreaccount1", f.key, date)
+	if auth := r.Header.Get("Authorization"); auth == "" || auth != signed.Header.Get("Authorization") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	path, ok := strings.CutPrefix(r.URL.Path, "/devstoreaccount1/")
+	container, key, _ := strings.Cut(path, "/")
+	if !ok || container != f.container {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case key == "" && query.Get("comp") == "list":
+		var listing azureListing
+		keys, next := f.page(query.Get("prefix"), query.Get("marker"))
+		for _, key := range keys {
+			listing.Blobs = append(listing.Blobs, struct {
+				Name string `xml:"Name"`
+			}{key})
+		}
+		listing.NextMarker = next
+		xml.NewEncoder(w).Encode(listing)
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut:
+		if _, exists := f.objects[key]; exists && r.Header.Get("If-None-Match") == "*" {
+			w.Header().Set("X-Ms-Error-Code", "BlobAlreadyExists")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if f.checkMatch(w, r, key) {
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.store(key, body)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		body, exists := f.objects[key]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", f.etag(key))
+		w.Write(body)
+	case r.Method == http.MethodDelete:
+		if _, exists := f.objects[key]; !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if f.checkMatch(w, r, key) {
+			return
+		}
+		f.remove(key)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeServiceAccount writes a service account key file whose tokens come
+// from tokenURI, and returns its path and public key.
+func writeServiceAccount(t *testing.T, tokenURI string) (string, *rsa.PublicKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "flashpaper@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenURI,
+	})
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "credentials.json")
+	require.NoError(t, os.WriteFile(file, data, 0600))
+	return file, &key.PublicKey
+}
+
+// newTestBlob creates a Blob backend on a fake server for scheme, and
+// returns it with a function listing the stored keys.
+func newTestBlob(t *testing.T, scheme string) (*Blob, func() []string) {
+	t.Helper()
+
+	cfg := &config.Config{Model: config.ModelConfig{Class: "Blob", Prefix: "fp"}}
+	var keys func() []string
+	switch scheme {
+	case "s3":
+		fake := &fakeS3{fakeBucket: fakeBucket{objects: map[string][]byte{}}, bucket: "pastes"}
+		server := httptest.NewServer(fake)
+		t.Cleanup(server.Close)
+		cfg.Model.Endpoint = server.URL
+		cfg.Model.PathStyle = true
+		cfg.Model.AccessKey = "test-access-key"
+		cfg.Model.SecretKey = "test-secret-key"
+		keys = fake.keys
+	case "gs":
+		fake := &fakeGCS{fakeBucket: fakeBucket{objects: map[string][]byte{}}, bucket: "pastes"}
+		server := httptest.NewServer(fake)
+		t.Cleanup(server.Close)
+		file, key := writeServiceAccount(t, server.URL+"/token")
+		fake.key = key
+		t.Setenv("STORAGE_EMULATOR_HOST", "")
+		t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", file)
+		cfg.Model.Endpoint = server.URL
+		keys = fake.keys
+	case "azblob":
+		fake := &fakeAzure{fakeBucket: fakeBucket{objects: map[string][]byte{}}, container: "pastes", key: []byte("test-account-key")}
+		server := httptest.NewServer(fake)
+		t.Cleanup(server.Close)
+		cfg.Model.Endpoint = server.URL + "/devstoreaccount1"
+		cfg.Model.AccessKey = "devstoreaccount1"
+		cfg.Model.SecretKey = base64.StdEncoding.EncodeToString(fake.key)
+		keys = fake.keys
+	}
+	cfg.Model.Bucket = scheme + "://pastes"
+
+	s, err := NewBlob(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, keys
+}
+
+// blobSchemes are the services Blob has a client for.
+var blobSchemes = []string{"s3", "gs", "azblob"}
+
+func TestBlob_Layout(t *testing.T) {
+	ctx := context.Background()
+	for _, scheme := range blobSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			s, keys := newTestBlob(t, scheme)
+			pasteID := "abcdef1234567890"
+
+			store, ok := Attachments(s)
+			require.True(t, ok)
+			_, err := store.WriteAttachment(ctx, pasteID, 0, strings.NewReader("attached"))
+			require.NoError(t, err)
+			paste := newS3TestPaste(time.Hour)
+			require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+			require.NoError(t, s.CreateComment(ctx, pasteID, pasteID, "c1", &model.Comment{Data: "comment"}))
+			_, err = s.MarkRead(ctx, pasteID)
+			require.NoError(t, err)
+			require.NoError(t, s.SetValue(ctx, "traffic", "key", "1"))
+
+			// Named like the Filesystem backend's files
+			assert.Equal(t, []string{
+				"fp/ab/cd/abcdef1234567890",
+				"fp/ab/cd/abcdef1234567890.attachment.0",
+				"fp/ab/cd/abcdef1234567890.discussion/c1.abcdef1234567890.json",
+				"fp/ab/cd/abcdef1234567890.read",
+				fmt.Sprintf("fp/expiry/%010d/abcdef1234567890", paste.Meta.ExpireDate),
+				"fp/values/traffic/key",
+			}, keys())
+
+			ids, err := s.PasteIDs(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []string{pasteID}, ids)
+
+			require.NoError(t, s.DeletePaste(ctx, pasteID))
+			assert.Equal(t, []string{"fp/values/traffic/key"}, keys())
+		})
+	}
+}
+
+func TestBlob_ExclusiveCreate(t *testing.T) {
+	for _, scheme := range blobSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			s, _ := newTestBlob(t, scheme)
+			ctx := context.Background()
+
+			require.NoError(t, s.client.putObject(ctx, "fp/key", []byte("first"), true))
+			assert.ErrorIs(t, s.client.putObject(ctx, "fp/key", []byte("second"), true), errPreconditionFailed)
+			require.NoError(t, s.client.putObject(ctx, "fp/key", []byte("third"), false))
+
+			data, err := s.client.getObject(ctx, "fp/key")
+			require.NoError(t, err)
+			assert.Equal(t, "third", string(data))
+
+			_, err = s.client.getObject(ctx, "fp/missing")
+			assert.ErrorIs(t, err, errObjectNotFound)
+		})
+	}
+}
+
+func TestBlob_ConditionalWrites(t *testing.T) {
+	for _, scheme := range blobSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			s, _ := newTestBlob(t, scheme)
+			ctx := context.Background()
+
+			require.NoError(t, s.client.putObject(ctx, "fp/key", []byte("first"), false))
+			_, first, err := s.client.getVersion(ctx, "fp/key")
+			require.NoError(t, err)
+			require.NoError(t, s.client.replaceObject(ctx, "fp/key", []byte("second"), first))
+			assert.ErrorIs(t, s.client.replaceObject(ctx, "fp/key", []byte("third"), first), errPreconditionFailed)
+
+			data, second, err := s.client.getVersion(ctx, "fp/key")
+			require.NoError(t, err)
+			assert.Equal(t, "second", string(data))
+			assert.ErrorIs(t, s.client.deleteVersion(ctx, "fp/key", first), errPreconditionFailed)
+			require.NoError(t, s.client.deleteVersion(ctx, "fp/key", second))
+			assert.ErrorIs(t, s.client.deleteVersion(ctx, "fp/key", second), errObjectNotFound)
+			err = s.client.replaceObject(ctx, "fp/key", []byte("fourth"), second)
+			assert.True(t, errors.Is(err, errObjectNotFound) || errors.Is(err, errPreconditionFailed), err)
+		})
+	}
+}
+
+// blobReplicas spreads reads over several Blob instances sharing a bucket,
+// each with its own lock, as the replicas of a deployment would.
+type blobReplicas struct {
+	*Blob
+	all  []*Blob
+	next atomic.Int64
+}
+
+func newBlobReplicas(s *Blob, n int) *blobReplicas {
+	r := &blobReplicas{Blob: s}
+	for i := 0; i < n; i++ {
+		r.all = append(r.all, newBlob(s.client, &config.Config{Model: config.ModelConfig{Prefix: "fp"}}, true))
+	}
+	return r
+}
+
+func (r *blobReplicas) pick() *Blob {
+	return r.all[int(r.next.Add(1))%len(r.all)]
+}
+
+func (r *blobReplicas) ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error) {
+	return r.pick().ReadAndDeletePaste(ctx, id)
+}
+
+func (r *blobReplicas) CountRead(ctx context.Context, id string) (*model.Paste, error) {
+	return r.pick().CountRead(ctx, id)
+}
+
+// TestBlob_Replicas tests that burn-after-reading and read limits hold
+// across instances sharing a bucket.
+func TestBlob_Replicas(t *testing.T) {
+	for _, scheme := range blobSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			s, _ := newTestBlob(t, scheme)
+			replicas := newBlobReplicas(s, 8)
+			checkReadAndDelete(t, replicas)
+			checkCountRead(t, replicas)
+		})
+	}
+}
+
+func TestBlob_Storage(t *testing.T) {
+	for _, scheme := range blobSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			s, keys := newTestBlob(t, scheme)
+			checkReadAndDelete(t, s)
+			checkCountRead(t, s)
+			checkUpdatePaste(t, s)
+			checkDeleteComment(t, s)
+			checkAttachmentStore(t, s)
+			assert.Empty(t, keys())
+			checkIterate(t, s)
+		})
+	}
+}
+
+func TestBlob_Warmup(t *testing.T) {
+	for _, scheme := range blobSchemes {
+		t.Run(scheme, func(t *testing.T) {
+			s, _ := newTestBlob(t, scheme)
+			assert.NoError(t, s.Warmup(context.Background()))
+
+			switch c := s.client.(type) {
+			case *s3Client:
+				c.bucket = "missing"
+			case *gcsClient:
+				c.bucket = "missing"
+			case *azureClient:
+				c.container = "missing"
+			}
+			assert.Error(t, s.Warmup(context.Background()))
+		})
+	}
+}
+
+func TestNewBlob_BucketURL(t *testing.T) {
+	for _, bucket := range []string{"pastes", "s3://", "ftp://pastes", "://pastes"} {
+		_, err := NewBlob(&config.Config{Model: config.ModelConfig{Class: "Blob", Bucket: bucket}})
+		assert.Error(t, err, bucket)
+	}
+
+	t.Setenv("AZURE_STORAGE_ACCOUNT", "")
+	t.Setenv("AZURE_STORAGE_KEY", "")
+	_, err := NewBlob(&config.Config{Model: config.ModelConfig{Class: "Blob", Bucket: "azblob://pastes"}})
+	assert.ErrorContains(t, err, "no Azure credentials")
+}
+
+func TestGCS_MetadataTokens(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "/computeMetadata/v1/instance/service-accounts/default/token", r.URL.Path)
+		assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+		fmt.Fprint(w, `{"access_token":"instance-token","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+	c, err := newGCSClient("pastes", config.ModelConfig{})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		token, err := c.tokens.accessToken(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "instance-token", token)
+	}
+	assert.Equal(t, 1, calls, "tokens should be cached until close to expiry")
+}
+
+func TestGCS_Emulator(t *testing.T) {
+	t.Setenv("STORAGE_EMULATOR_HOST", "localhost:4443")
+	c, err := newGCSClient("pastes", config.ModelConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:4443", c.endpoint)
+	assert.Nil(t, c.tokens)
+}
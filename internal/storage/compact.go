@@ -0,0 +1,394 @@
+// Package storage provides compaction for the filesystem backend.
+// Every paste is normally its own file, which on instances with millions of
+// tiny pastes exhausts inodes and makes purge scans read millions of files.
+// Compaction repacks small paste files into one container per shard:
+//
+//	data/
+//	  f4/
+//	    pack.idx                    <- JSON index: paste ID -> offset, length, expiry
+//	    pack-1718031234567890.dat   <- concatenated paste documents
+//	    68/
+//	      f468483c313401e8.read     <- receipts and discussions stay as files
+//
+// Packed pastes are read through the index; new pastes are still written as
+// loose files, so the simple layout remains the default until an operator
+// runs compaction. Deleting a packed paste removes it from the index, and its
+// bytes are reclaimed by the next compaction. Dropping an expired packed
+// paste also drops its expiration index entry (see expiry.go).
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// packIndexName is the index file in each shard directory.
+const packIndexName = "pack.idx"
+
+// packIndex maps paste IDs to their location in a shard's container file.
+type packIndex struct {
+	Data    string               `json:"data"` // Container filename within the shard
+	Entries map[string]packEntry `json:"entries"`
+
+	modTime time.Time // Index file mtime when loaded, to notice compactions
+	size    int64
+}
+
+// packEntry locates one paste in a container file.
+type packEntry struct {
+	Offset int64 `json:"off"`
+	Length int64 `json:"len"`
+	Expire int64 `json:"expire,omitempty"` // Unix timestamp (0 = never)
+}
+
+// CompactStats reports what a compaction did.
+type CompactStats struct {
+	Shards  int // Shards whose container was rewritten
+	Packed  int // Loose paste files moved into containers
+	Expired int // Expired packed pastes dropped
+}
+
+// shardOf returns the shard directory name for a paste ID.
+func shardOf(id string) string {
+	if len(id) < 4 {
+		return ""
+	}
+	return id[:2]
+}
+
+// isPackFile reports whether a filename belongs to a shard container.
+func isPackFile(name string) bool {
+	return name == packIndexName || (strings.HasPrefix(name, "pack-") && strings.HasSuffix(name, ".dat"))
+}
+
+// packIndexPath returns the index path for a shard.
+func (f *Filesystem) packIndexPath(shard string) string {
+	return filepath.Join(f.baseDir, shard, packIndexName)
+}
+
+// loadPack returns a shard's index, or nil if the shard isn't packed.
+// Indexes are cached and reloaded when the file changes, so a compaction run
+// by a separate process is picked up without restarting the server.
+func (f *Filesystem) loadPack(shard string) (*packIndex, error) {
+	if shard == "" {
+		return nil, nil
+	}
+
+	f.packMu.Lock()
+	defer f.packMu.Unlock()
+
+	path := f.packIndexPath(shard)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		delete(f.packs, shard)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading pack index: %w", err)
+	}
+
+	if idx, ok := f.packs[shard]; ok && idx.modTime.Equal(info.ModTime()) && idx.size == info.Size() {
+		return idx, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pack index: %w", err)
+	}
+	idx := &packIndex{}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("parsing pack index: %w", err)
+	}
+	if idx.Entries == nil {
+		idx.Entries = map[string]packEntry{}
+	}
+	idx.modTime = info.ModTime()
+	idx.size = info.Size()
+
+	f.packs[shard] = idx
+	return idx, nil
+}
+
+// writePackUnsafe atomically replaces a shard's index and updates the cache.
+// Caller must hold the write lock.
+func (f *Filesystem) writePackUnsafe(shard string, idx *packIndex) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("serializing pack index: %w", err)
+	}
+
+	path := f.packIndexPath(shard)
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return fmt.Errorf("writing pack index: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return fmt.Errorf("renaming pack index: %w", err)
+	}
+
+	// Force a reload so the cached mtime matches the new file
+	f.packMu.Lock()
+	delete(f.packs, shard)
+	f.packMu.Unlock()
+	return nil
+}
+
+// readPacked reads a packed paste document, reporting whether it was found.
+func (f *Filesystem) readPacked(id string) (data []byte, found bool, err error) {
+	shard := shardOf(id)
+	idx, err := f.loadPack(shard)
+	if err != nil || idx == nil {
+		return nil, false, err
+	}
+	entry, ok := idx.Entries[id]
+	if !ok {
+		return nil, false, nil
+	}
+
+	file, err := os.Open(filepath.Join(f.baseDir, shard, idx.Data))
+	if err != nil {
+		return nil, false, fmt.Errorf("opening pack: %w", err)
+	}
+	defer file.Close()
+
+	data = make([]byte, entry.Length)
+	if _, err := file.ReadAt(data, entry.Offset); err != nil {
+		return nil, false, fmt.Errorf("reading pack: %w", err)
+	}
+	return data, true, nil
+}
+
+// isPacked reports whether a paste is in its shard's container.
+func (f *Filesystem) isPacked(id string) bool {
+	idx, err := f.loadPack(shardOf(id))
+	if err != nil || idx == nil {
+		return false
+	}
+	_, ok := idx.Entries[id]
+	return ok
+}
+
+// unpackUnsafe removes a paste from its shard's index, reporting whether it
+// was packed. Caller must hold the write lock.
+func (f *Filesystem) unpackUnsafe(id string) (bool, error) {
+	shard := shardOf(id)
+	idx, err := f.loadPack(shard)
+	if err != nil || idx == nil {
+		return false, err
+	}
+	if _, ok := idx.Entries[id]; !ok {
+		return false, nil
+	}
+
+	entries := make(map[string]packEntry, len(idx.Entries))
+	for k, v := range idx.Entries {
+		if k != id {
+			entries[k] = v
+		}
+	}
+	return true, f.writePackUnsafe(shard, &packIndex{Data: idx.Data, Entries: entries})
+}
+
+// Compact repacks loose paste files of at most maxSize bytes (0 = any size)
+// into per-shard containers, and drops expired pastes from existing
+// containers. Each shard is rewritten under the write lock, so the running
+// process can keep serving; the container is fully written and synced before
+// the index switches to it, and loose files are only removed afterwards.
+//
+// Compaction is safe to run from a separate process while a server uses the
+// same directory, except that a packed paste deleted by the server during
+// the run may reappear. Stopping the server first avoids that.
+func (f *Filesystem) Compact(ctx context.Context, maxSize int64) (CompactStats, error) {
+	var stats CompactStats
+
+	shards, err := os.ReadDir(f.baseDir)
+	if err != nil {
+		return stats, fmt.Errorf("reading data directory: %w", err)
+	}
+
+	for _, shard := range shards {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue // _config and stray files
+		}
+		if err := f.compactShard(shard.Name(), maxSize, &stats); err != nil {
+			return stats, fmt.Errorf("compacting shard %s: %w", shard.Name(), err)
+		}
+	}
+
+	return stats, nil
+}
+
+// compactShard rewrites one shard's container.
+func (f *Filesystem) compactShard(shard string, maxSize int64, stats *CompactStats) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	shardDir := filepath.Join(f.baseDir, shard)
+	now := f.clock.Now().Unix()
+
+	// Loose paste files small enough to pack: data/f4/68/f468483c313401e8
+	loose := map[string]string{} // ID -> path
+	subdirs, err := os.ReadDir(shardDir)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subdirs {
+		if !sub.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(shardDir, sub.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.IsDir() || !util.ValidateID(file.Name()) {
+				continue // Receipts, discussions, temp files
+			}
+			info, err := file.Info()
+			if err != nil || (maxSize > 0 && info.Size() > maxSize) {
+				continue
+			}
+			loose[file.Name()] = filepath.Join(shardDir, sub.Name(), file.Name())
+		}
+	}
+
+	old, err := f.loadPack(shard)
+	if err != nil {
+		return err
+	}
+
+	// Existing entries whose paste expired are dropped along with their
+	// receipts and discussions; purge would otherwise do the same later
+	var expired []string
+	if old != nil {
+		for id, entry := range old.Entries {
+			if entry.Expire > 0 && entry.Expire < now {
+				expired = append(expired, id)
+			}
+		}
+	}
+	if len(loose) == 0 && len(expired) == 0 {
+		return nil
+	}
+
+	// Write the new container: surviving packed pastes, then loose ones.
+	// A loose file wins over a packed copy left by an interrupted run.
+	dataName := fmt.Sprintf("pack-%d.dat", time.Now().UnixNano())
+	dataPath := filepath.Join(shardDir, dataName)
+	out, err := os.OpenFile(dataPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		return fmt.Errorf("creating pack: %w", err)
+	}
+	defer out.Close()
+
+	idx := &packIndex{Data: dataName, Entries: map[string]packEntry{}}
+	var offset int64
+	appendEntry := func(id string, doc []byte, expire int64) error {
+		if _, err := out.Write(doc); err != nil {
+			return fmt.Errorf("writing pack: %w", err)
+		}
+		idx.Entries[id] = packEntry{Offset: offset, Length: int64(len(doc)), Expire: expire}
+		offset += int64(len(doc))
+		return nil
+	}
+
+	if old != nil {
+		for id, entry := range old.Entries {
+			if _, ok := loose[id]; ok || (entry.Expire > 0 && entry.Expire < now) {
+				continue
+			}
+			doc, found, err := f.readPacked(id)
+			if err != nil {
+				return err
+			}
+			if found {
+				if err := appendEntry(id, doc, entry.Expire); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	var packed []string
+	for id, path := range loose {
+		doc, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var storageData pasteStorageData
+		if err := json.Unmarshal(doc, &storageData); err != nil {
+			continue // Leave unreadable files for inspection
+		}
+		expire := storageData.Meta.ExpiresAt()
+		if expire > 0 && expire < now {
+			continue // Left for purge, which also removes comments
+		}
+		if err := appendEntry(id, doc, expire); err != nil {
+			return err
+		}
+		packed = append(packed, id)
+	}
+
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("syncing pack: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("closing pack: %w", err)
+	}
+
+	// Switching the index commits the compaction
+	if err := f.writePackUnsafe(shard, idx); err != nil {
+		os.Remove(dataPath)
+		return err
+	}
+
+	// Everything below only reclaims space; failures are harmless
+	if old != nil && old.Data != dataName {
+		os.Remove(filepath.Join(shardDir, old.Data))
+	}
+	for _, id := range expired {
+		os.RemoveAll(f.discussionDir(id))
+		os.Remove(f.receiptPath(id))
+		f.removeExpiry(id, old.Entries[id].Expire)
+	}
+	for _, id := range packed {
+		os.Remove(loose[id])
+	}
+	for _, sub := range subdirs {
+		if sub.IsDir() {
+			os.Remove(filepath.Join(shardDir, sub.Name())) // Only succeeds if empty
+		}
+	}
+
+	stats.Shards++
+	stats.Packed += len(packed)
+	stats.Expired += len(expired)
+	return nil
+}
+
+// writeFileSync writes data to path and syncs it to disk.
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,233 @@
+// Package storage provides tests for filesystem compaction.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// compactTestPaste returns a paste expiring after ttl (0 = never).
+func compactTestPaste(data string, ttl time.Duration) *model.Paste {
+	paste := &model.Paste{
+		Data:    data,
+		Version: 2,
+		Meta:    model.PasteMeta{PostDate: time.Now().Unix()},
+	}
+	if ttl != 0 {
+		paste.Meta.ExpireDate = time.Now().Add(ttl).Unix()
+	}
+	return paste
+}
+
+func TestFilesystem_Compact_PacksAndReads(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+
+	ids := []string{"f468483c313401e8", "f4aa000000000001", "0b12345678901234"}
+	for i, id := range ids {
+		require.NoError(t, fs.CreatePaste(ctx, id, compactTestPaste(fmt.Sprintf("content-%d", i), time.Hour)))
+	}
+	_, err = fs.MarkRead(ctx, ids[0])
+	require.NoError(t, err)
+
+	stats, err := fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, CompactStats{Shards: 2, Packed: 3}, stats)
+
+	// Loose files are gone; the shard holds a container and index
+	_, err = os.Stat(fs.pastePath(ids[0]))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(fs.baseDir, "f4", packIndexName))
+	assert.NoError(t, err)
+
+	for i, id := range ids {
+		assert.True(t, fs.PasteExists(ctx, id))
+		paste, err := fs.ReadPaste(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("content-%d", i), paste.Data)
+	}
+
+	// Receipts survive compaction
+	receipt, err := fs.GetReadReceipt(ctx, ids[0])
+	require.NoError(t, err)
+	assert.True(t, receipt.IsRead())
+
+	// Packed pastes can't be recreated, and still take comments
+	assert.Equal(t, model.ErrPasteExists, fs.CreatePaste(ctx, ids[1], compactTestPaste("x", time.Hour)))
+	require.NoError(t, fs.CreateComment(ctx, ids[1], ids[1], "c000000000000001", &model.Comment{Data: "comment"}))
+}
+
+func TestFilesystem_Compact_Delete(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+
+	require.NoError(t, fs.CreatePaste(ctx, "f468483c313401e8", compactTestPaste("a", time.Hour)))
+	require.NoError(t, fs.CreatePaste(ctx, "f4aa000000000001", compactTestPaste("b", time.Hour)))
+	_, err = fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+
+	require.NoError(t, fs.DeletePaste(ctx, "f468483c313401e8"))
+	assert.False(t, fs.PasteExists(ctx, "f468483c313401e8"))
+	assert.Equal(t, model.ErrPasteNotFound, fs.DeletePaste(ctx, "f468483c313401e8"))
+
+	paste, err := fs.ReadPaste(ctx, "f4aa000000000001")
+	require.NoError(t, err)
+	assert.Equal(t, "b", paste.Data)
+}
+
+func TestFilesystem_Compact_Incremental(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+
+	require.NoError(t, fs.CreatePaste(ctx, "f468483c313401e8", compactTestPaste("first", time.Hour)))
+	_, err = fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+
+	// New pastes are loose until the next compaction, which keeps old entries
+	require.NoError(t, fs.CreatePaste(ctx, "f4aa000000000001", compactTestPaste("second", time.Hour)))
+	stats, err := fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Packed)
+
+	for id, want := range map[string]string{"f468483c313401e8": "first", "f4aa000000000001": "second"} {
+		paste, err := fs.ReadPaste(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, want, paste.Data)
+	}
+
+	// Only one container remains in the shard
+	entries, err := os.ReadDir(filepath.Join(fs.baseDir, "f4"))
+	require.NoError(t, err)
+	containers := 0
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".dat") {
+			containers++
+		}
+	}
+	assert.Equal(t, 1, containers)
+
+	// Nothing left to do
+	stats, err = fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, CompactStats{}, stats)
+}
+
+func TestFilesystem_Compact_MaxSize(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+
+	require.NoError(t, fs.CreatePaste(ctx, "f468483c313401e8", compactTestPaste("small", time.Hour)))
+	require.NoError(t, fs.CreatePaste(ctx, "f4aa000000000001", compactTestPaste(strings.Repeat("x", 4096), time.Hour)))
+
+	stats, err := fs.Compact(context.Background(), 1024)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Packed)
+
+	// The large paste stays loose
+	_, err = os.Stat(fs.pastePath("f4aa000000000001"))
+	assert.NoError(t, err)
+}
+
+func TestFilesystem_Compact_Expiry(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	c := clock.NewFake(time.Now())
+	fs.SetClock(c)
+
+	require.NoError(t, fs.CreatePaste(ctx, "f468483c313401e8", compactTestPaste("keep", time.Hour)))
+	require.NoError(t, fs.CreatePaste(ctx, "f4aa000000000001", compactTestPaste("soon", 2*time.Second)))
+	_, err = fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+
+	c.Advance(3 * time.Second)
+
+	// Purge finds expired pastes through the index
+	expired, err := fs.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"f4aa000000000001"}, expired)
+
+	// Compaction drops them too
+	stats, err := fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Expired)
+	assert.False(t, fs.PasteExists(ctx, "f4aa000000000001"))
+	assert.True(t, fs.PasteExists(ctx, "f468483c313401e8"))
+}
+
+func TestFilesystem_Compact_SeenByOtherInstance(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	server, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	require.NoError(t, server.CreatePaste(ctx, "f468483c313401e8", compactTestPaste("content", time.Hour)))
+	assert.True(t, server.PasteExists(ctx, "f468483c313401e8"))
+
+	// A separate compaction process over the same directory
+	compactor, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	_, err = compactor.Compact(context.Background(), 0)
+	require.NoError(t, err)
+
+	paste, err := server.ReadPaste(ctx, "f468483c313401e8")
+	require.NoError(t, err)
+	assert.Equal(t, "content", paste.Data)
+}
+
+func TestFilesystem_Compact_ReadAndDelete(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+
+	id := "f468483c313401e8"
+	require.NoError(t, fs.CreatePaste(ctx, id, compactTestPaste("burn", time.Hour)))
+	_, err = fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+
+	paste, err := fs.ReadAndDeletePaste(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "burn", paste.Data)
+	assert.False(t, fs.isPacked(id))
+	_, err = fs.ReadAndDeletePaste(ctx, id)
+	assert.Equal(t, model.ErrPasteNotFound, err)
+}
+
+func TestFilesystem_Compact_Pinned(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+
+	id := "f468483c313401e8"
+	require.NoError(t, fs.CreatePaste(ctx, id, compactTestPaste("pinned", time.Hour)))
+	_, err = fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+
+	// Pinning a packed paste moves it back to a loose file
+	require.NoError(t, fs.SetPinned(ctx, id, true))
+	assert.False(t, fs.isPacked(id))
+	paste, err := fs.ReadPaste(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, paste.Meta.Pinned)
+
+	// Repacked, it keeps no expiry in the index
+	_, err = fs.Compact(context.Background(), 0)
+	require.NoError(t, err)
+	idx, err := fs.loadPack(shardOf(id))
+	require.NoError(t, err)
+	assert.Zero(t, idx.Entries[id].Expire)
+}
@@ -6,35 +6,78 @@
 // - paste: stores encrypted paste data and metadata
 // - comment: stores encrypted comments with threading support
 // - config: stores key-value pairs for server configuration
+// - receipt: stores first-read timestamps (FlashPaper extension)
+// - commentcount: per-paste comment counters (FlashPaper extension)
+// - attachment: attachments in numbered chunks (FlashPaper extension)
 package storage
 
 import (
+	"context"
 	"database/sql"
+	sqldriver "database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	// Database drivers - imported for side effects (driver registration)
+	// Database drivers - imported for side effects (driver registration).
+	// The SQLite driver depends on the build (see sqlite_cgo.go)
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3"
 
+	"github.com/liskl/flashpaper/internal/clock"
 	"github.com/liskl/flashpaper/internal/config"
 	"github.com/liskl/flashpaper/internal/model"
 )
 
 // Database implements the Storage interface using SQL databases.
 // Supports SQLite, PostgreSQL, and MySQL.
+//
+// Operations run concurrently on the connection pool, relying on the
+// database's own row locks and transactions; only SQLite writes are
+// serialized (see lockWrites).
 type Database struct {
-	db     *sql.DB
-	driver string // "sqlite3", "postgres", or "mysql"
-	mu     sync.RWMutex
+	driver       string        // "sqlite3", "postgres", or "mysql"
+	sqlitePath   string        // SQLite database file, for lock files; "" in memory
+	commentLimit int           // Max comments per paste (0 = unlimited)
+	queryTimeout time.Duration // Bound on each operation (0 = none)
+
+	// pool is loaded by every operation and replaced whole by Warmup,
+	// Drain, and failover, which swapMu serializes.
+	pool   atomic.Pointer[dbPool]
+	swapMu sync.Mutex
+
+	writeMu sync.Mutex // SQLite's single writer (see lockWrites)
+
+	// failover tracks the candidate hosts when the DSN lists several or
+	// [model] srv is set; nil otherwise. See failover.go.
+	failover *failover
+
+	clock clock.Clock // Expiration and receipt times (see SetClock)
+}
+
+// dbPool is a connection pool with the statements Warmup prepared on it.
+type dbPool struct {
+	db    *sql.DB
+	stmts map[string]*sql.Stmt // Keyed by query text; nil until Warmup
+}
+
+// closeStmts closes the pool's prepared statements, once the queries
+// using them finish.
+func (p *dbPool) closeStmts() {
+	for _, stmt := range p.stmts {
+		stmt.Close()
+	}
 }
 
 // NewDatabase creates a new database storage backend.
-// The database tables are created automatically if they don't exist.
+// Pending schema migrations are applied unless [model] no_migrate is set.
 func NewDatabase(cfg *config.Config) (*Database, error) {
 	// Determine the driver name for sql.Open
 	// PostgreSQL DSN format needs the "postgres" driver
@@ -46,129 +89,170 @@ func NewDatabase(cfg *config.Config) (*Database, error) {
 		dsn = strings.Replace(dsn, "postgresql://", "postgres://", 1)
 	}
 
-	db, err := sql.Open(driver, dsn)
-	if err != nil {
-		return nil, fmt.Errorf("opening database: %w", err)
+	d := &Database{
+		driver:       driver,
+		commentLimit: cfg.Main.CommentLimit,
+		queryTimeout: time.Duration(cfg.Model.QueryTimeout) * time.Second,
+		clock:        clock.System,
 	}
-
-	// Verify connection works
-	if err := db.Ping(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("connecting to database: %w", err)
+	pool := poolSettingsFor(cfg.Model)
+	if driver == "sqlite3" {
+		d.sqlitePath = sqliteFile(dsn)
+		dsn = sqliteDSN(dsn, cfg.Model)
 	}
 
-	// Configure connection pool
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	// With several hosts (or an SRV record) connect to whichever is primary
+	f, err := newFailover(cfg, dsn, pool)
+	if err != nil {
+		return nil, err
+	}
+	var db *sql.DB
+	if f != nil {
+		f.current, db, err = f.findPrimary(context.Background(), "")
+		d.failover = f
+	} else {
+		db, err = openDB(driver, dsn, pool)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.pool.Store(&dbPool{db: db})
 
-	d := &Database{
-		db:     db,
-		driver: driver,
+	// Bring the schema up to date, unless that's a separate deployment step
+	if !cfg.Model.NoMigrate {
+		if _, err := d.MigrateSchema(context.Background()); err != nil {
+			db.Close()
+			return nil, err
+		}
 	}
 
-	// Create tables if they don't exist
-	if err := d.createTables(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("creating tables: %w", err)
+	if d.failover != nil {
+		d.failover.start(d)
 	}
 
 	return d, nil
 }
 
-// createTables creates the necessary database tables.
-// The schema is designed to be compatible with PrivateBin.
-func (d *Database) createTables() error {
-	// Get database-specific SQL variations
-	textType := d.textType()
-	autoIncrement := d.autoIncrement()
-	_ = autoIncrement // Not used for these tables
-
-	// Create paste table
-	pasteSQL := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS paste (
-			dataid CHAR(16) PRIMARY KEY,
-			data %s NOT NULL,
-			expiredate BIGINT,
-			meta %s
-		)
-	`, textType, textType)
-
-	if _, err := d.db.Exec(pasteSQL); err != nil {
-		return fmt.Errorf("creating paste table: %w", err)
-	}
-
-	// Create comment table
-	commentSQL := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS comment (
-			dataid CHAR(16) PRIMARY KEY,
-			pasteid CHAR(16) NOT NULL,
-			parentid CHAR(16),
-			data %s NOT NULL,
-			vizhash %s,
-			postdate BIGINT NOT NULL
-		)
-	`, textType, textType)
+// SetClock sets the clock expiration is judged by.
+func (d *Database) SetClock(c clock.Clock) {
+	d.clock = c
+}
 
-	if _, err := d.db.Exec(commentSQL); err != nil {
-		return fmt.Errorf("creating comment table: %w", err)
+// sqliteFile returns the file of an SQLite DSN, or "" for an in-memory
+// database.
+func sqliteFile(dsn string) string {
+	path, params, _ := strings.Cut(strings.TrimPrefix(dsn, "file:"), "?")
+	if path == "" || path == ":memory:" || strings.Contains(params, "mode=memory") {
+		return ""
 	}
+	return path
+}
 
-	// Create index on comment.pasteid for efficient retrieval
-	indexSQL := d.createIndexSQL("idx_comment_pasteid", "comment", "pasteid")
-	if _, err := d.db.Exec(indexSQL); err != nil {
-		// Ignore error if index already exists
-		if !strings.Contains(err.Error(), "already exists") &&
-			!strings.Contains(err.Error(), "duplicate") {
-			return fmt.Errorf("creating comment index: %w", err)
+// defaultSQLiteBusyTimeout is the busy_timeout, in milliseconds, when
+// [model] sqlite_busy_timeout is 0, as with Configs built in code.
+const defaultSQLiteBusyTimeout = 5000
+
+// sqliteDSN adds the [model] SQLite pragmas to dsn as parameters of the
+// build's driver, which applies them to every connection it opens.
+// Pragmas the DSN sets itself are left alone, as is the journal mode of
+// in-memory databases.
+func sqliteDSN(dsn string, cfg config.ModelConfig) string {
+	_, params, _ := strings.Cut(dsn, "?")
+	query, _ := url.ParseQuery(params)
+
+	var add []string
+	set := func(pragma, value string) {
+		if value != "" && !sqlitePragmaSet(query, pragma) {
+			add = append(add, sqlitePragmaParam(pragma, strings.ToUpper(value)))
 		}
 	}
+	// The timeout first, so switching the journal mode waits for the lock.
+	// Always set, as the drivers' defaults differ (mattn waits 5s, modernc
+	// not at all).
+	busyTimeout := cfg.SQLiteBusyTimeout
+	if busyTimeout <= 0 {
+		busyTimeout = defaultSQLiteBusyTimeout
+	}
+	set("busy_timeout", strconv.Itoa(busyTimeout))
+	if sqliteFile(dsn) != "" {
+		set("journal_mode", cfg.SQLiteJournalMode)
+	}
+	set("synchronous", cfg.SQLiteSynchronous)
 
-	// Create config table for key-value storage
-	configSQL := fmt.Sprintf(`
-		CREATE TABLE IF NOT EXISTS config (
-			id VARCHAR(64) PRIMARY KEY,
-			value %s NOT NULL
-		)
-	`, textType)
-
-	if _, err := d.db.Exec(configSQL); err != nil {
-		return fmt.Errorf("creating config table: %w", err)
+	if len(add) == 0 {
+		return dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
 	}
+	return dsn + sep + strings.Join(add, "&")
+}
 
-	return nil
+// poolSettings sizes a connection pool.
+type poolSettings struct {
+	maxOpen     int
+	maxIdle     int
+	maxLifetime time.Duration // 0 = connections are reused forever
 }
 
-// textType returns the appropriate TEXT type for the database driver.
-func (d *Database) textType() string {
-	switch d.driver {
-	case "mysql":
-		return "MEDIUMTEXT" // Up to 16MB
-	default:
-		return "TEXT"
+// poolSettingsFor returns the [model] pool settings, with the driver's
+// defaults for those left at 0. SQLite connections are cheap to keep and
+// have no server to rebalance across, so idle ones are kept for good;
+// connections to a server are recycled every five minutes.
+func poolSettingsFor(cfg config.ModelConfig) poolSettings {
+	p := poolSettings{
+		maxOpen:     cfg.MaxOpenConns,
+		maxIdle:     cfg.MaxIdleConns,
+		maxLifetime: time.Duration(cfg.ConnMaxLifetime) * time.Second,
+	}
+	if p.maxOpen == 0 {
+		p.maxOpen = 25
+	}
+	if cfg.Driver == "sqlite3" {
+		if p.maxIdle == 0 {
+			p.maxIdle = p.maxOpen
+		}
+		return p
 	}
+	if p.maxIdle == 0 {
+		p.maxIdle = 5
+	}
+	if p.maxLifetime == 0 {
+		p.maxLifetime = 5 * time.Minute
+	}
+	return p
 }
 
-// autoIncrement returns the auto-increment syntax for the database.
-func (d *Database) autoIncrement() string {
-	switch d.driver {
-	case "postgres":
-		return "SERIAL"
-	case "mysql":
-		return "AUTO_INCREMENT"
-	default: // sqlite3
-		return "AUTOINCREMENT"
-	}
+// isDuplicate reports whether err is a unique constraint violation.
+func isDuplicate(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE") ||
+		strings.Contains(err.Error(), "duplicate") ||
+		strings.Contains(err.Error(), "Duplicate")
 }
 
-// createIndexSQL returns database-specific CREATE INDEX syntax.
-func (d *Database) createIndexSQL(name, table, column string) string {
-	switch d.driver {
-	case "postgres":
-		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, column)
-	default:
-		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", name, table, column)
+// openDB opens a connection pool and verifies the database is reachable.
+func openDB(driver, dsn string, pool poolSettings) (*sql.DB, error) {
+	if driver == "sqlite3" {
+		driver = sqliteDriver
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	// Verify connection works
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to database: %w", err)
 	}
+
+	// Configure connection pool
+	db.SetMaxOpenConns(pool.maxOpen)
+	db.SetMaxIdleConns(pool.maxIdle)
+	db.SetConnMaxLifetime(pool.maxLifetime)
+
+	return db, nil
 }
 
 // placeholder returns the appropriate placeholder for the database.
@@ -192,13 +276,42 @@ func (d *Database) placeholders(count int) string {
 	return strings.Repeat("?, ", count-1) + "?"
 }
 
+// db returns the current connection pool.
+func (d *Database) db() *sql.DB {
+	return d.pool.Load().db
+}
+
+// lockWrites serializes writes on SQLite, which allows a single writer at
+// a time: a transaction that reads before writing fails with SQLITE_BUSY,
+// instead of waiting, if another one writes first. The server, PostgreSQL,
+// and MySQL lock rows themselves, so there it is a no-op. Returns the
+// unlock function.
+func (d *Database) lockWrites() func() {
+	if d.driver != "sqlite3" {
+		return func() {}
+	}
+	d.writeMu.Lock()
+	return d.writeMu.Unlock
+}
+
+// queryContext bounds ctx by [model] query_timeout for one operation.
+// Paged reads and chunked attachments apply it to each page or chunk.
+func (d *Database) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d.queryTimeout)
+}
+
 // CreatePaste stores a new paste in the database.
-func (d *Database) CreatePaste(id string, paste *model.Paste) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (d *Database) CreatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	// Serialize metadata to JSON
-	metaJSON, err := json.Marshal(paste.Meta)
+	metaJSON, err := json.Marshal(storedMeta(paste.Meta))
 	if err != nil {
 		return fmt.Errorf("serializing paste meta: %w", err)
 	}
@@ -206,15 +319,15 @@ func (d *Database) CreatePaste(id string, paste *model.Paste) error {
 	// Combine data fields for storage
 	// PrivateBin stores the full paste object as JSON in the data field
 	storageData := struct {
-		Data           string          `json:"data"`
-		AttachmentName string          `json:"attachmentname,omitempty"`
-		Attachment     string          `json:"attachment,omitempty"`
-		AData          json.RawMessage `json:"adata,omitempty"`
-		Version        int             `json:"v"`
+		Data           string           `json:"data"`
+		AttachmentName model.StringList `json:"attachmentname,omitempty"`
+		Attachment     model.StringList `json:"attachment,omitempty"`
+		AData          json.RawMessage  `json:"adata,omitempty"`
+		Version        int              `json:"v"`
 	}{
 		Data:           paste.Data,
-		AttachmentName: paste.AttachmentName,
-		Attachment:     paste.Attachment,
+		AttachmentName: paste.AttachmentNames,
+		Attachment:     paste.Attachments,
 		AData:          paste.AData,
 		Version:        paste.Version,
 	}
@@ -228,11 +341,9 @@ func (d *Database) CreatePaste(id string, paste *model.Paste) error {
 		d.placeholders(4),
 	)
 
-	_, err = d.db.Exec(query, id, string(dataJSON), paste.Meta.ExpireDate, string(metaJSON))
+	_, err = d.db().ExecContext(ctx, query, id, string(dataJSON), paste.Meta.ExpiresAt(), string(metaJSON))
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE") ||
-			strings.Contains(err.Error(), "duplicate") ||
-			strings.Contains(err.Error(), "Duplicate") {
+		if isDuplicate(err) {
 			return model.ErrPasteExists
 		}
 		return fmt.Errorf("inserting paste: %w", err)
@@ -242,9 +353,9 @@ func (d *Database) CreatePaste(id string, paste *model.Paste) error {
 }
 
 // ReadPaste retrieves a paste from the database.
-func (d *Database) ReadPaste(id string) (*model.Paste, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+func (d *Database) ReadPaste(ctx context.Context, id string) (*model.Paste, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	query := fmt.Sprintf(
 		"SELECT data, expiredate, meta FROM paste WHERE dataid = %s",
@@ -254,7 +365,7 @@ func (d *Database) ReadPaste(id string) (*model.Paste, error) {
 	var dataJSON, metaJSON string
 	var expireDate sql.NullInt64
 
-	err := d.db.QueryRow(query, id).Scan(&dataJSON, &expireDate, &metaJSON)
+	err := d.queryRow(ctx, query, id).Scan(&dataJSON, &expireDate, &metaJSON)
 	if err == sql.ErrNoRows {
 		return nil, model.ErrPasteNotFound
 	}
@@ -262,72 +373,89 @@ func (d *Database) ReadPaste(id string) (*model.Paste, error) {
 		return nil, fmt.Errorf("querying paste: %w", err)
 	}
 
+	paste, err := decodePaste(id, dataJSON, metaJSON, expireDate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if expired
+	if paste.IsExpiredAt(d.clock.Now()) {
+		// Delete the expired paste
+		d.DeletePaste(ctx, id)
+		return nil, model.ErrPasteExpired
+	}
+
+	return paste, nil
+}
+
+// decodePaste builds a paste from its row's data, meta, and expiredate
+// columns.
+func decodePaste(id, dataJSON, metaJSON string, expireDate sql.NullInt64) (*model.Paste, error) {
 	// Deserialize data
 	var storageData struct {
-		Data           string          `json:"data"`
-		AttachmentName string          `json:"attachmentname,omitempty"`
-		Attachment     string          `json:"attachment,omitempty"`
-		AData          json.RawMessage `json:"adata,omitempty"`
-		Version        int             `json:"v"`
+		Data           string           `json:"data"`
+		AttachmentName model.StringList `json:"attachmentname,omitempty"`
+		Attachment     model.StringList `json:"attachment,omitempty"`
+		AData          json.RawMessage  `json:"adata,omitempty"`
+		Version        int              `json:"v"`
 	}
 	if err := json.Unmarshal([]byte(dataJSON), &storageData); err != nil {
 		return nil, fmt.Errorf("deserializing paste data: %w", err)
 	}
 
 	// Deserialize metadata
-	var meta model.PasteMeta
-	if err := json.Unmarshal([]byte(metaJSON), &meta); err != nil {
+	var stored pasteMeta
+	if err := json.Unmarshal([]byte(metaJSON), &stored); err != nil {
 		return nil, fmt.Errorf("deserializing paste meta: %w", err)
 	}
+	meta := stored.meta()
 
-	// Set expire date from database column (more reliable than JSON)
-	if expireDate.Valid {
+	// Set expire date from database column (more reliable than JSON).
+	// Pinned pastes have no column value; their meta keeps the original.
+	if expireDate.Valid && !meta.Pinned {
 		meta.ExpireDate = expireDate.Int64
 	}
 
 	paste := &model.Paste{
-		ID:             id,
-		Data:           storageData.Data,
-		AttachmentName: storageData.AttachmentName,
-		Attachment:     storageData.Attachment,
-		AData:          storageData.AData,
-		Version:        storageData.Version,
-		Meta:           meta,
-	}
-
-	// Check if expired
-	if paste.IsExpired() {
-		// Delete the expired paste (don't hold lock for delete)
-		d.mu.RUnlock()
-		d.DeletePaste(id)
-		d.mu.RLock()
-		return nil, model.ErrPasteExpired
+		ID:              id,
+		Data:            storageData.Data,
+		AttachmentNames: storageData.AttachmentName,
+		Attachments:     storageData.Attachment,
+		AData:           storageData.AData,
+		Version:         storageData.Version,
+		Meta:            meta,
 	}
-
 	return paste, nil
 }
 
 // DeletePaste removes a paste and all its comments from the database.
-func (d *Database) DeletePaste(id string) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (d *Database) DeletePaste(ctx context.Context, id string) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	// Start transaction
-	tx, err := d.db.Begin()
+	tx, err := d.db().BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("starting transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Delete comments first (foreign key-like behavior)
-	commentQuery := fmt.Sprintf("DELETE FROM comment WHERE pasteid = %s", d.placeholder(1))
-	if _, err := tx.Exec(commentQuery, id); err != nil {
-		return fmt.Errorf("deleting comments: %w", err)
+	// Delete comments and receipt first (foreign key-like behavior)
+	if err := d.deleteRelated(ctx, tx, id); err != nil {
+		return err
+	}
+
+	// Delete attachment chunks
+	attachmentQuery := fmt.Sprintf("DELETE FROM attachment WHERE dataid = %s", d.placeholder(1))
+	if _, err := tx.ExecContext(ctx, attachmentQuery, id); err != nil {
+		return fmt.Errorf("deleting attachment: %w", err)
 	}
 
 	// Delete paste
 	pasteQuery := fmt.Sprintf("DELETE FROM paste WHERE dataid = %s", d.placeholder(1))
-	result, err := tx.Exec(pasteQuery, id)
+	result, err := tx.ExecContext(ctx, pasteQuery, id)
 	if err != nil {
 		return fmt.Errorf("deleting paste: %w", err)
 	}
@@ -343,24 +471,258 @@ func (d *Database) DeletePaste(id string) error {
 	return tx.Commit()
 }
 
+// ReadAndDeletePaste reads and deletes a paste in one transaction. The
+// delete is what claims the paste, so of concurrent callers, even on other
+// instances sharing the database, only one gets it back.
+func (d *Database) ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error) {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	tx, err := d.db().BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var dataJSON, metaJSON string
+	var expireDate sql.NullInt64
+	if d.driver == "mysql" {
+		// No DELETE ... RETURNING; lock the row until it is deleted instead
+		query := fmt.Sprintf(
+			"SELECT data, expiredate, meta FROM paste WHERE dataid = %s FOR UPDATE",
+			d.placeholder(1),
+		)
+		err = tx.QueryRowContext(ctx, query, id).Scan(&dataJSON, &expireDate, &metaJSON)
+		if err == nil {
+			query = fmt.Sprintf("DELETE FROM paste WHERE dataid = %s", d.placeholder(1))
+			_, err = tx.ExecContext(ctx, query, id)
+		}
+	} else {
+		query := fmt.Sprintf(
+			"DELETE FROM paste WHERE dataid = %s RETURNING data, expiredate, meta",
+			d.placeholder(1),
+		)
+		err = tx.QueryRowContext(ctx, query, id).Scan(&dataJSON, &expireDate, &metaJSON)
+	}
+	if err == sql.ErrNoRows {
+		return nil, model.ErrPasteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("deleting paste: %w", err)
+	}
+
+	if err := d.deleteRelated(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("committing transaction: %w", err)
+	}
+
+	paste, err := decodePaste(id, dataJSON, metaJSON, expireDate)
+	if err != nil {
+		return nil, err
+	}
+	if paste.IsExpiredAt(d.clock.Now()) {
+		query := fmt.Sprintf("DELETE FROM attachment WHERE dataid = %s", d.placeholder(1))
+		if _, err := d.db().ExecContext(ctx, query, id); err != nil {
+			return nil, fmt.Errorf("deleting attachments: %w", err)
+		}
+		return nil, model.ErrPasteExpired
+	}
+	return paste, nil
+}
+
+// CountRead uses up one of a paste's reads. The count is taken down with
+// the row locked, so instances sharing the database count together. On
+// SQLite the read before it isn't serialized by lockWrites and relies on
+// busy_timeout (see sqliteDSN) to wait out a committing writer.
+func (d *Database) CountRead(ctx context.Context, id string) (*model.Paste, error) {
+	return countRead(ctx, d, id, func(update func(meta *pasteMeta)) error {
+		return d.updateMeta(ctx, id, update)
+	})
+}
+
+// deleteRelated deletes a paste's comments, comment counter, and read
+// receipt as part of tx.
+func (d *Database) deleteRelated(ctx context.Context, tx *sql.Tx, id string) error {
+	commentQuery := fmt.Sprintf("DELETE FROM comment WHERE pasteid = %s", d.placeholder(1))
+	if _, err := tx.ExecContext(ctx, commentQuery, id); err != nil {
+		return fmt.Errorf("deleting comments: %w", err)
+	}
+
+	countQuery := fmt.Sprintf("DELETE FROM commentcount WHERE pasteid = %s", d.placeholder(1))
+	if _, err := tx.ExecContext(ctx, countQuery, id); err != nil {
+		return fmt.Errorf("deleting comment count: %w", err)
+	}
+
+	receiptQuery := fmt.Sprintf("DELETE FROM receipt WHERE dataid = %s", d.placeholder(1))
+	if _, err := tx.ExecContext(ctx, receiptQuery, id); err != nil {
+		return fmt.Errorf("deleting read receipt: %w", err)
+	}
+	return nil
+}
+
+// UpdatePaste replaces a paste's content. The row is locked from the read
+// to the write, like updateMeta's, as the formatter lives in meta.
+func (d *Database) UpdatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	tx, err := d.db().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("SELECT data, meta FROM paste WHERE dataid = %s", d.placeholder(1))
+	if d.driver != "sqlite3" {
+		query += " FOR UPDATE"
+	}
+	var dataJSON, metaJSON string
+	err = tx.QueryRowContext(ctx, query, id).Scan(&dataJSON, &metaJSON)
+	if err == sql.ErrNoRows {
+		return model.ErrPasteNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("querying paste: %w", err)
+	}
+
+	var storageData struct {
+		Data           string           `json:"data"`
+		AttachmentName model.StringList `json:"attachmentname,omitempty"`
+		Attachment     model.StringList `json:"attachment,omitempty"`
+		AData          json.RawMessage  `json:"adata,omitempty"`
+		Version        int              `json:"v"`
+	}
+	if err := json.Unmarshal([]byte(dataJSON), &storageData); err != nil {
+		return fmt.Errorf("deserializing paste data: %w", err)
+	}
+	var meta pasteMeta
+	if err := json.Unmarshal([]byte(metaJSON), &meta); err != nil {
+		return fmt.Errorf("deserializing paste meta: %w", err)
+	}
+	storageData.Data = paste.Data
+	storageData.AData = paste.AData
+	storageData.Version = paste.Version
+	meta.Formatter = paste.Meta.Formatter
+
+	data, err := json.Marshal(storageData)
+	if err != nil {
+		return fmt.Errorf("serializing paste data: %w", err)
+	}
+	updated, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("serializing paste meta: %w", err)
+	}
+	query = fmt.Sprintf(
+		"UPDATE paste SET data = %s, meta = %s WHERE dataid = %s",
+		d.placeholder(1), d.placeholder(2), d.placeholder(3),
+	)
+	if _, err := tx.ExecContext(ctx, query, string(data), string(updated), id); err != nil {
+		return fmt.Errorf("updating paste: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// SetPinned updates a paste's Pinned flag. The expiredate column, which
+// purging queries, is cleared while the paste is pinned and restored from
+// meta when it is unpinned.
+func (d *Database) SetPinned(ctx context.Context, id string, pinned bool) error {
+	return d.updateMeta(ctx, id, func(meta *pasteMeta) { meta.Pinned = pinned })
+}
+
+// SetDisabled updates a paste's Disabled flag.
+func (d *Database) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	return d.updateMeta(ctx, id, func(meta *pasteMeta) { meta.Disabled = disabled })
+}
+
+// SetExpireDate updates a paste's expiration date, in meta and in the
+// expiredate column unless the paste is pinned.
+func (d *Database) SetExpireDate(ctx context.Context, id string, expireDate int64) error {
+	return d.updateMeta(ctx, id, func(meta *pasteMeta) { meta.ExpireDate = expireDate })
+}
+
+// updateMeta rewrites a paste's meta as changed by update, and its
+// expiredate column to match. The row is locked from the read to the
+// write, so concurrent updates of different flags don't undo each other.
+func (d *Database) updateMeta(ctx context.Context, id string, update func(meta *pasteMeta)) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	tx, err := d.db().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("SELECT meta FROM paste WHERE dataid = %s", d.placeholder(1))
+	if d.driver != "sqlite3" {
+		query += " FOR UPDATE" // SQLite writers are serialized already
+	}
+	var metaJSON string
+	err = tx.QueryRowContext(ctx, query, id).Scan(&metaJSON)
+	if err == sql.ErrNoRows {
+		return model.ErrPasteNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("querying paste: %w", err)
+	}
+
+	// Unmarshal into the persisted form so the salt survives the rewrite
+	var meta pasteMeta
+	if err := json.Unmarshal([]byte(metaJSON), &meta); err != nil {
+		return fmt.Errorf("deserializing paste meta: %w", err)
+	}
+	update(&meta)
+	updated, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("serializing paste meta: %w", err)
+	}
+
+	query = fmt.Sprintf(
+		"UPDATE paste SET meta = %s, expiredate = %s WHERE dataid = %s",
+		d.placeholder(1), d.placeholder(2), d.placeholder(3),
+	)
+	if _, err := tx.ExecContext(ctx, query, string(updated), meta.ExpiresAt(), id); err != nil {
+		return fmt.Errorf("updating paste: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
 // PasteExists checks if a paste exists in the database.
-func (d *Database) PasteExists(id string) bool {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+func (d *Database) PasteExists(ctx context.Context, id string) bool {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	query := fmt.Sprintf("SELECT 1 FROM paste WHERE dataid = %s", d.placeholder(1))
 	var exists int
-	err := d.db.QueryRow(query, id).Scan(&exists)
+	err := d.queryRow(ctx, query, id).Scan(&exists)
 	return err == nil
 }
 
 // CreateComment stores a new comment in the database.
-func (d *Database) CreateComment(pasteID, parentID, commentID string, comment *model.Comment) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// The comment insert and counter increment share a transaction, so a
+// failed insert never leaves the counter ahead of the comment table.
+func (d *Database) CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	// Verify paste exists
-	if !d.pasteExistsUnsafe(pasteID) {
+	if !d.PasteExists(ctx, pasteID) {
 		return model.ErrPasteNotFound
 	}
 
@@ -369,44 +731,114 @@ func (d *Database) CreateComment(pasteID, parentID, commentID string, comment *m
 		Data    string          `json:"data"`
 		AData   json.RawMessage `json:"adata,omitempty"`
 		Version int             `json:"v"`
+		Flagged bool            `json:"flagged,omitempty"`
 	}{
 		Data:    comment.Data,
 		AData:   comment.AData,
 		Version: comment.Version,
+		Flagged: comment.Meta.Flagged,
 	})
 	if err != nil {
 		return fmt.Errorf("serializing comment: %w", err)
 	}
 
+	// Start transaction
+	tx, err := d.db().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := d.incrementCommentCount(ctx, tx, pasteID); err != nil {
+		return err
+	}
+
 	query := fmt.Sprintf(
 		"INSERT INTO comment (dataid, pasteid, parentid, data, vizhash, postdate) VALUES (%s)",
 		d.placeholders(6),
 	)
 
-	_, err = d.db.Exec(query, commentID, pasteID, parentID, string(dataJSON), comment.Vizhash, comment.Meta.PostDate)
+	_, err = tx.ExecContext(ctx, query, commentID, pasteID, parentID, string(dataJSON), comment.Vizhash, comment.Meta.PostDate)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE") ||
-			strings.Contains(err.Error(), "duplicate") ||
-			strings.Contains(err.Error(), "Duplicate") {
+		if isDuplicate(err) {
 			return model.ErrCommentExists
 		}
 		return fmt.Errorf("inserting comment: %w", err)
 	}
 
+	return tx.Commit()
+}
+
+// incrementCommentCount bumps a paste's comment counter within tx.
+// Returns model.ErrCommentLimitReached if the counter is already at the limit.
+func (d *Database) incrementCommentCount(ctx context.Context, tx *sql.Tx, pasteID string) error {
+	// Seed the counter from existing comments the first time a paste is
+	// commented on, which also covers databases created before counters
+	var seed string
+	switch d.driver {
+	case "sqlite3":
+		seed = "INSERT OR IGNORE INTO commentcount (pasteid, total) SELECT %s, COUNT(*) FROM comment WHERE pasteid = %s"
+	case "postgres":
+		seed = "INSERT INTO commentcount (pasteid, total) SELECT %s, COUNT(*) FROM comment WHERE pasteid = %s ON CONFLICT (pasteid) DO NOTHING"
+	case "mysql":
+		seed = "INSERT IGNORE INTO commentcount (pasteid, total) SELECT %s, COUNT(*) FROM comment WHERE pasteid = %s"
+	}
+	seed = fmt.Sprintf(seed, d.placeholder(1), d.placeholder(2))
+	if _, err := tx.ExecContext(ctx, seed, pasteID, pasteID); err != nil {
+		return fmt.Errorf("seeding comment count: %w", err)
+	}
+
+	// The conditional update locks the counter row and enforces the limit
+	query := fmt.Sprintf(
+		"UPDATE commentcount SET total = total + 1 WHERE pasteid = %s AND (%s = 0 OR total < %s)",
+		d.placeholder(1), d.placeholder(2), d.placeholder(3),
+	)
+	result, err := tx.ExecContext(ctx, query, pasteID, d.commentLimit, d.commentLimit)
+	if err != nil {
+		return fmt.Errorf("updating comment count: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("checking rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return model.ErrCommentLimitReached
+	}
 	return nil
 }
 
+// CountComments returns the number of comments on a paste.
+func (d *Database) CountComments(ctx context.Context, pasteID string) (int, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf("SELECT total FROM commentcount WHERE pasteid = %s", d.placeholder(1))
+
+	var total int
+	err := d.queryRow(ctx, query, pasteID).Scan(&total)
+	if err == sql.ErrNoRows {
+		// Never commented on since counters were introduced
+		query = fmt.Sprintf("SELECT COUNT(*) FROM comment WHERE pasteid = %s", d.placeholder(1))
+		err = d.queryRow(ctx, query, pasteID).Scan(&total)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("counting comments: %w", err)
+	}
+	return total, nil
+}
+
 // ReadComments retrieves all comments for a paste.
-func (d *Database) ReadComments(pasteID string) ([]*model.Comment, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+func (d *Database) ReadComments(ctx context.Context, pasteID string) ([]*model.Comment, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	query := fmt.Sprintf(
 		"SELECT dataid, parentid, data, vizhash, postdate FROM comment WHERE pasteid = %s ORDER BY postdate ASC",
 		d.placeholder(1),
 	)
 
-	rows, err := d.db.Query(query, pasteID)
+	rows, err := d.db().QueryContext(ctx, query, pasteID)
 	if err != nil {
 		return nil, fmt.Errorf("querying comments: %w", err)
 	}
@@ -414,37 +846,9 @@ func (d *Database) ReadComments(pasteID string) ([]*model.Comment, error) {
 
 	var comments []*model.Comment
 	for rows.Next() {
-		var id string
-		var parentID sql.NullString
-		var dataJSON string
-		var vizhash sql.NullString
-		var postDate int64
-
-		if err := rows.Scan(&id, &parentID, &dataJSON, &vizhash, &postDate); err != nil {
-			return nil, fmt.Errorf("scanning comment row: %w", err)
-		}
-
-		// Deserialize comment data
-		var data struct {
-			Data    string          `json:"data"`
-			AData   json.RawMessage `json:"adata,omitempty"`
-			Version int             `json:"v"`
-		}
-		if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-			return nil, fmt.Errorf("deserializing comment: %w", err)
-		}
-
-		comment := &model.Comment{
-			ID:       id,
-			PasteID:  pasteID,
-			ParentID: parentID.String,
-			Data:     data.Data,
-			AData:    data.AData,
-			Version:  data.Version,
-			Vizhash:  vizhash.String,
-			Meta: model.CommentMeta{
-				PostDate: postDate,
-			},
+		comment, err := scanComment(rows, pasteID)
+		if err != nil {
+			return nil, err
 		}
 		comments = append(comments, comment)
 	}
@@ -456,21 +860,213 @@ func (d *Database) ReadComments(pasteID string) ([]*model.Comment, error) {
 	return comments, nil
 }
 
-// CommentExists checks if a comment exists.
-func (d *Database) CommentExists(pasteID, parentID, commentID string) bool {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-
-	query := fmt.Sprintf("SELECT 1 FROM comment WHERE dataid = %s AND pasteid = %s", d.placeholder(1), d.placeholder(2))
-	var exists int
-	err := d.db.QueryRow(query, commentID, pasteID).Scan(&exists)
-	return err == nil
-}
+// iteratePageSize is the number of rows IterateComments and IteratePastes
+// read at a time.
+const iteratePageSize = 100
 
-// SetValue stores a key-value pair in the config table.
-func (d *Database) SetValue(namespace, key, value string) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+// IterateComments calls fn for each comment on a paste, oldest first. The
+// comments are read a page at a time, and no rows are open while fn runs,
+// so fn may use the database.
+func (d *Database) IterateComments(ctx context.Context, pasteID string, fn func(*model.Comment) error) error {
+	query := fmt.Sprintf(
+		"SELECT dataid, parentid, data, vizhash, postdate FROM comment WHERE pasteid = %s "+
+			"AND (postdate > %s OR (postdate = %s AND dataid > %s)) ORDER BY postdate ASC, dataid ASC LIMIT %d",
+		d.placeholder(1), d.placeholder(2), d.placeholder(3), d.placeholder(4), iteratePageSize,
+	)
+
+	// Pages continue after the last comment of the previous one
+	var lastDate int64 = -1
+	lastID := ""
+	for {
+		page, err := d.commentPage(ctx, query, pasteID, lastDate, lastID)
+		if err != nil {
+			return err
+		}
+		for _, c := range page {
+			if err := fn(c); err != nil {
+				return err
+			}
+		}
+		if len(page) < iteratePageSize {
+			return nil
+		}
+		last := page[len(page)-1]
+		lastDate, lastID = last.Meta.PostDate, last.ID
+	}
+}
+
+// commentPage reads a page of IterateComments.
+func (d *Database) commentPage(ctx context.Context, query, pasteID string, lastDate int64, lastID string) ([]*model.Comment, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	rows, err := d.db().QueryContext(ctx, query, pasteID, lastDate, lastDate, lastID)
+	if err != nil {
+		return nil, fmt.Errorf("querying comments: %w", err)
+	}
+	defer rows.Close()
+
+	var page []*model.Comment
+	for rows.Next() {
+		comment, err := scanComment(rows, pasteID)
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating comments: %w", err)
+	}
+	return page, nil
+}
+
+// scanComment reads a comment from a row of dataid, parentid, data,
+// vizhash, and postdate.
+func scanComment(rows *sql.Rows, pasteID string) (*model.Comment, error) {
+	var id string
+	var parentID sql.NullString
+	var dataJSON string
+	var vizhash sql.NullString
+	var postDate int64
+
+	if err := rows.Scan(&id, &parentID, &dataJSON, &vizhash, &postDate); err != nil {
+		return nil, fmt.Errorf("scanning comment row: %w", err)
+	}
+
+	// Deserialize comment data
+	var data struct {
+		Data    string          `json:"data"`
+		AData   json.RawMessage `json:"adata,omitempty"`
+		Version int             `json:"v"`
+		Flagged bool            `json:"flagged,omitempty"`
+	}
+	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
+		return nil, fmt.Errorf("deserializing comment: %w", err)
+	}
+
+	return &model.Comment{
+		ID:       id,
+		PasteID:  pasteID,
+		ParentID: parentID.String,
+		Data:     data.Data,
+		AData:    data.AData,
+		Version:  data.Version,
+		Vizhash:  vizhash.String,
+		Meta: model.CommentMeta{
+			PostDate: postDate,
+			Flagged:  data.Flagged,
+		},
+	}, nil
+}
+
+// CommentExists checks if a comment exists.
+func (d *Database) CommentExists(ctx context.Context, pasteID, parentID, commentID string) bool {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf("SELECT 1 FROM comment WHERE dataid = %s AND pasteid = %s", d.placeholder(1), d.placeholder(2))
+	var exists int
+	err := d.queryRow(ctx, query, commentID, pasteID).Scan(&exists)
+	return err == nil
+}
+
+// DeleteComment removes a comment from the database.
+// The delete and counter decrement share a transaction, so the counter
+// never drops without the comment going with it.
+func (d *Database) DeleteComment(ctx context.Context, pasteID, commentID string) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	tx, err := d.db().BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("DELETE FROM comment WHERE dataid = %s AND pasteid = %s", d.placeholder(1), d.placeholder(2))
+	result, err := tx.ExecContext(ctx, query, commentID, pasteID)
+	if err != nil {
+		return fmt.Errorf("deleting comment: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("checking rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return model.ErrCommentNotFound
+	}
+
+	// Pastes without a counter row are counted from the comment table
+	query = fmt.Sprintf("UPDATE commentcount SET total = total - 1 WHERE pasteid = %s AND total > 0", d.placeholder(1))
+	if _, err := tx.ExecContext(ctx, query, pasteID); err != nil {
+		return fmt.Errorf("updating comment count: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// MarkRead records the first read of a paste.
+// The insert is ignored if a receipt already exists, so concurrent
+// readers agree on a single first read.
+func (d *Database) MarkRead(ctx context.Context, id string) (bool, error) {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	if !d.PasteExists(ctx, id) {
+		return false, model.ErrPasteNotFound
+	}
+
+	var query string
+	switch d.driver {
+	case "sqlite3":
+		query = "INSERT OR IGNORE INTO receipt (dataid, firstread) VALUES (%s)"
+	case "postgres":
+		query = "INSERT INTO receipt (dataid, firstread) VALUES (%s) ON CONFLICT (dataid) DO NOTHING"
+	case "mysql":
+		query = "INSERT IGNORE INTO receipt (dataid, firstread) VALUES (%s)"
+	}
+	query = fmt.Sprintf(query, d.placeholders(2))
+
+	result, err := d.db().ExecContext(ctx, query, id, d.clock.Now().Unix())
+	if err != nil {
+		return false, fmt.Errorf("recording read receipt: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("checking rows affected: %w", err)
+	}
+	return rowsAffected == 1, nil
+}
+
+// GetReadReceipt retrieves the read receipt for a paste.
+func (d *Database) GetReadReceipt(ctx context.Context, id string) (*model.ReadReceipt, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	if !d.PasteExists(ctx, id) {
+		return nil, model.ErrPasteNotFound
+	}
+
+	receipt := &model.ReadReceipt{PasteID: id}
+	query := fmt.Sprintf("SELECT firstread FROM receipt WHERE dataid = %s", d.placeholder(1))
+	err := d.queryRow(ctx, query, id).Scan(&receipt.FirstRead)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("querying read receipt: %w", err)
+	}
+	return receipt, nil
+}
+
+// SetValue stores a key-value pair in the config table.
+func (d *Database) SetValue(ctx context.Context, namespace, key, value string) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	id := namespace + "_" + key
 
@@ -494,7 +1090,7 @@ func (d *Database) SetValue(namespace, key, value string) error {
 		)
 	}
 
-	_, err := d.db.Exec(query, id, value)
+	_, err := d.db().ExecContext(ctx, query, id, value)
 	if err != nil {
 		return fmt.Errorf("setting value: %w", err)
 	}
@@ -502,15 +1098,15 @@ func (d *Database) SetValue(namespace, key, value string) error {
 }
 
 // GetValue retrieves a value from the config table.
-func (d *Database) GetValue(namespace, key string) (string, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+func (d *Database) GetValue(ctx context.Context, namespace, key string) (string, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	id := namespace + "_" + key
 	query := fmt.Sprintf("SELECT value FROM config WHERE id = %s", d.placeholder(1))
 
 	var value string
-	err := d.db.QueryRow(query, id).Scan(&value)
+	err := d.queryRow(ctx, query, id).Scan(&value)
 	if err == sql.ErrNoRows {
 		return "", nil
 	}
@@ -520,12 +1116,56 @@ func (d *Database) GetValue(namespace, key string) (string, error) {
 	return value, nil
 }
 
+// CompareAndSwapValue sets a value if it still holds old, in a single
+// conditional statement: of two instances swapping from the same value,
+// one updates no row.
+func (d *Database) CompareAndSwapValue(ctx context.Context, namespace, key, old, new string) (bool, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	if old == new {
+		// MySQL reports unchanged rows as unaffected
+		current, err := d.GetValue(ctx, namespace, key)
+		return err == nil && current == old, err
+	}
+
+	unlock := d.lockWrites()
+	defer unlock()
+
+	id := namespace + "_" + key
+	if old == "" {
+		query := fmt.Sprintf("INSERT INTO config (id, value) VALUES (%s)", d.placeholders(2))
+		_, err := d.db().ExecContext(ctx, query, id, new)
+		if err == nil {
+			return true, nil
+		}
+		if !isDuplicate(err) {
+			return false, fmt.Errorf("setting value: %w", err)
+		}
+		// Stored, but maybe empty, which also counts as none
+	}
+
+	query := fmt.Sprintf(
+		"UPDATE config SET value = %s WHERE id = %s AND value = %s",
+		d.placeholder(1), d.placeholder(2), d.placeholder(3),
+	)
+	result, err := d.db().ExecContext(ctx, query, new, id, old)
+	if err != nil {
+		return false, fmt.Errorf("swapping value: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("swapping value: %w", err)
+	}
+	return n == 1, nil
+}
+
 // GetExpiredPastes returns a list of expired paste IDs.
-func (d *Database) GetExpiredPastes(batchSize int) ([]string, error) {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+func (d *Database) GetExpiredPastes(ctx context.Context, batchSize int) ([]string, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
-	now := time.Now().Unix()
+	now := d.clock.Now().Unix()
 
 	var query string
 	switch d.driver {
@@ -541,7 +1181,7 @@ func (d *Database) GetExpiredPastes(batchSize int) ([]string, error) {
 		)
 	}
 
-	rows, err := d.db.Query(query, now, batchSize)
+	rows, err := d.db().QueryContext(ctx, query, now, batchSize)
 	if err != nil {
 		return nil, fmt.Errorf("querying expired pastes: %w", err)
 	}
@@ -559,44 +1199,82 @@ func (d *Database) GetExpiredPastes(batchSize int) ([]string, error) {
 	return ids, rows.Err()
 }
 
-// Purge deletes expired pastes.
-func (d *Database) Purge(batchSize int) (int, error) {
-	ids, err := d.GetExpiredPastes(batchSize)
+// Purge deletes up to batchSize expired pastes, oldest first, with their
+// comments, counters, receipts, and attachments, in one transaction of a
+// statement per table.
+func (d *Database) Purge(ctx context.Context, batchSize int) (int, error) {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	// The batch is selected through a derived table: MySQL allows neither
+	// LIMIT in an IN subquery nor a subquery on the table being deleted
+	// from, unless it is wrapped. Every statement selects the same batch.
+	expired := fmt.Sprintf(
+		"SELECT dataid FROM (SELECT dataid FROM paste WHERE expiredate > 0 AND expiredate < %s "+
+			"ORDER BY expiredate ASC, dataid ASC LIMIT %s) expired",
+		d.placeholder(1), d.placeholder(2),
+	)
+	now := d.clock.Now().Unix()
+
+	tx, err := d.db().BeginTx(ctx, nil)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("starting transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	count := 0
-	for _, id := range ids {
-		if err := d.DeletePaste(id); err != nil && err != model.ErrPasteNotFound {
-			return count, err
+	for _, related := range []struct{ table, column string }{
+		{"comment", "pasteid"},
+		{"commentcount", "pasteid"},
+		{"receipt", "dataid"},
+		{"attachment", "dataid"},
+	} {
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s IN (%s)", related.table, related.column, expired)
+		if _, err := tx.ExecContext(ctx, query, now, batchSize); err != nil {
+			return 0, fmt.Errorf("purging %s: %w", related.table, err)
 		}
-		count++
 	}
 
-	return count, nil
+	result, err := tx.ExecContext(ctx, "DELETE FROM paste WHERE dataid IN ("+expired+")", now, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("purging pastes: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("checking rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
+	return int(purged), nil
 }
 
 // PurgeValues removes old traffic limiter entries.
-func (d *Database) PurgeValues(namespace string, maxAge int64) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (d *Database) PurgeValues(ctx context.Context, namespace string, maxAge int64) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
 
 	// Traffic values are stored with timestamps as values
 	// We need to delete entries where the timestamp is older than maxAge
 	prefix := namespace + "_"
-	cutoff := time.Now().Unix() - maxAge
+	cutoff := d.clock.Now().Unix() - maxAge
 
 	// This is a simplified approach - in production, you might want to
 	// parse the values to check timestamps
+	// Timestamps past maxUnixSeconds are in milliseconds
 	query := fmt.Sprintf(
-		"DELETE FROM config WHERE id LIKE %s AND CAST(value AS BIGINT) < %s",
-		d.placeholder(1), d.placeholder(2),
+		"DELETE FROM config WHERE id LIKE %s AND (CAST(value AS BIGINT) < %s OR "+
+			"(CAST(value AS BIGINT) > %s AND CAST(value AS BIGINT) < %s))",
+		d.placeholder(1), d.placeholder(2), d.placeholder(3), d.placeholder(4),
 	)
 
 	// Note: This query may not work on all databases due to CAST syntax
 	// For production, consider storing timestamp in a separate column
-	_, err := d.db.Exec(query, prefix+"%", cutoff)
+	_, err := d.db().ExecContext(ctx, query, prefix+"%", cutoff, int64(maxUnixSeconds), cutoff*1000)
 	if err != nil {
 		// Silently ignore errors - this is a cleanup operation
 		return nil
@@ -604,16 +1282,489 @@ func (d *Database) PurgeValues(namespace string, maxAge int64) error {
 	return nil
 }
 
+// attachmentChunkSize is the size of the attachment table's chunks.
+const attachmentChunkSize = 1 << 20
+
+// WriteAttachment stores an attachment chunk by chunk. Each chunk is
+// inserted on its own, so a slow upload doesn't hold up other writers on
+// SQLite; the paste, written afterwards, makes it visible.
+func (d *Database) WriteAttachment(ctx context.Context, id string, index int, r io.Reader) (int64, error) {
+	if err := d.deleteAttachment(ctx, id, index); err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf("INSERT INTO attachment (dataid, idx, seq, data) VALUES (%s)", d.placeholders(4))
+	buf := make([]byte, attachmentChunkSize)
+	var size int64
+	for seq := 0; ; seq++ {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			insertErr := d.insertChunk(ctx, query, id, index, seq, buf[:n])
+			if insertErr != nil {
+				d.deleteAttachment(ctx, id, index)
+				return size, fmt.Errorf("inserting attachment chunk: %w", insertErr)
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return size, nil
+		}
+		if err != nil {
+			d.deleteAttachment(ctx, id, index)
+			return size, fmt.Errorf("reading attachment: %w", err)
+		}
+	}
+}
+
+// insertChunk inserts one chunk of WriteAttachment.
+func (d *Database) insertChunk(ctx context.Context, query, id string, index, seq int, chunk []byte) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	_, err := d.db().ExecContext(ctx, query, id, index, seq, chunk)
+	return err
+}
+
+// OpenAttachment returns a reader that fetches an attachment one chunk
+// at a time, for as long as ctx lasts.
+func (d *Database) OpenAttachment(ctx context.Context, id string, index int) (io.ReadCloser, error) {
+	queryCtx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(
+		"SELECT COUNT(*) FROM attachment WHERE dataid = %s AND idx = %s",
+		d.placeholder(1), d.placeholder(2),
+	)
+	var chunks int
+	if err := d.queryRow(queryCtx, query, id, index).Scan(&chunks); err != nil {
+		return nil, fmt.Errorf("querying attachment: %w", err)
+	}
+	if chunks == 0 {
+		return nil, model.ErrPasteNotFound
+	}
+	return &chunkReader{ctx: ctx, d: d, id: id, index: index, chunks: chunks}, nil
+}
+
+// chunkReader reads an attachment from the attachment table.
+type chunkReader struct {
+	ctx    context.Context
+	d      *Database
+	id     string
+	index  int
+	chunks int    // Number of chunks
+	seq    int    // Next chunk to fetch
+	buf    []byte // Unread part of the current chunk
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.seq >= c.chunks {
+			return 0, io.EOF
+		}
+		chunk, err := c.d.attachmentChunk(c.ctx, c.id, c.index, c.seq)
+		if err != nil {
+			return 0, err
+		}
+		c.buf = chunk
+		c.seq++
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *chunkReader) Close() error {
+	return nil
+}
+
+// attachmentChunk reads one chunk of an attachment. A chunk deleted while
+// the attachment is being read ends it early.
+func (d *Database) attachmentChunk(ctx context.Context, id string, index, seq int) ([]byte, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(
+		"SELECT data FROM attachment WHERE dataid = %s AND idx = %s AND seq = %s",
+		d.placeholder(1), d.placeholder(2), d.placeholder(3),
+	)
+	var chunk []byte
+	err := d.queryRow(ctx, query, id, index, seq).Scan(&chunk)
+	if err == sql.ErrNoRows {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading attachment chunk: %w", err)
+	}
+	return chunk, nil
+}
+
+// DeleteAttachments removes all chunks of a paste's attachments.
+func (d *Database) DeleteAttachments(ctx context.Context, id string) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf("DELETE FROM attachment WHERE dataid = %s", d.placeholder(1))
+	if _, err := d.db().ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("deleting attachments: %w", err)
+	}
+	return nil
+}
+
+// deleteAttachment removes all chunks of one attachment.
+func (d *Database) deleteAttachment(ctx context.Context, id string, index int) error {
+	unlock := d.lockWrites()
+	defer unlock()
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(
+		"DELETE FROM attachment WHERE dataid = %s AND idx = %s",
+		d.placeholder(1), d.placeholder(2),
+	)
+	if _, err := d.db().ExecContext(ctx, query, id, index); err != nil {
+		return fmt.Errorf("deleting attachment: %w", err)
+	}
+	return nil
+}
+
+// PasteIDs returns the IDs of all stored pastes.
+func (d *Database) PasteIDs(ctx context.Context) ([]string, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	rows, err := d.db().QueryContext(ctx, "SELECT dataid FROM paste")
+	if err != nil {
+		return nil, fmt.Errorf("querying pastes: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scanning paste row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating pastes: %w", err)
+	}
+	return ids, nil
+}
+
+// IteratePastes calls fn for every paste that hasn't expired, in order of
+// ID. Like IterateComments, it reads a page at a time and has no rows open
+// while fn runs.
+func (d *Database) IteratePastes(ctx context.Context, fn func(*model.Paste) error) error {
+	query := fmt.Sprintf(
+		"SELECT dataid, data, expiredate, meta FROM paste WHERE dataid > %s ORDER BY dataid ASC LIMIT %d",
+		d.placeholder(1), iteratePageSize,
+	)
+
+	lastID := ""
+	for {
+		page, err := d.pastePage(ctx, query, lastID)
+		if err != nil {
+			return err
+		}
+		now := d.clock.Now()
+		for _, p := range page {
+			if p.IsExpiredAt(now) {
+				continue // Left for Purge
+			}
+			if err := fn(p); err != nil {
+				return err
+			}
+		}
+		if len(page) < iteratePageSize {
+			return nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+// pastePage reads a page of IteratePastes.
+func (d *Database) pastePage(ctx context.Context, query, lastID string) ([]*model.Paste, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	rows, err := d.db().QueryContext(ctx, query, lastID)
+	if err != nil {
+		return nil, fmt.Errorf("querying pastes: %w", err)
+	}
+	defer rows.Close()
+
+	var page []*model.Paste
+	for rows.Next() {
+		var id, dataJSON, metaJSON string
+		var expireDate sql.NullInt64
+		if err := rows.Scan(&id, &dataJSON, &expireDate, &metaJSON); err != nil {
+			return nil, fmt.Errorf("scanning paste row: %w", err)
+		}
+		paste, err := decodePaste(id, dataJSON, metaJSON, expireDate)
+		if err != nil {
+			return nil, fmt.Errorf("paste %s: %w", id, err)
+		}
+		page = append(page, paste)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating pastes: %w", err)
+	}
+	return page, nil
+}
+
+// PasteStats aggregates the live pastes without loading their data: only
+// its length and the metadata are read. The length counts the JSON
+// document the paste is stored as.
+func (d *Database) PasteStats(ctx context.Context, since int64) (*PasteStats, error) {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(
+		"SELECT LENGTH(data), meta FROM paste WHERE expiredate IS NULL OR expiredate = 0 OR expiredate >= %s",
+		d.placeholder(1),
+	)
+	rows, err := d.db().QueryContext(ctx, query, d.clock.Now().Unix())
+	if err != nil {
+		return nil, fmt.Errorf("querying paste stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &PasteStats{}
+	for rows.Next() {
+		var size int64
+		var metaJSON sql.NullString
+		if err := rows.Scan(&size, &metaJSON); err != nil {
+			return nil, fmt.Errorf("scanning paste stats row: %w", err)
+		}
+		var meta model.PasteMeta
+		if metaJSON.Valid {
+			_ = json.Unmarshal([]byte(metaJSON.String), &meta)
+		}
+		stats.Pastes++
+		if meta.PostDate >= since {
+			stats.Recent++
+		}
+		stats.Bytes += size
+		for _, n := range meta.AttachmentSizes {
+			stats.Bytes += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating paste stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Values calls fn for every entry of the config table. The entries are
+// read before fn is first called, so fn may write to the database.
+func (d *Database) Values(ctx context.Context, fn func(namespace, key, value string) error) error {
+	ctx, cancel := d.queryContext(ctx)
+	defer cancel()
+
+	rows, err := d.db().QueryContext(ctx, "SELECT id, value FROM config")
+	if err != nil {
+		return fmt.Errorf("querying values: %w", err)
+	}
+
+	var entries [][2]string
+	for rows.Next() {
+		var id, value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning value row: %w", err)
+		}
+		entries = append(entries, [2]string{id, value})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return fmt.Errorf("iterating values: %w", err)
+	}
+
+	for _, e := range entries {
+		// Namespaces never contain "_"; keys may
+		namespace, key, ok := strings.Cut(e[0], "_")
+		if !ok {
+			continue
+		}
+		if err := fn(namespace, key, e[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping checks the connection to the current primary host.
+func (d *Database) Ping(ctx context.Context) error {
+	db := d.db()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+	return nil
+}
+
+// Lock takes a named lock shared by every instance using the database.
+// PostgreSQL and MySQL hold an advisory lock on a connection kept for the
+// purpose. SQLite's locks only last as long as a transaction, which would
+// also block this instance's own writes, so a lock file beside the
+// database is used instead.
+func (d *Database) Lock(ctx context.Context, name string) (func(), error) {
+	if d.driver == "sqlite3" {
+		if d.sqlitePath == "" {
+			return processLocks.lock(ctx, "sqlite:"+name) // In memory: this process only
+		}
+		return lockFile(ctx, d.sqlitePath+".lock-"+name)
+	}
+
+	db := d.db()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connecting for lock %q: %w", name, err)
+	}
+
+	var release func() error
+	switch d.driver {
+	case "postgres":
+		key := advisoryKey(name)
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key)
+		release = func() error {
+			_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+			return err
+		}
+	case "mysql":
+		wait := lockTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			wait = time.Until(deadline)
+		}
+		var got sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", "flashpaper:"+name, int(wait.Seconds())).Scan(&got)
+		if err == nil && got.Int64 != 1 {
+			err = fmt.Errorf("timed out")
+		}
+		release = func() error {
+			_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", "flashpaper:"+name)
+			return err
+		}
+	default:
+		err = fmt.Errorf("not supported by %s", d.driver)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("taking lock %q: %w", name, err)
+	}
+	return func() {
+		if err := release(); err != nil {
+			// Closing the connection releases it all the same
+			conn.Raw(func(any) error { return sqldriver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// advisoryKey maps a lock name to a PostgreSQL advisory lock key.
+func advisoryKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("flashpaper:" + name))
+	return int64(h.Sum64())
+}
+
 // Close closes the database connection.
 func (d *Database) Close() error {
-	return d.db.Close()
+	if d.failover != nil {
+		d.failover.stop()
+	}
+
+	d.swapMu.Lock()
+	defer d.swapMu.Unlock()
+	p := d.pool.Load()
+	p.closeStmts()
+	return p.db.Close()
 }
 
-// pasteExistsUnsafe checks paste existence without acquiring lock.
-// Only call this when you already hold the lock.
-func (d *Database) pasteExistsUnsafe(id string) bool {
-	query := fmt.Sprintf("SELECT 1 FROM paste WHERE dataid = %s", d.placeholder(1))
-	var exists int
-	err := d.db.QueryRow(query, id).Scan(&exists)
-	return err == nil
+// hotQueries returns the single-row lookups made on every paste view.
+// The text must match what the methods build, since prepared statements
+// are found by query text.
+func (d *Database) hotQueries() []string {
+	return []string{
+		fmt.Sprintf("SELECT data, expiredate, meta FROM paste WHERE dataid = %s", d.placeholder(1)),
+		fmt.Sprintf("SELECT 1 FROM paste WHERE dataid = %s", d.placeholder(1)),
+		fmt.Sprintf("SELECT 1 FROM comment WHERE dataid = %s AND pasteid = %s", d.placeholder(1), d.placeholder(2)),
+		fmt.Sprintf("SELECT total FROM commentcount WHERE pasteid = %s", d.placeholder(1)),
+		fmt.Sprintf("SELECT firstread FROM receipt WHERE dataid = %s", d.placeholder(1)),
+		fmt.Sprintf("SELECT value FROM config WHERE id = %s", d.placeholder(1)),
+	}
+}
+
+// Warmup verifies the connection and prepares the hot-path statements,
+// so the first requests after startup don't pay for query planning.
+func (d *Database) Warmup(ctx context.Context) error {
+	d.swapMu.Lock()
+	defer d.swapMu.Unlock()
+
+	p := d.pool.Load()
+	if err := p.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+
+	stmts, err := d.prepareStmts(ctx, p.db)
+	if err != nil {
+		return err
+	}
+
+	d.pool.Store(&dbPool{db: p.db, stmts: stmts})
+	p.closeStmts()
+	return nil
+}
+
+// prepareStmts prepares the hot-path statements on db.
+func (d *Database) prepareStmts(ctx context.Context, db *sql.DB) (map[string]*sql.Stmt, error) {
+	stmts := make(map[string]*sql.Stmt)
+	for _, query := range d.hotQueries() {
+		stmt, err := db.PrepareContext(ctx, query)
+		if err != nil {
+			for _, s := range stmts {
+				s.Close()
+			}
+			return nil, fmt.Errorf("preparing statement: %w", err)
+		}
+		stmts[query] = stmt
+	}
+	return stmts, nil
+}
+
+// Drain releases prepared statements once the queries using them finish.
+// SQLite databases in WAL mode are checkpointed, after in-flight writes,
+// so the main database file is complete once the process exits.
+func (d *Database) Drain(ctx context.Context) error {
+	d.swapMu.Lock()
+	defer d.swapMu.Unlock()
+
+	p := d.pool.Load()
+	d.pool.Store(&dbPool{db: p.db})
+	p.closeStmts()
+
+	if d.driver == "sqlite3" {
+		unlock := d.lockWrites()
+		defer unlock()
+		// A no-op unless the database is in WAL mode
+		if _, err := p.db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			return fmt.Errorf("checkpointing database: %w", err)
+		}
+	}
+	return ctx.Err()
+}
+
+// queryRow runs a single-row query, using a prepared statement if Warmup
+// created one.
+func (d *Database) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	p := d.pool.Load()
+	if stmt, ok := p.stmts[query]; ok {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return p.db.QueryRowContext(ctx, query, args...)
 }
@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,26 +17,8 @@ import (
 	"github.com/liskl/flashpaper/internal/model"
 )
 
-// skipIfNoCGO skips the test if SQLite is not available (requires CGO)
-func skipIfNoCGO(t *testing.T) {
-	cfg := &config.Config{
-		Model: config.ModelConfig{
-			Class:  "Database",
-			Driver: "sqlite3",
-			DSN:    ":memory:",
-		},
-	}
-	_, err := NewDatabase(cfg)
-	if err != nil && strings.Contains(err.Error(), "CGO_ENABLED=0") {
-		t.Skip("Skipping test: SQLite requires CGO which is not available")
-	}
-}
-
 // testDatabaseConfig creates a config for SQLite testing.
-// Automatically skips the test if CGO is not available.
-func testDatabaseConfig(t *testing.T) *config.Config {
-	skipIfNoCGO(t)
-
+func testDatabaseConfig(t testing.TB) *config.Config {
 	tmpDir := t.TempDir()
 	dbPath := filepath.Join(tmpDir, "test.db")
 
@@ -47,16 +32,18 @@ func testDatabaseConfig(t *testing.T) *config.Config {
 }
 
 func TestNewDatabase_CreatesTables(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
 	// Verify tables exist by attempting operations
-	assert.False(t, db.PasteExists("nonexistent"))
+	assert.False(t, db.PasteExists(ctx, "nonexistent"))
 }
 
 func TestDatabase_CreatePaste_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -74,14 +61,15 @@ func TestDatabase_CreatePaste_Success(t *testing.T) {
 		},
 	}
 
-	err = db.CreatePaste("f468483c313401e8", paste)
+	err = db.CreatePaste(ctx, "f468483c313401e8", paste)
 	require.NoError(t, err)
 
 	// Verify paste exists
-	assert.True(t, db.PasteExists("f468483c313401e8"))
+	assert.True(t, db.PasteExists(ctx, "f468483c313401e8"))
 }
 
 func TestDatabase_CreatePaste_DuplicateID_ReturnsError(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -89,15 +77,34 @@ func TestDatabase_CreatePaste_DuplicateID_ReturnsError(t *testing.T) {
 
 	paste := &model.Paste{Data: "content"}
 
-	err = db.CreatePaste("testid", paste)
+	err = db.CreatePaste(ctx, "testid", paste)
 	require.NoError(t, err)
 
 	// Try to create with same ID
-	err = db.CreatePaste("testid", paste)
+	err = db.CreatePaste(ctx, "testid", paste)
 	assert.ErrorIs(t, err, model.ErrPasteExists)
 }
 
+func TestDatabase_Context(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.CreatePaste(canceled, "testid", &model.Paste{Data: "content"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, db.PasteExists(context.Background(), "testid"))
+
+	// [model] query_timeout bounds operations the caller left unbounded
+	db.queryTimeout = time.Nanosecond
+	err = db.CreatePaste(context.Background(), "testid", &model.Paste{Data: "content"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
 func TestDatabase_ReadPaste_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -116,11 +123,11 @@ func TestDatabase_ReadPaste_Success(t *testing.T) {
 		},
 	}
 
-	err = db.CreatePaste("testpaste", original)
+	err = db.CreatePaste(ctx, "testpaste", original)
 	require.NoError(t, err)
 
 	// Read it back
-	paste, err := db.ReadPaste("testpaste")
+	paste, err := db.ReadPaste(ctx, "testpaste")
 	require.NoError(t, err)
 
 	assert.Equal(t, "testpaste", paste.ID)
@@ -131,16 +138,18 @@ func TestDatabase_ReadPaste_Success(t *testing.T) {
 }
 
 func TestDatabase_ReadPaste_NotFound(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
-	_, err = db.ReadPaste("nonexistent")
+	_, err = db.ReadPaste(ctx, "nonexistent")
 	assert.ErrorIs(t, err, model.ErrPasteNotFound)
 }
 
 func TestDatabase_ReadPaste_Expired_DeletesAndReturnsError(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -154,44 +163,47 @@ func TestDatabase_ReadPaste_Expired_DeletesAndReturnsError(t *testing.T) {
 		},
 	}
 
-	err = db.CreatePaste("expired", paste)
+	err = db.CreatePaste(ctx, "expired", paste)
 	require.NoError(t, err)
 
 	// Try to read it
-	_, err = db.ReadPaste("expired")
+	_, err = db.ReadPaste(ctx, "expired")
 	assert.ErrorIs(t, err, model.ErrPasteExpired)
 
 	// Verify it was deleted
-	assert.False(t, db.PasteExists("expired"))
+	assert.False(t, db.PasteExists(ctx, "expired"))
 }
 
 func TestDatabase_DeletePaste_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
 	paste := &model.Paste{Data: "content"}
-	err = db.CreatePaste("todelete", paste)
+	err = db.CreatePaste(ctx, "todelete", paste)
 	require.NoError(t, err)
 
-	err = db.DeletePaste("todelete")
+	err = db.DeletePaste(ctx, "todelete")
 	require.NoError(t, err)
 
-	assert.False(t, db.PasteExists("todelete"))
+	assert.False(t, db.PasteExists(ctx, "todelete"))
 }
 
 func TestDatabase_DeletePaste_NotFound(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
-	err = db.DeletePaste("nonexistent")
+	err = db.DeletePaste(ctx, "nonexistent")
 	assert.ErrorIs(t, err, model.ErrPasteNotFound)
 }
 
 func TestDatabase_DeletePaste_DeletesComments(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -202,7 +214,7 @@ func TestDatabase_DeletePaste_DeletesComments(t *testing.T) {
 		Data: "content",
 		Meta: model.PasteMeta{OpenDiscussion: true},
 	}
-	err = db.CreatePaste("withcomments", paste)
+	err = db.CreatePaste(ctx, "withcomments", paste)
 	require.NoError(t, err)
 
 	// Add comments
@@ -210,20 +222,21 @@ func TestDatabase_DeletePaste_DeletesComments(t *testing.T) {
 		Data: "comment content",
 		Meta: model.CommentMeta{PostDate: time.Now().Unix()},
 	}
-	err = db.CreateComment("withcomments", "", "comment1", comment)
+	err = db.CreateComment(ctx, "withcomments", "", "comment1", comment)
 	require.NoError(t, err)
 
 	// Delete paste
-	err = db.DeletePaste("withcomments")
+	err = db.DeletePaste(ctx, "withcomments")
 	require.NoError(t, err)
 
 	// Verify comment is gone too
-	comments, err := db.ReadComments("withcomments")
+	comments, err := db.ReadComments(ctx, "withcomments")
 	require.NoError(t, err)
 	assert.Empty(t, comments)
 }
 
 func TestDatabase_CreateComment_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -234,7 +247,7 @@ func TestDatabase_CreateComment_Success(t *testing.T) {
 		Data: "content",
 		Meta: model.PasteMeta{OpenDiscussion: true},
 	}
-	err = db.CreatePaste("paste1", paste)
+	err = db.CreatePaste(ctx, "paste1", paste)
 	require.NoError(t, err)
 
 	// Create comment
@@ -244,50 +257,192 @@ func TestDatabase_CreateComment_Success(t *testing.T) {
 		Version: 2,
 		Meta:    model.CommentMeta{PostDate: time.Now().Unix()},
 	}
-	err = db.CreateComment("paste1", "", "comment1", comment)
+	err = db.CreateComment(ctx, "paste1", "", "comment1", comment)
 	require.NoError(t, err)
 
-	assert.True(t, db.CommentExists("paste1", "", "comment1"))
+	assert.True(t, db.CommentExists(ctx, "paste1", "", "comment1"))
 }
 
 func TestDatabase_CreateComment_PasteNotFound(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
 	comment := &model.Comment{Data: "content"}
-	err = db.CreateComment("nonexistent", "", "c1", comment)
+	err = db.CreateComment(ctx, "nonexistent", "", "c1", comment)
 	assert.ErrorIs(t, err, model.ErrPasteNotFound)
 }
 
 func TestDatabase_CreateComment_DuplicateID(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
 	paste := &model.Paste{Data: "content", Meta: model.PasteMeta{OpenDiscussion: true}}
-	err = db.CreatePaste("paste1", paste)
+	err = db.CreatePaste(ctx, "paste1", paste)
 	require.NoError(t, err)
 
 	comment := &model.Comment{Data: "content", Meta: model.CommentMeta{PostDate: time.Now().Unix()}}
-	err = db.CreateComment("paste1", "", "c1", comment)
+	err = db.CreateComment(ctx, "paste1", "", "c1", comment)
 	require.NoError(t, err)
 
 	// Try duplicate
-	err = db.CreateComment("paste1", "", "c1", comment)
+	err = db.CreateComment(ctx, "paste1", "", "c1", comment)
 	assert.ErrorIs(t, err, model.ErrCommentExists)
 }
 
+func TestDatabase_CreateComment_LimitUnderConcurrency(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	cfg.Main.CommentLimit = 3
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.CreatePaste(ctx, "limited", &model.Paste{Data: "content"})
+	require.NoError(t, err)
+
+	// Race more writers than the limit allows
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			comment := &model.Comment{Data: "comment", Meta: model.CommentMeta{PostDate: time.Now().Unix()}}
+			results <- db.CreateComment(ctx, "limited", "", fmt.Sprintf("comment%02d", i), comment)
+		}(i)
+	}
+
+	stored, limited := 0, 0
+	for i := 0; i < 10; i++ {
+		switch err := <-results; err {
+		case nil:
+			stored++
+		case model.ErrCommentLimitReached:
+			limited++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 3, stored)
+	assert.Equal(t, 7, limited)
+
+	// Counter and comments agree
+	count, err := db.CountComments(ctx, "limited")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	comments, err := db.ReadComments(ctx, "limited")
+	require.NoError(t, err)
+	assert.Len(t, comments, 3)
+}
+
+func TestDatabase_CreateComment_DuplicateDoesNotCount(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.CreatePaste(ctx, "limited", &model.Paste{Data: "content"})
+	require.NoError(t, err)
+
+	comment := &model.Comment{Data: "comment"}
+	require.NoError(t, db.CreateComment(ctx, "limited", "", "comment1", comment))
+	assert.ErrorIs(t, db.CreateComment(ctx, "limited", "", "comment1", comment), model.ErrCommentExists)
+
+	count, err := db.CountComments(ctx, "limited")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestDatabase_CreatePaste_PersistsCategory(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	paste := &model.Paste{Data: "content", Meta: model.PasteMeta{Category: model.CategoryImage}}
+	require.NoError(t, db.CreatePaste(ctx, "category12345678", paste))
+
+	read, err := db.ReadPaste(ctx, "category12345678")
+	require.NoError(t, err)
+	assert.Equal(t, model.CategoryImage, read.Meta.Category)
+}
+
+func TestDatabase_CreatePaste_PersistsSalt(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	paste := &model.Paste{Data: "content", Meta: model.PasteMeta{Salt: "cGFzdGUtc2FsdA=="}}
+	require.NoError(t, db.CreatePaste(ctx, "salted1234567890", paste))
+
+	read, err := db.ReadPaste(ctx, "salted1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, "cGFzdGUtc2FsdA==", read.Meta.Salt)
+
+	// Rewriting the meta keeps it
+	require.NoError(t, db.SetPinned(ctx, "salted1234567890", true))
+	read, err = db.ReadPaste(ctx, "salted1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, "cGFzdGUtc2FsdA==", read.Meta.Salt)
+}
+
+func TestDatabase_CreatePaste_PersistsAccessProof(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	paste := &model.Paste{Data: "content", Meta: model.PasteMeta{AccessProof: "proofhash"}}
+	require.NoError(t, db.CreatePaste(ctx, "gated12345678901", paste))
+
+	read, err := db.ReadPaste(ctx, "gated12345678901")
+	require.NoError(t, err)
+	assert.Equal(t, "proofhash", read.Meta.AccessProof)
+
+	// Rewriting the meta keeps it
+	require.NoError(t, db.SetExpireDate(ctx, "gated12345678901", 0))
+	read, err = db.ReadPaste(ctx, "gated12345678901")
+	require.NoError(t, err)
+	assert.Equal(t, "proofhash", read.Meta.AccessProof)
+}
+
+func TestDatabase_CreateComment_PersistsFlag(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.CreatePaste(ctx, "flagged", &model.Paste{Data: "content"})
+	require.NoError(t, err)
+
+	comment := &model.Comment{Data: "comment", Meta: model.CommentMeta{Flagged: true}}
+	require.NoError(t, db.CreateComment(ctx, "flagged", "", "comment1", comment))
+
+	comments, err := db.ReadComments(ctx, "flagged")
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+	assert.True(t, comments[0].Meta.Flagged)
+}
+
 func TestDatabase_ReadComments_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
 	paste := &model.Paste{Data: "content", Meta: model.PasteMeta{OpenDiscussion: true}}
-	err = db.CreatePaste("paste1", paste)
+	err = db.CreatePaste(ctx, "paste1", paste)
 	require.NoError(t, err)
 
 	// Add multiple comments
@@ -299,12 +454,12 @@ func TestDatabase_ReadComments_Success(t *testing.T) {
 			Version: 2,
 			Meta:    model.CommentMeta{PostDate: now + int64(i)},
 		}
-		err = db.CreateComment("paste1", "", id, comment)
+		err = db.CreateComment(ctx, "paste1", "", id, comment)
 		require.NoError(t, err)
 	}
 
 	// Read comments
-	comments, err := db.ReadComments("paste1")
+	comments, err := db.ReadComments(ctx, "paste1")
 	require.NoError(t, err)
 	require.Len(t, comments, 3)
 
@@ -315,59 +470,119 @@ func TestDatabase_ReadComments_Success(t *testing.T) {
 }
 
 func TestDatabase_ReadComments_Empty(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
-	comments, err := db.ReadComments("nonexistent")
+	comments, err := db.ReadComments(ctx, "nonexistent")
 	require.NoError(t, err)
 	assert.Empty(t, comments)
 }
 
+func TestDatabase_MarkRead_RecordsFirstReadOnly(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	pasteID := "receipt123456789"
+	require.NoError(t, db.CreatePaste(ctx, pasteID, &model.Paste{Data: "content"}))
+
+	// Unread paste has an empty receipt
+	receipt, err := db.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.False(t, receipt.IsRead())
+
+	first, err := db.MarkRead(ctx, pasteID)
+	require.NoError(t, err)
+	assert.True(t, first)
+
+	receipt, err = db.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.True(t, receipt.IsRead())
+	firstRead := receipt.FirstRead
+
+	// Later reads don't move the timestamp
+	first, err = db.MarkRead(ctx, pasteID)
+	require.NoError(t, err)
+	assert.False(t, first)
+
+	receipt, err = db.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, firstRead, receipt.FirstRead)
+
+	// Deleting the paste removes its receipt
+	require.NoError(t, db.DeletePaste(ctx, pasteID))
+	_, err = db.GetReadReceipt(ctx, pasteID)
+	assert.ErrorIs(t, err, model.ErrPasteNotFound)
+
+	require.NoError(t, db.CreatePaste(ctx, pasteID, &model.Paste{Data: "content"}))
+	receipt, err = db.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.False(t, receipt.IsRead())
+}
+
+func TestDatabase_MarkRead_PasteNotFound(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.MarkRead(ctx, "nonexistent12345")
+	assert.ErrorIs(t, err, model.ErrPasteNotFound)
+}
+
 func TestDatabase_SetValue_GetValue(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
-	err = db.SetValue("test", "key1", "value1")
+	err = db.SetValue(ctx, "test", "key1", "value1")
 	require.NoError(t, err)
 
-	value, err := db.GetValue("test", "key1")
+	value, err := db.GetValue(ctx, "test", "key1")
 	require.NoError(t, err)
 	assert.Equal(t, "value1", value)
 }
 
 func TestDatabase_SetValue_Overwrites(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
-	err = db.SetValue("test", "key1", "original")
+	err = db.SetValue(ctx, "test", "key1", "original")
 	require.NoError(t, err)
 
-	err = db.SetValue("test", "key1", "updated")
+	err = db.SetValue(ctx, "test", "key1", "updated")
 	require.NoError(t, err)
 
-	value, err := db.GetValue("test", "key1")
+	value, err := db.GetValue(ctx, "test", "key1")
 	require.NoError(t, err)
 	assert.Equal(t, "updated", value)
 }
 
 func TestDatabase_GetValue_NotFound_ReturnsEmpty(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
-	value, err := db.GetValue("nonexistent", "key")
+	value, err := db.GetValue(ctx, "nonexistent", "key")
 	require.NoError(t, err)
 	assert.Empty(t, value)
 }
 
 func TestDatabase_GetExpiredPastes(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -381,7 +596,7 @@ func TestDatabase_GetExpiredPastes(t *testing.T) {
 				ExpireDate: time.Now().Add(-time.Duration(i+1) * time.Hour).Unix(),
 			},
 		}
-		err = db.CreatePaste(id, paste)
+		err = db.CreatePaste(ctx, id, paste)
 		require.NoError(t, err)
 	}
 
@@ -392,16 +607,96 @@ func TestDatabase_GetExpiredPastes(t *testing.T) {
 			ExpireDate: time.Now().Add(time.Hour).Unix(),
 		},
 	}
-	err = db.CreatePaste("notexpired", paste)
+	err = db.CreatePaste(ctx, "notexpired", paste)
 	require.NoError(t, err)
 
-	expired, err := db.GetExpiredPastes(10)
+	expired, err := db.GetExpiredPastes(ctx, 10)
 	require.NoError(t, err)
 	assert.Len(t, expired, 3)
 	assert.NotContains(t, expired, "notexpired")
 }
 
+func TestDatabase_SetPinned(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	expireDate := time.Now().Add(-time.Hour).Unix()
+	paste := &model.Paste{
+		Data: "privacy policy",
+		Meta: model.PasteMeta{ExpireDate: expireDate},
+	}
+	require.NoError(t, db.CreatePaste(ctx, "pinned", paste))
+	require.NoError(t, db.SetPinned(ctx, "pinned", true))
+
+	// Pinned pastes outlive their expiration
+	expired, err := db.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	read, err := db.ReadPaste(ctx, "pinned")
+	require.NoError(t, err)
+	assert.True(t, read.Meta.Pinned)
+	assert.Equal(t, expireDate, read.Meta.ExpireDate)
+
+	// Unpinning restores the original expiration
+	require.NoError(t, db.SetPinned(ctx, "pinned", false))
+	expired, err = db.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"pinned"}, expired)
+
+	assert.Equal(t, model.ErrPasteNotFound, db.SetPinned(ctx, "missing", true))
+}
+
+func TestDatabase_SetDisabled(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.CreatePaste(ctx, "disabled", &model.Paste{Data: "snippet"}))
+	require.NoError(t, db.SetDisabled(ctx, "disabled", true))
+	read, err := db.ReadPaste(ctx, "disabled")
+	require.NoError(t, err)
+	assert.True(t, read.Meta.Disabled)
+
+	require.NoError(t, db.SetDisabled(ctx, "disabled", false))
+	read, err = db.ReadPaste(ctx, "disabled")
+	require.NoError(t, err)
+	assert.False(t, read.Meta.Disabled)
+
+	assert.Equal(t, model.ErrPasteNotFound, db.SetDisabled(ctx, "missing", true))
+}
+
+func TestDatabase_SetExpireDate(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	paste := &model.Paste{
+		Data: "snippet",
+		Meta: model.PasteMeta{ExpireDate: time.Now().Add(-time.Hour).Unix()},
+	}
+	require.NoError(t, db.CreatePaste(ctx, "extended", paste))
+	later := time.Now().Add(time.Hour).Unix()
+	require.NoError(t, db.SetExpireDate(ctx, "extended", later))
+
+	expired, err := db.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	read, err := db.ReadPaste(ctx, "extended")
+	require.NoError(t, err)
+	assert.Equal(t, later, read.Meta.ExpireDate)
+
+	assert.Equal(t, model.ErrPasteNotFound, db.SetExpireDate(ctx, "missing", later))
+}
+
 func TestDatabase_Purge(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -415,20 +710,65 @@ func TestDatabase_Purge(t *testing.T) {
 				ExpireDate: time.Now().Add(-time.Hour).Unix(),
 			},
 		}
-		err = db.CreatePaste(id, paste)
+		err = db.CreatePaste(ctx, id, paste)
 		require.NoError(t, err)
 	}
 
-	count, err := db.Purge(10)
+	count, err := db.Purge(ctx, 10)
 	require.NoError(t, err)
 	assert.Equal(t, 2, count)
 
 	// Verify they're gone
-	assert.False(t, db.PasteExists("exp1"))
-	assert.False(t, db.PasteExists("exp2"))
+	assert.False(t, db.PasteExists(ctx, "exp1"))
+	assert.False(t, db.PasteExists(ctx, "exp2"))
+}
+
+// TestDatabase_Purge_Batch tests that a purge takes the oldest pastes of
+// the batch along with everything stored for them.
+func TestDatabase_Purge_Batch(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	for i, id := range []string{"0000000000000003", "0000000000000001", "0000000000000002"} {
+		paste := &model.Paste{Data: "content", Meta: model.PasteMeta{
+			ExpireDate: now.Add(-time.Duration(3-i) * time.Hour).Unix(),
+		}}
+		require.NoError(t, db.CreatePaste(ctx, id, paste))
+		comment := &model.Comment{Data: "comment", Meta: model.CommentMeta{PostDate: now.Unix()}}
+		require.NoError(t, db.CreateComment(ctx, id, id, "c"+id[1:], comment))
+		_, err = db.WriteAttachment(ctx, id, 0, strings.NewReader("attachment"))
+		require.NoError(t, err)
+		_, err = db.MarkRead(ctx, id)
+		require.NoError(t, err)
+	}
+
+	count, err := db.Purge(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.False(t, db.PasteExists(ctx, "0000000000000003"))
+	assert.False(t, db.PasteExists(ctx, "0000000000000001"))
+	assert.True(t, db.PasteExists(ctx, "0000000000000002"))
+
+	for _, table := range []string{"comment", "commentcount", "receipt", "attachment"} {
+		var n int
+		require.NoError(t, db.db().QueryRow("SELECT COUNT(*) FROM "+table).Scan(&n))
+		assert.Equal(t, 1, n, table)
+	}
+
+	count, err = db.Purge(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = db.Purge(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
 }
 
 func TestDatabase_ConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -436,15 +776,15 @@ func TestDatabase_ConcurrentAccess(t *testing.T) {
 
 	// Create a paste
 	paste := &model.Paste{Data: "content"}
-	err = db.CreatePaste("concurrent", paste)
+	err = db.CreatePaste(ctx, "concurrent", paste)
 	require.NoError(t, err)
 
 	// Access concurrently
 	done := make(chan bool, 10)
 	for i := 0; i < 10; i++ {
 		go func() {
-			_, _ = db.ReadPaste("concurrent")
-			_ = db.PasteExists("concurrent")
+			_, _ = db.ReadPaste(ctx, "concurrent")
+			_ = db.PasteExists(ctx, "concurrent")
 			done <- true
 		}()
 	}
@@ -457,8 +797,7 @@ func TestDatabase_ConcurrentAccess(t *testing.T) {
 
 // TestDatabase_IntegrationWithFilesystem tests that both backends work similarly
 func TestDatabase_SameInterfaceAsFilesystem(t *testing.T) {
-	skipIfNoCGO(t)
-
+	ctx := context.Background()
 	// This test verifies that Database and Filesystem implement the same interface
 	// and behave consistently for basic operations
 
@@ -500,22 +839,22 @@ func TestDatabase_SameInterfaceAsFilesystem(t *testing.T) {
 			}
 
 			// Create
-			err := store.CreatePaste("test123", paste)
+			err := store.CreatePaste(ctx, "test123", paste)
 			require.NoError(t, err)
 
 			// Exists
-			assert.True(t, store.PasteExists("test123"))
+			assert.True(t, store.PasteExists(ctx, "test123"))
 
 			// Read
-			read, err := store.ReadPaste("test123")
+			read, err := store.ReadPaste(ctx, "test123")
 			require.NoError(t, err)
 			assert.Equal(t, "test content", read.Data)
 
 			// Delete
-			err = store.DeletePaste("test123")
+			err = store.DeletePaste(ctx, "test123")
 			require.NoError(t, err)
 
-			assert.False(t, store.PasteExists("test123"))
+			assert.False(t, store.PasteExists(ctx, "test123"))
 		})
 	}
 }
@@ -524,6 +863,7 @@ func TestDatabase_SameInterfaceAsFilesystem(t *testing.T) {
 // encrypted content and never contains plaintext. This is a security test
 // to ensure the zero-knowledge principle is maintained.
 func TestDatabase_NoPlaintextInStorage(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -545,11 +885,11 @@ func TestDatabase_NoPlaintextInStorage(t *testing.T) {
 
 	// Create paste with "encrypted" content (simulating what client sends)
 	paste := &model.Paste{
-		Data:           encryptedData,
-		Attachment:     encryptedAttachment,
-		AttachmentName: "secret_document.pdf", // Filename is also encrypted in real usage
-		Version:        2,
-		AData:          []byte(`[["iv_base64","salt_base64",100000,256,128,"aes","gcm","zlib"],"plaintext",0,0]`),
+		Data:            encryptedData,
+		Attachments:     model.StringList{encryptedAttachment},
+		AttachmentNames: model.StringList{"secret_document.pdf"}, // Filename is also encrypted in real usage
+		Version:         2,
+		AData:           []byte(`[["iv_base64","salt_base64",100000,256,128,"aes","gcm","zlib"],"plaintext",0,0]`),
 		Meta: model.PasteMeta{
 			PostDate:       time.Now().Unix(),
 			ExpireDate:     time.Now().Add(time.Hour).Unix(),
@@ -560,7 +900,7 @@ func TestDatabase_NoPlaintextInStorage(t *testing.T) {
 	}
 
 	pasteID := "a1b2c3d4e5f67890"
-	err = db.CreatePaste(pasteID, paste)
+	err = db.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	// Also create a comment with "encrypted" content
@@ -570,7 +910,7 @@ func TestDatabase_NoPlaintextInStorage(t *testing.T) {
 		Version: 2,
 		Meta:    model.CommentMeta{PostDate: time.Now().Unix()},
 	}
-	err = db.CreateComment(pasteID, "", "comment123", comment)
+	err = db.CreateComment(ctx, pasteID, "", "comment123", comment)
 	require.NoError(t, err)
 
 	// Close the database to flush all writes
@@ -614,6 +954,7 @@ func TestDatabase_NoPlaintextInStorage(t *testing.T) {
 // stored in the database can be retrieved exactly as it was stored,
 // ensuring no corruption or modification of ciphertext occurs.
 func TestDatabase_EncryptedContentRoundTrip(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -656,10 +997,10 @@ func TestDatabase_EncryptedContentRoundTrip(t *testing.T) {
 				},
 			}
 
-			err := db.CreatePaste(pasteID, original)
+			err := db.CreatePaste(ctx, pasteID, original)
 			require.NoError(t, err)
 
-			retrieved, err := db.ReadPaste(pasteID)
+			retrieved, err := db.ReadPaste(ctx, pasteID)
 			require.NoError(t, err)
 
 			assert.Equal(t, tc.data, retrieved.Data,
@@ -670,40 +1011,64 @@ func TestDatabase_EncryptedContentRoundTrip(t *testing.T) {
 
 // TestDatabase_PurgeValues tests the PurgeValues function.
 func TestDatabase_PurgeValues(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
 	defer db.Close()
 
 	// Create some values in different namespaces
-	err = db.SetValue("traffic", "ip1", "1234567890")
+	err = db.SetValue(ctx, "traffic", "ip1", "1234567890")
 	require.NoError(t, err)
-	err = db.SetValue("traffic", "ip2", "1234567891")
+	err = db.SetValue(ctx, "traffic", "ip2", "1234567891")
 	require.NoError(t, err)
-	err = db.SetValue("salt", "server", "serversalt")
+	err = db.SetValue(ctx, "salt", "server", "serversalt")
 	require.NoError(t, err)
 
 	// Purge the traffic namespace (maxAge 0 means purge all)
-	err = db.PurgeValues("traffic", 0)
+	err = db.PurgeValues(ctx, "traffic", 0)
 	require.NoError(t, err)
 
 	// Traffic values should be gone
-	val, err := db.GetValue("traffic", "ip1")
+	val, err := db.GetValue(ctx, "traffic", "ip1")
 	require.NoError(t, err)
 	assert.Empty(t, val)
 
-	val, err = db.GetValue("traffic", "ip2")
+	val, err = db.GetValue(ctx, "traffic", "ip2")
 	require.NoError(t, err)
 	assert.Empty(t, val)
 
 	// Salt value should still exist
-	val, err = db.GetValue("salt", "server")
+	val, err = db.GetValue(ctx, "salt", "server")
 	require.NoError(t, err)
 	assert.Equal(t, "serversalt", val)
 }
 
+// TestDatabase_PurgeValues_Milliseconds tests that rate limiter entries,
+// which hold Unix milliseconds, are purged once outdated.
+func TestDatabase_PurgeValues_Milliseconds(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	require.NoError(t, db.SetValue(ctx, NamespaceTraffic, "full", fmt.Sprint(now.Add(-time.Minute).UnixMilli())))
+	require.NoError(t, db.SetValue(ctx, NamespaceTraffic, "refilling", fmt.Sprint(now.Add(time.Minute).UnixMilli())))
+	require.NoError(t, db.PurgeValues(ctx, NamespaceTraffic, 0))
+
+	full, err := db.GetValue(ctx, NamespaceTraffic, "full")
+	require.NoError(t, err)
+	assert.Empty(t, full)
+	refilling, err := db.GetValue(ctx, NamespaceTraffic, "refilling")
+	require.NoError(t, err)
+	assert.NotEmpty(t, refilling)
+}
+
 // TestDatabase_Purge_WithNeverExpire tests that pastes with no expiration are not purged.
 func TestDatabase_Purge_WithNeverExpire(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -716,7 +1081,7 @@ func TestDatabase_Purge_WithNeverExpire(t *testing.T) {
 			ExpireDate: 0, // Never expires
 		},
 	}
-	err = db.CreatePaste("permanent1", neverExpire)
+	err = db.CreatePaste(ctx, "permanent1", neverExpire)
 	require.NoError(t, err)
 
 	// Create expired paste
@@ -726,21 +1091,22 @@ func TestDatabase_Purge_WithNeverExpire(t *testing.T) {
 			ExpireDate: time.Now().Add(-time.Hour).Unix(),
 		},
 	}
-	err = db.CreatePaste("expired1", expired)
+	err = db.CreatePaste(ctx, "expired1", expired)
 	require.NoError(t, err)
 
 	// Purge
-	count, err := db.Purge(10)
+	count, err := db.Purge(ctx, 10)
 	require.NoError(t, err)
 	assert.Equal(t, 1, count) // Only expired paste should be purged
 
 	// Verify permanent paste still exists
-	assert.True(t, db.PasteExists("permanent1"))
-	assert.False(t, db.PasteExists("expired1"))
+	assert.True(t, db.PasteExists(ctx, "permanent1"))
+	assert.False(t, db.PasteExists(ctx, "expired1"))
 }
 
 // TestDatabase_GetExpiredPastes_WithLimit tests the limit parameter.
 func TestDatabase_GetExpiredPastes_WithLimit(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -754,18 +1120,19 @@ func TestDatabase_GetExpiredPastes_WithLimit(t *testing.T) {
 				ExpireDate: time.Now().Add(-time.Hour).Unix(),
 			},
 		}
-		err = db.CreatePaste("expired"+string(rune('a'+i))+"12345", paste)
+		err = db.CreatePaste(ctx, "expired"+string(rune('a'+i))+"12345", paste)
 		require.NoError(t, err)
 	}
 
 	// Get with limit
-	expired, err := db.GetExpiredPastes(5)
+	expired, err := db.GetExpiredPastes(ctx, 5)
 	require.NoError(t, err)
 	assert.Len(t, expired, 5)
 }
 
 // TestDatabase_CommentExists tests the CommentExists function.
 func TestDatabase_CommentExists(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -773,26 +1140,27 @@ func TestDatabase_CommentExists(t *testing.T) {
 
 	// Create paste
 	paste := &model.Paste{Data: "content", Meta: model.PasteMeta{OpenDiscussion: true}}
-	err = db.CreatePaste("paste123", paste)
+	err = db.CreatePaste(ctx, "paste123", paste)
 	require.NoError(t, err)
 
 	// Comment doesn't exist yet
-	assert.False(t, db.CommentExists("paste123", "", "comment1"))
+	assert.False(t, db.CommentExists(ctx, "paste123", "", "comment1"))
 
 	// Create comment
 	comment := &model.Comment{Data: "comment", Meta: model.CommentMeta{PostDate: time.Now().Unix()}}
-	err = db.CreateComment("paste123", "", "comment1", comment)
+	err = db.CreateComment(ctx, "paste123", "", "comment1", comment)
 	require.NoError(t, err)
 
 	// Now it exists
-	assert.True(t, db.CommentExists("paste123", "", "comment1"))
+	assert.True(t, db.CommentExists(ctx, "paste123", "", "comment1"))
 
 	// Different comment ID doesn't exist
-	assert.False(t, db.CommentExists("paste123", "", "comment2"))
+	assert.False(t, db.CommentExists(ctx, "paste123", "", "comment2"))
 }
 
 // TestDatabase_ReadPaste_WithAData tests reading paste with AData field.
 func TestDatabase_ReadPaste_WithAData(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -810,10 +1178,10 @@ func TestDatabase_ReadPaste_WithAData(t *testing.T) {
 		},
 	}
 
-	err = db.CreatePaste("adatatest", original)
+	err = db.CreatePaste(ctx, "adatatest", original)
 	require.NoError(t, err)
 
-	read, err := db.ReadPaste("adatatest")
+	read, err := db.ReadPaste(ctx, "adatatest")
 	require.NoError(t, err)
 
 	// Compare as strings to avoid type mismatch between []byte and json.RawMessage
@@ -822,6 +1190,7 @@ func TestDatabase_ReadPaste_WithAData(t *testing.T) {
 
 // TestDatabase_Close tests the Close function.
 func TestDatabase_Close(t *testing.T) {
+	ctx := context.Background()
 	cfg := testDatabaseConfig(t)
 	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
@@ -831,12 +1200,74 @@ func TestDatabase_Close(t *testing.T) {
 	require.NoError(t, err)
 
 	// Operations after close should fail
-	_, err = db.ReadPaste("test")
+	_, err = db.ReadPaste(ctx, "test")
 	assert.Error(t, err)
 }
 
+func TestDatabase_WarmupPreparesHotQueries(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, Warmup(context.Background(), db))
+	assert.Len(t, db.pool.Load().stmts, len(db.hotQueries()))
+
+	// Prepared statements serve the usual operations
+	paste := &model.Paste{Data: "warm", Version: 2, Meta: model.PasteMeta{PostDate: time.Now().Unix()}}
+	require.NoError(t, db.CreatePaste(ctx, "warmpaste1234567", paste))
+	assert.True(t, db.PasteExists(ctx, "warmpaste1234567"))
+	read, err := db.ReadPaste(ctx, "warmpaste1234567")
+	require.NoError(t, err)
+	assert.Equal(t, "warm", read.Data)
+
+	require.NoError(t, db.SetValue(ctx, "ns", "key", "value"))
+	value, err := db.GetValue(ctx, "ns", "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+}
+
+func TestDatabase_DrainReleasesStatements(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Warmup(context.Background()))
+	require.NoError(t, Drain(context.Background(), db))
+	assert.Nil(t, db.pool.Load().stmts)
+
+	// Still usable after draining, just without prepared statements
+	assert.False(t, db.PasteExists(ctx, "doesnotexist1234"))
+}
+
+func TestDatabase_Ping(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Ping(context.Background()))
+	require.NoError(t, db.Close())
+	assert.ErrorContains(t, db.Ping(context.Background()), "database unreachable")
+}
+
+func TestDatabase_WarmupHonorsContext(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, db.Warmup(ctx))
+	assert.Nil(t, db.pool.Load().stmts)
+}
+
 // Skip this test if DATABASE_URL is not set (for CI integration)
 func TestDatabase_PostgresIntegration(t *testing.T) {
+	ctx := context.Background()
 	dsn := os.Getenv("DATABASE_URL")
 	if dsn == "" {
 		t.Skip("DATABASE_URL not set, skipping PostgreSQL integration test")
@@ -856,12 +1287,305 @@ func TestDatabase_PostgresIntegration(t *testing.T) {
 
 	// Basic smoke test
 	paste := &model.Paste{Data: "test", Version: 2}
-	err = db.CreatePaste("pgtest123", paste)
+	err = db.CreatePaste(ctx, "pgtest123", paste)
+	require.NoError(t, err)
+
+	_, err = db.ReadPaste(ctx, "pgtest123")
+	require.NoError(t, err)
+
+	err = db.DeletePaste(ctx, "pgtest123")
+	require.NoError(t, err)
+}
+
+func TestDatabase_ReadAndDeletePaste(t *testing.T) {
+	db, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	checkReadAndDelete(t, db)
+}
+
+func TestDatabase_CountRead(t *testing.T) {
+	db, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	checkCountRead(t, db)
+}
+
+func TestDatabase_UpdatePaste(t *testing.T) {
+	db, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	checkUpdatePaste(t, db)
+}
+
+func TestDatabase_DeleteComment(t *testing.T) {
+	db, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	checkDeleteComment(t, db)
+}
+
+func TestDatabase_Clock(t *testing.T) {
+	db, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	checkClock(t, db)
+}
+
+func TestDatabase_Attachments(t *testing.T) {
+	db, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	checkAttachmentStore(t, db)
+}
+
+func TestDatabase_Iterate(t *testing.T) {
+	db, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	checkIterate(t, db)
+}
+
+func TestDatabase_PasteStats(t *testing.T) {
+	db, err := NewDatabase(testDatabaseConfig(t))
 	require.NoError(t, err)
+	defer db.Close()
+	checkPasteStats(t, db)
+}
+
+func TestMigrations_Drivers(t *testing.T) {
+	sqlite, err := migrations("sqlite3")
+	require.NoError(t, err)
+	require.NotEmpty(t, sqlite)
+
+	// Every driver has the same migrations
+	for _, driver := range []string{"postgres", "mysql"} {
+		ms, err := migrations(driver)
+		require.NoError(t, err, driver)
+		require.Len(t, ms, len(sqlite), driver)
+		for i, m := range ms {
+			assert.Equal(t, sqlite[i].name, m.name, driver)
+			assert.NotEmpty(t, m.statements, driver)
+		}
+	}
+
+	_, err = migrations("oracle")
+	assert.Error(t, err)
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements("-- comment\nCREATE TABLE a (\n\tx INTEGER\n);\n\nCREATE INDEX i ON a (x);\nSELECT 1")
+	assert.Equal(t, []string{"CREATE TABLE a (\n\tx INTEGER\n)", "CREATE INDEX i ON a (x)", "SELECT 1"}, statements)
+}
+
+func TestDatabase_SchemaMigrations(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	current, latest, err := db.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latest, current)
+	require.NoError(t, CheckSchema(ctx, db))
+
+	// Up to date: nothing to apply
+	applied, err := db.MigrateSchema(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+	db.Close()
+
+	// Reopening doesn't apply anything twice
+	db, err = NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	var rows int
+	require.NoError(t, db.db().QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&rows))
+	assert.Equal(t, latest, rows)
+
+	// A newer release's schema is left alone
+	_, err = db.db().Exec("INSERT INTO schema_version (version, name, applied) VALUES (?, 'future', 0)", latest+1)
+	require.NoError(t, err)
+	applied, err = db.MigrateSchema(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+	assert.NoError(t, CheckSchema(ctx, db))
+}
+
+func TestDatabase_SchemaAdoptsLegacyTables(t *testing.T) {
+	ctx := context.Background()
+	cfg := testDatabaseConfig(t)
+
+	// Tables as created before versioned migrations, with a paste
+	legacy, err := openDB("sqlite3", cfg.Model.DSN, poolSettings{maxOpen: 1})
+	require.NoError(t, err)
+	for _, statement := range []string{
+		"CREATE TABLE paste (dataid CHAR(16) PRIMARY KEY, data TEXT NOT NULL, expiredate BIGINT, meta TEXT)",
+		"CREATE TABLE comment (dataid CHAR(16) PRIMARY KEY, pasteid CHAR(16) NOT NULL, parentid CHAR(16), data TEXT NOT NULL, vizhash TEXT, postdate BIGINT NOT NULL)",
+		"CREATE INDEX IF NOT EXISTS idx_comment_pasteid ON comment (pasteid)",
+		`INSERT INTO paste (dataid, data, expiredate, meta) VALUES ('abcdef1234567890', '{"data":"ciphertext","v":2}', 0, '{}')`,
+	} {
+		_, err := legacy.Exec(statement)
+		require.NoError(t, err)
+	}
+	require.NoError(t, legacy.Close())
+
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	current, latest, err := db.SchemaVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, latest, current)
+
+	paste, err := db.ReadPaste(ctx, "abcdef1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", paste.Data)
+	assert.NoError(t, db.SetValue(ctx, NamespaceAdmin, "key", "value"))
+}
 
-	_, err = db.ReadPaste("pgtest123")
+func TestDatabase_NoMigrate(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	cfg.Model.NoMigrate = true
+	db, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	// Nothing is created, and startup checks refuse the empty schema
+	current, latest, err := db.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, current)
+	assert.ErrorContains(t, CheckSchema(ctx, WithMetrics(db, "sqlite3")), "flashpaper schema upgrade")
+
+	applied, err := db.MigrateSchema(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latest, applied)
+	assert.NoError(t, CheckSchema(ctx, db))
+	assert.False(t, db.PasteExists(ctx, "nonexistent"))
+
+	// Backends without a schema always pass
+	assert.NoError(t, CheckSchema(ctx, NewMock()))
+}
+
+func TestDatabase_ConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	db, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreatePaste(ctx, "a1b2c3d4e5f60718", &model.Paste{Data: "snippet", Meta: model.PasteMeta{OpenDiscussion: true}}))
+
+	// Without a global lock, SQLite writers must still wait for each
+	// other, and flag updates must not undo each other
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			errs <- db.SetPinned(ctx, "a1b2c3d4e5f60718", true)
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- db.SetDisabled(ctx, "a1b2c3d4e5f60718", true)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			commentID := fmt.Sprintf("c0000000000000%02d", i)
+			errs <- db.CreateComment(ctx, "a1b2c3d4e5f60718", "a1b2c3d4e5f60718", commentID, &model.Comment{Data: "comment"})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	paste, err := db.ReadPaste(ctx, "a1b2c3d4e5f60718")
+	require.NoError(t, err)
+	assert.True(t, paste.Meta.Pinned)
+	assert.True(t, paste.Meta.Disabled)
+	count, err := db.CountComments(ctx, "a1b2c3d4e5f60718")
 	require.NoError(t, err)
+	assert.Equal(t, 10, count)
+}
+
+// BenchmarkDatabase_ReadPaste compares concurrent paste reads with the
+// same reads serialized, as they were behind the backend's former global
+// lock: go test -bench ReadPaste -cpu 1,4,8 ./internal/storage
+func BenchmarkDatabase_ReadPaste(b *testing.B) {
+	ctx := context.Background()
+	db, err := NewDatabase(testDatabaseConfig(b))
+	require.NoError(b, err)
+	defer db.Close()
+	require.NoError(b, db.Warmup(ctx))
 
-	err = db.DeletePaste("pgtest123")
+	ids := make([]string, 64)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("a1b2c3d4e5f607%02x", i)
+		require.NoError(b, db.CreatePaste(ctx, ids[i], &model.Paste{Data: strings.Repeat("x", 4096)}))
+	}
+
+	read := func(b *testing.B, lock func() func()) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				unlock := lock()
+				_, err := db.ReadPaste(ctx, ids[i%len(ids)])
+				unlock()
+				if err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	}
+
+	b.Run("concurrent", func(b *testing.B) {
+		read(b, func() func() { return func() {} })
+	})
+	b.Run("serialized", func(b *testing.B) {
+		var mu sync.Mutex
+		read(b, func() func() {
+			mu.Lock()
+			return mu.Unlock
+		})
+	})
+}
+
+func TestDatabase_SQLitePragmas(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	cfg.Model.SQLiteJournalMode = "wal"
+	cfg.Model.SQLiteBusyTimeout = 7000
+	cfg.Model.SQLiteSynchronous = "normal"
+	cfg.Model.MaxOpenConns = 8
+	db, err := NewDatabase(cfg)
 	require.NoError(t, err)
+	defer db.Close()
+
+	var journal string
+	var busy, synchronous int
+	require.NoError(t, db.db().QueryRow("PRAGMA journal_mode").Scan(&journal))
+	require.NoError(t, db.db().QueryRow("PRAGMA busy_timeout").Scan(&busy))
+	require.NoError(t, db.db().QueryRow("PRAGMA synchronous").Scan(&synchronous))
+	assert.Equal(t, "wal", journal)
+	assert.Equal(t, 7000, busy)
+	assert.Equal(t, 1, synchronous, "NORMAL")
+	assert.Equal(t, 8, db.db().Stats().MaxOpenConnections)
+
+	// Pragmas in the DSN take precedence
+	full := sqlitePragmaParam("synchronous", "FULL")
+	timeout := sqlitePragmaParam("busy_timeout", "7000")
+	assert.Equal(t, "data.db?"+full+"&"+timeout+"&"+sqlitePragmaParam("journal_mode", "WAL"),
+		sqliteDSN("data.db?"+full, cfg.Model))
+	assert.Equal(t, ":memory:?"+timeout+"&"+sqlitePragmaParam("synchronous", "NORMAL"), sqliteDSN(":memory:", cfg.Model))
+
+	// Left at 0, the timeout is the same for both drivers
+	assert.Equal(t, ":memory:?"+sqlitePragmaParam("busy_timeout", "5000"), sqliteDSN(":memory:", config.ModelConfig{}))
+}
+
+func TestPoolSettingsFor(t *testing.T) {
+	assert.Equal(t, poolSettings{maxOpen: 25, maxIdle: 25}, poolSettingsFor(config.ModelConfig{Driver: "sqlite3"}))
+	assert.Equal(t, poolSettings{maxOpen: 25, maxIdle: 5, maxLifetime: 5 * time.Minute},
+		poolSettingsFor(config.ModelConfig{Driver: "postgres"}))
+	assert.Equal(t, poolSettings{maxOpen: 50, maxIdle: 10, maxLifetime: time.Minute},
+		poolSettingsFor(config.ModelConfig{Driver: "mysql", MaxOpenConns: 50, MaxIdleConns: 10, ConnMaxLifetime: 60}))
 }
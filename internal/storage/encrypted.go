@@ -0,0 +1,413 @@
+// Package storage provides encryption of stored records at rest.
+// Paste and comment contents are encrypted by their creators, but what
+// surrounds them is not: adata with the cipher parameters and formatter,
+// attachment names, flags such as burn-after-reading, delete token salts,
+// and comment vizhashes all sit in the backend as readable JSON. With
+// [model] encryption_keys set, New wraps the backend so that each paste and
+// comment is sealed with AES-256-GCM before the backend sees it, and opened
+// again on the way out.
+//
+// Backends still need a few fields to do their work, which stay readable:
+// IDs, expiration dates, pinning, read limits and counts, the sizes of
+// attachments stored apart, and comment post dates. Attachments stored
+// apart are already nothing but client ciphertext and pass through as they
+// are, as do key-value entries.
+//
+// Every sealed record names its key, so keys can be rotated: put the new
+// key first and keep the old ones for reading. "flashpaper migrate" reseals
+// everything it copies with the first key. Records stored before
+// encryption was turned on are read as they are.
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// sealedPrefix starts the Data of sealed records, followed by the key ID.
+// Client ciphertext is base64, which has no ":", so the two can't be
+// mistaken for one another.
+const sealedPrefix = "fpenc1:"
+
+// EncryptionKeySize is the size of encryption keys in bytes (AES-256).
+const EncryptionKeySize = 32
+
+// Keyring holds the keys records are sealed with.
+type Keyring struct {
+	current string // ID of the key sealing new records
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeyring parses keys given as id:base64 entries separated by commas
+// or line breaks. The first entry's key seals new records; all of them
+// open records. IDs may contain letters, digits, "-" and "_".
+func ParseKeyring(entries string) (*Keyring, error) {
+	k := &Keyring{keys: map[string]cipher.AEAD{}}
+	fields := strings.FieldsFunc(entries, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(field, ":")
+		if !ok || !validKeyID(id) {
+			return nil, fmt.Errorf("encryption key entries must be id:base64, with an ID of letters, digits, - or _")
+		}
+		if _, dup := k.keys[id]; dup {
+			return nil, fmt.Errorf("encryption key %q is listed twice", id)
+		}
+		key, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(encoded), "="))
+		if err != nil || len(key) != EncryptionKeySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes in base64", id, EncryptionKeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if k.current == "" {
+			k.current = id
+		}
+		k.keys[id] = gcm
+	}
+	if k.current == "" {
+		return nil, errors.New("no encryption keys given")
+	}
+	return k, nil
+}
+
+// validKeyID reports whether id is usable as a key ID.
+func validKeyID(id string) bool {
+	if id == "" || len(id) > 32 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// encryptionKeys returns the keyring configured in m, or nil if
+// encryption at rest is off.
+func encryptionKeys(m config.ModelConfig) (*Keyring, error) {
+	entries := m.EncryptionKeys
+	if m.EncryptionKeysFile != "" {
+		data, err := os.ReadFile(m.EncryptionKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading encryption keys: %w", err)
+		}
+		entries = string(data)
+	}
+	if entries == "" {
+		return nil, nil
+	}
+	return ParseKeyring(entries)
+}
+
+// seal encrypts plaintext with the current key, bound to aad.
+//
+// Format: fpenc1:<key ID>:base64(nonce || ciphertext || tag)
+func (k *Keyring) seal(aad string, plaintext []byte) (string, error) {
+	gcm := k.keys[k.current]
+	nonce, err := util.RandomBytes(gcm.NonceSize())
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(aad))
+	return sealedPrefix + k.current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts data sealed by seal with the same aad.
+func (k *Keyring) open(aad, data string) ([]byte, error) {
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(data, sealedPrefix), ":")
+	if !ok {
+		return nil, errors.New("malformed sealed record")
+	}
+	gcm, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("sealed with unknown encryption key %q", id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("malformed sealed record")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(aad))
+	if err != nil {
+		return nil, fmt.Errorf("record doesn't open with encryption key %q", id)
+	}
+	return plaintext, nil
+}
+
+// encrypted is a Storage sealing pastes and comments before they reach
+// the wrapped backend.
+type encrypted struct {
+	Storage
+	keys *Keyring
+}
+
+// WithEncryption returns s with pastes and comments sealed under keys.
+func WithEncryption(s Storage, keys *Keyring) Storage {
+	return &encrypted{Storage: s, keys: keys}
+}
+
+// unwrap returns the wrapped backend.
+func (e *encrypted) unwrap() Storage {
+	return e.Storage
+}
+
+// pasteAAD and commentAAD bind sealed records to their place, so that
+// one can't be swapped in for another.
+func pasteAAD(id string) string {
+	return "paste:" + id
+}
+
+func commentAAD(pasteID, parentID, commentID string) string {
+	return "comment:" + pasteID + "/" + parentID + "/" + commentID
+}
+
+// sealPaste returns the form of paste the backend stores: the document
+// sealed into Data, and only the metadata the backend needs besides.
+func (e *encrypted) sealPaste(id string, paste *model.Paste) (*model.Paste, error) {
+	doc, err := json.Marshal(pasteStorageData{
+		Data:           paste.Data,
+		AttachmentName: paste.AttachmentNames,
+		Attachment:     paste.Attachments,
+		AData:          paste.AData,
+		Version:        paste.Version,
+		Meta:           storedMeta(paste.Meta),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("serializing paste: %w", err)
+	}
+	data, err := e.keys.seal(pasteAAD(id), doc)
+	if err != nil {
+		return nil, fmt.Errorf("sealing paste: %w", err)
+	}
+	return &model.Paste{
+		ID:   paste.ID,
+		Data: data,
+		Meta: model.PasteMeta{
+			ExpireDate:      paste.Meta.ExpireDate,
+			Pinned:          paste.Meta.Pinned,
+			Disabled:        paste.Meta.Disabled,
+			MaxReads:        paste.Meta.MaxReads,
+			ReadsLeft:       paste.Meta.ReadsLeft,
+			AttachmentSizes: paste.Meta.AttachmentSizes,
+		},
+	}, nil
+}
+
+// openPaste reverses sealPaste. The backend's expiration date, pinning,
+// disabled flag, and read count win over the sealed ones, as they may
+// have been changed since.
+func (e *encrypted) openPaste(id string, stored *model.Paste) (*model.Paste, error) {
+	if !strings.HasPrefix(stored.Data, sealedPrefix) {
+		return stored, nil
+	}
+	doc, err := e.keys.open(pasteAAD(id), stored.Data)
+	if err != nil {
+		return nil, fmt.Errorf("opening paste %s: %w", id, err)
+	}
+	var d pasteStorageData
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("deserializing paste %s: %w", id, err)
+	}
+	paste := d.paste(id)
+	paste.Meta.ExpireDate = stored.Meta.ExpireDate
+	paste.Meta.Pinned = stored.Meta.Pinned
+	paste.Meta.Disabled = stored.Meta.Disabled
+	paste.Meta.MaxReads = stored.Meta.MaxReads
+	paste.Meta.ReadsLeft = stored.Meta.ReadsLeft
+	paste.Meta.AttachmentSizes = stored.Meta.AttachmentSizes
+	return paste, nil
+}
+
+// sealComment returns the form of comment the backend stores.
+func (e *encrypted) sealComment(pasteID, parentID, commentID string, comment *model.Comment) (*model.Comment, error) {
+	doc, err := json.Marshal(commentStorageData{
+		Data:     comment.Data,
+		AData:    comment.AData,
+		Version:  comment.Version,
+		Vizhash:  comment.Vizhash,
+		PostDate: comment.Meta.PostDate,
+		Flagged:  comment.Meta.Flagged,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("serializing comment: %w", err)
+	}
+	data, err := e.keys.seal(commentAAD(pasteID, parentID, commentID), doc)
+	if err != nil {
+		return nil, fmt.Errorf("sealing comment: %w", err)
+	}
+	return &model.Comment{
+		ID:       comment.ID,
+		PasteID:  comment.PasteID,
+		ParentID: comment.ParentID,
+		Data:     data,
+		Meta:     model.CommentMeta{PostDate: comment.Meta.PostDate},
+	}, nil
+}
+
+// openComment reverses sealComment.
+func (e *encrypted) openComment(stored *model.Comment) (*model.Comment, error) {
+	if !strings.HasPrefix(stored.Data, sealedPrefix) {
+		return stored, nil
+	}
+	doc, err := e.keys.open(commentAAD(stored.PasteID, stored.ParentID, stored.ID), stored.Data)
+	if err != nil {
+		return nil, fmt.Errorf("opening comment %s: %w", stored.ID, err)
+	}
+	var d commentStorageData
+	if err := json.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("deserializing comment %s: %w", stored.ID, err)
+	}
+	return &model.Comment{
+		ID:       stored.ID,
+		PasteID:  stored.PasteID,
+		ParentID: stored.ParentID,
+		Data:     d.Data,
+		AData:    d.AData,
+		Version:  d.Version,
+		Vizhash:  d.Vizhash,
+		Meta: model.CommentMeta{
+			PostDate: stored.Meta.PostDate,
+			Flagged:  d.Flagged,
+		},
+	}, nil
+}
+
+func (e *encrypted) CreatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	sealed, err := e.sealPaste(id, paste)
+	if err != nil {
+		return err
+	}
+	return e.Storage.CreatePaste(ctx, id, sealed)
+}
+
+func (e *encrypted) ReadPaste(ctx context.Context, id string) (*model.Paste, error) {
+	paste, err := e.Storage.ReadPaste(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return e.openPaste(id, paste)
+}
+
+func (e *encrypted) ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error) {
+	paste, err := e.Storage.ReadAndDeletePaste(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return e.openPaste(id, paste)
+}
+
+// UpdatePaste reseals the paste with its content replaced. The backend
+// keeps its own meta, so only the sealed document changes.
+func (e *encrypted) UpdatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	stored, err := e.ReadPaste(ctx, id)
+	if err != nil {
+		return err
+	}
+	stored.Data = paste.Data
+	stored.AData = paste.AData
+	stored.Version = paste.Version
+	stored.Meta.Formatter = paste.Meta.Formatter
+	sealed, err := e.sealPaste(id, stored)
+	if err != nil {
+		return err
+	}
+	return e.Storage.UpdatePaste(ctx, id, sealed)
+}
+
+func (e *encrypted) CountRead(ctx context.Context, id string) (*model.Paste, error) {
+	paste, err := e.Storage.CountRead(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return e.openPaste(id, paste)
+}
+
+func (e *encrypted) IteratePastes(ctx context.Context, fn func(*model.Paste) error) error {
+	return e.Storage.IteratePastes(ctx, func(stored *model.Paste) error {
+		paste, err := e.openPaste(stored.ID, stored)
+		if err != nil {
+			return err
+		}
+		return fn(paste)
+	})
+}
+
+// PasteStats tallies the opened pastes: post dates and attachment sizes
+// are sealed, so the backend can't count them.
+func (e *encrypted) PasteStats(ctx context.Context, since int64) (*PasteStats, error) {
+	return tallyPastes(ctx, e, since)
+}
+
+func (e *encrypted) CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) error {
+	sealed, err := e.sealComment(pasteID, parentID, commentID, comment)
+	if err != nil {
+		return err
+	}
+	return e.Storage.CreateComment(ctx, pasteID, parentID, commentID, sealed)
+}
+
+func (e *encrypted) ReadComments(ctx context.Context, pasteID string) ([]*model.Comment, error) {
+	comments, err := e.Storage.ReadComments(ctx, pasteID)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range comments {
+		if comments[i], err = e.openComment(c); err != nil {
+			return nil, err
+		}
+	}
+	return comments, nil
+}
+
+func (e *encrypted) IterateComments(ctx context.Context, pasteID string, fn func(*model.Comment) error) error {
+	return e.Storage.IterateComments(ctx, pasteID, func(stored *model.Comment) error {
+		comment, err := e.openComment(stored)
+		if err != nil {
+			return err
+		}
+		return fn(comment)
+	})
+}
+
+// PasteIDs lists the pastes of the wrapped backend, so Migrate can copy
+// from an encrypted backend, opening its records.
+func (e *encrypted) PasteIDs(ctx context.Context) ([]string, error) {
+	exporter, ok := e.Storage.(Exporter)
+	if !ok {
+		return nil, errors.New("source storage can't enumerate its contents")
+	}
+	return exporter.PasteIDs(ctx)
+}
+
+// Values calls fn for the key-value entries of the wrapped backend, which
+// aren't sealed.
+func (e *encrypted) Values(ctx context.Context, fn func(namespace, key, value string) error) error {
+	exporter, ok := e.Storage.(Exporter)
+	if !ok {
+		return errors.New("source storage can't enumerate its contents")
+	}
+	return exporter.Values(ctx, fn)
+}
@@ -0,0 +1,251 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/metrics"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// testKey returns an encryption key entry with every byte set to b.
+func testKey(id string, b byte) string {
+	key := make([]byte, EncryptionKeySize)
+	for i := range key {
+		key[i] = b
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+// newEncrypted returns a Mock wrapped with encryption under entries.
+func newEncrypted(t *testing.T, entries string) (Storage, *Mock) {
+	t.Helper()
+	keys, err := ParseKeyring(entries)
+	require.NoError(t, err)
+	mock := NewMock()
+	return WithEncryption(mock, keys), mock
+}
+
+func TestParseKeyring(t *testing.T) {
+	keys, err := ParseKeyring(testKey("new", 2) + ",\n " + testKey("old", 1) + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, "new", keys.current)
+	assert.Len(t, keys.keys, 2)
+
+	// Unpadded base64 is accepted too
+	_, err = ParseKeyring(strings.TrimRight(testKey("k", 1), "="))
+	assert.NoError(t, err)
+
+	for _, entries := range []string{
+		"",
+		"k:short",
+		"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+		testKey("bad id", 1),
+		testKey("k", 1) + "," + testKey("k", 2),
+	} {
+		_, err := ParseKeyring(entries)
+		assert.Error(t, err, entries)
+	}
+}
+
+func TestEncrypted_Paste(t *testing.T) {
+	ctx := context.Background()
+	s, backend := newEncrypted(t, testKey("k1", 1))
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	paste.AData = json.RawMessage(`[["iv","salt",100000,256,128,"aes","gcm","zlib"],"markdown",1,0]`)
+	paste.AttachmentNames = model.StringList{"name"}
+	paste.Attachments = model.StringList{"attachment"}
+	paste.Meta.OpenDiscussion = true
+	paste.Meta.Salt = "salt"
+	paste.Meta.AccessProof = "proof"
+	paste.SetExpiration(time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+
+	// The backend holds nothing but the sealed document and what it needs
+	stored, err := backend.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Data, "fpenc1:k1:"), stored.Data)
+	assert.NotContains(t, stored.Data, "markdown")
+	assert.Empty(t, stored.AData)
+	assert.Empty(t, stored.AttachmentNames)
+	assert.Empty(t, stored.Attachments)
+	assert.Equal(t, model.PasteMeta{ExpireDate: paste.Meta.ExpireDate}, stored.Meta)
+
+	read, err := s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", read.Data)
+	assert.JSONEq(t, string(paste.AData), string(read.AData))
+	assert.Equal(t, paste.AttachmentNames, read.AttachmentNames)
+	assert.Equal(t, paste.Attachments, read.Attachments)
+	assert.True(t, read.Meta.OpenDiscussion)
+	assert.Equal(t, "salt", read.Meta.Salt)
+	assert.Equal(t, "proof", read.Meta.AccessProof)
+
+	// Changes the backend makes to the metadata it holds are kept
+	require.NoError(t, Pin(ctx, s, pasteID, true))
+	read, err = s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.True(t, read.Meta.Pinned)
+	assert.Equal(t, paste.Meta.ExpireDate, read.Meta.ExpireDate)
+
+	// A sealed document moved to another paste doesn't open
+	require.NoError(t, backend.CreatePaste(ctx, "0123456789abcdef", stored))
+	_, err = s.ReadPaste(ctx, "0123456789abcdef")
+	assert.ErrorContains(t, err, "doesn't open")
+}
+
+func TestEncrypted_Comments(t *testing.T) {
+	ctx := context.Background()
+	s, backend := newEncrypted(t, testKey("k1", 1))
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Meta.OpenDiscussion = true
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+
+	comment := model.NewComment(pasteID)
+	comment.Data = "comment"
+	comment.Vizhash = "data:image/png;base64,vizhash"
+	comment.Meta.PostDate = 1_700_000_000
+	comment.Meta.Flagged = true
+	require.NoError(t, s.CreateComment(ctx, pasteID, pasteID, "1111111111111111", comment))
+
+	stored, err := backend.ReadComments(ctx, pasteID)
+	require.NoError(t, err)
+	require.Len(t, stored, 1)
+	assert.True(t, strings.HasPrefix(stored[0].Data, "fpenc1:k1:"))
+	assert.Empty(t, stored[0].Vizhash)
+	assert.False(t, stored[0].Meta.Flagged)
+	assert.Equal(t, int64(1_700_000_000), stored[0].Meta.PostDate)
+
+	comments, err := s.ReadComments(ctx, pasteID)
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+	assert.Equal(t, "comment", comments[0].Data)
+	assert.Equal(t, comment.Vizhash, comments[0].Vizhash)
+	assert.True(t, comments[0].Meta.Flagged)
+	assert.Equal(t, "1111111111111111", comments[0].ID)
+}
+
+func TestEncrypted_KeyRotation(t *testing.T) {
+	ctx := context.Background()
+	s, backend := newEncrypted(t, testKey("old", 1))
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+
+	// Records sealed with an old key still open; new ones get the new key
+	keys, err := ParseKeyring(testKey("new", 2) + "," + testKey("old", 1))
+	require.NoError(t, err)
+	rotated := WithEncryption(backend, keys)
+	read, err := rotated.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", read.Data)
+
+	require.NoError(t, rotated.CreatePaste(ctx, "0123456789abcdef", paste))
+	stored, err := backend.ReadPaste(ctx, "0123456789abcdef")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Data, "fpenc1:new:"))
+
+	// Without the old key, its records don't open
+	keys, err = ParseKeyring(testKey("new", 2))
+	require.NoError(t, err)
+	_, err = WithEncryption(backend, keys).ReadPaste(ctx, pasteID)
+	assert.ErrorContains(t, err, `unknown encryption key "old"`)
+}
+
+func TestEncrypted_Unsealed(t *testing.T) {
+	ctx := context.Background()
+	s, backend := newEncrypted(t, testKey("k1", 1))
+
+	// Stored before encryption was turned on
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	paste.AData = json.RawMessage(`["plain"]`)
+	require.NoError(t, backend.CreatePaste(ctx, pasteID, paste))
+
+	read, err := s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", read.Data)
+	assert.JSONEq(t, `["plain"]`, string(read.AData))
+}
+
+func TestEncrypted_Backend(t *testing.T) {
+	s, _ := newEncrypted(t, testKey("k1", 1))
+	checkReadAndDelete(t, s)
+	checkCountRead(t, s)
+	checkUpdatePaste(t, s)
+	checkDeleteComment(t, s)
+
+	s, _ = newEncrypted(t, testKey("k1", 1))
+	checkIterate(t, s)
+
+	s, _ = newEncrypted(t, testKey("k1", 1))
+	checkClock(t, s)
+
+	s, _ = newEncrypted(t, testKey("k1", 1))
+	checkPasteStats(t, s)
+
+	s, _ = newEncrypted(t, testKey("k1", 1))
+	s = WithMetrics(s, "mock")
+	s.(Instrumenter).Instrument(metrics.NewRegistry())
+	checkAttachmentStore(t, s)
+}
+
+func TestEncrypted_Migrate(t *testing.T) {
+	ctx := context.Background()
+	src, _ := newEncrypted(t, testKey("old", 1))
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	require.NoError(t, src.CreatePaste(ctx, "abcdef1234567890", paste))
+
+	dst, backend := newEncrypted(t, testKey("new", 2))
+	stats, err := Migrate(ctx, dst, src)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Pastes)
+
+	stored, err := backend.ReadPaste(ctx, "abcdef1234567890")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Data, "fpenc1:new:"))
+	read, err := dst.ReadPaste(ctx, "abcdef1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", read.Data)
+}
+
+func TestNew_EncryptionKeys(t *testing.T) {
+	cfg := testFilesystemConfig(t)
+	s, err := New(cfg)
+	require.NoError(t, err)
+	assert.IsType(t, &Filesystem{}, s)
+
+	keysFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keysFile, []byte(testKey("k1", 1)+"\n"), 0600))
+	cfg.Model.EncryptionKeysFile = keysFile
+	s, err = New(cfg)
+	require.NoError(t, err)
+	assert.IsType(t, &encrypted{}, s)
+	assert.IsType(t, &Filesystem{}, Unwrap(s))
+
+	cfg.Model.EncryptionKeysFile = ""
+	cfg.Model.EncryptionKeys = "k1:short"
+	_, err = New(cfg)
+	assert.Error(t, err)
+
+	_, err = New(&config.Config{Model: config.ModelConfig{Class: "S3", EncryptionKeysFile: filepath.Join(t.TempDir(), "missing")}})
+	assert.ErrorContains(t, err, "reading encryption keys")
+}
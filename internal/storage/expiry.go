@@ -0,0 +1,209 @@
+// Package storage provides the expiration index for the filesystem backend.
+// Finding expired pastes by walking the data directory means opening every
+// paste file, which takes minutes on instances with hundreds of thousands of
+// pastes. Instead, every paste that expires has an empty entry file in a
+// bucket for the day it expires:
+//
+//	data/
+//	  _expiry/
+//	    complete                     <- written once the index covers all pastes
+//	    19876/
+//	      1717286400.f468483c313401e8
+//
+// Buckets are named by the expiration date in days since the epoch, and the
+// zero-padded timestamp in each entry name keeps a bucket's entries sorted,
+// so purge runs only read the entries that have expired. Entries are written
+// before the paste and removed after it, so a crash leaves at most a stale
+// entry, which purge drops once it finds the paste gone or not yet expired.
+// Data directories written before the index existed are indexed by one full
+// walk on the first purge.
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+const (
+	expiryDirName    = "_expiry"
+	expiryMarkerName = "complete"
+	secondsPerDay    = 86400
+)
+
+// expiryDir returns the root directory of the expiration index.
+func (f *Filesystem) expiryDir() string {
+	return filepath.Join(f.baseDir, expiryDirName)
+}
+
+// expiryEntryPath returns the index entry of a paste expiring at expireDate.
+func (f *Filesystem) expiryEntryPath(id string, expireDate int64) string {
+	day := strconv.FormatInt(expireDate/secondsPerDay, 10)
+	return filepath.Join(f.expiryDir(), day, fmt.Sprintf("%010d.%s", expireDate, id))
+}
+
+// addExpiry records that a paste expires at expireDate (0 = never).
+func (f *Filesystem) addExpiry(id string, expireDate int64) error {
+	if expireDate <= 0 {
+		return nil
+	}
+	path := f.expiryEntryPath(id, expireDate)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("creating expiry index directory: %w", err)
+	}
+	if err := os.WriteFile(path, nil, 0640); err != nil {
+		return fmt.Errorf("writing expiry index entry: %w", err)
+	}
+	return nil
+}
+
+// removeExpiry drops a paste's index entry. A leftover entry only costs
+// purge a lookup, so failures are ignored.
+func (f *Filesystem) removeExpiry(id string, expireDate int64) {
+	if expireDate > 0 {
+		os.Remove(f.expiryEntryPath(id, expireDate))
+	}
+}
+
+// expiredEntriesUnsafe lists up to batchSize index entries that expired
+// before now, oldest first. Buckets of past days left empty are removed.
+// Caller must hold the lock.
+func (f *Filesystem) expiredEntriesUnsafe(ctx context.Context, batchSize int, now int64) ([]expiryEntry, error) {
+	buckets, err := os.ReadDir(f.expiryDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading expiry index: %w", err)
+	}
+
+	today := now / secondsPerDay
+	var days []int64
+	for _, bucket := range buckets {
+		day, err := strconv.ParseInt(bucket.Name(), 10, 64)
+		if err != nil || !bucket.IsDir() || day > today {
+			continue // The marker, and days still to come
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] < days[j] })
+
+	var entries []expiryEntry
+	for _, day := range days {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(f.expiryDir(), strconv.FormatInt(day, 10))
+		names, err := os.ReadDir(dir) // Sorted by name, hence by date
+		if err != nil {
+			return nil, fmt.Errorf("reading expiry index: %w", err)
+		}
+		if len(names) == 0 && day < today {
+			os.Remove(dir)
+			continue
+		}
+		for _, name := range names {
+			stamp, id, ok := strings.Cut(name.Name(), ".")
+			expireDate, err := strconv.ParseInt(stamp, 10, 64)
+			if !ok || err != nil {
+				continue
+			}
+			if expireDate >= now {
+				return entries, nil // Everything after this expires later still
+			}
+			entries = append(entries, expiryEntry{id, expireDate})
+			if len(entries) >= batchSize {
+				return entries, nil
+			}
+		}
+	}
+	return entries, nil
+}
+
+// indexExpiry builds the expiration index from the stored pastes, unless
+// it has been built already, by this process or another one sharing the
+// directory. New pastes can't be stored while it runs.
+func (f *Filesystem) indexExpiry(ctx context.Context) error {
+	if f.expiryIndexed.Load() {
+		return nil
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	marker := filepath.Join(f.expiryDir(), expiryMarkerName)
+	if _, err := os.Stat(marker); err == nil {
+		f.expiryIndexed.Store(true)
+		return nil
+	}
+
+	// Loose paste files
+	err := filepath.WalkDir(f.baseDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if name := d.Name(); name == "_config" || name == expiryDirName || strings.HasSuffix(name, ".discussion") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !util.ValidateID(d.Name()) {
+			return nil // Receipts, counters, packs, and temporary files
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		var storageData pasteStorageData
+		if err := json.Unmarshal(data, &storageData); err != nil {
+			return nil // Left for inspection, as by compaction
+		}
+		return f.addExpiry(d.Name(), storageData.Meta.ExpiresAt())
+	})
+	if err != nil {
+		return fmt.Errorf("indexing expiration dates: %w", err)
+	}
+
+	// Packed pastes, from their container indexes
+	shards, err := os.ReadDir(f.baseDir)
+	if err != nil {
+		return fmt.Errorf("reading data directory: %w", err)
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue
+		}
+		idx, err := f.loadPack(shard.Name())
+		if err != nil {
+			return err
+		}
+		if idx == nil {
+			continue
+		}
+		for id, entry := range idx.Entries {
+			if err := f.addExpiry(id, entry.Expire); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(f.expiryDir(), 0700); err != nil {
+		return fmt.Errorf("creating expiry index directory: %w", err)
+	}
+	if err := os.WriteFile(marker, nil, 0640); err != nil {
+		return fmt.Errorf("writing expiry index marker: %w", err)
+	}
+	f.expiryIndexed.Store(true)
+	return nil
+}
@@ -0,0 +1,338 @@
+// Package storage provides database failover for replicated PostgreSQL and
+// MySQL setups. The DSN may list several hosts, or [model] srv may name a
+// DNS SRV record to look them up from; FlashPaper then connects to the
+// first host that is reachable and writable (not a replica).
+//
+// While running, the current primary is probed every [model]
+// probe_interval seconds. If it stops answering or turns read-only (e.g.
+// after a demotion), the candidates are tried again in order and the
+// backend switches its connection pool to the new primary. SRV records are
+// looked up afresh on every attempt, so DNS-based failover works too.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/metrics"
+)
+
+// errNotPrimary is returned by the primary check for read-only hosts.
+var errNotPrimary = errors.New("database host is read-only")
+
+// lookupSRV resolves SRV records; replaced in tests.
+var lookupSRV = net.LookupSRV
+
+// failover tracks the candidate hosts of a replicated database.
+type failover struct {
+	driver   string
+	pool     poolSettings
+	dsn      func(host string) string // DSN connecting to one host
+	hosts    []string                 // Hosts listed in the DSN
+	srv      string                   // SRV record name, if configured
+	interval time.Duration            // Between probes; 0 disables probing
+
+	// check reports whether db is usable as primary (nil) or not.
+	// Defaults to checkPrimary; replaced in tests.
+	check func(ctx context.Context, db *sql.DB) error
+
+	// current is the host the pool is connected to. Only the probe loop
+	// changes it after startup.
+	current string
+
+	metrics atomic.Pointer[failoverMetrics]
+
+	stopOnce sync.Once
+	done     chan struct{} // Closed to stop the probe loop
+	stopped  chan struct{} // Closed when the probe loop has exited
+}
+
+// failoverMetrics are registered by Database.Instrument.
+type failoverMetrics struct {
+	failovers     *metrics.CounterVec // By previous and new host
+	probeFailures *metrics.CounterVec // By host
+	primary       *metrics.GaugeVec   // 1 for the current primary
+}
+
+// newFailover returns the failover state for cfg, or nil if the DSN names
+// a single host and no SRV record is configured.
+func newFailover(cfg *config.Config, dsn string, pool poolSettings) (*failover, error) {
+	driver := cfg.Model.Driver
+	if driver != "postgres" && driver != "mysql" {
+		return nil, nil
+	}
+
+	template, hosts, err := splitDSNHosts(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) < 2 && cfg.Model.SRV == "" {
+		return nil, nil
+	}
+
+	f := &failover{
+		driver:   driver,
+		pool:     pool,
+		dsn:      template,
+		hosts:    hosts,
+		srv:      cfg.Model.SRV,
+		interval: time.Duration(cfg.Model.ProbeInterval) * time.Second,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	f.check = f.checkPrimary
+	return f, nil
+}
+
+// splitDSNHosts finds the host list in a DSN. It returns the hosts and a
+// function building a DSN for any one of them.
+//
+// PostgreSQL DSNs may be URLs (postgres://user:pw@h1:5432,h2:5432/db) or
+// key/value strings (host=h1,h2 port=5432 dbname=db); MySQL DSNs list the
+// hosts in the address (user:pw@tcp(h1:3306,h2:3306)/db).
+func splitDSNHosts(driver, dsn string) (func(string) string, []string, error) {
+	var prefix, list, suffix string
+
+	switch {
+	case driver == "mysql":
+		// The address sits between the last "@" and the "/" before dbname
+		slash := strings.LastIndex(dsn, "/")
+		if slash < 0 {
+			return nil, nil, fmt.Errorf("invalid mysql dsn: missing database name")
+		}
+		start := strings.LastIndex(dsn[:slash], "@") + 1
+		addr := dsn[start:slash]
+		inner, ok := strings.CutPrefix(addr, "tcp(")
+		if !ok || !strings.HasSuffix(inner, ")") {
+			// Unix sockets and default addresses name a single host
+			return func(string) string { return dsn }, nil, nil
+		}
+		prefix = dsn[:start] + "tcp("
+		list = strings.TrimSuffix(inner, ")")
+		suffix = ")" + dsn[slash:]
+
+	case strings.HasPrefix(dsn, "postgres://"):
+		rest := dsn[len("postgres://"):]
+		end := strings.IndexAny(rest, "/?")
+		if end < 0 {
+			end = len(rest)
+		}
+		start := strings.LastIndex(rest[:end], "@") + 1
+		prefix = "postgres://" + rest[:start]
+		list = rest[start:end]
+		suffix = rest[end:]
+
+	default:
+		// Key/value form: replace the value of host=
+		fields := strings.Fields(dsn)
+		for i, field := range fields {
+			value, ok := strings.CutPrefix(field, "host=")
+			if !ok {
+				continue
+			}
+			prefix = strings.Join(append(fields[:i:i], "host="), " ")
+			list = value
+			if i+1 < len(fields) {
+				suffix = " " + strings.Join(fields[i+1:], " ")
+			}
+			break
+		}
+		if prefix == "" {
+			// No host given; SRV lookups still need somewhere to put one
+			prefix = strings.TrimSpace(dsn) + " host="
+		}
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return func(host string) string { return prefix + host + suffix }, hosts, nil
+}
+
+// candidates returns the hosts to try, in order of preference.
+// SRV records are ordered by priority and weight as returned by the lookup;
+// if the lookup fails, the DSN's own hosts are used.
+func (f *failover) candidates() []string {
+	if f.srv == "" {
+		return f.hosts
+	}
+	_, records, err := lookupSRV("", "", f.srv)
+	if err != nil || len(records) == 0 {
+		slog.Warn("Database failover: SRV lookup failed", "srv", f.srv, "error", err)
+		return f.hosts
+	}
+	hosts := make([]string, 0, len(records))
+	for _, rec := range records {
+		target := strings.TrimSuffix(rec.Target, ".")
+		hosts = append(hosts, net.JoinHostPort(target, strconv.Itoa(int(rec.Port))))
+	}
+	return hosts
+}
+
+// checkPrimary verifies db is reachable and accepts writes.
+func (f *failover) checkPrimary(ctx context.Context, db *sql.DB) error {
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+
+	query := "SELECT pg_is_in_recovery()"
+	if f.driver == "mysql" {
+		query = "SELECT @@global.read_only"
+	}
+	var readOnly bool
+	if err := db.QueryRowContext(ctx, query).Scan(&readOnly); err != nil {
+		return err
+	}
+	if readOnly {
+		return errNotPrimary
+	}
+	return nil
+}
+
+// findPrimary connects to the first candidate that passes the primary
+// check, skipping the host named by skip.
+func (f *failover) findPrimary(ctx context.Context, skip string) (string, *sql.DB, error) {
+	var errs []error
+	for _, host := range f.candidates() {
+		if host == skip {
+			continue
+		}
+		db, err := openDB(f.driver, f.dsn(host), f.pool)
+		if err == nil {
+			if err = f.check(ctx, db); err != nil {
+				db.Close()
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+			continue
+		}
+		return host, db, nil
+	}
+	if len(errs) == 0 {
+		return "", nil, fmt.Errorf("no database hosts to connect to")
+	}
+	return "", nil, fmt.Errorf("no usable primary database: %w", errors.Join(errs...))
+}
+
+// start launches the probe loop, unless probing is disabled.
+func (f *failover) start(d *Database) {
+	if f.interval <= 0 {
+		close(f.stopped)
+		return
+	}
+	go func() {
+		defer close(f.stopped)
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.done:
+				return
+			case <-ticker.C:
+				d.probe()
+			}
+		}
+	}()
+}
+
+// stop ends the probe loop and waits for a running probe to finish.
+func (f *failover) stop() {
+	f.stopOnce.Do(func() { close(f.done) })
+	<-f.stopped
+}
+
+// Instrument registers failover metrics. Nothing is registered for
+// single-host databases.
+func (d *Database) Instrument(r *metrics.Registry) {
+	f := d.failover
+	if f == nil {
+		return
+	}
+	m := &failoverMetrics{
+		failovers: r.Counter("flashpaper_db_failovers_total",
+			"Switches of the database connection to a new primary.", "from", "to"),
+		probeFailures: r.Counter("flashpaper_db_probe_failures_total",
+			"Failed health probes of the database primary.", "host"),
+		primary: r.Gauge("flashpaper_db_primary",
+			"1 for the database host currently used as primary.", "host"),
+	}
+	m.primary.Set(1, f.current)
+	f.metrics.Store(m)
+}
+
+// probe checks the current primary and fails over if it isn't usable.
+// Called from the probe loop only.
+func (d *Database) probe() {
+	f := d.failover
+	m := f.metrics.Load()
+	if m == nil {
+		m = &failoverMetrics{} // Not instrumented; nil metrics are no-ops
+	}
+
+	timeout := min(f.interval, 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	db := d.db()
+
+	err := f.check(ctx, db)
+	if err == nil {
+		return
+	}
+	m.probeFailures.Inc(f.current)
+	slog.Warn("Database failover: primary failed health probe", "host", f.current, "error", err)
+
+	host, newDB, err := f.findPrimary(ctx, f.current)
+	if err != nil {
+		// Keep the current pool; it may recover before another host does
+		slog.Error("Database failover: no primary found", "error", err)
+		return
+	}
+	if err := d.switchTo(ctx, newDB); err != nil {
+		newDB.Close()
+		slog.Error("Database failover: switching primary failed", "host", host, "error", err)
+		return
+	}
+
+	slog.Warn("Database failover: switched primary", "from", f.current, "to", host)
+	m.failovers.Inc(f.current, host)
+	m.primary.Set(0, f.current)
+	m.primary.Set(1, host)
+	f.current = host
+}
+
+// switchTo replaces the connection pool. Closing the old one waits for
+// the queries running on it; operations starting meanwhile use the new
+// one. Prepared statements are recreated if Warmup made them.
+func (d *Database) switchTo(ctx context.Context, db *sql.DB) error {
+	d.swapMu.Lock()
+	defer d.swapMu.Unlock()
+
+	old := d.pool.Load()
+	var stmts map[string]*sql.Stmt
+	if old.stmts != nil {
+		var err error
+		if stmts, err = d.prepareStmts(ctx, db); err != nil {
+			return err
+		}
+	}
+
+	d.pool.Store(&dbPool{db: db, stmts: stmts})
+	old.closeStmts()
+	old.db.Close()
+	return nil
+}
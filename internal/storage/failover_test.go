@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/metrics"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+func TestSplitDSNHosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver string
+		dsn    string
+		hosts  []string
+		first  string // DSN built for host db9:1
+	}{
+		{
+			name:   "postgres url",
+			driver: "postgres",
+			dsn:    "postgres://app:p@ss@db1:5432,db2:5433/flashpaper?sslmode=disable",
+			hosts:  []string{"db1:5432", "db2:5433"},
+			first:  "postgres://app:p@ss@db9:1/flashpaper?sslmode=disable",
+		},
+		{
+			name:   "postgres url without path",
+			driver: "postgres",
+			dsn:    "postgres://db1,db2",
+			hosts:  []string{"db1", "db2"},
+			first:  "postgres://db9:1",
+		},
+		{
+			name:   "postgres key value",
+			driver: "postgres",
+			dsn:    "user=app host=db1,db2 dbname=flashpaper",
+			hosts:  []string{"db1", "db2"},
+			first:  "user=app host=db9:1 dbname=flashpaper",
+		},
+		{
+			name:   "postgres key value without host",
+			driver: "postgres",
+			dsn:    "user=app dbname=flashpaper",
+			first:  "user=app dbname=flashpaper host=db9:1",
+		},
+		{
+			name:   "mysql",
+			driver: "mysql",
+			dsn:    "app:secret@tcp(db1:3306,db2:3306)/flashpaper?parseTime=true",
+			hosts:  []string{"db1:3306", "db2:3306"},
+			first:  "app:secret@tcp(db9:1)/flashpaper?parseTime=true",
+		},
+		{
+			name:   "mysql unix socket",
+			driver: "mysql",
+			dsn:    "app@unix(/run/mysqld.sock)/flashpaper",
+			first:  "app@unix(/run/mysqld.sock)/flashpaper",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			build, hosts, err := splitDSNHosts(tt.driver, tt.dsn)
+			require.NoError(t, err)
+			assert.Equal(t, tt.hosts, hosts)
+			assert.Equal(t, tt.first, build("db9:1"))
+		})
+	}
+}
+
+func TestNewFailover_SingleHost(t *testing.T) {
+	cfg := &config.Config{Model: config.ModelConfig{Driver: "postgres"}}
+
+	f, err := newFailover(cfg, "postgres://db1:5432/flashpaper", poolSettings{})
+	require.NoError(t, err)
+	assert.Nil(t, f, "a single host needs no failover")
+
+	cfg.Model.SRV = "_postgresql._tcp.example.com"
+	f, err = newFailover(cfg, "postgres://db1:5432/flashpaper", poolSettings{})
+	require.NoError(t, err)
+	assert.NotNil(t, f)
+}
+
+func TestFailover_CandidatesFromSRV(t *testing.T) {
+	orig := lookupSRV
+	defer func() { lookupSRV = orig }()
+
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_postgresql._tcp.example.com", name)
+		return "", []*net.SRV{
+			{Target: "db2.example.com.", Port: 5433},
+			{Target: "db1.example.com.", Port: 5432},
+		}, nil
+	}
+	f := &failover{srv: "_postgresql._tcp.example.com", hosts: []string{"fallback:5432"}}
+	assert.Equal(t, []string{"db2.example.com:5433", "db1.example.com:5432"}, f.candidates())
+
+	// A failed lookup falls back to the DSN's hosts
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return "", nil, errors.New("no such host")
+	}
+	assert.Equal(t, []string{"fallback:5432"}, f.candidates())
+}
+
+func TestDatabase_Failover(t *testing.T) {
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	primary := filepath.Join(dir, "primary.db")
+	standby := filepath.Join(dir, "standby.db")
+
+	// The standby needs the schema, as a replica would have it
+	replica, err := NewDatabase(&config.Config{Model: config.ModelConfig{Driver: "sqlite3", DSN: standby}})
+	require.NoError(t, err)
+	require.NoError(t, replica.Close())
+
+	db, err := NewDatabase(&config.Config{Model: config.ModelConfig{Driver: "sqlite3", DSN: primary}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Fake the primary check: once demoted, the first host is read-only
+	demoted := false
+	db.failover = &failover{
+		driver:   "sqlite3",
+		dsn:      func(host string) string { return host },
+		hosts:    []string{primary, standby},
+		interval: time.Second,
+		current:  primary,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	db.failover.check = func(ctx context.Context, conn *sql.DB) error {
+		var file string
+		if err := conn.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file); err != nil {
+			return err
+		}
+		if demoted && file == primary {
+			return errNotPrimary
+		}
+		return nil
+	}
+	close(db.failover.stopped) // No probe loop; probe is called directly
+
+	db.Instrument(metrics.NewRegistry())
+	require.NoError(t, db.Warmup(context.Background()))
+
+	// A healthy primary stays in place
+	db.probe()
+	assert.Equal(t, primary, db.failover.current)
+
+	demoted = true
+	db.probe()
+	assert.Equal(t, standby, db.failover.current)
+	assert.NotNil(t, db.pool.Load().stmts, "prepared statements are recreated on the new primary")
+
+	m := db.failover.metrics.Load()
+	assert.Equal(t, 1.0, m.failovers.Value(primary, standby))
+	assert.Equal(t, 1.0, m.probeFailures.Value(primary))
+	assert.Equal(t, 0.0, m.primary.Value(primary))
+	assert.Equal(t, 1.0, m.primary.Value(standby))
+
+	// Writes now land on the standby
+	paste := &model.Paste{
+		Data: "encrypted",
+		Meta: model.PasteMeta{PostDate: time.Now().Unix(), ExpireDate: time.Now().Add(time.Hour).Unix()},
+	}
+	require.NoError(t, db.CreatePaste(ctx, "a1b2c3d4e5f6a7b8", paste))
+
+	replica, err = NewDatabase(&config.Config{Model: config.ModelConfig{Driver: "sqlite3", DSN: standby}})
+	require.NoError(t, err)
+	defer replica.Close()
+	assert.True(t, replica.PasteExists(ctx, "a1b2c3d4e5f6a7b8"))
+}
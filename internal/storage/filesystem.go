@@ -6,32 +6,51 @@
 //   data/
 //     f4/
 //       68/
-//         f468483c313401e8           <- paste file
+//         f468483c313401e8              <- paste file
+//         f468483c313401e8.read         <- first-read timestamp (if read)
+//         f468483c313401e8.attachment.0 <- attachments (if stored separately)
 //         f468483c313401e8.discussion/
-//           comment1.parent1.json    <- comment file
+//           comment1.parent1.json       <- comment file
+//           count                       <- comment counter
+//     _expiry/                          <- expiration index (see expiry.go)
 //
 // Each paste file contains JSON with the encrypted data and metadata.
-// Comments are stored in a .discussion subdirectory.
+// Comments are stored in a .discussion subdirectory. Small paste files may be
+// repacked into per-shard containers by Compact (see compact.go).
 package storage
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/liskl/flashpaper/internal/clock"
 	"github.com/liskl/flashpaper/internal/config"
 	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
 )
 
 // Filesystem implements the Storage interface using the local filesystem.
 type Filesystem struct {
-	baseDir string
-	mu      sync.RWMutex
+	baseDir      string
+	commentLimit int // Max comments per paste (0 = unlimited)
+	mu           sync.RWMutex
+
+	packMu sync.Mutex            // Guards packs; taken under mu
+	packs  map[string]*packIndex // Cached shard indexes (see compact.go)
+
+	expiryIndexed atomic.Bool // Expiration index covers all pastes (see expiry.go)
+
+	clock clock.Clock // Expiration and receipt times (see SetClock)
 }
 
 // NewFilesystem creates a new filesystem storage backend.
@@ -53,10 +72,18 @@ func NewFilesystem(cfg *config.Config) (*Filesystem, error) {
 	}
 
 	return &Filesystem{
-		baseDir: baseDir,
+		baseDir:      baseDir,
+		commentLimit: cfg.Main.CommentLimit,
+		packs:        map[string]*packIndex{},
+		clock:        clock.System,
 	}, nil
 }
 
+// SetClock sets the clock expiration is judged by.
+func (f *Filesystem) SetClock(c clock.Clock) {
+	f.clock = c
+}
+
 // pastePath returns the file path for a paste.
 // Uses nested directories: data/f4/68/f468483c313401e8
 func (f *Filesystem) pastePath(id string) string {
@@ -71,12 +98,35 @@ func (f *Filesystem) discussionDir(id string) string {
 	return f.pastePath(id) + ".discussion"
 }
 
+// commentCountPath returns the file path for a paste's comment counter.
+func (f *Filesystem) commentCountPath(id string) string {
+	return filepath.Join(f.discussionDir(id), "count")
+}
+
+// receiptPath returns the file path for a paste's read receipt.
+func (f *Filesystem) receiptPath(id string) string {
+	return f.pastePath(id) + ".read"
+}
+
+// attachmentPath returns the file path for one of a paste's attachments.
+func (f *Filesystem) attachmentPath(id string, index int) string {
+	return fmt.Sprintf("%s.attachment.%d", f.pastePath(id), index)
+}
+
 // commentPath returns the file path for a comment.
 func (f *Filesystem) commentPath(pasteID, parentID, commentID string) string {
 	filename := fmt.Sprintf("%s.%s.json", commentID, parentID)
 	return filepath.Join(f.discussionDir(pasteID), filename)
 }
 
+// pasteExistsUnsafe checks for a loose or packed paste without locking.
+func (f *Filesystem) pasteExistsUnsafe(id string) bool {
+	if _, err := os.Stat(f.pastePath(id)); err == nil {
+		return true
+	}
+	return f.isPacked(id)
+}
+
 // configPath returns the path for a config value.
 func (f *Filesystem) configPath(namespace, key string) string {
 	return filepath.Join(f.baseDir, "_config", namespace+"_"+key)
@@ -84,23 +134,44 @@ func (f *Filesystem) configPath(namespace, key string) string {
 
 // pasteStorageData is the structure stored in paste files.
 type pasteStorageData struct {
-	Data           string          `json:"data"`
-	AttachmentName string          `json:"attachmentname,omitempty"`
-	Attachment     string          `json:"attachment,omitempty"`
-	AData          json.RawMessage `json:"adata,omitempty"`
-	Version        int             `json:"v"`
-	Meta           model.PasteMeta `json:"meta"`
+	Data           string           `json:"data"`
+	AttachmentName model.StringList `json:"attachmentname,omitempty"`
+	Attachment     model.StringList `json:"attachment,omitempty"`
+	AData          json.RawMessage  `json:"adata,omitempty"`
+	Version        int              `json:"v"`
+	Meta           pasteMeta        `json:"meta"`
+}
+
+// paste returns the stored paste as a model.Paste.
+func (d *pasteStorageData) paste(id string) *model.Paste {
+	return &model.Paste{
+		ID:              id,
+		Data:            d.Data,
+		AttachmentNames: d.AttachmentName,
+		Attachments:     d.Attachment,
+		AData:           d.AData,
+		Version:         d.Version,
+		Meta:            d.Meta.meta(),
+	}
+}
+
+// setContent replaces the stored content with paste's.
+func (d *pasteStorageData) setContent(paste *model.Paste) {
+	d.Data = paste.Data
+	d.AData = paste.AData
+	d.Version = paste.Version
+	d.Meta.Formatter = paste.Meta.Formatter
 }
 
 // CreatePaste stores a new paste on the filesystem.
-func (f *Filesystem) CreatePaste(id string, paste *model.Paste) error {
+func (f *Filesystem) CreatePaste(ctx context.Context, id string, paste *model.Paste) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	path := f.pastePath(id)
 
 	// Check if paste already exists
-	if _, err := os.Stat(path); err == nil {
+	if f.pasteExistsUnsafe(id) {
 		return model.ErrPasteExists
 	}
 
@@ -112,11 +183,11 @@ func (f *Filesystem) CreatePaste(id string, paste *model.Paste) error {
 	// Prepare storage data
 	storageData := pasteStorageData{
 		Data:           paste.Data,
-		AttachmentName: paste.AttachmentName,
-		Attachment:     paste.Attachment,
+		AttachmentName: paste.AttachmentNames,
+		Attachment:     paste.Attachments,
 		AData:          paste.AData,
 		Version:        paste.Version,
-		Meta:           paste.Meta,
+		Meta:           storedMeta(paste.Meta),
 	}
 
 	data, err := json.Marshal(storageData)
@@ -124,6 +195,10 @@ func (f *Filesystem) CreatePaste(id string, paste *model.Paste) error {
 		return fmt.Errorf("serializing paste: %w", err)
 	}
 
+	if err := f.addExpiry(id, storageData.Meta.ExpiresAt()); err != nil {
+		return err
+	}
+
 	// Write atomically using temp file
 	tmpPath := path + ".tmp"
 	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
@@ -139,40 +214,20 @@ func (f *Filesystem) CreatePaste(id string, paste *model.Paste) error {
 }
 
 // ReadPaste retrieves a paste from the filesystem.
-func (f *Filesystem) ReadPaste(id string) (*model.Paste, error) {
+func (f *Filesystem) ReadPaste(ctx context.Context, id string) (*model.Paste, error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
-	path := f.pastePath(id)
-
-	data, err := os.ReadFile(path)
-	if os.IsNotExist(err) {
-		return nil, model.ErrPasteNotFound
-	}
+	paste, err := f.loadPasteUnsafe(id)
 	if err != nil {
-		return nil, fmt.Errorf("reading paste file: %w", err)
-	}
-
-	var storageData pasteStorageData
-	if err := json.Unmarshal(data, &storageData); err != nil {
-		return nil, fmt.Errorf("deserializing paste: %w", err)
-	}
-
-	paste := &model.Paste{
-		ID:             id,
-		Data:           storageData.Data,
-		AttachmentName: storageData.AttachmentName,
-		Attachment:     storageData.Attachment,
-		AData:          storageData.AData,
-		Version:        storageData.Version,
-		Meta:           storageData.Meta,
+		return nil, err
 	}
 
 	// Check if expired
-	if paste.IsExpired() {
+	if paste.IsExpiredAt(f.clock.Now()) {
 		// Delete the expired paste
 		f.mu.RUnlock()
-		f.DeletePaste(id)
+		f.DeletePaste(ctx, id)
 		f.mu.RLock()
 		return nil, model.ErrPasteExpired
 	}
@@ -180,16 +235,49 @@ func (f *Filesystem) ReadPaste(id string) (*model.Paste, error) {
 	return paste, nil
 }
 
+// loadPasteUnsafe reads a paste, loose or packed, whether or not it has
+// expired. Caller must hold the lock.
+func (f *Filesystem) loadPasteUnsafe(id string) (*model.Paste, error) {
+	data, err := os.ReadFile(f.pastePath(id))
+	if os.IsNotExist(err) {
+		// Not loose; it may have been compacted
+		var found bool
+		data, found, err = f.readPacked(id)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, model.ErrPasteNotFound
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("reading paste file: %w", err)
+	}
+
+	var storageData pasteStorageData
+	if err := json.Unmarshal(data, &storageData); err != nil {
+		return nil, fmt.Errorf("deserializing paste: %w", err)
+	}
+	return storageData.paste(id), nil
+}
+
 // DeletePaste removes a paste and its comments from the filesystem.
-func (f *Filesystem) DeletePaste(id string) error {
+func (f *Filesystem) DeletePaste(ctx context.Context, id string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
+	return f.deletePasteUnsafe(id)
+}
+
+// deletePasteUnsafe removes a paste, its comments, and its expiration index
+// entry. Caller must hold the write lock.
+func (f *Filesystem) deletePasteUnsafe(id string) error {
 	path := f.pastePath(id)
 	discussionPath := f.discussionDir(id)
 
 	// Check if paste exists
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	_, err := os.Stat(path)
+	loose := err == nil
+	if !loose && !f.isPacked(id) {
 		return model.ErrPasteNotFound
 	}
 
@@ -198,22 +286,198 @@ func (f *Filesystem) DeletePaste(id string) error {
 		return fmt.Errorf("deleting discussion directory: %w", err)
 	}
 
-	// Delete paste file
-	if err := os.Remove(path); err != nil {
-		return fmt.Errorf("deleting paste file: %w", err)
+	// Delete read receipt
+	if err := os.Remove(f.receiptPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("deleting read receipt: %w", err)
+	}
+
+	// Delete attachments
+	if err := f.deleteAttachmentsUnsafe(id); err != nil {
+		return err
+	}
+
+	// The index entry goes last, so a failed delete is retried by purge
+	var expireDate int64
+	if paste, err := f.loadPasteUnsafe(id); err == nil {
+		expireDate = paste.Meta.ExpiresAt()
+	}
+
+	// Delete paste file and any packed copy
+	if loose {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("deleting paste file: %w", err)
+		}
+	}
+	if _, err := f.unpackUnsafe(id); err != nil {
+		return fmt.Errorf("deleting packed paste: %w", err)
 	}
+	f.removeExpiry(id, expireDate)
 
 	return nil
 }
 
+// ReadAndDeletePaste claims a loose paste by renaming its file, which only
+// one of several concurrent callers can do, even in other processes sharing
+// the directory, and then reads and removes it. Packed pastes are only
+// written by this process, so the lock is enough for them.
+func (f *Filesystem) ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := f.pastePath(id)
+	claimed := path + ".burn"
+	var data []byte
+	err := os.Rename(path, claimed)
+	switch {
+	case err == nil:
+		data, err = os.ReadFile(claimed)
+		if err != nil {
+			os.Rename(claimed, path)
+			return nil, fmt.Errorf("reading paste file: %w", err)
+		}
+		if err := os.Remove(claimed); err != nil {
+			return nil, fmt.Errorf("deleting paste file: %w", err)
+		}
+	case os.IsNotExist(err):
+		var found bool
+		data, found, err = f.readPacked(id)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, model.ErrPasteNotFound
+		}
+	default:
+		return nil, fmt.Errorf("claiming paste file: %w", err)
+	}
+	if _, err := f.unpackUnsafe(id); err != nil {
+		return nil, fmt.Errorf("deleting packed paste: %w", err)
+	}
+
+	if err := os.RemoveAll(f.discussionDir(id)); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("deleting discussion directory: %w", err)
+	}
+	if err := os.Remove(f.receiptPath(id)); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("deleting read receipt: %w", err)
+	}
+
+	var storageData pasteStorageData
+	if err := json.Unmarshal(data, &storageData); err != nil {
+		return nil, fmt.Errorf("deserializing paste: %w", err)
+	}
+	paste := storageData.paste(id)
+	f.removeExpiry(id, paste.Meta.ExpiresAt())
+	if paste.IsExpiredAt(f.clock.Now()) {
+		if err := f.deleteAttachmentsUnsafe(id); err != nil {
+			return nil, err
+		}
+		return nil, model.ErrPasteExpired
+	}
+	return paste, nil
+}
+
+// CountRead uses up one of a paste's reads. The count is rewritten under
+// the lock, which, unlike the claim of the last read, only covers this
+// process.
+func (f *Filesystem) CountRead(ctx context.Context, id string) (*model.Paste, error) {
+	return countRead(ctx, f, id, func(update func(meta *pasteMeta)) error {
+		return f.updateMeta(id, update)
+	})
+}
+
 // PasteExists checks if a paste exists on the filesystem.
-func (f *Filesystem) PasteExists(id string) bool {
+func (f *Filesystem) PasteExists(ctx context.Context, id string) bool {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
+	return f.pasteExistsUnsafe(id)
+}
+
+// SetPinned rewrites a paste with its Pinned flag set or cleared.
+func (f *Filesystem) SetPinned(ctx context.Context, id string, pinned bool) error {
+	return f.updateMeta(id, func(meta *pasteMeta) { meta.Pinned = pinned })
+}
+
+// SetDisabled rewrites a paste with its Disabled flag set or cleared.
+func (f *Filesystem) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	return f.updateMeta(id, func(meta *pasteMeta) { meta.Disabled = disabled })
+}
+
+// SetExpireDate rewrites a paste with a new expiration date.
+func (f *Filesystem) SetExpireDate(ctx context.Context, id string, expireDate int64) error {
+	return f.updateMeta(id, func(meta *pasteMeta) { meta.ExpireDate = expireDate })
+}
+
+// UpdatePaste rewrites a paste with its content replaced.
+func (f *Filesystem) UpdatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	return f.rewrite(id, func(d *pasteStorageData) { d.setContent(paste) })
+}
+
+// updateMeta rewrites a paste with its meta changed by update.
+func (f *Filesystem) updateMeta(id string, update func(meta *pasteMeta)) error {
+	return f.rewrite(id, func(d *pasteStorageData) { update(&d.Meta) })
+}
+
+// rewrite rewrites a paste as changed by update.
+// A packed paste is written back as a loose file and dropped from its
+// container's index; the next compaction packs it again.
+func (f *Filesystem) rewrite(id string, update func(d *pasteStorageData)) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
 	path := f.pastePath(id)
-	_, err := os.Stat(path)
-	return err == nil
+	data, err := os.ReadFile(path)
+	packed := false
+	if os.IsNotExist(err) {
+		data, packed, err = f.readPacked(id)
+		if err != nil {
+			return err
+		}
+		if !packed {
+			return model.ErrPasteNotFound
+		}
+	} else if err != nil {
+		return fmt.Errorf("reading paste file: %w", err)
+	}
+
+	var storageData pasteStorageData
+	if err := json.Unmarshal(data, &storageData); err != nil {
+		return fmt.Errorf("deserializing paste: %w", err)
+	}
+	oldExpire := storageData.Meta.ExpiresAt()
+	update(&storageData)
+	newExpire := storageData.Meta.ExpiresAt()
+
+	data, err = json.Marshal(storageData)
+	if err != nil {
+		return fmt.Errorf("serializing paste: %w", err)
+	}
+	if newExpire != oldExpire {
+		if err := f.addExpiry(id, newExpire); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("creating paste directory: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0640); err != nil {
+		return fmt.Errorf("writing paste file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("renaming paste file: %w", err)
+	}
+
+	if packed {
+		if _, err := f.unpackUnsafe(id); err != nil {
+			return fmt.Errorf("unpacking paste: %w", err)
+		}
+	}
+	if newExpire != oldExpire {
+		f.removeExpiry(id, oldExpire)
+	}
+	return nil
 }
 
 // commentStorageData is the structure stored in comment files.
@@ -223,16 +487,18 @@ type commentStorageData struct {
 	Version  int             `json:"v"`
 	Vizhash  string          `json:"vizhash,omitempty"`
 	PostDate int64           `json:"postdate"`
+	Flagged  bool            `json:"flagged,omitempty"`
 }
 
 // CreateComment stores a new comment on the filesystem.
-func (f *Filesystem) CreateComment(pasteID, parentID, commentID string, comment *model.Comment) error {
+// The comment file and counter are written under the write lock; if the
+// counter can't be updated the comment file is removed again.
+func (f *Filesystem) CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	// Verify paste exists
-	pastePath := f.pastePath(pasteID)
-	if _, err := os.Stat(pastePath); os.IsNotExist(err) {
+	if !f.pasteExistsUnsafe(pasteID) {
 		return model.ErrPasteNotFound
 	}
 
@@ -249,6 +515,15 @@ func (f *Filesystem) CreateComment(pasteID, parentID, commentID string, comment
 		return model.ErrCommentExists
 	}
 
+	// Enforce the comment limit
+	count, err := f.countCommentsUnsafe(pasteID)
+	if err != nil {
+		return err
+	}
+	if f.commentLimit > 0 && count >= f.commentLimit {
+		return model.ErrCommentLimitReached
+	}
+
 	// Prepare storage data
 	storageData := commentStorageData{
 		Data:     comment.Data,
@@ -256,6 +531,7 @@ func (f *Filesystem) CreateComment(pasteID, parentID, commentID string, comment
 		Version:  comment.Version,
 		Vizhash:  comment.Vizhash,
 		PostDate: comment.Meta.PostDate,
+		Flagged:  comment.Meta.Flagged,
 	}
 
 	data, err := json.Marshal(storageData)
@@ -274,11 +550,73 @@ func (f *Filesystem) CreateComment(pasteID, parentID, commentID string, comment
 		return fmt.Errorf("renaming comment file: %w", err)
 	}
 
+	// Update the counter, rolling back the comment if that fails
+	if err := f.writeCommentCountUnsafe(pasteID, count+1); err != nil {
+		os.Remove(commentPath)
+		return err
+	}
+
+	return nil
+}
+
+// CountComments returns the number of comments on a paste.
+func (f *Filesystem) CountComments(ctx context.Context, pasteID string) (int, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.countCommentsUnsafe(pasteID)
+}
+
+// countCommentsUnsafe reads a paste's comment counter without locking.
+// Discussions without a counter file (created before counters existed)
+// are counted by listing their comment files.
+func (f *Filesystem) countCommentsUnsafe(pasteID string) (int, error) {
+	data, err := os.ReadFile(f.commentCountPath(pasteID))
+	if err == nil {
+		var count int
+		if _, err := fmt.Sscanf(string(data), "%d", &count); err != nil {
+			return 0, fmt.Errorf("parsing comment count: %w", err)
+		}
+		return count, nil
+	}
+	if !os.IsNotExist(err) {
+		return 0, fmt.Errorf("reading comment count: %w", err)
+	}
+
+	entries, err := os.ReadDir(f.discussionDir(pasteID))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading discussion directory: %w", err)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// writeCommentCountUnsafe atomically replaces a paste's comment counter.
+// Caller must hold the write lock.
+func (f *Filesystem) writeCommentCountUnsafe(pasteID string, count int) error {
+	path := f.commentCountPath(pasteID)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(fmt.Sprintf("%d", count)), 0640); err != nil {
+		return fmt.Errorf("writing comment count: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("renaming comment count: %w", err)
+	}
 	return nil
 }
 
 // ReadComments retrieves all comments for a paste.
-func (f *Filesystem) ReadComments(pasteID string) ([]*model.Comment, error) {
+func (f *Filesystem) ReadComments(ctx context.Context, pasteID string) ([]*model.Comment, error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
@@ -328,6 +666,7 @@ func (f *Filesystem) ReadComments(pasteID string) ([]*model.Comment, error) {
 			Vizhash:  storageData.Vizhash,
 			Meta: model.CommentMeta{
 				PostDate: storageData.PostDate,
+				Flagged:  storageData.Flagged,
 			},
 		}
 		comments = append(comments, comment)
@@ -341,8 +680,24 @@ func (f *Filesystem) ReadComments(pasteID string) ([]*model.Comment, error) {
 	return comments, nil
 }
 
+// IterateComments calls fn for each comment on a paste, oldest first.
+// Nothing orders the comment files but the dates inside them, so they are
+// all read before fn is first called.
+func (f *Filesystem) IterateComments(ctx context.Context, pasteID string, fn func(*model.Comment) error) error {
+	comments, err := f.ReadComments(ctx, pasteID)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CommentExists checks if a comment exists.
-func (f *Filesystem) CommentExists(pasteID, parentID, commentID string) bool {
+func (f *Filesystem) CommentExists(ctx context.Context, pasteID, parentID, commentID string) bool {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
@@ -351,8 +706,88 @@ func (f *Filesystem) CommentExists(pasteID, parentID, commentID string) bool {
 	return err == nil
 }
 
+// DeleteComment removes a comment file from the filesystem.
+// The comment's parent isn't known, so its file is found by listing the
+// discussion directory; the counter is updated under the same write lock.
+func (f *Filesystem) DeleteComment(ctx context.Context, pasteID, commentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := os.ReadDir(f.discussionDir(pasteID))
+	if os.IsNotExist(err) {
+		return model.ErrCommentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("reading discussion directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), commentID+".") || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		count, err := f.countCommentsUnsafe(pasteID)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(f.discussionDir(pasteID), entry.Name())); err != nil {
+			return fmt.Errorf("deleting comment file: %w", err)
+		}
+		return f.writeCommentCountUnsafe(pasteID, max(count-1, 0))
+	}
+	return model.ErrCommentNotFound
+}
+
+// MarkRead records the first read of a paste.
+// The receipt file is created exclusively, so only one reader wins.
+func (f *Filesystem) MarkRead(ctx context.Context, id string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.pasteExistsUnsafe(id) {
+		return false, model.ErrPasteNotFound
+	}
+
+	file, err := os.OpenFile(f.receiptPath(id), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if os.IsExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("creating read receipt: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%d", f.clock.Now().Unix()); err != nil {
+		return false, fmt.Errorf("writing read receipt: %w", err)
+	}
+	return true, nil
+}
+
+// GetReadReceipt retrieves the read receipt for a paste.
+func (f *Filesystem) GetReadReceipt(ctx context.Context, id string) (*model.ReadReceipt, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.pasteExistsUnsafe(id) {
+		return nil, model.ErrPasteNotFound
+	}
+
+	receipt := &model.ReadReceipt{PasteID: id}
+	data, err := os.ReadFile(f.receiptPath(id))
+	if os.IsNotExist(err) {
+		return receipt, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading read receipt: %w", err)
+	}
+	if _, err := fmt.Sscanf(string(data), "%d", &receipt.FirstRead); err != nil {
+		return nil, fmt.Errorf("parsing read receipt: %w", err)
+	}
+	return receipt, nil
+}
+
 // SetValue stores a key-value pair.
-func (f *Filesystem) SetValue(namespace, key, value string) error {
+func (f *Filesystem) SetValue(ctx context.Context, namespace, key, value string) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
@@ -373,7 +808,7 @@ func (f *Filesystem) SetValue(namespace, key, value string) error {
 }
 
 // GetValue retrieves a stored value.
-func (f *Filesystem) GetValue(namespace, key string) (string, error) {
+func (f *Filesystem) GetValue(ctx context.Context, namespace, key string) (string, error) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
@@ -388,82 +823,118 @@ func (f *Filesystem) GetValue(namespace, key string) (string, error) {
 	return string(data), nil
 }
 
-// GetExpiredPastes returns a list of expired paste IDs.
-func (f *Filesystem) GetExpiredPastes(batchSize int) ([]string, error) {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-
-	var expired []string
-	now := time.Now().Unix()
-
-	// Walk the data directory looking for paste files
-	err := filepath.WalkDir(f.baseDir, func(path string, d os.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
-			return nil
-		}
-
-		// Skip config directory
-		if strings.Contains(path, "_config") {
-			return nil
-		}
-
-		// Skip discussion files
-		if strings.Contains(path, ".discussion") || strings.HasSuffix(path, ".json") {
-			return nil
-		}
+// CompareAndSwapValue sets a value if it still holds old. Instances
+// sharing the directory serialize swaps with a lock file, so one of two
+// concurrent swaps from the same value fails.
+func (f *Filesystem) CompareAndSwapValue(ctx context.Context, namespace, key, old, new string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+	unlock, err := f.Lock(ctx, "values")
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
 
-		// Read paste to check expiration
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return nil
-		}
+	current, err := f.GetValue(ctx, namespace, key)
+	if err != nil || current != old {
+		return false, err
+	}
+	return true, f.SetValue(ctx, namespace, key, new)
+}
 
-		var storageData pasteStorageData
-		if err := json.Unmarshal(data, &storageData); err != nil {
-			return nil
-		}
+// Lock takes a named lock with a lock file in the config directory,
+// which other instances sharing the directory honor too.
+func (f *Filesystem) Lock(ctx context.Context, name string) (func(), error) {
+	return lockFile(ctx, filepath.Join(f.baseDir, "_config", ".lock-"+name))
+}
 
-		if storageData.Meta.ExpireDate > 0 && storageData.Meta.ExpireDate < now {
-			// Extract ID from path
-			id := filepath.Base(path)
-			expired = append(expired, id)
-			if len(expired) >= batchSize {
-				return filepath.SkipAll
-			}
-		}
+// GetExpiredPastes returns a list of expired paste IDs, oldest first, from
+// the expiration index.
+func (f *Filesystem) GetExpiredPastes(ctx context.Context, batchSize int) ([]string, error) {
+	if err := f.indexExpiry(ctx); err != nil {
+		return nil, err
+	}
 
-		return nil
-	})
+	f.mu.RLock()
+	defer f.mu.RUnlock()
 
-	return expired, err
+	entries, err := f.expiredEntriesUnsafe(ctx, batchSize, f.clock.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(entries))
+	for i, e := range entries {
+		ids[i] = e.id
+	}
+	return ids, nil
 }
 
-// Purge deletes expired pastes.
-func (f *Filesystem) Purge(batchSize int) (int, error) {
-	ids, err := f.GetExpiredPastes(batchSize)
+// Purge deletes expired pastes. Index entries whose paste is gone or no
+// longer expires then are removed along the way.
+func (f *Filesystem) Purge(ctx context.Context, batchSize int) (int, error) {
+	if err := f.indexExpiry(ctx); err != nil {
+		return 0, err
+	}
+
+	now := f.clock.Now()
+	f.mu.RLock()
+	entries, err := f.expiredEntriesUnsafe(ctx, batchSize, now.Unix())
+	f.mu.RUnlock()
 	if err != nil {
 		return 0, err
 	}
 
 	count := 0
-	for _, id := range ids {
-		if err := f.DeletePaste(id); err != nil && err != model.ErrPasteNotFound {
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		deleted, err := f.purgeEntry(e, now)
+		if err != nil {
 			return count, err
 		}
-		count++
+		if deleted {
+			count++
+		}
 	}
 
 	return count, nil
 }
 
+// purgeEntry deletes the paste of an expired index entry if it is still
+// expired, and drops the entry.
+func (f *Filesystem) purgeEntry(e expiryEntry, now time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	paste, err := f.loadPasteUnsafe(e.id)
+	switch {
+	case errors.Is(err, model.ErrPasteNotFound):
+		f.removeExpiry(e.id, e.expireDate)
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("paste %s: %w", e.id, err)
+	case paste.Meta.ExpiresAt() != e.expireDate:
+		// Stale entry; the paste was moved to its new date
+		f.removeExpiry(e.id, e.expireDate)
+		if !paste.IsExpiredAt(now) {
+			return false, nil
+		}
+	}
+	if err := f.deletePasteUnsafe(e.id); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 // PurgeValues removes old config entries.
-func (f *Filesystem) PurgeValues(namespace string, maxAge int64) error {
+func (f *Filesystem) PurgeValues(ctx context.Context, namespace string, maxAge int64) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
 	configDir := filepath.Join(f.baseDir, "_config")
 	prefix := namespace + "_"
-	cutoff := time.Now().Unix() - maxAge
+	cutoff := f.clock.Now().Unix() - maxAge
 
 	entries, err := os.ReadDir(configDir)
 	if err != nil {
@@ -484,7 +955,7 @@ func (f *Filesystem) PurgeValues(namespace string, maxAge int64) error {
 		// Try to parse as timestamp
 		var timestamp int64
 		if _, err := fmt.Sscanf(string(data), "%d", &timestamp); err == nil {
-			if timestamp < cutoff {
+			if valueSeconds(timestamp) < cutoff {
 				os.Remove(path)
 			}
 		}
@@ -493,6 +964,222 @@ func (f *Filesystem) PurgeValues(namespace string, maxAge int64) error {
 	return nil
 }
 
+// PasteIDs returns the IDs of all stored pastes, loose and packed.
+func (f *Filesystem) PasteIDs(ctx context.Context) ([]string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	var ids []string
+	seen := map[string]bool{}
+	err := filepath.WalkDir(f.baseDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			if name := d.Name(); name == "_config" || name == expiryDirName || strings.HasSuffix(name, ".discussion") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Paste files are named by their ID; receipts, counters, packs,
+		// and temporary files are not
+		if util.ValidateID(d.Name()) {
+			ids = append(ids, d.Name())
+			seen[d.Name()] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walking data directory: %w", err)
+	}
+
+	shards, err := os.ReadDir(f.baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("reading data directory: %w", err)
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || len(shard.Name()) != 2 {
+			continue
+		}
+		idx, err := f.loadPack(shard.Name())
+		if err != nil {
+			return nil, err
+		}
+		if idx == nil {
+			continue
+		}
+		for id := range idx.Entries {
+			// A paste being unpacked may briefly be in both places
+			if !seen[id] {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// IteratePastes calls fn for every paste that hasn't expired. Pastes are
+// read one at a time from the listing of PasteIDs, without the lock held
+// while fn runs; pastes deleted in the meantime are skipped.
+func (f *Filesystem) IteratePastes(ctx context.Context, fn func(*model.Paste) error) error {
+	ids, err := f.PasteIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		f.mu.RLock()
+		paste, err := f.loadPasteUnsafe(id)
+		f.mu.RUnlock()
+		switch {
+		case errors.Is(err, model.ErrPasteNotFound):
+			continue
+		case err != nil:
+			return fmt.Errorf("paste %s: %w", id, err)
+		case paste.IsExpiredAt(f.clock.Now()):
+			continue // Left for Purge
+		}
+		if err := fn(paste); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PasteStats tallies the pastes that haven't expired. The filesystem has
+// no index to aggregate from, so every paste is read.
+func (f *Filesystem) PasteStats(ctx context.Context, since int64) (*PasteStats, error) {
+	return tallyPastes(ctx, f, since)
+}
+
+// WriteAttachment streams an attachment into a temporary file, renamed
+// into place once complete. The lock isn't held while copying, so a slow
+// upload doesn't hold up other requests.
+func (f *Filesystem) WriteAttachment(ctx context.Context, id string, index int, r io.Reader) (int64, error) {
+	path := f.attachmentPath(id, index)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, fmt.Errorf("creating paste directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".attachment-*")
+	if err != nil {
+		return 0, fmt.Errorf("creating attachment file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("writing attachment: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return n, fmt.Errorf("storing attachment: %w", err)
+	}
+	return n, nil
+}
+
+// OpenAttachment opens one of a paste's attachment files.
+func (f *Filesystem) OpenAttachment(ctx context.Context, id string, index int) (io.ReadCloser, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	file, err := os.Open(f.attachmentPath(id, index))
+	if os.IsNotExist(err) {
+		return nil, model.ErrPasteNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening attachment: %w", err)
+	}
+	return file, nil
+}
+
+// DeleteAttachments removes a paste's attachment files.
+func (f *Filesystem) DeleteAttachments(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deleteAttachmentsUnsafe(id)
+}
+
+// deleteAttachmentsUnsafe removes a paste's attachment files without locking.
+func (f *Filesystem) deleteAttachmentsUnsafe(id string) error {
+	dir, name := filepath.Split(f.pastePath(id))
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading paste directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), name+".attachment.") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("deleting attachment: %w", err)
+		}
+	}
+	return nil
+}
+
+// Values calls fn for every file in the _config directory.
+func (f *Filesystem) Values(ctx context.Context, fn func(namespace, key, value string) error) error {
+	configDir := filepath.Join(f.baseDir, "_config")
+	entries, err := os.ReadDir(configDir)
+	if err != nil {
+		return fmt.Errorf("reading config directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		namespace, key, ok := strings.Cut(entry.Name(), "_")
+		if entry.IsDir() || !ok || strings.HasSuffix(key, ".tmp") {
+			continue
+		}
+		value, err := f.GetValue(ctx, namespace, key)
+		if err != nil {
+			return err
+		}
+		if err := fn(namespace, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Warmup checks that the data directory is writable, so a read-only mount
+// fails at startup rather than on the first paste. Writes are synchronous,
+// so the filesystem backend has nothing to drain at shutdown.
+func (f *Filesystem) Warmup(ctx context.Context) error {
+	tmp, err := os.CreateTemp(f.baseDir, ".warmup-*")
+	if err != nil {
+		return fmt.Errorf("data directory not writable: %w", err)
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	return ctx.Err()
+}
+
+// Ping checks that the data directory is still there.
+func (f *Filesystem) Ping(ctx context.Context) error {
+	info, err := os.Stat(f.baseDir)
+	if err != nil {
+		return fmt.Errorf("data directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("data directory %s is not a directory", f.baseDir)
+	}
+	return ctx.Err()
+}
+
 // Close is a no-op for filesystem storage.
 func (f *Filesystem) Close() error {
 	return nil
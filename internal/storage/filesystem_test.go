@@ -2,12 +2,17 @@
 package storage
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/liskl/flashpaper/internal/clock"
 	"github.com/liskl/flashpaper/internal/config"
 	"github.com/liskl/flashpaper/internal/model"
 )
@@ -24,16 +29,18 @@ func testFilesystemConfig(t *testing.T) *config.Config {
 }
 
 func TestNewFilesystem_CreatesBaseDir(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
 	defer fs.Close()
 
 	// Verify we can use the filesystem
-	assert.False(t, fs.PasteExists("nonexistent"))
+	assert.False(t, fs.PasteExists(ctx, "nonexistent"))
 }
 
 func TestFilesystem_CreatePaste_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -51,14 +58,33 @@ func TestFilesystem_CreatePaste_Success(t *testing.T) {
 		},
 	}
 
-	err = fs.CreatePaste("f468483c313401e8", paste)
+	err = fs.CreatePaste(ctx, "f468483c313401e8", paste)
 	require.NoError(t, err)
 
 	// Verify paste exists
-	assert.True(t, fs.PasteExists("f468483c313401e8"))
+	assert.True(t, fs.PasteExists(ctx, "f468483c313401e8"))
+}
+
+func TestFilesystem_IteratePastes_Canceled(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	defer fs.Close()
+	require.NoError(t, fs.CreatePaste(ctx, "f468483c313401e8", &model.Paste{Data: "content"}))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = fs.IteratePastes(canceled, func(*model.Paste) error {
+		t.Error("iterated with a canceled context")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = fs.Purge(canceled, 10)
+	assert.ErrorIs(t, err, context.Canceled)
 }
 
 func TestFilesystem_CreatePaste_DuplicateID_ReturnsError(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -66,15 +92,16 @@ func TestFilesystem_CreatePaste_DuplicateID_ReturnsError(t *testing.T) {
 
 	paste := &model.Paste{Data: "content"}
 
-	err = fs.CreatePaste("testpasteid1234", paste)
+	err = fs.CreatePaste(ctx, "testpasteid1234", paste)
 	require.NoError(t, err)
 
 	// Try to create with same ID
-	err = fs.CreatePaste("testpasteid1234", paste)
+	err = fs.CreatePaste(ctx, "testpasteid1234", paste)
 	assert.ErrorIs(t, err, model.ErrPasteExists)
 }
 
 func TestFilesystem_ReadPaste_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -94,11 +121,11 @@ func TestFilesystem_ReadPaste_Success(t *testing.T) {
 	}
 
 	pasteID := "readpaste123456"
-	err = fs.CreatePaste(pasteID, original)
+	err = fs.CreatePaste(ctx, pasteID, original)
 	require.NoError(t, err)
 
 	// Read it back
-	read, err := fs.ReadPaste(pasteID)
+	read, err := fs.ReadPaste(ctx, pasteID)
 	require.NoError(t, err)
 
 	assert.Equal(t, original.Data, read.Data)
@@ -108,16 +135,18 @@ func TestFilesystem_ReadPaste_Success(t *testing.T) {
 }
 
 func TestFilesystem_ReadPaste_NotFound(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
 	defer fs.Close()
 
-	_, err = fs.ReadPaste("nonexistent12345")
+	_, err = fs.ReadPaste(ctx, "nonexistent12345")
 	assert.ErrorIs(t, err, model.ErrPasteNotFound)
 }
 
 func TestFilesystem_ReadPaste_Expired(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -131,15 +160,16 @@ func TestFilesystem_ReadPaste_Expired(t *testing.T) {
 	}
 
 	pasteID := "expiredpaste123"
-	err = fs.CreatePaste(pasteID, paste)
+	err = fs.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	// Should return expired error
-	_, err = fs.ReadPaste(pasteID)
+	_, err = fs.ReadPaste(ctx, pasteID)
 	assert.ErrorIs(t, err, model.ErrPasteExpired)
 }
 
 func TestFilesystem_DeletePaste_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -147,30 +177,32 @@ func TestFilesystem_DeletePaste_Success(t *testing.T) {
 
 	paste := &model.Paste{Data: "to delete"}
 	pasteID := "deletepaste1234"
-	err = fs.CreatePaste(pasteID, paste)
+	err = fs.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	// Delete it
-	err = fs.DeletePaste(pasteID)
+	err = fs.DeletePaste(ctx, pasteID)
 	require.NoError(t, err)
 
 	// Verify it's gone
-	assert.False(t, fs.PasteExists(pasteID))
+	assert.False(t, fs.PasteExists(ctx, pasteID))
 }
 
 func TestFilesystem_DeletePaste_NotFound(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
 	defer fs.Close()
 
-	err = fs.DeletePaste("nonexistent12345")
+	err = fs.DeletePaste(ctx, "nonexistent12345")
 	assert.ErrorIs(t, err, model.ErrPasteNotFound)
 }
 
 // Comment tests
 
 func TestFilesystem_CreateComment_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -179,7 +211,7 @@ func TestFilesystem_CreateComment_Success(t *testing.T) {
 	// First create a paste
 	paste := &model.Paste{Data: "paste for comments"}
 	pasteID := "commentpaste123"
-	err = fs.CreatePaste(pasteID, paste)
+	err = fs.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	// Create a comment
@@ -192,25 +224,27 @@ func TestFilesystem_CreateComment_Success(t *testing.T) {
 		},
 	}
 
-	err = fs.CreateComment(pasteID, pasteID, "comment12345678", comment)
+	err = fs.CreateComment(ctx, pasteID, pasteID, "comment12345678", comment)
 	require.NoError(t, err)
 
 	// Verify comment exists
-	assert.True(t, fs.CommentExists(pasteID, pasteID, "comment12345678"))
+	assert.True(t, fs.CommentExists(ctx, pasteID, pasteID, "comment12345678"))
 }
 
 func TestFilesystem_CreateComment_PasteNotFound(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
 	defer fs.Close()
 
 	comment := &model.Comment{Data: "orphan comment"}
-	err = fs.CreateComment("nonexistent12345", "nonexistent12345", "comment12345678", comment)
+	err = fs.CreateComment(ctx, "nonexistent12345", "nonexistent12345", "comment12345678", comment)
 	assert.ErrorIs(t, err, model.ErrPasteNotFound)
 }
 
 func TestFilesystem_CreateComment_DuplicateID(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -219,21 +253,139 @@ func TestFilesystem_CreateComment_DuplicateID(t *testing.T) {
 	// First create a paste
 	paste := &model.Paste{Data: "paste data"}
 	pasteID := "dupcomment12345"
-	err = fs.CreatePaste(pasteID, paste)
+	err = fs.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	// Create a comment
 	comment := &model.Comment{Data: "comment"}
 	commentID := "commentdup12345"
-	err = fs.CreateComment(pasteID, pasteID, commentID, comment)
+	err = fs.CreateComment(ctx, pasteID, pasteID, commentID, comment)
 	require.NoError(t, err)
 
 	// Try to create again with same ID
-	err = fs.CreateComment(pasteID, pasteID, commentID, comment)
+	err = fs.CreateComment(ctx, pasteID, pasteID, commentID, comment)
 	assert.ErrorIs(t, err, model.ErrCommentExists)
 }
 
+func TestFilesystem_CreateComment_LimitUnderConcurrency(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	cfg.Main.CommentLimit = 3
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	err = fs.CreatePaste(ctx, "limited123456789", &model.Paste{Data: "content"})
+	require.NoError(t, err)
+
+	// Race more writers than the limit allows
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			comment := &model.Comment{Data: "comment", Meta: model.CommentMeta{PostDate: time.Now().Unix()}}
+			results <- fs.CreateComment(ctx, "limited123456789", "", fmt.Sprintf("comment%02d", i), comment)
+		}(i)
+	}
+
+	stored, limited := 0, 0
+	for i := 0; i < 10; i++ {
+		switch err := <-results; err {
+		case nil:
+			stored++
+		case model.ErrCommentLimitReached:
+			limited++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, 3, stored)
+	assert.Equal(t, 7, limited)
+
+	// Counter and comments agree
+	count, err := fs.CountComments(ctx, "limited123456789")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	comments, err := fs.ReadComments(ctx, "limited123456789")
+	require.NoError(t, err)
+	assert.Len(t, comments, 3)
+}
+
+func TestFilesystem_CreateComment_DuplicateDoesNotCount(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	err = fs.CreatePaste(ctx, "limited123456789", &model.Paste{Data: "content"})
+	require.NoError(t, err)
+
+	comment := &model.Comment{Data: "comment"}
+	require.NoError(t, fs.CreateComment(ctx, "limited123456789", "", "comment1", comment))
+	assert.ErrorIs(t, fs.CreateComment(ctx, "limited123456789", "", "comment1", comment), model.ErrCommentExists)
+
+	count, err := fs.CountComments(ctx, "limited123456789")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestFilesystem_CreatePaste_PersistsCategory(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	paste := &model.Paste{Data: "content", Meta: model.PasteMeta{Category: model.CategoryImage}}
+	require.NoError(t, fs.CreatePaste(ctx, "category12345678", paste))
+
+	read, err := fs.ReadPaste(ctx, "category12345678")
+	require.NoError(t, err)
+	assert.Equal(t, model.CategoryImage, read.Meta.Category)
+}
+
+func TestFilesystem_CreatePaste_PersistsSalt(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	paste := &model.Paste{Data: "content", Meta: model.PasteMeta{Salt: "cGFzdGUtc2FsdA=="}}
+	require.NoError(t, fs.CreatePaste(ctx, "salted1234567890", paste))
+
+	read, err := fs.ReadPaste(ctx, "salted1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, "cGFzdGUtc2FsdA==", read.Meta.Salt)
+
+	require.NoError(t, fs.SetPinned(ctx, "salted1234567890", true))
+	read, err = fs.ReadPaste(ctx, "salted1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, "cGFzdGUtc2FsdA==", read.Meta.Salt)
+}
+
+func TestFilesystem_CreateComment_PersistsFlag(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	err = fs.CreatePaste(ctx, "flagged123456789", &model.Paste{Data: "content"})
+	require.NoError(t, err)
+
+	comment := &model.Comment{Data: "comment", Meta: model.CommentMeta{Flagged: true}}
+	require.NoError(t, fs.CreateComment(ctx, "flagged123456789", "", "comment1", comment))
+
+	comments, err := fs.ReadComments(ctx, "flagged123456789")
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+	assert.True(t, comments[0].Meta.Flagged)
+}
+
 func TestFilesystem_ReadComments_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -242,7 +394,7 @@ func TestFilesystem_ReadComments_Success(t *testing.T) {
 	// Create a paste
 	paste := &model.Paste{Data: "paste with multiple comments"}
 	pasteID := "multicomment123"
-	err = fs.CreatePaste(pasteID, paste)
+	err = fs.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	// Create multiple comments
@@ -256,13 +408,13 @@ func TestFilesystem_ReadComments_Success(t *testing.T) {
 		Meta: model.CommentMeta{PostDate: now + 1},
 	}
 
-	err = fs.CreateComment(pasteID, pasteID, "comment10000001", comment1)
+	err = fs.CreateComment(ctx, pasteID, pasteID, "comment10000001", comment1)
 	require.NoError(t, err)
-	err = fs.CreateComment(pasteID, pasteID, "comment20000002", comment2)
+	err = fs.CreateComment(ctx, pasteID, pasteID, "comment20000002", comment2)
 	require.NoError(t, err)
 
 	// Read all comments
-	comments, err := fs.ReadComments(pasteID)
+	comments, err := fs.ReadComments(ctx, pasteID)
 	require.NoError(t, err)
 	assert.Len(t, comments, 2)
 
@@ -272,6 +424,7 @@ func TestFilesystem_ReadComments_Success(t *testing.T) {
 }
 
 func TestFilesystem_ReadComments_NoComments(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -280,16 +433,17 @@ func TestFilesystem_ReadComments_NoComments(t *testing.T) {
 	// Create a paste without comments
 	paste := &model.Paste{Data: "lonely paste"}
 	pasteID := "nocomments12345"
-	err = fs.CreatePaste(pasteID, paste)
+	err = fs.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	// Read comments (should be empty, not error)
-	comments, err := fs.ReadComments(pasteID)
+	comments, err := fs.ReadComments(ctx, pasteID)
 	require.NoError(t, err)
 	assert.Empty(t, comments)
 }
 
 func TestFilesystem_CommentExists(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -298,62 +452,145 @@ func TestFilesystem_CommentExists(t *testing.T) {
 	// Create a paste and comment
 	paste := &model.Paste{Data: "paste"}
 	pasteID := "existscheck1234"
-	err = fs.CreatePaste(pasteID, paste)
+	err = fs.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	comment := &model.Comment{Data: "comment"}
 	commentID := "commentexists12"
-	err = fs.CreateComment(pasteID, pasteID, commentID, comment)
+	err = fs.CreateComment(ctx, pasteID, pasteID, commentID, comment)
 	require.NoError(t, err)
 
 	// Should exist
-	assert.True(t, fs.CommentExists(pasteID, pasteID, commentID))
+	assert.True(t, fs.CommentExists(ctx, pasteID, pasteID, commentID))
 
 	// Should not exist
-	assert.False(t, fs.CommentExists(pasteID, pasteID, "nonexistent1234"))
+	assert.False(t, fs.CommentExists(ctx, pasteID, pasteID, "nonexistent1234"))
 }
 
 // Config/Value tests
 
+func TestFilesystem_MarkRead_RecordsFirstReadOnly(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	pasteID := "receipt123456789"
+	require.NoError(t, fs.CreatePaste(ctx, pasteID, &model.Paste{Data: "content"}))
+
+	// Unread paste has an empty receipt
+	receipt, err := fs.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.False(t, receipt.IsRead())
+
+	first, err := fs.MarkRead(ctx, pasteID)
+	require.NoError(t, err)
+	assert.True(t, first)
+
+	receipt, err = fs.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.True(t, receipt.IsRead())
+	firstRead := receipt.FirstRead
+
+	// Later reads don't move the timestamp
+	first, err = fs.MarkRead(ctx, pasteID)
+	require.NoError(t, err)
+	assert.False(t, first)
+
+	receipt, err = fs.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, firstRead, receipt.FirstRead)
+
+	// Deleting the paste removes its receipt
+	require.NoError(t, fs.DeletePaste(ctx, pasteID))
+	_, err = fs.GetReadReceipt(ctx, pasteID)
+	assert.ErrorIs(t, err, model.ErrPasteNotFound)
+
+	require.NoError(t, fs.CreatePaste(ctx, pasteID, &model.Paste{Data: "content"}))
+	receipt, err = fs.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.False(t, receipt.IsRead())
+}
+
+func TestFilesystem_MarkRead_PasteNotFound(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	_, err = fs.MarkRead(ctx, "nonexistent12345")
+	assert.ErrorIs(t, err, model.ErrPasteNotFound)
+}
+
 func TestFilesystem_SetValue_Success(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
 	defer fs.Close()
 
-	err = fs.SetValue("test", "key1", "value1")
+	err = fs.SetValue(ctx, "test", "key1", "value1")
 	require.NoError(t, err)
 
 	// Verify we can read it back
-	value, err := fs.GetValue("test", "key1")
+	value, err := fs.GetValue(ctx, "test", "key1")
 	require.NoError(t, err)
 	assert.Equal(t, "value1", value)
 }
 
 func TestFilesystem_GetValue_NotFound(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
 	defer fs.Close()
 
-	value, err := fs.GetValue("nonexistent", "key")
+	value, err := fs.GetValue(ctx, "nonexistent", "key")
 	require.NoError(t, err) // Not an error, just empty
 	assert.Empty(t, value)
 }
 
+func TestFilesystem_PurgeValues(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	// The rate limiter stores milliseconds, other users seconds
+	now := time.Now()
+	require.NoError(t, fs.SetValue(ctx, NamespaceTraffic, "old", fmt.Sprint(now.Add(-time.Hour).Unix())))
+	require.NoError(t, fs.SetValue(ctx, NamespaceTraffic, "full", fmt.Sprint(now.Add(-time.Minute).UnixMilli())))
+	require.NoError(t, fs.SetValue(ctx, NamespaceTraffic, "refilling", fmt.Sprint(now.Add(time.Minute).UnixMilli())))
+	require.NoError(t, fs.SetValue(ctx, NamespaceSalt, "server", "pepper"))
+	require.NoError(t, fs.PurgeValues(ctx, NamespaceTraffic, 0))
+
+	for key, kept := range map[string]bool{"old": false, "full": false, "refilling": true} {
+		value, err := fs.GetValue(ctx, NamespaceTraffic, key)
+		require.NoError(t, err)
+		assert.Equal(t, kept, value != "", key)
+	}
+	salt, err := fs.GetValue(ctx, NamespaceSalt, "server")
+	require.NoError(t, err)
+	assert.Equal(t, "pepper", salt)
+}
+
 func TestFilesystem_SetValue_Overwrite(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
 	defer fs.Close()
 
-	err = fs.SetValue("test", "key", "original")
+	err = fs.SetValue(ctx, "test", "key", "original")
 	require.NoError(t, err)
 
-	err = fs.SetValue("test", "key", "updated")
+	err = fs.SetValue(ctx, "test", "key", "updated")
 	require.NoError(t, err)
 
-	value, err := fs.GetValue("test", "key")
+	value, err := fs.GetValue(ctx, "test", "key")
 	require.NoError(t, err)
 	assert.Equal(t, "updated", value)
 }
@@ -361,6 +598,7 @@ func TestFilesystem_SetValue_Overwrite(t *testing.T) {
 // Expiration tests
 
 func TestFilesystem_GetExpiredPastes(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -373,7 +611,7 @@ func TestFilesystem_GetExpiredPastes(t *testing.T) {
 			ExpireDate: time.Now().Add(-time.Hour).Unix(),
 		},
 	}
-	err = fs.CreatePaste("expired12345678", expiredPaste)
+	err = fs.CreatePaste(ctx, "expired12345678", expiredPaste)
 	require.NoError(t, err)
 
 	// Create non-expired paste
@@ -383,7 +621,7 @@ func TestFilesystem_GetExpiredPastes(t *testing.T) {
 			ExpireDate: time.Now().Add(time.Hour).Unix(),
 		},
 	}
-	err = fs.CreatePaste("valid123456789a", validPaste)
+	err = fs.CreatePaste(ctx, "valid123456789a", validPaste)
 	require.NoError(t, err)
 
 	// Create never-expires paste
@@ -393,11 +631,11 @@ func TestFilesystem_GetExpiredPastes(t *testing.T) {
 			ExpireDate: 0,
 		},
 	}
-	err = fs.CreatePaste("never123456789a", neverPaste)
+	err = fs.CreatePaste(ctx, "never123456789a", neverPaste)
 	require.NoError(t, err)
 
 	// Get expired pastes
-	expired, err := fs.GetExpiredPastes(10)
+	expired, err := fs.GetExpiredPastes(ctx, 10)
 	require.NoError(t, err)
 
 	// Should only contain the expired one
@@ -405,7 +643,85 @@ func TestFilesystem_GetExpiredPastes(t *testing.T) {
 	assert.Contains(t, expired, "expired12345678")
 }
 
+func TestFilesystem_SetPinned(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	paste := &model.Paste{
+		Data: "privacy policy",
+		Meta: model.PasteMeta{ExpireDate: time.Now().Add(-time.Hour).Unix()},
+	}
+	require.NoError(t, fs.CreatePaste(ctx, "a1b2c3d4e5f60718", paste))
+	require.NoError(t, fs.SetPinned(ctx, "a1b2c3d4e5f60718", true))
+
+	// Pinned pastes outlive their expiration
+	expired, err := fs.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	read, err := fs.ReadPaste(ctx, "a1b2c3d4e5f60718")
+	require.NoError(t, err)
+	assert.True(t, read.Meta.Pinned)
+
+	// Unpinning restores the original expiration
+	require.NoError(t, fs.SetPinned(ctx, "a1b2c3d4e5f60718", false))
+	expired, err = fs.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1b2c3d4e5f60718"}, expired)
+
+	assert.Equal(t, model.ErrPasteNotFound, fs.SetPinned(ctx, "0000000000000000", true))
+}
+
+func TestFilesystem_SetDisabled(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	require.NoError(t, fs.CreatePaste(ctx, "a1b2c3d4e5f60718", &model.Paste{Data: "snippet"}))
+	require.NoError(t, fs.SetDisabled(ctx, "a1b2c3d4e5f60718", true))
+	read, err := fs.ReadPaste(ctx, "a1b2c3d4e5f60718")
+	require.NoError(t, err)
+	assert.True(t, read.Meta.Disabled)
+
+	require.NoError(t, fs.SetDisabled(ctx, "a1b2c3d4e5f60718", false))
+	read, err = fs.ReadPaste(ctx, "a1b2c3d4e5f60718")
+	require.NoError(t, err)
+	assert.False(t, read.Meta.Disabled)
+
+	assert.Equal(t, model.ErrPasteNotFound, fs.SetDisabled(ctx, "0000000000000000", true))
+}
+
+func TestFilesystem_SetExpireDate(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	paste := &model.Paste{
+		Data: "snippet",
+		Meta: model.PasteMeta{ExpireDate: time.Now().Add(-time.Hour).Unix()},
+	}
+	require.NoError(t, fs.CreatePaste(ctx, "a1b2c3d4e5f60718", paste))
+	later := time.Now().Add(time.Hour).Unix()
+	require.NoError(t, fs.SetExpireDate(ctx, "a1b2c3d4e5f60718", later))
+
+	expired, err := fs.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+	read, err := fs.ReadPaste(ctx, "a1b2c3d4e5f60718")
+	require.NoError(t, err)
+	assert.Equal(t, later, read.Meta.ExpireDate)
+
+	assert.Equal(t, model.ErrPasteNotFound, fs.SetExpireDate(ctx, "0000000000000000", later))
+}
+
 func TestFilesystem_Purge(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -418,19 +734,87 @@ func TestFilesystem_Purge(t *testing.T) {
 			ExpireDate: time.Now().Add(-time.Hour).Unix(),
 		},
 	}
-	err = fs.CreatePaste("purge123456789a", expiredPaste)
+	err = fs.CreatePaste(ctx, "purge123456789a", expiredPaste)
 	require.NoError(t, err)
 
 	// Verify the paste exists before purge
-	assert.True(t, fs.PasteExists("purge123456789a"))
+	assert.True(t, fs.PasteExists(ctx, "purge123456789a"))
 
 	// Purge - should delete at least 1 paste (our expired one)
-	count, err := fs.Purge(10)
+	count, err := fs.Purge(ctx, 10)
 	require.NoError(t, err)
 	assert.GreaterOrEqual(t, count, 1)
 
 	// Verify it's gone - this is the key assertion
-	assert.False(t, fs.PasteExists("purge123456789a"))
+	assert.False(t, fs.PasteExists(ctx, "purge123456789a"))
+}
+
+func TestFilesystem_Purge_ExpiryIndex(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	c := clock.NewFake(time.Now())
+	fs.SetClock(c)
+
+	for i, expire := range []time.Duration{3 * time.Hour, time.Hour, 2 * time.Hour, 0} {
+		paste := &model.Paste{Data: "snippet"}
+		paste.SetExpirationFrom(c.Now(), expire)
+		require.NoError(t, fs.CreatePaste(ctx, fmt.Sprintf("a1b2c3d4e5f6071%d", i), paste))
+	}
+	// Moving an expiration moves its index entry
+	require.NoError(t, fs.SetExpireDate(ctx, "a1b2c3d4e5f60712", c.Now().Add(48*time.Hour).Unix()))
+
+	c.Advance(4 * time.Hour)
+	expired, err := fs.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a1b2c3d4e5f60711", "a1b2c3d4e5f60710"}, expired, "oldest first")
+
+	count, err := fs.Purge(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.False(t, fs.PasteExists(ctx, "a1b2c3d4e5f60711"))
+	assert.True(t, fs.PasteExists(ctx, "a1b2c3d4e5f60710"))
+
+	// Deleted pastes leave no entries behind
+	require.NoError(t, fs.DeletePaste(ctx, "a1b2c3d4e5f60710"))
+	expired, err = fs.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+
+	c.Advance(48 * time.Hour)
+	count, err = fs.Purge(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.True(t, fs.PasteExists(ctx, "a1b2c3d4e5f60713"))
+}
+
+func TestFilesystem_Purge_BuildsExpiryIndex(t *testing.T) {
+	ctx := context.Background()
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Hour).Unix()
+	require.NoError(t, fs.CreatePaste(ctx, "a1b2c3d4e5f60718", &model.Paste{Data: "loose", Meta: model.PasteMeta{ExpireDate: past}}))
+	require.NoError(t, fs.CreatePaste(ctx, "f468483c313401e8", compactTestPaste("packed", time.Hour)))
+	stats, err := fs.Compact(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Packed) // The expired paste stays loose
+
+	// A data directory from before the index existed
+	require.NoError(t, os.RemoveAll(filepath.Join(cfg.Model.Dir, "_expiry")))
+	fs, err = NewFilesystem(cfg)
+	require.NoError(t, err)
+	fs.SetClock(clock.NewFake(time.Now().Add(2 * time.Hour)))
+
+	count, err := fs.Purge(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.False(t, fs.PasteExists(ctx, "a1b2c3d4e5f60718"))
+	assert.False(t, fs.PasteExists(ctx, "f468483c313401e8"))
+	_, err = os.Stat(filepath.Join(cfg.Model.Dir, "_expiry", "complete"))
+	assert.NoError(t, err)
 }
 
 func TestFilesystem_Close(t *testing.T) {
@@ -443,6 +827,7 @@ func TestFilesystem_Close(t *testing.T) {
 }
 
 func TestFilesystem_DeletePaste_WithComments(t *testing.T) {
+	ctx := context.Background()
 	cfg := testFilesystemConfig(t)
 	fs, err := NewFilesystem(cfg)
 	require.NoError(t, err)
@@ -451,18 +836,112 @@ func TestFilesystem_DeletePaste_WithComments(t *testing.T) {
 	// Create paste with comments
 	paste := &model.Paste{Data: "paste with comments"}
 	pasteID := "delwithcomment1"
-	err = fs.CreatePaste(pasteID, paste)
+	err = fs.CreatePaste(ctx, pasteID, paste)
 	require.NoError(t, err)
 
 	comment := &model.Comment{Data: "comment"}
-	err = fs.CreateComment(pasteID, pasteID, "comment12345678", comment)
+	err = fs.CreateComment(ctx, pasteID, pasteID, "comment12345678", comment)
 	require.NoError(t, err)
 
 	// Delete paste (should also delete discussion directory)
-	err = fs.DeletePaste(pasteID)
+	err = fs.DeletePaste(ctx, pasteID)
 	require.NoError(t, err)
 
 	// Both should be gone
-	assert.False(t, fs.PasteExists(pasteID))
-	assert.False(t, fs.CommentExists(pasteID, pasteID, "comment12345678"))
+	assert.False(t, fs.PasteExists(ctx, pasteID))
+	assert.False(t, fs.CommentExists(ctx, pasteID, pasteID, "comment12345678"))
+}
+
+func TestFilesystem_Ping(t *testing.T) {
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	require.NoError(t, fs.Ping(context.Background()))
+	require.NoError(t, os.RemoveAll(cfg.Model.Dir))
+	assert.ErrorContains(t, fs.Ping(context.Background()), "data directory")
+}
+
+func TestFilesystem_Warmup(t *testing.T) {
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	require.NoError(t, Warmup(context.Background(), fs))
+
+	// The probe file is cleaned up
+	entries, err := os.ReadDir(cfg.Model.Dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), "warmup")
+	}
+
+	// Nothing to drain
+	assert.NoError(t, Drain(context.Background(), fs))
+}
+
+func TestFilesystem_Warmup_ReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permission checks don't apply to root")
+	}
+
+	cfg := testFilesystemConfig(t)
+	fs, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	require.NoError(t, os.Chmod(cfg.Model.Dir, 0500))
+	defer os.Chmod(cfg.Model.Dir, 0700)
+
+	assert.Error(t, fs.Warmup(context.Background()))
+}
+
+func TestFilesystem_ReadAndDeletePaste(t *testing.T) {
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	checkReadAndDelete(t, fs)
+}
+
+func TestFilesystem_CountRead(t *testing.T) {
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	checkCountRead(t, fs)
+}
+
+func TestFilesystem_UpdatePaste(t *testing.T) {
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	checkUpdatePaste(t, fs)
+}
+
+func TestFilesystem_DeleteComment(t *testing.T) {
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	checkDeleteComment(t, fs)
+}
+
+func TestFilesystem_Clock(t *testing.T) {
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	checkClock(t, fs)
+}
+
+func TestFilesystem_Attachments(t *testing.T) {
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	checkAttachmentStore(t, fs)
+}
+
+func TestFilesystem_Iterate(t *testing.T) {
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	checkIterate(t, fs)
+}
+
+func TestFilesystem_PasteStats(t *testing.T) {
+	fs, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	checkPasteStats(t, fs)
 }
@@ -0,0 +1,425 @@
+// Package storage provides a minimal client for the Google Cloud Storage
+// JSON API, covering the object operations the Blob backend needs. Requests
+// carry OAuth2 access tokens of a service account: the one whose key file
+// GOOGLE_APPLICATION_CREDENTIALS names, or else the one attached to the
+// Compute Engine, GKE, or Cloud Run instance, from its metadata server.
+// With STORAGE_EMULATOR_HOST set, requests go to that emulator without
+// credentials, as with Google's own client libraries.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+const (
+	// gcsEndpoint is the root of the Cloud Storage APIs.
+	gcsEndpoint = "https://storage.googleapis.com"
+
+	// gcsScope is the OAuth2 scope requested for service account tokens.
+	gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// gcsTokenURI issues tokens for key files that don't name their own.
+	gcsTokenURI = "https://oauth2.googleapis.com/token"
+
+	// gcsMetadataHost serves instance tokens (GCE_METADATA_HOST overrides).
+	gcsMetadataHost = "metadata.google.internal"
+)
+
+// gcsClient talks to one Cloud Storage bucket.
+type gcsClient struct {
+	endpoint string // API root, without a trailing slash
+	bucket   string
+	tokens   *oauthTokens // nil for emulators, which take no credentials
+	http     *http.Client
+}
+
+// newGCSClient creates a client for bucket. [model] endpoint replaces the
+// API root, e.g. for a private endpoint.
+func newGCSClient(bucket string, m config.ModelConfig) (*gcsClient, error) {
+	c := &gcsClient{
+		endpoint: gcsEndpoint,
+		bucket:   bucket,
+		http:     &http.Client{Timeout: blobTimeout},
+	}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		c.endpoint = strings.TrimSuffix(host, "/")
+		return c, nil
+	}
+	if m.Endpoint != "" {
+		c.endpoint = strings.TrimSuffix(m.Endpoint, "/")
+	}
+
+	var err error
+	if file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); file != "" {
+		c.tokens, err = serviceAccountTokens(file, c.http)
+	} else {
+		host := os.Getenv("GCE_METADATA_HOST")
+		if host == "" {
+			host = gcsMetadataHost
+		}
+		c.tokens = metadataTokens(host, c.http)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// objectURL returns the JSON API URL of an object's metadata.
+func (c *gcsClient) objectURL(key string) string {
+	return c.endpoint + "/storage/v1/b/" + url.PathEscape(c.bucket) + "/o/" + url.PathEscape(key)
+}
+
+// do sends an authorized request and returns the response if it succeeded.
+// 404 and 412 map to errObjectNotFound and errPreconditionFailed.
+func (c *gcsClient) do(ctx context.Context, method, rawURL string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if c.tokens != nil {
+		token, err := c.tokens.accessToken(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("gcs credentials: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, errObjectNotFound
+	case http.StatusPreconditionFailed:
+		return nil, errPreconditionFailed
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return nil, fmt.Errorf("gcs %s %s: %s: %s", method, req.URL.Path, resp.Status, bytes.TrimSpace(msg))
+}
+
+// putObject uploads an object in a single request. An exclusive write is
+// conditional on generation 0, which only a missing object has.
+func (c *gcsClient) putObject(ctx context.Context, key string, body []byte, exclusive bool) error {
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	if exclusive {
+		query.Set("ifGenerationMatch", "0")
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	resp, err := c.do(ctx, http.MethodPost,
+		c.endpoint+"/upload/storage/v1/b/"+url.PathEscape(c.bucket)+"/o?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *gcsClient) getObject(ctx context.Context, key string) ([]byte, error) {
+	body, err := c.openObject(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// getVersion reads an object and its generation.
+func (c *gcsClient) getVersion(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.Header.Get("X-Goog-Generation"), err
+}
+
+// replaceObject uploads an object on the condition that its generation is
+// still version.
+func (c *gcsClient) replaceObject(ctx context.Context, key string, body []byte, version string) error {
+	query := url.Values{"uploadType": {"media"}, "name": {key}, "ifGenerationMatch": {version}}
+	resp, err := c.do(ctx, http.MethodPost,
+		c.endpoint+"/upload/storage/v1/b/"+url.PathEscape(c.bucket)+"/o?"+query.Encode(), body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// deleteVersion removes an object on the condition that its generation is
+// still version.
+func (c *gcsClient) deleteVersion(ctx context.Context, key, version string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.objectURL(key)+"?ifGenerationMatch="+url.QueryEscape(version), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *gcsClient) openObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *gcsClient) headObject(ctx context.Context, key string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.objectURL(key)+"?fields=name", nil)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+func (c *gcsClient) deleteObject(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.objectURL(key), nil)
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// gcsListing is the part of an objects.list response we use.
+type gcsListing struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (c *gcsClient) listObjects(ctx context.Context, prefix string, fn func(key string) bool) error {
+	query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+	for {
+		resp, err := c.do(ctx, http.MethodGet,
+			c.endpoint+"/storage/v1/b/"+url.PathEscape(c.bucket)+"/o?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		var listing gcsListing
+		err = json.NewDecoder(resp.Body).Decode(&listing)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding gcs listing: %w", err)
+		}
+
+		for _, item := range listing.Items {
+			if !fn(item.Name) {
+				return nil
+			}
+		}
+		if listing.NextPageToken == "" {
+			return nil
+		}
+		query.Set("pageToken", listing.NextPageToken)
+	}
+}
+
+func (c *gcsClient) headBucket(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint+"/storage/v1/b/"+url.PathEscape(c.bucket)+"?fields=name", nil)
+	if errors.Is(err, errObjectNotFound) {
+		return fmt.Errorf("gcs bucket %q not found", c.bucket)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *gcsClient) close() {
+	c.http.CloseIdleConnections()
+}
+
+// oauthTokens fetches OAuth2 access tokens and reuses each until shortly
+// before it expires.
+type oauthTokens struct {
+	request func(ctx context.Context, now time.Time) (*http.Request, error)
+	http    *http.Client
+	now     func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (t *oauthTokens) accessToken(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.token != "" && t.now().Add(5*time.Minute).Before(t.expires) {
+		return t.token, nil
+	}
+
+	req, err := t.request(ctx, t.now())
+	if err != nil {
+		return "", err
+	}
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return "", fmt.Errorf("token endpoint: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token: %w", err)
+	}
+	if body.AccessToken == "" {
+		return "", errors.New("token endpoint returned no access token")
+	}
+	t.token = body.AccessToken
+	t.expires = t.now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// metadataTokens returns the token source of the instance's service
+// account, served by the metadata server at host.
+func metadataTokens(host string, client *http.Client) *oauthTokens {
+	tokenURL := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token"
+	return &oauthTokens{
+		request: func(ctx context.Context, _ time.Time) (*http.Request, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Metadata-Flavor", "Google")
+			return req, nil
+		},
+		http: client,
+		now:  time.Now,
+	}
+}
+
+// serviceAccountTokens returns the token source of the service account
+// whose JSON key file is at file. Tokens are exchanged for a JWT signed
+// with the account's key.
+func serviceAccountTokens(file string, client *http.Client) (*oauthTokens, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading gcs credentials: %w", err)
+	}
+	var account struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("parsing gcs credentials: %w", err)
+	}
+	if account.Type != "service_account" {
+		return nil, fmt.Errorf("gcs credentials %s: type %q is not supported (want service_account)", file, account.Type)
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("gcs credentials %s: no PEM private key", file)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("gcs credentials %s: %w", file, err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("gcs credentials %s: private key is not RSA", file)
+	}
+	tokenURI := account.TokenURI
+	if tokenURI == "" {
+		tokenURI = gcsTokenURI
+	}
+
+	return &oauthTokens{
+		request: func(ctx context.Context, now time.Time) (*http.Request, error) {
+			assertion, err := signJWT(key, map[string]any{
+				"iss":   account.ClientEmail,
+				"scope": gcsScope,
+				"aud":   tokenURI,
+				"iat":   now.Unix(),
+				"exp":   now.Add(time.Hour).Unix(),
+			})
+			if err != nil {
+				return nil, err
+			}
+			form := url.Values{
+				"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+				"assertion":  {assertion},
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req, nil
+		},
+		http: client,
+		now:  time.Now,
+	}, nil
+}
+
+// signJWT returns claims as a JWT signed with RS256.
+func signJWT(key *rsa.PrivateKey, claims any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) +
+		"." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,82 @@
+// Package storage provides coordination between instances sharing a
+// backend. Replicas starting together against one database would each find
+// no server salt and store their own, and replicas counting one client's
+// requests would overwrite each other's rate limit buckets. Backends that
+// can offer locks held across every instance implement Locker, and ones
+// that can update a value conditionally implement ValueSwapper; Lock and
+// Swapper find them through wrappers. S3 offers neither, so instances
+// sharing a bucket only coordinate within each process.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// lockTimeout bounds the wait for a lock taken at startup.
+const lockTimeout = 30 * time.Second
+
+// Locker is implemented by backends that can hold named locks shared by
+// every instance using them, for one-time work such as generating the
+// server salt. Locks are advisory: they only exclude other Lock callers.
+type Locker interface {
+	// Lock blocks until it holds the named lock, or ctx is done, and
+	// returns a function releasing it.
+	Lock(ctx context.Context, name string) (unlock func(), err error)
+}
+
+// ValueSwapper is implemented by backends that can replace a key-value
+// entry only if it still holds what the caller last read, so concurrent
+// read-modify-write cycles of replicas don't overwrite each other.
+type ValueSwapper interface {
+	// CompareAndSwapValue sets namespace/key to new if it holds old ("" for
+	// none), and reports whether it did.
+	CompareAndSwapValue(ctx context.Context, namespace, key, old, new string) (bool, error)
+}
+
+// Lock takes the named lock of s, looking through wrappers. Backends that
+// aren't Lockers are locked within the process only.
+func Lock(ctx context.Context, s Storage, name string) (func(), error) {
+	if l, ok := find[Locker](s); ok {
+		return l.Lock(ctx, name)
+	}
+	return processLocks.lock(ctx, name)
+}
+
+// Swapper returns s as a ValueSwapper, looking through wrappers, or false
+// if it can't update values conditionally.
+func Swapper(s Storage) (ValueSwapper, bool) {
+	return find[ValueSwapper](s)
+}
+
+// localLocks are named locks within the process.
+type localLocks struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{} // Holding a lock = a send that succeeded
+}
+
+// processLocks serves Lock for backends without locks of their own.
+var processLocks = &localLocks{}
+
+// lock takes the named lock, waiting until ctx is done.
+func (l *localLocks) lock(ctx context.Context, name string) (func(), error) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]chan struct{}{}
+	}
+	ch, ok := l.locks[name]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		l.locks[name] = ch
+	}
+	l.mu.Unlock()
+
+	select {
+	case ch <- struct{}{}:
+		return func() { <-ch }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for lock %q: %w", name, ctx.Err())
+	}
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkLock tests that a and b, which share their data, exclude each
+// other with Lock, while different names don't.
+func checkLock(t *testing.T, a, b Storage) {
+	t.Helper()
+	unlock, err := Lock(context.Background(), a, "salt")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = Lock(ctx, b, "salt")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	other, err := Lock(context.Background(), b, "other")
+	require.NoError(t, err)
+	other()
+
+	unlock()
+	unlock, err = Lock(context.Background(), b, "salt")
+	require.NoError(t, err)
+	unlock()
+}
+
+// checkSwap tests CompareAndSwapValue, including from no value.
+func checkSwap(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	swapper, ok := Swapper(s)
+	require.True(t, ok)
+
+	swapped, err := swapper.CompareAndSwapValue(ctx, NamespaceTraffic, "key", "", "1")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+	swapped, err = swapper.CompareAndSwapValue(ctx, NamespaceTraffic, "key", "", "2")
+	require.NoError(t, err)
+	assert.False(t, swapped, "a value is stored")
+	swapped, err = swapper.CompareAndSwapValue(ctx, NamespaceTraffic, "key", "1", "2")
+	require.NoError(t, err)
+	assert.True(t, swapped)
+	swapped, err = swapper.CompareAndSwapValue(ctx, NamespaceTraffic, "key", "1", "3")
+	require.NoError(t, err)
+	assert.False(t, swapped, "the value changed")
+
+	value, err := s.GetValue(ctx, NamespaceTraffic, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "2", value)
+}
+
+// checkServerSalt tests that instances starting at once agree on a salt.
+func checkServerSalt(t *testing.T, instances ...Storage) {
+	t.Helper()
+	ctx := context.Background()
+	salts := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range salts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			salt, err := ServerSalt(ctx, instances[i%len(instances)])
+			assert.NoError(t, err)
+			salts[i] = salt
+		}(i)
+	}
+	wg.Wait()
+	for _, salt := range salts {
+		assert.Equal(t, salts[0], salt)
+	}
+	assert.NotEmpty(t, salts[0])
+}
+
+func TestMock_Lock(t *testing.T) {
+	m := NewMock()
+	checkLock(t, m, WithMetrics(m, "mock"))
+	checkSwap(t, WithMetrics(NewMock(), "mock"))
+	checkServerSalt(t, NewMock())
+}
+
+func TestFilesystem_Lock(t *testing.T) {
+	cfg := testFilesystemConfig(t)
+	a, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+	b, err := NewFilesystem(cfg)
+	require.NoError(t, err)
+
+	checkLock(t, a, b)
+	checkSwap(t, a)
+	checkServerSalt(t, a, b)
+}
+
+func TestDatabase_Lock(t *testing.T) {
+	cfg := testDatabaseConfig(t)
+	a, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := NewDatabase(cfg)
+	require.NoError(t, err)
+	defer b.Close()
+
+	checkLock(t, a, b)
+	checkSwap(t, a)
+	checkServerSalt(t, a, b)
+}
+
+func TestShadow_Swap(t *testing.T) {
+	ctx := context.Background()
+	s, _, shadow := newShadowed(t)
+	checkSwap(t, s)
+	flush(t, s)
+	value, _ := shadow.GetValue(ctx, NamespaceTraffic, "key")
+	assert.Equal(t, "2", value)
+}
+
+func TestLock_WithoutLocker(t *testing.T) {
+	s := inlineOnly{NewMock()} // Hides the Mock's Locker
+	checkLock(t, s, s)
+	_, ok := Swapper(s)
+	assert.False(t, ok)
+}
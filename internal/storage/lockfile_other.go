@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "context"
+
+// lockFile locks path within the process only: there is no flock here.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	return processLocks.lock(ctx, path)
+}
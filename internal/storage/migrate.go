@@ -0,0 +1,158 @@
+// Package storage provides migration between storage backends.
+// Migrate copies pastes, comments, read receipts, and key-value entries from
+// any backend implementing Exporter to any other backend, through the
+// regular Storage methods. Pastes already present at the destination are
+// left alone, so an interrupted migration can simply be run again.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// MigrateStats counts what Migrate copied.
+type MigrateStats struct {
+	Pastes   int // Pastes copied
+	Existing int // Pastes already at the destination
+	Skipped  int // Pastes that expired or were deleted during the run
+	Comments int // Comments copied
+	Values   int // Key-value entries copied
+}
+
+// Migrate copies everything stored in src to dst. src must implement
+// Exporter. Expired pastes are not copied; reading them removes them from
+// src as it would when serving. The server salt is copied with the other
+// values, so delete tokens and admin tokens stay valid.
+//
+// Pastes and comments are read through src and written through dst, so
+// with encryption at rest they are opened and sealed again with dst's
+// current key.
+//
+// Attachments stored apart from their paste are streamed across to dst,
+// or moved inline if dst keeps attachments inline.
+//
+// Read receipts only record that a paste was read: backends set the time
+// of the first read themselves, so at dst it becomes the migration time.
+//
+// dst should not enforce a comment limit, or pastes that were created
+// under a higher limit lose the comments past it.
+func Migrate(ctx context.Context, dst, src Storage) (MigrateStats, error) {
+	var stats MigrateStats
+
+	exporter, ok := src.(Exporter)
+	if !ok {
+		return stats, errors.New("source storage can't enumerate its contents")
+	}
+
+	ids, err := exporter.PasteIDs(ctx)
+	if err != nil {
+		return stats, err
+	}
+	for _, id := range ids {
+		if err := migratePaste(ctx, dst, src, id, &stats); err != nil {
+			return stats, fmt.Errorf("paste %s: %w", id, err)
+		}
+	}
+
+	err = exporter.Values(ctx, func(namespace, key, value string) error {
+		if err := dst.SetValue(ctx, namespace, key, value); err != nil {
+			return fmt.Errorf("value %s/%s: %w", namespace, key, err)
+		}
+		stats.Values++
+		return nil
+	})
+	return stats, err
+}
+
+// migratePaste copies one paste with its comments and read receipt.
+// Comments are copied even if the paste already exists, since a previous
+// run may have stopped partway through them.
+func migratePaste(ctx context.Context, dst, src Storage, id string, stats *MigrateStats) error {
+	paste, err := src.ReadPaste(ctx, id)
+	if errors.Is(err, model.ErrPasteNotFound) || errors.Is(err, model.ErrPasteExpired) {
+		stats.Skipped++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(paste.Meta.AttachmentSizes) > 0 && !dst.PasteExists(ctx, id) {
+		if err := migrateAttachments(ctx, dst, src, id, paste); err != nil {
+			return fmt.Errorf("attachments: %w", err)
+		}
+	}
+
+	switch err := dst.CreatePaste(ctx, id, paste); {
+	case errors.Is(err, model.ErrPasteExists):
+		stats.Existing++
+	case err != nil:
+		return err
+	default:
+		stats.Pastes++
+	}
+
+	// Comments come oldest first, so parents precede their replies
+	err = src.IterateComments(ctx, id, func(c *model.Comment) error {
+		err := dst.CreateComment(ctx, id, c.ParentID, c.ID, c)
+		if errors.Is(err, model.ErrCommentExists) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("comment %s: %w", c.ID, err)
+		}
+		stats.Comments++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	receipt, err := src.GetReadReceipt(ctx, id)
+	if err != nil {
+		return err
+	}
+	if receipt.IsRead() {
+		if _, err := dst.MarkRead(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateAttachments copies a paste's separately stored attachments to
+// dst, before the paste itself so the paste never exists without them.
+func migrateAttachments(ctx context.Context, dst, src Storage, id string, paste *model.Paste) error {
+	store, separate := Attachments(dst)
+	sizes := make([]int64, len(paste.Meta.AttachmentSizes))
+	var inline model.StringList
+	for i := range sizes {
+		r, err := OpenAttachment(ctx, src, id, paste, i)
+		if err != nil {
+			return err
+		}
+		if separate {
+			sizes[i], err = store.WriteAttachment(ctx, id, i, r)
+		} else {
+			var data []byte
+			data, err = io.ReadAll(r)
+			inline = append(inline, string(data))
+		}
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if separate {
+		paste.Meta.AttachmentSizes = sizes
+	} else {
+		paste.Attachments = inline
+		paste.Meta.AttachmentSizes = nil
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Package storage provides tests for migration between backends.
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+func TestMigrate_FilesystemToDatabase(t *testing.T) {
+	ctx := context.Background()
+	src, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	dst, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	// One paste gets packed, one stays loose, one has expired
+	packed, loose, expired := "f468483c313401e8", "0b12345678901234", "aa00000000000001"
+	salted := compactTestPaste("packed", time.Hour)
+	salted.Meta.Salt = "pastesalt"
+	require.NoError(t, src.CreatePaste(ctx, packed, salted))
+	_, err = src.MarkRead(ctx, packed)
+	require.NoError(t, err)
+	_, err = src.Compact(context.Background(), 0)
+	require.NoError(t, err)
+	require.NoError(t, src.CreatePaste(ctx, loose, compactTestPaste("loose", 0)))
+	require.NoError(t, src.CreatePaste(ctx, expired, compactTestPaste("expired", -time.Hour)))
+
+	require.NoError(t, src.CreateComment(ctx, loose, loose, "c000000000000001", &model.Comment{Data: "top", Meta: model.CommentMeta{PostDate: 1}}))
+	require.NoError(t, src.CreateComment(ctx, loose, "c000000000000001", "c000000000000002", &model.Comment{Data: "reply", Meta: model.CommentMeta{PostDate: 2}}))
+	require.NoError(t, src.SetValue(ctx, NamespaceSalt, "server", "serversalt"))
+	require.NoError(t, src.SetValue(ctx, NamespaceCallback, loose, "https://example.com/hook"))
+
+	ids, err := src.PasteIDs(ctx)
+	require.NoError(t, err)
+	sort.Strings(ids)
+	assert.Equal(t, []string{loose, expired, packed}, ids)
+
+	stats, err := Migrate(ctx, dst, src)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateStats{Pastes: 2, Skipped: 1, Comments: 2, Values: 2}, stats)
+
+	paste, err := dst.ReadPaste(ctx, packed)
+	require.NoError(t, err)
+	assert.Equal(t, "packed", paste.Data)
+	assert.Equal(t, "pastesalt", paste.Meta.Salt)
+	receipt, err := dst.GetReadReceipt(ctx, packed)
+	require.NoError(t, err)
+	assert.True(t, receipt.IsRead())
+
+	comments, err := dst.ReadComments(ctx, loose)
+	require.NoError(t, err)
+	require.Len(t, comments, 2)
+	assert.Equal(t, "c000000000000001", comments[1].ParentID)
+
+	salt, err := dst.GetValue(ctx, NamespaceSalt, "server")
+	require.NoError(t, err)
+	assert.Equal(t, "serversalt", salt)
+	hook, err := dst.GetValue(ctx, NamespaceCallback, loose)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/hook", hook)
+
+	// Running again copies nothing twice
+	stats, err = Migrate(ctx, dst, src)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateStats{Existing: 2, Values: 2}, stats)
+	count, err := dst.CountComments(ctx, loose)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestMigrate_DatabaseExports(t *testing.T) {
+	ctx := context.Background()
+	src, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer src.Close()
+	dst := NewMock()
+
+	require.NoError(t, src.CreatePaste(ctx, "f468483c313401e8", compactTestPaste("content", time.Hour)))
+	require.NoError(t, src.SetValue(ctx, NamespaceTraffic, "hash_with_underscore", "123"))
+
+	stats, err := Migrate(ctx, dst, src)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateStats{Pastes: 1, Values: 1}, stats)
+	assert.True(t, dst.PasteExists(ctx, "f468483c313401e8"))
+	value, err := dst.GetValue(ctx, NamespaceTraffic, "hash_with_underscore")
+	require.NoError(t, err)
+	assert.Equal(t, "123", value)
+}
+
+func TestMigrate_SourceMustExport(t *testing.T) {
+	ctx := context.Background()
+	_, err := Migrate(ctx, NewMock(), WithMetrics(NewMock(), "mock"))
+	assert.Error(t, err)
+}
+
+func TestMigrate_Attachments(t *testing.T) {
+	ctx := context.Background()
+	src, err := NewFilesystem(testFilesystemConfig(t))
+	require.NoError(t, err)
+	dst, err := NewDatabase(testDatabaseConfig(t))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	pasteID := "f468483c313401e8"
+	paste := compactTestPaste("content", time.Hour)
+	for i, attachment := range []string{"attachment", "second"} {
+		n, err := src.WriteAttachment(ctx, pasteID, i, strings.NewReader(attachment))
+		require.NoError(t, err)
+		paste.Meta.AttachmentSizes = append(paste.Meta.AttachmentSizes, n)
+	}
+	require.NoError(t, src.CreatePaste(ctx, pasteID, paste))
+
+	stats, err := Migrate(ctx, dst, src)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.Pastes)
+
+	migrated, err := dst.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{10, 6}, migrated.Meta.AttachmentSizes)
+	assert.Equal(t, "attachment", readAttachment(t, dst, pasteID, migrated, 0))
+	assert.Equal(t, "second", readAttachment(t, dst, pasteID, migrated, 1))
+}
@@ -4,9 +4,14 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
 	"sync"
-	"time"
 
+	"github.com/liskl/flashpaper/internal/clock"
 	"github.com/liskl/flashpaper/internal/model"
 )
 
@@ -16,8 +21,18 @@ type Mock struct {
 	mu       sync.RWMutex
 	pastes   map[string]*model.Paste
 	comments map[string][]*model.Comment
+	receipts map[string]int64
 	values   map[string]string
 
+	attachments map[string][][]byte
+
+	locks localLocks // Named locks (see Lock)
+
+	clock clock.Clock // Expiration and receipt times (see SetClock)
+
+	// CommentLimit is the max comments per paste (0 = unlimited)
+	CommentLimit int
+
 	// Error injection for testing error handling
 	CreatePasteErr   error
 	ReadPasteErr     error
@@ -26,6 +41,7 @@ type Mock struct {
 	ReadCommentsErr  error
 	SetValueErr      error
 	GetValueErr      error
+	PingErr          error
 }
 
 // NewMock creates a new mock storage instance.
@@ -33,12 +49,23 @@ func NewMock() *Mock {
 	return &Mock{
 		pastes:   make(map[string]*model.Paste),
 		comments: make(map[string][]*model.Comment),
+		receipts: make(map[string]int64),
 		values:   make(map[string]string),
+
+		attachments: make(map[string][][]byte),
+		clock:       clock.System,
 	}
 }
 
+// SetClock sets the clock expiration is judged by.
+func (m *Mock) SetClock(c clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = c
+}
+
 // CreatePaste stores a paste in memory.
-func (m *Mock) CreatePaste(id string, paste *model.Paste) error {
+func (m *Mock) CreatePaste(ctx context.Context, id string, paste *model.Paste) error {
 	if m.CreatePasteErr != nil {
 		return m.CreatePasteErr
 	}
@@ -58,7 +85,7 @@ func (m *Mock) CreatePaste(id string, paste *model.Paste) error {
 }
 
 // ReadPaste retrieves a paste from memory.
-func (m *Mock) ReadPaste(id string) (*model.Paste, error) {
+func (m *Mock) ReadPaste(ctx context.Context, id string) (*model.Paste, error) {
 	if m.ReadPasteErr != nil {
 		return nil, m.ReadPasteErr
 	}
@@ -71,7 +98,7 @@ func (m *Mock) ReadPaste(id string) (*model.Paste, error) {
 		return nil, model.ErrPasteNotFound
 	}
 
-	if paste.IsExpired() {
+	if paste.IsExpiredAt(m.clock.Now()) {
 		delete(m.pastes, id)
 		return nil, model.ErrPasteExpired
 	}
@@ -82,7 +109,7 @@ func (m *Mock) ReadPaste(id string) (*model.Paste, error) {
 }
 
 // DeletePaste removes a paste from memory.
-func (m *Mock) DeletePaste(id string) error {
+func (m *Mock) DeletePaste(ctx context.Context, id string) error {
 	if m.DeletePasteErr != nil {
 		return m.DeletePasteErr
 	}
@@ -96,19 +123,119 @@ func (m *Mock) DeletePaste(id string) error {
 
 	delete(m.pastes, id)
 	delete(m.comments, id)
+	delete(m.receipts, id)
+	delete(m.attachments, id)
 	return nil
 }
 
+// ReadAndDeletePaste removes a paste from memory and returns it, leaving
+// its attachments.
+func (m *Mock) ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error) {
+	if m.ReadPasteErr != nil {
+		return nil, m.ReadPasteErr
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paste, exists := m.pastes[id]
+	if !exists {
+		return nil, model.ErrPasteNotFound
+	}
+	delete(m.pastes, id)
+	delete(m.comments, id)
+	delete(m.receipts, id)
+
+	if paste.IsExpiredAt(m.clock.Now()) {
+		delete(m.attachments, id)
+		return nil, model.ErrPasteExpired
+	}
+	return paste, nil
+}
+
+// CountRead uses up one of a paste's reads, removing it with the last as
+// ReadAndDeletePaste does.
+func (m *Mock) CountRead(ctx context.Context, id string) (*model.Paste, error) {
+	return countRead(ctx, m, id, func(update func(meta *pasteMeta)) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		paste, exists := m.pastes[id]
+		if !exists {
+			return model.ErrPasteNotFound
+		}
+		meta := storedMeta(paste.Meta)
+		update(&meta)
+		paste.Meta = meta.meta()
+		return nil
+	})
+}
+
 // PasteExists checks if a paste exists in memory.
-func (m *Mock) PasteExists(id string) bool {
+func (m *Mock) PasteExists(ctx context.Context, id string) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	_, exists := m.pastes[id]
 	return exists
 }
 
+// UpdatePaste replaces a paste's content.
+func (m *Mock) UpdatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, exists := m.pastes[id]
+	if !exists {
+		return model.ErrPasteNotFound
+	}
+	stored.Data = paste.Data
+	stored.AData = paste.AData
+	stored.Version = paste.Version
+	stored.Meta.Formatter = paste.Meta.Formatter
+	return nil
+}
+
+// SetPinned sets a paste's Pinned flag.
+func (m *Mock) SetPinned(ctx context.Context, id string, pinned bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paste, exists := m.pastes[id]
+	if !exists {
+		return model.ErrPasteNotFound
+	}
+	paste.Meta.Pinned = pinned
+	return nil
+}
+
+// SetDisabled sets a paste's Disabled flag.
+func (m *Mock) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paste, exists := m.pastes[id]
+	if !exists {
+		return model.ErrPasteNotFound
+	}
+	paste.Meta.Disabled = disabled
+	return nil
+}
+
+// SetExpireDate sets a paste's expiration date.
+func (m *Mock) SetExpireDate(ctx context.Context, id string, expireDate int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	paste, exists := m.pastes[id]
+	if !exists {
+		return model.ErrPasteNotFound
+	}
+	paste.Meta.ExpireDate = expireDate
+	return nil
+}
+
 // CreateComment stores a comment in memory.
-func (m *Mock) CreateComment(pasteID, parentID, commentID string, comment *model.Comment) error {
+func (m *Mock) CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) error {
 	if m.CreateCommentErr != nil {
 		return m.CreateCommentErr
 	}
@@ -127,6 +254,10 @@ func (m *Mock) CreateComment(pasteID, parentID, commentID string, comment *model
 		}
 	}
 
+	if m.CommentLimit > 0 && len(m.comments[pasteID]) >= m.CommentLimit {
+		return model.ErrCommentLimitReached
+	}
+
 	// Make a copy
 	stored := *comment
 	stored.ID = commentID
@@ -137,7 +268,7 @@ func (m *Mock) CreateComment(pasteID, parentID, commentID string, comment *model
 }
 
 // ReadComments retrieves all comments for a paste.
-func (m *Mock) ReadComments(pasteID string) ([]*model.Comment, error) {
+func (m *Mock) ReadComments(ctx context.Context, pasteID string) ([]*model.Comment, error) {
 	if m.ReadCommentsErr != nil {
 		return nil, m.ReadCommentsErr
 	}
@@ -159,8 +290,30 @@ func (m *Mock) ReadComments(pasteID string) ([]*model.Comment, error) {
 	return result, nil
 }
 
+// CountComments returns the number of comments on a paste.
+func (m *Mock) CountComments(ctx context.Context, pasteID string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.comments[pasteID]), nil
+}
+
+// IterateComments calls fn for each comment on a paste, oldest first.
+func (m *Mock) IterateComments(ctx context.Context, pasteID string, fn func(*model.Comment) error) error {
+	comments, err := m.ReadComments(ctx, pasteID)
+	if err != nil {
+		return err
+	}
+	for _, c := range comments {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // CommentExists checks if a comment exists.
-func (m *Mock) CommentExists(pasteID, parentID, commentID string) bool {
+func (m *Mock) CommentExists(ctx context.Context, pasteID, parentID, commentID string) bool {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -172,8 +325,50 @@ func (m *Mock) CommentExists(pasteID, parentID, commentID string) bool {
 	return false
 }
 
+// DeleteComment removes a comment from memory.
+func (m *Mock) DeleteComment(ctx context.Context, pasteID, commentID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comments := m.comments[pasteID]
+	for i, c := range comments {
+		if c.ID == commentID {
+			m.comments[pasteID] = append(comments[:i:i], comments[i+1:]...)
+			return nil
+		}
+	}
+	return model.ErrCommentNotFound
+}
+
+// MarkRead records the first read of a paste.
+func (m *Mock) MarkRead(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.pastes[id]; !exists {
+		return false, model.ErrPasteNotFound
+	}
+	if _, read := m.receipts[id]; read {
+		return false, nil
+	}
+
+	m.receipts[id] = m.clock.Now().Unix()
+	return true, nil
+}
+
+// GetReadReceipt retrieves the read receipt for a paste.
+func (m *Mock) GetReadReceipt(ctx context.Context, id string) (*model.ReadReceipt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, exists := m.pastes[id]; !exists {
+		return nil, model.ErrPasteNotFound
+	}
+	return &model.ReadReceipt{PasteID: id, FirstRead: m.receipts[id]}, nil
+}
+
 // SetValue stores a key-value pair.
-func (m *Mock) SetValue(namespace, key, value string) error {
+func (m *Mock) SetValue(ctx context.Context, namespace, key, value string) error {
 	if m.SetValueErr != nil {
 		return m.SetValueErr
 	}
@@ -186,7 +381,7 @@ func (m *Mock) SetValue(namespace, key, value string) error {
 }
 
 // GetValue retrieves a stored value.
-func (m *Mock) GetValue(namespace, key string) (string, error) {
+func (m *Mock) GetValue(ctx context.Context, namespace, key string) (string, error) {
 	if m.GetValueErr != nil {
 		return "", m.GetValueErr
 	}
@@ -197,16 +392,37 @@ func (m *Mock) GetValue(namespace, key string) (string, error) {
 	return m.values[namespace+"_"+key], nil
 }
 
+// CompareAndSwapValue sets a value if it still holds old.
+func (m *Mock) CompareAndSwapValue(ctx context.Context, namespace, key, old, new string) (bool, error) {
+	if m.SetValueErr != nil {
+		return false, m.SetValueErr
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.values[namespace+"_"+key] != old {
+		return false, nil
+	}
+	m.values[namespace+"_"+key] = new
+	return true, nil
+}
+
+// Lock takes a named lock of this Mock.
+func (m *Mock) Lock(ctx context.Context, name string) (func(), error) {
+	return m.locks.lock(ctx, name)
+}
+
 // GetExpiredPastes returns expired paste IDs.
-func (m *Mock) GetExpiredPastes(batchSize int) ([]string, error) {
+func (m *Mock) GetExpiredPastes(ctx context.Context, batchSize int) ([]string, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	var expired []string
-	now := time.Now().Unix()
+	now := m.clock.Now().Unix()
 
 	for id, paste := range m.pastes {
-		if paste.Meta.ExpireDate > 0 && paste.Meta.ExpireDate < now {
+		if expiresAt := paste.Meta.ExpiresAt(); expiresAt > 0 && expiresAt < now {
 			expired = append(expired, id)
 			if len(expired) >= batchSize {
 				break
@@ -218,15 +434,15 @@ func (m *Mock) GetExpiredPastes(batchSize int) ([]string, error) {
 }
 
 // Purge deletes expired pastes.
-func (m *Mock) Purge(batchSize int) (int, error) {
-	ids, err := m.GetExpiredPastes(batchSize)
+func (m *Mock) Purge(ctx context.Context, batchSize int) (int, error) {
+	ids, err := m.GetExpiredPastes(ctx, batchSize)
 	if err != nil {
 		return 0, err
 	}
 
 	count := 0
 	for _, id := range ids {
-		if err := m.DeletePaste(id); err == nil {
+		if err := m.DeletePaste(ctx, id); err == nil {
 			count++
 		}
 	}
@@ -234,11 +450,134 @@ func (m *Mock) Purge(batchSize int) (int, error) {
 	return count, nil
 }
 
-// PurgeValues removes old entries (no-op for mock).
-func (m *Mock) PurgeValues(namespace string, maxAge int64) error {
+// PurgeValues removes entries in namespace holding a timestamp more than
+// maxAge seconds ago.
+func (m *Mock) PurgeValues(ctx context.Context, namespace string, maxAge int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cutoff := m.clock.Now().Unix() - maxAge
+	for k, v := range m.values {
+		if !strings.HasPrefix(k, namespace+"_") {
+			continue
+		}
+		if timestamp, err := strconv.ParseInt(v, 10, 64); err == nil && valueSeconds(timestamp) < cutoff {
+			delete(m.values, k)
+		}
+	}
 	return nil
 }
 
+// IteratePastes calls fn for every paste that hasn't expired.
+func (m *Mock) IteratePastes(ctx context.Context, fn func(*model.Paste) error) error {
+	m.mu.RLock()
+	pastes := make([]*model.Paste, 0, len(m.pastes))
+	for _, p := range m.pastes {
+		if !p.IsExpiredAt(m.clock.Now()) {
+			copied := *p
+			pastes = append(pastes, &copied)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, p := range pastes {
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PasteStats tallies the pastes that haven't expired.
+func (m *Mock) PasteStats(ctx context.Context, since int64) (*PasteStats, error) {
+	return tallyPastes(ctx, m, since)
+}
+
+// PasteIDs returns the IDs of all stored pastes.
+func (m *Mock) PasteIDs(ctx context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.pastes))
+	for id := range m.pastes {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Values calls fn for every stored value.
+func (m *Mock) Values(ctx context.Context, fn func(namespace, key, value string) error) error {
+	m.mu.RLock()
+	values := make(map[string]string, len(m.values))
+	for k, v := range m.values {
+		values[k] = v
+	}
+	m.mu.RUnlock()
+
+	for k, v := range values {
+		namespace, key, _ := strings.Cut(k, "_")
+		if err := fn(namespace, key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAttachment stores an attachment in memory.
+func (m *Mock) WriteAttachment(ctx context.Context, id string, index int, r io.Reader) (int64, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return int64(len(data)), err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	attachments := m.attachments[id]
+	for len(attachments) <= index {
+		attachments = append(attachments, nil)
+	}
+	attachments[index] = data
+	m.attachments[id] = attachments
+	return int64(len(data)), nil
+}
+
+// OpenAttachment returns a reader over an attachment in memory.
+func (m *Mock) OpenAttachment(ctx context.Context, id string, index int) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	attachments := m.attachments[id]
+	if index >= len(attachments) || attachments[index] == nil {
+		return nil, model.ErrPasteNotFound
+	}
+	return io.NopCloser(bytes.NewReader(attachments[index])), nil
+}
+
+// DeleteAttachments removes a paste's attachments from memory.
+func (m *Mock) DeleteAttachments(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attachments, id)
+	return nil
+}
+
+// GetAttachment returns a stored attachment, or nil if there is none.
+func (m *Mock) GetAttachment(id string, index int) []byte {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if attachments := m.attachments[id]; index < len(attachments) {
+		return attachments[index]
+	}
+	return nil
+}
+
+// Ping returns PingErr.
+func (m *Mock) Ping(ctx context.Context) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.PingErr
+}
+
 // Close is a no-op for mock storage.
 func (m *Mock) Close() error {
 	return nil
@@ -252,7 +591,9 @@ func (m *Mock) Reset() {
 
 	m.pastes = make(map[string]*model.Paste)
 	m.comments = make(map[string][]*model.Comment)
+	m.receipts = make(map[string]int64)
 	m.values = make(map[string]string)
+	m.attachments = make(map[string][][]byte)
 	m.CreatePasteErr = nil
 	m.ReadPasteErr = nil
 	m.DeletePasteErr = nil
@@ -260,6 +601,7 @@ func (m *Mock) Reset() {
 	m.ReadCommentsErr = nil
 	m.SetValueErr = nil
 	m.GetValueErr = nil
+	m.PingErr = nil
 }
 
 // GetPasteCount returns the number of pastes stored.
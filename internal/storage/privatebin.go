@@ -0,0 +1,365 @@
+// Package storage provides import from PrivateBin. PrivateBin keeps each
+// paste as the JSON document its client sent: in a database, in tables
+// named with an optional prefix (prefix_paste, prefix_comment,
+// prefix_config), or in a data directory of PHP files guarded against
+// direct access. ImportPrivateBin and ImportPrivateBinDir read them and
+// store the pastes and comments through any FlashPaper backend. Paste links and delete tokens keep working:
+// PrivateBin keys delete tokens with the salt string itself, where
+// FlashPaper decodes it from base64, so salts are re-encoded on the way.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// ImportStats counts what ImportPrivateBin copied.
+type ImportStats struct {
+	Pastes   int // Pastes copied
+	Existing int // Pastes already at the destination
+	Expired  int // Pastes past their expiration
+	Legacy   int // Pastes in PrivateBin's format 1, which can't be served
+	Comments int // Comments copied
+}
+
+// privateBinPrefix matches the table prefixes ImportPrivateBin accepts.
+var privateBinPrefix = regexp.MustCompile(`^[A-Za-z0-9_]*$`)
+
+// privateBinBatch is the number of pastes read per query.
+const privateBinBatch = 100
+
+// privateBinDocument is a paste or comment as PrivateBin stores it in the
+// data column or file (format 2), and the meta column of pastes.
+type privateBinDocument struct {
+	Version int             `json:"v"`
+	CT      string          `json:"ct"`
+	AData   json.RawMessage `json:"adata"`
+	Meta    privateBinMeta  `json:"meta"`
+}
+
+// privateBinMeta holds the metadata PrivateBin keeps with a paste or
+// comment.
+type privateBinMeta struct {
+	Created    int64  `json:"created"`
+	ExpireDate int64  `json:"expire_date"` // Its own column in a database
+	Salt       string `json:"salt"`        // Keys the delete token; server salt if empty
+	Icon       string `json:"icon"`        // Comments: the commenter's identicon
+}
+
+// ImportPrivateBin copies the pastes and comments of the PrivateBin
+// database at dsn, whose tables are named with prefix, into dst. Like
+// Migrate it leaves pastes already at dst alone, so an interrupted import
+// can be run again. Expired pastes are skipped, as are pastes from
+// PrivateBin releases before 1.3 that still use format 1.
+//
+// PrivateBin has no read receipts, and its server salt stays its own:
+// the pastes that were keyed with it get it as their own salt instead.
+func ImportPrivateBin(ctx context.Context, dst Storage, driver, dsn, prefix string) (ImportStats, error) {
+	var stats ImportStats
+	if !privateBinPrefix.MatchString(prefix) {
+		return stats, fmt.Errorf("invalid table prefix %q", prefix)
+	}
+	db, err := openDB(driver, dsn, poolSettingsFor(config.ModelConfig{Driver: driver}))
+	if err != nil {
+		return stats, err
+	}
+	defer db.Close()
+	src := &Database{driver: driver}
+	src.pool.Store(&dbPool{db: db})
+
+	// The fallback for pastes created before PrivateBin gave each its own
+	serverSalt := ""
+	err = db.QueryRow(fmt.Sprintf("SELECT value FROM %sconfig WHERE id = %s", prefix, src.placeholder(1)), "SALT").Scan(&serverSalt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return stats, fmt.Errorf("reading server salt: %w", err)
+	}
+
+	query := fmt.Sprintf(
+		"SELECT dataid, data, expiredate, meta FROM %spaste WHERE dataid > %s ORDER BY dataid ASC LIMIT %d",
+		prefix, src.placeholder(1), privateBinBatch)
+	after := ""
+	for {
+		rows, err := db.Query(query, after)
+		if err != nil {
+			return stats, fmt.Errorf("reading pastes: %w", err)
+		}
+		var pastes []*model.Paste
+		read := 0
+		for rows.Next() {
+			read++
+			var id string
+			var data, meta []byte
+			var expireDate sql.NullInt64
+			if err := rows.Scan(&id, &data, &expireDate, &meta); err != nil {
+				rows.Close()
+				return stats, fmt.Errorf("reading pastes: %w", err)
+			}
+			after = id
+			paste, err := decodePrivateBinPaste(data, meta, serverSalt)
+			if err != nil {
+				rows.Close()
+				return stats, fmt.Errorf("paste %s: %w", id, err)
+			}
+			if paste == nil {
+				stats.Legacy++
+				continue
+			}
+			paste.ID = id
+			paste.Meta.ExpireDate = expireDate.Int64
+			pastes = append(pastes, paste)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return stats, fmt.Errorf("reading pastes: %w", err)
+		}
+
+		for _, paste := range pastes {
+			if paste.IsExpiredAt(time.Now()) {
+				stats.Expired++
+				continue
+			}
+			comments, err := privateBinComments(ctx, src, prefix, paste.ID)
+			if err == nil {
+				err = storePrivateBinPaste(ctx, dst, paste, comments, &stats)
+			}
+			if err != nil {
+				return stats, fmt.Errorf("paste %s: %w", paste.ID, err)
+			}
+		}
+		if read < privateBinBatch {
+			return stats, nil
+		}
+	}
+}
+
+// decodePrivateBinPaste builds a paste from a PrivateBin paste document
+// and, from a database, its meta column, or returns nil for one in format 1.
+func decodePrivateBinPaste(data, meta []byte, serverSalt string) (*model.Paste, error) {
+	var doc privateBinDocument
+	if err := json.Unmarshal(data, &doc); err != nil || doc.Version != 2 {
+		return nil, nil
+	}
+	// The meta column is what PrivateBin itself reads
+	if len(meta) > 0 {
+		if err := json.Unmarshal(meta, &doc.Meta); err != nil {
+			return nil, fmt.Errorf("decoding meta: %w", err)
+		}
+	}
+
+	paste := &model.Paste{
+		Data:    doc.CT,
+		AData:   doc.AData,
+		Version: 2,
+		Meta: model.PasteMeta{
+			PostDate:   doc.Meta.Created,
+			ExpireDate: doc.Meta.ExpireDate,
+		},
+	}
+	if err := paste.ParseAData(); err != nil {
+		return nil, fmt.Errorf("decoding adata: %w", err)
+	}
+	salt := doc.Meta.Salt
+	if salt == "" {
+		salt = serverSalt
+	}
+	if salt != "" {
+		paste.Meta.Salt = base64.StdEncoding.EncodeToString([]byte(salt))
+	}
+	return paste, nil
+}
+
+// decodePrivateBinComment builds a comment from a PrivateBin comment
+// document, or returns nil for one in format 1. A database keeps the icon
+// and creation time in columns of their own, which take precedence.
+func decodePrivateBinComment(pasteID, parentID, id string, data []byte, icon string, created int64) *model.Comment {
+	var doc privateBinDocument
+	if err := json.Unmarshal(data, &doc); err != nil || doc.Version != 2 {
+		return nil
+	}
+	if icon == "" {
+		icon = doc.Meta.Icon
+	}
+	if created == 0 {
+		created = doc.Meta.Created
+	}
+	if parentID == "" {
+		parentID = pasteID
+	}
+	return &model.Comment{
+		ID:       id,
+		PasteID:  pasteID,
+		ParentID: parentID,
+		Data:     doc.CT,
+		AData:    doc.AData,
+		Version:  2,
+		Vizhash:  icon,
+		Meta:     model.CommentMeta{PostDate: created},
+	}
+}
+
+// privateBinComments reads the comments of a paste from a PrivateBin
+// database, oldest first so parents precede their replies.
+func privateBinComments(ctx context.Context, src *Database, prefix, pasteID string) ([]*model.Comment, error) {
+	rows, err := src.db().Query(fmt.Sprintf(
+		"SELECT dataid, parentid, data, vizhash, postdate FROM %scomment WHERE pasteid = %s ORDER BY postdate ASC, dataid ASC",
+		prefix, src.placeholder(1)), pasteID)
+	if err != nil {
+		return nil, fmt.Errorf("reading comments: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []*model.Comment
+	for rows.Next() {
+		var id string
+		var parentID, vizhash sql.NullString
+		var data []byte
+		var postDate sql.NullInt64
+		if err := rows.Scan(&id, &parentID, &data, &vizhash, &postDate); err != nil {
+			return nil, fmt.Errorf("reading comments: %w", err)
+		}
+		if c := decodePrivateBinComment(pasteID, parentID.String, id, data, vizhash.String, postDate.Int64); c != nil {
+			comments = append(comments, c)
+		}
+	}
+	return comments, rows.Err()
+}
+
+// storePrivateBinPaste stores one paste with its comments. Comments are
+// stored even if the paste already exists, since a previous run may have
+// stopped partway through them.
+func storePrivateBinPaste(ctx context.Context, dst Storage, paste *model.Paste, comments []*model.Comment, stats *ImportStats) error {
+	switch err := dst.CreatePaste(ctx, paste.ID, paste); {
+	case errors.Is(err, model.ErrPasteExists):
+		stats.Existing++
+	case err != nil:
+		return err
+	default:
+		stats.Pastes++
+	}
+
+	for _, c := range comments {
+		err := dst.CreateComment(ctx, paste.ID, c.ParentID, c.ID, c)
+		if errors.Is(err, model.ErrCommentExists) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("comment %s: %w", c.ID, err)
+		}
+		stats.Comments++
+	}
+	return nil
+}
+
+// privateBinProtection is the first line of every PrivateBin data file,
+// which keeps web servers running PHP from serving it.
+const privateBinProtection = "<?php http_response_code(403); /*"
+
+// ImportPrivateBinDir copies the pastes and comments of a PrivateBin data
+// directory into dst, like ImportPrivateBin. The traffic and purge limiter
+// files hold state FlashPaper keeps for itself and aren't imported.
+func ImportPrivateBinDir(ctx context.Context, dst Storage, dir string) (ImportStats, error) {
+	var stats ImportStats
+
+	// salt.php holds "<?php # |salt|"
+	serverSalt := ""
+	if data, err := os.ReadFile(filepath.Join(dir, "salt.php")); err == nil {
+		if items := strings.Split(string(data), "|"); len(items) == 3 {
+			serverSalt = items[1]
+		}
+	}
+
+	// Pastes are ab/cd/abcd....php, their comments in ab/cd/abcd....discussion
+	files, err := filepath.Glob(filepath.Join(dir, "[0-9a-f][0-9a-f]", "[0-9a-f][0-9a-f]", "*.php"))
+	if err != nil {
+		return stats, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return stats, err
+		}
+	}
+	for _, path := range files {
+		id := strings.TrimSuffix(filepath.Base(path), ".php")
+		if !util.ValidateID(id) {
+			continue
+		}
+		data, err := readPrivateBinFile(path)
+		if err != nil {
+			return stats, fmt.Errorf("paste %s: %w", id, err)
+		}
+		paste, err := decodePrivateBinPaste(data, nil, serverSalt)
+		if err != nil {
+			return stats, fmt.Errorf("paste %s: %w", id, err)
+		}
+		if paste == nil {
+			stats.Legacy++
+			continue
+		}
+		paste.ID = id
+		if paste.IsExpiredAt(time.Now()) {
+			stats.Expired++
+			continue
+		}
+
+		comments, err := privateBinDirComments(strings.TrimSuffix(path, ".php")+".discussion", id)
+		if err == nil {
+			err = storePrivateBinPaste(ctx, dst, paste, comments, &stats)
+		}
+		if err != nil {
+			return stats, fmt.Errorf("paste %s: %w", id, err)
+		}
+	}
+	return stats, nil
+}
+
+// readPrivateBinFile returns the JSON document of a PrivateBin data file.
+func readPrivateBinFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(bytes.TrimPrefix(data, []byte(privateBinProtection))), nil
+}
+
+// privateBinDirComments reads the comments in a PrivateBin discussion
+// directory, named pasteid.commentid.parentid.php, oldest first.
+func privateBinDirComments(dir, pasteID string) ([]*model.Comment, error) {
+	files, err := filepath.Glob(filepath.Join(dir, pasteID+".*.*.php"))
+	if err != nil {
+		return nil, err
+	}
+	var comments []*model.Comment
+	for _, path := range files {
+		parts := strings.Split(strings.TrimSuffix(filepath.Base(path), ".php"), ".")
+		if len(parts) != 3 || !util.ValidateID(parts[1]) || !util.ValidateID(parts[2]) {
+			continue
+		}
+		data, err := readPrivateBinFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("comment %s: %w", parts[1], err)
+		}
+		if c := decodePrivateBinComment(pasteID, parts[2], parts[1], data, "", 0); c != nil {
+			comments = append(comments, c)
+		}
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Meta.PostDate < comments[j].Meta.PostDate
+	})
+	return comments, nil
+}
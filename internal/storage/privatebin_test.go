@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// newPrivateBinDB creates an SQLite database with PrivateBin's tables
+// under prefix and returns its DSN.
+func newPrivateBinDB(t *testing.T, prefix string, statements ...string) string {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "privatebin.db")
+	db, err := openDB("sqlite3", dsn, poolSettings{maxOpen: 1})
+	require.NoError(t, err)
+	defer db.Close()
+
+	schema := []string{
+		`CREATE TABLE %spaste (dataid CHAR(16) NOT NULL PRIMARY KEY, data BLOB, expiredate INT, meta TEXT)`,
+		`CREATE TABLE %scomment (dataid CHAR(16) NOT NULL PRIMARY KEY, pasteid CHAR(16), parentid CHAR(16), data BLOB, nickname BLOB, vizhash BLOB, postdate INT)`,
+		`CREATE TABLE %sconfig (id CHAR(16) NOT NULL PRIMARY KEY, value TEXT)`,
+	}
+	for _, statement := range schema {
+		_, err := db.Exec(fmt.Sprintf(statement, prefix))
+		require.NoError(t, err)
+	}
+	for _, statement := range statements {
+		_, err := db.Exec(statement)
+		require.NoError(t, err, statement)
+	}
+	return dsn
+}
+
+// privateBinToken is a delete token as PrivateBin computes it.
+func privateBinToken(id, salt string) string {
+	h := hmac.New(sha256.New, []byte(salt))
+	h.Write([]byte(id))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestImportPrivateBin(t *testing.T) {
+	ctx := context.Background()
+	future := time.Now().Add(time.Hour).Unix()
+	adata := `[["iv","salt",100000,256,128,"aes","gcm","zlib"],"markdown",1,0]`
+	dsn := newPrivateBinDB(t, "pb_",
+		`INSERT INTO pb_config VALUES ('SALT', 'serversalt')`,
+		fmt.Sprintf(`INSERT INTO pb_paste VALUES ('abcdef1234567890', '{"v":2,"ct":"ciphertext","adata":%s,"meta":{"expire":"1day"}}', %d, '{"created":1700000000,"salt":"pastesalt"}')`, adata, future),
+		`INSERT INTO pb_paste VALUES ('0123456789abcdef', '{"v":2,"ct":"old","adata":[[],"plaintext",0,1],"meta":{}}', 0, '{"created":1600000000}')`,
+		`INSERT INTO pb_paste VALUES ('1111111111111111', '{"v":2,"ct":"expired","adata":[],"meta":{}}', 1000, '{}')`,
+		`INSERT INTO pb_paste VALUES ('2222222222222222', '{"iv":"x","v":1,"ct":"sjcl"}', 0, '{}')`,
+		`INSERT INTO pb_comment VALUES ('aaaaaaaaaaaaaaaa', 'abcdef1234567890', 'abcdef1234567890', '{"v":2,"ct":"first","adata":[]}', NULL, 'data:image/png;base64,icon', 1700000100)`,
+		`INSERT INTO pb_comment VALUES ('bbbbbbbbbbbbbbbb', 'abcdef1234567890', 'aaaaaaaaaaaaaaaa', '{"v":2,"ct":"reply","adata":[]}', NULL, NULL, 1700000200)`,
+	)
+
+	dst := NewMock()
+	stats, err := ImportPrivateBin(ctx, dst, "sqlite3", dsn, "pb_")
+	require.NoError(t, err)
+	assert.Equal(t, ImportStats{Pastes: 2, Expired: 1, Legacy: 1, Comments: 2}, stats)
+
+	paste, err := dst.ReadPaste(ctx, "abcdef1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", paste.Data)
+	assert.JSONEq(t, adata, string(paste.AData))
+	assert.Equal(t, int64(1700000000), paste.Meta.PostDate)
+	assert.Equal(t, future, paste.Meta.ExpireDate)
+	assert.Equal(t, "markdown", paste.Meta.Formatter)
+	assert.True(t, paste.Meta.OpenDiscussion)
+
+	// Delete links from PrivateBin still work
+	token, err := util.GenerateDeleteToken("abcdef1234567890", paste.Meta.Salt)
+	require.NoError(t, err)
+	assert.Equal(t, privateBinToken("abcdef1234567890", "pastesalt"), token)
+	old, err := dst.ReadPaste(ctx, "0123456789abcdef")
+	require.NoError(t, err)
+	assert.True(t, old.Meta.BurnAfterReading)
+	token, err = util.GenerateDeleteToken("0123456789abcdef", old.Meta.Salt)
+	require.NoError(t, err)
+	assert.Equal(t, privateBinToken("0123456789abcdef", "serversalt"), token)
+
+	comments, err := dst.ReadComments(ctx, "abcdef1234567890")
+	require.NoError(t, err)
+	require.Len(t, comments, 2)
+	assert.Equal(t, "first", comments[0].Data)
+	assert.Equal(t, "data:image/png;base64,icon", comments[0].Vizhash)
+	assert.Equal(t, "aaaaaaaaaaaaaaaa", comments[1].ParentID)
+	assert.Equal(t, int64(1700000200), comments[1].Meta.PostDate)
+
+	// Running again copies nothing twice
+	stats, err = ImportPrivateBin(ctx, dst, "sqlite3", dsn, "pb_")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Existing)
+	assert.Zero(t, stats.Pastes)
+	assert.Zero(t, stats.Comments)
+}
+
+func TestImportPrivateBin_Batches(t *testing.T) {
+	ctx := context.Background()
+	var statements []string
+	for i := 0; i < privateBinBatch+5; i++ {
+		statements = append(statements, fmt.Sprintf(`INSERT INTO paste VALUES ('%016x', '{"v":2,"ct":"ct","adata":[],"meta":{}}', 0, '{}')`, i))
+	}
+	dsn := newPrivateBinDB(t, "", statements...)
+
+	dst := NewMock()
+	stats, err := ImportPrivateBin(ctx, dst, "sqlite3", dsn, "")
+	require.NoError(t, err)
+	assert.Equal(t, privateBinBatch+5, stats.Pastes)
+	assert.Equal(t, privateBinBatch+5, dst.GetPasteCount())
+}
+
+func TestImportPrivateBin_InvalidPrefix(t *testing.T) {
+	ctx := context.Background()
+	_, err := ImportPrivateBin(ctx, NewMock(), "sqlite3", ":memory:", "pb; DROP TABLE paste; --")
+	assert.ErrorContains(t, err, "invalid table prefix")
+}
+
+func TestImportPrivateBinDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0700))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+	future := time.Now().Add(time.Hour).Unix()
+	write("salt.php", "<?php # |serversalt|")
+	write("purge_limiter.php", "<?php\n$GLOBALS['purge_limiter'] = 1700000000;")
+	write(".htaccess", "Require all denied")
+	write("ab/cd/abcd123456789012.php", privateBinProtection+"\n"+fmt.Sprintf(
+		`{"v":2,"ct":"ciphertext","adata":[[],"plaintext",1,0],"meta":{"expire_date":%d,"created":1700000000,"salt":"pastesalt"}}`, future))
+	write("ab/cd/abcd123456789012.discussion/abcd123456789012.aaaaaaaaaaaaaaaa.abcd123456789012.php", privateBinProtection+"\n"+
+		`{"v":2,"ct":"first","adata":[],"meta":{"created":1700000100,"icon":"data:image/png;base64,icon"}}`)
+	write("ab/cd/abcd123456789012.discussion/abcd123456789012.bbbbbbbbbbbbbbbb.aaaaaaaaaaaaaaaa.php", privateBinProtection+"\n"+
+		`{"v":2,"ct":"reply","adata":[],"meta":{"created":1700000200}}`)
+	write("01/23/0123456789abcdef.php", privateBinProtection+"\n"+`{"v":2,"ct":"keyed by server salt","adata":[],"meta":{"created":1600000000}}`)
+	write("11/11/1111111111111111.php", privateBinProtection+"\n"+`{"v":2,"ct":"expired","adata":[],"meta":{"expire_date":1000}}`)
+
+	dst := NewMock()
+	stats, err := ImportPrivateBinDir(ctx, dst, dir)
+	require.NoError(t, err)
+	assert.Equal(t, ImportStats{Pastes: 2, Expired: 1, Comments: 2}, stats)
+
+	paste, err := dst.ReadPaste(ctx, "abcd123456789012")
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", paste.Data)
+	assert.Equal(t, future, paste.Meta.ExpireDate)
+	assert.True(t, paste.Meta.OpenDiscussion)
+	token, err := util.GenerateDeleteToken("abcd123456789012", paste.Meta.Salt)
+	require.NoError(t, err)
+	assert.Equal(t, privateBinToken("abcd123456789012", "pastesalt"), token)
+
+	comments, err := dst.ReadComments(ctx, "abcd123456789012")
+	require.NoError(t, err)
+	require.Len(t, comments, 2)
+	assert.Equal(t, "data:image/png;base64,icon", comments[0].Vizhash)
+	assert.Equal(t, "aaaaaaaaaaaaaaaa", comments[1].ParentID)
+
+	old, err := dst.ReadPaste(ctx, "0123456789abcdef")
+	require.NoError(t, err)
+	token, err = util.GenerateDeleteToken("0123456789abcdef", old.Meta.Salt)
+	require.NoError(t, err)
+	assert.Equal(t, privateBinToken("0123456789abcdef", "serversalt"), token)
+
+	_, err = ImportPrivateBinDir(ctx, dst, filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,498 @@
+// Package storage provides a minimal client for the S3 REST API.
+// It covers just the object operations the S3 and Blob backends need and signs
+// requests with AWS Signature Version 4, which AWS S3, MinIO, Backblaze B2,
+// and other S3-compatible services accept. Keeping it this small avoids
+// pulling the AWS SDK into the binary.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// maxErrorBody bounds how much of an error response is kept for messages.
+const maxErrorBody = 1024
+
+// s3Client talks to one bucket.
+type s3Client struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	pathStyle bool
+	creds     credentialSource
+	http      *http.Client
+	now       func() time.Time
+}
+
+// newS3Client creates a client for bucket from the [model] settings.
+// Credentials come from the config, the standard AWS environment variables,
+// or the ECS task role, in that order.
+func newS3Client(bucket string, m config.ModelConfig) (*s3Client, error) {
+	region := m.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := m.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parsing s3 endpoint: %w", err)
+	}
+
+	httpClient := &http.Client{Timeout: blobTimeout}
+	creds, err := resolveCredentials(m.AccessKey, m.SecretKey, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Client{
+		endpoint:  u,
+		bucket:    bucket,
+		region:    region,
+		pathStyle: m.PathStyle,
+		creds:     creds,
+		http:      httpClient,
+		now:       time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of an object ("" for the bucket itself).
+func (c *s3Client) objectURL(key string) *url.URL {
+	u := *c.endpoint
+	switch {
+	case c.pathStyle && key == "":
+		u.Path = "/" + c.bucket
+	case c.pathStyle:
+		u.Path = "/" + c.bucket + "/" + key
+	default:
+		u.Host = c.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	return &u
+}
+
+// do sends a signed request and returns the response if it succeeded.
+// 404 maps to errObjectNotFound; 412, and 409 for a conditional write
+// racing another, to errPreconditionFailed.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := c.objectURL(key)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	creds, err := c.creds.credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("s3 credentials: %w", err)
+	}
+	signV4(req, body, creds, c.region, c.now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return nil, errObjectNotFound
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return nil, errPreconditionFailed
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(msg))
+}
+
+// putObject stores an object. With exclusive set, the write is conditional
+// on the object not existing yet and fails with errPreconditionFailed
+// otherwise.
+func (c *s3Client) putObject(ctx context.Context, key string, body []byte, exclusive bool) error {
+	header := http.Header{}
+	if exclusive {
+		header.Set("If-None-Match", "*")
+	}
+	resp, err := c.do(ctx, http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// getObject reads an object.
+func (c *s3Client) getObject(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// getVersion reads an object and its ETag.
+func (c *s3Client) getVersion(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.Header.Get("ETag"), err
+}
+
+// replaceObject overwrites an object on the condition If-Match: version.
+func (c *s3Client) replaceObject(ctx context.Context, key string, body []byte, version string) error {
+	header := http.Header{}
+	header.Set("If-Match", version)
+	resp, err := c.do(ctx, http.MethodPut, key, nil, header, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// deleteVersion removes an object on the condition If-Match: version.
+func (c *s3Client) deleteVersion(ctx context.Context, key, version string) error {
+	header := http.Header{}
+	header.Set("If-Match", version)
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// openObject opens an object for streaming. The body must be closed.
+func (c *s3Client) openObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// headObject reports whether an object exists.
+func (c *s3Client) headObject(ctx context.Context, key string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, key, nil, nil, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// deleteObject removes an object; deleting a missing object succeeds.
+func (c *s3Client) deleteObject(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult is the part of a ListObjectsV2 response we use.
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// listObjects calls fn for each key under prefix, in lexicographic order,
+// until fn returns false.
+func (c *s3Client) listObjects(ctx context.Context, prefix string, fn func(key string) bool) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+
+	for {
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding s3 listing: %w", err)
+		}
+
+		for _, obj := range result.Contents {
+			if !fn(obj.Key) {
+				return nil
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// headBucket checks that the bucket exists and the credentials can use it.
+func (c *s3Client) headBucket(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodHead, "", nil, nil, nil)
+	if errors.Is(err, errObjectNotFound) {
+		return fmt.Errorf("s3 bucket %q not found", c.bucket)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// close releases idle connections.
+func (c *s3Client) close() {
+	c.http.CloseIdleConnections()
+}
+
+// signV4 signs req for the s3 service with AWS Signature Version 4.
+// Every header already set on req is signed, along with Host and the
+// x-amz-* headers added here.
+func signV4(req *http.Request, body []byte, creds credentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as signed.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, the way
+// SigV4 expects. Slashes are kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// credentials are an AWS access key pair, possibly temporary.
+type credentials struct {
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Expires      time.Time // Zero for long-lived keys
+}
+
+// credentialSource supplies credentials for signing.
+type credentialSource interface {
+	credentials(ctx context.Context) (credentials, error)
+}
+
+// staticCredentials are configured keys that never change.
+type staticCredentials credentials
+
+func (s staticCredentials) credentials(context.Context) (credentials, error) {
+	return credentials(s), nil
+}
+
+// ecsCredentialsHost serves task role credentials on ECS.
+const ecsCredentialsHost = "http://169.254.170.2"
+
+// containerCredentials fetches temporary credentials from the ECS container
+// credentials endpoint and refreshes them shortly before they expire.
+type containerCredentials struct {
+	url       string
+	authToken string
+	http      *http.Client
+	now       func() time.Time
+
+	mu     sync.Mutex
+	cached credentials
+}
+
+func (c *containerCredentials) credentials(ctx context.Context) (credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cached.AccessKey != "" && c.now().Add(5*time.Minute).Before(c.cached.Expires) {
+		return c.cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return credentials{}, err
+	}
+	if c.authToken != "" {
+		req.Header.Set("Authorization", c.authToken)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return credentials{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return credentials{}, fmt.Errorf("container credentials endpoint: %s", resp.Status)
+	}
+
+	var body struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return credentials{}, fmt.Errorf("decoding container credentials: %w", err)
+	}
+	c.cached = credentials{
+		AccessKey:    body.AccessKeyID,
+		SecretKey:    body.SecretAccessKey,
+		SessionToken: body.Token,
+		Expires:      body.Expiration,
+	}
+	return c.cached, nil
+}
+
+// resolveCredentials picks the credential source: configured keys first,
+// then the standard AWS environment variables, then the ECS task role.
+func resolveCredentials(accessKey, secretKey string, client *http.Client) (credentialSource, error) {
+	if accessKey != "" {
+		return staticCredentials{AccessKey: accessKey, SecretKey: secretKey}, nil
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return staticCredentials{
+			AccessKey:    id,
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		endpoint = ecsCredentialsHost + rel
+	}
+	if endpoint == "" {
+		return nil, errors.New("no S3 credentials: set [model] access_key and secret_key, AWS_ACCESS_KEY_ID, or run with an ECS task role")
+	}
+	return &containerCredentials{
+		url:       endpoint,
+		authToken: os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"),
+		http:      client,
+		now:       time.Now,
+	}, nil
+}
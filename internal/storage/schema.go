@@ -0,0 +1,200 @@
+// Package storage provides versioned schema migrations for the database
+// backend. Each driver has its migrations embedded from schema/<driver>,
+// named NNNN_description.sql and numbered from 1 without gaps, and the
+// schema_version table records the ones a database has had applied.
+// NewDatabase applies pending migrations at startup unless [model]
+// no_migrate is set, for operators who run "flashpaper schema upgrade" as a
+// separate deployment step. Migrations only ever add to the schema, so
+// instances of the previous release keep working during a rolling upgrade.
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+//go:embed schema
+var schemaFiles embed.FS
+
+// schemaVersionTable records applied migrations, one row each.
+const schemaVersionTable = `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied BIGINT NOT NULL
+	)
+`
+
+// SchemaMigrator is implemented by backends with a versioned schema.
+type SchemaMigrator interface {
+	// SchemaVersion returns the version of the stored schema and the
+	// latest version this release knows.
+	SchemaVersion(ctx context.Context) (current, latest int, err error)
+
+	// MigrateSchema applies the pending migrations and returns how many
+	// it applied. Instances migrating at once take turns.
+	MigrateSchema(ctx context.Context) (int, error)
+}
+
+// Schema returns s as a SchemaMigrator, looking through wrappers, or false
+// if its backend has no schema.
+func Schema(s Storage) (SchemaMigrator, bool) {
+	return find[SchemaMigrator](s)
+}
+
+// CheckSchema returns an error if s's schema is older than this release
+// needs, e.g. at startup with migrations turned off.
+func CheckSchema(ctx context.Context, s Storage) error {
+	m, ok := Schema(s)
+	if !ok {
+		return nil
+	}
+	current, latest, err := m.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current < latest {
+		return fmt.Errorf("database schema is at version %d, this release needs %d: run \"flashpaper schema upgrade\"", current, latest)
+	}
+	return nil
+}
+
+// migration is one embedded migration file.
+type migration struct {
+	version    int
+	name       string
+	statements []string
+}
+
+// migrations returns the migrations of driver in order.
+func migrations(driver string) ([]migration, error) {
+	dir := "schema/" + driver
+	entries, err := fs.ReadDir(schemaFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no schema migrations for driver %q", driver)
+	}
+
+	var ms []migration
+	for _, entry := range entries {
+		num, name, _ := strings.Cut(strings.TrimSuffix(entry.Name(), ".sql"), "_")
+		version, err := strconv.Atoi(num)
+		if err != nil || version != len(ms)+1 {
+			return nil, fmt.Errorf("schema migration %s/%s is out of sequence", driver, entry.Name())
+		}
+		data, err := schemaFiles.ReadFile(dir + "/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, migration{version: version, name: name, statements: splitStatements(string(data))})
+	}
+	return ms, nil
+}
+
+// splitStatements splits a migration file into its statements, since not
+// every driver executes several at once. Comment lines are dropped, and a
+// statement ends with a semicolon at the end of a line.
+func splitStatements(file string) []string {
+	var statements []string
+	var b strings.Builder
+	for _, line := range strings.Split(file, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(b.String()), ";"))
+			b.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(b.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+// SchemaVersion returns the version of the database's schema, 0 if it
+// predates versioned migrations, and the latest version this release knows.
+func (d *Database) SchemaVersion(ctx context.Context) (int, int, error) {
+	ms, err := migrations(d.driver)
+	if err != nil {
+		return 0, 0, err
+	}
+	db := d.db()
+
+	var version sql.NullInt64
+	err = db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_version").Scan(&version)
+	// Each driver's "no such table" error names the table. Reading
+	// mustn't create it: with no_migrate the user may lack the privilege
+	if err != nil && !strings.Contains(err.Error(), "schema_version") {
+		return 0, 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return int(version.Int64), len(ms), nil
+}
+
+// MigrateSchema applies the pending migrations, each in a transaction
+// with its schema_version row, under a lock shared by every instance.
+// MySQL commits schema changes implicitly, so there a migration that
+// fails halfway has to be finished by hand.
+func (d *Database) MigrateSchema(ctx context.Context) (int, error) {
+	ms, err := migrations(d.driver)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock, err := d.Lock(ctx, "schema")
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	db := d.db()
+	if _, err := db.ExecContext(ctx, schemaVersionTable); err != nil {
+		return 0, fmt.Errorf("creating schema_version table: %w", err)
+	}
+	current, latest, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if current > latest {
+		// A newer release got here first; its migrations only add to
+		// what this one uses
+		slog.Warn("Database schema is newer than this release", "version", current, "latest", latest)
+		return 0, nil
+	}
+
+	for _, m := range ms[current:] {
+		if err := d.applyMigration(ctx, db, m); err != nil {
+			return m.version - current - 1, fmt.Errorf("applying schema migration %d (%s): %w", m.version, m.name, err)
+		}
+		slog.Info("Applied database schema migration", "version", m.version, "name", m.name)
+	}
+	return latest - current, nil
+}
+
+// applyMigration runs m's statements and records it.
+func (d *Database) applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.statements {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	query := fmt.Sprintf("INSERT INTO schema_version (version, name, applied) VALUES (%s)", d.placeholders(3))
+	if _, err := tx.ExecContext(ctx, query, m.version, m.name, d.clock.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
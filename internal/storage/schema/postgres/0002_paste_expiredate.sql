@@ -0,0 +1,2 @@
+-- Purge looks up expired pastes by expiredate
+CREATE INDEX IF NOT EXISTS idx_paste_expiredate ON paste (expiredate);
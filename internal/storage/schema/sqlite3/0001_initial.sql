@@ -0,0 +1,50 @@
+-- The schema from before versioned migrations, which mirrors PrivateBin's.
+-- IF NOT EXISTS lets databases created back then adopt it as version 1.
+
+CREATE TABLE IF NOT EXISTS paste (
+	dataid CHAR(16) PRIMARY KEY,
+	data TEXT NOT NULL,
+	expiredate BIGINT,
+	meta TEXT
+);
+
+CREATE TABLE IF NOT EXISTS comment (
+	dataid CHAR(16) PRIMARY KEY,
+	pasteid CHAR(16) NOT NULL,
+	parentid CHAR(16),
+	data TEXT NOT NULL,
+	vizhash TEXT,
+	postdate BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_pasteid ON comment (pasteid);
+
+-- Key-value entries: server salt, rate limits, purge times, ...
+CREATE TABLE IF NOT EXISTS config (
+	id VARCHAR(64) PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+-- First-read timestamps
+CREATE TABLE IF NOT EXISTS receipt (
+	dataid CHAR(16) PRIMARY KEY,
+	firstread BIGINT NOT NULL
+);
+
+-- The counter row is what concurrent comment writers lock on, so the
+-- comment limit holds even under READ COMMITTED isolation
+CREATE TABLE IF NOT EXISTS commentcount (
+	pasteid CHAR(16) PRIMARY KEY,
+	total INTEGER NOT NULL
+);
+
+-- Attachments are split into chunks so they can be streamed without
+-- loading a whole file into memory, and stay within packet limits.
+-- idx numbers a paste's attachments, seq the chunks of each
+CREATE TABLE IF NOT EXISTS attachment (
+	dataid CHAR(16) NOT NULL,
+	idx INTEGER NOT NULL,
+	seq INTEGER NOT NULL,
+	data BLOB NOT NULL,
+	PRIMARY KEY (dataid, idx, seq)
+);
@@ -0,0 +1,499 @@
+// Package storage provides shadow writes for trying a new backend.
+// Before cutting over to another backend, operators can run it as a shadow
+// of the current one ([model] shadow): every change made to the primary is
+// made to the shadow as well, and every read answered by the primary is
+// repeated against the shadow and the two results compared. Requests are
+// only ever answered by the primary, and the shadow's work happens on a
+// background queue so its latency and failures never reach clients.
+// Divergences are counted per operation, so a shadow that has stayed in
+// agreement for long enough can be promoted with confidence.
+//
+// The shadow starts empty; run "flashpaper migrate" to the shadow first,
+// or expect reads of older pastes to diverge until they have expired.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/metrics"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// shadowQueueSize bounds the shadow work waiting to run. Beyond it work is
+// dropped, so a slow shadow can't hold up the primary.
+const shadowQueueSize = 1024
+
+// shadowed is a Storage answering from its primary and mirroring
+// everything to a shadow backend.
+type shadowed struct {
+	Storage // Primary
+	shadow  Storage
+
+	work    chan func()
+	pending sync.WaitGroup // Queued work not done yet
+	done    chan struct{}  // Closed when the worker exits
+
+	// Set by Instrument; nil records nothing
+	compared    *metrics.CounterVec
+	divergences *metrics.CounterVec
+	failures    *metrics.CounterVec
+	dropped     *metrics.CounterVec
+}
+
+// WithShadow returns primary with every operation mirrored to shadow in
+// the background and the results of reads compared. Closing it closes
+// both backends.
+func WithShadow(primary, shadow Storage) Storage {
+	s := &shadowed{
+		Storage: primary,
+		shadow:  shadow,
+		work:    make(chan func(), shadowQueueSize),
+		done:    make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// unwrap returns the primary backend.
+func (s *shadowed) unwrap() Storage {
+	return s.Storage
+}
+
+// run does the queued shadow work in order, so that a read is compared
+// only after the writes that preceded it reached the shadow.
+func (s *shadowed) run() {
+	defer close(s.done)
+	for fn := range s.work {
+		fn()
+		s.pending.Done()
+	}
+}
+
+// enqueue queues fn, or drops it if the queue is full.
+func (s *shadowed) enqueue(operation string, fn func()) {
+	s.pending.Add(1)
+	select {
+	case s.work <- fn:
+	default:
+		s.pending.Done()
+		s.dropped.Inc(operation)
+	}
+}
+
+// outcome classifies an operation's error for comparison: "" for success,
+// the error for the model errors that are part of the Storage contract,
+// and false for failures of the backend itself.
+func outcome(err error) (string, bool) {
+	for _, known := range []error{
+		model.ErrPasteNotFound, model.ErrPasteExpired, model.ErrPasteExists,
+		model.ErrCommentExists, model.ErrCommentLimitReached, model.ErrCommentNotFound,
+	} {
+		if errors.Is(err, known) {
+			return known.Error(), true
+		}
+	}
+	return "", err == nil
+}
+
+// replicate mirrors a write the primary made without error. fn runs in
+// the background, with ctx's values but not its cancellation, as the
+// request that made the write is usually over by then.
+func (s *shadowed) replicate(ctx context.Context, operation string, err error, fn func(ctx context.Context) error) {
+	if err != nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	s.enqueue(operation, func() {
+		s.record(operation, "", "", fn(ctx))
+	})
+}
+
+// record compares the shadow's result of an operation, a digest and an
+// error, with the primary's outcome and digest in want.
+func (s *shadowed) record(operation, want, digest string, err error) {
+	got, ok := outcome(err)
+	if !ok {
+		s.failures.Inc(operation)
+		slog.Debug("Shadow storage failed", "operation", operation, "error", err)
+		return
+	}
+	s.compared.Inc(operation)
+	if got+digest != want {
+		s.divergences.Inc(operation)
+		slog.Debug("Shadow storage diverged", "operation", operation)
+	}
+}
+
+// compare queues a read against the shadow, to be compared with the
+// primary's result. Reads the primary failed aren't compared. Like
+// replicate's, read runs without ctx's cancellation.
+func (s *shadowed) compare(ctx context.Context, operation string, err error, digest string, read func(ctx context.Context) (string, error)) {
+	want, ok := outcome(err)
+	if !ok {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	s.enqueue(operation, func() {
+		got, err := read(ctx)
+		s.record(operation, want+digest, got, err)
+	})
+}
+
+// pasteDigest returns what must match between two backends' copies of a
+// paste. Attachments are compared by number, as one backend may store them
+// apart and the other inline.
+func pasteDigest(paste *model.Paste) string {
+	if paste == nil {
+		return ""
+	}
+	meta := paste.Meta
+	meta.AttachmentSizes = nil
+	data, _ := json.Marshal(struct {
+		pasteStorageData
+		Attachments int
+	}{
+		pasteStorageData: pasteStorageData{
+			Data:           paste.Data,
+			AttachmentName: paste.AttachmentNames,
+			AData:          compactJSON(paste.AData),
+			Version:        paste.Version,
+			Meta:           storedMeta(meta),
+		},
+		Attachments: max(len(paste.Attachments), len(paste.Meta.AttachmentSizes)),
+	})
+	return string(data)
+}
+
+// commentsDigest returns what must match between two backends' copies of
+// a paste's comments.
+func commentsDigest(comments []*model.Comment) string {
+	type stored struct {
+		ID, ParentID string
+		commentStorageData
+	}
+	list := make([]stored, 0, len(comments))
+	for _, c := range comments {
+		list = append(list, stored{c.ID, c.ParentID, commentStorageData{
+			Data:     c.Data,
+			AData:    compactJSON(c.AData),
+			Version:  c.Version,
+			Vizhash:  c.Vizhash,
+			PostDate: c.Meta.PostDate,
+			Flagged:  c.Meta.Flagged,
+		}})
+	}
+	data, _ := json.Marshal(list)
+	return string(data)
+}
+
+// compactJSON removes insignificant whitespace, which backends storing
+// JSON natively may not preserve.
+func compactJSON(raw json.RawMessage) json.RawMessage {
+	var buf bytes.Buffer
+	if json.Compact(&buf, raw) != nil {
+		return raw
+	}
+	return buf.Bytes()
+}
+
+// copyAttachments makes a new paste's attachments available to the
+// shadow, before the paste itself, as Migrate does.
+func (s *shadowed) copyAttachments(ctx context.Context, id string, paste *model.Paste) error {
+	if len(paste.Meta.AttachmentSizes) == 0 {
+		return nil
+	}
+	return migrateAttachments(ctx, s.shadow, s.Storage, id, paste)
+}
+
+func (s *shadowed) CreatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	err := s.Storage.CreatePaste(ctx, id, paste)
+	copied := *paste
+	s.replicate(ctx, "create_paste", err, func(ctx context.Context) error {
+		if err := s.copyAttachments(ctx, id, &copied); err != nil {
+			return err
+		}
+		return s.shadow.CreatePaste(ctx, id, &copied)
+	})
+	return err
+}
+
+func (s *shadowed) ReadPaste(ctx context.Context, id string) (*model.Paste, error) {
+	paste, err := s.Storage.ReadPaste(ctx, id)
+	s.compare(ctx, "read_paste", err, pasteDigest(paste), func(ctx context.Context) (string, error) {
+		paste, err := s.shadow.ReadPaste(ctx, id)
+		return pasteDigest(paste), err
+	})
+	return paste, err
+}
+
+func (s *shadowed) DeletePaste(ctx context.Context, id string) error {
+	err := s.Storage.DeletePaste(ctx, id)
+	s.replicate(ctx, "delete_paste", err, func(ctx context.Context) error {
+		return s.shadow.DeletePaste(ctx, id)
+	})
+	return err
+}
+
+func (s *shadowed) ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error) {
+	paste, err := s.Storage.ReadAndDeletePaste(ctx, id)
+	s.compare(ctx, "read_and_delete_paste", err, pasteDigest(paste), func(ctx context.Context) (string, error) {
+		paste, err := s.shadow.ReadAndDeletePaste(ctx, id)
+		if err == nil && len(paste.Meta.AttachmentSizes) > 0 {
+			if a, ok := Attachments(s.shadow); ok {
+				_ = a.DeleteAttachments(ctx, id)
+			}
+		}
+		return pasteDigest(paste), err
+	})
+	return paste, err
+}
+
+func (s *shadowed) CountRead(ctx context.Context, id string) (*model.Paste, error) {
+	paste, err := s.Storage.CountRead(ctx, id)
+	s.compare(ctx, "count_read", err, pasteDigest(paste), func(ctx context.Context) (string, error) {
+		paste, err := s.shadow.CountRead(ctx, id)
+		if err == nil && paste.IsLastRead() && len(paste.Meta.AttachmentSizes) > 0 {
+			if a, ok := Attachments(s.shadow); ok {
+				_ = a.DeleteAttachments(ctx, id)
+			}
+		}
+		return pasteDigest(paste), err
+	})
+	return paste, err
+}
+
+func (s *shadowed) PasteExists(ctx context.Context, id string) bool {
+	exists := s.Storage.PasteExists(ctx, id)
+	s.compare(ctx, "paste_exists", nil, boolDigest(exists), func(ctx context.Context) (string, error) {
+		return boolDigest(s.shadow.PasteExists(ctx, id)), nil
+	})
+	return exists
+}
+
+func (s *shadowed) UpdatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	err := s.Storage.UpdatePaste(ctx, id, paste)
+	s.replicate(ctx, "update_paste", err, func(ctx context.Context) error {
+		return s.shadow.UpdatePaste(ctx, id, paste)
+	})
+	return err
+}
+
+func (s *shadowed) SetPinned(ctx context.Context, id string, pinned bool) error {
+	err := s.Storage.SetPinned(ctx, id, pinned)
+	s.replicate(ctx, "set_pinned", err, func(ctx context.Context) error {
+		return s.shadow.SetPinned(ctx, id, pinned)
+	})
+	return err
+}
+
+func (s *shadowed) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	err := s.Storage.SetDisabled(ctx, id, disabled)
+	s.replicate(ctx, "set_disabled", err, func(ctx context.Context) error {
+		return s.shadow.SetDisabled(ctx, id, disabled)
+	})
+	return err
+}
+
+func (s *shadowed) SetExpireDate(ctx context.Context, id string, expireDate int64) error {
+	err := s.Storage.SetExpireDate(ctx, id, expireDate)
+	s.replicate(ctx, "set_expire_date", err, func(ctx context.Context) error {
+		return s.shadow.SetExpireDate(ctx, id, expireDate)
+	})
+	return err
+}
+
+func (s *shadowed) CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) error {
+	err := s.Storage.CreateComment(ctx, pasteID, parentID, commentID, comment)
+	copied := *comment
+	s.replicate(ctx, "create_comment", err, func(ctx context.Context) error {
+		return s.shadow.CreateComment(ctx, pasteID, parentID, commentID, &copied)
+	})
+	return err
+}
+
+func (s *shadowed) ReadComments(ctx context.Context, pasteID string) ([]*model.Comment, error) {
+	comments, err := s.Storage.ReadComments(ctx, pasteID)
+	s.compare(ctx, "read_comments", err, commentsDigest(comments), func(ctx context.Context) (string, error) {
+		comments, err := s.shadow.ReadComments(ctx, pasteID)
+		return commentsDigest(comments), err
+	})
+	return comments, err
+}
+
+func (s *shadowed) CountComments(ctx context.Context, pasteID string) (int, error) {
+	count, err := s.Storage.CountComments(ctx, pasteID)
+	s.compare(ctx, "count_comments", err, strconv.Itoa(count), func(ctx context.Context) (string, error) {
+		count, err := s.shadow.CountComments(ctx, pasteID)
+		return strconv.Itoa(count), err
+	})
+	return count, err
+}
+
+func (s *shadowed) CommentExists(ctx context.Context, pasteID, parentID, commentID string) bool {
+	exists := s.Storage.CommentExists(ctx, pasteID, parentID, commentID)
+	s.compare(ctx, "comment_exists", nil, boolDigest(exists), func(ctx context.Context) (string, error) {
+		return boolDigest(s.shadow.CommentExists(ctx, pasteID, parentID, commentID)), nil
+	})
+	return exists
+}
+
+func (s *shadowed) DeleteComment(ctx context.Context, pasteID, commentID string) error {
+	err := s.Storage.DeleteComment(ctx, pasteID, commentID)
+	s.replicate(ctx, "delete_comment", err, func(ctx context.Context) error {
+		return s.shadow.DeleteComment(ctx, pasteID, commentID)
+	})
+	return err
+}
+
+func (s *shadowed) MarkRead(ctx context.Context, id string) (bool, error) {
+	first, err := s.Storage.MarkRead(ctx, id)
+	s.compare(ctx, "mark_read", err, boolDigest(first), func(ctx context.Context) (string, error) {
+		first, err := s.shadow.MarkRead(ctx, id)
+		return boolDigest(first), err
+	})
+	return first, err
+}
+
+// GetReadReceipt compares whether the paste was read; the backends each
+// take their own time of the first read.
+func (s *shadowed) GetReadReceipt(ctx context.Context, id string) (*model.ReadReceipt, error) {
+	receipt, err := s.Storage.GetReadReceipt(ctx, id)
+	s.compare(ctx, "get_read_receipt", err, receiptDigest(receipt), func(ctx context.Context) (string, error) {
+		receipt, err := s.shadow.GetReadReceipt(ctx, id)
+		return receiptDigest(receipt), err
+	})
+	return receipt, err
+}
+
+func (s *shadowed) SetValue(ctx context.Context, namespace, key, value string) error {
+	err := s.Storage.SetValue(ctx, namespace, key, value)
+	s.replicate(ctx, "set_value", err, func(ctx context.Context) error {
+		return s.shadow.SetValue(ctx, namespace, key, value)
+	})
+	return err
+}
+
+func (s *shadowed) GetValue(ctx context.Context, namespace, key string) (string, error) {
+	value, err := s.Storage.GetValue(ctx, namespace, key)
+	s.compare(ctx, "get_value", err, value, func(ctx context.Context) (string, error) {
+		return s.shadow.GetValue(ctx, namespace, key)
+	})
+	return value, err
+}
+
+// CompareAndSwapValue swaps on the primary and, if that took, sets the
+// value on the shadow. A primary that can't swap gets a plain compare and
+// set, which is what callers would otherwise do themselves.
+func (s *shadowed) CompareAndSwapValue(ctx context.Context, namespace, key, old, new string) (bool, error) {
+	var swapped bool
+	var err error
+	if swapper, ok := Swapper(s.Storage); ok {
+		swapped, err = swapper.CompareAndSwapValue(ctx, namespace, key, old, new)
+	} else {
+		var current string
+		current, err = s.Storage.GetValue(ctx, namespace, key)
+		if swapped = err == nil && current == old; swapped {
+			err = s.Storage.SetValue(ctx, namespace, key, new)
+		}
+	}
+	if swapped {
+		s.replicate(ctx, "set_value", err, func(ctx context.Context) error {
+			return s.shadow.SetValue(ctx, namespace, key, new)
+		})
+	}
+	return swapped && err == nil, err
+}
+
+// Purge purges the primary, and the shadow on its own schedule: the two
+// may pick different expired pastes for a batch.
+func (s *shadowed) Purge(ctx context.Context, batchSize int) (int, error) {
+	purged, err := s.Storage.Purge(ctx, batchSize)
+	s.replicate(ctx, "purge", err, func(ctx context.Context) error {
+		_, err := s.shadow.Purge(ctx, batchSize)
+		return err
+	})
+	return purged, err
+}
+
+func (s *shadowed) PurgeValues(ctx context.Context, namespace string, maxAge int64) error {
+	err := s.Storage.PurgeValues(ctx, namespace, maxAge)
+	s.replicate(ctx, "purge_values", err, func(ctx context.Context) error {
+		return s.shadow.PurgeValues(ctx, namespace, maxAge)
+	})
+	return err
+}
+
+// boolDigest returns b as a digest.
+func boolDigest(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+// receiptDigest returns whether receipt records a read, as a digest.
+func receiptDigest(receipt *model.ReadReceipt) string {
+	return boolDigest(receipt != nil && receipt.IsRead())
+}
+
+// SetClock sets the clock of both backends.
+func (s *shadowed) SetClock(c clock.Clock) {
+	SetClock(s.Storage, c)
+	SetClock(s.shadow, c)
+}
+
+// Warmup warms up both backends. A shadow that fails to warm up is
+// logged rather than stopping startup.
+func (s *shadowed) Warmup(ctx context.Context) error {
+	if err := Warmup(ctx, s.shadow); err != nil {
+		slog.Warn("Shadow storage warmup failed", "error", err)
+	}
+	return Warmup(ctx, s.Storage)
+}
+
+// Drain waits for the queued shadow work, then drains both backends.
+func (s *shadowed) Drain(ctx context.Context) error {
+	flushed := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := Drain(ctx, s.shadow); err != nil {
+		slog.Warn("Shadow storage drain failed", "error", err)
+	}
+	return Drain(ctx, s.Storage)
+}
+
+// Instrument registers the comparison metrics and the primary's own.
+func (s *shadowed) Instrument(r *metrics.Registry) {
+	s.compared = r.Counter("flashpaper_storage_shadow_compared_total",
+		"Storage operations repeated against the shadow backend and compared.", "operation")
+	s.divergences = r.Counter("flashpaper_storage_shadow_divergences_total",
+		"Storage operations whose shadow backend result differed from the primary's.", "operation")
+	s.failures = r.Counter("flashpaper_storage_shadow_errors_total",
+		"Storage operations that failed on the shadow backend.", "operation")
+	s.dropped = r.Counter("flashpaper_storage_shadow_dropped_total",
+		"Storage operations not mirrored because the shadow queue was full.", "operation")
+	if i, ok := find[Instrumenter](s.Storage); ok {
+		i.Instrument(r)
+	}
+}
+
+// Close finishes the queued shadow work and closes both backends.
+func (s *shadowed) Close() error {
+	close(s.work)
+	<-s.done
+	if err := s.shadow.Close(); err != nil {
+		slog.Warn("Closing shadow storage failed", "error", err)
+	}
+	return s.Storage.Close()
+}
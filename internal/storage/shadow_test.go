@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/metrics"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// inlineOnly hides a backend's AttachmentStore.
+type inlineOnly struct{ Storage }
+
+// newShadowed returns two Mocks, the second shadowing the first, with
+// the comparison metrics registered.
+func newShadowed(t *testing.T) (*shadowed, *Mock, *Mock) {
+	t.Helper()
+	primary, shadow := NewMock(), NewMock()
+	s := WithShadow(primary, shadow).(*shadowed)
+	s.Instrument(metrics.NewRegistry())
+	t.Cleanup(func() { s.Close() })
+	return s, primary, shadow
+}
+
+// flush waits for the queued shadow work.
+func flush(t *testing.T, s Storage) {
+	t.Helper()
+	require.NoError(t, Drain(context.Background(), s))
+}
+
+func TestShadow_Mirrors(t *testing.T) {
+	ctx := context.Background()
+	s, _, shadow := newShadowed(t)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	paste.AData = []byte(`[ ["iv","salt",100000,256,128,"aes","gcm","zlib"], "plaintext", 1, 0 ]`)
+	paste.Meta.OpenDiscussion = true
+	paste.SetExpiration(time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+	comment := model.NewComment(pasteID)
+	comment.Data = "comment"
+	require.NoError(t, s.CreateComment(ctx, pasteID, pasteID, "1111111111111111", comment))
+	require.NoError(t, s.SetValue(ctx, NamespaceAdmin, "key", "value"))
+	_, err := s.MarkRead(ctx, pasteID)
+	require.NoError(t, err)
+	flush(t, s)
+
+	stored, err := shadow.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", stored.Data)
+	assert.Equal(t, 1, shadow.GetCommentCount(pasteID))
+	value, _ := shadow.GetValue(ctx, NamespaceAdmin, "key")
+	assert.Equal(t, "value", value)
+
+	// Reads agree
+	_, err = s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	_, err = s.ReadComments(ctx, pasteID)
+	require.NoError(t, err)
+	_, err = s.ReadPaste(ctx, "0123456789abcdef")
+	assert.ErrorIs(t, err, model.ErrPasteNotFound)
+	_, err = s.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	flush(t, s)
+	for _, op := range []string{"read_paste", "read_comments", "get_read_receipt", "mark_read"} {
+		assert.Equal(t, 0.0, s.divergences.Value(op), op)
+	}
+	assert.Equal(t, 2.0, s.compared.Value("read_paste"))
+
+	require.NoError(t, s.DeletePaste(ctx, pasteID))
+	flush(t, s)
+	assert.False(t, shadow.PasteExists(ctx, pasteID))
+	assert.Equal(t, 0.0, s.failures.Value("delete_paste"))
+}
+
+func TestShadow_Divergence(t *testing.T) {
+	ctx := context.Background()
+	s, primary, shadow := newShadowed(t)
+
+	// Stored before the shadow was added
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	require.NoError(t, primary.CreatePaste(ctx, pasteID, paste))
+	read, err := s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, "ciphertext", read.Data)
+
+	// Changed behind the primary's back
+	require.NoError(t, s.SetValue(ctx, NamespaceAdmin, "key", "value"))
+	flush(t, s)
+	require.NoError(t, shadow.SetValue(ctx, NamespaceAdmin, "key", "other"))
+	value, err := s.GetValue(ctx, NamespaceAdmin, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+
+	// Writes the shadow can't make
+	require.NoError(t, s.SetPinned(ctx, pasteID, true))
+	flush(t, s)
+
+	assert.Equal(t, 1.0, s.divergences.Value("read_paste"))
+	assert.Equal(t, 1.0, s.divergences.Value("get_value"))
+	assert.Equal(t, 1.0, s.divergences.Value("set_pinned"))
+}
+
+func TestShadow_Attachments(t *testing.T) {
+	ctx := context.Background()
+	primary, shadow := NewMock(), NewMock()
+	s := WithShadow(primary, inlineOnly{shadow}).(*shadowed)
+	s.Instrument(metrics.NewRegistry())
+	defer s.Close()
+
+	pasteID := "abcdef1234567890"
+	store, ok := Attachments(s)
+	require.True(t, ok)
+	n, err := store.WriteAttachment(ctx, pasteID, 0, strings.NewReader("attachment"))
+	require.NoError(t, err)
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	paste.Meta.AttachmentSizes = []int64{n}
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+	flush(t, s)
+
+	// The shadow keeps attachments inline, which compares equal
+	stored, err := shadow.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, model.StringList{"attachment"}, stored.Attachments)
+	_, err = s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	flush(t, s)
+	assert.Equal(t, 1.0, s.compared.Value("read_paste"))
+	assert.Equal(t, 0.0, s.divergences.Value("read_paste"))
+}
+
+func TestShadow_Backend(t *testing.T) {
+	s, _, _ := newShadowed(t)
+	checkReadAndDelete(t, s)
+	checkCountRead(t, s)
+	checkUpdatePaste(t, s)
+	checkDeleteComment(t, s)
+
+	s, _, _ = newShadowed(t)
+	checkIterate(t, s)
+
+	s, _, shadow := newShadowed(t)
+	checkClock(t, s)
+	flush(t, s)
+	assert.Zero(t, shadow.GetPasteCount())
+
+	s, _, _ = newShadowed(t)
+	checkAttachmentStore(t, WithMetrics(s, "mock"))
+}
@@ -0,0 +1,38 @@
+//go:build cgo && !purego
+
+// Package storage provides the SQLite driver of cgo builds,
+// github.com/mattn/go-sqlite3, which compiles in the SQLite C library.
+// Builds without cgo, or with the purego tag, use sqlite_purego.go instead.
+package storage
+
+import (
+	"net/url"
+
+	_ "github.com/mattn/go-sqlite3" // Registers "sqlite3"
+)
+
+// sqliteDriver is the database/sql driver serving the sqlite3 model driver.
+const sqliteDriver = "sqlite3"
+
+// sqlitePragmaParams lists go-sqlite3's DSN parameters for each pragma
+// sqliteDSN sets, the preferred name first.
+var sqlitePragmaParams = map[string][]string{
+	"busy_timeout": {"_busy_timeout", "_timeout"},
+	"journal_mode": {"_journal_mode", "_journal"},
+	"synchronous":  {"_synchronous", "_sync"},
+}
+
+// sqlitePragmaSet reports whether DSN parameters set a pragma.
+func sqlitePragmaSet(query url.Values, pragma string) bool {
+	for _, name := range sqlitePragmaParams[pragma] {
+		if query.Has(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlitePragmaParam returns the DSN parameter setting a pragma.
+func sqlitePragmaParam(pragma, value string) string {
+	return sqlitePragmaParams[pragma][0] + "=" + url.QueryEscape(value)
+}
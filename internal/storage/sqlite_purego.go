@@ -0,0 +1,33 @@
+//go:build !cgo || purego
+
+// Package storage provides the SQLite driver of builds without cgo,
+// modernc.org/sqlite, a translation of the SQLite C library to Go. Static
+// CGO_ENABLED=0 binaries thus still support the sqlite3 model driver; the
+// purego build tag selects it in cgo builds too.
+package storage
+
+import (
+	"net/url"
+	"strings"
+
+	_ "modernc.org/sqlite" // Registers "sqlite"
+)
+
+// sqliteDriver is the database/sql driver serving the sqlite3 model driver.
+const sqliteDriver = "sqlite"
+
+// sqlitePragmaSet reports whether DSN parameters set a pragma. The driver
+// takes any pragma as _pragma=name(value).
+func sqlitePragmaSet(query url.Values, pragma string) bool {
+	for _, p := range query["_pragma"] {
+		if strings.HasPrefix(strings.ToLower(p), pragma+"(") {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlitePragmaParam returns the DSN parameter setting a pragma.
+func sqlitePragmaParam(pragma, value string) string {
+	return "_pragma=" + url.QueryEscape(pragma+"("+value+")")
+}
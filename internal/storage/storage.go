@@ -1,28 +1,46 @@
 // Package storage provides the persistence layer for FlashPaper.
 // It defines the Storage interface that abstracts different backends
-// (database, filesystem) allowing the application to switch between
+// (database, filesystem, S3) allowing the application to switch between
 // them without changing business logic.
 //
 // The storage layer is responsible for:
 // - Paste CRUD operations
 // - Comment management
+// - Iteration over comments and pastes without loading them all
+// - First-read receipts
 // - Key-value storage for config (rate limiting, server salt)
 // - Expired paste purging
+// - Aggregate paste statistics (PasteStats)
+// - Optional startup warm-up and shutdown draining (Warmer, Drainer)
+// - Optional backend metrics (Instrumenter)
+// - Optional enumeration of all contents for migration (Exporter)
+// - Optional attachment storage apart from pastes (AttachmentStore)
+// - Optional encryption of stored records at rest (WithEncryption)
+// - Optional shadow writes to a second backend under evaluation (WithShadow)
 //
 // All implementations must be safe for concurrent use.
 package storage
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/liskl/flashpaper/internal/clock"
 	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/metrics"
 	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/util"
 )
 
 // Storage defines the contract for paste and comment persistence.
 // Implementations must be safe for concurrent use.
 // All methods that modify state should be atomic where possible.
+//
+// Every method takes the context of the request it serves, and gives up
+// with the context's error once it is done; backends that talk to a
+// server also bound each operation by [model] query_timeout.
 type Storage interface {
 	// Paste operations
 
@@ -30,87 +48,484 @@ type Storage interface {
 	// Returns model.ErrPasteExists if a paste with this ID already exists.
 	// The paste's metadata (expiration, burn-after-reading) is stored
 	// alongside the encrypted content.
-	CreatePaste(id string, paste *model.Paste) error
+	CreatePaste(ctx context.Context, id string, paste *model.Paste) error
 
 	// ReadPaste retrieves a paste by ID.
 	// Returns model.ErrPasteNotFound if the paste doesn't exist.
 	// Returns model.ErrPasteExpired if the paste has expired (and deletes it).
-	ReadPaste(id string) (*model.Paste, error)
+	ReadPaste(ctx context.Context, id string) (*model.Paste, error)
 
 	// DeletePaste removes a paste and all its comments.
 	// Returns model.ErrPasteNotFound if the paste doesn't exist.
-	DeletePaste(id string) error
+	DeletePaste(ctx context.Context, id string) error
+
+	// ReadAndDeletePaste reads and deletes a paste as one operation, for
+	// burn-after-reading: of concurrent callers only one gets the paste,
+	// the others get model.ErrPasteNotFound. Comments and the read receipt
+	// go with it; attachments stored apart (see AttachmentStore) are left
+	// for the caller to send and then remove with DeleteAttachments.
+	// Returns model.ErrPasteExpired if the paste has expired.
+	ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error)
+
+	// CountRead reads a paste with a read limit and uses up one of its
+	// reads: of concurrent callers each uses up a different one, and the
+	// caller using the last deletes the paste as ReadAndDeletePaste does.
+	// The paste is returned with the reads left after this one in
+	// Meta.ReadsLeft, 0 if it was deleted. Pastes without a read limit are
+	// returned as ReadPaste returns them.
+	CountRead(ctx context.Context, id string) (*model.Paste, error)
+
+	// UpdatePaste replaces the content of a paste, its Data, AData,
+	// Version, and formatter, with paste's. Everything else is kept:
+	// meta, comments, attachments, and the read receipt.
+	// Returns model.ErrPasteNotFound if the paste doesn't exist.
+	UpdatePaste(ctx context.Context, id string, paste *model.Paste) error
 
 	// PasteExists checks if a paste with the given ID exists.
 	// This is a quick check that doesn't load the full paste data.
-	PasteExists(id string) bool
+	PasteExists(ctx context.Context, id string) bool
+
+	// SetPinned sets the paste's Pinned meta flag. Pinned pastes never
+	// expire and are skipped by GetExpiredPastes and Purge; unpinning
+	// restores the original expiration date.
+	// Returns model.ErrPasteNotFound if the paste doesn't exist.
+	SetPinned(ctx context.Context, id string, pinned bool) error
+
+	// SetDisabled sets the paste's Disabled meta flag. Disabled pastes are
+	// stored and expire as before; the handlers refuse to serve them.
+	// Returns model.ErrPasteNotFound if the paste doesn't exist.
+	SetDisabled(ctx context.Context, id string, disabled bool) error
+
+	// SetExpireDate sets the paste's expiration date (Unix time, 0 for
+	// never). A pinned paste keeps the date for when it is unpinned.
+	// Returns model.ErrPasteNotFound if the paste doesn't exist.
+	SetExpireDate(ctx context.Context, id string, expireDate int64) error
 
 	// Comment operations
 
-	// CreateComment stores a new comment on a paste.
+	// CreateComment stores a new comment on a paste and increments the
+	// paste's comment count as a single atomic operation. If the count has
+	// reached the configured comment limit, nothing is stored.
 	// Returns model.ErrPasteNotFound if the paste doesn't exist.
 	// Returns model.ErrCommentExists if a comment with this ID exists.
-	CreateComment(pasteID, parentID, commentID string, comment *model.Comment) error
+	// Returns model.ErrCommentLimitReached if the paste is full.
+	CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) error
+
+	// CountComments returns the number of comments on a paste
+	// without loading them.
+	CountComments(ctx context.Context, pasteID string) (int, error)
 
 	// ReadComments retrieves all comments for a paste.
 	// Returns an empty slice if no comments exist.
-	ReadComments(pasteID string) ([]*model.Comment, error)
+	ReadComments(ctx context.Context, pasteID string) ([]*model.Comment, error)
+
+	// IterateComments calls fn for each comment on a paste, in the order
+	// of ReadComments, stopping at the first error fn returns and
+	// returning it. Backends that can read comments in order keep only
+	// some in memory at a time; fn may call into the store.
+	IterateComments(ctx context.Context, pasteID string, fn func(*model.Comment) error) error
 
 	// CommentExists checks if a comment exists.
-	CommentExists(pasteID, parentID, commentID string) bool
+	CommentExists(ctx context.Context, pasteID, parentID, commentID string) bool
+
+	// DeleteComment removes a comment from a paste and decrements the
+	// paste's comment count as a single atomic operation. Replies to the
+	// comment are kept.
+	// Returns model.ErrCommentNotFound if the comment doesn't exist.
+	DeleteComment(ctx context.Context, pasteID, commentID string) error
+
+	// Read receipt operations
+
+	// MarkRead records the first read of a paste at the current time.
+	// Later calls leave the original timestamp in place and report false.
+	// Returns model.ErrPasteNotFound if the paste doesn't exist.
+	MarkRead(ctx context.Context, id string) (first bool, err error)
+
+	// GetReadReceipt retrieves the read receipt for a paste.
+	// An unread paste yields a receipt with FirstRead == 0.
+	// Returns model.ErrPasteNotFound if the paste doesn't exist.
+	GetReadReceipt(ctx context.Context, id string) (*model.ReadReceipt, error)
 
 	// Key-value storage for configuration and rate limiting
 
 	// SetValue stores a string value with the given namespace and key.
 	// Used for server salt, rate limiting timestamps, etc.
-	SetValue(namespace, key, value string) error
+	SetValue(ctx context.Context, namespace, key, value string) error
 
 	// GetValue retrieves a stored value.
 	// Returns empty string if the key doesn't exist.
-	GetValue(namespace, key string) (string, error)
+	GetValue(ctx context.Context, namespace, key string) (string, error)
 
 	// Maintenance operations
 
 	// GetExpiredPastes returns a list of expired paste IDs up to batchSize.
 	// Used by the purge system to clean up old pastes.
-	GetExpiredPastes(batchSize int) ([]string, error)
+	GetExpiredPastes(ctx context.Context, batchSize int) ([]string, error)
+
+	// IteratePastes calls fn for every paste that hasn't expired, in no
+	// particular order, stopping at the first error fn returns and
+	// returning it. Pastes are read as fn needs them rather than all at
+	// once, and fn may call into the store. Pastes created or deleted
+	// during the iteration may or may not be seen.
+	IteratePastes(ctx context.Context, fn func(*model.Paste) error) error
+
+	// PasteStats counts the pastes that haven't expired, how many of them
+	// were posted at or after since (Unix time), and their total size.
+	// Backends that can aggregate without reading every paste should.
+	PasteStats(ctx context.Context, since int64) (*PasteStats, error)
 
 	// Purge deletes expired pastes up to batchSize.
 	// Returns the number of pastes deleted.
-	Purge(batchSize int) (int, error)
+	Purge(ctx context.Context, batchSize int) (int, error)
 
 	// PurgeValues removes outdated rate limiting entries.
-	// Entries older than maxAge seconds are removed.
-	PurgeValues(namespace string, maxAge int64) error
+	// Entries holding a Unix time, in seconds or milliseconds (as the rate
+	// limiter stores them), more than maxAge seconds ago are removed.
+	PurgeValues(ctx context.Context, namespace string, maxAge int64) error
+
+	// Ping checks cheaply that the backend can be reached, for readiness
+	// probes. It should return early with ctx.Err() if ctx is done.
+	Ping(ctx context.Context) error
 
 	// Close releases any resources held by the storage backend.
 	// Should be called when the application shuts down.
 	Close() error
 }
 
+// PasteStats are aggregate figures over a backend's pastes.
+type PasteStats struct {
+	Pastes int   // Pastes that haven't expired
+	Recent int   // Of those, posted at or after the time asked about
+	Bytes  int64 // Their paste data and attachments, as stored
+}
+
+// tallyPastes computes PasteStats with IteratePastes, for backends that
+// have no cheaper way to aggregate.
+func tallyPastes(ctx context.Context, s Storage, since int64) (*PasteStats, error) {
+	stats := &PasteStats{}
+	err := s.IteratePastes(ctx, func(p *model.Paste) error {
+		stats.Pastes++
+		if p.Meta.PostDate >= since {
+			stats.Recent++
+		}
+		stats.Bytes += int64(len(p.Data)) + p.AttachmentLength()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Warmer is implemented by backends that can prepare for traffic at
+// startup, e.g. by pre-creating prepared statements or filling caches.
+type Warmer interface {
+	// Warmup is called once before the server starts accepting requests.
+	// An error means the backend isn't usable and startup should abort.
+	Warmup(ctx context.Context) error
+}
+
+// Drainer is implemented by backends with work to finish before Close,
+// e.g. flushing write-behind buffers.
+type Drainer interface {
+	// Drain is called during graceful shutdown, after the server has
+	// stopped accepting requests and before Close. It should return early
+	// with ctx.Err() if ctx is done.
+	Drain(ctx context.Context) error
+}
+
+// Instrumenter is implemented by backends that export metrics of their
+// own, e.g. database failover events.
+type Instrumenter interface {
+	// Instrument registers the backend's metrics with r.
+	Instrument(r *metrics.Registry)
+}
+
+// Exporter is implemented by backends that can enumerate everything they
+// store, which Migrate needs to copy them to another backend.
+type Exporter interface {
+	// PasteIDs returns the IDs of all stored pastes, including expired
+	// ones that haven't been purged yet.
+	PasteIDs(ctx context.Context) ([]string, error)
+
+	// Values calls fn for every key-value entry, stopping at the first
+	// error fn returns.
+	Values(ctx context.Context, fn func(namespace, key, value string) error) error
+}
+
+// Clocked is implemented by backends that judge expiration themselves:
+// whether a read paste has expired, which pastes GetExpiredPastes returns,
+// and when a receipt was taken. All built-in backends are.
+type Clocked interface {
+	// SetClock replaces the system clock. It must be called before the
+	// backend is used.
+	SetClock(c clock.Clock)
+}
+
+// wrapper is implemented by Storages wrapping a backend, such as those of
+// WithMetrics and WithEncryption.
+type wrapper interface {
+	unwrap() Storage
+}
+
+// Unwrap returns the backend under any wrappers of s.
+func Unwrap(s Storage) Storage {
+	for {
+		w, ok := s.(wrapper)
+		if !ok {
+			return s
+		}
+		s = w.unwrap()
+	}
+}
+
+// find returns the first of s and the Storages it wraps that implements
+// T. Wrappers implement T when they need to pass the call on themselves,
+// such as to both backends of WithShadow.
+func find[T any](s Storage) (T, bool) {
+	for {
+		if t, ok := s.(T); ok {
+			return t, true
+		}
+		w, ok := s.(wrapper)
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		s = w.unwrap()
+	}
+}
+
+// SetClock sets the clock of s, looking through wrappers, if it is
+// Clocked.
+func SetClock(s Storage, c clock.Clock) {
+	if cs, ok := find[Clocked](s); ok {
+		cs.SetClock(c)
+	}
+}
+
+// AttachmentStore is implemented by backends that keep attachments apart
+// from the paste document, so large files are streamed in and out rather
+// than loaded into memory with every read of the paste. A paste whose
+// Meta.AttachmentSizes is set has its attachments here, numbered from 0.
+type AttachmentStore interface {
+	// WriteAttachment stores attachment index of paste id from r,
+	// replacing any previous one, and returns its size. Attachments are
+	// written before the paste itself; DeletePaste removes them along
+	// with the paste.
+	WriteAttachment(ctx context.Context, id string, index int, r io.Reader) (int64, error)
+
+	// OpenAttachment opens attachment index of paste id for reading.
+	// Returns model.ErrPasteNotFound if there is none.
+	OpenAttachment(ctx context.Context, id string, index int) (io.ReadCloser, error)
+
+	// DeleteAttachments removes all attachments of paste id, e.g. when
+	// storing the paste failed after its attachments were written.
+	DeleteAttachments(ctx context.Context, id string) error
+}
+
+// Attachments returns s as an AttachmentStore, looking through wrappers,
+// or false if s keeps attachments inline.
+func Attachments(s Storage) (AttachmentStore, bool) {
+	a, ok := find[AttachmentStore](s)
+	return a, ok
+}
+
+// OpenAttachment opens attachment index of a paste wherever it is stored:
+// inline in the paste, or in s's AttachmentStore.
+func OpenAttachment(ctx context.Context, s Storage, id string, paste *model.Paste, index int) (io.ReadCloser, error) {
+	if len(paste.Meta.AttachmentSizes) == 0 {
+		if index >= len(paste.Attachments) {
+			return nil, model.ErrPasteNotFound
+		}
+		return io.NopCloser(strings.NewReader(paste.Attachments[index])), nil
+	}
+	a, ok := Attachments(s)
+	if !ok || index >= len(paste.Meta.AttachmentSizes) {
+		return nil, model.ErrPasteNotFound
+	}
+	return a.OpenAttachment(ctx, id, index)
+}
+
+// Warmup warms up the backend if it implements Warmer.
+func Warmup(ctx context.Context, s Storage) error {
+	if w, ok := find[Warmer](s); ok {
+		return w.Warmup(ctx)
+	}
+	return nil
+}
+
+// Drain drains the backend if it implements Drainer.
+func Drain(ctx context.Context, s Storage) error {
+	if d, ok := find[Drainer](s); ok {
+		return d.Drain(ctx)
+	}
+	return nil
+}
+
+// Pin pins or unpins a paste. Expired pastes can't be pinned (reading them
+// deletes them), nor can burn-after-reading pastes.
+func Pin(ctx context.Context, s Storage, id string, pinned bool) error {
+	if pinned {
+		paste, err := s.ReadPaste(ctx, id)
+		if err != nil {
+			return err
+		}
+		if paste.IsBurnAfterReading() {
+			return model.ErrPinBurnAfterReading
+		}
+	}
+	return s.SetPinned(ctx, id, pinned)
+}
+
+// countRead implements CountRead for backends whose update rewrites a
+// paste's meta under a lock or in a transaction, as their updateMeta does.
+// Only meta changes after creation, so the paste is read first; the count
+// is then taken down under update, except for the last read, which is left
+// to ReadAndDeletePaste to settle between concurrent readers.
+func countRead(ctx context.Context, s Storage, id string, update func(func(meta *pasteMeta)) error) (*model.Paste, error) {
+	paste, err := s.ReadPaste(ctx, id)
+	if err != nil || !paste.HasReadLimit() {
+		return paste, err
+	}
+
+	var left int
+	err = update(func(meta *pasteMeta) {
+		left = meta.ReadsLeft
+		if left > 1 {
+			meta.ReadsLeft--
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if left <= 1 {
+		if paste, err = s.ReadAndDeletePaste(ctx, id); err != nil {
+			return nil, err
+		}
+		paste.Meta.ReadsLeft = 0
+		return paste, nil
+	}
+	paste.Meta.ReadsLeft = left - 1
+	return paste, nil
+}
+
+// pasteMeta is paste metadata as the backends persist it. model.PasteMeta
+// leaves the salt and the access proof hash out of its JSON so they can't
+// reach clients; this adds them.
+type pasteMeta struct {
+	model.PasteMeta
+	Salt        string `json:"salt,omitempty"`
+	AccessProof string `json:"accessproof,omitempty"`
+}
+
+// storedMeta returns m in its persisted form.
+func storedMeta(m model.PasteMeta) pasteMeta {
+	return pasteMeta{PasteMeta: m, Salt: m.Salt, AccessProof: m.AccessProof}
+}
+
+// meta returns the metadata with its salt. Pastes stored before per-paste
+// salts have none; their delete tokens derive from the server salt.
+func (m pasteMeta) meta() model.PasteMeta {
+	meta := m.PasteMeta
+	meta.Salt = m.Salt
+	meta.AccessProof = m.AccessProof
+	return meta
+}
+
+// ServerSalt returns the server salt, generating and storing one if the
+// backend doesn't have it yet. The salt keys signed admin tokens and older
+// pastes' delete tokens, so it must persist across restarts, and replicas
+// starting together must agree on it: generating it is done under Lock.
+func ServerSalt(ctx context.Context, s Storage) (string, error) {
+	salt, err := s.GetValue(ctx, NamespaceSalt, "server")
+	if err == nil && salt != "" {
+		return salt, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+	unlock, err := Lock(ctx, s, "salt")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	// Another instance may have stored one while we waited
+	salt, err = s.GetValue(ctx, NamespaceSalt, "server")
+	if err != nil {
+		return "", fmt.Errorf("reading server salt: %w", err)
+	}
+	if salt != "" {
+		return salt, nil
+	}
+
+	salt, err = util.GenerateSalt()
+	if err != nil {
+		return "", err
+	}
+	if err := s.SetValue(ctx, NamespaceSalt, "server", salt); err != nil {
+		return "", fmt.Errorf("storing server salt: %w", err)
+	}
+	return salt, nil
+}
+
 // StorageCloser combines Storage with io.Closer for resource management.
 type StorageCloser interface {
 	Storage
 	io.Closer
 }
 
-// New creates a new storage backend based on configuration.
+// New creates a new storage backend based on configuration, sealing
+// records with the configured encryption keys if there are any.
 // The returned Storage should be closed when no longer needed.
 func New(cfg *config.Config) (Storage, error) {
+	keys, err := encryptionKeys(cfg.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Storage
 	switch cfg.Model.Class {
 	case "Database":
-		return NewDatabase(cfg)
+		s, err = NewDatabase(cfg)
 	case "Filesystem":
-		return NewFilesystem(cfg)
+		s, err = NewFilesystem(cfg)
+	case "S3":
+		s, err = NewS3(cfg)
+	case "Blob":
+		s, err = NewBlob(cfg)
 	default:
 		return nil, fmt.Errorf("unknown storage class: %s", cfg.Model.Class)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if keys != nil {
+		s = WithEncryption(s, keys)
+	}
+	return s, nil
+}
+
+// maxUnixSeconds separates the Unix times key-value entries hold in seconds
+// from those in milliseconds (see PurgeValues): as seconds, it is a date
+// over three thousand years away, and as milliseconds one in 1973.
+const maxUnixSeconds = 100_000_000_000
+
+// valueSeconds returns an entry's Unix time in seconds.
+func valueSeconds(timestamp int64) int64 {
+	if timestamp > maxUnixSeconds {
+		return timestamp / 1000
+	}
+	return timestamp
 }
 
 // Namespace constants for key-value storage.
 // These prevent key collisions between different subsystems.
 const (
-	// NamespaceSalt stores the server salt for delete tokens
+	// NamespaceSalt stores the server salt (IP hashes, admin tokens, and
+	// delete tokens of pastes without their own salt)
 	NamespaceSalt = "salt"
 
 	// NamespaceTraffic stores rate limiting timestamps per IP hash
@@ -118,4 +533,26 @@ const (
 
 	// NamespacePurge stores the last purge timestamp
 	NamespacePurge = "purge"
+
+	// NamespaceCallback stores creator callback URLs per paste ID
+	NamespaceCallback = "callback"
+
+	// NamespaceAdmin stores state managed through the admin API
+	NamespaceAdmin = "admin"
+
+	// NamespaceIdempotency stores create results per idempotency key hash
+	NamespaceIdempotency = "idempotency"
+
+	// NamespaceTokenUsage stores API token usage per token and month
+	NamespaceTokenUsage = "tokenusage"
+
+	// NamespaceModeration stores comment moderation state and the pending queue
+	NamespaceModeration = "moderation"
+
+	// NamespaceShortLink stores the local URL shortener's codes and, per
+	// paste ID, the code of each paste
+	NamespaceShortLink = "shortlink"
+
+	// NamespacePoW stores answered proof-of-work challenges until they expire
+	NamespacePoW = "pow"
 )
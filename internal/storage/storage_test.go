@@ -0,0 +1,445 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/metrics"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// readAttachment reads attachment index of a paste from s.
+func readAttachment(t *testing.T, s Storage, id string, paste *model.Paste, index int) string {
+	t.Helper()
+	r, err := OpenAttachment(context.Background(), s, id, paste, index)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// checkAttachmentStore stores, reads, and deletes two attachments, one of
+// a couple of chunks, on backend s, and checks DeletePaste removes them.
+func checkAttachmentStore(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	store, ok := Attachments(s)
+	require.True(t, ok)
+
+	pasteID := "abcdef1234567890"
+	attachments := []string{strings.Repeat("0123456789abcdef", attachmentChunkSize/8), "second"}
+	paste := model.NewPaste()
+	paste.Data = "encrypted"
+	paste.SetExpiration(time.Hour)
+	for i, attachment := range attachments {
+		n, err := store.WriteAttachment(ctx, pasteID, i, strings.NewReader(attachment))
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(attachment)), n)
+		paste.Meta.AttachmentSizes = append(paste.Meta.AttachmentSizes, n)
+	}
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+
+	stored, err := s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, paste.Meta.AttachmentSizes, stored.Meta.AttachmentSizes)
+	assert.Empty(t, stored.Attachments)
+	for i, attachment := range attachments {
+		assert.Equal(t, attachment, readAttachment(t, s, pasteID, stored, i))
+	}
+	_, err = OpenAttachment(ctx, s, pasteID, stored, 2)
+	assert.Equal(t, model.ErrPasteNotFound, err)
+
+	// Replacing an attachment leaves nothing of the old one
+	_, err = store.WriteAttachment(ctx, pasteID, 0, strings.NewReader("short"))
+	require.NoError(t, err)
+	assert.Equal(t, "short", readAttachment(t, s, pasteID, stored, 0))
+
+	ids, err := s.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+
+	require.NoError(t, s.DeletePaste(ctx, pasteID))
+	for i := range attachments {
+		_, err = store.OpenAttachment(ctx, pasteID, i)
+		assert.Equal(t, model.ErrPasteNotFound, err)
+	}
+	assert.NoError(t, store.DeleteAttachments(ctx, pasteID))
+}
+
+// checkReadAndDelete checks that of concurrent ReadAndDeletePaste calls on
+// backend s exactly one gets the paste, and that separately stored
+// attachments are left for the caller.
+func checkReadAndDelete(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "secret"
+	paste.Meta.BurnAfterReading = true
+	paste.SetExpiration(time.Hour)
+	store, separate := Attachments(s)
+	if separate {
+		n, err := store.WriteAttachment(ctx, pasteID, 0, strings.NewReader("attached"))
+		require.NoError(t, err)
+		paste.Meta.AttachmentSizes = []int64{n}
+	}
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+	_, err := s.MarkRead(ctx, pasteID)
+	require.NoError(t, err)
+
+	const readers = 8
+	results := make(chan error, readers)
+	var mu sync.Mutex
+	var got []*model.Paste
+	for i := 0; i < readers; i++ {
+		go func() {
+			p, err := s.ReadAndDeletePaste(ctx, pasteID)
+			if err == nil {
+				mu.Lock()
+				got = append(got, p)
+				mu.Unlock()
+			}
+			results <- err
+		}()
+	}
+	for i := 0; i < readers; i++ {
+		if err := <-results; err != nil {
+			assert.Equal(t, model.ErrPasteNotFound, err)
+		}
+	}
+	require.Len(t, got, 1)
+	assert.Equal(t, "secret", got[0].Data)
+	assert.True(t, got[0].IsBurnAfterReading())
+
+	assert.False(t, s.PasteExists(ctx, pasteID))
+	_, err = s.GetReadReceipt(ctx, pasteID)
+	assert.Equal(t, model.ErrPasteNotFound, err)
+	if separate {
+		assert.Equal(t, "attached", readAttachment(t, s, pasteID, got[0], 0))
+		require.NoError(t, store.DeleteAttachments(ctx, pasteID))
+	}
+
+	// Expired pastes are deleted, not returned
+	paste.SetExpiration(0)
+	paste.Meta.ExpireDate = time.Now().Add(-time.Hour).Unix()
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+	_, err = s.ReadAndDeletePaste(ctx, pasteID)
+	assert.Equal(t, model.ErrPasteExpired, err)
+	assert.False(t, s.PasteExists(ctx, pasteID))
+}
+
+// checkCountRead checks that concurrent CountRead calls on backend s get a
+// paste limited to N reads exactly N times, each with a different count,
+// and that the last deletes it.
+func checkCountRead(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	pasteID := "abcdef1234567891"
+	paste := model.NewPaste()
+	paste.Data = "secret"
+	paste.Meta.MaxReads = 3
+	paste.Meta.ReadsLeft = 3
+	paste.SetExpiration(time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+
+	const readers = 8
+	results := make(chan error, readers)
+	var mu sync.Mutex
+	var left []int
+	for i := 0; i < readers; i++ {
+		go func() {
+			p, err := s.CountRead(ctx, pasteID)
+			if err == nil {
+				mu.Lock()
+				left = append(left, p.Meta.ReadsLeft)
+				mu.Unlock()
+				assert.Equal(t, "secret", p.Data)
+				assert.Equal(t, 3, p.Meta.MaxReads)
+			}
+			results <- err
+		}()
+	}
+	for i := 0; i < readers; i++ {
+		if err := <-results; err != nil {
+			assert.Equal(t, model.ErrPasteNotFound, err)
+		}
+	}
+	assert.ElementsMatch(t, []int{2, 1, 0}, left)
+	assert.False(t, s.PasteExists(ctx, pasteID))
+
+	// Pastes without a limit are only read
+	paste.Meta.MaxReads = 0
+	paste.Meta.ReadsLeft = 0
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+	for i := 0; i < 2; i++ {
+		p, err := s.CountRead(ctx, pasteID)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", p.Data)
+	}
+	require.NoError(t, s.DeletePaste(ctx, pasteID))
+}
+
+// checkUpdatePaste checks that UpdatePaste on backend s replaces a paste's
+// content and keeps the rest.
+func checkUpdatePaste(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	pasteID := "abcdef1234567892"
+	paste := model.NewPaste()
+	paste.Data = "old"
+	paste.AData = []byte(`["old"]`)
+	paste.AttachmentNames = model.StringList{"name"}
+	paste.Attachments = model.StringList{"attached"}
+	paste.Meta.OpenDiscussion = true
+	paste.Meta.Salt = "salt"
+	paste.SetExpiration(time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+	require.NoError(t, s.CreateComment(ctx, pasteID, pasteID, "c0ffee1234567890", &model.Comment{Data: "comment"}))
+
+	update := &model.Paste{Data: "new", AData: []byte(`["new"]`), Version: 2}
+	update.Meta.Formatter = model.FormatterMarkdown
+	require.NoError(t, s.UpdatePaste(ctx, pasteID, update))
+
+	stored, err := s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, "new", stored.Data)
+	assert.JSONEq(t, `["new"]`, string(stored.AData))
+	assert.Equal(t, model.FormatterMarkdown, stored.Meta.Formatter)
+	assert.Equal(t, model.StringList{"attached"}, stored.Attachments)
+	assert.Equal(t, model.StringList{"name"}, stored.AttachmentNames)
+	assert.True(t, stored.Meta.OpenDiscussion)
+	assert.Equal(t, "salt", stored.Meta.Salt)
+	assert.Equal(t, paste.Meta.ExpireDate, stored.Meta.ExpireDate)
+	n, err := s.CountComments(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	require.NoError(t, s.DeletePaste(ctx, pasteID))
+	assert.Equal(t, model.ErrPasteNotFound, s.UpdatePaste(ctx, pasteID, update))
+}
+
+// checkDeleteComment checks that DeleteComment on backend s removes one
+// comment, keeps its replies, and updates the comment count.
+func checkDeleteComment(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	pasteID := "abcdef1234567893"
+	paste := model.NewPaste()
+	paste.Data = "paste"
+	paste.Meta.OpenDiscussion = true
+	paste.SetExpiration(time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+	require.NoError(t, s.CreateComment(ctx, pasteID, pasteID, "c0ffee1234567891", &model.Comment{Data: "parent"}))
+	require.NoError(t, s.CreateComment(ctx, pasteID, "c0ffee1234567891", "c0ffee1234567892", &model.Comment{Data: "reply"}))
+
+	require.NoError(t, s.DeleteComment(ctx, pasteID, "c0ffee1234567891"))
+	assert.False(t, s.CommentExists(ctx, pasteID, pasteID, "c0ffee1234567891"))
+	comments, err := s.ReadComments(ctx, pasteID)
+	require.NoError(t, err)
+	require.Len(t, comments, 1)
+	assert.Equal(t, "reply", comments[0].Data)
+	n, err := s.CountComments(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.ErrorIs(t, s.DeleteComment(ctx, pasteID, "c0ffee1234567891"), model.ErrCommentNotFound)
+	assert.ErrorIs(t, s.DeleteComment(ctx, "abcdef1234567894", "c0ffee1234567892"), model.ErrCommentNotFound)
+
+	require.NoError(t, s.DeletePaste(ctx, pasteID))
+}
+
+// checkClock checks that backend s judges expiration and receipt times by
+// the clock it is given rather than the system clock.
+func checkClock(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	c := clock.NewFake(time.Unix(1_700_000_000, 0))
+	SetClock(s, c)
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "secret"
+	paste.SetExpirationFrom(c.Now(), time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+
+	// Long expired by the system clock, but not by c
+	_, err := s.ReadPaste(ctx, pasteID)
+	require.NoError(t, err)
+	expired, err := s.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, expired)
+
+	_, err = s.MarkRead(ctx, pasteID)
+	require.NoError(t, err)
+	receipt, err := s.GetReadReceipt(ctx, pasteID)
+	require.NoError(t, err)
+	assert.Equal(t, c.Now().Unix(), receipt.FirstRead)
+
+	c.Advance(2 * time.Hour)
+	expired, err = s.GetExpiredPastes(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{pasteID}, expired)
+	_, err = s.ReadPaste(ctx, pasteID)
+	assert.Equal(t, model.ErrPasteExpired, err)
+}
+
+// checkIterate checks IterateComments and IteratePastes on backend s over
+// more than a page of each, with fn writing to the store as it goes.
+func checkIterate(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	const n = 150
+
+	pasteID := "abcdef1234567890"
+	paste := model.NewPaste()
+	paste.Data = "discussed"
+	paste.Meta.OpenDiscussion = true
+	paste.SetExpiration(time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, pasteID, paste))
+	for i := 0; i < n; i++ {
+		comment := model.NewComment(pasteID)
+		comment.Data = fmt.Sprintf("comment %d", i)
+		comment.Meta.PostDate = int64(1_700_000_000 + i/3) // Some share a date
+		require.NoError(t, s.CreateComment(ctx, pasteID, pasteID, fmt.Sprintf("%016x", n-i), comment))
+	}
+
+	var dates []int64
+	seen := map[string]bool{}
+	err := s.IterateComments(ctx, pasteID, func(c *model.Comment) error {
+		dates = append(dates, c.Meta.PostDate)
+		seen[c.ID] = true
+		return s.SetValue(ctx, "test", c.ID, c.Data)
+	})
+	require.NoError(t, err)
+	assert.Len(t, seen, n)
+	assert.True(t, sort.SliceIsSorted(dates, func(i, j int) bool { return dates[i] < dates[j] }), "oldest first")
+
+	stop := errors.New("stop")
+	calls := 0
+	err = s.IterateComments(ctx, pasteID, func(*model.Comment) error {
+		calls++
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, calls)
+
+	for i := 1; i < n; i++ {
+		p := model.NewPaste()
+		p.Data = "paste"
+		p.SetExpiration(time.Hour)
+		require.NoError(t, s.CreatePaste(ctx, fmt.Sprintf("%016x", i), p))
+	}
+	expired := model.NewPaste()
+	expired.Meta.ExpireDate = time.Now().Add(-time.Hour).Unix()
+	require.NoError(t, s.CreatePaste(ctx, "ffffffffffffffff", expired))
+
+	ids := map[string]bool{}
+	err = s.IteratePastes(ctx, func(p *model.Paste) error {
+		ids[p.ID] = true
+		if p.ID == pasteID {
+			return nil
+		}
+		assert.Equal(t, "paste", p.Data)
+		return s.DeletePaste(ctx, p.ID)
+	})
+	require.NoError(t, err)
+	assert.Len(t, ids, n)
+	assert.True(t, ids[pasteID])
+	assert.False(t, ids["ffffffffffffffff"], "expired pastes are skipped")
+	assert.False(t, s.PasteExists(ctx, fmt.Sprintf("%016x", 1)))
+}
+
+// checkPasteStats tests PasteStats: expired pastes aren't counted, and
+// only pastes posted since the given time are recent. Backends may count
+// their own encoding in the size, so it is only bounded from below.
+func checkPasteStats(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().Unix()
+
+	old := model.NewPaste()
+	old.Data = strings.Repeat("o", 100)
+	old.Meta.PostDate = now - 2*86400
+	require.NoError(t, s.CreatePaste(ctx, "0000000000000001", old))
+	recent := model.NewPaste()
+	recent.Data = strings.Repeat("r", 200)
+	recent.Meta.PostDate = now - 60
+	recent.SetExpiration(time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, "0000000000000002", recent))
+	expired := model.NewPaste()
+	expired.Data = strings.Repeat("e", 1000)
+	expired.Meta.PostDate = now - 60
+	expired.Meta.ExpireDate = now - 30
+	require.NoError(t, s.CreatePaste(ctx, "0000000000000003", expired))
+
+	stats, err := s.PasteStats(ctx, now-86400)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Pastes)
+	assert.Equal(t, 1, stats.Recent)
+	assert.GreaterOrEqual(t, stats.Bytes, int64(300))
+	assert.Less(t, stats.Bytes, int64(1000), "expired pastes don't count")
+}
+
+func TestMock_ReadAndDeletePaste(t *testing.T) {
+	checkReadAndDelete(t, NewMock())
+}
+
+func TestMock_CountRead(t *testing.T) {
+	checkCountRead(t, NewMock())
+}
+
+func TestMock_UpdatePaste(t *testing.T) {
+	checkUpdatePaste(t, NewMock())
+}
+
+func TestMock_DeleteComment(t *testing.T) {
+	checkDeleteComment(t, NewMock())
+}
+
+func TestMock_Attachments(t *testing.T) {
+	checkAttachmentStore(t, NewMock())
+}
+
+func TestMock_Clock(t *testing.T) {
+	checkClock(t, NewMock())
+}
+
+func TestMock_Iterate(t *testing.T) {
+	checkIterate(t, NewMock())
+}
+
+func TestMock_PasteStats(t *testing.T) {
+	checkPasteStats(t, WithMetrics(NewMock(), "mock"))
+}
+
+func TestSetClock_ThroughMetrics(t *testing.T) {
+	checkClock(t, WithMetrics(NewMock(), "mock"))
+}
+
+func TestAttachments_ThroughMetrics(t *testing.T) {
+	s := WithMetrics(NewMock(), "mock")
+	s.(Instrumenter).Instrument(metrics.NewRegistry())
+	checkAttachmentStore(t, s)
+}
+
+func TestOpenAttachment_Inline(t *testing.T) {
+	ctx := context.Background()
+	paste := model.NewPaste()
+	paste.Attachments = model.StringList{"inline", "second"}
+
+	assert.Equal(t, "second", readAttachment(t, NewMock(), "abcdef1234567890", paste, 1))
+	_, err := OpenAttachment(ctx, NewMock(), "abcdef1234567890", paste, 2)
+	assert.Equal(t, model.ErrPasteNotFound, err)
+}
@@ -0,0 +1,182 @@
+// Package storage provides per-operation latency metrics for any backend.
+// WithMetrics wraps a Storage so every call is timed into
+// flashpaper_storage_operation_duration_seconds, labeled by backend and
+// operation, without the backends knowing about metrics. The histogram is
+// registered through the Instrumenter interface like any backend metric.
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/metrics"
+	"github.com/liskl/flashpaper/internal/model"
+)
+
+// storageLatencyBuckets spans 100µs to about 6.5s.
+var storageLatencyBuckets = metrics.ExponentialBuckets(0.0001, 4, 9)
+
+// timed is a Storage that records the latency of every operation but
+// IterateComments and IteratePastes, whose time goes mostly to the caller.
+type timed struct {
+	Storage
+	backend string
+	latency *metrics.HistogramVec // Set by Instrument; nil records nothing
+}
+
+// WithMetrics returns s with operation latencies recorded under the given
+// backend name, once Instrument has registered them (before serving
+// traffic). Warmup and Drain are passed through.
+func WithMetrics(s Storage, backend string) Storage {
+	return &timed{Storage: s, backend: backend}
+}
+
+// unwrap returns the wrapped backend.
+func (t *timed) unwrap() Storage {
+	return t.Storage
+}
+
+// observe records the time since start for an operation.
+func (t *timed) observe(operation string, start time.Time) {
+	t.latency.Observe(time.Since(start).Seconds(), t.backend, operation)
+}
+
+func (t *timed) CreatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	defer t.observe("create_paste", time.Now())
+	return t.Storage.CreatePaste(ctx, id, paste)
+}
+
+func (t *timed) ReadPaste(ctx context.Context, id string) (*model.Paste, error) {
+	defer t.observe("read_paste", time.Now())
+	return t.Storage.ReadPaste(ctx, id)
+}
+
+func (t *timed) DeletePaste(ctx context.Context, id string) error {
+	defer t.observe("delete_paste", time.Now())
+	return t.Storage.DeletePaste(ctx, id)
+}
+
+func (t *timed) ReadAndDeletePaste(ctx context.Context, id string) (*model.Paste, error) {
+	defer t.observe("read_and_delete_paste", time.Now())
+	return t.Storage.ReadAndDeletePaste(ctx, id)
+}
+
+func (t *timed) CountRead(ctx context.Context, id string) (*model.Paste, error) {
+	defer t.observe("count_read", time.Now())
+	return t.Storage.CountRead(ctx, id)
+}
+
+func (t *timed) PasteExists(ctx context.Context, id string) bool {
+	defer t.observe("paste_exists", time.Now())
+	return t.Storage.PasteExists(ctx, id)
+}
+
+func (t *timed) UpdatePaste(ctx context.Context, id string, paste *model.Paste) error {
+	defer t.observe("update_paste", time.Now())
+	return t.Storage.UpdatePaste(ctx, id, paste)
+}
+
+func (t *timed) SetPinned(ctx context.Context, id string, pinned bool) error {
+	defer t.observe("set_pinned", time.Now())
+	return t.Storage.SetPinned(ctx, id, pinned)
+}
+
+func (t *timed) SetDisabled(ctx context.Context, id string, disabled bool) error {
+	defer t.observe("set_disabled", time.Now())
+	return t.Storage.SetDisabled(ctx, id, disabled)
+}
+
+func (t *timed) SetExpireDate(ctx context.Context, id string, expireDate int64) error {
+	defer t.observe("set_expire_date", time.Now())
+	return t.Storage.SetExpireDate(ctx, id, expireDate)
+}
+
+func (t *timed) CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) error {
+	defer t.observe("create_comment", time.Now())
+	return t.Storage.CreateComment(ctx, pasteID, parentID, commentID, comment)
+}
+
+func (t *timed) CountComments(ctx context.Context, pasteID string) (int, error) {
+	defer t.observe("count_comments", time.Now())
+	return t.Storage.CountComments(ctx, pasteID)
+}
+
+func (t *timed) ReadComments(ctx context.Context, pasteID string) ([]*model.Comment, error) {
+	defer t.observe("read_comments", time.Now())
+	return t.Storage.ReadComments(ctx, pasteID)
+}
+
+func (t *timed) CommentExists(ctx context.Context, pasteID, parentID, commentID string) bool {
+	defer t.observe("comment_exists", time.Now())
+	return t.Storage.CommentExists(ctx, pasteID, parentID, commentID)
+}
+
+func (t *timed) DeleteComment(ctx context.Context, pasteID, commentID string) error {
+	defer t.observe("delete_comment", time.Now())
+	return t.Storage.DeleteComment(ctx, pasteID, commentID)
+}
+
+func (t *timed) MarkRead(ctx context.Context, id string) (bool, error) {
+	defer t.observe("mark_read", time.Now())
+	return t.Storage.MarkRead(ctx, id)
+}
+
+func (t *timed) GetReadReceipt(ctx context.Context, id string) (*model.ReadReceipt, error) {
+	defer t.observe("get_read_receipt", time.Now())
+	return t.Storage.GetReadReceipt(ctx, id)
+}
+
+func (t *timed) SetValue(ctx context.Context, namespace, key, value string) error {
+	defer t.observe("set_value", time.Now())
+	return t.Storage.SetValue(ctx, namespace, key, value)
+}
+
+func (t *timed) GetValue(ctx context.Context, namespace, key string) (string, error) {
+	defer t.observe("get_value", time.Now())
+	return t.Storage.GetValue(ctx, namespace, key)
+}
+
+func (t *timed) GetExpiredPastes(ctx context.Context, batchSize int) ([]string, error) {
+	defer t.observe("get_expired_pastes", time.Now())
+	return t.Storage.GetExpiredPastes(ctx, batchSize)
+}
+
+func (t *timed) PasteStats(ctx context.Context, since int64) (*PasteStats, error) {
+	defer t.observe("paste_stats", time.Now())
+	return t.Storage.PasteStats(ctx, since)
+}
+
+func (t *timed) Purge(ctx context.Context, batchSize int) (int, error) {
+	defer t.observe("purge", time.Now())
+	return t.Storage.Purge(ctx, batchSize)
+}
+
+func (t *timed) PurgeValues(ctx context.Context, namespace string, maxAge int64) error {
+	defer t.observe("purge_values", time.Now())
+	return t.Storage.PurgeValues(ctx, namespace, maxAge)
+}
+
+func (t *timed) Ping(ctx context.Context) error {
+	defer t.observe("ping", time.Now())
+	return t.Storage.Ping(ctx)
+}
+
+// Warmup warms up the wrapped backend.
+func (t *timed) Warmup(ctx context.Context) error {
+	return Warmup(ctx, t.Storage)
+}
+
+// Drain drains the wrapped backend.
+func (t *timed) Drain(ctx context.Context) error {
+	return Drain(ctx, t.Storage)
+}
+
+// Instrument registers the latency histogram and the wrapped backend's
+// own metrics.
+func (t *timed) Instrument(r *metrics.Registry) {
+	t.latency = r.HistogramVec("flashpaper_storage_operation_duration_seconds",
+		"Latency of storage backend operations in seconds.", storageLatencyBuckets, "backend", "operation")
+	if i, ok := find[Instrumenter](t.Storage); ok {
+		i.Instrument(r)
+	}
+}
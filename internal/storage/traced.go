@@ -0,0 +1,188 @@
+// Package storage provides tracing of storage operations for any backend.
+// WithTracing wraps a Storage so every call gets a span under the request
+// that made it, named after the operation, and shows up in the request's
+// Server-Timing header. Spans carry the backend name but not paste IDs.
+package storage
+
+import (
+	"context"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/tracing"
+)
+
+// traced is a Storage that starts a span for every operation but
+// IterateComments and IteratePastes, like timed.
+type traced struct {
+	Storage
+	tracer  *tracing.Tracer // Nil times operations for Server-Timing only
+	backend string
+}
+
+// WithTracing returns s with its operations traced by tracer under the
+// given backend name. Warmup and Drain are passed through.
+func WithTracing(s Storage, tracer *tracing.Tracer, backend string) Storage {
+	return &traced{Storage: s, tracer: tracer, backend: backend}
+}
+
+// unwrap returns the wrapped backend.
+func (t *traced) unwrap() Storage {
+	return t.Storage
+}
+
+// start starts the span of an operation.
+func (t *traced) start(ctx context.Context, operation string) (context.Context, *tracing.Span) {
+	ctx, span := t.tracer.Start(ctx, "storage."+operation, tracing.KindInternal)
+	span.SetAttr("flashpaper.storage.backend", t.backend)
+	return ctx, span
+}
+
+// finish ends span, marking it failed if the operation returned an error.
+func finish(span *tracing.Span, err *error) {
+	span.SetError(*err)
+	span.End()
+}
+
+func (t *traced) CreatePaste(ctx context.Context, id string, paste *model.Paste) (err error) {
+	ctx, span := t.start(ctx, "create_paste")
+	defer finish(span, &err)
+	return t.Storage.CreatePaste(ctx, id, paste)
+}
+
+func (t *traced) ReadPaste(ctx context.Context, id string) (paste *model.Paste, err error) {
+	ctx, span := t.start(ctx, "read_paste")
+	defer finish(span, &err)
+	return t.Storage.ReadPaste(ctx, id)
+}
+
+func (t *traced) DeletePaste(ctx context.Context, id string) (err error) {
+	ctx, span := t.start(ctx, "delete_paste")
+	defer finish(span, &err)
+	return t.Storage.DeletePaste(ctx, id)
+}
+
+func (t *traced) ReadAndDeletePaste(ctx context.Context, id string) (paste *model.Paste, err error) {
+	ctx, span := t.start(ctx, "read_and_delete_paste")
+	defer finish(span, &err)
+	return t.Storage.ReadAndDeletePaste(ctx, id)
+}
+
+func (t *traced) CountRead(ctx context.Context, id string) (paste *model.Paste, err error) {
+	ctx, span := t.start(ctx, "count_read")
+	defer finish(span, &err)
+	return t.Storage.CountRead(ctx, id)
+}
+
+func (t *traced) PasteExists(ctx context.Context, id string) bool {
+	ctx, span := t.start(ctx, "paste_exists")
+	defer span.End()
+	return t.Storage.PasteExists(ctx, id)
+}
+
+func (t *traced) UpdatePaste(ctx context.Context, id string, paste *model.Paste) (err error) {
+	ctx, span := t.start(ctx, "update_paste")
+	defer finish(span, &err)
+	return t.Storage.UpdatePaste(ctx, id, paste)
+}
+
+func (t *traced) SetPinned(ctx context.Context, id string, pinned bool) (err error) {
+	ctx, span := t.start(ctx, "set_pinned")
+	defer finish(span, &err)
+	return t.Storage.SetPinned(ctx, id, pinned)
+}
+
+func (t *traced) SetDisabled(ctx context.Context, id string, disabled bool) (err error) {
+	ctx, span := t.start(ctx, "set_disabled")
+	defer finish(span, &err)
+	return t.Storage.SetDisabled(ctx, id, disabled)
+}
+
+func (t *traced) SetExpireDate(ctx context.Context, id string, expireDate int64) (err error) {
+	ctx, span := t.start(ctx, "set_expire_date")
+	defer finish(span, &err)
+	return t.Storage.SetExpireDate(ctx, id, expireDate)
+}
+
+func (t *traced) CreateComment(ctx context.Context, pasteID, parentID, commentID string, comment *model.Comment) (err error) {
+	ctx, span := t.start(ctx, "create_comment")
+	defer finish(span, &err)
+	return t.Storage.CreateComment(ctx, pasteID, parentID, commentID, comment)
+}
+
+func (t *traced) CountComments(ctx context.Context, pasteID string) (n int, err error) {
+	ctx, span := t.start(ctx, "count_comments")
+	defer finish(span, &err)
+	return t.Storage.CountComments(ctx, pasteID)
+}
+
+func (t *traced) ReadComments(ctx context.Context, pasteID string) (comments []*model.Comment, err error) {
+	ctx, span := t.start(ctx, "read_comments")
+	defer finish(span, &err)
+	return t.Storage.ReadComments(ctx, pasteID)
+}
+
+func (t *traced) CommentExists(ctx context.Context, pasteID, parentID, commentID string) bool {
+	ctx, span := t.start(ctx, "comment_exists")
+	defer span.End()
+	return t.Storage.CommentExists(ctx, pasteID, parentID, commentID)
+}
+
+func (t *traced) DeleteComment(ctx context.Context, pasteID, commentID string) (err error) {
+	ctx, span := t.start(ctx, "delete_comment")
+	defer finish(span, &err)
+	return t.Storage.DeleteComment(ctx, pasteID, commentID)
+}
+
+func (t *traced) MarkRead(ctx context.Context, id string) (ok bool, err error) {
+	ctx, span := t.start(ctx, "mark_read")
+	defer finish(span, &err)
+	return t.Storage.MarkRead(ctx, id)
+}
+
+func (t *traced) GetReadReceipt(ctx context.Context, id string) (receipt *model.ReadReceipt, err error) {
+	ctx, span := t.start(ctx, "get_read_receipt")
+	defer finish(span, &err)
+	return t.Storage.GetReadReceipt(ctx, id)
+}
+
+func (t *traced) SetValue(ctx context.Context, namespace, key, value string) (err error) {
+	ctx, span := t.start(ctx, "set_value")
+	defer finish(span, &err)
+	return t.Storage.SetValue(ctx, namespace, key, value)
+}
+
+func (t *traced) GetValue(ctx context.Context, namespace, key string) (value string, err error) {
+	ctx, span := t.start(ctx, "get_value")
+	defer finish(span, &err)
+	return t.Storage.GetValue(ctx, namespace, key)
+}
+
+func (t *traced) GetExpiredPastes(ctx context.Context, batchSize int) (ids []string, err error) {
+	ctx, span := t.start(ctx, "get_expired_pastes")
+	defer finish(span, &err)
+	return t.Storage.GetExpiredPastes(ctx, batchSize)
+}
+
+func (t *traced) PasteStats(ctx context.Context, since int64) (stats *PasteStats, err error) {
+	ctx, span := t.start(ctx, "paste_stats")
+	defer finish(span, &err)
+	return t.Storage.PasteStats(ctx, since)
+}
+
+func (t *traced) Purge(ctx context.Context, batchSize int) (n int, err error) {
+	ctx, span := t.start(ctx, "purge")
+	defer finish(span, &err)
+	return t.Storage.Purge(ctx, batchSize)
+}
+
+func (t *traced) PurgeValues(ctx context.Context, namespace string, maxAge int64) (err error) {
+	ctx, span := t.start(ctx, "purge_values")
+	defer finish(span, &err)
+	return t.Storage.PurgeValues(ctx, namespace, maxAge)
+}
+
+func (t *traced) Ping(ctx context.Context) (err error) {
+	ctx, span := t.start(ctx, "ping")
+	defer finish(span, &err)
+	return t.Storage.Ping(ctx)
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/tracing"
+)
+
+func TestTraced_ServerTiming(t *testing.T) {
+	ctx, timings := tracing.WithTimings(context.Background())
+	mock := NewMock()
+	s := WithTracing(mock, nil, "mock")
+
+	paste := model.NewPaste()
+	paste.Data = "ciphertext"
+	paste.SetExpiration(time.Hour)
+	require.NoError(t, s.CreatePaste(ctx, "abcdef1234567890", paste))
+	_, err := s.ReadPaste(ctx, "abcdef1234567890")
+	require.NoError(t, err)
+	_, err = s.ReadPaste(ctx, "0000000000000000")
+	assert.ErrorIs(t, err, model.ErrPasteNotFound)
+
+	// Repeated operations are summed, in the order they were first made
+	assert.Regexp(t, regexp.MustCompile(`^storage\.create_paste;dur=[0-9.]+, storage\.read_paste;dur=[0-9.]+, total;dur=[0-9.]+$`),
+		timings.Header())
+
+	// The backend is reached through both wrappers
+	assert.Same(t, mock, Unwrap(WithMetrics(s, "mock")))
+}
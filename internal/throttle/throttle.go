@@ -0,0 +1,125 @@
+// Package throttle limits the bandwidth of response bodies.
+// Encrypted attachments can be tens of megabytes, and a single client pulling
+// one at full speed can saturate a small server's uplink. A Limiter is a token
+// bucket measured in bytes; a Writer draws from one or more limiters (for
+// example one per connection and one shared by all downloads) before each
+// write, so every limit holds at once.
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// chunkSize caps how much a Writer sends between waits, so concurrent
+// downloads sharing a limiter interleave instead of taking turns.
+const chunkSize = 16 * 1024
+
+// Limiter is a token bucket that refills at a fixed number of bytes per second.
+// A nil Limiter never waits. Limiters are safe for concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64   // Bytes added per second
+	burst  float64   // Bucket capacity in bytes
+	tokens float64   // Available bytes; negative when writers are queued
+	last   time.Time // Last refill
+}
+
+// NewLimiter returns a limiter allowing bytesPerSecond, or nil if the rate is
+// not positive. The bucket holds one second's worth of bytes and starts full,
+// so short responses are never delayed.
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSecond)
+	return &Limiter{
+		rate:   rate,
+		burst:  rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes may be sent, or ctx is done.
+// Each call reserves its bytes immediately, so concurrent callers are served
+// in arrival order rather than racing for a refill.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give the reservation back so other writers aren't penalized
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Writer wraps w so that every write waits on all the given limiters.
+// Nil limiters are skipped; with none left, w is returned unchanged.
+// Writes fail with ctx's error once ctx is done (e.g. the client went away).
+func Writer(ctx context.Context, w io.Writer, limiters ...*Limiter) io.Writer {
+	active := make([]*Limiter, 0, len(limiters))
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+	if len(active) == 0 {
+		return w
+	}
+	return &writer{ctx: ctx, w: w, limiters: active}
+}
+
+// writer is the io.Writer returned by Writer.
+type writer struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+}
+
+// Write sends p in chunks, waiting on every limiter before each chunk.
+func (t *writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), chunkSize)]
+		for _, l := range t.limiters {
+			if err := l.WaitN(t.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,78 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLimiter_Disabled(t *testing.T) {
+	assert.Nil(t, NewLimiter(0))
+	assert.Nil(t, NewLimiter(-1))
+
+	var l *Limiter
+	assert.NoError(t, l.WaitN(context.Background(), 1<<20))
+}
+
+func TestWriter_NoLimitersPassesThrough(t *testing.T) {
+	var buf bytes.Buffer
+	assert.Same(t, &buf, Writer(context.Background(), &buf, nil, nil))
+}
+
+func TestWriter_LimitsRate(t *testing.T) {
+	// The bucket starts with one second's worth, so 1.5x the rate takes
+	// about half a second
+	const rate = 200 * 1024
+	data := bytes.Repeat([]byte("x"), rate*3/2)
+
+	var buf bytes.Buffer
+	w := Writer(context.Background(), &buf, NewLimiter(rate))
+
+	start := time.Now()
+	n, err := w.Write(data)
+	elapsed := time.Since(start)
+
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, data, buf.Bytes())
+	assert.GreaterOrEqual(t, elapsed, 400*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestWriter_SharedLimiter(t *testing.T) {
+	// Two writers sharing a global limiter split its rate
+	const rate = 200 * 1024
+	global := NewLimiter(rate)
+	data := bytes.Repeat([]byte("x"), rate*3/4)
+
+	start := time.Now()
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			var buf bytes.Buffer
+			Writer(context.Background(), &buf, NewLimiter(10*rate), global).Write(data)
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestWriter_ContextCanceled(t *testing.T) {
+	const rate = 16 * 1024
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var buf bytes.Buffer
+	w := Writer(ctx, &buf, NewLimiter(rate))
+
+	n, err := w.Write(bytes.Repeat([]byte("x"), rate*10))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, n, rate*10)
+}
@@ -0,0 +1,272 @@
+// Package tracing provides the OTLP/HTTP exporter. Ended spans are queued
+// and POSTed in batches, as OTLP's JSON encoding, to the collector's
+// /v1/traces by a single background goroutine, so requests never wait on
+// the collector. When the queue is full, spans are dropped and counted;
+// failed exports are logged and not retried, as traces are for looking at
+// now rather than keeping.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/version"
+)
+
+// Export batching.
+const (
+	exportQueue    = 4096            // Spans waiting for export
+	exportBatch    = 512             // Most spans in one request
+	exportInterval = 5 * time.Second // Longest a span waits for its batch
+	exportTimeout  = 10 * time.Second
+)
+
+// scopeName identifies FlashPaper's instrumentation to collectors.
+const scopeName = "github.com/liskl/flashpaper"
+
+// OTLP status codes.
+const statusError = 2
+
+// exporter sends ended spans to a collector.
+type exporter struct {
+	url      string
+	headers  http.Header
+	resource []otlpAttr
+	client   *http.Client
+	interval time.Duration
+
+	mu      sync.RWMutex // Guards closed against sends on a closed queue
+	closed  bool
+	queue   chan *Span
+	done    chan struct{} // Closed once the last batch is sent
+	dropped atomic.Int64  // Spans dropped since the last export
+}
+
+// newExporter creates an exporter for cfg's collector and starts it.
+func newExporter(cfg config.TelemetryConfig) *exporter {
+	e := &exporter{
+		url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		headers: make(http.Header),
+		resource: []otlpAttr{
+			attr("service.name", cfg.ServiceName),
+			attr("service.version", version.Version),
+		},
+		client:   &http.Client{Timeout: exportTimeout},
+		interval: exportInterval,
+		queue:    make(chan *Span, exportQueue),
+		done:     make(chan struct{}),
+	}
+	for _, header := range cfg.Headers {
+		name, value, _ := strings.Cut(header, "=") // Validate checked the form
+		e.headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	go e.run()
+	return e
+}
+
+// enqueue queues s for export without blocking.
+func (e *exporter) enqueue(s *Span) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// close stops accepting spans and waits for the queued ones to be sent,
+// or for ctx to be done.
+func (e *exporter) close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("exporting spans: %w", ctx.Err())
+	}
+}
+
+// run sends full batches right away and others every interval, until the
+// queue is closed and empty.
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case s, ok := <-e.queue:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) < exportBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+		e.send(batch)
+		batch = nil
+	}
+}
+
+// send exports batch, logging rather than returning failures.
+func (e *exporter) send(batch []*Span) {
+	if dropped := e.dropped.Swap(0); dropped > 0 {
+		slog.Warn("Trace export queue full; spans dropped", "spans", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err := e.post(batch); err != nil {
+		slog.Warn("Trace export failed", "url", e.url, "spans", len(batch), "error", err)
+	}
+}
+
+// post sends batch to the collector once.
+func (e *exporter) post(batch []*Span) error {
+	body, err := json.Marshal(e.request(batch))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FlashPaper/"+version.Version)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+// request encodes batch as an OTLP ExportTraceServiceRequest.
+func (e *exporter) request(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = otlpSpan{
+			TraceID:           hex.EncodeToString(s.trace[:]),
+			SpanID:            hex.EncodeToString(s.id[:]),
+			Name:              s.name,
+			Kind:              int(s.kind),
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        s.attrs,
+		}
+		if s.parent != [8]byte{} {
+			spans[i].ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			spans[i].Status = otlpStatus{Code: statusError, Message: s.err}
+		}
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: e.resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: scopeName, Version: version.Version},
+			Spans: spans,
+		}},
+	}}}
+}
+
+// OTLP's JSON encoding of trace exports. IDs are hex, and 64-bit integers
+// are decimal strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []otlpAttr `json:"attributes,omitempty"`
+		Status            otlpStatus `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    string  `json:"intValue,omitempty"`
+		BoolValue   *bool   `json:"boolValue,omitempty"`
+	}
+)
+
+// attr encodes an attribute, keeping the type of strings, integers, and
+// booleans and formatting anything else as text.
+func attr(key string, value any) otlpAttr {
+	a := otlpAttr{Key: key}
+	switch v := value.(type) {
+	case string:
+		a.Value.StringValue = &v
+	case int:
+		a.Value.IntValue = strconv.Itoa(v)
+	case int64:
+		a.Value.IntValue = strconv.FormatInt(v, 10)
+	case bool:
+		a.Value.BoolValue = &v
+	default:
+		s := fmt.Sprint(v)
+		a.Value.StringValue = &s
+	}
+	return a
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// collect starts a collector and returns the config exporting to it and
+// a channel of the requests it gets.
+func collect(t *testing.T) (config.TelemetryConfig, <-chan *http.Request, <-chan otlpRequest) {
+	t.Helper()
+	requests := make(chan *http.Request, 8)
+	bodies := make(chan otlpRequest, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Errorf("collector got invalid JSON: %v", err)
+		}
+		requests <- r
+		bodies <- req
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := config.DefaultConfig().Telemetry
+	cfg.Enabled = true
+	cfg.Endpoint = srv.URL + "/"
+	cfg.Headers = []string{"x-api-key = secret"}
+	return cfg, requests, bodies
+}
+
+func TestExport(t *testing.T) {
+	cfg, requests, bodies := collect(t)
+	tracer := New(cfg)
+
+	ctx, server := tracer.Start(context.Background(), "GET /", KindServer)
+	server.SetAttr("http.response.status_code", 500)
+	server.SetAttr("http.route", "/")
+	server.SetError(errors.New("Internal Server Error"))
+	_, child := tracer.Start(ctx, "storage.read_paste", KindInternal)
+	child.SetAttr("flashpaper.storage.backend", "filesystem")
+	child.End()
+	server.End()
+	require.NoError(t, tracer.Shutdown(context.Background()))
+
+	req := <-requests
+	assert.Equal(t, "/v1/traces", req.URL.Path)
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "secret", req.Header.Get("X-Api-Key"))
+
+	body := <-bodies
+	require.Len(t, body.ResourceSpans, 1)
+	rs := body.ResourceSpans[0]
+	require.NotEmpty(t, rs.Resource.Attributes)
+	assert.Equal(t, "service.name", rs.Resource.Attributes[0].Key)
+	assert.Equal(t, "flashpaper", *rs.Resource.Attributes[0].Value.StringValue)
+
+	require.Len(t, rs.ScopeSpans, 1)
+	spans := rs.ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	read, get := spans[0], spans[1]
+	assert.Equal(t, "storage.read_paste", read.Name)
+	assert.Equal(t, int(KindInternal), read.Kind)
+	assert.Equal(t, get.SpanID, read.ParentSpanID)
+	assert.Equal(t, get.TraceID, read.TraceID)
+	assert.Len(t, get.TraceID, 32)
+	assert.Len(t, get.SpanID, 16)
+	assert.Empty(t, get.ParentSpanID)
+	assert.LessOrEqual(t, get.StartTimeUnixNano, read.StartTimeUnixNano)
+
+	assert.Equal(t, "GET /", get.Name)
+	assert.Equal(t, int(KindServer), get.Kind)
+	assert.Equal(t, statusError, get.Status.Code)
+	assert.Equal(t, "500", get.Attributes[0].Value.IntValue)
+	assert.Equal(t, "/", *get.Attributes[1].Value.StringValue)
+
+	// Nothing is exported once shut down
+	_, late := tracer.Start(context.Background(), "GET /", KindServer)
+	late.End()
+	assert.Len(t, requests, 0)
+}
+
+func TestExport_CollectorDown(t *testing.T) {
+	cfg := config.DefaultConfig().Telemetry
+	cfg.Enabled = true
+	cfg.Endpoint = "http://127.0.0.1:1"
+	tracer := New(cfg)
+
+	// Failed exports are logged, and don't hold up shutdown
+	_, span := tracer.Start(context.Background(), "GET /", KindServer)
+	span.End()
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+}
+
+func TestExport_QueueFull(t *testing.T) {
+	e := &exporter{queue: make(chan *Span, 1), done: make(chan struct{})}
+	e.enqueue(&Span{})
+	e.enqueue(&Span{})
+	assert.Equal(t, int64(1), e.dropped.Load())
+}
+
+func TestNew_Disabled(t *testing.T) {
+	assert.Nil(t, New(config.DefaultConfig().Telemetry))
+}
@@ -0,0 +1,92 @@
+// Package tracing provides Server-Timing headers. Browsers show them in
+// their developer tools next to the request, which answers "was that slow
+// because of storage?" without a collector: every span ended while the
+// request is handled adds its duration under its name, and the header
+// sums them up with the total time before the response began.
+package tracing
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimingHeader is the response header timings are reported in.
+const TimingHeader = "Server-Timing"
+
+// Timings collects span durations for one request's Server-Timing header.
+// A nil *Timings collects nothing. It is safe for concurrent use, as
+// handlers may start spans from several goroutines.
+type Timings struct {
+	start time.Time
+
+	mu      sync.Mutex
+	entries []timing // In order of first appearance
+}
+
+// timing is the total duration of the spans of one name.
+type timing struct {
+	name  string
+	total time.Duration
+}
+
+// timingsKey is the context key of a request's Timings.
+type timingsKey struct{}
+
+// WithTimings returns ctx with new Timings that spans started from it
+// report to, and those Timings.
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{start: time.Now()}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// timingsFrom returns the Timings of ctx, or nil.
+func timingsFrom(ctx context.Context) *Timings {
+	t, _ := ctx.Value(timingsKey{}).(*Timings)
+	return t
+}
+
+// add adds d to the total of name.
+func (t *Timings) add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.entries {
+		if t.entries[i].name == name {
+			t.entries[i].total += d
+			return
+		}
+	}
+	t.entries = append(t.entries, timing{name: name, total: d})
+}
+
+// Header returns the Server-Timing header value for the spans ended so
+// far, ending with the time since WithTimings as "total":
+//
+//	storage.read_paste;dur=0.84, total;dur=2.13
+func (t *Timings) Header() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var b strings.Builder
+	for _, e := range t.entries {
+		writeTiming(&b, e.name, e.total)
+		b.WriteString(", ")
+	}
+	writeTiming(&b, "total", time.Since(t.start))
+	return b.String()
+}
+
+// writeTiming writes one metric with its duration in milliseconds.
+// Names are span names, which are tokens already.
+func writeTiming(b *strings.Builder, name string, d time.Duration) {
+	b.WriteString(name)
+	b.WriteString(";dur=")
+	b.WriteString(strconv.FormatFloat(float64(d.Microseconds())/1000, 'f', -1, 64))
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimings_Header(t *testing.T) {
+	ctx, timings := WithTimings(context.Background())
+	timings.add("storage.read_paste", 1500*time.Microsecond)
+	timings.add("storage.count_comments", 250*time.Microsecond)
+	timings.add("storage.read_paste", 500*time.Microsecond)
+
+	// Server spans are the total, not an entry of their own
+	_, server := (*Tracer)(nil).Start(ctx, "GET", KindServer)
+	server.End()
+
+	header := timings.Header()
+	assert.Regexp(t, regexp.MustCompile(`^storage\.read_paste;dur=2, storage\.count_comments;dur=0\.25, total;dur=[0-9.]+$`), header)
+}
+
+func TestTimings_Concurrent(t *testing.T) {
+	ctx, timings := WithTimings(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, span := (*Tracer)(nil).Start(ctx, "storage.get_value", KindInternal)
+			span.End()
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, timings.entries, 1)
+}
+
+func TestTimings_Nil(t *testing.T) {
+	var timings *Timings
+	timings.add("storage.read_paste", time.Millisecond)
+	assert.Empty(t, timings.Header())
+}
@@ -0,0 +1,221 @@
+// Package tracing records where FlashPaper spends its time: a span for
+// every request and for every storage operation it makes, exported to an
+// OpenTelemetry collector over OTLP/HTTP (see otlp.go), and optionally a
+// Server-Timing header on each response (see timing.go).
+//
+// Traces continue those of callers that send a W3C traceparent header, so
+// a request shows up under the proxy or client that made it. Spans carry
+// methods, route patterns, status codes, and storage operation names;
+// never paste IDs, content, or client addresses.
+//
+// A nil *Tracer is valid and exports nothing; its spans still feed
+// Server-Timing when the request asks for it.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// TraceparentHeader carries the caller's trace context (W3C Trace Context).
+const TraceparentHeader = "traceparent"
+
+// SpanKind tells collectors what side of an operation a span is on.
+// The values are OTLP's.
+type SpanKind int
+
+// Span kinds.
+const (
+	KindInternal SpanKind = 1 // Work within the process, such as storage
+	KindServer   SpanKind = 2 // An incoming request
+	KindClient   SpanKind = 3 // An outgoing request
+)
+
+// spanContext identifies a span within its trace.
+type spanContext struct {
+	trace   [16]byte
+	id      [8]byte
+	sampled bool
+}
+
+// Tracer starts spans and exports the sampled ones.
+type Tracer struct {
+	exporter *exporter
+	sample   int // Percentage of new traces sampled
+}
+
+// New creates a Tracer exporting to cfg's collector and starts its
+// exporter. Returns nil when telemetry isn't enabled.
+func New(cfg config.TelemetryConfig) *Tracer {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Tracer{
+		exporter: newExporter(cfg),
+		sample:   cfg.Sample,
+	}
+}
+
+// Shutdown stops accepting spans and exports the ones still queued,
+// giving up when ctx is done.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.close(ctx)
+}
+
+// sampled decides whether a new trace is recorded. The decision is derived
+// from the trace ID, so every service seeing the trace can make the same one.
+func (t *Tracer) sampled(trace [16]byte) bool {
+	if t.sample >= 100 {
+		return true
+	}
+	// The low 8 bytes of a trace ID are random; compare 63 bits of them
+	r := binary.BigEndian.Uint64(trace[8:]) >> 1
+	return r < uint64(t.sample)*(uint64(1)<<63/100)
+}
+
+// Span is an operation being timed. Spans are used by one goroutine at a
+// time, and a nil *Span ignores every call.
+type Span struct {
+	spanContext
+	parent  [8]byte // Zero for the root of a trace
+	tracer  *Tracer // Nil if the span only feeds Server-Timing
+	timings *Timings
+	name    string
+	kind    SpanKind
+	start   time.Time
+	end     time.Time
+	attrs   []otlpAttr
+	err     string
+}
+
+// spanKey is the context key of the current span.
+type spanKey struct{}
+
+// remoteKey is the context key of a caller's span, from traceparent.
+type remoteKey struct{}
+
+// Start starts a span named name as a child of the span in ctx, or of the
+// caller's span Extract found, or as the root of a new trace. It returns a
+// context carrying the new span. Start returns a nil span when there is
+// neither a tracer nor a Server-Timing header to report to.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	timings := timingsFrom(ctx)
+	if t == nil && timings == nil {
+		return ctx, nil
+	}
+
+	s := &Span{tracer: t, timings: timings, name: name, kind: kind, start: time.Now()}
+	if t != nil {
+		if parent := FromContext(ctx); parent != nil && parent.tracer != nil {
+			s.trace, s.parent, s.sampled = parent.trace, parent.id, parent.sampled
+		} else if remote, ok := ctx.Value(remoteKey{}).(spanContext); ok {
+			s.trace, s.parent, s.sampled = remote.trace, remote.id, remote.sampled
+		} else {
+			_, _ = rand.Read(s.trace[:])
+			s.sampled = t.sampled(s.trace)
+		}
+		_, _ = rand.Read(s.id[:])
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the current span of ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Extract returns ctx with the caller's span from a traceparent header
+// value, which spans started without a parent in ctx continue. Malformed
+// headers are ignored.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	sc, ok := parseTraceparent(traceparent)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// parseTraceparent parses "00-<trace>-<span>-<flags>". Later versions may
+// append fields, which are ignored.
+func parseTraceparent(h string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.trace[:], []byte(parts[1])); err != nil || sc.trace == [16]byte{} {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.id[:], []byte(parts[2])); err != nil || sc.id == [8]byte{} {
+		return sc, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return sc, false
+	}
+	sc.sampled = flags&1 == 1
+	return sc, true
+}
+
+// SetName renames the span, for servers that learn the route only once
+// the request has been routed.
+func (s *Span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+// SetAttr records an attribute of the operation. Strings, integers, and
+// booleans keep their type; anything else is recorded as text.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil || s.tracer == nil {
+		return
+	}
+	s.attrs = append(s.attrs, attr(key, value))
+}
+
+// SetError marks the span as failed with err.
+func (s *Span) SetError(err error) {
+	if s != nil && err != nil {
+		s.err = err.Error()
+	}
+}
+
+// End finishes the span, adds its duration to the request's Server-Timing
+// (server spans are its total), and queues it for export if its trace is
+// sampled.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	if s.kind != KindServer {
+		s.timings.add(s.name, s.end.Sub(s.start))
+	}
+	if s.tracer != nil && s.sampled {
+		s.tracer.exporter.enqueue(s)
+	}
+}
+
+// TraceID returns the span's trace ID in hex, or "" if the span isn't
+// exported, for correlating logs with traces.
+func (s *Span) TraceID() string {
+	if s == nil || s.tracer == nil || !s.sampled {
+		return ""
+	}
+	return hex.EncodeToString(s.trace[:])
+}
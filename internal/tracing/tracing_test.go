@@ -0,0 +1,126 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// callerTrace is a caller's traceparent, from the W3C specification.
+const callerTrace = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// testTracer returns a Tracer sampling sample percent of new traces with
+// an exporter that is never started, so ended spans stay in its queue.
+func testTracer(sample int) *Tracer {
+	return &Tracer{
+		exporter: &exporter{queue: make(chan *Span, 16), done: make(chan struct{})},
+		sample:   sample,
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := parseTraceparent(callerTrace)
+	require.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(sc.trace[:]))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(sc.id[:]))
+	assert.True(t, sc.sampled)
+
+	sc, ok = parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	require.True(t, ok)
+	assert.False(t, sc.sampled)
+
+	// Later versions may add fields
+	_, ok = parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	assert.True(t, ok)
+
+	for _, bad := range []string{
+		"",
+		"garbage",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",   // Invalid version
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x", // Extra field in version 00
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",   // Zero trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",   // Zero span ID
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",    // Short trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",   // Not hex
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",   // Bad flags
+	} {
+		_, ok := parseTraceparent(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestStart_Parents(t *testing.T) {
+	tracer := testTracer(100)
+
+	// A request continues its caller's trace
+	ctx := Extract(context.Background(), callerTrace)
+	ctx, server := tracer.Start(ctx, "GET", KindServer)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceID())
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(server.parent[:]))
+	assert.Same(t, server, FromContext(ctx))
+
+	// Its storage operations are its children
+	_, child := tracer.Start(ctx, "storage.read_paste", KindInternal)
+	assert.Equal(t, server.trace, child.trace)
+	assert.Equal(t, server.id, child.parent)
+	assert.NotEqual(t, server.id, child.id)
+	child.End()
+	server.End()
+	assert.Len(t, tracer.exporter.queue, 2)
+
+	// Without a caller, a span starts a new trace
+	_, root := tracer.Start(context.Background(), "storage.purge", KindInternal)
+	assert.Equal(t, [8]byte{}, root.parent)
+	assert.NotEqual(t, server.trace, root.trace)
+}
+
+func TestStart_Sampling(t *testing.T) {
+	// Unsampled traces aren't exported, nor logged with a trace ID
+	tracer := testTracer(0)
+	_, span := tracer.Start(context.Background(), "GET", KindServer)
+	span.End()
+	assert.Empty(t, span.TraceID())
+	assert.Len(t, tracer.exporter.queue, 0)
+
+	// The caller's decision wins over the sampling rate
+	ctx := Extract(context.Background(), callerTrace)
+	_, span = tracer.Start(ctx, "GET", KindServer)
+	span.End()
+	assert.Len(t, tracer.exporter.queue, 1)
+
+	// Rates in between sample about that share of traces
+	tracer = testTracer(25)
+	sampled := 0
+	for i := 0; i < 2000; i++ {
+		_, span := tracer.Start(context.Background(), "GET", KindServer)
+		if span.sampled {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 500, sampled, 100)
+}
+
+func TestStart_NilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	// Without Server-Timing there is nothing to record
+	ctx, span := tracer.Start(context.Background(), "storage.read_paste", KindInternal)
+	assert.Nil(t, span)
+	assert.Nil(t, FromContext(ctx))
+	span.SetAttr("key", "value")
+	span.End()
+	assert.NoError(t, tracer.Shutdown(context.Background()))
+
+	// With it, spans are timed but not traced
+	ctx, timings := WithTimings(context.Background())
+	_, span = tracer.Start(ctx, "storage.read_paste", KindInternal)
+	require.NotNil(t, span)
+	span.SetAttr("key", "value")
+	span.End()
+	assert.Empty(t, span.TraceID())
+	assert.Empty(t, span.attrs)
+	assert.Contains(t, timings.Header(), "storage.read_paste;dur=")
+}
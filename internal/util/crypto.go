@@ -8,6 +8,8 @@
 package util
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -15,7 +17,11 @@ import (
 	"crypto/subtle"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // SaltSize is the number of random bytes in a server salt.
@@ -71,6 +77,23 @@ func ValidateDeleteToken(providedToken, pasteID, salt string) bool {
 	return subtle.ConstantTimeCompare([]byte(providedToken), []byte(expectedToken)) == 1
 }
 
+// HashAccessProof returns the stored form of a paste's access proof: the
+// proof itself would let anyone reading the storage pass the check.
+// Binding it to the paste ID keeps equal proofs on different pastes apart.
+//
+// Format: hex(HMAC-SHA256(pasteID, proof))
+func HashAccessProof(pasteID, proof string) string {
+	h := hmac.New(sha256.New, []byte(proof))
+	h.Write([]byte(pasteID))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ValidateAccessProof reports whether proof matches the hash stored for a
+// paste, in constant time.
+func ValidateAccessProof(proof, pasteID, stored string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAccessProof(pasteID, proof)), []byte(stored)) == 1
+}
+
 // GenerateVizhash creates a visual hash for anonymous comment attribution.
 // The hash is derived from the commenter's IP address and server salt,
 // allowing consistent avatar display without storing the actual IP.
@@ -106,6 +129,22 @@ func HashIP(ip, salt string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// RotatingSalt derives from salt the salt for the period of the given
+// length in seconds that contains now, so IP hashes made with it change
+// every period and can't be linked across periods. Periods count from the
+// Unix epoch, so a period of 86400 is a UTC day. A period of 0 or less
+// returns salt unchanged.
+//
+// Format: hex(HMAC-SHA256(salt, "traffic:" + now/period))
+func RotatingSalt(salt string, period int64, now time.Time) string {
+	if period <= 0 {
+		return salt
+	}
+	h := hmac.New(sha256.New, []byte(salt))
+	h.Write([]byte("traffic:" + strconv.FormatInt(now.Unix()/period, 10)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // RandomBytes generates n cryptographically random bytes.
 // Returns error if the system's random number generator fails.
 func RandomBytes(n int) ([]byte, error) {
@@ -125,3 +164,119 @@ func RandomHex(n int) (string, error) {
 	}
 	return hex.EncodeToString(b), nil
 }
+
+// MaxAdminTokenTTL is the longest lifetime of a signed admin token.
+// Tokens claiming to expire later are rejected, so a leaked one can't
+// have been minted to last indefinitely.
+const MaxAdminTokenTTL = 24 * time.Hour
+
+// adminTokenPrefix marks signed admin tokens and versions their format.
+const adminTokenPrefix = "fpa1."
+
+// GenerateAdminToken creates a signed admin token that expires at the
+// given time. Anyone with the server salt can mint one, so only operators
+// with access to the storage backend can.
+//
+// Format: fpa1.<expiry unix seconds>.hex(HMAC-SHA256("fpa1.<expiry>", salt))
+func GenerateAdminToken(salt string, expires time.Time) (string, error) {
+	payload := adminTokenPrefix + strconv.FormatInt(expires.Unix(), 10)
+	mac, err := saltMAC(payload, salt)
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + mac, nil
+}
+
+// ValidateAdminToken reports whether token is a signed admin token that
+// hasn't expired at now. Uses constant-time comparison for the signature.
+func ValidateAdminToken(token, salt string, now time.Time) bool {
+	payload, mac, ok := strings.Cut(strings.TrimPrefix(token, adminTokenPrefix), ".")
+	if !ok || !strings.HasPrefix(token, adminTokenPrefix) {
+		return false
+	}
+	expires, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil || expires <= now.Unix() || expires > now.Add(MaxAdminTokenTTL).Unix() {
+		return false
+	}
+
+	expected, err := saltMAC(adminTokenPrefix+payload, salt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(mac), []byte(expected)) == 1
+}
+
+// saltMAC returns hex(HMAC-SHA256(payload, salt)), the signature of admin
+// tokens and proof-of-work challenges. Callers prefix their payloads
+// ("fpa1.", "fpw1."), so they can't collide with each other or with the
+// paste IDs delete tokens are derived from.
+func saltMAC(payload, salt string) (string, error) {
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return "", fmt.Errorf("decoding salt: %w", err)
+	}
+	h := hmac.New(sha256.New, saltBytes)
+	h.Write([]byte(payload))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ErrSecretKey is returned by OpenSecret when the key is malformed or
+// doesn't decrypt the secret.
+var ErrSecretKey = errors.New("invalid secret key")
+
+// SealSecret encrypts a secret for storage with AES-256-GCM under a new
+// random key, which is returned to the creator and never stored. The secret
+// is bound to its paste ID, so ciphertext moved to another ID won't open.
+//
+// Formats: data is base64(nonce || ciphertext || tag), the key base64url.
+func SealSecret(pasteID string, plaintext []byte) (data, key string, err error) {
+	keyBytes, err := RandomBytes(32)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := secretCipher(keyBytes)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := RandomBytes(gcm.NonceSize())
+	if err != nil {
+		return "", "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(pasteID))
+	return base64.StdEncoding.EncodeToString(sealed), base64.RawURLEncoding.EncodeToString(keyBytes), nil
+}
+
+// OpenSecret decrypts a secret sealed by SealSecret. Returns ErrSecretKey
+// if key is wrong.
+func OpenSecret(pasteID, data, key string) ([]byte, error) {
+	keyBytes, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(keyBytes) != 32 {
+		return nil, ErrSecretKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("decoding secret: %w", err)
+	}
+	gcm, err := secretCipher(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("secret too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(pasteID))
+	if err != nil {
+		return nil, ErrSecretKey
+	}
+	return plaintext, nil
+}
+
+// secretCipher returns the AEAD for a secret key.
+func secretCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -164,6 +165,16 @@ func TestValidateDeleteToken_InvalidSalt_ReturnsFalse(t *testing.T) {
 	assert.False(t, valid)
 }
 
+func TestAccessProof_Validate(t *testing.T) {
+	stored := HashAccessProof("abcdef1234567890", "proof-0123456789abcdef0123456789")
+	assert.NotContains(t, stored, "proof")
+
+	assert.True(t, ValidateAccessProof("proof-0123456789abcdef0123456789", "abcdef1234567890", stored))
+	assert.False(t, ValidateAccessProof("proof-0123456789abcdef012345678x", "abcdef1234567890", stored))
+	assert.False(t, ValidateAccessProof("proof-0123456789abcdef0123456789", "1234567890abcdef", stored))
+	assert.False(t, ValidateAccessProof("", "abcdef1234567890", stored))
+}
+
 func TestGenerateVizhash_ReturnsConsistentHash(t *testing.T) {
 	salt, err := GenerateSalt()
 	require.NoError(t, err)
@@ -242,6 +253,18 @@ func TestHashIP_DifferentSalts_DifferentHashes(t *testing.T) {
 	assert.NotEqual(t, hash1, hash2)
 }
 
+func TestRotatingSalt(t *testing.T) {
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	salt := RotatingSalt("salt", 86400, day)
+	assert.NotEqual(t, "salt", salt)
+	assert.Equal(t, salt, RotatingSalt("salt", 86400, day.Add(23*time.Hour)))
+	assert.NotEqual(t, salt, RotatingSalt("salt", 86400, day.Add(24*time.Hour)))
+	assert.NotEqual(t, salt, RotatingSalt("pepper", 86400, day))
+
+	assert.Equal(t, "salt", RotatingSalt("salt", 0, day))
+}
+
 func TestHashIP_ReturnsValidHex(t *testing.T) {
 	hash := HashIP("192.168.1.100", "salt")
 
@@ -324,3 +347,68 @@ func BenchmarkValidateDeleteToken(b *testing.B) {
 		ValidateDeleteToken(token, pasteID, salt)
 	}
 }
+
+func TestAdminToken_ValidUntilExpiry(t *testing.T) {
+	salt, err := GenerateSalt()
+	require.NoError(t, err)
+
+	now := time.Now()
+	token, err := GenerateAdminToken(salt, now.Add(15*time.Minute))
+	require.NoError(t, err)
+
+	assert.True(t, ValidateAdminToken(token, salt, now))
+	assert.True(t, ValidateAdminToken(token, salt, now.Add(14*time.Minute)))
+	assert.False(t, ValidateAdminToken(token, salt, now.Add(16*time.Minute)), "expired")
+
+	other, err := GenerateSalt()
+	require.NoError(t, err)
+	assert.False(t, ValidateAdminToken(token, other, now), "different salt")
+}
+
+func TestAdminToken_RejectsTampering(t *testing.T) {
+	salt, err := GenerateSalt()
+	require.NoError(t, err)
+
+	now := time.Now()
+	token, err := GenerateAdminToken(salt, now.Add(time.Hour))
+	require.NoError(t, err)
+
+	// Extending the expiry invalidates the signature
+	extended, err := GenerateAdminToken(salt, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	forged := extended[:len(extended)-64] + token[len(token)-64:]
+	assert.False(t, ValidateAdminToken(forged, salt, now))
+
+	// Lifetimes beyond the maximum are rejected even when signed
+	long, err := GenerateAdminToken(salt, now.Add(MaxAdminTokenTTL+time.Hour))
+	require.NoError(t, err)
+	assert.False(t, ValidateAdminToken(long, salt, now))
+
+	for _, bad := range []string{"", "fpa1.", "fpa1.abc.def", token[5:], "fpa2." + token[5:]} {
+		assert.False(t, ValidateAdminToken(bad, salt, now), bad)
+	}
+}
+
+func TestSecret_RoundTrip(t *testing.T) {
+	data, key, err := SealSecret("f468483c313401e8", []byte("hunter2"))
+	require.NoError(t, err)
+	assert.NotContains(t, data, "hunter2")
+
+	plaintext, err := OpenSecret("f468483c313401e8", data, key)
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", string(plaintext))
+}
+
+func TestSecret_RejectsWrongKeyOrID(t *testing.T) {
+	data, key, err := SealSecret("f468483c313401e8", []byte("hunter2"))
+	require.NoError(t, err)
+	_, otherKey, err := SealSecret("f468483c313401e8", []byte("hunter2"))
+	require.NoError(t, err)
+
+	_, err = OpenSecret("f468483c313401e8", data, otherKey)
+	assert.Equal(t, ErrSecretKey, err)
+	_, err = OpenSecret("f468483c313401e8", data, "not-a-key")
+	assert.Equal(t, ErrSecretKey, err)
+	_, err = OpenSecret("0123456789abcdef", data, key)
+	assert.Equal(t, ErrSecretKey, err)
+}
@@ -0,0 +1,98 @@
+// Package util provides Hashcash-style proof-of-work challenges.
+// To create a paste on an instance requiring proof of work, a client first
+// fetches a challenge, then searches for a nonce such that
+// SHA-256(challenge ":" nonce) starts with at least difficulty zero bits.
+// Finding one takes about 2^difficulty hashes, checking it a single one.
+// Challenges are signed with the server salt and carry their difficulty
+// and expiry, so the server doesn't keep them until they are answered.
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// powChallengePrefix marks proof-of-work challenges and versions their format.
+const powChallengePrefix = "fpw1."
+
+// MaxPoWDifficulty is the highest difficulty a challenge may ask for, about
+// four billion hashes; browsers take hours for that.
+const MaxPoWDifficulty = 32
+
+// MaxPoWNonceLength bounds the nonces accepted. Any difficulty can be met
+// with a decimal counter far shorter than this.
+const MaxPoWNonceLength = 64
+
+// GeneratePoWChallenge creates a challenge of the given difficulty that
+// expires at the given time.
+//
+// Format: fpw1.<difficulty>.<expiry unix seconds>.<random hex>.hex(HMAC-SHA256(payload, salt))
+func GeneratePoWChallenge(salt string, difficulty int, expires time.Time) (string, error) {
+	random, err := RandomHex(16)
+	if err != nil {
+		return "", err
+	}
+	payload := powChallengePrefix + strconv.Itoa(difficulty) + "." +
+		strconv.FormatInt(expires.Unix(), 10) + "." + random
+	mac, err := saltMAC(payload, salt)
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + mac, nil
+}
+
+// ValidatePoWChallenge checks that challenge was signed with salt and
+// hasn't expired at now, and returns the difficulty and expiry it was
+// issued with. Uses constant-time comparison for the signature.
+func ValidatePoWChallenge(challenge, salt string, now time.Time) (difficulty int, expires time.Time, ok bool) {
+	rest, found := strings.CutPrefix(challenge, powChallengePrefix)
+	fields := strings.Split(rest, ".")
+	if !found || len(fields) != 4 {
+		return 0, time.Time{}, false
+	}
+	difficulty, err := strconv.Atoi(fields[0])
+	if err != nil || difficulty < 1 || difficulty > MaxPoWDifficulty {
+		return 0, time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || unix <= now.Unix() {
+		return 0, time.Time{}, false
+	}
+
+	expected, err := saltMAC(powChallengePrefix+strings.Join(fields[:3], "."), salt)
+	if err != nil || subtle.ConstantTimeCompare([]byte(fields[3]), []byte(expected)) != 1 {
+		return 0, time.Time{}, false
+	}
+	return difficulty, time.Unix(unix, 0), true
+}
+
+// CheckPoW reports whether nonce solves challenge at the given difficulty.
+func CheckPoW(challenge, nonce string, difficulty int) bool {
+	if nonce == "" || len(nonce) > MaxPoWNonceLength {
+		return false
+	}
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	zeros := 0
+	for _, b := range sum {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros >= difficulty
+}
+
+// SolvePoW finds a nonce solving challenge at the given difficulty, as
+// clients do; for tests and command-line clients.
+func SolvePoW(challenge string, difficulty int) string {
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		if CheckPoW(challenge, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
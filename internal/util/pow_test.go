@@ -0,0 +1,81 @@
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoWChallenge_ValidUntilExpiry(t *testing.T) {
+	salt, err := GenerateSalt()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	challenge, err := GeneratePoWChallenge(salt, 12, now.Add(5*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(challenge, "fpw1.12.1700000300."))
+
+	difficulty, expires, ok := ValidatePoWChallenge(challenge, salt, now)
+	assert.True(t, ok)
+	assert.Equal(t, 12, difficulty)
+	assert.Equal(t, now.Add(5*time.Minute), expires)
+
+	_, _, ok = ValidatePoWChallenge(challenge, salt, now.Add(5*time.Minute))
+	assert.False(t, ok, "expired")
+
+	other, err := GenerateSalt()
+	require.NoError(t, err)
+	_, _, ok = ValidatePoWChallenge(challenge, other, now)
+	assert.False(t, ok, "different salt")
+
+	// Each challenge is new
+	again, err := GeneratePoWChallenge(salt, 12, now.Add(5*time.Minute))
+	require.NoError(t, err)
+	assert.NotEqual(t, challenge, again)
+}
+
+func TestPoWChallenge_RejectsTampering(t *testing.T) {
+	salt, err := GenerateSalt()
+	require.NoError(t, err)
+
+	now := time.Now()
+	challenge, err := GeneratePoWChallenge(salt, 20, now.Add(time.Minute))
+	require.NoError(t, err)
+
+	// Lowering the difficulty invalidates the signature
+	easier := strings.Replace(challenge, "fpw1.20.", "fpw1.1.", 1)
+	_, _, ok := ValidatePoWChallenge(easier, salt, now)
+	assert.False(t, ok)
+
+	for _, bad := range []string{"", "fpw1.", "fpw1.20.1.2", challenge[5:], "fpw2." + challenge[5:], challenge + ".x"} {
+		_, _, ok := ValidatePoWChallenge(bad, salt, now)
+		assert.False(t, ok, bad)
+	}
+
+	// Difficulties out of range are rejected even when signed
+	for _, difficulty := range []int{0, MaxPoWDifficulty + 1} {
+		challenge, err := GeneratePoWChallenge(salt, difficulty, now.Add(time.Minute))
+		require.NoError(t, err)
+		_, _, ok := ValidatePoWChallenge(challenge, salt, now)
+		assert.False(t, ok, difficulty)
+	}
+}
+
+func TestCheckPoW(t *testing.T) {
+	const challenge = "fpw1.8.1700000300.00112233445566778899aabbccddeeff.mac"
+
+	// SHA-256 of challenge ":1" starts with 0x10, three zero bits
+	assert.True(t, CheckPoW(challenge, "1", 3))
+	assert.False(t, CheckPoW(challenge, "1", 4))
+
+	// The first solution of difficulty 8 starts with 0x00
+	assert.Equal(t, "151", SolvePoW(challenge, 8))
+	assert.True(t, CheckPoW(challenge, "151", 8))
+	assert.False(t, CheckPoW(challenge, "151", 9))
+
+	assert.False(t, CheckPoW(challenge, "", 0))
+	assert.False(t, CheckPoW(challenge, strings.Repeat("1", MaxPoWNonceLength+1), 0))
+}
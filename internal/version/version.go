@@ -0,0 +1,14 @@
+// Package version holds build-time version information for FlashPaper.
+// The values are injected via ldflags so every package (CLI, handlers,
+// middleware) reports the same version without threading it through
+// constructors:
+//
+//	go build -ldflags "-X github.com/liskl/flashpaper/internal/version.Version=1.0.0 \
+//	  -X github.com/liskl/flashpaper/internal/version.Commit=abc123"
+package version
+
+// Version is the release version, or "dev" for local builds.
+var Version = "dev"
+
+// Commit is the git commit the binary was built from.
+var Commit = "none"
@@ -0,0 +1,256 @@
+// Package webhook delivers paste lifecycle events to the operator's HTTP
+// endpoints, for alerting, auditing, or relaying to email and chat. Each
+// event is POSTed to every configured URL as a small JSON document naming
+// the event, the paste and comment IDs, and the time; never content, keys,
+// or client addresses.
+//
+// Events are queued and delivered by a fixed pool of workers, so requests
+// never wait on an endpoint. Failed deliveries are retried with growing
+// delays; when the queue is full, new events are dropped and logged. With a
+// secret configured, each payload carries an HMAC-SHA256 signature the
+// receiver can check (see Sign).
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// Event identifies what a webhook reports.
+type Event string
+
+// Events reported to webhooks (see config.WebhookEvents).
+const (
+	// PasteCreated is sent after a paste is stored
+	PasteCreated Event = "paste.created"
+
+	// PasteDeleted is sent after a paste is deleted with its delete token,
+	// or after being read once
+	PasteDeleted Event = "paste.deleted"
+
+	// PasteExpired is sent when a paste is found past its expiration time.
+	// Pastes removed by the background purge aren't reported one by one.
+	PasteExpired Event = "paste.expired"
+
+	// CommentCreated is sent after a comment is stored
+	CommentCreated Event = "comment.created"
+)
+
+// Request headers of a delivery, besides Content-Type and User-Agent.
+const (
+	// EventHeader names the event, as in the payload
+	EventHeader = "X-FlashPaper-Event"
+
+	// DeliveryHeader identifies the delivery; retries of it keep the ID,
+	// so receivers can ignore ones they already handled
+	DeliveryHeader = "X-FlashPaper-Delivery"
+
+	// SignatureHeader holds "sha256=" and the hex HMAC-SHA256 of the body,
+	// keyed with the secret (only if one is configured)
+	SignatureHeader = "X-FlashPaper-Signature"
+)
+
+// maxRetryDelay caps the wait between attempts.
+const maxRetryDelay = 5 * time.Minute
+
+// Payload is the JSON document POSTed to each endpoint.
+type Payload struct {
+	Event     Event  `json:"event"`
+	PasteID   string `json:"pasteid"`
+	CommentID string `json:"commentid,omitempty"`
+	Time      int64  `json:"time"`
+}
+
+// delivery is one payload on its way to one endpoint.
+type delivery struct {
+	url   string
+	event Event
+	id    string
+	body  []byte
+}
+
+// Dispatcher queues events and delivers them in the background.
+// A nil Dispatcher drops every event.
+type Dispatcher struct {
+	urls       []string
+	secret     []byte
+	events     map[Event]bool // Events to send (nil = all)
+	retries    int
+	retryDelay time.Duration // Wait before the first retry; doubles after
+	client     *http.Client
+
+	mu     sync.RWMutex // Guards closed against sends on a closed queue
+	closed bool
+	queue  chan delivery
+	stop   chan struct{} // Closed by Close to cut retries short
+	wg     sync.WaitGroup
+}
+
+// New creates a Dispatcher from configuration and starts its workers.
+// Returns nil when no URLs are configured, which disables webhooks.
+func New(cfg config.WebhookConfig) *Dispatcher {
+	if len(cfg.URLs) == 0 {
+		return nil
+	}
+
+	d := &Dispatcher{
+		urls:       cfg.URLs,
+		secret:     []byte(cfg.Secret),
+		retries:    cfg.Retries,
+		retryDelay: time.Second,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+			// Endpoints are the operator's own; a redirect is more likely
+			// a misconfiguration than something to follow
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		queue: make(chan delivery, cfg.Queue),
+		stop:  make(chan struct{}),
+	}
+	if len(cfg.Events) > 0 {
+		d.events = make(map[Event]bool, len(cfg.Events))
+		for _, e := range cfg.Events {
+			d.events[Event(e)] = true
+		}
+	}
+
+	d.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Send queues p for every endpoint, unless its event isn't wanted. It
+// never blocks: if the queue is full, the delivery is dropped.
+func (d *Dispatcher) Send(p Payload) {
+	if d == nil || (d.events != nil && !d.events[p.Event]) {
+		return
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, url := range d.urls {
+		select {
+		case d.queue <- delivery{url: url, event: p.Event, id: newDeliveryID(), body: body}:
+		default:
+			slog.Warn("Webhook queue full; event dropped", "url", url, "event", string(p.Event))
+		}
+	}
+}
+
+// Close stops accepting events and waits for the queued ones to be
+// attempted. Deliveries waiting to be retried are given up.
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.stop)
+		close(d.queue)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// work delivers queued payloads until the queue is closed and empty.
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for del := range d.queue {
+		d.deliver(del)
+	}
+}
+
+// deliver makes the first attempt at del and its retries.
+func (d *Dispatcher) deliver(del delivery) {
+	delay := d.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := d.attempt(del)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= d.retries {
+			slog.Warn("Webhook delivery failed", "url", del.url, "event", string(del.event),
+				"attempts", attempt+1, "error", err)
+			return
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-d.stop:
+			slog.Warn("Webhook delivery abandoned at shutdown", "url", del.url, "event", string(del.event),
+				"attempts", attempt+1, "error", err)
+			return
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// attempt POSTs del once. It reports whether a failure is worth retrying:
+// network errors, server errors, and 429 are; other refusals are not.
+func (d *Dispatcher) attempt(del delivery) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, del.url, bytes.NewReader(del.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "FlashPaper-Webhook")
+	req.Header.Set(EventHeader, string(del.event))
+	req.Header.Set(DeliveryHeader, del.id)
+	if len(d.secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(d.secret, del.body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("endpoint responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+}
+
+// Sign returns the SignatureHeader value for body: "sha256=" followed by
+// the hex HMAC-SHA256 of body keyed with secret. Receivers recompute it
+// over the raw request body and compare in constant time.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDeliveryID returns a random ID for DeliveryHeader.
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
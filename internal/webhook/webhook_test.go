@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/liskl/flashpaper/internal/config"
+)
+
+// received is a request an endpoint got.
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// endpoint records requests and answers each with the next status, 200
+// once they run out.
+type endpoint struct {
+	mu       sync.Mutex
+	requests []received
+	statuses []int
+	got      chan struct{}
+}
+
+func newEndpoint(t *testing.T, statuses ...int) (*endpoint, *httptest.Server) {
+	e := &endpoint{statuses: statuses, got: make(chan struct{}, 100)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		e.mu.Lock()
+		e.requests = append(e.requests, received{r.Header.Clone(), body})
+		status := http.StatusOK
+		if len(e.statuses) > 0 {
+			status, e.statuses = e.statuses[0], e.statuses[1:]
+		}
+		e.mu.Unlock()
+		w.WriteHeader(status)
+		e.got <- struct{}{}
+	}))
+	t.Cleanup(srv.Close)
+	return e, srv
+}
+
+// wait blocks until the endpoint has been called n more times.
+func (e *endpoint) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-e.got:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a delivery")
+		}
+	}
+}
+
+func (e *endpoint) received() []received {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]received(nil), e.requests...)
+}
+
+func testConfig(urls ...string) config.WebhookConfig {
+	cfg := config.DefaultConfig().Webhook
+	cfg.URLs = urls
+	return cfg
+}
+
+func TestNew_NoURLsDisables(t *testing.T) {
+	d := New(config.DefaultConfig().Webhook)
+	assert.Nil(t, d)
+
+	// Nil dispatchers are safe to use
+	d.Send(Payload{Event: PasteCreated, PasteID: "abcdef1234567890"})
+	d.Close()
+}
+
+func TestDispatcher_Delivers(t *testing.T) {
+	e, srv := newEndpoint(t)
+	cfg := testConfig(srv.URL+"/a", srv.URL+"/b")
+	cfg.Secret = "s3cret"
+	d := New(cfg)
+	require.NotNil(t, d)
+
+	d.Send(Payload{Event: CommentCreated, PasteID: "abcdef1234567890", CommentID: "0123456789abcdef", Time: 1700000000})
+	d.Close()
+
+	requests := e.received()
+	require.Len(t, requests, 2)
+	for _, r := range requests {
+		var p Payload
+		require.NoError(t, json.Unmarshal(r.body, &p))
+		assert.Equal(t, Payload{Event: CommentCreated, PasteID: "abcdef1234567890", CommentID: "0123456789abcdef", Time: 1700000000}, p)
+		assert.Equal(t, "application/json", r.header.Get("Content-Type"))
+		assert.Equal(t, "comment.created", r.header.Get(EventHeader))
+		assert.Len(t, r.header.Get(DeliveryHeader), 32)
+		assert.Equal(t, Sign([]byte("s3cret"), r.body), r.header.Get(SignatureHeader))
+	}
+
+	// Sent after Close: dropped
+	d.Send(Payload{Event: PasteCreated, PasteID: "abcdef1234567890"})
+	assert.Len(t, e.received(), 2)
+}
+
+func TestDispatcher_Unsigned(t *testing.T) {
+	e, srv := newEndpoint(t)
+	d := New(testConfig(srv.URL))
+	d.Send(Payload{Event: PasteCreated, PasteID: "abcdef1234567890"})
+	d.Close()
+
+	requests := e.received()
+	require.Len(t, requests, 1)
+	assert.Empty(t, requests[0].header.Get(SignatureHeader))
+}
+
+func TestDispatcher_EventFilter(t *testing.T) {
+	e, srv := newEndpoint(t)
+	cfg := testConfig(srv.URL)
+	cfg.Events = []string{"paste.expired"}
+	d := New(cfg)
+
+	d.Send(Payload{Event: PasteCreated, PasteID: "abcdef1234567890"})
+	d.Send(Payload{Event: PasteExpired, PasteID: "abcdef1234567890"})
+	d.Close()
+
+	requests := e.received()
+	require.Len(t, requests, 1)
+	assert.Equal(t, "paste.expired", requests[0].header.Get(EventHeader))
+}
+
+func TestDispatcher_Retries(t *testing.T) {
+	e, srv := newEndpoint(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	d := New(testConfig(srv.URL))
+	d.retryDelay = time.Millisecond
+
+	d.Send(Payload{Event: PasteDeleted, PasteID: "abcdef1234567890"})
+	e.wait(t, 3)
+	d.Close()
+
+	requests := e.received()
+	require.Len(t, requests, 3)
+	assert.Equal(t, requests[0].header.Get(DeliveryHeader), requests[2].header.Get(DeliveryHeader))
+	assert.Equal(t, requests[0].body, requests[2].body)
+}
+
+func TestDispatcher_GivesUp(t *testing.T) {
+	// Refusals other than 429 aren't retried
+	e, srv := newEndpoint(t, http.StatusBadRequest)
+	d := New(testConfig(srv.URL))
+	d.retryDelay = time.Millisecond
+	d.Send(Payload{Event: PasteCreated, PasteID: "abcdef1234567890"})
+	d.Close()
+	assert.Len(t, e.received(), 1)
+
+	// Retries stop at the configured number
+	e, srv = newEndpoint(t, 500, 500, 500, 500)
+	cfg := testConfig(srv.URL)
+	cfg.Retries = 2
+	d = New(cfg)
+	d.retryDelay = time.Millisecond
+	d.Send(Payload{Event: PasteCreated, PasteID: "abcdef1234567890"})
+	e.wait(t, 3)
+	d.Close()
+	assert.Len(t, e.received(), 3)
+}
+
+func TestDispatcher_QueueFull(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	var mu sync.Mutex
+	count := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(srv.URL)
+	cfg.Queue = 1
+	cfg.Workers = 1
+	d := New(cfg)
+
+	// The worker is busy with the first, the second waits, the third is dropped
+	d.Send(Payload{Event: PasteCreated, PasteID: "1111111111111111"})
+	<-started
+	d.Send(Payload{Event: PasteCreated, PasteID: "2222222222222222"})
+	d.Send(Payload{Event: PasteCreated, PasteID: "3333333333333333"})
+	close(release)
+	d.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, count)
+}
+
+func TestEvents_MatchConfig(t *testing.T) {
+	var events []string
+	for _, e := range []Event{PasteCreated, PasteDeleted, PasteExpired, CommentCreated} {
+		events = append(events, string(e))
+	}
+	assert.Equal(t, config.WebhookEvents, events)
+}
@@ -26,5 +26,10 @@ export default defineConfig({
     url: 'http://localhost:8080/health',
     reuseExistingServer: !process.env.CI,
     timeout: 30000,
+    // Tests create pastes and comments faster than the default limits allow
+    env: {
+      FLASHPAPER_TRAFFIC_LIMIT: '0',
+      FLASHPAPER_TRAFFIC_COMMENT_LIMIT: '0',
+    },
   },
 });
@@ -0,0 +1,238 @@
+// Package testserver runs a fully wired FlashPaper instance for integration
+// tests of clients and third-party tooling. Each Server listens on a random
+// loopback port with in-memory storage and a fixed server salt, so delete
+// tokens are reproducible. Pastes can be seeded directly into storage. The
+// instance runs on a fake clock, which Advance moves forward to test
+// expiration and rate limits without waiting.
+//
+//	srv := testserver.New(t)
+//	id, token := srv.Seed(testserver.Paste{Data: "ciphertext", Expire: time.Hour})
+//	// ... point the client under test at srv.URL ...
+//	srv.Advance(2 * time.Hour) // the paste is now expired
+//
+// Rate limits are off unless the INI passed to WithINI turns them on.
+package testserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/liskl/flashpaper/internal/clock"
+	"github.com/liskl/flashpaper/internal/config"
+	"github.com/liskl/flashpaper/internal/model"
+	"github.com/liskl/flashpaper/internal/server"
+	"github.com/liskl/flashpaper/internal/storage"
+	"github.com/liskl/flashpaper/internal/util"
+)
+
+// DefaultSalt is the server salt of instances not given one with WithSalt.
+const DefaultSalt = "dGVzdC1zYWx0LWZsYXNocGFwZXItdGVzdHNlcnZlcg==" // base64("test-salt-flashpaper-testserver")
+
+// baseINI is applied before the caller's INI: throttling gets in the way
+// of tests that create pastes in quick succession.
+const baseINI = `[traffic]
+limit = 0
+comment_limit = 0
+`
+
+// Server is a running FlashPaper instance.
+type Server struct {
+	// URL is the base URL of the instance, e.g. "http://127.0.0.1:41234".
+	URL string
+
+	// Salt is the server salt.
+	Salt string
+
+	t     testing.TB
+	store *storage.Mock
+	clock *clock.Fake
+	srv   *server.Server
+	done  chan struct{} // Closed when Serve returns
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+type options struct {
+	ini  string
+	salt string
+}
+
+// WithINI configures the instance with INI text in the format of
+// config.sample.ini. [main] host and port and the [model] section are
+// ignored. FLASHPAPER_* environment variables apply on top, as they do
+// for a real instance.
+func WithINI(ini string) Option {
+	return func(o *options) { o.ini = ini }
+}
+
+// WithSalt sets the server salt, which must be base64-encoded.
+func WithSalt(salt string) Option {
+	return func(o *options) { o.salt = salt }
+}
+
+// New starts a Server and stops it when the test ends. Setup errors fail
+// the test.
+func New(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	o := options{salt: DefaultSalt}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	path := filepath.Join(t.TempDir(), "flashpaper.ini")
+	if err := os.WriteFile(path, []byte(baseINI+"\n"+o.ini), 0600); err != nil {
+		t.Fatalf("testserver: writing config: %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("testserver: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("testserver: listening: %v", err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	cfg.Main.Host = addr.IP.String()
+	cfg.Main.Port = addr.Port
+	cfg.Model.Class = "Mock"
+
+	// The handler picks the salt up from storage
+	store := storage.NewMock()
+	if err := store.SetValue(context.Background(), storage.NamespaceSalt, "server", o.salt); err != nil {
+		t.Fatalf("testserver: storing salt: %v", err)
+	}
+
+	srv, err := server.New(cfg, store)
+	if err != nil {
+		listener.Close()
+		t.Fatalf("testserver: %v", err)
+	}
+	fake := clock.NewFake(time.Now())
+	srv.SetClock(fake)
+
+	s := &Server{
+		URL:   "http://" + addr.String(),
+		Salt:  o.salt,
+		t:     t,
+		store: store,
+		clock: fake,
+		srv:   srv,
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			t.Errorf("testserver: serving: %v", err)
+		}
+	}()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the server. It is called automatically when the test ends.
+func (s *Server) Close() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.srv.Shutdown(ctx)
+	<-s.done
+}
+
+// Paste is a paste to seed an instance with. The server never decrypts
+// pastes, so Data and Attachments needn't be real ciphertext.
+type Paste struct {
+	Data  string
+	AData json.RawMessage // Defaults to adata matching the flags below
+
+	Expire           time.Duration // Zero for never
+	BurnAfterReading bool
+	OpenDiscussion   bool
+
+	Attachments     []string
+	AttachmentNames []string
+}
+
+// Seed stores p directly, without going through the API, and returns its
+// ID and delete token.
+func (s *Server) Seed(p Paste) (id, deleteToken string) {
+	s.t.Helper()
+	now := s.clock.Now()
+	paste := model.NewPaste()
+	paste.Meta.PostDate = now.Unix()
+	paste.Data = p.Data
+	paste.AData = p.AData
+	if paste.AData == nil {
+		paste.AData = json.RawMessage(fmt.Sprintf(
+			`[["iv","salt",100000,256,128,"aes","gcm","zlib"],"plaintext",%d,%d]`,
+			boolInt(p.OpenDiscussion), boolInt(p.BurnAfterReading),
+		))
+	}
+	paste.SetExpirationFrom(now, p.Expire)
+	paste.Meta.BurnAfterReading = p.BurnAfterReading
+	paste.Meta.OpenDiscussion = p.OpenDiscussion
+	paste.Attachments = p.Attachments
+	paste.AttachmentNames = p.AttachmentNames
+	paste.Meta.AttachmentList = len(p.Attachments) > 1
+
+	var err error
+	if paste.Meta.Salt, err = util.GenerateSalt(); err != nil {
+		s.t.Fatalf("testserver: generating salt: %v", err)
+	}
+	for {
+		if id, err = util.GenerateID(); err != nil {
+			s.t.Fatalf("testserver: generating ID: %v", err)
+		}
+		if err = s.store.CreatePaste(context.Background(), id, paste); err != model.ErrPasteExists {
+			break
+		}
+	}
+	if err != nil {
+		s.t.Fatalf("testserver: storing paste: %v", err)
+	}
+	if deleteToken, err = util.GenerateDeleteToken(id, paste.Meta.Salt); err != nil {
+		s.t.Fatalf("testserver: generating delete token: %v", err)
+	}
+	return id, deleteToken
+}
+
+// PasteURL returns the URL a browser opens a paste at, without the key.
+func (s *Server) PasteURL(id string) string {
+	return s.URL + "/?" + id
+}
+
+// Exists reports whether a paste is stored, expired or not.
+func (s *Server) Exists(id string) bool {
+	return s.store.PasteExists(context.Background(), id)
+}
+
+// Now returns the instance's current time.
+func (s *Server) Now() time.Time {
+	return s.clock.Now()
+}
+
+// Advance moves the instance's clock d forward: pastes due to expire
+// within d are expired afterwards, and rate limits refill by d.
+func (s *Server) Advance(d time.Duration) {
+	s.clock.Advance(d)
+}
+
+// boolInt returns 1 for true, as adata flags are numbers.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+package testserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// getPaste reads a paste through the JSON API.
+func getPaste(t *testing.T, s *Server, id string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, s.PasteURL(id), nil)
+	req.Header.Set("X-Requested-With", "JSONHttpRequest")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+// TestServer_SeedAndRead tests that seeded pastes are served over HTTP.
+func TestServer_SeedAndRead(t *testing.T) {
+	s := New(t)
+	id, _ := s.Seed(Paste{Data: "ciphertext", Attachments: []string{"a", "b"}})
+
+	status, body := getPaste(t, s, id)
+	if status != http.StatusOK || body["ct"] != "ciphertext" {
+		t.Fatalf("unexpected response %d %v", status, body)
+	}
+	if attachments, _ := body["attachment"].([]interface{}); len(attachments) != 2 {
+		t.Errorf("expected two attachments, got %v", body["attachment"])
+	}
+}
+
+// TestServer_DeleteToken tests that seeded delete tokens are accepted.
+func TestServer_DeleteToken(t *testing.T) {
+	s := New(t)
+	id, token := s.Seed(Paste{Data: "ciphertext"})
+
+	payload, _ := json.Marshal(map[string]string{"pasteid": id, "deletetoken": token})
+	req, _ := http.NewRequest(http.MethodDelete, s.URL+"/", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || s.Exists(id) {
+		t.Errorf("expected paste deleted, got status %d", resp.StatusCode)
+	}
+}
+
+// TestServer_Advance tests expiring pastes without waiting.
+func TestServer_Advance(t *testing.T) {
+	s := New(t)
+	short, _ := s.Seed(Paste{Data: "short", Expire: time.Hour})
+	long, _ := s.Seed(Paste{Data: "long", Expire: 24 * time.Hour})
+
+	s.Advance(2 * time.Hour)
+
+	if status, _ := getPaste(t, s, short); status != http.StatusNotFound {
+		t.Errorf("expected expired paste, got status %d", status)
+	}
+	if status, _ := getPaste(t, s, long); status != http.StatusOK {
+		t.Errorf("expected paste still readable, got status %d", status)
+	}
+}
+
+// TestServer_WithINI tests configuring the instance.
+func TestServer_WithINI(t *testing.T) {
+	s := New(t, WithINI("[main]\nname = Test Instance\n"))
+
+	resp, err := http.Get(s.URL + "/.well-known/flashpaper.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var doc map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&doc)
+	if doc["name"] != "Test Instance" {
+		t.Errorf("expected configured name, got %v", doc["name"])
+	}
+}
+
+// createPaste creates a paste through the JSON API and returns the status.
+func createPaste(t *testing.T, s *Server) int {
+	t.Helper()
+	payload, _ := json.Marshal(map[string]interface{}{
+		"v":     2,
+		"ct":    "ciphertext",
+		"adata": []interface{}{[]interface{}{"iv", "salt", 100000, 256, 128, "aes", "gcm", "zlib"}, "plaintext", 0, 0},
+		"meta":  map[string]interface{}{"expire": "1day"},
+	})
+	req, _ := http.NewRequest(http.MethodPost, s.URL+"/", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestServer_CreateRepeatedly tests that rate limits are off by default.
+func TestServer_CreateRepeatedly(t *testing.T) {
+	s := New(t)
+	for i := 0; i < 3; i++ {
+		if status := createPaste(t, s); status != http.StatusOK {
+			t.Fatalf("create %d: expected status %d, got %d", i, http.StatusOK, status)
+		}
+	}
+}
+
+// TestServer_AdvanceRateLimit tests refilling rate limits without waiting.
+func TestServer_AdvanceRateLimit(t *testing.T) {
+	s := New(t, WithINI("[traffic]\nlimit = 60\nstore = memory\n"))
+
+	if status := createPaste(t, s); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	if status := createPaste(t, s); status != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, status)
+	}
+	s.Advance(time.Minute)
+	if status := createPaste(t, s); status != http.StatusOK {
+		t.Errorf("expected status %d after a minute, got %d", http.StatusOK, status)
+	}
+}
+
+// TestServer_CORS tests that paste reads get CORS headers on every route
+// they are served at.
+func TestServer_CORS(t *testing.T) {
+	s := New(t, WithINI("[security]\ncors_origins = https://app.example.com\n"))
+	id, _ := s.Seed(Paste{Data: "ciphertext"})
+
+	for _, path := range []string{"/?" + id, "/p/" + id, "/paste/" + id, "/raw/" + id} {
+		req, _ := http.NewRequest(http.MethodGet, s.URL+path, nil)
+		req.Header.Set("X-Requested-With", "JSONHttpRequest")
+		req.Header.Set("Origin", "https://app.example.com")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("%s: expected Access-Control-Allow-Origin, got %q", path, got)
+		}
+	}
+}
@@ -26,13 +26,29 @@ const FlashPaper = (function() {
     let currentPaste = null;
     let deleteToken = null;
 
+    // Proof of the paste password for pastes the server gates (see accessProof)
+    let currentProof = null;
+    let gatedPasteId = null;
+
+    // Instance configuration embedded by the server (see readConfig)
+    let config = null;
+
+    // Catalog of the page's language (see loadTranslations)
+    let lang = 'en';
+    let messages = {};
+
     /**
      * Initialize FlashPaper - detect if viewing paste or creating new
      */
-    function init() {
+    async function init() {
         // Initialize theme from localStorage or system preference
         initTheme();
 
+        // Read instance configuration and apply it to the create form
+        config = readConfig();
+        await loadTranslations();
+        applyConfig();
+
         const pasteId = getPasteIdFromUrl();
 
         if (pasteId) {
@@ -46,12 +62,185 @@ const FlashPaper = (function() {
         setupEventListeners();
     }
 
+    // =====================
+    // Configuration
+    // =====================
+
+    /**
+     * Read the bootstrap configuration embedded by the server.
+     * Falls back to permissive defaults if the block is missing or malformed.
+     */
+    function readConfig() {
+        const defaults = {
+            basepath: '',
+            sizelimit: 0,
+            expire: { default: '', options: [] },
+            features: { discussion: true, password: true, burnafterreadingselected: false }
+        };
+
+        const el = document.getElementById('flashpaper-config');
+        if (!el) return defaults;
+
+        try {
+            return Object.assign(defaults, JSON.parse(el.textContent));
+        } catch (e) {
+            console.error('Invalid bootstrap config:', e);
+            return defaults;
+        }
+    }
+
+    /**
+     * Populate the create form from the instance configuration
+     */
+    function applyConfig() {
+        // Expiration dropdown
+        const select = document.getElementById('expire');
+        if (select && config.expire.options.length > 0) {
+            select.innerHTML = '';
+            for (const option of config.expire.options) {
+                const el = document.createElement('option');
+                el.value = option.value;
+                el.textContent = t(option.label);
+                el.selected = option.value === config.expire.default;
+                select.appendChild(el);
+            }
+        }
+
+        const features = config.features;
+
+        // Hide discussion toggle when discussions are disabled instance-wide
+        const discussion = document.getElementById('open-discussion');
+        if (discussion && !features.discussion) {
+            discussion.closest('.toolbar-group')?.classList.add('hidden');
+        }
+
+        // Hide password field when password protection is disabled
+        if (!features.password) {
+            document.querySelector('.toolbar-password')?.classList.add('hidden');
+        }
+
+        // Preselect burn-after-reading (which excludes discussion)
+        const burn = document.getElementById('burn-after-reading');
+        if (burn && (features.burnafterreadingselected || features.forceburnafterreading)) {
+            burn.checked = true;
+            if (discussion) {
+                discussion.checked = false;
+                discussion.disabled = true;
+            }
+        }
+
+        // Lock options the instance forces, so adata matches what the server stores
+        if (burn && features.forceburnafterreading) {
+            burn.disabled = true;
+        }
+        if (discussion && burn && features.forceopendiscussion) {
+            discussion.checked = true;
+            discussion.disabled = true;
+            burn.checked = false;
+            burn.disabled = true;
+        }
+    }
+
+    /**
+     * Build an API URL relative to the configured base path
+     */
+    function apiUrl(query) {
+        const base = (config.basepath || '').replace(/\/+$/, '');
+        return base + '/' + (query ? '?' + query : '');
+    }
+
+    // =====================
+    // Translations
+    // =====================
+
+    /**
+     * Fetch the catalog of the language the server rendered the page in.
+     * Messages stay in English if it can't be loaded.
+     */
+    async function loadTranslations() {
+        lang = document.documentElement.lang || 'en';
+        if (lang === 'en') return;
+
+        try {
+            const base = (config.basepath || '').replace(/\/+$/, '');
+            const response = await fetch(base + '/i18n/' + encodeURIComponent(lang) + '.json');
+            if (response.ok) {
+                messages = await response.json();
+            }
+        } catch (e) {
+            console.error('Failed to load translations:', e);
+        }
+    }
+
+    /**
+     * Translate a message and fill in its %s and %d placeholders from args.
+     * Translations with plural forms pick the form for the first argument.
+     */
+    function t(message, ...args) {
+        let text = messages[message] || message;
+        if (Array.isArray(text)) {
+            text = text[Math.min(pluralForm(Number(args[0])), text.length - 1)];
+        }
+        let i = 0;
+        return text.replace(/%[sd]/g, () => String(args[i++]));
+    }
+
+    /**
+     * Index of the plural form for n things, by PrivateBin's rules
+     * (see i18n.PluralForm on the server)
+     */
+    function pluralForm(n) {
+        switch (lang) {
+            case 'ar':
+                return n === 0 ? 0 : n === 1 ? 1 : n === 2 ? 2 : n % 100 >= 3 && n % 100 <= 10 ? 3 : n % 100 >= 11 ? 4 : 5;
+            case 'cs':
+            case 'sk':
+                return n === 1 ? 0 : n >= 2 && n <= 4 ? 1 : 2;
+            case 'co':
+            case 'fa':
+            case 'fr':
+            case 'oc':
+            case 'tr':
+            case 'zh':
+                return n > 1 ? 1 : 0;
+            case 'he':
+                return n === 1 ? 0 : n === 2 ? 1 : (n < 0 || n > 10) && n % 10 === 0 ? 2 : 3;
+            case 'id':
+            case 'ja':
+            case 'jbo':
+            case 'th':
+                return 0;
+            case 'lt':
+                return n % 10 === 1 && n % 100 !== 11 ? 0 : (n % 10 >= 2 && n % 100 < 10) || n % 100 >= 20 ? 1 : 2;
+            case 'pl':
+                return n === 1 ? 0 : n % 10 >= 2 && n % 10 <= 4 && (n % 100 < 10 || n % 100 >= 20) ? 1 : 2;
+            case 'ro':
+                return n === 1 ? 0 : n === 0 || (n % 100 > 0 && n % 100 < 20) ? 1 : 2;
+            case 'ru':
+            case 'uk':
+                return n % 10 === 1 && n % 100 !== 11 ? 0 : n % 10 >= 2 && n % 10 <= 4 && (n % 100 < 10 || n % 100 >= 20) ? 1 : 2;
+            case 'sl':
+                return n % 100 === 1 ? 1 : n % 100 === 2 ? 2 : n % 100 === 3 || n % 100 === 4 ? 3 : 0;
+            default:
+                return n !== 1 ? 1 : 0;
+        }
+    }
+
+    /**
+     * Remember the language picked in the UI and re-render the page in it
+     */
+    function selectLanguage() {
+        const path = config.basepath || '/';
+        document.cookie = 'lang=' + encodeURIComponent(this.value) + '; path=' + path + '; max-age=31536000; SameSite=Lax';
+        window.location.reload();
+    }
+
     // =====================
     // Theme Functions
     // =====================
 
     /**
-     * Initialize theme from localStorage or system preference
+     * Initialize theme from localStorage or the server's template theme
      */
     function initTheme() {
         const savedTheme = localStorage.getItem('flashpaper-theme');
@@ -59,8 +248,8 @@ const FlashPaper = (function() {
         if (savedTheme) {
             setTheme(savedTheme);
         } else {
-            // Default to light mode (don't check system preference)
-            setTheme('light');
+            // Default to the template's theme (don't check system preference)
+            setTheme(document.documentElement.getAttribute('data-theme') || 'light');
         }
     }
 
@@ -71,15 +260,17 @@ const FlashPaper = (function() {
         const html = document.documentElement;
         const icon = document.getElementById('theme-icon');
         const text = document.getElementById('theme-text');
+        // Labels come translated from the page (see index.html)
+        const toggle = document.getElementById('theme-toggle');
 
         if (theme === 'dark') {
             html.setAttribute('data-theme', 'dark');
             if (icon) icon.innerHTML = '&#9728;'; // Sun icon
-            if (text) text.textContent = 'Light';
+            if (text) text.textContent = toggle?.dataset.labelLight || 'Light';
         } else {
             html.removeAttribute('data-theme');
             if (icon) icon.innerHTML = '&#9790;'; // Moon icon
-            if (text) text.textContent = 'Dark';
+            if (text) text.textContent = toggle?.dataset.labelDark || 'Dark';
         }
 
         localStorage.setItem('flashpaper-theme', theme);
@@ -101,6 +292,9 @@ const FlashPaper = (function() {
         // Theme toggle button
         document.getElementById('theme-toggle')?.addEventListener('click', toggleTheme);
 
+        // Language picker (only with [main] languageselection)
+        document.getElementById('language')?.addEventListener('change', selectLanguage);
+
         // Create paste button
         document.getElementById('create-paste')?.addEventListener('click', createPaste);
 
@@ -164,7 +358,8 @@ const FlashPaper = (function() {
     }
 
     /**
-     * Get paste ID from URL query string
+     * Get paste ID from URL query string, or from the path of /p/{id} and
+     * /paste/{id} links
      */
     function getPasteIdFromUrl() {
         const query = window.location.search;
@@ -172,6 +367,10 @@ const FlashPaper = (function() {
             // Remove leading '?' and any additional parameters
             return query.substring(1).split('&')[0];
         }
+        const match = window.location.pathname.match(/\/(?:p|paste)\/([^\/]+)\/?$/);
+        if (match) {
+            return decodeURIComponent(match[1]);
+        }
         return null;
     }
 
@@ -358,6 +557,85 @@ const FlashPaper = (function() {
         );
     }
 
+    /**
+     * Derive the proof of a paste password the server checks on reads when
+     * [main] access_proof is on. It mixes in the key from the URL fragment,
+     * so the server, which never gets the key, can't test passwords with it.
+     */
+    async function accessProof(password, keyBytes) {
+        const hmacKey = await crypto.subtle.importKey(
+            'raw',
+            stringToUint8Array(password),
+            { name: 'HMAC', hash: 'SHA-256' },
+            false,
+            ['sign']
+        );
+        const mac = new Uint8Array(await crypto.subtle.sign('HMAC', hmacKey, keyBytes));
+        return Array.from(mac, b => b.toString(16).padStart(2, '0')).join('');
+    }
+
+    /**
+     * Fetch a proof-of-work challenge and find a nonce for it, when the
+     * server requires one ([pow]). A nonce solves the challenge if
+     * SHA-256(challenge ":" nonce) starts with difficulty zero bits; hashes
+     * are computed in batches so the page stays responsive.
+     */
+    async function proofOfWork() {
+        const base = (config.basepath || '').replace(/\/+$/, '');
+        const response = await fetch(base + config.pow.url, {
+            headers: { 'X-Requested-With': 'JSONHttpRequest' }
+        });
+        const data = await response.json();
+        if (data.status !== 0) {
+            throw new Error(data.message || t('Failed to create paste'));
+        }
+
+        const zeroBits = (hash) => {
+            let zeros = 0;
+            for (const b of hash) {
+                zeros += Math.clz32(b) - 24;
+                if (b !== 0) break;
+            }
+            return zeros;
+        };
+        for (let n = 0; ; n += 256) {
+            const batch = [];
+            for (let i = n; i < n + 256; i++) {
+                batch.push(crypto.subtle.digest('SHA-256', stringToUint8Array(data.challenge + ':' + i)));
+            }
+            const hashes = await Promise.all(batch);
+            for (let i = 0; i < hashes.length; i++) {
+                if (zeroBits(new Uint8Array(hashes[i])) >= data.difficulty) {
+                    return { challenge: data.challenge, nonce: String(n + i) };
+                }
+            }
+        }
+    }
+
+    /**
+     * Return the response token the captcha widget put in its form field,
+     * or '' if the captcha hasn't been solved ([captcha]).
+     */
+    function captchaResponse() {
+        const field = document.querySelector('[name="' + config.captcha.field + '"]');
+        return field ? field.value : '';
+    }
+
+    /**
+     * Reset the captcha widget after a failed creation; the server has
+     * already spent its token.
+     */
+    function resetCaptcha() {
+        const api = {
+            turnstile: window.turnstile,
+            hcaptcha: window.hcaptcha,
+            recaptcha: window.grecaptcha
+        }[config.captcha.provider];
+        if (api && api.reset) {
+            api.reset();
+        }
+    }
+
     /**
      * Encrypt data using AES-256-GCM
      */
@@ -454,7 +732,22 @@ const FlashPaper = (function() {
     async function createPaste() {
         const content = document.getElementById('paste-content').value;
         if (!content.trim()) {
-            showAlert('Please enter some content', 'error');
+            showAlert(t('Please enter some content'), 'error');
+            return;
+        }
+
+        // Instances may require accepting the terms of service
+        const tosRequired = Boolean(config.tos && config.tos.required);
+        const tos = document.getElementById('tos-accepted');
+        if (tosRequired && !(tos && tos.checked)) {
+            showAlert(t('Please accept the terms of service'), 'error');
+            return;
+        }
+
+        // Instances may require solving a captcha
+        const captcha = config.captcha ? captchaResponse() : '';
+        if (config.captcha && !captcha) {
+            showAlert(t('Please solve the captcha'), 'error');
             return;
         }
 
@@ -462,11 +755,16 @@ const FlashPaper = (function() {
         const expire = document.getElementById('expire').value;
 
         try {
-            showAlert('Encrypting...', 'info');
+            showAlert(t('Encrypting...'), 'info');
 
             // Encrypt the content
             const encrypted = await encrypt(content, password);
 
+            // Check size limit before uploading
+            if (config.sizelimit > 0 && encrypted.ciphertext.length > config.sizelimit) {
+                throw new Error(t('Paste exceeds size limit'));
+            }
+
             // Build request
             const request = {
                 v: 2,
@@ -476,9 +774,22 @@ const FlashPaper = (function() {
                     expire: expire
                 }
             };
+            if (tosRequired) {
+                request.tos_accepted = true;
+            }
+            if (config.features.accessproof && password) {
+                request.meta.accessproof = await accessProof(password, encrypted.key);
+            }
+            if (config.pow) {
+                showAlert(t('Proving work...'), 'info');
+                request.pow = await proofOfWork();
+            }
+            if (captcha) {
+                request.captcha = captcha;
+            }
 
             // Send to server
-            const response = await fetch('/', {
+            const response = await fetch(apiUrl(), {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -490,7 +801,7 @@ const FlashPaper = (function() {
             const data = await response.json();
 
             if (data.status !== 0) {
-                throw new Error(data.message || 'Failed to create paste');
+                throw new Error(data.message || t('Failed to create paste'));
             }
 
             // Store delete token in memory and sessionStorage for persistence
@@ -502,16 +813,24 @@ const FlashPaper = (function() {
             // Build URL with key in fragment
             const burnPrefix = encrypted.adata[3] === 1 ? '-' : '';
             const keyEncoded = base58Encode(encrypted.key);
-            const newUrl = window.location.origin + '/?' + data.id + '#' + burnPrefix + keyEncoded;
+            const newUrl = window.location.origin + apiUrl(data.id) + '#' + burnPrefix + keyEncoded;
+
+            // Share the short link instead if the server shortened the URL;
+            // the key goes in its fragment, which survives the redirect
+            let shareUrl = newUrl;
+            const returnedUrl = data.url ? new URL(data.url, window.location.origin).href : '';
+            if (returnedUrl && returnedUrl !== window.location.origin + apiUrl(data.id)) {
+                shareUrl = returnedUrl + '#' + burnPrefix + keyEncoded;
+            }
 
             // Update URL and show success
             window.history.pushState({}, '', newUrl);
 
-            showAlert('Paste created! URL copied to clipboard.', 'success');
+            showAlert(t('Paste created! URL copied to clipboard.'), 'success');
 
             // Try to copy URL to clipboard
             try {
-                await navigator.clipboard.writeText(newUrl);
+                await navigator.clipboard.writeText(shareUrl);
             } catch (e) {
                 console.log('Could not copy to clipboard');
             }
@@ -521,14 +840,17 @@ const FlashPaper = (function() {
 
         } catch (error) {
             console.error('Create paste error:', error);
-            showAlert('Error: ' + error.message, 'error');
+            showAlert(t('Error: %s', error.message), 'error');
+            if (config.captcha) {
+                resetCaptcha();
+            }
         }
     }
 
     /**
      * Load a paste from the server
      */
-    async function loadPaste(pasteId) {
+    async function loadPaste(pasteId, password) {
         try {
             // Check for stored delete token from sessionStorage
             const storedToken = sessionStorage.getItem('deleteToken-' + pasteId);
@@ -536,33 +858,46 @@ const FlashPaper = (function() {
                 deleteToken = storedToken;
             }
 
-            const response = await fetch('/?' + pasteId, {
-                headers: {
-                    'X-Requested-With': 'JSONHttpRequest'
-                }
-            });
+            const headers = { 'X-Requested-With': 'JSONHttpRequest' };
+            const proof = password ? await accessProof(password, getKeyFromUrl()) : null;
+            if (proof) {
+                headers['X-Access-Proof'] = proof;
+            }
+            const response = await fetch(apiUrl(pasteId), { headers: headers });
 
             const data = await response.json();
 
+            // The server holds the paste back until the password is proven
+            if (data.code === 'access_proof_required') {
+                gatedPasteId = pasteId;
+                document.getElementById('password-prompt').classList.remove('hidden');
+                return;
+            }
+            if (data.code === 'access_proof_invalid') {
+                showAlert(t('Wrong password'), 'error');
+                return;
+            }
+
             if (data.status !== 0) {
-                showAlert(data.message || 'Paste not found', 'error');
+                showAlert(data.message || t('Paste not found'), 'error');
                 return;
             }
 
             currentPaste = data;
+            currentProof = proof;
 
             // Check if burn-after-reading
-            if (data.adata && data.adata[3] === 1) {
+            if ((data.adata && data.adata[3] === 1) || (data.meta && data.meta.burnafterreading)) {
                 document.getElementById('burn-warning').classList.remove('hidden');
                 return;
             }
 
             // Try to decrypt
-            await decryptPaste();
+            await decryptPaste(password);
 
         } catch (error) {
             console.error('Load paste error:', error);
-            showAlert('Error loading paste: ' + error.message, 'error');
+            showAlert(t('Error loading paste: %s', error.message), 'error');
         }
     }
 
@@ -574,7 +909,7 @@ const FlashPaper = (function() {
 
         const key = getKeyFromUrl();
         if (!key || key.length === 0) {
-            showAlert('No decryption key found in URL', 'error');
+            showAlert(t('No decryption key found in URL'), 'error');
             return;
         }
 
@@ -594,7 +929,7 @@ const FlashPaper = (function() {
             // Show paste info
             if (currentPaste.meta && currentPaste.meta.postdate) {
                 const date = new Date(currentPaste.meta.postdate * 1000);
-                document.getElementById('paste-date').textContent = 'Created: ' + date.toLocaleString();
+                document.getElementById('paste-date').textContent = t('Created: %s', date.toLocaleString(lang));
             }
 
             // Show discussion if enabled
@@ -603,6 +938,7 @@ const FlashPaper = (function() {
                 if (currentPaste.comments && currentPaste.comments.length > 0) {
                     renderComments(currentPaste.comments, key, password);
                 }
+                watchComments(currentPaste.id, key, password);
             }
 
             // Enable clone button
@@ -621,7 +957,7 @@ const FlashPaper = (function() {
                 document.getElementById('password-prompt').classList.remove('hidden');
                 document.getElementById('paste-output').classList.add('hidden');
             } else {
-                showAlert('Decryption failed. Wrong password?', 'error');
+                showAlert(t('Decryption failed. Wrong password?'), 'error');
             }
         }
     }
@@ -631,6 +967,10 @@ const FlashPaper = (function() {
      */
     async function decryptWithPassword() {
         const password = document.getElementById('decrypt-password').value;
+        if (!currentPaste && gatedPasteId) {
+            await loadPaste(gatedPasteId, password);
+            return;
+        }
         await decryptPaste(password);
     }
 
@@ -659,9 +999,9 @@ const FlashPaper = (function() {
         if (e) e.preventDefault();
         try {
             await navigator.clipboard.writeText(window.location.href);
-            showAlert('URL copied to clipboard', 'success');
+            showAlert(t('URL copied to clipboard'), 'success');
         } catch (error) {
-            showAlert('Could not copy URL', 'error');
+            showAlert(t('Could not copy URL'), 'error');
         }
     }
 
@@ -671,7 +1011,7 @@ const FlashPaper = (function() {
     function showRawPaste() {
         const content = document.getElementById('paste-text').textContent;
         if (!content) {
-            showAlert('No content to display', 'error');
+            showAlert(t('No content to display'), 'error');
             return;
         }
 
@@ -686,16 +1026,16 @@ const FlashPaper = (function() {
      */
     async function deletePaste() {
         if (!currentPaste || !deleteToken) {
-            showAlert('Cannot delete: no delete token', 'error');
+            showAlert(t('Cannot delete: no delete token'), 'error');
             return;
         }
 
-        if (!confirm('Are you sure you want to delete this paste?')) {
+        if (!confirm(t('Are you sure you want to delete this paste?'))) {
             return;
         }
 
         try {
-            const response = await fetch('/', {
+            const response = await fetch(apiUrl(), {
                 method: 'DELETE',
                 headers: {
                     'Content-Type': 'application/json',
@@ -710,19 +1050,19 @@ const FlashPaper = (function() {
             const data = await response.json();
 
             if (data.status !== 0) {
-                throw new Error(data.message || 'Failed to delete paste');
+                throw new Error(data.message || t('Failed to delete paste'));
             }
 
             // Clean up stored delete token
             sessionStorage.removeItem('deleteToken-' + currentPaste.id);
 
-            showAlert('Paste deleted', 'success');
+            showAlert(t('Paste deleted'), 'success');
             setTimeout(() => {
-                window.location.href = '/';
+                window.location.href = apiUrl();
             }, 1500);
 
         } catch (error) {
-            showAlert('Error: ' + error.message, 'error');
+            showAlert(t('Error: %s', error.message), 'error');
         }
     }
 
@@ -732,7 +1072,7 @@ const FlashPaper = (function() {
     async function addComment() {
         const content = document.getElementById('comment-content').value;
         if (!content.trim()) {
-            showAlert('Please enter a comment', 'error');
+            showAlert(t('Please enter a comment'), 'error');
             return;
         }
 
@@ -743,12 +1083,16 @@ const FlashPaper = (function() {
             // Encrypt comment
             const encrypted = await encryptComment(content, key, password);
 
-            const response = await fetch('/', {
+            const headers = {
+                'Content-Type': 'application/json',
+                'X-Requested-With': 'JSONHttpRequest'
+            };
+            if (currentProof) {
+                headers['X-Access-Proof'] = currentProof;
+            }
+            const response = await fetch(apiUrl(), {
                 method: 'POST',
-                headers: {
-                    'Content-Type': 'application/json',
-                    'X-Requested-With': 'JSONHttpRequest'
-                },
+                headers: headers,
                 body: JSON.stringify({
                     pasteid: currentPaste.id,
                     parentid: currentPaste.id,
@@ -761,20 +1105,58 @@ const FlashPaper = (function() {
             const data = await response.json();
 
             if (data.status !== 0) {
-                throw new Error(data.message || 'Failed to add comment');
+                throw new Error(data.message || t('Failed to add comment'));
             }
 
-            showAlert('Comment added', 'success');
             document.getElementById('comment-content').value = '';
 
+            // Held for moderation: nothing new to show yet
+            if (data.pending) {
+                showAlert(t('Comment submitted; it will appear once a moderator approves it'), 'info');
+                return;
+            }
+
+            showAlert(t('Comment added'), 'success');
+
             // Reload to show new comment
             window.location.reload();
 
         } catch (error) {
-            showAlert('Error: ' + error.message, 'error');
+            showAlert(t('Error: %s', error.message), 'error');
         }
     }
 
+    /**
+     * Re-render the discussion as comments are posted or deleted elsewhere.
+     * EventSource can't send the access proof header, so password-gated
+     * pastes aren't watched.
+     */
+    function watchComments(pasteId, key, password) {
+        if (!config.features.livecomments || currentProof || !window.EventSource) return;
+
+        const base = (config.basepath || '').replace(/\/+$/, '');
+        const source = new EventSource(base + '/events?pasteid=' + encodeURIComponent(pasteId));
+        const refresh = async function () {
+            try {
+                const response = await fetch(apiUrl(pasteId), {
+                    headers: { 'X-Requested-With': 'JSONHttpRequest' }
+                });
+                const data = await response.json();
+                if (data.status === 0) {
+                    renderComments(data.comments || [], key, password);
+                }
+            } catch (error) {
+                console.error('Comment refresh error:', error);
+            }
+        };
+        source.addEventListener('comment.created', refresh);
+        source.addEventListener('comment.deleted', refresh);
+        source.addEventListener('paste.deleted', function () {
+            source.close();
+            showAlert(t('This paste has been deleted'), 'info');
+        });
+    }
+
     /**
      * Encrypt a comment (uses same nested adata format as pastes for compatibility)
      */
@@ -827,12 +1209,19 @@ const FlashPaper = (function() {
                 const plaintext = await decrypt(comment.data, comment.adata, key, password);
 
                 const div = document.createElement('div');
-                div.className = 'comment';
+                div.className = comment.meta.flagged ? 'comment comment-flagged' : 'comment';
 
                 const meta = document.createElement('div');
                 meta.className = 'comment-meta';
+                if (comment.meta.icon && comment.meta.icon.startsWith('data:image/')) {
+                    const icon = document.createElement('img');
+                    icon.className = 'comment-vizhash';
+                    icon.src = comment.meta.icon;
+                    icon.alt = '';
+                    meta.appendChild(icon);
+                }
                 const date = new Date(comment.meta.postdate * 1000);
-                meta.textContent = date.toLocaleString();
+                meta.appendChild(document.createTextNode(date.toLocaleString()));
 
                 const content = document.createElement('div');
                 content.className = 'comment-content';